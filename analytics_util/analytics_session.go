@@ -17,7 +17,7 @@ import (
 	"go.fuchsia.dev/jiri/version"
 )
 
-var Version = "1.0v"
+var Version = "2.0v"
 var analyticsUrl = "https://www.google-analytics.com/collect"
 
 var CollectedData = `When opted in, jiri collects the following anonymized data in order to improve the user experience:
@@ -31,7 +31,11 @@ var CollectedData = `When opted in, jiri collects the following anonymized data
 4. Tracks user's operating system and its architecture.
 5. Tracks the time taken by a command to complete.
 6. Tracks jiri version.
-7. Tracks time between subsequent jiri updates if more than 30 mins and less than 2 weeks.`
+7. Tracks time between subsequent jiri updates if more than 30 mins and less than 2 weeks.
+
+When an analytics endpoint is set with "jiri analytics enable -endpoint", the same data, along with
+whether each command succeeded or the class of its failure, is spooled in .jiri_root/analytics and
+uploaded to that endpoint in batches instead of being sent to Google Analytics.`
 
 var customDimensionMapping map[string]string
 
@@ -69,6 +73,8 @@ type AnalyticsSession struct {
 	nextId  int
 	lock    *sync.Mutex
 	slock   *sync.RWMutex
+	// spool, if set, receives the objects instead of Google Analytics.
+	spool *Spool
 }
 
 func (e Event) send(as *AnalyticsSession) {
@@ -117,6 +123,31 @@ func NewAnalyticsSession(enabled bool, tid string, cid string) *AnalyticsSession
 	}
 }
 
+// NewSpoolingSession returns an enabled session whose objects are spooled
+// to spool, to be uploaded in batches, instead of being sent one by one to
+// Google Analytics.
+func NewSpoolingSession(spool *Spool, cid string) *AnalyticsSession {
+	as := NewAnalyticsSession(true, "", cid)
+	as.spool = spool
+	return as
+}
+
+// Disable stops the session from sending or spooling anything, dropping the
+// objects not sent yet, e.g. once the user opted out during the command.
+func (as *AnalyticsSession) Disable() {
+	as.lock.Lock()
+	defer as.lock.Unlock()
+	as.enabled = false
+	as.objects = make(map[int]JiriObject)
+	as.spool = nil
+}
+
+// Spool returns the spool of the session, or nil if it sends its objects to
+// Google Analytics.
+func (as *AnalyticsSession) Spool() *Spool {
+	return as.spool
+}
+
 func (as *AnalyticsSession) sendAnalytic(params, cds map[string]string) {
 	if !as.enabled {
 		return
@@ -167,6 +198,19 @@ func (as *AnalyticsSession) AddCommandExecutionTiming(name string, timing time.D
 	return as.Add(newCommandExecutionTiming(name, timing))
 }
 
+// SetResult sets the result of the command id, "success" or the class of its
+// failure. Only spooled records have results.
+func (as *AnalyticsSession) SetResult(id int, result string) {
+	if !as.enabled {
+		return
+	}
+	as.lock.Lock()
+	defer as.lock.Unlock()
+	if c, ok := as.objects[id].(*Command); ok {
+		c.result = result
+	}
+}
+
 func (as *AnalyticsSession) Send(id int) {
 	if !as.enabled {
 		return
@@ -185,6 +229,11 @@ func (as *AnalyticsSession) Send(id int) {
 		}()
 		if v, ok := as.objects[id]; ok {
 			delete(as.objects, id)
+			if sv, ok := v.(spoolable); ok && as.spool != nil {
+				// Errors are ignored, as for Google Analytics.
+				as.spool.Append(sv.record(as))
+				return
+			}
 			gaobject := v.AnalyticsObject()
 			gaobject.send(as)
 		}
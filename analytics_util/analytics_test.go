@@ -5,9 +5,11 @@
 package analytics_util
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"runtime"
 	"sort"
 	"strings"
@@ -144,3 +146,88 @@ func TestSendCommand(t *testing.T) {
 		t.Fatal("Analytics should have been sent")
 	}
 }
+
+func TestSpoolingSession(t *testing.T) {
+	var uploads [][]Record
+	fail := true
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if fail {
+			http.Error(rw, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		var body struct {
+			Records []Record `json:"records"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("bad upload: %v", err)
+		}
+		uploads = append(uploads, body.Records)
+	}))
+	defer server.Close()
+
+	spool := NewSpool(filepath.Join(t.TempDir(), "analytics", "spool.jsonl"), server.URL)
+	spool.BatchSize = 2
+	for i := 0; i < 2; i++ {
+		as := NewSpoolingSession(spool, "test-id")
+		id := as.AddCommand("update", map[string]string{"gc": "true", "local-manifest-project": "secret"})
+		as.SetResult(id, "network")
+		as.Done(id)
+		as.SendAllAndWaitToFinish()
+		if n, err := spool.Flush(false); i == 0 && (n != 0 || err != nil) {
+			t.Fatalf("Flush() = %d, %v, want nothing uploaded below the batch size", n, err)
+		} else if i == 1 && err == nil {
+			t.Fatalf("Flush() should fail with the endpoint down")
+		}
+	}
+	records, err := spool.Records()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d spooled records after a failed upload, want 2", len(records))
+	}
+	r := records[0]
+	if r.UserID != "test-id" || r.Kind != "command" || r.Command != "update" || r.Result != "network" {
+		t.Errorf("unexpected record %+v", r)
+	}
+	if r.Flags["gc"] != "true" || r.Flags["local-manifest-project"] != "" {
+		t.Errorf("flags were not anonymized: %v", r.Flags)
+	}
+
+	fail = false
+	// Uploads are not tried again until the backoff after the failure
+	// expires.
+	if n, err := spool.Flush(false); n != 0 || err != nil || len(uploads) != 0 {
+		t.Fatalf("Flush() = %d, %v, want no upload during the backoff", n, err)
+	}
+	if n, err := spool.Flush(true); n != 2 || err != nil {
+		t.Fatalf("Flush(true) = %d, %v, want 2 records uploaded", n, err)
+	}
+	if len(uploads) != 1 || len(uploads[0]) != 2 {
+		t.Errorf("got uploads %v, want one batch of 2 records", uploads)
+	}
+	if records, err := spool.Records(); err != nil || len(records) != 0 {
+		t.Errorf("spool should be empty after an upload, got %d records, %v", len(records), err)
+	}
+	if b := spool.readBackoff(); b.Failures != 0 {
+		t.Errorf("the backoff should be reset after an upload, got %+v", b)
+	}
+}
+
+func TestUploadBackoff(t *testing.T) {
+	now := time.Now()
+	for _, test := range []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, minUploadBackoff},
+		{2, 2 * minUploadBackoff},
+		{3, 4 * minUploadBackoff},
+		{100, maxUploadBackoff},
+	} {
+		b := uploadBackoff{LastFailure: now, Failures: test.failures}
+		if got := b.until().Sub(now); got != test.want {
+			t.Errorf("backoff after %d failures = %v, want %v", test.failures, got, test.want)
+		}
+	}
+}
@@ -16,6 +16,7 @@ type Command struct {
 	flags     map[string]string
 	startTime time.Time
 	endTime   time.Time
+	result    string
 }
 
 // string values allowed to be tracked
@@ -76,6 +77,18 @@ func (c *Command) AnalyticsObject() AnayticsObject {
 
 }
 
+func (c *Command) record(as *AnalyticsSession) Record {
+	r := newRecord(as, "command", c.name)
+	if len(c.flags) > 0 {
+		r.Flags = c.flags
+	}
+	if !c.endTime.IsZero() {
+		r.DurationMs = c.endTime.Sub(c.startTime).Milliseconds()
+	}
+	r.Result = c.result
+	return r
+}
+
 func (c *Command) Done() {
 	c.endTime = time.Now()
 }
@@ -27,4 +27,10 @@ func (c *CommandExecutionTiming) AnalyticsObject() AnayticsObject {
 	return ut
 }
 
+func (c *CommandExecutionTiming) record(as *AnalyticsSession) Record {
+	r := newRecord(as, "execution_timing", c.name)
+	r.DurationMs = c.timing.Milliseconds()
+	return r
+}
+
 func (c *CommandExecutionTiming) Done() {}
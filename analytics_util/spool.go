@@ -0,0 +1,283 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package analytics_util
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
+
+	"go.fuchsia.dev/jiri/version"
+)
+
+const (
+	// DefaultBatchSize is the number of spooled records which triggers an
+	// upload.
+	DefaultBatchSize = 20
+	// DefaultMaxAge is the age of the oldest spooled record which triggers
+	// an upload, so that records of roots rarely used are uploaded too.
+	DefaultMaxAge = 24 * time.Hour
+	// maxSpooledRecords bounds the spool when the endpoint is unreachable.
+	// The oldest records are dropped first.
+	maxSpooledRecords = 1000
+	uploadTimeout     = 5 * time.Second
+	// minUploadBackoff is how long Flush waits after a failed upload before
+	// trying again, doubled on each consecutive failure up to
+	// maxUploadBackoff, so that commands don't all wait for an unreachable
+	// endpoint on exit.
+	minUploadBackoff = 10 * time.Minute
+	maxUploadBackoff = 24 * time.Hour
+)
+
+// uploadBackoff records the failed uploads of a spool, next to it.
+type uploadBackoff struct {
+	LastFailure time.Time `json:"last_failure"`
+	Failures    int       `json:"failures"`
+}
+
+// until returns when the next upload may be tried.
+func (b uploadBackoff) until() time.Time {
+	d := minUploadBackoff
+	for i := 1; i < b.Failures && d < maxUploadBackoff; i++ {
+		d *= 2
+	}
+	return b.LastFailure.Add(min(d, maxUploadBackoff))
+}
+
+// Record is an anonymized metric of a command, spooled locally before it is
+// uploaded. It contains no path, remote or string flag value.
+type Record struct {
+	Time time.Time `json:"time"`
+	// UserID is the random id of the jiri root, see "jiri init -analytics-opt".
+	UserID string `json:"user_id"`
+	// Kind is "command" for a run of a command, and "execution_timing" for
+	// the time since the previous run of a command.
+	Kind    string            `json:"kind"`
+	Command string            `json:"command"`
+	Flags   map[string]string `json:"flags,omitempty"`
+	// DurationMs is the duration of the command, or the time since its
+	// previous run, in milliseconds.
+	DurationMs int64 `json:"duration_ms"`
+	// Result is "success", or the class of the failure of the command, see
+	// jiri.ClassifiedError, or "error" if it has none.
+	Result  string `json:"result,omitempty"`
+	OS      string `json:"os"`
+	Version string `json:"version"`
+}
+
+// spoolable is implemented by the objects of a session which can be spooled.
+type spoolable interface {
+	record(as *AnalyticsSession) Record
+}
+
+// Spool is a file of records waiting to be uploaded to an endpoint in
+// batches.
+type Spool struct {
+	path     string
+	endpoint string
+	// BatchSize and MaxAge control when Flush uploads the records.
+	BatchSize int
+	MaxAge    time.Duration
+}
+
+// NewSpool returns the spool of records kept at path and uploaded to
+// endpoint.
+func NewSpool(path, endpoint string) *Spool {
+	return &Spool{path: path, endpoint: endpoint, BatchSize: DefaultBatchSize, MaxAge: DefaultMaxAge}
+}
+
+// Path returns the path of the spool file.
+func (s *Spool) Path() string {
+	return s.path
+}
+
+// Endpoint returns the URL the records are uploaded to.
+func (s *Spool) Endpoint() string {
+	return s.endpoint
+}
+
+// Append adds r to the spool.
+func (s *Spool) Append(r Record) error {
+	return s.append([]Record{r})
+}
+
+func (s *Spool) append(records []Record) error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	for _, r := range records {
+		data, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	// A single write, so that records appended concurrently by several jiri
+	// processes don't interleave.
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Records returns the spooled records, oldest first.
+func (s *Spool) Records() ([]Record, error) {
+	return readRecords(s.path)
+}
+
+func readRecords(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r Record
+		// Skip lines that can't be parsed, e.g. a record truncated by a
+		// full disk, rather than losing the whole spool.
+		if err := json.Unmarshal(scanner.Bytes(), &r); err == nil {
+			records = append(records, r)
+		}
+	}
+	return records, scanner.Err()
+}
+
+// Clear removes the spooled records.
+func (s *Spool) Clear() error {
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *Spool) backoffPath() string {
+	return s.path + ".backoff"
+}
+
+func (s *Spool) readBackoff() uploadBackoff {
+	var b uploadBackoff
+	if data, err := os.ReadFile(s.backoffPath()); err == nil {
+		json.Unmarshal(data, &b)
+	}
+	return b
+}
+
+// recordUpload records the result of an upload, err, for the backoff of the
+// next ones.
+func (s *Spool) recordUpload(err error) {
+	if err == nil {
+		os.Remove(s.backoffPath())
+		return
+	}
+	b := s.readBackoff()
+	b.LastFailure = time.Now().UTC()
+	b.Failures++
+	if data, err := json.Marshal(b); err == nil {
+		os.WriteFile(s.backoffPath(), data, 0644)
+	}
+}
+
+// Flush uploads the spooled records if there are at least BatchSize of them,
+// if the oldest one is older than MaxAge, or if force is set. It returns the
+// number of records uploaded. Records which could not be uploaded stay in
+// the spool, up to a limit. After a failed upload, Flush does not try again,
+// unless force is set, for a backoff growing with the consecutive failures.
+func (s *Spool) Flush(force bool) (int, error) {
+	records, err := s.Records()
+	if err != nil || len(records) == 0 {
+		return 0, err
+	}
+	if !force && len(records) < s.BatchSize && time.Since(records[0].Time) < s.MaxAge {
+		return 0, nil
+	}
+	if !force && time.Now().Before(s.readBackoff().until()) {
+		return 0, nil
+	}
+	// Take the records out of the spool first, so that concurrent jiri
+	// processes don't upload them twice: only one of them can rename it.
+	claimed := s.path + ".uploading." + strconv.Itoa(os.Getpid())
+	if err := os.Rename(s.path, claimed); err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer os.Remove(claimed)
+	if records, err = readRecords(claimed); err != nil {
+		return 0, err
+	}
+	err = s.upload(records)
+	s.recordUpload(err)
+	if err != nil {
+		if len(records) > maxSpooledRecords {
+			records = records[len(records)-maxSpooledRecords:]
+		}
+		if appendErr := s.append(records); appendErr != nil {
+			return 0, fmt.Errorf("%v, and the records could not be spooled again: %v", err, appendErr)
+		}
+		return 0, err
+	}
+	return len(records), nil
+}
+
+// upload posts records to the endpoint as a JSON object {"records": [...]}.
+func (s *Spool) upload(records []Record) error {
+	data, err := json.Marshal(struct {
+		Records []Record `json:"records"`
+	}{records})
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: uploadTimeout}
+	req, err := http.NewRequest("POST", s.endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("uploading analytics to %s: %s", s.endpoint, resp.Status)
+	}
+	return nil
+}
+
+// newRecord returns a record of the session as, with the fields common to
+// all records filled in.
+func newRecord(as *AnalyticsSession, kind, command string) Record {
+	v := version.FormattedVersion()
+	if v == "" {
+		v = "test"
+	}
+	return Record{
+		Time:    time.Now().UTC(),
+		UserID:  as.cid,
+		Kind:    kind,
+		Command: command,
+		OS:      fmt.Sprintf("%s-%s", runtime.GOOS, runtime.GOARCH),
+		Version: v,
+	}
+}
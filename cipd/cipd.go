@@ -22,6 +22,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -45,9 +46,13 @@ var (
 	CurrentPlatform Platform
 	cipdOS          string
 	cipdArch        string
-	selfUpdateOnce  sync.Once
 	templateRE      = regexp.MustCompile(`\${[^}]*}`)
 
+	// bootstrapped records the cipd binaries verified by Bootstrap, by
+	// path and digest, so that they are only hashed once.
+	bootstrapped = make(map[string]bool)
+	bootstrapMu  sync.Mutex
+
 	// ErrSkipTemplate may be returned from Resolver.Resolve to indicate that
 	// a given resolution doesn't apply to the current template parameters. For
 	// example, resolving `"foo/${os=linux,mac}"` with a template parameter of
@@ -88,73 +93,119 @@ func init() {
 
 // FetchBinary downloads CIPD to the specified path.
 func FetchBinary(jirix *jiri.X, binaryPath string) error {
-	// Fetch cipd digest
-	digest, _, err := fetchDigest(CurrentPlatform.String())
+	version, digest, err := clientPin(jirix, CurrentPlatform.String())
 	if err != nil {
 		return err
 	}
-	return fetchBinaryImpl(jirix, binaryPath, CurrentPlatform.String(), cipdVersion, digest)
+	return fetchBinaryImpl(jirix, binaryPath, CurrentPlatform.String(), version, digest)
 }
 
 func fetchBinaryImpl(jirix *jiri.X, binaryPath, platform, version, digest string) error {
 	cipdURL := fmt.Sprintf("%s/client?platform=%s&version=%s", cipdBackend, platform, version)
-	data, err := fetchFile(jirix, cipdURL)
+	if _, err := os.Stat(filepath.Dir(binaryPath)); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(binaryPath), 0755); err != nil {
+			return fmt.Errorf("failed to create parent directory %q for cipd: %v", filepath.Dir(binaryPath), err)
+		}
+	}
+	// The download is kept next to the binary until it is verified, so that
+	// an interrupted download is resumed by the next bootstrap.
+	partialPath := binaryPath + ".partial"
+	if err := fetchFile(jirix, cipdURL, partialPath); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(partialPath)
 	if err != nil {
 		return err
 	}
 	if verified, err := verifyDigest(data, digest); err != nil || !verified {
+		// Start over next time, the partial download may be corrupted.
+		os.Remove(partialPath)
 		if err != nil {
 			return err
 		}
 		return errors.New("cipd failed integrity test")
 	}
 	// cipd binary verified. Save to disk
-	if _, err := os.Stat(filepath.Dir(binaryPath)); os.IsNotExist(err) {
-		if err := os.MkdirAll(filepath.Dir(binaryPath), 0755); err != nil {
-			return fmt.Errorf("failed to create parent directory %q for cipd: %v", filepath.Dir(binaryPath), err)
+	defer os.Remove(partialPath)
+	return writeFile(binaryPath, data)
+}
+
+// clientPin returns the version of the cipd client to use and the digest of
+// its binary for platform: those pinned by the manifest if it pins the client,
+// and the ones built into jiri otherwise.
+func clientPin(jirix *jiri.X, platform string) (version, digest string, err error) {
+	if pin := jirix.CIPDClient; pin != nil {
+		digest, ok := pin.Digests[platform]
+		if !ok {
+			return "", "", fmt.Errorf("the cipd client %s pinned by the manifest has no digest for platform %s", pin.Version, platform)
 		}
+		return pin.Version, digest, nil
 	}
-	return writeFile(binaryPath, data)
+	digest, _, err = fetchDigest(platform)
+	if err != nil {
+		return "", "", err
+	}
+	return cipdVersion, digest, nil
 }
 
-// Bootstrap returns the path of a valid cipd binary. It will fetch cipd from
-// remote if a valid cipd binary is not found. It will update cipd if there
-// is a new version.
+// Bootstrap makes sure that the cipd binary of jirix is the pinned cipd
+// client, fetching it if it is missing or does not match the digest of the
+// pin, so that it is verified before it is run. When jirix is offline, a
+// cipd binary that does not match is reused with a warning instead.
 func Bootstrap(jirix *jiri.X) error {
-	checkValidity := func() error {
-		fileInfo, err := os.Stat(jirix.CIPDPath())
-		if err != nil {
-			if os.IsNotExist(err) {
-				return fmt.Errorf("cipd binary was not found at %q", jirix.CIPDPath())
-			}
-			return err
-		}
-		// Check if cipd binary has execution permission
-		if fileInfo.Mode()&0111 == 0 {
-			return fmt.Errorf("cipd binary at %q is not executable", jirix.CIPDPath())
-		}
+	version, digest, err := clientPin(jirix, CurrentPlatform.String())
+	if err != nil {
+		return err
+	}
+	cipdPath := jirix.CIPDPath()
+	key := cipdPath + "@" + digest
+	bootstrapMu.Lock()
+	defer bootstrapMu.Unlock()
+	if bootstrapped[key] {
 		return nil
 	}
 
-	if err := checkValidity(); err != nil {
-		// Could not find cipd binary or cipd is invalid
-		// Bootstrap it from scratch
-		return FetchBinary(jirix, jirix.CIPDPath())
+	verified, err := verifyBinary(cipdPath, digest)
+	if err == nil && verified {
+		bootstrapped[key] = true
+		return nil
 	}
-	// cipd is found, do self update
-	var e error
-	selfUpdateOnce.Do(func() {
-		e = selfUpdate(jirix.CIPDPath(), cipdVersion)
-	})
-	if e != nil {
-		// Self update is unsuccessful, redo bootstrap
-		if err := FetchBinary(jirix, jirix.CIPDPath()); err != nil {
-			return err
+	if jirix.Offline {
+		if err != nil {
+			return fmt.Errorf("offline: cannot bootstrap cipd %s: %v", version, err)
 		}
+		jirix.Logger.Warningf("offline: the cipd binary at %q is not the pinned cipd %s, reusing it\n\n", cipdPath, version)
+		bootstrapped[key] = true
+		return nil
+	}
+	if err := fetchBinaryImpl(jirix, cipdPath, CurrentPlatform.String(), version, digest); err != nil {
+		return err
 	}
+	bootstrapped[key] = true
 	return nil
 }
 
+// verifyBinary returns whether the executable at path has the sha256 digest.
+// It returns an error if there is no executable at path.
+func verifyBinary(path, digest string) (bool, error) {
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, fmt.Errorf("cipd binary was not found at %q", path)
+		}
+		return false, err
+	}
+	// Check if cipd binary has execution permission
+	if fileInfo.Mode()&0111 == 0 {
+		return false, fmt.Errorf("cipd binary at %q is not executable", path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	return verifyDigest(data, digest)
+}
+
 // FuchsiaPlatform returns a Platform struct which can be used in
 // determining the correct path for prebuilt packages. It replace
 // the os and arch names from cipd format to a format used by
@@ -244,35 +295,66 @@ func getUserAgent() string {
 	return ua
 }
 
-func fetchFile(jirix *jiri.X, url string) ([]byte, error) {
+// fetchFile downloads url to dest. If dest already has the beginning of the
+// file, e.g. after an interrupted download, only the rest of it is fetched
+// when the server supports ranged requests.
+func fetchFile(jirix *jiri.X, url, dest string) error {
 	// Retry the fetch a hardcoded number of times. jirix.Attempts is intended
 	// to only apply to Git operations, and Git operation retries may be
 	// disabled even when HTTP file fetches should still be retried.
 	const maxAttempts = 3
 
 	client := &http.Client{}
+	if err := retry.Function(jirix, func() error {
+		return fetchFileOnce(jirix, client, url, dest)
+	}, "bootstrapping cipd binary", retry.AttemptsOpt(maxAttempts)); err != nil {
+		jirix.Logger.Component("cipd").Errorf("error: failed to download cipd client: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// fetchFileOnce makes one attempt of fetchFile.
+func fetchFileOnce(jirix *jiri.X, client *http.Client, url, dest string) error {
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	req.Header.Set("User-Agent", getUserAgent())
-	var contents []byte
-	if err := retry.Function(jirix, func() error {
-		resp, err := client.Do(req)
-		if err != nil {
-			return err
-		}
-		defer resp.Body.Close()
-		if resp.StatusCode >= 400 {
-			return fmt.Errorf("got non-success response: %s", resp.Status)
+	var offset int64
+	if fi, err := os.Stat(dest); err == nil && fi.Size() > 0 {
+		offset = fi.Size()
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	switch {
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// The partial download is complete, or longer than the file.
+		// Either way, the digest check decides.
+		return nil
+	case resp.StatusCode >= 400:
+		return fmt.Errorf("got non-success response: %s", resp.Status)
+	case resp.StatusCode == http.StatusPartialContent:
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)) {
+			return fmt.Errorf("got unexpected range %q, wanted bytes from %d", resp.Header.Get("Content-Range"), offset)
 		}
-		contents, err = io.ReadAll(resp.Body)
+		jirix.Logger.Component("cipd").Debugf("Resuming download of %s from byte %d", url, offset)
+		flags = os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(dest, flags, 0644)
+	if err != nil {
 		return err
-	}, "bootstrapping cipd binary", retry.AttemptsOpt(maxAttempts)); err != nil {
-		jirix.Logger.Errorf("error: failed to download cipd client: %v\n", err)
-		return nil, err
 	}
-	return contents, nil
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
 
 type packageACL struct {
@@ -283,7 +365,7 @@ type packageACL struct {
 func checkPackageACL(jirix *jiri.X, cipdPath, jsonDir string, c chan<- packageACL) {
 	jsonFile, err := os.CreateTemp(jsonDir, "cipd*.json")
 	if err != nil {
-		jirix.Logger.Warningf("Error while creating temporary file for cipd")
+		jirix.Logger.Component("cipd").Warningf("Error while creating temporary file for cipd")
 		c <- packageACL{path: cipdPath, access: false}
 		return
 	}
@@ -291,7 +373,7 @@ func checkPackageACL(jirix *jiri.X, cipdPath, jsonDir string, c chan<- packageAC
 	jsonFile.Close()
 
 	args := []string{"acl-check", "-reader", "-json-output", jsonFileName, cipdPath}
-	jirix.Logger.Debugf("Invoke cipd with %v", args)
+	jirix.Logger.Component("cipd").Debugf("Invoke cipd with %v", args)
 
 	command := exec.Command(jirix.CIPDPath(), args...)
 	var stdoutBuf, stderrBuf bytes.Buffer
@@ -299,7 +381,7 @@ func checkPackageACL(jirix *jiri.X, cipdPath, jsonDir string, c chan<- packageAC
 	command.Stderr = &stderrBuf
 	// Return false if cipd cannot be executed or output jsonfile contains false.
 	if err := command.Run(); err != nil {
-		jirix.Logger.Debugf("Error while executing cipd, err: %q, stderr: %q", err, stderrBuf.String())
+		jirix.Logger.Component("cipd").Debugf("Error while executing cipd, err: %q, stderr: %q", err, stderrBuf.String())
 		c <- packageACL{path: cipdPath, access: false}
 		return
 	}
@@ -365,6 +447,154 @@ func CheckPackageACL(jirix *jiri.X, pkgs map[string]bool) error {
 	return nil
 }
 
+// InstanceDescription is the subset of `cipd describe` output used to verify
+// package instances before they are deployed.
+type InstanceDescription struct {
+	InstanceID   string
+	RegisteredBy string
+	Tags         []string
+}
+
+// Describe runs `cipd describe` for the instance of pkg resolved by version.
+func Describe(jirix *jiri.X, pkg, version string) (*InstanceDescription, error) {
+	if err := Bootstrap(jirix); err != nil {
+		return nil, err
+	}
+	jsonFile, err := os.CreateTemp("", "cipd_describe*.json")
+	if err != nil {
+		return nil, err
+	}
+	jsonFileName := jsonFile.Name()
+	jsonFile.Close()
+	defer os.Remove(jsonFileName)
+
+	args := []string{"describe", pkg, "-version", version, "-json-output", jsonFileName, "-log-level", "warning"}
+	jirix.Logger.Component("cipd").Debugf("Invoke cipd with %v", args)
+	command := exec.Command(jirix.CIPDPath(), args...)
+	command.Env = append(os.Environ(), "CIPD_HTTP_USER_AGENT_PREFIX="+getUserAgent())
+	var stderrBuf bytes.Buffer
+	command.Stderr = &stderrBuf
+	if err := command.Run(); err != nil {
+		return nil, fmt.Errorf("cipd describe %s@%s failed: %v: %s", pkg, version, err, strings.TrimSpace(stderrBuf.String()))
+	}
+	jsonData, err := os.ReadFile(jsonFileName)
+	if err != nil {
+		return nil, err
+	}
+	var out struct {
+		Result struct {
+			Pin struct {
+				InstanceID string `json:"instance_id"`
+			} `json:"pin"`
+			RegisteredBy string `json:"registered_by"`
+			Tags         []struct {
+				Tag string `json:"tag"`
+			} `json:"tags"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(jsonData, &out); err != nil {
+		return nil, fmt.Errorf("cannot parse cipd describe output for %s@%s: %v", pkg, version, err)
+	}
+	desc := &InstanceDescription{
+		InstanceID:   out.Result.Pin.InstanceID,
+		RegisteredBy: out.Result.RegisteredBy,
+	}
+	for _, t := range out.Result.Tags {
+		desc.Tags = append(desc.Tags, t.Tag)
+	}
+	return desc, nil
+}
+
+// InstalledPackage is a package deployed in a cipd root, as reported by
+// `cipd installed`.
+type InstalledPackage struct {
+	// Subdir is the directory of the package, relative to the cipd root.
+	Subdir      string
+	PackageName string
+	InstanceID  string
+	// Version is the version the package was deployed from, e.g. a tag or a
+	// ref, as written in the ensure file.
+	Version string
+}
+
+// ServerHost returns the host of the cipd backend packages are fetched from.
+func ServerHost() string {
+	return strings.TrimPrefix(cipdBackend, "https://")
+}
+
+// Installed runs `cipd installed` to list the packages deployed in root,
+// sorted by subdir and package name.
+func Installed(jirix *jiri.X, root string) ([]InstalledPackage, error) {
+	if err := Bootstrap(jirix); err != nil {
+		return nil, err
+	}
+	jsonFile, err := os.CreateTemp("", "cipd_installed*.json")
+	if err != nil {
+		return nil, err
+	}
+	jsonFileName := jsonFile.Name()
+	jsonFile.Close()
+	defer os.Remove(jsonFileName)
+
+	args := []string{"installed", "-root", root, "-json-output", jsonFileName, "-log-level", "warning"}
+	jirix.Logger.Component("cipd").Debugf("Invoke cipd with %v", args)
+	command := exec.Command(jirix.CIPDPath(), args...)
+	command.Env = append(os.Environ(), "CIPD_HTTP_USER_AGENT_PREFIX="+getUserAgent())
+	var stderrBuf bytes.Buffer
+	command.Stdout = io.Discard
+	command.Stderr = &stderrBuf
+	if err := command.Run(); err != nil {
+		return nil, fmt.Errorf("cipd installed -root %s failed: %v: %s", root, err, strings.TrimSpace(stderrBuf.String()))
+	}
+	jsonData, err := os.ReadFile(jsonFileName)
+	if err != nil {
+		return nil, err
+	}
+	return parseInstalled(jsonData)
+}
+
+// parseInstalled parses the JSON output of `cipd installed`, which maps each
+// subdir to the packages deployed in it.
+func parseInstalled(data []byte) ([]InstalledPackage, error) {
+	var out struct {
+		Result map[string][]struct {
+			Package string `json:"package"`
+			Pin     *struct {
+				InstanceID string `json:"instance_id"`
+			} `json:"pin"`
+			Tracking string `json:"tracking"`
+			Error    string `json:"error"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("cannot parse cipd installed output: %v", err)
+	}
+	var pkgs []InstalledPackage
+	for subdir, infos := range out.Result {
+		for _, info := range infos {
+			if info.Error != "" {
+				return nil, fmt.Errorf("cipd package %q in %q: %s", info.Package, subdir, info.Error)
+			}
+			if info.Pin == nil {
+				continue
+			}
+			pkgs = append(pkgs, InstalledPackage{
+				Subdir:      subdir,
+				PackageName: info.Package,
+				InstanceID:  info.Pin.InstanceID,
+				Version:     info.Tracking,
+			})
+		}
+	}
+	sort.Slice(pkgs, func(i, j int) bool {
+		if pkgs[i].Subdir != pkgs[j].Subdir {
+			return pkgs[i].Subdir < pkgs[j].Subdir
+		}
+		return pkgs[i].PackageName < pkgs[j].PackageName
+	})
+	return pkgs, nil
+}
+
 // CheckLoggedIn checks cipd's user login information. It will return true
 // if login information is found or return false if login information is not
 // found.
@@ -379,7 +609,7 @@ func CheckLoggedIn(jirix *jiri.X) (bool, error) {
 	command.Stderr = &stderrBuf
 	if err := command.Run(); err != nil {
 		stdErrMsg := strings.TrimSpace(stderrBuf.String())
-		jirix.Logger.Debugf("Error happened while executing cipd, err: %q, stderr: %q", err, stdErrMsg)
+		jirix.Logger.Component("cipd").Debugf("Error happened while executing cipd, err: %q, stderr: %q", err, stdErrMsg)
 		if err, ok := err.(*exec.ExitError); ok && err.ExitCode() == exitCodeNoValidToken {
 			return false, nil
 		}
@@ -402,6 +632,12 @@ func Ensure(jirix *jiri.X, file, projectRoot string, timeout uint) error {
 		"-root", projectRoot,
 		"-max-threads", strconv.Itoa(jirix.CipdMaxThreads),
 	}
+	if jirix.CipdCacheDir != "" {
+		// cipd keeps the verified instances it downloads in the cache, so
+		// that they are not downloaded again, e.g. if ensure is
+		// interrupted, or by other roots sharing the cache.
+		args = append(args, "-cache-dir", jirix.CipdCacheDir)
+	}
 
 	if jirix.Logger.LoggerLevel <= log.WarningLevel {
 		// If jiri is running with -quiet, use cipd's "warning" log-level.
@@ -423,7 +659,7 @@ func Ensure(jirix *jiri.X, file, projectRoot string, timeout uint) error {
 
 	task := jirix.Logger.AddTaskMsg("Fetching CIPD packages")
 	defer task.Done()
-	jirix.Logger.Debugf("Invoke cipd with %v", args)
+	jirix.Logger.Component("cipd").Debugf("Invoke cipd with %v", args)
 
 	// Construct arguments and invoke cipd for ensure file
 	command := exec.CommandContext(ctx, jirix.CIPDPath(), args...)
@@ -459,7 +695,7 @@ func EnsureFileVerify(jirix *jiri.X, file string) error {
 
 	task := jirix.Logger.AddTaskMsg("Verifying CIPD ensure file")
 	defer task.Done()
-	jirix.Logger.Debugf("Invoke cipd with %v", args)
+	jirix.Logger.Component("cipd").Debugf("Invoke cipd with %v", args)
 
 	// Construct arguments and invoke cipd for ensure file
 	command := exec.Command(jirix.CIPDPath(), args...)
@@ -473,7 +709,7 @@ func EnsureFileVerify(jirix *jiri.X, file string) error {
 	command.Stderr = &stderrBuf
 
 	if err := command.Run(); err != nil {
-		jirix.Logger.Errorf("`cipd ensure-file-verify` failed: stdout: %s\nstderr: %s", stdoutBuf.String(), stderrBuf.String())
+		jirix.Logger.Component("cipd").Errorf("`cipd ensure-file-verify` failed: stdout: %s\nstderr: %s", stdoutBuf.String(), stderrBuf.String())
 		return cipdManifestInvalidErr
 	}
 
@@ -498,7 +734,7 @@ func ResolveEnsureFile(jirix *jiri.X, file string) ([]PackageInstance, error) {
 		return nil, err
 	}
 	args := []string{"ensure-file-resolve", "-ensure-file", file, "-log-level", "warning"}
-	jirix.Logger.Debugf("Invoke cipd with %v", args)
+	jirix.Logger.Component("cipd").Debugf("Invoke cipd with %v", args)
 
 	command := exec.Command(jirix.CIPDPath(), args...)
 	command.Env = append(os.Environ(), "CIPD_HTTP_USER_AGENT_PREFIX="+getUserAgent())
@@ -509,7 +745,7 @@ func ResolveEnsureFile(jirix *jiri.X, file string) ([]PackageInstance, error) {
 	command.Stdout = &stdoutBuf
 	command.Stderr = &stderrBuf
 	if err := command.Run(); err != nil {
-		jirix.Logger.Errorf("cipd returned error: %v", stderrBuf.String())
+		jirix.Logger.Component("cipd").Errorf("cipd returned error: %v", stderrBuf.String())
 		return nil, err
 	}
 
@@ -6,8 +6,12 @@ package cipd
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
 	"path/filepath"
@@ -170,6 +174,48 @@ func TestFetchDigest(t *testing.T) {
 	}
 }
 
+func TestFetchFileResume(t *testing.T) {
+	t.Parallel()
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	var ranges []string
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		mu.Unlock()
+		http.ServeContent(w, r, "cipd", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	jirix := &jiri.X{Logger: log.NewLogger(log.InfoLevel, color.NewColor(color.ColorNever), false, 0, time.Second, io.Discard, io.Discard)}
+	dest := filepath.Join(t.TempDir(), "cipd.partial")
+	// An interrupted download left the first half of the file.
+	if err := os.WriteFile(dest, content[:len(content)/2], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fetchFile(jirix, server.URL, dest); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("resumed download has %d bytes, want the %d bytes of the file", len(got), len(content))
+	}
+	if want := fmt.Sprintf("bytes=%d-", len(content)/2); len(ranges) != 1 || ranges[0] != want {
+		t.Errorf("got requests with ranges %q, want one with %q", ranges, want)
+	}
+
+	// A complete download is not fetched again.
+	if err := fetchFile(jirix, server.URL, dest); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(dest); err != nil || !bytes.Equal(got, content) {
+		t.Errorf("complete download was modified: %v", err)
+	}
+}
+
 func TestSelfUpdate(t *testing.T) {
 	t.Parallel()
 	fakex := newX(t)
@@ -215,6 +261,61 @@ func TestBootstrap(t *testing.T) {
 	}
 }
 
+// TestBootstrapPinned tests that Bootstrap verifies the cipd binary against
+// the client pinned by the manifest, and reuses it when offline. It does not
+// require network access.
+func TestBootstrapPinned(t *testing.T) {
+	root := t.TempDir()
+	env := cmdline.EnvFromOS()
+	env.Stdout = io.Discard
+	env.Stderr = io.Discard
+	color := color.NewColor(color.ColorNever)
+	jirix := &jiri.X{
+		Context: tool.NewContextFromEnv(env),
+		Root:    root,
+		Color:   color,
+		Logger:  log.NewLogger(log.InfoLevel, color, false, 0, time.Second*100, env.Stdout, env.Stderr),
+		Offline: true,
+	}
+	contents := []byte("#!/bin/sh\n")
+	sum := sha256.Sum256(contents)
+	digest := hex.EncodeToString(sum[:])
+	pin := func(version, digest string) *jiri.CIPDClientPin {
+		return &jiri.CIPDClientPin{Version: version, Digests: map[string]string{CurrentPlatform.String(): digest}}
+	}
+
+	jirix.CIPDClient = pin("version:1", digest)
+	if err := Bootstrap(jirix); err == nil {
+		t.Fatalf("Bootstrap offline without a cipd binary succeeded")
+	}
+	if err := os.MkdirAll(filepath.Dir(jirix.CIPDPath()), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(jirix.CIPDPath(), contents, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if verified, err := verifyBinary(jirix.CIPDPath(), digest); err != nil || !verified {
+		t.Fatalf("verifyBinary() = %t, %v, want true", verified, err)
+	}
+	if err := Bootstrap(jirix); err != nil {
+		t.Fatalf("Bootstrap with the pinned cipd binary failed: %v", err)
+	}
+	// The binary does not match this pin, but is reused offline.
+	other := strings.Repeat("0", 64)
+	if verified, _ := verifyBinary(jirix.CIPDPath(), other); verified {
+		t.Fatalf("verifyBinary() succeeded with another digest")
+	}
+	jirix.CIPDClient = pin("version:2", other)
+	if err := Bootstrap(jirix); err != nil {
+		t.Fatalf("Bootstrap offline with another cipd binary failed: %v", err)
+	}
+
+	jirix.CIPDClient = &jiri.CIPDClientPin{Version: "version:3", Digests: map[string]string{"plan9-mips": digest}}
+	if err := Bootstrap(jirix); err == nil {
+		t.Fatalf("Bootstrap succeeded without a digest for %s", CurrentPlatform)
+	}
+}
+
 func TestEnsure(t *testing.T) {
 	t.Parallel()
 	fakex := newX(t)
@@ -479,3 +580,34 @@ func TestFloatingRefs(t *testing.T) {
 		}
 	}
 }
+
+func TestParseInstalled(t *testing.T) {
+	t.Parallel()
+	data := []byte(`{
+  "result": {
+    "": [
+      {"package": "fuchsia/tools/b", "pin": {"package": "fuchsia/tools/b", "instance_id": "B"}, "tracking": "latest"},
+      {"package": "fuchsia/tools/a", "pin": {"package": "fuchsia/tools/a", "instance_id": "A"}}
+    ],
+    "prebuilt/clang": [
+      {"package": "fuchsia/clang/linux-amd64", "pin": {"package": "fuchsia/clang/linux-amd64", "instance_id": "C"}, "tracking": "git_revision:1234"}
+    ]
+  }
+}`)
+	got, err := parseInstalled(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []InstalledPackage{
+		{Subdir: "", PackageName: "fuchsia/tools/a", InstanceID: "A"},
+		{Subdir: "", PackageName: "fuchsia/tools/b", InstanceID: "B", Version: "latest"},
+		{Subdir: "prebuilt/clang", PackageName: "fuchsia/clang/linux-amd64", InstanceID: "C", Version: "git_revision:1234"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	if _, err := parseInstalled([]byte(`{"result": {"": [{"package": "a", "error": "broken"}]}}`)); err == nil {
+		t.Errorf("expected an error for a broken package")
+	}
+}
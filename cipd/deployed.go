@@ -0,0 +1,206 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cipd
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Problems of deployed files, see FileProblem.
+const (
+	FileMissing  = "missing"
+	FileModified = "modified"
+)
+
+// DeployedFile is a file of a deployed package instance, as listed in the
+// manifest cipd keeps of the instance.
+type DeployedFile struct {
+	// Name is the path of the file relative to the subdir of the package,
+	// with slashes.
+	Name       string `json:"name"`
+	Size       uint64 `json:"size"`
+	Executable bool   `json:"executable,omitempty"`
+	// Symlink is the target of the file if it is a symlink.
+	Symlink string `json:"symlink,omitempty"`
+	// Hash is the hex digest of the content of the file, with the hash
+	// algorithm of the instance ID. cipd only records it for the files of
+	// deployed instances.
+	Hash string `json:"hash,omitempty"`
+}
+
+// DeployedPackage is a package instance deployed in a cipd root, with its
+// files.
+type DeployedPackage struct {
+	// Subdir is the directory of the package, relative to the cipd root.
+	Subdir      string
+	PackageName string
+	InstanceID  string
+	Files       []DeployedFile
+}
+
+// FileProblem is a file of a deployed package which is not as deployed.
+type FileProblem struct {
+	// Name is the path of the file relative to the cipd root, with slashes.
+	Name string `json:"name"`
+	// Problem is FileMissing or FileModified.
+	Problem string `json:"problem"`
+}
+
+// Deployed reads the packages deployed in root from the metadata cipd keeps
+// in root/.cipd/pkgs, sorted by subdir and package name. Unlike Installed,
+// it doesn't need the cipd binary.
+func Deployed(root string) ([]DeployedPackage, error) {
+	pkgsDir := filepath.Join(root, ".cipd", "pkgs")
+	entries, err := os.ReadDir(pkgsDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var pkgs []DeployedPackage
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		pkg, ok, err := readDeployedPackage(filepath.Join(pkgsDir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			pkgs = append(pkgs, pkg)
+		}
+	}
+	sort.Slice(pkgs, func(i, j int) bool {
+		if pkgs[i].Subdir != pkgs[j].Subdir {
+			return pkgs[i].Subdir < pkgs[j].Subdir
+		}
+		return pkgs[i].PackageName < pkgs[j].PackageName
+	})
+	return pkgs, nil
+}
+
+// readDeployedPackage reads the package deployed in dir, a directory of
+// .cipd/pkgs. It returns false if no instance of the package is current,
+// e.g. if its deployment was interrupted.
+func readDeployedPackage(dir string) (DeployedPackage, bool, error) {
+	var pkg DeployedPackage
+	data, err := os.ReadFile(filepath.Join(dir, "description.json"))
+	if os.IsNotExist(err) {
+		return pkg, false, nil
+	} else if err != nil {
+		return pkg, false, err
+	}
+	var desc struct {
+		Subdir      string `json:"subdir"`
+		PackageName string `json:"package_name"`
+	}
+	if err := json.Unmarshal(data, &desc); err != nil {
+		return pkg, false, fmt.Errorf("cannot parse %s: %v", filepath.Join(dir, "description.json"), err)
+	}
+	pkg.Subdir = desc.Subdir
+	pkg.PackageName = desc.PackageName
+
+	// The current instance is a symlink, or a file on Windows.
+	if target, err := os.Readlink(filepath.Join(dir, "_current")); err == nil {
+		pkg.InstanceID = filepath.Base(target)
+	} else if data, err := os.ReadFile(filepath.Join(dir, "_current.txt")); err == nil {
+		pkg.InstanceID = strings.TrimSpace(string(data))
+	} else if os.IsNotExist(err) {
+		return pkg, false, nil
+	} else {
+		return pkg, false, err
+	}
+
+	manifest := filepath.Join(dir, pkg.InstanceID, ".cipdpkg", "manifest.json")
+	if data, err = os.ReadFile(manifest); err != nil {
+		return pkg, false, err
+	}
+	var m struct {
+		Files []DeployedFile `json:"files"`
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return pkg, false, fmt.Errorf("cannot parse %s: %v", manifest, err)
+	}
+	for _, f := range m.Files {
+		// The metadata of the package is not deployed to the subdir.
+		if !strings.HasPrefix(f.Name, ".cipdpkg/") {
+			pkg.Files = append(pkg.Files, f)
+		}
+	}
+	return pkg, true, nil
+}
+
+// VerifyDeployed checks the files of pkg, deployed in root, against the
+// manifest of its instance, and returns those which are missing or were
+// modified since. Files are compared by hash when the manifest records it,
+// and by size otherwise.
+func VerifyDeployed(root string, pkg DeployedPackage) ([]FileProblem, error) {
+	var problems []FileProblem
+	for _, f := range pkg.Files {
+		name := filepath.ToSlash(filepath.Join(pkg.Subdir, filepath.FromSlash(f.Name)))
+		problem, err := verifyDeployedFile(filepath.Join(root, filepath.FromSlash(name)), f)
+		if err != nil {
+			return nil, err
+		}
+		if problem != "" {
+			problems = append(problems, FileProblem{Name: name, Problem: problem})
+		}
+	}
+	return problems, nil
+}
+
+func verifyDeployedFile(path string, f DeployedFile) (string, error) {
+	if f.Symlink != "" {
+		target, err := os.Readlink(path)
+		if os.IsNotExist(err) {
+			return FileMissing, nil
+		} else if err != nil || target != f.Symlink {
+			return FileModified, nil
+		}
+		return "", nil
+	}
+	// Files deployed in symlink mode are symlinks to the instance, and are
+	// followed.
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return FileMissing, nil
+	} else if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() || uint64(info.Size()) != f.Size {
+		return FileModified, nil
+	}
+	var h hash.Hash
+	switch len(f.Hash) {
+	case sha1.Size * 2:
+		h = sha1.New()
+	case sha256.Size * 2:
+		h = sha256.New()
+	default:
+		return "", nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != strings.ToLower(f.Hash) {
+		return FileModified, nil
+	}
+	return "", nil
+}
@@ -0,0 +1,82 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cipd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestVerifyDeployed(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("cipd deploys the current instance as a symlink")
+	}
+	root := t.TempDir()
+	const instanceID = "S1nqWa3RPbI8kHh4HZ1vV0r3k3TEIMnhu1E1w1t2F7oC"
+	pkgDir := filepath.Join(root, ".cipd", "pkgs", "0")
+	write := func(path, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sum := sha256.Sum256([]byte("tool"))
+	write(filepath.Join(pkgDir, "description.json"), `{"subdir": "prebuilt/tools", "package_name": "fuchsia/tools/linux-amd64"}`)
+	write(filepath.Join(pkgDir, instanceID, ".cipdpkg", "manifest.json"), fmt.Sprintf(`{
+  "format_version": "1.1",
+  "package_name": "fuchsia/tools/linux-amd64",
+  "files": [
+    {"name": ".cipdpkg/manifest.json", "size": 1},
+    {"name": "bin/tool", "size": 4, "executable": true, "hash": %q},
+    {"name": "README", "size": 6},
+    {"name": "bin/link", "size": 0, "symlink": "tool"}
+  ]
+}`, hex.EncodeToString(sum[:])))
+	if err := os.Symlink(instanceID, filepath.Join(pkgDir, "_current")); err != nil {
+		t.Fatal(err)
+	}
+	write(filepath.Join(root, "prebuilt", "tools", "bin", "tool"), "tool")
+	write(filepath.Join(root, "prebuilt", "tools", "README"), "readme")
+	if err := os.Symlink("tool", filepath.Join(root, "prebuilt", "tools", "bin", "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	pkgs, err := Deployed(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkgs) != 1 || pkgs[0].Subdir != "prebuilt/tools" || pkgs[0].PackageName != "fuchsia/tools/linux-amd64" || pkgs[0].InstanceID != instanceID || len(pkgs[0].Files) != 3 {
+		t.Fatalf("got deployed packages %+v", pkgs)
+	}
+	if problems, err := VerifyDeployed(root, pkgs[0]); err != nil || len(problems) != 0 {
+		t.Fatalf("got problems %v, %v for an intact package", problems, err)
+	}
+
+	// A modification of the same size is only found by hash.
+	write(filepath.Join(root, "prebuilt", "tools", "bin", "tool"), "TOOL")
+	if err := os.Remove(filepath.Join(root, "prebuilt", "tools", "README")); err != nil {
+		t.Fatal(err)
+	}
+	problems, err := VerifyDeployed(root, pkgs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []FileProblem{
+		{Name: "prebuilt/tools/bin/tool", Problem: FileModified},
+		{Name: "prebuilt/tools/README", Problem: FileMissing},
+	}
+	if !reflect.DeepEqual(problems, want) {
+		t.Errorf("got problems %v, want %v", problems, want)
+	}
+}
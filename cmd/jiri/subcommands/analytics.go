@@ -0,0 +1,162 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"context"
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/google/subcommands"
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/analytics_util"
+)
+
+type analyticsCmd struct {
+	cmdBase
+
+	endpoint    string
+	endpointSet bool
+}
+
+func (c *analyticsCmd) Name() string     { return "analytics" }
+func (c *analyticsCmd) Synopsis() string { return "Show or change the analytics settings of the root" }
+func (c *analyticsCmd) Usage() string {
+	return `Shows or changes whether jiri collects anonymized analytics in this root.
+Consent is stored in [root]/.jiri_root/config, and is the same as set by
+"jiri init -analytics-opt".
+
+By default, analytics are sent to Google Analytics. If an endpoint is set,
+they are spooled in [root]/.jiri_root/analytics instead, including the
+class of the failure of failed commands, and uploaded to the endpoint in
+batches, as a JSON object {"records": [...]}.
+
+Usage:
+  jiri analytics [status]
+  jiri analytics [flags] enable
+  jiri analytics disable
+
+"disable" also deletes the records which were not uploaded yet.
+`
+}
+
+func (c *analyticsCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&c.endpoint, "endpoint", "", "With enable, the URL analytics are uploaded to in batches. An empty value sends them to Google Analytics.")
+}
+
+func (c *analyticsCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	f.Visit(func(fl *flag.Flag) {
+		if fl.Name == "endpoint" {
+			c.endpointSet = true
+		}
+	})
+	return executeWrapper(ctx, c.run, c.topLevelFlags, f.Args())
+}
+
+func (c *analyticsCmd) run(jirix *jiri.X, args []string) error {
+	action := "status"
+	if len(args) > 0 {
+		action = args[0]
+	}
+	if len(args) > 1 {
+		return jirix.UsageErrorf("%s takes no arguments", action)
+	}
+	if c.endpointSet && action != "enable" {
+		return jirix.UsageErrorf("-endpoint is only valid with enable")
+	}
+	configPath := filepath.Join(jirix.RootMetaDir(), jiri.ConfigFile)
+	config := &jiri.Config{}
+	if _, err := os.Stat(configPath); err == nil {
+		if config, err = jiri.ConfigFromFile(configPath); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	switch action {
+	case "status":
+		return c.status(jirix, config)
+	case "enable":
+		if config.AnalyticsOptIn != "yes" || config.AnalyticsVersion != analytics_util.Version || config.AnalyticsUserId == "" {
+			userID, err := newAnalyticsUserID()
+			if err != nil {
+				return err
+			}
+			config.AnalyticsOptIn = "yes"
+			config.AnalyticsVersion = analytics_util.Version
+			config.AnalyticsUserId = userID
+		}
+		if c.endpointSet {
+			config.AnalyticsEndpoint = c.endpoint
+		}
+		if err := config.Write(configPath); err != nil {
+			return err
+		}
+		fmt.Fprintln(jirix.Stdout(), "Analytics enabled.")
+		return c.status(jirix, config)
+	case "disable":
+		config.AnalyticsOptIn = "no"
+		config.AnalyticsVersion = ""
+		config.AnalyticsUserId = ""
+		if err := config.Write(configPath); err != nil {
+			return err
+		}
+		// The session of this command would spool its own record again
+		// when it ends.
+		if jirix.AnalyticsSession != nil {
+			jirix.AnalyticsSession.Disable()
+		}
+		if err := os.RemoveAll(filepath.Dir(jirix.AnalyticsSpoolFile())); err != nil {
+			return err
+		}
+		fmt.Fprintln(jirix.Stdout(), "Analytics disabled.")
+		return nil
+	}
+	return jirix.UsageErrorf("unknown analytics command %q", action)
+}
+
+func (c *analyticsCmd) status(jirix *jiri.X, config *jiri.Config) error {
+	w := jirix.Stdout()
+	switch {
+	case config.AnalyticsOptIn == "":
+		fmt.Fprintln(w, "Analytics: not set, run \"jiri analytics enable\" or \"jiri analytics disable\"")
+		return nil
+	case config.AnalyticsOptIn != "yes":
+		fmt.Fprintln(w, "Analytics: disabled")
+		return nil
+	case config.AnalyticsVersion != analytics_util.Version:
+		fmt.Fprintln(w, "Analytics: disabled, consent was given for an older version of the data collection, run \"jiri analytics enable\" again")
+		return nil
+	}
+	fmt.Fprintln(w, "Analytics: enabled")
+	fmt.Fprintf(w, "User id: %s\n", config.AnalyticsUserId)
+	if config.AnalyticsEndpoint == "" {
+		fmt.Fprintln(w, "Endpoint: Google Analytics")
+		return nil
+	}
+	fmt.Fprintf(w, "Endpoint: %s\n", config.AnalyticsEndpoint)
+	records, err := analytics_util.NewSpool(jirix.AnalyticsSpoolFile(), config.AnalyticsEndpoint).Records()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Records waiting for upload: %d\n", len(records))
+	return nil
+}
+
+// newAnalyticsUserID returns a random version 4 UUID identifying the root in
+// the analytics.
+func newAnalyticsUserID() (string, error) {
+	bytes := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, bytes); err != nil {
+		return "", err
+	}
+	bytes[6] = (bytes[6] & 0x0f) | 0x40
+	bytes[8] = (bytes[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", bytes[0:4], bytes[4:6], bytes[6:8], bytes[8:10], bytes[10:]), nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/subcommands"
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/analytics_util"
+	"go.fuchsia.dev/jiri/cmdline"
+	"go.fuchsia.dev/jiri/jiritest/xtest"
+)
+
+func TestAnalytics(t *testing.T) {
+	jirix := xtest.NewX(t)
+	configPath := filepath.Join(jirix.RootMetaDir(), jiri.ConfigFile)
+
+	cmd := analyticsCmd{endpoint: "https://analytics.example.com/jiri", endpointSet: true}
+	stdout, _, err := collectStdio(jirix, []string{"enable"}, cmd.run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Analytics: enabled", "Endpoint: https://analytics.example.com/jiri", "Records waiting for upload: 0"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("expected %q in output, got:\n%s", want, stdout)
+		}
+	}
+	config, err := jiri.ConfigFromFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.AnalyticsOptIn != "yes" || config.AnalyticsUserId == "" || config.AnalyticsEndpoint != "https://analytics.example.com/jiri" {
+		t.Errorf("unexpected config after enable: %+v", config)
+	}
+	userID := config.AnalyticsUserId
+
+	// Enabling again keeps the user id.
+	cmd = analyticsCmd{}
+	if _, _, err := collectStdio(jirix, []string{"enable"}, cmd.run); err != nil {
+		t.Fatal(err)
+	}
+	if config, err = jiri.ConfigFromFile(configPath); err != nil {
+		t.Fatal(err)
+	}
+	if config.AnalyticsUserId != userID || config.AnalyticsEndpoint == "" {
+		t.Errorf("enable changed the user id or endpoint: %+v", config)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(jirix.AnalyticsSpoolFile()), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(jirix.AnalyticsSpoolFile(), []byte(`{"command":"update"}`+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stdout, _, err = collectStdio(jirix, nil, cmd.run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Records waiting for upload: 1"; !strings.Contains(stdout, want) {
+		t.Errorf("expected %q in output, got:\n%s", want, stdout)
+	}
+
+	if _, _, err := collectStdio(jirix, []string{"disable"}, cmd.run); err != nil {
+		t.Fatal(err)
+	}
+	if config, err = jiri.ConfigFromFile(configPath); err != nil {
+		t.Fatal(err)
+	}
+	if config.AnalyticsOptIn != "no" || config.AnalyticsUserId != "" {
+		t.Errorf("unexpected config after disable: %+v", config)
+	}
+	if _, err := os.Stat(jirix.AnalyticsSpoolFile()); !os.IsNotExist(err) {
+		t.Errorf("disable should delete the spooled records, got %v", err)
+	}
+	stdout, _, err = collectStdio(jirix, []string{"status"}, cmd.run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Analytics: disabled"; !strings.Contains(stdout, want) {
+		t.Errorf("expected %q in output, got:\n%s", want, stdout)
+	}
+
+	if _, _, err := collectStdio(jirix, []string{"bogus"}, cmd.run); err == nil {
+		t.Errorf("expected an error for an unknown command")
+	}
+}
+
+// TestAnalyticsDisableSession tests that disabling the analytics does not
+// spool the record of the disable command itself when it ends.
+func TestAnalyticsDisableSession(t *testing.T) {
+	jirix := xtest.NewX(t)
+	config := &jiri.Config{
+		AnalyticsOptIn:    "yes",
+		AnalyticsVersion:  analytics_util.Version,
+		AnalyticsUserId:   "user",
+		AnalyticsEndpoint: "http://127.0.0.1:0/jiri",
+	}
+	if err := config.Write(filepath.Join(jirix.RootMetaDir(), jiri.ConfigFile)); err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr bytes.Buffer
+	env := cmdline.EnvFromOS()
+	env.Stdout, env.Stderr = &stdout, &stderr
+	ctx := cmdline.AddEnvToContext(context.Background(), env)
+	cmd := analyticsCmd{}
+	if got := executeWrapper(ctx, cmd.run, jiri.TopLevelFlags{Root: jirix.Root, Color: "never", Jobs: 1}, []string{"disable"}); got != subcommands.ExitSuccess {
+		t.Fatalf("disable failed with %v: %s", got, stderr.String())
+	}
+	if _, err := os.Stat(jirix.AnalyticsSpoolFile()); !os.IsNotExist(err) {
+		t.Errorf("the spool exists after disable: %v", err)
+	}
+}
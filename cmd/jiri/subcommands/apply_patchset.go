@@ -0,0 +1,288 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/subcommands"
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/project"
+)
+
+type applyPatchsetCmd struct {
+	cmdBase
+
+	mode   string
+	revert bool
+	list   bool
+}
+
+func (c *applyPatchsetCmd) Name() string { return "apply-patchset" }
+func (c *applyPatchsetCmd) Synopsis() string {
+	return "Apply or revert sets of patch files to projects"
+}
+func (c *applyPatchsetCmd) Usage() string {
+	return `Applies sets of patch files to projects, e.g. to patch a pinned checkout on
+builders, and records them in the metadata of each project so that they can be
+reverted.
+
+Usage:
+  jiri apply-patchset [flags] <dir, file or url>
+  jiri apply-patchset -revert [<project> ...]
+  jiri apply-patchset -list
+
+The patches are given either by:
+  - a directory with a sub-directory per project, named after the path of the
+    project relative to the root, or its name, holding the *.patch files to
+    apply in lexical order, e.g. as written by "git format-patch";
+  - a JSON file or an http(s) url of one, mapping projects to their patches,
+    relative to the file:
+      {"path/to/project": ["0001-fix.patch", "0002-fix.patch"]}
+
+With -mode=am, the default, the patches are committed with "git am --3way" on
+top of the current revision. With -mode=apply, they are applied to the working
+tree and the index with "git apply", without committing. Projects must have
+no uncommitted changes. If a patch of a project fails to apply, the project
+is restored as it was, and the other projects are still patched.
+
+-revert reverts the patchsets applied to the given projects, or to all
+projects. "jiri update" reverts applied patchsets before updating, and fails
+if one cannot be reverted cleanly, e.g. because commits were made on top of
+it. -list prints the applied patchsets.
+`
+}
+
+func (c *applyPatchsetCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&c.mode, "mode", project.PatchsetAm, `How to apply patches: "am" commits them, "apply" only changes the working tree and the index.`)
+	f.BoolVar(&c.revert, "revert", false, "Revert the applied patchsets of the given projects, or of all projects.")
+	f.BoolVar(&c.list, "list", false, "List the applied patchsets.")
+}
+
+func (c *applyPatchsetCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	return executeWrapper(ctx, c.run, c.topLevelFlags, f.Args())
+}
+
+func (c *applyPatchsetCmd) run(jirix *jiri.X, args []string) error {
+	if c.revert && c.list {
+		return jirix.UsageErrorf("-revert and -list cannot be used together")
+	}
+	if c.mode != project.PatchsetAm && c.mode != project.PatchsetApply {
+		return jirix.UsageErrorf("-mode should be %q or %q", project.PatchsetAm, project.PatchsetApply)
+	}
+	localProjects, err := project.LocalProjects(jirix, project.FastScan)
+	if err != nil {
+		return err
+	}
+	switch {
+	case c.list:
+		if len(args) != 0 {
+			return jirix.UsageErrorf("-list takes no arguments")
+		}
+		return listPatchsets(jirix, localProjects)
+	case c.revert:
+		projects := localProjects
+		if len(args) != 0 {
+			projects = make(project.Projects)
+			for _, arg := range args {
+				p, err := findPatchsetProject(jirix, localProjects, arg)
+				if err != nil {
+					return err
+				}
+				projects[p.Key()] = p
+			}
+		}
+		return project.RevertPatchsets(jirix, projects)
+	}
+	if len(args) != 1 {
+		return jirix.UsageErrorf("Please provide the directory, file or url of the patches")
+	}
+	patchsets, err := loadPatchsets(jirix, args[0])
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(patchsets))
+	for name := range patchsets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	// All projects are looked up first so that none is patched if the
+	// patchsets don't match the checkout.
+	projects := make([]project.Project, len(names))
+	for i, name := range names {
+		if projects[i], err = findPatchsetProject(jirix, localProjects, name); err != nil {
+			return err
+		}
+	}
+	failed := false
+	for i, p := range projects {
+		patches := patchsets[names[i]]
+		if err := project.ApplyPatchset(jirix, p, c.mode, patches); err != nil {
+			jirix.Logger.Errorf("Failed to apply the patchset of project %s(%s): %v\n\n", p.Name, p.Path, err)
+			failed = true
+			continue
+		}
+		jirix.Logger.Infof("Applied %d patches to project %s(%s)", len(patches), p.Name, p.Path)
+	}
+	if failed {
+		return fmt.Errorf("some patchsets failed to apply")
+	}
+	return nil
+}
+
+// findPatchsetProject returns the project of localProjects whose path
+// relative to the root, key or name is nameOrPath.
+func findPatchsetProject(jirix *jiri.X, localProjects project.Projects, nameOrPath string) (project.Project, error) {
+	path := filepath.Join(jirix.Root, filepath.FromSlash(nameOrPath))
+	for _, p := range localProjects {
+		if p.Path == path {
+			return p, nil
+		}
+	}
+	return localProjects.FindUnique(nameOrPath)
+}
+
+// loadPatchsets returns the patches of each project given by source, a
+// directory, a JSON file or the url of one.
+func loadPatchsets(jirix *jiri.X, source string) (map[string][]project.Patch, error) {
+	if u, err := url.Parse(source); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		return loadPatchsetsFromURL(jirix, u)
+	}
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return loadPatchsetsFromDir(source)
+	}
+	data, err := os.ReadFile(source)
+	if err != nil {
+		return nil, err
+	}
+	return loadPatchsetsFromJSON(data, func(name string) ([]byte, error) {
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(filepath.Dir(source), filepath.FromSlash(name))
+		}
+		return os.ReadFile(name)
+	})
+}
+
+func loadPatchsetsFromDir(dir string) (map[string][]project.Patch, error) {
+	patchsets := make(map[string][]project.Patch)
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(d.Name(), ".patch") {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return fmt.Errorf("patch %s is not in the directory of a project", path)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		// WalkDir visits files in lexical order.
+		name := filepath.ToSlash(rel)
+		patchsets[name] = append(patchsets[name], project.Patch{Name: d.Name(), Data: data})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(patchsets) == 0 {
+		return nil, fmt.Errorf("no patches found in %s", dir)
+	}
+	return patchsets, nil
+}
+
+func loadPatchsetsFromURL(jirix *jiri.X, u *url.URL) (map[string][]project.Patch, error) {
+	if jirix.Offline {
+		return nil, fmt.Errorf("offline: cannot download patches from URL %q", u)
+	}
+	get := func(u *url.URL) ([]byte, error) {
+		resp, err := http.Get(u.String())
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("downloading %s failed: %s", u, resp.Status)
+		}
+		return io.ReadAll(resp.Body)
+	}
+	data, err := get(u)
+	if err != nil {
+		return nil, err
+	}
+	return loadPatchsetsFromJSON(data, func(name string) ([]byte, error) {
+		ref, err := url.Parse(name)
+		if err != nil {
+			return nil, err
+		}
+		return get(u.ResolveReference(ref))
+	})
+}
+
+// loadPatchsetsFromJSON returns the patchsets of the JSON mapping in data,
+// reading the patches with read.
+func loadPatchsetsFromJSON(data []byte, read func(name string) ([]byte, error)) (map[string][]project.Patch, error) {
+	var files map[string][]string
+	if err := json.Unmarshal(data, &files); err != nil {
+		return nil, fmt.Errorf("invalid patchset mapping: %v", err)
+	}
+	patchsets := make(map[string][]project.Patch)
+	for name, patches := range files {
+		for _, patch := range patches {
+			data, err := read(patch)
+			if err != nil {
+				return nil, fmt.Errorf("reading patch %s of project %s: %v", patch, name, err)
+			}
+			patchsets[name] = append(patchsets[name], project.Patch{Name: patch, Data: data})
+		}
+	}
+	return patchsets, nil
+}
+
+func listPatchsets(jirix *jiri.X, localProjects project.Projects) error {
+	projects := make([]project.Project, 0, len(localProjects))
+	for _, p := range localProjects {
+		projects = append(projects, p)
+	}
+	sort.Sort(project.ProjectsByPath(projects))
+	for _, p := range projects {
+		ps, err := project.ReadAppliedPatchset(jirix, p)
+		if err != nil {
+			return err
+		}
+		if ps == nil {
+			continue
+		}
+		rel, err := filepath.Rel(jirix.Root, p.Path)
+		if err != nil {
+			rel = p.Path
+		}
+		fmt.Fprintf(jirix.Stdout(), "* project %s(%s) %s on %s\n", p.Name, rel, ps.Mode, shortRevision(ps.BaseRevision))
+		for _, patch := range ps.Patches {
+			fmt.Fprintf(jirix.Stdout(), "  %s\n", patch.Name)
+		}
+	}
+	return nil
+}
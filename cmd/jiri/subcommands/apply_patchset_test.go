@@ -0,0 +1,137 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.fuchsia.dev/jiri/gitutil"
+	"go.fuchsia.dev/jiri/project"
+)
+
+func TestApplyPatchset(t *testing.T) {
+	localProjects, fake := setupUniverse(t)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	p := localProjects[1]
+	git := gitutil.New(fake.X, gitutil.RootDirOpt(p.Path), gitutil.UserNameOpt("John Doe"), gitutil.UserEmailOpt("john.doe@example.com"))
+	base, err := git.CurrentRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Make a patch of a new file, in the directory of the project.
+	commitFile(t, git, "patched", "patched content")
+	patches := t.TempDir()
+	cmd := exec.Command("git", "format-patch", "-1", "-o", filepath.Join(patches, "path-1"))
+	cmd.Dir = p.Path
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("making the patch: %v\n%s", err, out)
+	}
+	if err := git.Reset(base); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := collectStdio(fake.X, []string{patches}, (&applyPatchsetCmd{mode: project.PatchsetAm}).run); err != nil {
+		t.Fatal(err)
+	}
+	head, err := git.CurrentRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head == base {
+		t.Fatalf("the patch was not committed")
+	}
+	ps, err := project.ReadAppliedPatchset(fake.X, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ps == nil || ps.BaseRevision != base || ps.HeadRevision != head || len(ps.Patches) != 1 {
+		t.Fatalf("wrong patchset record %+v", ps)
+	}
+	stdout, _, err := collectStdio(fake.X, nil, (&applyPatchsetCmd{mode: project.PatchsetAm, list: true}).run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "* project " + p.Name + "(path-1) am on "; !strings.HasPrefix(stdout, want) || !strings.Contains(stdout, "0001-Commit-patched.patch") {
+		t.Errorf("got list %q, want it to start with %q", stdout, want)
+	}
+	if _, _, err := collectStdio(fake.X, []string{patches}, (&applyPatchsetCmd{mode: project.PatchsetAm}).run); err == nil {
+		t.Errorf("applying a second patchset should fail")
+	}
+
+	if _, _, err := collectStdio(fake.X, []string{p.Name}, (&applyPatchsetCmd{mode: project.PatchsetAm, revert: true}).run); err != nil {
+		t.Fatal(err)
+	}
+	if head, err := git.CurrentRevision(); err != nil {
+		t.Fatal(err)
+	} else if head != base {
+		t.Errorf("got revision %s after revert, want %s", head, base)
+	}
+	if ps, err := project.ReadAppliedPatchset(fake.X, p); err != nil || ps != nil {
+		t.Errorf("got patchset %+v, %v after revert, want none", ps, err)
+	}
+
+	// Apply the patch without committing it, from a JSON mapping.
+	mapping := filepath.Join(patches, "patches.json")
+	if err := os.WriteFile(mapping, []byte(`{"`+p.Name+`": ["path-1/0001-Commit-patched.patch"]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := collectStdio(fake.X, []string{mapping}, (&applyPatchsetCmd{mode: project.PatchsetApply}).run); err != nil {
+		t.Fatal(err)
+	}
+	if dirty, err := git.HasUncommittedChanges(); err != nil || !dirty {
+		t.Fatalf("the patch should be uncommitted: %v, %v", dirty, err)
+	}
+	// "jiri update" reverts the patchset.
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := collectStdio(fake.X, nil, (&updateCmd{attempts: 1}).run); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(p.Path, "patched")); !os.IsNotExist(err) {
+		t.Errorf("the patched file should be reverted: %v", err)
+	}
+	if ps, err := project.ReadAppliedPatchset(fake.X, p); err != nil || ps != nil {
+		t.Errorf("got patchset %+v, %v after update, want none", ps, err)
+	}
+
+	// A patchset which cannot be reverted entirely is left applied.
+	if base, err = git.CurrentRevision(); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, git, "patched", "patched content")
+	commitFile(t, git, "other", "other content")
+	patches = t.TempDir()
+	cmd = exec.Command("git", "format-patch", "-2", "-o", filepath.Join(patches, "path-1"))
+	cmd.Dir = p.Path
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("making the patches: %v\n%s", err, out)
+	}
+	if err := git.Reset(base); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := collectStdio(fake.X, []string{patches}, (&applyPatchsetCmd{mode: project.PatchsetApply}).run); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(p.Path, "patched"), []byte("changed content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := collectStdio(fake.X, []string{p.Name}, (&applyPatchsetCmd{mode: project.PatchsetApply, revert: true}).run); err == nil || !strings.Contains(err.Error(), "still applied") {
+		t.Errorf("got error %v reverting a changed patchset, want it to be left applied", err)
+	}
+	if _, err := os.Stat(filepath.Join(p.Path, "other")); err != nil {
+		t.Errorf("the patch reverted before the failure should be applied again: %v", err)
+	}
+	if ps, err := project.ReadAppliedPatchset(fake.X, p); err != nil || ps == nil || len(ps.Patches) != 2 {
+		t.Errorf("got patchset %+v, %v after the failed revert, want it recorded", ps, err)
+	}
+}
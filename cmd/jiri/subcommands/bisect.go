@@ -0,0 +1,303 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/google/subcommands"
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/envvar"
+	"go.fuchsia.dev/jiri/project"
+)
+
+type bisectCmd struct {
+	cmdBase
+
+	good             string
+	bad              string
+	snapshots        string
+	runHooks         bool
+	fetchPkgs        bool
+	hookTimeout      uint
+	fetchPkgsTimeout uint
+}
+
+func (c *bisectCmd) Name() string     { return "bisect" }
+func (c *bisectCmd) Synopsis() string { return "Find the snapshot that broke a test" }
+func (c *bisectCmd) Usage() string {
+	return `Binary searches a range of snapshots for the first one on which a test command
+fails. Each tested snapshot is checked out as with "jiri update <snapshot>" and
+the command is run from the root directory using the shell specified by the
+$SHELL environment variable, or "sh" if that's not set.
+
+The exit code of the command decides the outcome, as with "git bisect run":
+0 marks the snapshot good, 125 skips it, 1 to 127 mark it bad and anything
+else aborts the bisect.
+
+The range is either given by -good and -bad, which name snapshots of the update
+history (see "jiri history"), or by -snapshots, a file listing snapshot files
+or URLs one per line from oldest to newest. The first snapshot of the range is
+assumed to be good and the last one bad; neither is tested.
+
+Once done, the first bad snapshot is reported together with the projects
+that changed since the last good one. The checkout is left at the last
+tested snapshot, run "jiri update" to return to the manifest. The tested
+snapshots are not recorded in the update history.
+
+Usage:
+  jiri bisect [flags] <command> [<args>...]
+`
+}
+
+func (c *bisectCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&c.good, "good", "", "Update history snapshot known to be good.")
+	f.StringVar(&c.bad, "bad", "latest", "Update history snapshot known to be bad.")
+	f.StringVar(&c.snapshots, "snapshots", "", "File listing the snapshots to bisect, oldest first. Cannot be used with -good.")
+	f.BoolVar(&c.runHooks, "run-hooks", true, "Run hooks after checking out a snapshot.")
+	f.BoolVar(&c.fetchPkgs, "fetch-packages", true, "Use cipd to fetch packages after checking out a snapshot.")
+	f.UintVar(&c.hookTimeout, "hook-timeout", project.DefaultHookTimeout, "Timeout in minutes for running the hooks operation.")
+	f.UintVar(&c.fetchPkgsTimeout, "fetch-packages-timeout", project.DefaultPackageTimeout, "Timeout in minutes for fetching prebuilt packages using cipd.")
+}
+
+func (c *bisectCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	return executeWrapper(ctx, c.run, c.topLevelFlags, f.Args())
+}
+
+// bisectSnapshot is a snapshot in the bisected range.
+type bisectSnapshot struct {
+	// name is how the snapshot is reported to the user.
+	name string
+	// location is the path or URL of the snapshot.
+	location string
+}
+
+type bisectVerdict int
+
+const (
+	bisectGood bisectVerdict = iota
+	bisectBad
+	bisectSkip
+)
+
+func (c *bisectCmd) run(jirix *jiri.X, args []string) error {
+	if len(args) == 0 {
+		return jirix.UsageErrorf("no test command given")
+	}
+	var snapshots []bisectSnapshot
+	var err error
+	if c.snapshots != "" {
+		if c.good != "" {
+			return jirix.UsageErrorf("-snapshots cannot be used with -good")
+		}
+		snapshots, err = readSnapshotList(c.snapshots)
+	} else {
+		if c.good == "" {
+			return jirix.UsageErrorf("either -good or -snapshots is required")
+		}
+		snapshots, err = historyRange(jirix, c.good, c.bad)
+	}
+	if err != nil {
+		return err
+	}
+	if len(snapshots) < 2 {
+		return fmt.Errorf("need at least a good and a bad snapshot to bisect, got %d snapshot(s)", len(snapshots))
+	}
+
+	test := func(i int) (bisectVerdict, error) {
+		s := snapshots[i]
+		jirix.Logger.Infof("Bisecting: testing snapshot %s\n", s.name)
+		// The checkouts are not recorded in the update history, which holds
+		// the snapshots being bisected.
+		if err := project.CheckoutSnapshotWithParams(jirix, s.location, project.UpdateUniverseParams{
+			RunHooks:             c.runHooks,
+			FetchPackages:        c.fetchPkgs,
+			RunHookTimeout:       c.hookTimeout,
+			FetchPackagesTimeout: c.fetchPkgsTimeout,
+			NoHistory:            true,
+		}); err != nil {
+			return bisectSkip, fmt.Errorf("checking out snapshot %s: %v", s.name, err)
+		}
+		v, err := c.runTest(jirix, args)
+		if err != nil {
+			return v, err
+		}
+		switch v {
+		case bisectGood:
+			jirix.Logger.Infof("Snapshot %s is good\n\n", s.name)
+		case bisectBad:
+			jirix.Logger.Infof("Snapshot %s is bad\n\n", s.name)
+		default:
+			jirix.Logger.Infof("Skipping snapshot %s\n\n", s.name)
+		}
+		return v, nil
+	}
+	good, bad, err := bisectSnapshots(len(snapshots), test)
+	if err != nil {
+		return err
+	}
+
+	if bad-good > 1 {
+		fmt.Fprintf(jirix.Stdout(), "The first bad snapshot could not be identified because snapshots were skipped. It is one of:\n")
+		for _, s := range snapshots[good+1 : bad+1] {
+			fmt.Fprintf(jirix.Stdout(), "  %s\n", s.name)
+		}
+	} else {
+		fmt.Fprintf(jirix.Stdout(), "First bad snapshot: %s\n", snapshots[bad].name)
+	}
+	fmt.Fprintf(jirix.Stdout(), "Last good snapshot: %s\n", snapshots[good].name)
+	d, err := (&diffCmd{}).getDiff(jirix, snapshots[good].location, snapshots[bad].location)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(jirix.Stdout(), "\nChanged projects:\n")
+	printDiffSummary(jirix.Stdout(), d)
+	return nil
+}
+
+// runTest runs the test command in the root directory and classifies its exit
+// code.
+func (c *bisectCmd) runTest(jirix *jiri.X, args []string) (bisectVerdict, error) {
+	shell := os.Getenv("SHELL")
+	if shell == "" {
+		shell = "sh"
+	}
+	cmd := exec.Command(shell, "-c", strings.Join(args, " "))
+	cmd.Env = envvar.MapToSlice(jirix.Env())
+	cmd.Dir = jirix.Root
+	cmd.Stdin = jirix.Stdin()
+	cmd.Stdout = jirix.Stdout()
+	cmd.Stderr = jirix.Stderr()
+	err := cmd.Run()
+	if err == nil {
+		return bisectGood, nil
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return bisectSkip, fmt.Errorf("running test command: %v", err)
+	}
+	switch code := exitErr.ExitCode(); {
+	case code == 125:
+		return bisectSkip, nil
+	case code > 0 && code < 128:
+		return bisectBad, nil
+	default:
+		return bisectSkip, fmt.Errorf("test command exited with %d, aborting bisect", code)
+	}
+}
+
+// bisectSnapshots binary searches n snapshots, of which the first is good and
+// the last is bad, for the first bad one using test. It returns the index of
+// the last good snapshot and of the first bad one. If they are not adjacent,
+// all snapshots in between were skipped.
+func bisectSnapshots(n int, test func(i int) (bisectVerdict, error)) (int, int, error) {
+	good, bad := 0, n-1
+	skipped := make(map[int]bool)
+	for {
+		i := nextBisectCandidate(good, bad, skipped)
+		if i < 0 {
+			return good, bad, nil
+		}
+		v, err := test(i)
+		if err != nil {
+			return good, bad, err
+		}
+		switch v {
+		case bisectGood:
+			good = i
+		case bisectBad:
+			bad = i
+		default:
+			skipped[i] = true
+		}
+	}
+}
+
+// nextBisectCandidate returns the snapshot between good and bad that is
+// closest to the middle and was not skipped, or -1 if there is none.
+func nextBisectCandidate(good, bad int, skipped map[int]bool) int {
+	mid := (good + bad) / 2
+	for d := 0; mid-d > good || mid+d < bad; d++ {
+		for _, i := range []int{mid - d, mid + d} {
+			if i > good && i < bad && !skipped[i] {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// historyRange returns the update history snapshots from good to bad, oldest
+// first.
+func historyRange(jirix *jiri.X, good, bad string) ([]bisectSnapshot, error) {
+	history, err := project.ListUpdateHistory(jirix)
+	if err != nil {
+		return nil, err
+	}
+	index := func(name string) (int, error) {
+		path, err := project.ResolveUpdateHistory(jirix, name)
+		if err != nil {
+			return 0, err
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			return 0, err
+		}
+		// "latest" and "second-latest" are hard links to snapshots.
+		for i, s := range history {
+			if si, err := os.Stat(s.Path); err == nil && os.SameFile(fi, si) {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("snapshot %q is not in the update history", name)
+	}
+	goodIndex, err := index(good)
+	if err != nil {
+		return nil, err
+	}
+	badIndex, err := index(bad)
+	if err != nil {
+		return nil, err
+	}
+	if goodIndex <= badIndex {
+		return nil, fmt.Errorf("good snapshot %q must be older than bad snapshot %q", good, bad)
+	}
+	var snapshots []bisectSnapshot
+	// history is sorted newest first.
+	for i := goodIndex; i >= badIndex; i-- {
+		snapshots = append(snapshots, bisectSnapshot{name: history[i].Name, location: history[i].Path})
+	}
+	return snapshots, nil
+}
+
+// readSnapshotList reads a file listing snapshot files or URLs, one per line.
+// Empty lines and lines starting with "#" are ignored.
+func readSnapshotList(file string) ([]bisectSnapshot, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var snapshots []bisectSnapshot
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		snapshots = append(snapshots, bisectSnapshot{name: line, location: line})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return snapshots, nil
+}
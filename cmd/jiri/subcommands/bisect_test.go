@@ -0,0 +1,148 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.fuchsia.dev/jiri/jiritest/xtest"
+	"go.fuchsia.dev/jiri/project"
+)
+
+func TestBisectSnapshots(t *testing.T) {
+	tests := []struct {
+		n, firstBad       int
+		skip              map[int]bool
+		wantGood, wantBad int
+	}{
+		{2, 1, nil, 0, 1},
+		{10, 1, nil, 0, 1},
+		{10, 9, nil, 8, 9},
+		{10, 5, nil, 4, 5},
+		{10, 5, map[int]bool{4: true}, 3, 5},
+		{10, 5, map[int]bool{3: true, 4: true, 5: true}, 2, 6},
+	}
+	for _, test := range tests {
+		tested := make(map[int]bool)
+		good, bad, err := bisectSnapshots(test.n, func(i int) (bisectVerdict, error) {
+			if i <= 0 || i >= test.n-1 {
+				t.Errorf("n=%d: snapshot %d should not be tested", test.n, i)
+			}
+			if tested[i] {
+				t.Errorf("n=%d: snapshot %d tested twice", test.n, i)
+			}
+			tested[i] = true
+			switch {
+			case test.skip[i]:
+				return bisectSkip, nil
+			case i < test.firstBad:
+				return bisectGood, nil
+			}
+			return bisectBad, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if good != test.wantGood || bad != test.wantBad {
+			t.Errorf("n=%d firstBad=%d skip=%v: got (%d, %d), want (%d, %d)", test.n, test.firstBad, test.skip, good, bad, test.wantGood, test.wantBad)
+		}
+	}
+}
+
+func TestBisectHistoryRange(t *testing.T) {
+	jirix := xtest.NewX(t)
+	if err := os.MkdirAll(jirix.UpdateHistoryDir(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().Truncate(time.Second)
+	var names []string
+	for i := 3; i >= 0; i-- {
+		name := now.Add(-time.Duration(i) * time.Hour).Format(time.RFC3339)
+		if err := os.WriteFile(filepath.Join(jirix.UpdateHistoryDir(), name), []byte("<manifest/>\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	if err := os.Link(filepath.Join(jirix.UpdateHistoryDir(), names[3]), jirix.UpdateHistoryLatestLink()); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshots, err := historyRange(jirix, "~2", "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, s := range snapshots {
+		got = append(got, s.name)
+	}
+	if want := names[1:]; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("historyRange(~2, latest) = %v, want %v", got, want)
+	}
+	if _, err := historyRange(jirix, "latest", "~2"); err == nil {
+		t.Errorf("historyRange should fail if the good snapshot is newer than the bad one")
+	}
+}
+
+func TestBisect(t *testing.T) {
+	localProjects, fake := setupUniverse(t)
+	p := localProjects[1]
+	var list []string
+	for i := 0; i < 5; i++ {
+		state := "good"
+		if i >= 2 {
+			state = "bad"
+		}
+		writeReadme(t, fake.X, fake.Projects[p.Name], fmt.Sprintf("%s %d", state, i))
+		if err := fake.UpdateUniverse(false); err != nil {
+			t.Fatal(err)
+		}
+		file := filepath.Join(t.TempDir(), fmt.Sprintf("snapshot-%d", i))
+		if err := project.CreateSnapshot(fake.X, file, nil, nil, false, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		list = append(list, file)
+	}
+	listFile := filepath.Join(t.TempDir(), "snapshots")
+	if err := os.WriteFile(listFile, []byte(strings.Join(list, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	history := func() string {
+		t.Helper()
+		snapshots, err := project.ListUpdateHistory(fake.X)
+		if err != nil {
+			t.Fatal(err)
+		}
+		latest, err := os.ReadFile(fake.X.UpdateHistoryLatestLink())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fmt.Sprintf("%v\n%s", snapshots, latest)
+	}
+	before := history()
+
+	cmd := bisectCmd{snapshots: listFile}
+	stdout, _, err := collectStdio(fake.X, []string{"grep", "-q", "good", filepath.Join(p.Path, "README")}, cmd.run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after := history(); after != before {
+		t.Errorf("bisect changed the update history from:\n%s\nto:\n%s", before, after)
+	}
+	for _, want := range []string{
+		"First bad snapshot: " + list[2],
+		"Last good snapshot: " + list[1],
+		"~ " + p.Name + " (path-1)",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("expected %q in output, got:\n%s", want, stdout)
+		}
+	}
+}
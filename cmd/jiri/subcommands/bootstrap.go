@@ -8,15 +8,18 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"os"
 	"strings"
 
 	"github.com/google/subcommands"
 	"go.fuchsia.dev/jiri"
 	"go.fuchsia.dev/jiri/cipd"
+	"go.fuchsia.dev/jiri/project"
 )
 
 type bootstrapCmd struct {
 	cmdBase
+	offline bool
 }
 
 func (c *bootstrapCmd) Name() string     { return "bootstrap" }
@@ -28,10 +31,19 @@ Usage:
   jiri bootstrap [<package ...>]
 
 <package ...> is a list of packages that can be bootstrapped by jiri. If the list is empty, jiri will list supported packages.
+
+The cipd client is bootstrapped at the version pinned by the <cipd_client>
+element of the manifest, or at the version built into jiri if the manifest
+does not pin it. The client is verified against the sha256 digest of the pin
+for this platform before it is run, and fetched again if it does not match.
+With -offline, an existing client that does not match is reused with a
+warning instead.
 `
 }
 
-func (c *bootstrapCmd) SetFlags(f *flag.FlagSet) {}
+func (c *bootstrapCmd) SetFlags(f *flag.FlagSet) {
+	f.BoolVar(&c.offline, "offline", false, "Reuse the cipd client already present, even if it does not match the pinned version.")
+}
 
 func (c *bootstrapCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
 	return executeWrapper(ctx, c.run, c.topLevelFlags, f.Args())
@@ -43,9 +55,13 @@ func (c *bootstrapCmd) run(jirix *jiri.X, args []string) error {
 		fmt.Printf("Supported package(s):\n\tcipd\n")
 		return nil
 	}
+	jirix.Offline = c.offline
 	for _, v := range args {
 		switch strings.ToLower(v) {
 		case "cipd":
+			if err := loadCIPDClientPin(jirix); err != nil {
+				return err
+			}
 			if err := cipd.Bootstrap(jirix); err != nil {
 				return err
 			}
@@ -57,3 +73,20 @@ func (c *bootstrapCmd) run(jirix *jiri.X, args []string) error {
 	}
 	return nil
 }
+
+// loadCIPDClientPin sets the cipd client pinned by the manifest of the root,
+// if there is one, in jirix.
+func loadCIPDClientPin(jirix *jiri.X) error {
+	if _, err := os.Stat(jirix.JiriManifestFile()); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	localProjects, err := project.LocalProjects(jirix, project.FastScan)
+	if err != nil {
+		return err
+	}
+	_, _, _, err = project.LoadManifestFile(jirix, jirix.JiriManifestFile(), localProjects, nil)
+	return err
+}
@@ -30,6 +30,9 @@ type branchCmd struct {
 	deleteMerged          bool
 	forceDelete           bool
 	overrideProjectConfig bool
+	list                  bool
+	staleDays             int
+	pruneMerged           bool
 }
 
 func (c *branchCmd) Name() string     { return "branch" }
@@ -38,6 +41,16 @@ func (c *branchCmd) Usage() string {
 	return `Show all the projects having branch <branch>. If -d or -D is passed, <branch>
 is deleted. if <branch> is not passed, show all projects which have branches other than "main"
 
+With -list, the branches of all projects, or only <branch>, are listed in
+columns, with their upstream and the age of their last commit. Branches are
+flagged "gone" if their upstream no longer exists, "merged" if they are
+merged into JIRI_HEAD, the revision checked out by the last update, without
+being at JIRI_HEAD, and "stale" if they have no commit for -stale-days days.
+-prune-merged deletes the merged branches, except the current ones.
+
+With "jiri init -enable-submodules", the branches of the git submodules
+checked out in projects are shown and deleted too.
+
 Usage:
   jiri branch [flags] <branch>
 
@@ -50,6 +63,9 @@ func (c *branchCmd) SetFlags(f *flag.FlagSet) {
 	f.BoolVar(&c.forceDelete, "D", false, "Force delete branch from project. Similar to running 'git branch -D <branch-name>'")
 	f.BoolVar(&c.overrideProjectConfig, "override-pc", false, "Overrides project config's ignore and noupdate flag and deletes the branch.")
 	f.BoolVar(&c.deleteMerged, "delete-merged", false, "Delete merged branches. Merged branches are the tracked branches merged with their tracking remote or un-tracked branches merged with the branch specified in manifest(default main). If <branch> is provided, it will only delete branch <branch> if merged.")
+	f.BoolVar(&c.list, "list", false, "List the branches of all projects in columns, flagging the branches whose upstream is gone, which are merged or stale.")
+	f.IntVar(&c.staleDays, "stale-days", 30, "With -list, flag the branches without commits for this many days as stale. 0 disables it.")
+	f.BoolVar(&c.pruneMerged, "prune-merged", false, "Implies -list. Delete the branches merged into JIRI_HEAD, except the current branches.")
 	f.BoolVar(&c.deleteMergedCLs, "delete-merged-cl", false, "Implies -delete-merged. It also parses commit messages for ChangeID and checks with gerrit if those changes have been merged and deletes those branches. It will ignore a branch if it differs with remote by more than 10 commits.")
 }
 
@@ -70,6 +86,9 @@ func (c *branchCmd) run(jirix *jiri.X, args []string) error {
 		}
 		return c.deleteBranches(jirix, branch)
 	}
+	if c.list || c.pruneMerged {
+		return c.listBranches(jirix, branch)
+	}
 	if c.deleteMergedCLs {
 		return c.deleteMergedBranches(jirix, branch, true)
 	}
@@ -84,6 +103,9 @@ func (c *branchCmd) displayProjects(jirix *jiri.X, branch string) error {
 	if err != nil {
 		return err
 	}
+	if localProjects, err = project.WithSubmodules(jirix, localProjects); err != nil {
+		return err
+	}
 	jirix.TimerPush("Get states")
 	states, err := project.GetProjectStates(jirix, localProjects, false)
 	if err != nil {
@@ -149,17 +171,21 @@ func (c *branchCmd) deleteMergedBranches(jirix *jiri.X, branchToDelete string, d
 
 	cDir := jirix.Cwd
 
-	jirix.TimerPush("Get states")
-	states, err := project.GetProjectStates(jirix, localProjects, false)
+	remoteProjects, _, _, err := project.LoadManifestFile(jirix, jirix.JiriManifestFile(), localProjects, nil)
 	if err != nil {
 		return err
 	}
-	jirix.TimerPop()
+	if localProjects, err = project.WithSubmodules(jirix, localProjects); err != nil {
+		return err
+	}
+	addSubmoduleRemotes(localProjects, remoteProjects)
 
-	remoteProjects, _, _, err := project.LoadManifestFile(jirix, jirix.JiriManifestFile(), localProjects, nil)
+	jirix.TimerPush("Get states")
+	states, err := project.GetProjectStates(jirix, localProjects, false)
 	if err != nil {
 		return err
 	}
+	jirix.TimerPop()
 
 	jirix.TimerPush("Process")
 	processProject := func(key project.ProjectKey) {
@@ -286,7 +312,7 @@ func (c *branchCmd) deleteProjectMergedClsBranches(jirix *jiri.X, local project.
 			if rb == "" {
 				rb = "main"
 			}
-			trackingBranch = fmt.Sprintf("remotes/origin/%s", rb)
+			trackingBranch = fmt.Sprintf("remotes/%s/%s", remote.PrimaryRemote(), rb)
 		} else {
 			trackingBranch = b.Tracking.Name
 		}
@@ -386,7 +412,7 @@ func (c *branchCmd) deleteProjectMergedBranches(jirix *jiri.X, local project.Pro
 				if rb == "" {
 					rb = "main"
 				}
-				if mbs, err := scm.MergedBranches("remotes/origin/" + rb); err != nil {
+				if mbs, err := scm.MergedBranches("remotes/" + remote.PrimaryRemote() + "/" + rb); err != nil {
 					retErr = errors.Join(retErr, fmt.Errorf("Not able to get merged un-tracked branches: %s\n", err))
 					continue
 				} else {
@@ -453,6 +479,9 @@ func (c *branchCmd) deleteBranches(jirix *jiri.X, branchToDelete string) error {
 	if err != nil {
 		return err
 	}
+	if localProjects, err = project.WithSubmodules(jirix, localProjects); err != nil {
+		return err
+	}
 	cDir := jirix.Cwd
 	states, err := project.GetProjectStates(jirix, localProjects, false)
 	if err != nil {
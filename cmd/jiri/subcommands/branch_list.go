@@ -0,0 +1,204 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/gitutil"
+	"go.fuchsia.dev/jiri/project"
+)
+
+// listedBranch is a branch of a project listed by -list.
+type listedBranch struct {
+	project      project.Project
+	relativePath string
+	gitutil.BranchDetail
+	// merged is set if the branch is merged into JIRI_HEAD, without being
+	// at JIRI_HEAD, i.e. it has no commits of its own left. Local copies of
+	// remote branches, e.g. "main" tracking "origin/main", are never merged.
+	merged bool
+	// stale is set if the branch has no commit since -stale-days.
+	stale bool
+}
+
+// status returns the flags of b, colored.
+func (b listedBranch) status(jirix *jiri.X) string {
+	var flags []string
+	if b.UpstreamGone {
+		flags = append(flags, jirix.Color.Red("gone"))
+	}
+	if b.merged {
+		flags = append(flags, jirix.Color.Yellow("merged"))
+	}
+	if b.stale {
+		flags = append(flags, jirix.Color.Magenta("stale"))
+	}
+	return strings.Join(flags, ",")
+}
+
+// listBranches prints the local branches of all projects, or only those
+// named branch, in columns, flagging those whose upstream is gone, which are
+// merged into JIRI_HEAD or which are stale. With -prune-merged, the merged
+// branches are then deleted.
+func (c *branchCmd) listBranches(jirix *jiri.X, branch string) error {
+	localProjects, err := project.LocalProjects(jirix, project.FastScan)
+	if err != nil {
+		return err
+	}
+	if localProjects, err = project.WithSubmodules(jirix, localProjects); err != nil {
+		return err
+	}
+	var projects []project.Project
+	for _, p := range localProjects {
+		projects = append(projects, p)
+	}
+	sort.Sort(project.ProjectsByPath(projects))
+	now := time.Now()
+	var branches []listedBranch
+	for _, p := range projects {
+		relativePath, err := filepath.Rel(jirix.Cwd, p.Path)
+		if err != nil {
+			relativePath = p.Path
+		}
+		scm := gitutil.New(jirix, gitutil.RootDirOpt(p.Path))
+		details, err := scm.BranchDetails()
+		if err != nil {
+			return fmt.Errorf("listing branches of project %s(%s): %v", p.Name, relativePath, err)
+		}
+		merged := make(map[string]bool)
+		// Projects without JIRI_HEAD were never updated by jiri.
+		if head, err := scm.CurrentRevisionForRef("JIRI_HEAD"); err == nil {
+			mbs, err := scm.MergedBranches("JIRI_HEAD")
+			if err != nil {
+				return fmt.Errorf("listing merged branches of project %s(%s): %v", p.Name, relativePath, err)
+			}
+			for _, mb := range mbs {
+				merged[mb] = true
+			}
+			for _, d := range details {
+				switch {
+				case d.Revision == head:
+					// Just created, not merged.
+					delete(merged, d.Name)
+				case d.Upstream == p.PrimaryRemote()+"/"+d.Name && !d.UpstreamGone:
+					// A local copy of a remote branch, e.g. "main"
+					// tracking "origin/main", rather than a work branch.
+					delete(merged, d.Name)
+				}
+			}
+		}
+		for _, d := range details {
+			if branch != "" && d.Name != branch {
+				continue
+			}
+			branches = append(branches, listedBranch{
+				project:      p,
+				relativePath: relativePath,
+				BranchDetail: d,
+				merged:       merged[d.Name],
+				stale:        c.staleDays > 0 && now.Sub(d.CommitTime) > time.Duration(c.staleDays)*24*time.Hour,
+			})
+		}
+	}
+	if len(branches) == 0 {
+		if branch != "" {
+			fmt.Fprintf(jirix.Stdout(), "Cannot find any project with branch %q\n", branch)
+		}
+		return nil
+	}
+
+	rows := [][]string{{"PROJECT", "BRANCH", "UPSTREAM", "LAST COMMIT", "STATUS"}}
+	for _, b := range branches {
+		name := b.Name
+		if b.IsHead {
+			name = "*" + name
+		}
+		rows = append(rows, []string{b.relativePath, name, b.Upstream, formatAge(now.Sub(b.CommitTime)), b.status(jirix)})
+	}
+	// Colors are applied after padding, so that they don't count in the
+	// widths of the columns.
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		for i, cell := range row[:len(row)-1] {
+			widths[i] = max(widths[i], len(cell))
+		}
+	}
+	for r, row := range rows {
+		var line []string
+		for i, cell := range row {
+			if i < len(row)-1 {
+				cell = fmt.Sprintf("%-*s", widths[i], cell)
+			}
+			switch {
+			case r == 0:
+				cell = jirix.Color.Yellow("%s", cell)
+			case i == 1 && branches[r-1].IsHead:
+				cell = jirix.Color.Green("%s", cell)
+			}
+			line = append(line, cell)
+		}
+		fmt.Fprintln(jirix.Stdout(), strings.TrimRight(strings.Join(line, "  "), " "))
+	}
+
+	if c.pruneMerged {
+		return c.pruneMergedBranches(jirix, branches)
+	}
+	return nil
+}
+
+// pruneMergedBranches deletes the merged branches of branches, except the
+// current branches of projects.
+func (c *branchCmd) pruneMergedBranches(jirix *jiri.X, branches []listedBranch) error {
+	failed := false
+	for _, b := range branches {
+		if !b.merged {
+			continue
+		}
+		p := b.project
+		if !c.overrideProjectConfig && (p.LocalConfig.Ignore || p.LocalConfig.NoUpdate) {
+			jirix.Logger.Warningf("Project %s(%s): merged branch %q won't be deleted due to its local-config. Use '-override-pc' flag\n\n", p.Name, b.relativePath, b.Name)
+			continue
+		}
+		if b.IsHead {
+			jirix.Logger.Warningf("Project %s(%s): merged branch %q won't be deleted as it is the current branch\n\n", p.Name, b.relativePath, b.Name)
+			continue
+		}
+		scm := gitutil.New(jirix, gitutil.RootDirOpt(p.Path))
+		shortHash, err := scm.ShortHash(b.Revision)
+		if err != nil {
+			shortHash = b.Revision
+		}
+		// The branch is merged into JIRI_HEAD, but not necessarily into its
+		// upstream, which "git branch -d" requires.
+		if err := scm.DeleteBranch(b.Name, gitutil.ForceOpt(true)); err != nil {
+			failed = true
+			fmt.Fprintf(jirix.Stdout(), "Project %s(%s): %s", p.Name, b.relativePath, jirix.Color.Red("Error while deleting branch: %s\n", err))
+			continue
+		}
+		fmt.Fprintf(jirix.Stdout(), "Project %s(%s): %s (was %s)\n", p.Name, b.relativePath, jirix.Color.Green("Deleted Branch %s", b.Name), jirix.Color.Yellow(shortHash))
+	}
+	if failed {
+		return fmt.Errorf("some merged branches could not be deleted")
+	}
+	return nil
+}
+
+// formatAge returns d, the age of a commit, in days.
+func formatAge(d time.Duration) string {
+	switch days := int(d.Hours() / 24); days {
+	case 0:
+		return "today"
+	case 1:
+		return "1 day ago"
+	default:
+		return fmt.Sprintf("%d days ago", days)
+	}
+}
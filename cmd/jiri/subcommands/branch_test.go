@@ -9,6 +9,7 @@ import (
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
@@ -730,3 +731,95 @@ func executeBranch(t *testing.T, fake *jiritest.FakeJiriRoot, c branchCmd, args
 	}
 	return strings.TrimSpace(strings.Join([]string{stdout, stderr}, " "))
 }
+
+func TestBranchList(t *testing.T) {
+	t.Parallel()
+
+	localProjects, fake := setupUniverse(t)
+	remote := fake.Projects[localProjects[0].Name]
+	remoteGit := gitutil.New(fake.X, gitutil.RootDirOpt(remote))
+	if err := remoteGit.CreateBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	p := localProjects[0]
+	scm := gitutil.New(fake.X, gitutil.RootDirOpt(p.Path), gitutil.UserNameOpt("John Doe"), gitutil.UserEmailOpt("john.doe@example.com"))
+	for _, b := range []string{"merged", "ancient"} {
+		if err := scm.CreateBranch(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := scm.CreateBranchFromRef("feature", "origin/feature"); err != nil {
+		t.Fatal(err)
+	}
+	if err := scm.SetUpstream("feature", "origin/feature"); err != nil {
+		t.Fatal(err)
+	}
+	if err := scm.Checkout("ancient"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(p.Path, "ancient"), []byte("ancient"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := gitutil.New(fake.X, gitutil.RootDirOpt(p.Path), gitutil.UserNameOpt("John Doe"), gitutil.UserEmailOpt("john.doe@example.com"),
+		gitutil.CommitterDateOpt("2001-01-01T00:00:00"), gitutil.AuthorDateOpt("2001-01-01T00:00:00"))
+	if err := old.CommitFile("ancient", "ancient work"); err != nil {
+		t.Fatal(err)
+	}
+	if err := scm.Checkout("feature"); err != nil {
+		t.Fatal(err)
+	}
+	// Move JIRI_HEAD past the "merged" branch, and remove the upstream of
+	// "feature".
+	writeReadme(t, fake.X, remote, "new readme")
+	if err := remoteGit.DeleteBranch("feature", gitutil.ForceOpt(true)); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := scm.CreateBranchFromRef("fresh", "JIRI_HEAD"); err != nil {
+		t.Fatal(err)
+	}
+
+	fake.X.Cwd = fake.X.Root
+	cmd := branchCmd{list: true, staleDays: 30}
+	stdout, _, err := collectStdio(fake.X, nil, cmd.run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(stdout), "\n")[1:] {
+		fields := strings.Fields(line)
+		if fields[0] != "path-0" {
+			if fields[len(fields)-1] == "merged" {
+				t.Errorf("branch tracking its remote branch flagged as merged: %q", line)
+			}
+			continue
+		}
+		last := fields[len(fields)-1]
+		if last == "ago" || last == "today" {
+			last = ""
+		}
+		status[strings.TrimPrefix(fields[1], "*")] = last
+	}
+	want := map[string]string{"ancient": "stale", "feature": "gone,merged", "fresh": "", "merged": "merged"}
+	if diff := cmp.Diff(want, status); diff != "" {
+		t.Errorf("Wrong branch status (-want +got):\n%s\noutput:\n%s", diff, stdout)
+	}
+
+	cmd.pruneMerged = true
+	if _, _, err := collectStdio(fake.X, nil, cmd.run); err != nil {
+		t.Fatal(err)
+	}
+	branches, _, err := scm.GetBranches()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(branches)
+	if want := []string{"ancient", "feature", "fresh"}; !cmp.Equal(branches, want) {
+		t.Errorf("got branches %q after -prune-merged, want %q", branches, want)
+	}
+}
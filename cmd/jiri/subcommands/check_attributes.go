@@ -0,0 +1,117 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/google/subcommands"
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/project"
+)
+
+type checkAttributesCmd struct {
+	cmdBase
+
+	fix bool
+}
+
+func (c *checkAttributesCmd) Name() string { return "check-attributes" }
+func (c *checkAttributesCmd) Synopsis() string {
+	return "Check the files generated from the git attributes of projects"
+}
+func (c *checkAttributesCmd) Usage() string {
+	return `Checks that the git attributes of the projects of the manifest are valid
+.gitattributes attribute names, that every code owners rule of the jiri config
+matches some project, and that the .gitattributes and CODEOWNERS files that
+"jiri update" generates from them, if configured with "jiri init
+-gitattributes-file" and "jiri init -codeowners-file", are up to date.
+
+Exits non-zero and prints the problems found, if any.
+
+Usage:
+  jiri check-attributes [flags]
+`
+}
+
+func (c *checkAttributesCmd) SetFlags(f *flag.FlagSet) {
+	f.BoolVar(&c.fix, "fix", false, "Rewrite the generated files that are missing or out of date.")
+}
+
+func (c *checkAttributesCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	return executeWrapper(ctx, c.run, c.topLevelFlags, f.Args())
+}
+
+func (c *checkAttributesCmd) run(jirix *jiri.X, args []string) error {
+	if len(args) != 0 {
+		return jirix.UsageErrorf("unexpected arguments")
+	}
+	localProjects, err := project.LocalProjects(jirix, project.FastScan)
+	if err != nil {
+		return err
+	}
+	projects, _, _, err := project.LoadManifestFile(jirix, jirix.JiriManifestFile(), localProjects, nil)
+	if err != nil {
+		return err
+	}
+	// Only the projects that "jiri update" fetches are in the generated
+	// files.
+	if err := project.FilterOptionalProjectsPackages(jirix, jirix.FetchingAttrs, projects, nil); err != nil {
+		return err
+	}
+	project.FilterProjectsPackagesByGroup(jirix, projects, nil)
+
+	problems := project.CheckGitAttributes(jirix, projects)
+	stale := false
+	files := project.GeneratedAttributeFiles(jirix, projects)
+	var paths []string
+	for file := range files {
+		paths = append(paths, file)
+	}
+	sort.Strings(paths)
+	for _, file := range paths {
+		rel, err := filepath.Rel(jirix.Root, file)
+		if err != nil {
+			return err
+		}
+		old, err := os.ReadFile(file)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if err == nil && bytes.Equal(old, files[file]) {
+			continue
+		}
+		if c.fix {
+			if err := project.SafeWriteFile(jirix, file, files[file]); err != nil {
+				return err
+			}
+			fmt.Fprintf(jirix.Stdout(), "Updated %s\n", rel)
+			continue
+		}
+		stale = true
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s is missing", rel))
+		} else {
+			problems = append(problems, fmt.Sprintf("%s is out of date", rel))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	for _, p := range problems {
+		fmt.Fprintln(jirix.Stdout(), p)
+	}
+	if stale {
+		fmt.Fprintln(jirix.Stdout(), "Run \"jiri check-attributes -fix\" to rewrite the generated files.")
+	}
+	return fmt.Errorf("found %d problems with git attributes", len(problems))
+}
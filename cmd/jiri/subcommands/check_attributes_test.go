@@ -0,0 +1,51 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckAttributes(t *testing.T) {
+	_, fake := setupUniverse(t)
+	fake.X.GitAttributesFile = ".gitattributes"
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(fake.X.Root, ".gitattributes")
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf(".gitattributes should be generated on update: %v", err)
+	}
+	if !strings.Contains(string(data), "manifest") {
+		t.Errorf("expected the attributes of the manifest in .gitattributes, got:\n%s", data)
+	}
+
+	cmd := checkAttributesCmd{}
+	if stdout, _, err := collectStdio(fake.X, nil, cmd.run); err != nil {
+		t.Fatalf("check-attributes should pass after update: %v\n%s", err, stdout)
+	}
+
+	if err := os.WriteFile(file, []byte("edited\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stdout, _, err := collectStdio(fake.X, nil, cmd.run)
+	if err == nil || !strings.Contains(stdout, ".gitattributes is out of date") {
+		t.Fatalf("check-attributes should report the edited file, got %v:\n%s", err, stdout)
+	}
+
+	cmd.fix = true
+	if _, _, err := collectStdio(fake.X, nil, cmd.run); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(file); err != nil {
+		t.Fatal(err)
+	} else if string(got) != string(data) {
+		t.Errorf("-fix wrote:\n%s\nwant:\n%s", got, data)
+	}
+}
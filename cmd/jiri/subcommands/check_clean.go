@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"path/filepath"
 	"sort"
+	"strings"
 
 	"github.com/google/subcommands"
 	"go.fuchsia.dev/jiri"
@@ -19,54 +20,108 @@ import (
 
 type checkCleanCmd struct {
 	cmdBase
+
+	untracked  bool
+	checkHead  bool
+	commits    bool
+	projects   arrayFlag
+	jsonOutput string
 }
 
 func (c *checkCleanCmd) Name() string     { return "check-clean" }
 func (c *checkCleanCmd) Synopsis() string { return "Checks if the checkout is clean" }
 func (c *checkCleanCmd) Usage() string {
 	return `Exits non-zero and prints repositories (and their status) if they contain
-uncommitted changes.
+uncommitted changes or untracked files, are not on JIRI_HEAD, or have local
+commits that are not on their upstream branch. Each of these checks except the
+first can be turned off with a flag.
+
+This is meant as a gate for CI, to verify that a checkout matches the manifest
+before or after a build.
 
 Usage:
-  jiri check-clean
+  jiri check-clean [flags]
 `
 }
 
-func (c *checkCleanCmd) SetFlags(f *flag.FlagSet) {}
+func (c *checkCleanCmd) SetFlags(f *flag.FlagSet) {
+	f.BoolVar(&c.untracked, "untracked", true, "Consider projects with untracked files as not clean.")
+	f.BoolVar(&c.checkHead, "check-head", true, "Consider projects that are not on JIRI_HEAD as not clean.")
+	f.BoolVar(&c.commits, "commits", true, "Consider projects whose current branch has commits not merged to its upstream as not clean.")
+	f.Var(&c.projects, "project", "Only check this project, given by name or path relative to the root. Repeatable.")
+	f.StringVar(&c.jsonOutput, "json-output", "", "File to write the projects that are not clean to, in json format.")
+}
 
 func (c *checkCleanCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
 	return executeWrapper(ctx, c.run, c.topLevelFlags, f.Args())
 }
 
+// dirtyProject describes why a project is not clean.
+type dirtyProject struct {
+	Name         string   `json:"name"`
+	Path         string   `json:"path"`
+	Changes      []string `json:"changes,omitempty"`
+	Revision     string   `json:"revision,omitempty"`
+	JiriHead     string   `json:"jiri_head,omitempty"`
+	ExtraCommits []string `json:"extra_commits,omitempty"`
+}
+
 func (c *checkCleanCmd) run(jirix *jiri.X, args []string) error {
+	if len(args) != 0 {
+		return jirix.UsageErrorf("unexpected arguments")
+	}
 	localProjects, err := project.LocalProjects(jirix, project.FastScan)
 	if err != nil {
 		return err
 	}
+	if len(c.projects) != 0 {
+		if localProjects, err = c.filterProjects(jirix, localProjects); err != nil {
+			return err
+		}
+	}
+	var states map[project.ProjectKey]*project.ProjectState
+	if c.commits {
+		if states, err = project.GetProjectStates(jirix, localProjects, false); err != nil {
+			return err
+		}
+	}
 	cDir := jirix.Cwd
 	var keys project.ProjectKeys
 	for key := range localProjects {
 		keys = append(keys, key)
 	}
 	sort.Sort(keys)
-	dirtyProjects := make(map[string]string)
+	var dirtyProjects []dirtyProject
 	for _, key := range keys {
 		localProject := localProjects[key]
 		relativePath, err := filepath.Rel(cDir, localProject.Path)
 		if err != nil {
 			return err
 		}
-		scm := gitutil.New(jirix, gitutil.RootDirOpt(localProject.Path))
-		changes, err := scm.ShortStatus()
+		var state *project.ProjectState
+		if states != nil {
+			state = states[key]
+		}
+		d, err := c.checkProject(jirix, localProject, state)
 		if err != nil {
 			jirix.Logger.Errorf("%s :%s\n\n", fmt.Sprintf("getting changes for project %s(%s)", localProject.Name, relativePath), err)
 			jirix.IncrementFailures()
 			continue
 		}
-		if changes != "" {
-			dirtyProjects[relativePath] = changes
+		if d != nil {
+			d.Path = relativePath
+			dirtyProjects = append(dirtyProjects, *d)
 		}
 	}
+	if c.jsonOutput != "" {
+		if dirtyProjects == nil {
+			dirtyProjects = []dirtyProject{}
+		}
+		if err := writeJSONOutput(c.jsonOutput, dirtyProjects); err != nil {
+			return err
+		}
+	}
+
 	var finalErr error
 	if jirix.Failures() != 0 {
 		finalErr = fmt.Errorf("completed with non-fatal errors")
@@ -76,10 +131,91 @@ func (c *checkCleanCmd) run(jirix *jiri.X, args []string) error {
 
 	if len(dirtyProjects) > 0 {
 		fmt.Fprintln(jirix.Stdout(), "Dirty projects:")
-		for relativePath, changes := range dirtyProjects {
-			fmt.Fprintf(jirix.Stdout(), "%s\n%s\n\n", relativePath, changes)
+		for _, d := range dirtyProjects {
+			fmt.Fprintln(jirix.Stdout(), d.Path)
+			for _, change := range d.Changes {
+				fmt.Fprintln(jirix.Stdout(), change)
+			}
+			if d.JiriHead != "" {
+				fmt.Fprintf(jirix.Stdout(), "Not on JIRI_HEAD: at %s, JIRI_HEAD is %s\n", d.Revision, d.JiriHead)
+			}
+			if len(d.ExtraCommits) != 0 {
+				fmt.Fprintf(jirix.Stdout(), "%d commit(s) not merged to remote:\n", len(d.ExtraCommits))
+				for _, commit := range d.ExtraCommits {
+					fmt.Fprintln(jirix.Stdout(), commit)
+				}
+			}
+			fmt.Fprintln(jirix.Stdout())
 		}
 	}
 
 	return finalErr
 }
+
+// filterProjects returns the projects selected by the -project flags.
+func (c *checkCleanCmd) filterProjects(jirix *jiri.X, projects project.Projects) (project.Projects, error) {
+	filtered := make(project.Projects)
+	for _, name := range c.projects {
+		found := false
+		for key, p := range projects {
+			if rel, err := filepath.Rel(jirix.Root, p.Path); p.Name == name || (err == nil && rel == filepath.Clean(name)) {
+				filtered[key] = p
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("project %q not found", name)
+		}
+	}
+	return filtered, nil
+}
+
+// checkProject returns why p is not clean, or nil if it is.
+func (c *checkCleanCmd) checkProject(jirix *jiri.X, p project.Project, state *project.ProjectState) (*dirtyProject, error) {
+	d := &dirtyProject{Name: p.Name}
+	scm := gitutil.New(jirix, gitutil.RootDirOpt(p.Path))
+	changes, err := scm.ShortStatus()
+	if err != nil {
+		return nil, err
+	}
+	for _, change := range strings.Split(changes, "\n") {
+		if change == "" || (!c.untracked && strings.HasPrefix(change, "??")) {
+			continue
+		}
+		d.Changes = append(d.Changes, change)
+	}
+	if c.checkHead {
+		// Projects without JIRI_HEAD were never updated by jiri, there is
+		// nothing to compare against.
+		if head, err := scm.CurrentRevisionForRef("JIRI_HEAD"); err == nil {
+			rev, err := scm.CurrentRevision()
+			if err != nil {
+				return nil, err
+			}
+			if rev != head {
+				d.Revision, d.JiriHead = rev, head
+			}
+		}
+	}
+	if state != nil && state.CurrentBranch.Name != "" {
+		remoteBranch := strings.TrimPrefix(p.RemoteBranchRef(), "refs/")
+		if state.CurrentBranch.Tracking != nil {
+			remoteBranch = state.CurrentBranch.Tracking.Name
+		}
+		commits, err := scm.ExtraCommits(state.CurrentBranch.Name, remoteBranch)
+		if err != nil {
+			return nil, err
+		}
+		for _, commit := range commits {
+			log, err := scm.OneLineLog(commit)
+			if err != nil {
+				return nil, err
+			}
+			d.ExtraCommits = append(d.ExtraCommits, log)
+		}
+	}
+	if len(d.Changes) == 0 && d.JiriHead == "" && len(d.ExtraCommits) == 0 {
+		return nil, nil
+	}
+	return d, nil
+}
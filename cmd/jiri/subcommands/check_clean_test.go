@@ -0,0 +1,74 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.fuchsia.dev/jiri/gitutil"
+)
+
+func TestCheckClean(t *testing.T) {
+	localProjects, fake := setupUniverse(t)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	fake.X.Cwd = fake.X.Root
+	cmd := checkCleanCmd{untracked: true, checkHead: true, commits: true}
+	if _, _, err := collectStdio(fake.X, nil, cmd.run); err != nil {
+		t.Fatalf("fresh checkout should be clean: %v", err)
+	}
+
+	untracked := localProjects[0]
+	if err := os.WriteFile(filepath.Join(untracked.Path, "untracked"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	branched := localProjects[1]
+	scm := gitutil.New(fake.X, gitutil.RootDirOpt(branched.Path), gitutil.UserNameOpt("John Doe"), gitutil.UserEmailOpt("john.doe@example.com"))
+	if err := scm.CreateAndCheckoutBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	writeReadme(t, fake.X, branched.Path, "local change")
+
+	jsonFile := filepath.Join(t.TempDir(), "out.json")
+	cmd.jsonOutput = jsonFile
+	stdout, _, err := collectStdio(fake.X, nil, cmd.run)
+	if err == nil {
+		t.Fatalf("check-clean should fail, output:\n%s", stdout)
+	}
+	for _, want := range []string{"?? untracked", "Not on JIRI_HEAD", "1 commit(s) not merged to remote"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("expected %q in output, got:\n%s", want, stdout)
+		}
+	}
+	data, err := os.ReadFile(jsonFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dirty []dirtyProject
+	if err := json.Unmarshal(data, &dirty); err != nil {
+		t.Fatal(err)
+	}
+	if len(dirty) != 2 || dirty[0].Name != untracked.Name || dirty[1].Name != branched.Name {
+		t.Errorf("got json output %+v, want projects %s and %s", dirty, untracked.Name, branched.Name)
+	}
+
+	cmd = checkCleanCmd{untracked: false, checkHead: true, commits: true, projects: arrayFlag{untracked.Name}}
+	if _, _, err := collectStdio(fake.X, nil, cmd.run); err != nil {
+		t.Errorf("untracked files should be ignored with -untracked=false: %v", err)
+	}
+	cmd = checkCleanCmd{untracked: true, projects: arrayFlag{"path-2"}}
+	if _, _, err := collectStdio(fake.X, nil, cmd.run); err != nil {
+		t.Errorf("path-2 should be clean: %v", err)
+	}
+	cmd = checkCleanCmd{projects: arrayFlag{"missing"}}
+	if _, _, err := collectStdio(fake.X, nil, cmd.run); err == nil {
+		t.Errorf("an unknown -project should fail")
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/google/subcommands"
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/project"
+)
+
+type cipdCmd struct {
+	cmdBase
+
+	packagesToSkip        arrayFlag
+	localManifestProjects arrayFlag
+	groupFlags
+	attrFlags
+}
+
+func (c *cipdCmd) Name() string     { return "cipd" }
+func (c *cipdCmd) Synopsis() string { return "Export the cipd ensure files of the packages" }
+func (c *cipdCmd) Usage() string {
+	return `"jiri cipd ensure-file export <file>" writes the cipd ensure and version
+files that "jiri fetch-packages" would use, without fetching anything, so that
+build systems can run cipd themselves with the packages resolved by jiri: the
+packages of the manifest selected by the optional attributes and groups of the
+root, or -attrs and -group, with the instances locked in jiri.lock for every
+platform of each package.
+
+Like "jiri snapshot -cipd", it writes <file>.ensure and <file>.version with the
+public packages, and <file>_internal.ensure and <file>_internal.version with
+the public and internal packages, for the users who can access the internal
+ones. The ensure files refer to their version file by name, so the four files
+must be kept together. Deploy them with e.g.
+  cipd ensure -ensure-file <file>.ensure -root <dir>
+
+Usage:
+  jiri cipd [flags] ensure-file export <file>
+`
+}
+
+func (c *cipdCmd) SetFlags(f *flag.FlagSet) {
+	f.Var(&c.packagesToSkip, "package-to-skip", "Leave this package out. Repeatable.")
+	f.Var(&c.localManifestProjects, "local-manifest-project", "Import projects whose local manifests should be respected. Repeatable.")
+	c.groupFlags.setFlags(f)
+	c.attrFlags.setFlags(f)
+}
+
+func (c *cipdCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	return executeWrapper(ctx, c.run, c.topLevelFlags, f.Args())
+}
+
+func (c *cipdCmd) run(jirix *jiri.X, args []string) error {
+	if len(args) < 2 || args[0] != "ensure-file" || args[1] != "export" {
+		return jirix.UsageErrorf("unknown command, expected \"ensure-file export <file>\"")
+	}
+	if len(args) != 3 {
+		return jirix.UsageErrorf("ensure-file export takes exactly one file")
+	}
+	c.groupFlags.apply(jirix)
+	c.attrFlags.apply(jirix)
+	localProjects, err := project.LocalProjects(jirix, project.FastScan)
+	if err != nil {
+		return err
+	}
+	projects, _, pkgs, err := project.LoadManifestFile(jirix, jirix.JiriManifestFile(), localProjects, c.localManifestProjects)
+	if err != nil {
+		return err
+	}
+	if err := project.FilterOptionalProjectsPackages(jirix, jirix.FetchingAttrs, nil, pkgs); err != nil {
+		return err
+	}
+	project.FilterProjectsPackagesByGroup(jirix, projects, pkgs)
+	project.FilterPackagesByName(jirix, pkgs, c.packagesToSkip)
+	if err := project.WriteCipdEnsureFiles(jirix, pkgs, args[2]); err != nil {
+		return err
+	}
+	fmt.Fprintf(jirix.Stdout(), "Wrote the ensure files of %d packages to %s.ensure and %s_internal.ensure\n", len(pkgs), args[2], args[2])
+	return nil
+}
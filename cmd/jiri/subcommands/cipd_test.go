@@ -0,0 +1,84 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.fuchsia.dev/jiri/jiritest"
+	"go.fuchsia.dev/jiri/project"
+)
+
+func TestCipdEnsureFileExport(t *testing.T) {
+	t.Parallel()
+
+	fake := jiritest.NewFakeJiriRoot(t)
+	for _, pkg := range []project.Package{
+		{Name: "public_package", Path: "public", Version: "version:1"},
+		{Name: "internal_package", Path: "internal", Version: "version:2", Internal: true},
+		{Name: "optional_package", Path: "optional", Version: "version:3", Attributes: "extra"},
+		{Name: "skipped_package", Path: "skipped", Version: "version:4"},
+	} {
+		if err := fake.AddPackage(pkg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Update the manifest without fetching the packages.
+	if err := project.UpdateUniverse(fake.X, project.UpdateUniverseParams{
+		RunHookTimeout:       project.DefaultHookTimeout,
+		FetchPackagesTimeout: project.DefaultPackageTimeout,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(t.TempDir(), "packages")
+	cmd := cipdCmd{packagesToSkip: arrayFlag{"skipped_package"}}
+	if _, _, err := collectStdio(fake.X, []string{"ensure-file", "export", file}, cmd.run); err != nil {
+		t.Fatal(err)
+	}
+	read := func(name string) string {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	public, internal := read(file+".ensure"), read(file+"_internal.ensure")
+	for _, f := range []string{file + ".version", file + "_internal.version"} {
+		if _, err := os.Stat(f); err != nil {
+			t.Error(err)
+		}
+	}
+	if !strings.Contains(public, "$ResolvedVersions packages.version") || !strings.Contains(internal, "$ResolvedVersions packages_internal.version") {
+		t.Errorf("ensure files do not refer to their version files:\n%s\n%s", public, internal)
+	}
+	if !strings.Contains(public, "public_package version:1") || strings.Contains(public, "internal_package") {
+		t.Errorf("unexpected public ensure file:\n%s", public)
+	}
+	if !strings.Contains(internal, "public_package version:1") || !strings.Contains(internal, "internal_package version:2") {
+		t.Errorf("unexpected internal ensure file:\n%s", internal)
+	}
+	for _, name := range []string{"optional_package", "skipped_package"} {
+		if strings.Contains(internal, name) {
+			t.Errorf("%s should not be in the ensure file:\n%s", name, internal)
+		}
+	}
+
+	// -attrs selects the optional packages.
+	cmd = cipdCmd{attrFlags: attrFlags{attrs: "extra", attrsSet: true}}
+	if _, _, err := collectStdio(fake.X, []string{"ensure-file", "export", file}, cmd.run); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(file + ".ensure"); !strings.Contains(got, "optional_package version:3") {
+		t.Errorf("optional package is missing with -attrs:\n%s", got)
+	}
+
+	if _, _, err := collectStdio(fake.X, []string{"ensure-file", file}, cmd.run); err == nil {
+		t.Errorf("expected a missing export to fail")
+	}
+}
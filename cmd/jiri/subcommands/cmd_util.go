@@ -36,6 +36,18 @@ func currentProject(jirix *jiri.X) (project.Project, error) {
 	return project.Project{}, fmt.Errorf("directory %q is not contained in a project", dir)
 }
 
+// addSubmoduleRemotes adds the submodules of projects, see
+// project.WithSubmodules, to remoteProjects. They are not in the manifest,
+// and are their own remote projects, at the revision recorded in their
+// superproject.
+func addSubmoduleRemotes(projects, remoteProjects project.Projects) {
+	for key, p := range projects {
+		if p.Superproject != "" {
+			remoteProjects[key] = p
+		}
+	}
+}
+
 // getDefaultLocalManifestProjects essentially converts the boolean `-local-manifest=true`
 // flag to the repeated `-local-manifest-project` flag. The default is to only include
 // the root manifest project.
@@ -26,6 +26,8 @@ type diffCmd struct {
 
 	cls          bool
 	indentOutput bool
+	patch        bool
+	committed    bool
 
 	// Need this to avoid infinite loop
 	maxCls uint
@@ -34,9 +36,9 @@ type diffCmd struct {
 func (c *diffCmd) Name() string     { return "diff" }
 func (c *diffCmd) Synopsis() string { return "Prints diff between two snapshots" }
 func (c *diffCmd) Usage() string {
-	return `Prints diff between two snapshots in json format. Max CLs returned for a
-project is controlled by flag max-xls and is default by 5. The format of
-returned json:
+	return `Prints diff between two snapshots in json format. Max CLs and commits
+returned for a project is controlled by flag max-cls and is default by 5. The
+format of returned json:
 {
 	new_projects: [
 		{
@@ -62,10 +64,18 @@ returned json:
 			path: path,
 			relative_path: relative-path,
 			remote: remote,
+			old_remote: old-remote, // if the remote changed
 			revision: rev
 			old_revision: old-rev, // if updated
 			old_path: old-path //if moved
 			old_relative_path: old-relative-path //if moved
+			commits:[ // if the project is checked out with both revisions
+				{
+					revision: commit,
+					author: author,
+					subject: sub
+				},{...},...
+			]
 			cls:[
 				{
 					number: num,
@@ -80,8 +90,17 @@ returned json:
 	]
 }
 
+With -patch, jiri diff instead prints the uncommitted changes of all
+projects as a single patch, whose paths are relative to the root, e.g. to
+review changes spanning several projects. With -committed, the patch has the
+commits of each project since the revision checked out by the last "jiri
+update" instead. Untracked files are not part of the patch. It can be applied
+to another root with "git apply" run in that root, or with
+"git apply --directory=<root>" elsewhere.
+
 Usage:
   jiri diff [flags] <snapshot-1> <snapshot-2>
+  jiri diff -patch [-committed]
 
 <snapshot-1/2> are files or urls containing snapshot.
 `
@@ -90,7 +109,9 @@ Usage:
 func (c *diffCmd) SetFlags(f *flag.FlagSet) {
 	f.BoolVar(&c.cls, "cls", true, "Return CLs for changed projects")
 	f.BoolVar(&c.indentOutput, "indent", true, "Indent json output")
-	f.UintVar(&c.maxCls, "max-cls", 5, "Max number of CLs returned per changed project")
+	f.UintVar(&c.maxCls, "max-cls", 5, "Max number of CLs and commits returned per changed project")
+	f.BoolVar(&c.patch, "patch", false, "Print the uncommitted changes of all projects as a single patch instead.")
+	f.BoolVar(&c.committed, "committed", false, "With -patch, print the commits since the last update instead of the uncommitted changes.")
 }
 
 func (c *diffCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
@@ -98,6 +119,12 @@ func (c *diffCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcom
 }
 
 func (c *diffCmd) run(jirix *jiri.X, args []string) error {
+	if c.committed && !c.patch {
+		return jirix.UsageErrorf("-committed requires -patch")
+	}
+	if c.patch {
+		return c.runPatch(jirix, args)
+	}
 	if len(args) != 2 {
 		return jirix.UsageErrorf("Please provide two snapshots to diff")
 	}
@@ -113,11 +140,6 @@ func (c *diffCmd) run(jirix *jiri.X, args []string) error {
 }
 
 func (c *diffCmd) getDiff(jirix *jiri.X, snapshot1, snapshot2 string) (*Diff, error) {
-	diff := &Diff{
-		NewProjects:     make([]DiffProject, 0),
-		DeletedProjects: make([]DiffProject, 0),
-		UpdatedProjects: make([]DiffProject, 0),
-	}
 	oldLogger := jirix.Logger
 	defer func() {
 		jirix.Logger = oldLogger
@@ -131,56 +153,57 @@ func (c *diffCmd) getDiff(jirix *jiri.X, snapshot1, snapshot2 string) (*Diff, er
 	if err != nil {
 		return nil, err
 	}
-	project.MatchLocalWithRemote(projects1, projects2)
 	jirix.Logger = oldLogger
+	return c.diffProjects(jirix, projects1, projects2)
+}
 
-	// Get deleted projects
-	for key, p1 := range projects1 {
-		if _, ok := projects2[key]; !ok {
-			rp, err := filepath.Rel(jirix.Root, p1.Path)
-			if err != nil {
-				// should not happen
-				panic(err)
-			}
-			diff.DeletedProjects = append(diff.DeletedProjects, DiffProject{
-				Name:         p1.Name,
-				Remote:       p1.Remote,
-				Path:         p1.Path,
-				RelativePath: rp,
-				Revision:     p1.Revision,
-			})
+// diffProjects returns the diff between two sets of projects.
+func (c *diffCmd) diffProjects(jirix *jiri.X, projects1, projects2 project.Projects) (*Diff, error) {
+	diff := &Diff{
+		NewProjects:     make([]DiffProject, 0),
+		DeletedProjects: make([]DiffProject, 0),
+		UpdatedProjects: make([]DiffProject, 0),
+	}
+	changes := project.DiffStates(projects1, projects2)
+	for _, change := range changes.Removed {
+		p1 := change.Old
+		rp, err := filepath.Rel(jirix.Root, p1.Path)
+		if err != nil {
+			// should not happen
+			panic(err)
 		}
+		diff.DeletedProjects = append(diff.DeletedProjects, DiffProject{
+			Name:         p1.Name,
+			Remote:       p1.Remote,
+			Path:         p1.Path,
+			RelativePath: rp,
+			Revision:     p1.Revision,
+		})
 	}
-
-	// Get new projects and also extract updated projects
-	updatedProjectKeys := make(chan project.ProjectKey, len(projects2))
-	for key, p2 := range projects2 {
-		if p1, ok := projects1[key]; !ok {
-			rp, err := filepath.Rel(jirix.Root, p2.Path)
-			if err != nil {
-				// should not happen
-				panic(err)
-			}
-
-			diff.NewProjects = append(diff.NewProjects, DiffProject{
-				Name:         p2.Name,
-				Remote:       p2.Remote,
-				Path:         p2.Path,
-				RelativePath: rp,
-				Revision:     p2.Revision,
-			})
-		} else {
-			if p1.Path != p2.Path || p1.Revision != p2.Revision {
-				updatedProjectKeys <- key
-			}
+	for _, change := range changes.Added {
+		p2 := change.New
+		rp, err := filepath.Rel(jirix.Root, p2.Path)
+		if err != nil {
+			// should not happen
+			panic(err)
 		}
+		diff.NewProjects = append(diff.NewProjects, DiffProject{
+			Name:         p2.Name,
+			Remote:       p2.Remote,
+			Path:         p2.Path,
+			RelativePath: rp,
+			Revision:     p2.Revision,
+		})
 	}
 
-	close(updatedProjectKeys)
+	updatedProjects := make(chan project.Change, len(changes.Updated))
+	for _, change := range changes.Updated {
+		updatedProjects <- change
+	}
+	close(updatedProjects)
 
-	processUpdatedProject := func(key project.ProjectKey) DiffProject {
-		p1 := projects1[key]
-		p2 := projects2[key]
+	processUpdatedProject := func(change project.Change) DiffProject {
+		p1, p2 := change.Old, change.New
 		rp, err := filepath.Rel(jirix.Root, p2.Path)
 		if err != nil {
 			// should not happen
@@ -193,7 +216,10 @@ func (c *diffCmd) getDiff(jirix *jiri.X, snapshot1, snapshot2 string) (*Diff, er
 			RelativePath: rp,
 			Revision:     p2.Revision,
 		}
-		if p1.Path != p2.Path {
+		if change.RemoteChanged() {
+			diffP.OldRemote = p1.Remote
+		}
+		if change.Moved() {
 			rp, err := filepath.Rel(jirix.Root, p1.Path)
 			if err != nil {
 				// should not happen
@@ -202,8 +228,13 @@ func (c *diffCmd) getDiff(jirix *jiri.X, snapshot1, snapshot2 string) (*Diff, er
 			diffP.OldPath = p1.Path
 			diffP.OldRelativePath = rp
 		}
-		if p1.Revision != p2.Revision {
+		if change.RevisionChanged() {
 			diffP.OldRevision = p1.Revision
+			// The commits are only known if the project is checked out
+			// with both revisions.
+			if commits, err := change.Commits(jirix, int(c.maxCls)); err == nil {
+				diffP.Commits = commits
+			}
 			if !c.cls {
 				// do nothing, prevents nested if/else
 			} else if p2.GerritHost == "" {
@@ -257,14 +288,14 @@ func (c *diffCmd) getDiff(jirix *jiri.X, snapshot1, snapshot2 string) (*Diff, er
 		return diffP
 	}
 
-	diffs := make(chan DiffProject, len(updatedProjectKeys))
+	diffs := make(chan DiffProject, len(changes.Updated))
 	var wg sync.WaitGroup
 	for i := uint(0); i < jirix.Jobs; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			for key := range updatedProjectKeys {
-				diffs <- processUpdatedProject(key)
+			for change := range updatedProjects {
+				diffs <- processUpdatedProject(change)
 			}
 		}()
 	}
@@ -284,17 +315,19 @@ type DiffCl struct {
 }
 
 type DiffProject struct {
-	Name            string   `json:"name"`
-	Remote          string   `json:"remote"`
-	Path            string   `json:"path"`
-	RelativePath    string   `json:"relative_path"`
-	OldPath         string   `json:"old_path,omitempty"`
-	OldRelativePath string   `json:"old_relative_path,omitempty"`
-	Revision        string   `json:"revision"`
-	OldRevision     string   `json:"old_revision,omitempty"`
-	Cls             []DiffCl `json:"cls,omitempty"`
-	Error           string   `json:"error,omitempty"`
-	HasMoreCls      bool     `json:"has_more_cls,omitempty"`
+	Name            string                 `json:"name"`
+	Remote          string                 `json:"remote"`
+	OldRemote       string                 `json:"old_remote,omitempty"`
+	Path            string                 `json:"path"`
+	RelativePath    string                 `json:"relative_path"`
+	OldPath         string                 `json:"old_path,omitempty"`
+	OldRelativePath string                 `json:"old_relative_path,omitempty"`
+	Revision        string                 `json:"revision"`
+	OldRevision     string                 `json:"old_revision,omitempty"`
+	Commits         []project.ChangeCommit `json:"commits,omitempty"`
+	Cls             []DiffCl               `json:"cls,omitempty"`
+	Error           string                 `json:"error,omitempty"`
+	HasMoreCls      bool                   `json:"has_more_cls,omitempty"`
 }
 
 type DiffProjectsByName []DiffProject
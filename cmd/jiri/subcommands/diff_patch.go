@@ -0,0 +1,63 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/gitutil"
+	"go.fuchsia.dev/jiri/project"
+)
+
+// runPatch prints the changes of all projects as a single patch, whose paths
+// are relative to the root: the uncommitted changes, or with -committed the
+// commits since JIRI_HEAD, the revision checked out by the last update.
+func (c *diffCmd) runPatch(jirix *jiri.X, args []string) error {
+	if len(args) != 0 {
+		return jirix.UsageErrorf("-patch takes no arguments")
+	}
+	localProjects, err := project.LocalProjects(jirix, project.FastScan)
+	if err != nil {
+		return err
+	}
+	var projects []project.Project
+	for _, p := range localProjects {
+		projects = append(projects, p)
+	}
+	sort.Sort(project.ProjectsByPath(projects))
+	for _, p := range projects {
+		rel, err := filepath.Rel(jirix.Root, p.Path)
+		if err != nil {
+			return err
+		}
+		scm := gitutil.New(jirix, gitutil.RootDirOpt(p.Path))
+		base, head := "HEAD", ""
+		if c.committed {
+			if _, err := scm.CurrentRevisionForRef("JIRI_HEAD"); err != nil {
+				jirix.Logger.Warningf("Skipping project %s(%s): it was never updated by jiri\n\n", p.Name, rel)
+				continue
+			}
+			base, head = "JIRI_HEAD", "HEAD"
+		}
+		patch, err := scm.Patch(patchPrefix(rel), base, head)
+		if err != nil {
+			return fmt.Errorf("diffing project %s(%s): %v", p.Name, rel, err)
+		}
+		fmt.Fprint(jirix.Stdout(), patch)
+	}
+	return nil
+}
+
+// patchPrefix returns the prefix of the paths of the patch of the project at
+// rel, relative to the root, so that the patch applies from the root.
+func patchPrefix(rel string) string {
+	if rel == "." {
+		return ""
+	}
+	return filepath.ToSlash(rel) + "/"
+}
@@ -10,7 +10,10 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -183,3 +186,91 @@ func TestDiffSnapshotsURL(t *testing.T) {
 		t.Fatalf("Wrong diff (-want +got):\n%s", d)
 	}
 }
+
+func TestDiffPatch(t *testing.T) {
+	localProjects, fake := setupUniverse(t)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(localProjects[1].Path, "README"), []byte("uncommitted"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeReadme(t, fake.X, localProjects[2].Path, "committed")
+
+	uncommitted := "diff --git a/path-1/README b/path-1/README\n"
+	committed := "diff --git a/path-2/README b/path-2/README\n"
+	cmd := diffCmd{patch: true}
+	stdout, _, err := collectStdio(fake.X, nil, cmd.run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout, uncommitted) || strings.Contains(stdout, committed) {
+		t.Errorf("got patch:\n%s\nwant the uncommitted changes of path-1 only", stdout)
+	}
+	// The patch applies from the root: reverting it must succeed.
+	apply := exec.Command("git", "apply", "-R", "--check")
+	apply.Dir = fake.X.Root
+	apply.Stdin = strings.NewReader(stdout)
+	if out, err := apply.CombinedOutput(); err != nil {
+		t.Errorf("git apply: %v: %s", err, out)
+	}
+
+	cmd = diffCmd{patch: true, committed: true}
+	stdout, _, err = collectStdio(fake.X, nil, cmd.run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout, committed) || strings.Contains(stdout, uncommitted) {
+		t.Errorf("got patch:\n%s\nwant the commits of path-2 only", stdout)
+	}
+}
+
+func TestPatchPrefix(t *testing.T) {
+	for rel, want := range map[string]string{
+		".":                               "",
+		"path-1":                          "path-1/",
+		filepath.Join("third_party", "a"): "third_party/a/",
+	} {
+		if got := patchPrefix(rel); got != want {
+			t.Errorf("patchPrefix(%q) = %q, want %q", rel, got, want)
+		}
+	}
+}
+
+// TestDiffCommits tests that the diff of projects checked out with both
+// revisions lists the commits between them.
+func TestDiffCommits(t *testing.T) {
+	localProjects, fake := setupUniverse(t)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	before, err := project.LocalProjects(fake.X, project.FullScan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := localProjects[1]
+	writeReadme(t, fake.X, fake.Projects[p.Name], "first")
+	writeReadme(t, fake.X, fake.Projects[p.Name], "second")
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	after, err := project.LocalProjects(fake.X, project.FullScan)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err := (&diffCmd{maxCls: 5}).diffProjects(fake.X, before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.UpdatedProjects) != 1 || diff.UpdatedProjects[0].Name != p.Name {
+		t.Fatalf("got updated projects %+v, want %s", diff.UpdatedProjects, p.Name)
+	}
+	var got []string
+	for _, c := range diff.UpdatedProjects[0].Commits {
+		got = append(got, c.Subject)
+	}
+	if want := []string{"creating README", "creating README"}; !slices.Equal(got, want) {
+		t.Errorf("got commits %q, want %q", got, want)
+	}
+}
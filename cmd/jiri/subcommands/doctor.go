@@ -0,0 +1,326 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/subcommands"
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/cipd"
+	"go.fuchsia.dev/jiri/gitutil"
+	"go.fuchsia.dev/jiri/project"
+)
+
+type doctorCmd struct {
+	cmdBase
+
+	offline bool
+}
+
+func (c *doctorCmd) Name() string     { return "doctor" }
+func (c *doctorCmd) Synopsis() string { return "Diagnose common problems of the jiri root" }
+func (c *doctorCmd) Usage() string {
+	return `Checks the environment and the jiri root for common problems: the version
+of git and the features it supports, platform quirks such as case-insensitive
+filesystems, the health of the git cache, git credentials, the reachability of
+CIPD, and local project configs that change what "jiri update" does.
+
+Each problem is printed along with how to fix it. Exits non-zero if any check
+fails.
+
+Usage:
+  jiri doctor [flags]
+`
+}
+
+func (c *doctorCmd) SetFlags(f *flag.FlagSet) {
+	f.BoolVar(&c.offline, "offline", false, "Skip the checks which need network access.")
+}
+
+func (c *doctorCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	return executeWrapper(ctx, c.run, c.topLevelFlags, f.Args())
+}
+
+type doctorStatus int
+
+const (
+	doctorOK doctorStatus = iota
+	doctorWarning
+	doctorError
+)
+
+func (s doctorStatus) String() string {
+	switch s {
+	case doctorWarning:
+		return "WARN"
+	case doctorError:
+		return "FAIL"
+	}
+	return "OK"
+}
+
+// doctorResult is the outcome of a check, along with how to fix the problem
+// it found, if any.
+type doctorResult struct {
+	status  doctorStatus
+	check   string
+	message string
+	fix     string
+}
+
+func (c *doctorCmd) run(jirix *jiri.X, args []string) error {
+	if len(args) != 0 {
+		return jirix.UsageErrorf("unexpected arguments")
+	}
+	localProjects, err := project.LocalProjects(jirix, project.FastScan)
+	if err != nil {
+		return err
+	}
+	var projects []project.Project
+	for _, p := range localProjects {
+		projects = append(projects, p)
+	}
+	sort.Sort(project.ProjectsByPath(projects))
+
+	var results []doctorResult
+	results = append(results, checkGitVersion(jirix)...)
+	results = append(results, checkPlatform(jirix, projects)...)
+	results = append(results, checkCache(jirix, projects)...)
+	results = append(results, checkCredentials(jirix, projects)...)
+	if !c.offline {
+		results = append(results, checkCIPD(jirix)...)
+	}
+	results = append(results, checkLocalConfigs(jirix, projects)...)
+
+	failed := 0
+	for _, r := range results {
+		fmt.Fprintf(jirix.Stdout(), "[%s] %s: %s\n", r.status, r.check, r.message)
+		if r.status != doctorOK && r.fix != "" {
+			fmt.Fprintf(jirix.Stdout(), "       fix: %s\n", r.fix)
+		}
+		if r.status == doctorError {
+			failed++
+		}
+	}
+	if failed != 0 {
+		return fmt.Errorf("%d check(s) failed", failed)
+	}
+	return nil
+}
+
+// checkGitVersion checks that git supports the features used by jiri.
+func checkGitVersion(jirix *jiri.X) []doctorResult {
+	const check = "git"
+	major, minor, err := gitutil.New(jirix).Version()
+	if err != nil {
+		return []doctorResult{{doctorError, check, fmt.Sprintf("cannot get the version of git: %v", err), "Install git and make sure it is in your PATH."}}
+	}
+	atLeast := func(wantMajor, wantMinor int) bool {
+		return major > wantMajor || (major == wantMajor && minor >= wantMinor)
+	}
+	version := fmt.Sprintf("%d.%d", major, minor)
+	switch {
+	case jirix.Partial && !atLeast(2, 22):
+		return []doctorResult{{doctorError, check, fmt.Sprintf("git %s does not support the partial clones enabled for this root", version), "Upgrade git to 2.22 or later."}}
+	case !atLeast(2, 25):
+		return []doctorResult{{doctorWarning, check, fmt.Sprintf("git %s does not support sparse checkouts", version), "Upgrade git to 2.25 or later."}}
+	}
+	return []doctorResult{{doctorOK, check, fmt.Sprintf("git %s supports partial clones and sparse checkouts", version), ""}}
+}
+
+// checkPlatform checks for the quirks of the platform and of the filesystem
+// of the root.
+func checkPlatform(jirix *jiri.X, projects []project.Project) []doctorResult {
+	var results []doctorResult
+	const check = "filesystem"
+	sensitive, err := caseSensitive(jirix.RootMetaDir())
+	switch {
+	case err != nil:
+		results = append(results, doctorResult{doctorWarning, check, fmt.Sprintf("cannot check the case sensitivity of the root: %v", err), ""})
+	case sensitive:
+		results = append(results, doctorResult{doctorOK, check, "the root is on a case-sensitive filesystem", ""})
+	default:
+		paths := make(map[string]string)
+		var collisions []string
+		for _, p := range projects {
+			key := strings.ToLower(p.Path)
+			if other, ok := paths[key]; ok {
+				collisions = append(collisions, fmt.Sprintf("%s and %s", other, p.Path))
+			}
+			paths[key] = p.Path
+		}
+		if len(collisions) == 0 {
+			results = append(results, doctorResult{doctorOK, check, "the root is on a case-insensitive filesystem, but no project paths differ only by case", ""})
+		} else {
+			results = append(results, doctorResult{doctorError, check,
+				fmt.Sprintf("the root is on a case-insensitive filesystem, and the paths of projects differ only by case: %s", strings.Join(collisions, ", ")),
+				"Move the root to a case-sensitive filesystem, e.g. a case-sensitive APFS volume on macOS."})
+		}
+	}
+	if runtime.GOOS == "windows" {
+		scm := gitutil.New(jirix)
+		if v, _ := scm.ConfigGetKey("core.longpaths"); v != "true" {
+			results = append(results, doctorResult{doctorWarning, "git config", "core.longpaths is not set, checking out deep paths may fail",
+				"Run \"git config --global core.longpaths true\"."})
+		}
+		if v, _ := scm.ConfigGetKey("core.symlinks"); v != "true" {
+			results = append(results, doctorResult{doctorWarning, "git config", "core.symlinks is not set, symbolic links are checked out as plain files",
+				"Enable the developer mode of Windows and run \"git config --global core.symlinks true\"."})
+		}
+	}
+	return results
+}
+
+// caseSensitive reports whether the filesystem of dir is case-sensitive, by
+// creating a file in dir and looking it up with a different case.
+func caseSensitive(dir string) (bool, error) {
+	f, err := os.CreateTemp(dir, "doctor-CaseProbe")
+	if err != nil {
+		return false, err
+	}
+	name := f.Name()
+	f.Close()
+	defer os.Remove(name)
+	_, err = os.Stat(filepath.Join(dir, strings.ToLower(filepath.Base(name))))
+	if err == nil {
+		return false, nil
+	}
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	return false, err
+}
+
+// checkCache checks that the git cache is writable, and that the cache
+// repositories the projects borrow objects from still exist.
+func checkCache(jirix *jiri.X, projects []project.Project) []doctorResult {
+	const check = "cache"
+	if jirix.Cache == "" {
+		return []doctorResult{{doctorOK, check, "no git cache is used", ""}}
+	}
+	var results []doctorResult
+	if _, err := os.Stat(jirix.Cache); os.IsNotExist(err) {
+		results = append(results, doctorResult{doctorOK, check, fmt.Sprintf("the cache %s does not exist yet, \"jiri update\" creates it", jirix.Cache), ""})
+	} else if f, err := os.CreateTemp(jirix.Cache, "doctor"); err != nil {
+		results = append(results, doctorResult{doctorError, check, fmt.Sprintf("the cache %s is not writable: %v", jirix.Cache, err), "Fix the permissions of the cache, or use another one with -cache."})
+	} else {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	broken := 0
+	for _, p := range projects {
+		objects := filepath.Join(p.Path, ".git", "objects")
+		data, err := os.ReadFile(filepath.Join(objects, "info", "alternates"))
+		if err != nil {
+			continue
+		}
+		for _, dir := range strings.Fields(string(data)) {
+			// Relative alternates are relative to the objects directory.
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(objects, dir)
+			}
+			if _, err := os.Stat(dir); err == nil {
+				continue
+			}
+			broken++
+			results = append(results, doctorResult{doctorError, check,
+				fmt.Sprintf("project %s(%s) borrows objects from %s, which no longer exists", p.Name, p.Path, dir),
+				"Run \"jiri update\" to recreate the cache, or move the project away and run \"jiri update\" to clone it again."})
+		}
+	}
+	if broken == 0 && len(results) == 0 {
+		results = append(results, doctorResult{doctorOK, check, fmt.Sprintf("the cache %s is healthy", jirix.Cache), ""})
+	}
+	return results
+}
+
+// checkCredentials checks that credentials are set up for pushing to the
+// Gerrit hosts of the projects.
+func checkCredentials(jirix *jiri.X, projects []project.Project) []doctorResult {
+	const check = "credentials"
+	needed := false
+	for _, p := range projects {
+		if p.GerritHost != "" && strings.HasPrefix(p.Remote, "https://") {
+			needed = true
+			break
+		}
+	}
+	if !needed {
+		return []doctorResult{{doctorOK, check, "no project is pushed to over https", ""}}
+	}
+	scm := gitutil.New(jirix)
+	if helper, err := scm.ConfigGetKey("credential.helper"); err == nil && helper != "" {
+		return []doctorResult{{doctorOK, check, fmt.Sprintf("git uses the credential helper %q", helper), ""}}
+	}
+	if cookies, err := scm.ConfigGetPath("http.cookiefile"); err == nil && cookies != "" {
+		if _, err := os.Stat(cookies); err != nil {
+			return []doctorResult{{doctorError, check, fmt.Sprintf("the http.cookiefile %s of git does not exist", cookies),
+				"Generate the cookie file from the Gerrit host, or unset http.cookiefile."}}
+		}
+		return []doctorResult{{doctorOK, check, fmt.Sprintf("git uses the cookie file %s", cookies), ""}}
+	}
+	if _, err := os.Stat(filepath.Join(os.Getenv("HOME"), ".netrc")); err == nil {
+		return []doctorResult{{doctorOK, check, "git uses ~/.netrc", ""}}
+	}
+	return []doctorResult{{doctorWarning, check, "no git credential helper, cookie file or ~/.netrc is set up, \"jiri upload\" will prompt for credentials or fail",
+		"Run \"git config --global credential.helper <helper>\", or set http.cookiefile to a cookie file from the Gerrit host."}}
+}
+
+// checkCIPD checks that the CIPD server can be reached.
+func checkCIPD(jirix *jiri.X) []doctorResult {
+	const check = "cipd"
+	client := http.Client{Timeout: 10 * time.Second}
+	url := "https://" + cipd.ServerHost()
+	resp, err := client.Head(url)
+	if err != nil {
+		return []doctorResult{{doctorError, check, fmt.Sprintf("cannot reach %s: %v", url, err),
+			"Check your network connection and proxy settings, e.g. HTTPS_PROXY."}}
+	}
+	resp.Body.Close()
+	return []doctorResult{{doctorOK, check, fmt.Sprintf("%s is reachable", url), ""}}
+}
+
+// checkLocalConfigs reports the projects whose local config changes what
+// "jiri update" does to them, which is easy to forget about.
+func checkLocalConfigs(jirix *jiri.X, projects []project.Project) []doctorResult {
+	const check = "local config"
+	var results []doctorResult
+	for _, p := range projects {
+		lc := p.LocalConfig
+		rel := p.Path
+		if r, err := filepath.Rel(jirix.Root, p.Path); err == nil {
+			rel = r
+		}
+		report := func(what, fix string) {
+			results = append(results, doctorResult{doctorWarning, check, fmt.Sprintf("project %s(%s) %s", p.Name, rel, what), fix})
+		}
+		switch {
+		case lc.Ignore:
+			report("is ignored by \"jiri update\"", fmt.Sprintf("Run \"jiri project-config -ignore=false\" in %s.", rel))
+		case lc.NoUpdate:
+			report("is not updated by \"jiri update\"", fmt.Sprintf("Run \"jiri project-config -no-update=false\" in %s.", rel))
+		}
+		if lc.NoRebase {
+			report("has its branches never rebased by \"jiri update\"", fmt.Sprintf("Run \"jiri project-config -no-rebase=false\" in %s.", rel))
+		}
+		if lc.Pin != "" {
+			report(fmt.Sprintf("is pinned to revision %s", lc.Pin), fmt.Sprintf("Run \"jiri pin -delete %s\".", p.Name))
+		}
+	}
+	if len(results) == 0 {
+		results = append(results, doctorResult{doctorOK, check, "no project is ignored, pinned or excluded from updates", ""})
+	}
+	return results
+}
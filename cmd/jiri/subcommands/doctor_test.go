@@ -0,0 +1,80 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.fuchsia.dev/jiri/project"
+)
+
+func TestDoctor(t *testing.T) {
+	localProjects, fake := setupUniverse(t)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	cmd := doctorCmd{offline: true}
+	stdout, _, err := collectStdio(fake.X, nil, cmd.run)
+	if err != nil {
+		t.Fatalf("doctor failed on a fresh root: %v, output:\n%s", err, stdout)
+	}
+	for _, want := range []string{"] git: ", "] filesystem: ", "[OK] local config: "} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("expected %q in output, got:\n%s", want, stdout)
+		}
+	}
+
+	// A forgotten local config is reported with its fix.
+	if err := project.WriteLocalConfig(fake.X, localProjects[1], project.LocalConfig{Ignore: true}); err != nil {
+		t.Fatal(err)
+	}
+	stdout, _, err = collectStdio(fake.X, nil, cmd.run)
+	if err != nil {
+		t.Fatalf("warnings should not fail doctor: %v", err)
+	}
+	for _, want := range []string{
+		"[WARN] local config: project " + localProjects[1].Name + "(path-1) is ignored",
+		"fix: Run \"jiri project-config -ignore=false\" in path-1.",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("expected %q in output, got:\n%s", want, stdout)
+		}
+	}
+
+	// A project borrowing objects from a missing cache fails the check.
+	fake.X.Cache = filepath.Join(t.TempDir(), "cache")
+	alternates := filepath.Join(localProjects[0].Path, ".git", "objects", "info", "alternates")
+	if err := os.WriteFile(alternates, []byte(filepath.Join(fake.X.Cache, "gone", "objects")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stdout, _, err = collectStdio(fake.X, nil, cmd.run)
+	if err == nil {
+		t.Fatalf("doctor should fail with a missing cache, output:\n%s", stdout)
+	}
+	if want := "[FAIL] cache: project " + localProjects[0].Name; !strings.Contains(stdout, want) {
+		t.Errorf("expected %q in output, got:\n%s", want, stdout)
+	}
+
+	// Relative alternates are resolved against the objects directory, and
+	// the check does not create the cache.
+	objects := filepath.Join(localProjects[0].Path, ".git", "objects")
+	rel, err := filepath.Rel(objects, filepath.Join(localProjects[1].Path, ".git", "objects"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(alternates, []byte(rel+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stdout, _, err = collectStdio(fake.X, nil, cmd.run)
+	if err != nil {
+		t.Fatalf("doctor failed with relative alternates: %v, output:\n%s", err, stdout)
+	}
+	if _, err := os.Stat(fake.X.Cache); !os.IsNotExist(err) {
+		t.Errorf("doctor should not create the cache, got %v", err)
+	}
+}
@@ -12,12 +12,12 @@ import (
 	"os"
 	"path"
 	"path/filepath"
-	"regexp"
 	"strings"
 
 	"github.com/google/subcommands"
 	"go.fuchsia.dev/jiri"
 	"go.fuchsia.dev/jiri/gitutil"
+	"go.fuchsia.dev/jiri/manifestedit"
 	"go.fuchsia.dev/jiri/project"
 )
 
@@ -140,8 +140,9 @@ func (c *editCmd) writeManifest(jirix *jiri.X, manifestPath, manifestContent str
 		return true
 	}
 
-	if len(projects) != 0 && (c.editMode == lockfile || c.editMode == both) {
-		// Search lockfiles and update
+	if c.editMode == lockfile || c.editMode == both {
+		// Search lockfiles and update their project locks and the digest
+		// of the manifest.
 		dir := manifestPath
 		for ; isLockfileDir(jirix, dir); dir = path.Dir(dir) {
 			lockfile := path.Join(path.Dir(dir), jirix.LockfileName)
@@ -150,21 +151,21 @@ func (c *editCmd) writeManifest(jirix *jiri.X, manifestPath, manifestContent str
 				jirix.Logger.Debugf("lockfile could not be accessed at %q due to error %v", lockfile, err)
 				continue
 			}
-			if err := updateLocks(jirix, tempDir, lockfile, backup, projects); err != nil {
+			if err := updateLocks(jirix, tempDir, lockfile, manifestPath, manifestContent, backup, projects); err != nil {
 				rewind()
 				return err
 			}
 		}
 	}
 
-	if err := os.WriteFile(manifestPath, []byte(manifestContent), os.ModePerm); err != nil {
+	if err := project.SafeWriteFile(jirix, manifestPath, []byte(manifestContent)); err != nil {
 		rewind()
 		return err
 	}
 	return nil
 }
 
-func updateLocks(jirix *jiri.X, tempDir, lockfile string, backup, projects map[string]string) error {
+func updateLocks(jirix *jiri.X, tempDir, lockfile, manifestPath, manifestContent string, backup, projects map[string]string) error {
 	jirix.Logger.Debugf("try updating lockfile %q", lockfile)
 	bin, err := os.ReadFile(lockfile)
 	if err != nil {
@@ -175,6 +176,10 @@ func updateLocks(jirix *jiri.X, tempDir, lockfile string, backup, projects map[s
 	if err != nil {
 		return err
 	}
+	digests, err := project.UnmarshalLockDigests(bin)
+	if err != nil {
+		return err
+	}
 
 	found := false
 	for k, v := range projectLocks {
@@ -184,6 +189,9 @@ func updateLocks(jirix *jiri.X, tempDir, lockfile string, backup, projects map[s
 			found = true
 		}
 	}
+	if digests.UpdateManifest(jirix, manifestPath, []byte(manifestContent)) {
+		found = true
+	}
 
 	if found {
 		// backup original lockfile
@@ -196,82 +204,47 @@ func updateLocks(jirix *jiri.X, tempDir, lockfile string, backup, projects map[s
 			return err
 		}
 		backup[lockfile] = backupName
-		ebin, err := project.MarshalLockEntries(projectLocks, packageLocks, version)
+		ebin, err := project.MarshalLockFile(projectLocks, packageLocks, digests, version)
 		if err != nil {
 			return err
 		}
 		jirix.Logger.Debugf("updated lockfile %q", lockfile)
-		return os.WriteFile(lockfile, ebin, info.Mode())
+		return project.SafeWriteFile(jirix, lockfile, ebin)
 	}
-	jirix.Logger.Debugf("skipped lockfile %q, no matching projects", lockfile)
+	jirix.Logger.Debugf("skipped lockfile %q, no matching projects or manifest", lockfile)
 	return nil
 }
 
+// updateRevision sets the revision of the element tag named name, currently
+// at currentRevision, to newRevision in manifestContent, leaving the rest of
+// it untouched.
 func updateRevision(manifestContent, tag, currentRevision, newRevision, name string) (string, error) {
-	// We can do a trivial string replace if the `currentRevision` is non-empty
-	// and unique. Otherwise we need to edit the entire XML block for the project.
-	if currentRevision != "" && currentRevision != "HEAD" && strings.Count(manifestContent, currentRevision) == 1 {
-		return strings.Replace(manifestContent, currentRevision, newRevision, 1), nil
-	}
-	return updateRevisionOrVersionAttr(manifestContent, tag, newRevision, name, "revision")
+	return updateRevisionOrVersionAttr(manifestContent, tag, newRevision, name, "revision", currentRevision)
 }
 
+// updateVersion sets the version of the package pc in manifestContent,
+// leaving the rest of it untouched.
 func updateVersion(manifestContent, tag string, pc packageChanges) (string, error) {
-	// There are chances multiple packages share the same version tag,
-	// therefore, we cannot simple replace version string globally.
-	// Unlike project declaration, the version attribute of a package is not
-	// allowed to be empty.
-	name := regexp.QuoteMeta(pc.Name)
-	oldVal := regexp.QuoteMeta(pc.OldVer)
-	// Avoid using %q in regex, it behaves differently from regex.QuoteMeta.
-	r, err := regexp.Compile(fmt.Sprintf("( *?)<%s[\\s\\n]+[^<]*?name=\"%s\"(.|\\n)*?version=\"%s\"(.|\\n)*?\\/>", tag, name, oldVal))
-	if err != nil {
-		return "", err
-	}
-	t := r.FindStringSubmatch(manifestContent)
-	if t == nil {
-		return "", fmt.Errorf("Not able to match %s \"%s\"", tag, name)
-	}
-	s := t[0]
-	us := strings.Replace(s, fmt.Sprintf("version=\"%s\"", pc.OldVer), fmt.Sprintf("version=\"%s\"", pc.NewVer), 1)
-	return strings.Replace(manifestContent, s, us, 1), nil
+	return updateRevisionOrVersionAttr(manifestContent, tag, pc.NewVer, pc.Name, "version", pc.OldVer)
 }
 
-func updateRevisionOrVersionAttr(manifestContent, tag, newAttrValue, name, attr string) (string, error) {
-	// Find the manifest fragment with the appropriate `name`.
-	name = regexp.QuoteMeta(name)
-	// Avoid using %q in regex, it behaves differently from regex.QuoteMeta.
-	r, err := regexp.Compile(fmt.Sprintf("( *?)<%s[\\s\\n]+[^<]*?name=\"%s\"(.|\\n)*?\\/>", tag, name))
+// updateRevisionOrVersionAttr sets the attribute attr of the element tag
+// named name to newAttrValue in manifestContent, adding the attribute if
+// needed. If several elements have that name, e.g. the same project from
+// different remotes, the one whose attr is oldAttrValue is edited.
+func updateRevisionOrVersionAttr(manifestContent, tag, newAttrValue, name, attr, oldAttrValue string) (string, error) {
+	e, err := manifestedit.New([]byte(manifestContent))
 	if err != nil {
 		return "", err
 	}
-	t := r.FindStringSubmatch(manifestContent)
-	if t == nil {
-		return "", fmt.Errorf("Not able to match %s \"%s\"", tag, name)
-	}
-	s := t[0]
-	spaces := t[1]
-	for i := 0; i < len(tag); i++ {
-		spaces = spaces + " "
+	sel := manifestedit.Elem(tag, name)
+	if oldAttrValue != "" && e.Count(sel) > 1 {
+		sel = sel.With(attr, oldAttrValue)
 	}
-
-	// Try to find the attribute `attr` in the fragment.
-	r, err = regexp.Compile(fmt.Sprintf(`%s\s*=\s*"[^"]*"`, attr))
-	if err != nil {
-		return "", fmt.Errorf("error parsing attr regexp for: %v: %w", attr, err)
-	}
-
-	t = r.FindStringSubmatch(s)
-	var rs string
-	if len(t) == 0 {
-		// No such attribute, add it.
-		rs = strings.Replace(s, "/>", fmt.Sprintf("\n%s  %s=%q/>", spaces, attr, newAttrValue), 1)
-	} else {
-		// There is such an attribute, replace it.
-		rs = strings.Replace(s, t[0], fmt.Sprintf(`%s="%s"`, attr, newAttrValue), 1)
+	if err := e.SetAttr(sel, attr, newAttrValue); err != nil {
+		return "", err
 	}
-	// Replace entire original string s with the replacement string.
-	return strings.Replace(manifestContent, s, rs, 1), nil
+	return e.String(), nil
 }
 
 func (c *editCmd) updateManifest(jirix *jiri.X, manifestPath string, projects, imports, packages map[string]string) error {
@@ -291,7 +264,7 @@ func (c *editCmd) updateManifest(jirix *jiri.X, manifestPath string, projects, i
 	}
 	manifestContent := string(content)
 	editedProjects := make(map[string]string)
-	scm := gitutil.New(jirix, gitutil.RootDirOpt(filepath.Dir(manifestPath)))
+	manifestDir := filepath.Dir(manifestPath)
 	for _, p := range m.Projects {
 		newRevision := ""
 		if rev, ok := projects[p.Name]; !ok {
@@ -304,7 +277,7 @@ func (c *editCmd) updateManifest(jirix *jiri.X, manifestPath string, projects, i
 			if p.RemoteBranch != "" {
 				branch = p.RemoteBranch
 			}
-			out, err := scm.LsRemote(p.Remote, fmt.Sprintf("refs/heads/%s", branch))
+			out, err := gitutil.New(jirix, gitutil.RootDirOpt(manifestDir), gitutil.ProjectOpt(p.Name)).LsRemote(p.Remote, fmt.Sprintf("refs/heads/%s", branch))
 			if err != nil {
 				return err
 			}
@@ -341,7 +314,7 @@ func (c *editCmd) updateManifest(jirix *jiri.X, manifestPath string, projects, i
 			if i.RemoteBranch != "" {
 				branch = i.RemoteBranch
 			}
-			out, err := scm.LsRemote(i.Remote, fmt.Sprintf("refs/heads/%s", branch))
+			out, err := gitutil.New(jirix, gitutil.RootDirOpt(manifestDir), gitutil.ProjectOpt(i.Name)).LsRemote(i.Remote, fmt.Sprintf("refs/heads/%s", branch))
 			if err != nil {
 				return err
 			}
@@ -178,7 +178,7 @@ func TestUpdateRevision(t *testing.T) {
 				path="third_party/dart-pkg/pub"
 				remote="https://fuchsia.googlesource.com/third_party/dart-pkg"
 				gerrithost="https://fuchsia-review.googlesource.com"
-         revision="ffffffffffffffffffffffffffffffffffffffff"/>
+				revision="ffffffffffffffffffffffffffffffffffffffff"/>
 	</projects>
 </manifest>
 `
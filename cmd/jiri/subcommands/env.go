@@ -0,0 +1,98 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/subcommands"
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/project"
+)
+
+type envCmd struct {
+	cmdBase
+
+	format string
+}
+
+func (c *envCmd) Name() string     { return "env" }
+func (c *envCmd) Synopsis() string { return "Print environment variables defined by the manifest" }
+func (c *envCmd) Usage() string {
+	return `Prints shell commands exporting the environment variables defined by <env>
+elements of the manifest, so that build systems don't need to hard-code the
+location of prebuilts. For example, in bash:
+
+  eval "$(jiri env)"
+
+Values can refer to {{.Root}}, {{.OS}}, {{.Arch}} and to the path of a
+package with {{package "name"}}.
+
+If variable names are given, only those are printed.
+
+Usage:
+  jiri env [flags] [<name>...]
+`
+}
+
+func (c *envCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&c.format, "format", "bash", "Output format: bash, fish or powershell.")
+}
+
+func (c *envCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	return executeWrapper(ctx, c.run, c.topLevelFlags, f.Args())
+}
+
+// envFormatters format the export of a variable for each supported shell.
+var envFormatters = map[string]func(name, value string) string{
+	"bash": func(name, value string) string {
+		return fmt.Sprintf("export %s='%s'", name, strings.ReplaceAll(value, "'", `'\''`))
+	},
+	"fish": func(name, value string) string {
+		value = strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(value)
+		return fmt.Sprintf("set -gx %s '%s'", name, value)
+	},
+	"powershell": func(name, value string) string {
+		return fmt.Sprintf("$env:%s = '%s'", name, strings.ReplaceAll(value, "'", "''"))
+	},
+}
+
+func (c *envCmd) run(jirix *jiri.X, args []string) error {
+	format, ok := envFormatters[c.format]
+	if !ok {
+		return jirix.UsageErrorf("unknown format %q", c.format)
+	}
+	localProjects, err := project.LocalProjects(jirix, project.FastScan)
+	if err != nil {
+		return err
+	}
+	envs, pkgs, err := project.LoadManifestEnvs(jirix, jirix.JiriManifestFile(), localProjects)
+	if err != nil {
+		return err
+	}
+	names := args
+	if len(names) == 0 {
+		for name := range envs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	for _, name := range names {
+		env, ok := envs[name]
+		if !ok {
+			return fmt.Errorf("env %q is not defined by the manifest", name)
+		}
+		value, err := env.Expand(jirix, pkgs)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(jirix.Stdout(), format(name, value))
+	}
+	return nil
+}
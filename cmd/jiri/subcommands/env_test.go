@@ -0,0 +1,24 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"testing"
+)
+
+func TestEnvFormatters(t *testing.T) {
+	tests := []struct {
+		format, want string
+	}{
+		{"bash", `export FOO='/it'\''s \here'`},
+		{"fish", `set -gx FOO '/it\'s \\here'`},
+		{"powershell", `$env:FOO = '/it''s \here'`},
+	}
+	for _, test := range tests {
+		if got := envFormatters[test.format]("FOO", `/it's \here`); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.format, got, test.want)
+		}
+	}
+}
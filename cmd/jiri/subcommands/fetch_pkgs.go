@@ -22,6 +22,8 @@ type fetchPkgsCmd struct {
 	skipLocalProjects     bool
 	packagesToSkip        arrayFlag
 	localManifestProjects arrayFlag
+	wait                  bool
+	attrFlags
 }
 
 func (c *fetchPkgsCmd) Name() string { return "fetch-packages" }
@@ -44,6 +46,8 @@ func (c *fetchPkgsCmd) SetFlags(f *flag.FlagSet) {
 	f.BoolVar(&c.skipLocalProjects, "skip-local-projects", false, "Skip checking local project state.")
 	f.Var(&c.packagesToSkip, "package-to-skip", "Skip fetching this package. Repeatable.")
 	f.Var(&c.localManifestProjects, "local-manifest-project", "Import projects whose local manifests should be respected. Repeatable.")
+	f.BoolVar(&c.wait, "wait", false, "Wait for other jiri commands changing the root to finish instead of failing.")
+	c.attrFlags.setFlags(f)
 }
 
 func (c *fetchPkgsCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
@@ -51,6 +55,9 @@ func (c *fetchPkgsCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) s
 }
 
 func (c *fetchPkgsCmd) run(jirix *jiri.X, args []string) (err error) {
+	if err := jirix.LockRoot(c.Name(), c.wait); err != nil {
+		return err
+	}
 	localProjects := project.Projects{}
 	if !c.skipLocalProjects {
 		localProjects, err = project.LocalProjects(jirix, project.FastScan)
@@ -62,6 +69,7 @@ func (c *fetchPkgsCmd) run(jirix *jiri.X, args []string) (err error) {
 		return jirix.UsageErrorf("Number of attempts should be >= 1")
 	}
 	jirix.Attempts = c.attempts
+	c.attrFlags.apply(jirix)
 
 	// Get pkgs.
 	var pkgs project.Packages
@@ -72,10 +80,11 @@ func (c *fetchPkgsCmd) run(jirix *jiri.X, args []string) (err error) {
 		}
 
 	}
-	_, _, pkgs, err = project.LoadManifestFile(jirix, jirix.JiriManifestFile(), localProjects, c.localManifestProjects)
+	projects, _, pkgs, err := project.LoadManifestFile(jirix, jirix.JiriManifestFile(), localProjects, c.localManifestProjects)
 	if err := project.FilterOptionalProjectsPackages(jirix, jirix.FetchingAttrs, nil, pkgs); err != nil {
 		return err
 	}
+	project.FilterProjectsPackagesByGroup(jirix, projects, pkgs)
 
 	project.FilterPackagesByName(jirix, pkgs, c.packagesToSkip)
 	if len(pkgs) > 0 {
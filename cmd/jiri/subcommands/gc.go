@@ -0,0 +1,84 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/google/subcommands"
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/project"
+)
+
+type gcCmd struct {
+	cmdBase
+
+	force    bool
+	dryRun   bool
+	cache    bool
+	packages bool
+}
+
+func (c *gcCmd) Name() string     { return "gc" }
+func (c *gcCmd) Synopsis() string { return "Delete projects and other files no longer in the manifest" }
+func (c *gcCmd) Usage() string {
+	return `Deletes project directories that are no longer part of the manifest.
+
+Projects that contain local branches, uncommitted changes or untracked files
+are left in place unless -force is given. Projects whose local config sets
+"ignore" are never deleted.
+
+With -cache, git cache directories that are not used by any project in the
+manifest are deleted as well. The cache may be shared with other checkouts,
+so only use this if it is not.
+
+With -packages, package directories installed by previous updates that are
+no longer referenced by the manifest are deleted as well.
+
+Usage:
+  jiri gc [flags]
+`
+}
+
+func (c *gcCmd) SetFlags(f *flag.FlagSet) {
+	f.BoolVar(&c.force, "force", false, "Delete orphaned projects even if they contain branches or local changes.")
+	f.BoolVar(&c.dryRun, "n", false, "List what would be deleted without deleting anything.")
+	f.BoolVar(&c.cache, "cache", false, "Also delete unused git cache directories.")
+	f.BoolVar(&c.packages, "packages", false, "Also delete package directories no longer in the manifest.")
+}
+
+func (c *gcCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	return executeWrapper(ctx, c.run, c.topLevelFlags, f.Args())
+}
+
+func (c *gcCmd) run(jirix *jiri.X, args []string) error {
+	if len(args) > 0 {
+		return jirix.UsageErrorf("unexpected arguments")
+	}
+	candidates, err := project.GarbageCollect(jirix, project.GCParams{
+		Force:    c.force,
+		DryRun:   c.dryRun,
+		Cache:    c.cache,
+		Packages: c.packages,
+	})
+	if err != nil {
+		return err
+	}
+	if !c.dryRun {
+		return nil
+	}
+	for _, p := range candidates.Projects {
+		fmt.Fprintf(jirix.Stdout(), "project %s: %s\n", p.Name, p.Path)
+	}
+	for _, dir := range candidates.CacheDirs {
+		fmt.Fprintf(jirix.Stdout(), "cache: %s\n", dir)
+	}
+	for _, dir := range candidates.PackagePaths {
+		fmt.Fprintf(jirix.Stdout(), "package: %s\n", dir)
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.fuchsia.dev/jiri/gitutil"
+	"go.fuchsia.dev/jiri/project"
+)
+
+func TestGC(t *testing.T) {
+	localProjects, fake := setupUniverse(t)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Drop the last two projects from the manifest without deleting them.
+	m, err := fake.ReadRemoteManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kept []project.Project
+	for _, p := range m.Projects {
+		if p.Name != localProjects[1].Name && p.Name != localProjects[2].Name {
+			kept = append(kept, p)
+		}
+	}
+	m.Projects = kept
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := gitutil.New(fake.X, gitutil.RootDirOpt(localProjects[2].Path)).CreateBranch("work"); err != nil {
+		t.Fatal(err)
+	}
+
+	exists := func(p project.Project) bool {
+		t.Helper()
+		_, err := os.Stat(p.Path)
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		return err == nil
+	}
+
+	cmd := gcCmd{dryRun: true}
+	stdout, _, err := collectStdio(fake.X, nil, cmd.run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range localProjects[1:] {
+		if !strings.Contains(stdout, p.Path) {
+			t.Errorf("expected %q in output, got:\n%s", p.Path, stdout)
+		}
+		if !exists(p) {
+			t.Errorf("project %q was deleted in dry-run mode", p.Name)
+		}
+	}
+	if strings.Contains(stdout, localProjects[0].Path) {
+		t.Errorf("project %q should not be listed, got:\n%s", localProjects[0].Name, stdout)
+	}
+
+	cmd = gcCmd{}
+	if _, _, err := collectStdio(fake.X, nil, cmd.run); err != nil {
+		t.Fatal(err)
+	}
+	if !exists(localProjects[0]) {
+		t.Errorf("project %q should not be deleted", localProjects[0].Name)
+	}
+	if exists(localProjects[1]) {
+		t.Errorf("project %q should be deleted", localProjects[1].Name)
+	}
+	if !exists(localProjects[2]) {
+		t.Errorf("project %q has a branch and should not be deleted without -force", localProjects[2].Name)
+	}
+
+	cmd = gcCmd{force: true}
+	if _, _, err := collectStdio(fake.X, nil, cmd.run); err != nil {
+		t.Fatal(err)
+	}
+	if exists(localProjects[2]) {
+		t.Errorf("project %q should be deleted with -force", localProjects[2].Name)
+	}
+}
+
+// TestGCCache checks that -cache deletes the stale directories of the git
+// cache, but not the cache of package instances.
+func TestGCCache(t *testing.T) {
+	_, fake := setupUniverse(t)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	fake.X.Cache = t.TempDir()
+	fake.X.CipdCacheDir = filepath.Join(fake.X.Cache, "cipd")
+	stale := filepath.Join(fake.X.Cache, "stale")
+	for _, dir := range []string{fake.X.CipdCacheDir, stale} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, _, err := collectStdio(fake.X, nil, (&gcCmd{cache: true}).run); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("stale cache directory should be deleted: %v", err)
+	}
+	if _, err := os.Stat(fake.X.CipdCacheDir); err != nil {
+		t.Errorf("the cache of package instances should be kept: %v", err)
+	}
+}
+
+func TestGCNestedProject(t *testing.T) {
+	t.Parallel()
+
+	localProjects, fake := setupUniverse(t)
+	parent := localProjects[1]
+	nested := project.Project{
+		Name: "nested",
+		Path: filepath.Join(parent.Path, "sub", "nested"),
+	}
+	if err := fake.CreateRemoteProject(nested.Name); err != nil {
+		t.Fatal(err)
+	}
+	nested.Remote = fake.Projects[nested.Name]
+	if err := fake.AddProject(nested); err != nil {
+		t.Fatal(err)
+	}
+	writeReadme(t, fake.X, nested.Remote, "nested readme")
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Drop the parent from the manifest, keeping the project nested in it.
+	m, err := fake.ReadRemoteManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kept []project.Project
+	for _, p := range m.Projects {
+		if p.Name != parent.Name {
+			kept = append(kept, p)
+		}
+	}
+	m.Projects = kept
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(nested.Path, "local"), []byte("local edit"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := gcCmd{force: true}
+	if _, _, err := collectStdio(fake.X, nil, cmd.run); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(parent.Path, "README")); !os.IsNotExist(err) {
+		t.Errorf("project %q should be deleted, got %v", parent.Name, err)
+	}
+	if _, err := os.Stat(filepath.Join(parent.Path, ".git")); !os.IsNotExist(err) {
+		t.Errorf("git directory of project %q should be deleted, got %v", parent.Name, err)
+	}
+	for _, name := range []string{".git", "README", "local"} {
+		if _, err := os.Stat(filepath.Join(nested.Path, name)); err != nil {
+			t.Errorf("nested project %q should be kept: %v", nested.Name, err)
+		}
+	}
+}
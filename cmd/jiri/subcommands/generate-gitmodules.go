@@ -9,7 +9,6 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"os"
 	"path/filepath"
 	"strings"
 
@@ -134,20 +133,20 @@ func (c *genGitModuleCmd) writeGitModules(jirix *jiri.X, projects project.Projec
 		}
 	}
 	jirix.Logger.Debugf("generated gitmodule content \n%v\n", gitmoduleBuf.String())
-	if err := os.WriteFile(gitmodulesPath, gitmoduleBuf.Bytes(), 0644); err != nil {
+	if err := project.SafeWriteFile(jirix, gitmodulesPath, gitmoduleBuf.Bytes()); err != nil {
 		return err
 	}
 
 	if c.genScript != "" {
 		jirix.Logger.Debugf("generated set up script for gitmodule content \n%v\n", commandBuf.String())
-		if err := os.WriteFile(c.genScript, commandBuf.Bytes(), 0755); err != nil {
+		if err := project.SafeWriteFileMode(jirix, c.genScript, commandBuf.Bytes(), 0755); err != nil {
 			return err
 		}
 	}
 
 	if gitattributesPath != "" {
 		jirix.Logger.Debugf("generated gitattributes content \n%v\n", gitattributeBuf.String())
-		if err := os.WriteFile(gitattributesPath, gitattributeBuf.Bytes(), 0644); err != nil {
+		if err := project.SafeWriteFile(jirix, gitattributesPath, gitattributeBuf.Bytes()); err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,231 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/google/subcommands"
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/project"
+)
+
+type historyCmd struct {
+	cmdBase
+
+	jsonOutput bool
+}
+
+func (c *historyCmd) Name() string     { return "history" }
+func (c *historyCmd) Synopsis() string { return "Browse the snapshots of previous updates" }
+func (c *historyCmd) Usage() string {
+	return `Lists, prints and compares the snapshots that "jiri update" keeps in
+[root]/.jiri_root/update_history.
+
+Snapshots are named after the time of the update. Wherever a snapshot is
+expected, "latest", "second-latest" and "~N" (the Nth snapshot before the
+latest one) are accepted as well.
+
+How many snapshots are retained is configured with the -history-keep and
+-history-keep-days flags of "jiri init".
+
+Usage:
+  jiri history [flags] [list]
+  jiri history [flags] show <snapshot>
+  jiri history [flags] diff [<snapshot-1> <snapshot-2>]
+
+"show" prints the snapshot followed, when the log of the update is still
+kept, by a summary of what the update did: the number of operations of each
+kind run on projects, the hooks run or skipped with their exit code and
+duration, and the packages deployed with their size and whether they were
+already deployed (a cache hit) or fetched. With -json, only the summary is
+printed, as recorded in the log.
+
+"diff" compares second-latest with latest if no snapshots are given.
+`
+}
+
+func (c *historyCmd) SetFlags(f *flag.FlagSet) {
+	f.BoolVar(&c.jsonOutput, "json", false, "Print the output of list and diff, and the update summary of show, in json format.")
+}
+
+func (c *historyCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	return executeWrapper(ctx, c.run, c.topLevelFlags, f.Args())
+}
+
+func (c *historyCmd) run(jirix *jiri.X, args []string) error {
+	if len(args) == 0 {
+		return c.list(jirix)
+	}
+	switch args[0] {
+	case "list":
+		if len(args) != 1 {
+			return jirix.UsageErrorf("list takes no arguments")
+		}
+		return c.list(jirix)
+	case "show":
+		if len(args) != 2 {
+			return jirix.UsageErrorf("show takes exactly one snapshot")
+		}
+		return c.show(jirix, args[1])
+	case "diff":
+		switch len(args) {
+		case 1:
+			return c.diff(jirix, "second-latest", "latest")
+		case 3:
+			return c.diff(jirix, args[1], args[2])
+		}
+		return jirix.UsageErrorf("diff takes zero or two snapshots")
+	}
+	return jirix.UsageErrorf("unknown history command %q", args[0])
+}
+
+func (c *historyCmd) list(jirix *jiri.X) error {
+	snapshots, err := project.ListUpdateHistory(jirix)
+	if err != nil {
+		return err
+	}
+	if c.jsonOutput {
+		type entry struct {
+			Name string `json:"name"`
+			Path string `json:"path"`
+		}
+		entries := make([]entry, 0, len(snapshots))
+		for _, s := range snapshots {
+			entries = append(entries, entry{Name: s.Name, Path: s.Path})
+		}
+		e := json.NewEncoder(jirix.Stdout())
+		e.SetIndent("", " ")
+		return e.Encode(entries)
+	}
+	for i, s := range snapshots {
+		fmt.Fprintf(jirix.Stdout(), "~%d\t%s\n", i, s.Name)
+	}
+	return nil
+}
+
+func (c *historyCmd) show(jirix *jiri.X, name string) error {
+	path, err := project.ResolveUpdateHistory(jirix, name)
+	if err != nil {
+		return err
+	}
+	summary, err := project.ReadUpdateSummary(jirix, path)
+	if err != nil {
+		return err
+	}
+	if c.jsonOutput {
+		if summary == nil {
+			return fmt.Errorf("no summary of the update of snapshot %q is kept", name)
+		}
+		e := json.NewEncoder(jirix.Stdout())
+		e.SetIndent("", " ")
+		return e.Encode(summary)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(jirix.Stdout(), f); err != nil {
+		return err
+	}
+	if summary != nil {
+		fmt.Fprintln(jirix.Stdout())
+		printUpdateSummary(jirix.Stdout(), summary)
+	}
+	return nil
+}
+
+// printUpdateSummary prints what an update did, as recorded in its log.
+func printUpdateSummary(w io.Writer, s *jiri.UpdateSummary) {
+	fmt.Fprintln(w, "Update summary:")
+	var kinds []string
+	for kind := range s.Operations {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	var ops []string
+	for _, kind := range kinds {
+		ops = append(ops, fmt.Sprintf("%s %d", kind, s.Operations[kind]))
+	}
+	if len(ops) == 0 {
+		ops = append(ops, "none")
+	}
+	fmt.Fprintf(w, "Operations: %s\n", strings.Join(ops, ", "))
+	if len(s.Hooks) > 0 {
+		fmt.Fprintln(w, "Hooks:")
+	}
+	for _, h := range s.Hooks {
+		fmt.Fprintf(w, "  %s (%s)", h.Name, h.Project)
+		if h.Skipped {
+			fmt.Fprintln(w, " skipped, inputs unchanged")
+			continue
+		}
+		fmt.Fprintf(w, " exit %d in %.1fs", h.ExitCode, h.Duration)
+		if h.Error != "" {
+			fmt.Fprintf(w, ": %s", h.Error)
+		}
+		fmt.Fprintln(w)
+	}
+	if len(s.Packages) > 0 {
+		fmt.Fprintln(w, "Packages:")
+	}
+	for _, p := range s.Packages {
+		state := "fetched"
+		if p.CacheHit {
+			state = "cache hit"
+		}
+		fmt.Fprintf(w, "  %s (%s) %s, %d bytes, %s\n", p.Name, p.Path, p.InstanceID, p.Size, state)
+	}
+}
+
+func (c *historyCmd) diff(jirix *jiri.X, name1, name2 string) error {
+	snapshot1, err := project.ResolveUpdateHistory(jirix, name1)
+	if err != nil {
+		return err
+	}
+	snapshot2, err := project.ResolveUpdateHistory(jirix, name2)
+	if err != nil {
+		return err
+	}
+	d, err := (&diffCmd{}).getDiff(jirix, snapshot1, snapshot2)
+	if err != nil {
+		return err
+	}
+	if c.jsonOutput {
+		e := json.NewEncoder(jirix.Stdout())
+		e.SetIndent("", " ")
+		return e.Encode(d)
+	}
+	printDiffSummary(jirix.Stdout(), d)
+	return nil
+}
+
+// printDiffSummary prints one line per project added, deleted or updated in d.
+func printDiffSummary(w io.Writer, d *Diff) {
+	for _, p := range d.NewProjects {
+		fmt.Fprintf(w, "+ %s (%s) %s\n", p.Name, p.RelativePath, p.Revision)
+	}
+	for _, p := range d.DeletedProjects {
+		fmt.Fprintf(w, "- %s (%s) %s\n", p.Name, p.RelativePath, p.Revision)
+	}
+	for _, p := range d.UpdatedProjects {
+		fmt.Fprintf(w, "~ %s (%s)", p.Name, p.RelativePath)
+		if p.OldRelativePath != "" {
+			fmt.Fprintf(w, " moved from %s", p.OldRelativePath)
+		}
+		if p.OldRevision != "" {
+			fmt.Fprintf(w, " %s -> %s", p.OldRevision, p.Revision)
+		}
+		fmt.Fprintln(w)
+	}
+}
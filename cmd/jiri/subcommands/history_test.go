@@ -0,0 +1,104 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.fuchsia.dev/jiri/jiritest/xtest"
+	"go.fuchsia.dev/jiri/project"
+)
+
+func TestHistory(t *testing.T) {
+	jirix := xtest.NewX(t)
+	if err := os.MkdirAll(jirix.UpdateHistoryDir(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().Truncate(time.Second)
+	m := &project.Manifest{Version: project.ManifestVersion}
+	m.Projects = []project.Project{
+		{Name: "a", Path: filepath.Join(jirix.Root, "a"), Remote: "https://example.com/a", Revision: "rev-a1"},
+		{Name: "b", Path: filepath.Join(jirix.Root, "b"), Remote: "https://example.com/b", Revision: "rev-b"},
+	}
+	older := filepath.Join(jirix.UpdateHistoryDir(), now.Add(-time.Hour).Format(time.RFC3339))
+	if err := m.ToFile(jirix, older); err != nil {
+		t.Fatal(err)
+	}
+	m.Projects[0].Revision = "rev-a2"
+	m.Projects = m.Projects[:1]
+	newer := filepath.Join(jirix.UpdateHistoryDir(), now.Format(time.RFC3339))
+	if err := m.ToFile(jirix, newer); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := historyCmd{}
+	stdout, _, err := collectStdio(jirix, []string{"list"}, cmd.run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "~0\t" + filepath.Base(newer) + "\n~1\t" + filepath.Base(older) + "\n"
+	if stdout != want {
+		t.Errorf("list: got %q, want %q", stdout, want)
+	}
+
+	stdout, _, err = collectStdio(jirix, []string{"show", "~1"}, cmd.run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout, `name="b"`) {
+		t.Errorf("show ~1: expected project b, got:\n%s", stdout)
+	}
+
+	stdout, _, err = collectStdio(jirix, []string{"diff", "~1", "~0"}, cmd.run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"- b (b) rev-b", "~ a (a) rev-a1 -> rev-a2"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("diff: expected %q, got:\n%s", want, stdout)
+		}
+	}
+
+	// The log of the update of the newer snapshot has its summary.
+	if err := os.MkdirAll(jirix.UpdateHistoryLogDir(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	log := "some output\n\nUpdate summary:\n" + `{"snapshot": "` + filepath.Base(newer) + `", "hooks": [{"name": "gen", "project": "a", "duration": 2, "exit_code": 3, "error": "exit status 3"}], "packages": [], "operations": {"update": 1}}` + "\n"
+	if err := os.WriteFile(filepath.Join(jirix.UpdateHistoryLogDir(), filepath.Base(newer)), []byte(log), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stdout, _, err = collectStdio(jirix, []string{"show", "~0"}, cmd.run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`name="a"`, "Operations: update 1", "gen (a) exit 3 in 2.0s: exit status 3"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("show ~0: expected %q, got:\n%s", want, stdout)
+		}
+	}
+	stdout, _, err = collectStdio(jirix, []string{"show", "~1"}, cmd.run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(stdout, "Update summary:") {
+		t.Errorf("show ~1: expected no summary, got:\n%s", stdout)
+	}
+	jsonCmd := historyCmd{jsonOutput: true}
+	stdout, _, err = collectStdio(jirix, []string{"show", "~0"}, jsonCmd.run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout, `"exit_code": 3`) {
+		t.Errorf("show -json ~0: expected the summary, got:\n%s", stdout)
+	}
+
+	if _, _, err := collectStdio(jirix, []string{"bogus"}, cmd.run); err == nil {
+		t.Errorf("expected an error for an unknown history command")
+	}
+}
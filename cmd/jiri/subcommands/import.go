@@ -5,12 +5,17 @@
 package subcommands
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"slices"
+	"strings"
 
 	"github.com/google/subcommands"
 	"go.fuchsia.dev/jiri"
@@ -31,6 +36,10 @@ type importCmd struct {
 	revision   string
 	list       bool
 	jsonOutput string
+	replace    bool
+	// nameSet is whether -name was given, which lets -delete match
+	// imports by name alone.
+	nameSet bool
 }
 
 func (c *importCmd) Name() string     { return "import" }
@@ -51,9 +60,20 @@ Run "jiri help manifest" for details on manifests.
 
 Usage:
   jiri import <manifest> <remote>
+  jiri import -list [-json-output <file>]
+  jiri import -delete [-name <name>] [<manifest> [<remote>]]
+  jiri import -replace -name <name> <manifest> <remote>
 
 <manifest> specifies the manifest file to use.
 <remote> specifies the remote manifest repository.
+
+-list prints the imports of .jiri_manifest with their remotes and revisions.
+-delete removes the imports matching the arguments, or all the imports named
+-name if no argument is given. -replace replaces the import named -name with
+the given one in a single write.
+
+The existing .jiri_manifest is edited in place: its comments, the order of
+its elements and the formatting of the other imports are kept.
 `
 }
 
@@ -64,12 +84,18 @@ func (c *importCmd) SetFlags(f *flag.FlagSet) {
 	f.StringVar(&c.root, "manifest-root", "", `Root to store the manifest project locally.`)
 	f.BoolVar(&c.overwrite, "overwrite", false, `Write a new .jiri_manifest file with the given specification.  If it already exists, the existing content will be ignored and the file will be overwritten.`)
 	f.StringVar(&c.out, "out", "", `The output file.  Uses <root>/.jiri_manifest if unspecified.  Uses stdout if set to "-".`)
-	f.BoolVar(&c.delete, "delete", false, `Delete existing import. Import is matched using <manifest>, <remote> and name. <remote> is optional.`)
+	f.BoolVar(&c.delete, "delete", false, `Delete existing import. Import is matched using <manifest>, <remote> and name. <manifest> and <remote> are optional if -name is given.`)
+	f.BoolVar(&c.replace, "replace", false, `Replace the import matched by name with the given specification, keeping its place in the file.`)
 	f.BoolVar(&c.list, "list", false, `List all the imports from .jiri_manifest. This flag doesn't accept any arguments. -json-out flag can be used to specify json output file.`)
 	f.StringVar(&c.jsonOutput, "json-output", "", `Json output file from -list flag.`)
 }
 
 func (c *importCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	f.Visit(func(fl *flag.Flag) {
+		if fl.Name == "name" {
+			c.nameSet = true
+		}
+	})
 	return executeWrapper(ctx, c.run, c.topLevelFlags, f.Args())
 }
 
@@ -83,11 +109,14 @@ func (c *importCmd) run(jirix *jiri.X, args []string) error {
 	if c.delete && c.list {
 		return jirix.UsageErrorf("cannot use -delete and -list together")
 	}
+	if c.replace && (c.delete || c.list || c.overwrite) {
+		return jirix.UsageErrorf("cannot use -replace with -delete, -list or -overwrite")
+	}
 
 	if c.list && len(args) != 0 {
 		return jirix.UsageErrorf("wrong number of arguments with list flag: %v", len(args))
 	}
-	if c.delete && len(args) != 1 && len(args) != 2 {
+	if c.delete && (len(args) > 2 || (len(args) == 0 && !c.nameSet)) {
 		return jirix.UsageErrorf("wrong number of arguments with delete flag")
 	} else if !c.delete && !c.list && len(args) != 2 {
 		return jirix.UsageErrorf("wrong number of arguments")
@@ -95,6 +124,7 @@ func (c *importCmd) run(jirix *jiri.X, args []string) error {
 
 	// Initialize manifest.
 	var manifest *project.Manifest
+	var content []byte
 	manifestExists, err := isFile(jirix.JiriManifestFile())
 	if err != nil {
 		return err
@@ -105,6 +135,9 @@ func (c *importCmd) run(jirix *jiri.X, args []string) error {
 			return err
 		}
 		manifest = m
+		if content, err = os.ReadFile(jirix.JiriManifestFile()); err != nil {
+			return err
+		}
 	}
 	if manifest == nil {
 		manifest = &project.Manifest{}
@@ -132,24 +165,21 @@ func (c *importCmd) run(jirix *jiri.X, args []string) error {
 		}
 	}
 
+	var edits []importEdit
 	if c.delete {
-		var tempImports []project.Import
 		deletedImports := make(map[string]project.Import)
-		for _, imp := range manifest.Imports {
-			if imp.Manifest == args[0] && imp.Name == c.name {
-				match := true
-				if len(args) == 2 {
-					match = false
-					if imp.Remote == args[1] {
-						match = true
-					}
-				}
-				if match {
-					deletedImports[imp.Name+"~"+imp.Manifest+"~"+imp.Remote] = imp
-					continue
-				}
+		for i, imp := range manifest.Imports {
+			if imp.Name != c.name {
+				continue
+			}
+			if len(args) > 0 && imp.Manifest != args[0] {
+				continue
+			}
+			if len(args) == 2 && imp.Remote != args[1] {
+				continue
 			}
-			tempImports = append(tempImports, imp)
+			deletedImports[imp.Name+"~"+imp.Manifest+"~"+imp.Remote] = imp
+			edits = append(edits, importEdit{index: i})
 		}
 		if len(deletedImports) > 1 {
 			return fmt.Errorf("More than 1 import meets your criteria. Please provide remote.")
@@ -164,25 +194,54 @@ func (c *importCmd) run(jirix *jiri.X, args []string) error {
 			}
 			jirix.Logger.Infof("Deleted one import:\n%s", string(data))
 		}
-		manifest.Imports = tempImports
 	} else {
-		for _, imp := range manifest.Imports {
-			if imp.Manifest == args[0] && imp.Remote == args[1] && imp.Name == c.name {
-				//Already exists, skip
-				jirix.Logger.Debugf("Skip import. Duplicate entry")
-				return nil
-			}
-		}
 		// There's not much error checking when writing the .jiri_manifest file;
 		// errors will be reported when "jiri update" is run.
-		manifest.Imports = append(manifest.Imports, project.Import{
+		newImport := project.Import{
 			Manifest:     args[0],
 			Name:         c.name,
 			Remote:       args[1],
 			RemoteBranch: c.remoteBranch,
 			Revision:     c.revision,
 			Root:         c.root,
-		})
+		}
+		if c.replace {
+			index := -1
+			for i, imp := range manifest.Imports {
+				if imp.Name == c.name {
+					if index != -1 {
+						return fmt.Errorf("more than 1 import is named %q", c.name)
+					}
+					index = i
+				}
+			}
+			if index == -1 {
+				return fmt.Errorf("no import is named %q", c.name)
+			}
+			edits = append(edits, importEdit{index: index, imp: &newImport})
+		} else {
+			for _, imp := range manifest.Imports {
+				if imp.Manifest == args[0] && imp.Remote == args[1] && imp.Name == c.name {
+					//Already exists, skip
+					jirix.Logger.Debugf("Skip import. Duplicate entry")
+					return nil
+				}
+			}
+			edits = append(edits, importEdit{index: -1, imp: &newImport})
+		}
+	}
+
+	// Edit the content of the existing manifest in place, to keep its
+	// comments and formatting, unless it can't be.
+	data, err := editImports(content, manifest.Imports, edits)
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		manifest.Imports = applyImportEdits(manifest.Imports, edits)
+		if data, err = manifest.ToBytes(); err != nil {
+			return err
+		}
 	}
 
 	// Write output to stdout or file.
@@ -191,14 +250,10 @@ func (c *importCmd) run(jirix *jiri.X, args []string) error {
 		outFile = jirix.JiriManifestFile()
 	}
 	if outFile == "-" {
-		bytes, err := manifest.ToBytes()
-		if err != nil {
-			return err
-		}
-		_, err = jirix.Stdout().Write(bytes)
+		_, err = jirix.Stdout().Write(data)
 		return err
 	}
-	return manifest.ToFile(jirix, outFile)
+	return project.SafeWriteFile(jirix, outFile, data)
 }
 
 func isFile(file string) (bool, error) {
@@ -237,3 +292,189 @@ func getListObject(imports []project.Import) []Import {
 	}
 	return arr
 }
+
+// importEdit is a change to the imports of a manifest: it deletes the import
+// at index if imp is nil, replaces it with imp otherwise, or adds imp if index
+// is -1.
+type importEdit struct {
+	index int
+	imp   *project.Import
+}
+
+// applyImportEdits returns imports with edits applied.
+func applyImportEdits(imports []project.Import, edits []importEdit) []project.Import {
+	var result []project.Import
+	for i, imp := range imports {
+		n := slices.IndexFunc(edits, func(e importEdit) bool { return e.index == i })
+		if n == -1 {
+			result = append(result, imp)
+		} else if edits[n].imp != nil {
+			result = append(result, *edits[n].imp)
+		}
+	}
+	for _, e := range edits {
+		if e.index == -1 {
+			result = append(result, *e.imp)
+		}
+	}
+	return result
+}
+
+// importElement is an <import> element of the <imports> of a manifest, at
+// content[start:end].
+type importElement struct {
+	imp        project.Import
+	start, end int
+}
+
+// parseImportElements returns the <import> elements of the <imports> of the
+// manifest content, the offset of the last </imports> end tag and the offset
+// after the <manifest> start tag. The offsets are -1 if there is no such tag.
+func parseImportElements(content []byte) ([]importElement, int, int, error) {
+	var elems []importElement
+	importsEnd, manifestStart := -1, -1
+	var stack []string
+	d := xml.NewDecoder(bytes.NewReader(content))
+	for {
+		off := int(d.InputOffset())
+		tok, err := d.Token()
+		if errors.Is(err, io.EOF) {
+			return elems, importsEnd, manifestStart, nil
+		} else if err != nil {
+			return nil, -1, -1, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "import" && slices.Equal(stack, []string{"manifest", "imports"}) {
+				var imp project.Import
+				if err := d.DecodeElement(&imp, &t); err != nil {
+					return nil, -1, -1, err
+				}
+				elems = append(elems, importElement{imp, off, int(d.InputOffset())})
+				continue
+			}
+			stack = append(stack, t.Name.Local)
+			if len(stack) == 1 && t.Name.Local == "manifest" && !bytes.HasSuffix(content[:d.InputOffset()], []byte("/>")) {
+				manifestStart = int(d.InputOffset())
+			}
+		case xml.EndElement:
+			if slices.Equal(stack, []string{"manifest", "imports"}) {
+				importsEnd = off
+			}
+			stack = stack[:len(stack)-1]
+		}
+	}
+}
+
+// editImports returns content, the existing manifest whose imports are
+// imports, with edits applied to the text of its <import> elements, so that
+// the rest of the file is unchanged. It returns nil if content is empty or
+// can't be edited that way.
+func editImports(content []byte, imports []project.Import, edits []importEdit) ([]byte, error) {
+	if len(content) == 0 {
+		return nil, nil
+	}
+	elems, importsEnd, manifestStart, err := parseImportElements(content)
+	if err != nil || len(elems) != len(imports) {
+		return nil, nil
+	}
+	text := string(content)
+	// Edit from the end, so that the offsets of the elements before stay
+	// valid.
+	for i := len(elems) - 1; i >= 0; i-- {
+		n := slices.IndexFunc(edits, func(e importEdit) bool { return e.index == i })
+		if n == -1 {
+			continue
+		}
+		if edits[n].imp == nil {
+			start, end := lineExtent(text, elems[i].start, elems[i].end)
+			text = text[:start] + text[end:]
+			continue
+		}
+		elem, err := importElementText(*edits[n].imp)
+		if err != nil {
+			return nil, err
+		}
+		text = text[:elems[i].start] + elem + text[elems[i].end:]
+	}
+	var added []string
+	for _, e := range edits {
+		if e.index == -1 {
+			elem, err := importElementText(*e.imp)
+			if err != nil {
+				return nil, err
+			}
+			added = append(added, elem)
+		}
+	}
+	if len(added) == 0 {
+		return []byte(text), nil
+	}
+
+	// The offsets after the edited elements changed, so parse them again.
+	elems, importsEnd, manifestStart, err = parseImportElements([]byte(text))
+	if err != nil {
+		return nil, nil
+	}
+	if importsEnd == -1 {
+		if manifestStart == -1 {
+			return nil, nil
+		}
+		block := "\n  <imports>"
+		for _, elem := range added {
+			block += "\n    " + elem
+		}
+		return []byte(text[:manifestStart] + block + "\n  </imports>" + text[manifestStart:]), nil
+	}
+	indent := "    "
+	if len(elems) > 0 {
+		indent = lineIndent(text, elems[len(elems)-1].start)
+	}
+	lineStart := strings.LastIndexByte(text[:importsEnd], '\n') + 1
+	var insert string
+	if strings.TrimSpace(text[lineStart:importsEnd]) == "" {
+		for _, elem := range added {
+			insert += indent + elem + "\n"
+		}
+		return []byte(text[:lineStart] + insert + text[lineStart:]), nil
+	}
+	for _, elem := range added {
+		insert += elem
+	}
+	return []byte(text[:importsEnd] + insert + text[importsEnd:]), nil
+}
+
+// importElementText returns imp as an <import> element.
+func importElementText(imp project.Import) (string, error) {
+	imp.RemoveDefaults()
+	data, err := xml.Marshal(imp)
+	if err != nil {
+		return "", err
+	}
+	return strings.Replace(string(data), "></import>", "/>", 1), nil
+}
+
+// lineIndent returns the whitespace before text[pos] on its line, or "" if
+// there is something else before it.
+func lineIndent(text string, pos int) string {
+	lineStart := strings.LastIndexByte(text[:pos], '\n') + 1
+	if indent := text[lineStart:pos]; strings.TrimSpace(indent) == "" {
+		return indent
+	}
+	return ""
+}
+
+// lineExtent extends text[start:end] to the whole lines it is on if there is
+// only whitespace around it on these lines, so that deleting it leaves no
+// blank line.
+func lineExtent(text string, start, end int) (int, int) {
+	lineStart := strings.LastIndexByte(text[:start], '\n') + 1
+	lineEnd := len(text)
+	if i := strings.IndexByte(text[end:], '\n'); i != -1 {
+		lineEnd = end + i + 1
+	}
+	if strings.TrimSpace(text[lineStart:start]) == "" && strings.TrimSpace(text[end:lineEnd]) == "" {
+		return lineStart, lineEnd
+	}
+	return start, end
+}
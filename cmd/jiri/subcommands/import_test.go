@@ -332,6 +332,88 @@ func TestImport(t *testing.T) {
     <import manifest="foo" name="manifest" remote="https://github.com/orig.git"/>
   </imports>
 </manifest>
+`,
+		},
+		{
+			Name:    "delete by name",
+			Flags:   importCmd{delete: true, name: "foo", nameSet: true},
+			runOnce: true,
+			Exist: `<manifest>
+  <imports>
+    <import manifest="bar" name="bar" remote="https://github.com/orig.git"/>
+    <!-- The foo manifest. -->
+    <import manifest="foo" name="foo" remote="https://github.com/orig.git"/>
+  </imports>
+</manifest>
+`,
+			Want: `<manifest>
+  <imports>
+    <import manifest="bar" name="bar" remote="https://github.com/orig.git"/>
+    <!-- The foo manifest. -->
+  </imports>
+</manifest>
+`,
+		},
+		{
+			Name:  "replace",
+			Flags: importCmd{replace: true, name: "foo", revision: "abc"},
+			Args:  []string{"foo2", "https://github.com/new.git"},
+			Exist: `<manifest>
+  <!-- Imports. -->
+  <imports>
+    <import manifest="foo" name="foo" remote="https://github.com/orig.git"/>
+    <import name="bar"
+            manifest="bar"
+            remote="https://github.com/orig.git"/>
+  </imports>
+  <projects>
+    <project name="p" path="p" remote="https://github.com/p.git"/>
+  </projects>
+</manifest>
+`,
+			Want: `<manifest>
+  <!-- Imports. -->
+  <imports>
+    <import manifest="foo2" name="foo" remote="https://github.com/new.git" revision="abc"/>
+    <import name="bar"
+            manifest="bar"
+            remote="https://github.com/orig.git"/>
+  </imports>
+  <projects>
+    <project name="p" path="p" remote="https://github.com/p.git"/>
+  </projects>
+</manifest>
+`,
+		},
+		{
+			Name:    "replace missing",
+			Flags:   importCmd{replace: true, name: "foo"},
+			Args:    []string{"foo", "https://github.com/new.git"},
+			runOnce: true,
+			Exist: `<manifest>
+</manifest>
+`,
+			WantErr: `no import is named "foo"`,
+		},
+		{
+			Name: "import keeps comments",
+			Args: []string{"foo", "https://github.com/new.git"},
+			Exist: `<manifest>
+  <!-- Local projects. -->
+  <projects>
+    <project name="p" path="p" remote="https://github.com/p.git"/>
+  </projects>
+</manifest>
+`,
+			Want: `<manifest>
+  <imports>
+    <import manifest="foo" name="manifest" remote="https://github.com/new.git"/>
+  </imports>
+  <!-- Local projects. -->
+  <projects>
+    <project name="p" path="p" remote="https://github.com/p.git"/>
+  </projects>
+</manifest>
 `,
 		},
 	}
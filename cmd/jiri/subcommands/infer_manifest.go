@@ -0,0 +1,226 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"context"
+	"flag"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/subcommands"
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/gitutil"
+	"go.fuchsia.dev/jiri/project"
+)
+
+type inferManifestCmd struct {
+	cmdBase
+
+	output     string
+	pin        bool
+	attributes arrayFlag
+}
+
+func (c *inferManifestCmd) Name() string { return "infer-manifest" }
+func (c *inferManifestCmd) Synopsis() string {
+	return "Generate a manifest from existing git checkouts"
+}
+func (c *inferManifestCmd) Usage() string {
+	return `Generates a manifest describing the git repositories found under a directory,
+to adopt jiri on a workspace that was checked out by other means. Run
+"jiri init" in the workspace first, then "jiri infer-manifest" to print the
+manifest.
+
+For each repository, the project name is inferred from the path of its
+"origin" remote, and the remote branch from the upstream of its current
+branch. Paths are relative to the jiri root. Repositories without an "origin"
+remote are skipped.
+
+Usage:
+  jiri infer-manifest [flags] [<dir>]
+
+<dir> is the directory to search, the jiri root by default.
+`
+}
+
+func (c *inferManifestCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&c.output, "output", "", "File to write the manifest to, instead of stdout.")
+	f.BoolVar(&c.pin, "pin", true, "Pin each project to its current revision.")
+	f.Var(&c.attributes, "attribute", "Set attributes on projects whose path matches a pattern, in the form <pattern>=<attr1>,<attr2>. The pattern uses path.Match syntax and also matches the projects below a matching directory. Repeatable.")
+}
+
+func (c *inferManifestCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	return executeWrapper(ctx, c.run, c.topLevelFlags, f.Args())
+}
+
+// attributeRule assigns attributes to the projects matching a path pattern.
+type attributeRule struct {
+	pattern    string
+	attributes string
+}
+
+func (c *inferManifestCmd) run(jirix *jiri.X, args []string) error {
+	if len(args) > 1 {
+		return jirix.UsageErrorf("wrong number of arguments")
+	}
+	dir := jirix.Root
+	if len(args) == 1 {
+		var err error
+		if dir, err = filepath.Abs(args[0]); err != nil {
+			return err
+		}
+	}
+	var rules []attributeRule
+	for _, a := range c.attributes {
+		pattern, attrs, ok := strings.Cut(a, "=")
+		if !ok || pattern == "" || attrs == "" {
+			return jirix.UsageErrorf("bad -attribute %q, should be <pattern>=<attributes>", a)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return jirix.UsageErrorf("bad -attribute pattern %q: %v", pattern, err)
+		}
+		rules = append(rules, attributeRule{pattern, attrs})
+	}
+
+	repos, err := findGitRepos(dir)
+	if err != nil {
+		return err
+	}
+	manifest := project.Manifest{}
+	names := make(map[string]bool)
+	for _, repo := range repos {
+		p, err := c.inferProject(jirix, repo)
+		if err != nil {
+			return err
+		}
+		if p == nil {
+			continue
+		}
+		// Project keys must be unique, use the path to tell apart several
+		// checkouts of the same remote.
+		if names[p.Name+p.Remote] {
+			p.Name = p.Path
+		}
+		names[p.Name+p.Remote] = true
+		p.Attributes = matchAttributes(rules, p.Path)
+		manifest.Projects = append(manifest.Projects, *p)
+	}
+	data, err := manifest.ToBytes()
+	if err != nil {
+		return err
+	}
+	if c.output != "" {
+		return project.SafeWriteFile(jirix, c.output, data)
+	}
+	_, err = jirix.Stdout().Write(data)
+	return err
+}
+
+// findGitRepos returns the directories of the git repositories under dir,
+// including nested ones, sorted by path.
+func findGitRepos(dir string) ([]string, error) {
+	var repos []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		switch d.Name() {
+		case ".git":
+			repos = append(repos, filepath.Dir(p))
+			return filepath.SkipDir
+		case jiri.RootMetaDir:
+			return filepath.SkipDir
+		}
+		// Repositories whose .git is a file, e.g. worktrees and submodules.
+		if fi, err := os.Stat(filepath.Join(p, ".git")); err == nil && !fi.IsDir() {
+			repos = append(repos, p)
+		}
+		return nil
+	})
+	sort.Strings(repos)
+	return repos, err
+}
+
+// inferProject returns the project checked out in dir, or nil if it should be
+// skipped.
+func (c *inferManifestCmd) inferProject(jirix *jiri.X, dir string) (*project.Project, error) {
+	rel, err := filepath.Rel(jirix.Root, dir)
+	if err != nil {
+		return nil, err
+	}
+	scm := gitutil.New(jirix, gitutil.RootDirOpt(dir))
+	remote, err := scm.RemoteUrl("origin")
+	if err != nil || remote == "" {
+		jirix.Logger.Warningf("Skipping %s as it has no \"origin\" remote\n\n", rel)
+		return nil, nil
+	}
+	p := &project.Project{
+		Name:   inferProjectName(remote),
+		Path:   filepath.ToSlash(rel),
+		Remote: remote,
+	}
+	if p.Name == "" {
+		p.Name = p.Path
+	}
+	if branch, err := scm.RemoteBranchName(); err == nil && branch != "main" {
+		p.RemoteBranch = branch
+	}
+	if c.pin {
+		if p.Revision, err = scm.CurrentRevision(); err != nil {
+			jirix.Logger.Warningf("Not pinning %s as its revision is unknown: %v\n\n", rel, err)
+			p.Revision = ""
+		}
+	}
+	return p, nil
+}
+
+// inferProjectName returns the path of remote without its ".git" suffix, e.g.
+// "foo/bar" for "https://host/foo/bar.git" or "git@host:foo/bar.git".
+func inferProjectName(remote string) string {
+	name := remote
+	if u, err := url.Parse(remote); err == nil && u.Scheme != "" {
+		name = u.Path
+	} else if _, p, ok := strings.Cut(remote, ":"); ok && !strings.Contains(remote[:len(remote)-len(p)], "/") {
+		// scp-like syntax, e.g. "git@host:foo/bar".
+		name = p
+	}
+	name = strings.TrimSuffix(strings.Trim(name, "/"), ".git")
+	// Drop "/a/" used by Gerrit for authenticated access.
+	name = strings.TrimPrefix(name, "a/")
+	if name == "" || name == "." {
+		return ""
+	}
+	return path.Clean(name)
+}
+
+// matchAttributes returns the comma separated attributes of the rules that
+// match rel or one of its parent directories.
+func matchAttributes(rules []attributeRule, rel string) string {
+	var attrs []string
+	seen := make(map[string]bool)
+	for _, r := range rules {
+		for p := rel; p != "." && p != "/" && p != ""; p = path.Dir(p) {
+			if ok, _ := path.Match(r.pattern, p); ok {
+				for _, a := range strings.Split(r.attributes, ",") {
+					if a = strings.TrimSpace(a); a != "" && !seen[a] {
+						seen[a] = true
+						attrs = append(attrs, a)
+					}
+				}
+				break
+			}
+		}
+	}
+	return strings.Join(attrs, ",")
+}
@@ -0,0 +1,80 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"path/filepath"
+	"testing"
+
+	"go.fuchsia.dev/jiri/gitutil"
+	"go.fuchsia.dev/jiri/project"
+)
+
+func TestInferProjectName(t *testing.T) {
+	tests := []struct {
+		remote, want string
+	}{
+		{"https://fuchsia.googlesource.com/fuchsia", "fuchsia"},
+		{"https://fuchsia.googlesource.com/a/third_party/foo.git", "third_party/foo"},
+		{"sso://fuchsia/integration/", "integration"},
+		{"git@github.com:org/repo.git", "org/repo"},
+		{"/srv/git/repo", "srv/git/repo"},
+		{"https://example.com/", ""},
+	}
+	for _, test := range tests {
+		if got := inferProjectName(test.remote); got != test.want {
+			t.Errorf("inferProjectName(%q) = %q, want %q", test.remote, got, test.want)
+		}
+	}
+}
+
+func TestInferManifest(t *testing.T) {
+	localProjects, fake := setupUniverse(t)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(t.TempDir(), "manifest")
+	cmd := inferManifestCmd{output: output, pin: true, attributes: arrayFlag{"path-0=foo,bar"}}
+	if err := cmd.run(fake.X, nil); err != nil {
+		t.Fatal(err)
+	}
+	m, err := project.ManifestFromFile(fake.X, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inferred := make(map[string]project.Project)
+	for _, p := range m.Projects {
+		inferred[p.Path] = p
+	}
+	for _, want := range localProjects {
+		rel, err := filepath.Rel(fake.X.Root, want.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok := inferred[filepath.ToSlash(rel)]
+		if !ok {
+			t.Errorf("project %s at %s was not inferred", want.Name, rel)
+			continue
+		}
+		if got.Remote != want.Remote {
+			t.Errorf("project at %s: got remote %q, want %q", rel, got.Remote, want.Remote)
+		}
+		rev, err := gitutil.New(fake.X, gitutil.RootDirOpt(want.Path)).CurrentRevision()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Revision != rev {
+			t.Errorf("project at %s: got revision %q, want %q", rel, got.Revision, rev)
+		}
+		// path-6 is nested in path-0, so it matches the pattern too.
+		wantAttrs := ""
+		if rel == "path-0" || rel == filepath.Join("path-0", "path-6") {
+			wantAttrs = "foo,bar"
+		}
+		if got.Attributes != wantAttrs {
+			t.Errorf("project at %s: got attributes %q, want %q", rel, got.Attributes, wantAttrs)
+		}
+	}
+}
@@ -6,13 +6,13 @@ package subcommands
 
 import (
 	"context"
-	"crypto/rand"
 	"flag"
 	"fmt"
-	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"strconv"
+	"strings"
 
 	"github.com/google/subcommands"
 	"go.fuchsia.dev/jiri"
@@ -41,9 +41,28 @@ type initCmd struct {
 	partial           bool
 	partialSkip       arrayFlag
 	offloadPackfiles  bool
+	bundleURI         string
 	cipdParanoid      string
 	cipdMaxThreads    int
+	cipdCacheDir      string
 	excludeDirs       arrayFlag
+	urlRewrites       arrayFlag
+	projectRewrites   arrayFlag
+	hostLimits        arrayFlag
+	historyKeep       int
+	historyKeepDays   int
+	groups            string
+	excludedGroups    string
+	gitAttributesFile string
+	codeOwnersFile    string
+	reviewNotes       string
+	enableSubmodules  string
+	reuseConnections  string
+	allowNested       string
+	codeOwners        arrayFlag
+	profile           string
+	profileRemote     string
+	profileFile       string
 }
 
 func (c *initCmd) Name() string     { return "init" }
@@ -59,6 +78,42 @@ Usage:
 
 If you provide a directory, the command is run inside it. If this directory
 does not exists, it will be created.
+
+With -profile, init sets up the root from a named profile of the manifest
+repository given with -profile-remote. The profiles are defined in the JSON
+file of the repository given by -profile-file, e.g.
+
+  {
+    "profiles": {
+      "minimal": {
+        "description": "Only what is needed to build",
+        "imports": [{"manifest": "minimal", "name": "integration"}],
+        "fetch_optional": "tools",
+        "groups": "build"
+      }
+    }
+  }
+
+The imports of the profile are added to .jiri_manifest, their remote
+defaulting to the -profile-remote repository. "fetch_optional", "groups" and
+"exclude_groups" are the defaults of the -fetch-optional, -groups and
+-exclude-groups flags.
+
+With -reuse-connections=true, the git commands run by jiri open one SSH
+connection per host, which the following commands reuse, instead of each
+negotiating its own. The connection stays open for a minute after the last
+command, with its control socket in ~/.ssh. This only applies to SSH remotes,
+when GIT_SSH_COMMAND and GIT_SSH are not set, and not on Windows. Commands
+beyond the sessions a server allows per connection, 10 by default for
+OpenSSH, open their own connection. Compare the host totals of
+"jiri update -profile" with and without it to measure the gain.
+
+A root cannot be created inside another jiri root, e.g. by running "jiri init"
+in one of its projects, as the projects of the outer root would be scanned as
+part of the inner one and the other way around. Jiri commands also refuse to
+run in such a root. Pass -allow-nested=true if the nesting is intended: the
+outer root then leaves the inner root, and any project containing it, out of
+its scans.
 `
 }
 
@@ -80,20 +135,44 @@ func (c *initCmd) SetFlags(f *flag.FlagSet) {
 	f.BoolVar(&c.partial, "partial", false, "Whether to use a partial checkout.")
 	f.Var(&c.partialSkip, "skip-partial", "Skip using partial checkouts for these remotes.")
 	f.BoolVar(&c.offloadPackfiles, "offload-packfiles", true, "Whether to use a CDN for packfiles if available.")
+	f.StringVar(&c.bundleURI, "bundle-uri", "", "Whether new clones should use the bundles advertised by git servers. Takes true/false.")
 	f.StringVar(&c.cipdParanoid, "cipd-paranoid-mode", "", "Whether to use paranoid mode in cipd.")
 	// Default (0) causes CIPD to use as many threads as there are CPUs.
 	f.IntVar(&c.cipdMaxThreads, "cipd-max-threads", 0, "Number of threads to use for unpacking CIPD packages. If zero, uses all CPUs.")
+	f.StringVar(&c.cipdCacheDir, "cipd-cache-dir", "", "CIPD package cache directory, which can be shared by several roots. Defaults to the cipd directory of the jiri cache, if any.")
 	f.Var(&c.excludeDirs, "exclude-dirs", "Directories to skip when searching for local projects (Default: out).")
+	f.IntVar(&c.historyKeep, "history-keep", -1, "Number of most recent update history snapshots to keep. Zero disables this rule.")
+	f.IntVar(&c.historyKeepDays, "history-keep-days", -1, "Keep the last update history snapshot of each day for this many days. Zero disables this rule. If neither rule is set, all snapshots are kept.")
+	// As for optionalAttrs, empty strings clear the saved groups.
+	f.StringVar(&c.groups, "groups", optionalAttrsNotSet, "Comma separated manifest groups to fetch. Projects and packages of no group are always fetched.")
+	f.StringVar(&c.excludedGroups, "exclude-groups", optionalAttrsNotSet, "Comma separated manifest groups not to fetch.")
+	f.Var(&c.urlRewrites, "url-rewrite", "Rewrite remotes starting with <prefix> to start with <base> instead, in the form <prefix>=<base>. Repeatable; replaces any saved rules.")
+	f.Var(&c.projectRewrites, "project-url-rewrite", "Like -url-rewrite, for the remotes of one project only, in the form <project>=<prefix>=<base>. Rules of a project win over the other rules for the same prefix. Repeatable; replaces any saved rules.")
+	// As for optionalAttrs, empty strings stop generating the files.
+	f.StringVar(&c.gitAttributesFile, "gitattributes-file", optionalAttrsNotSet, "Path, relative to the root, of a .gitattributes file generated on update from the git attributes of projects.")
+	f.StringVar(&c.reviewNotes, "review-notes", optionalAttrsNotSet, `Comma-separated names, or globs, of the projects whose Gerrit review notes "jiri update" fetches and indexes for "jiri log -with-cl". "*" selects all projects.`)
+	f.StringVar(&c.enableSubmodules, "enable-submodules", "", `Whether "jiri status", "jiri branch" and "jiri upload" also handle the git submodules checked out in projects. Takes true/false.`)
+	f.StringVar(&c.reuseConnections, "reuse-connections", "", `Whether the git commands run by jiri share one SSH connection per host instead of each opening its own, e.g. to speed up updates over high latency links. Takes true/false.`)
+	f.StringVar(&c.allowNested, "allow-nested", "", "Whether the root may be created, and used, inside another jiri root. Takes true/false.")
+	f.StringVar(&c.codeOwnersFile, "codeowners-file", optionalAttrsNotSet, "Path, relative to the root, of a CODEOWNERS file generated on update, routing projects to the owners of their git attributes.")
+	f.Var(&c.codeOwners, "codeowner", "Give the projects with a git attribute to owners in the generated CODEOWNERS file, in the form <attribute>=<owner>[ <owner>...]. Repeatable; replaces any saved rules.")
+	f.StringVar(&c.profile, "profile", "", "Set up the root from this profile of the -profile-remote manifest repository.")
+	f.StringVar(&c.profileRemote, "profile-remote", "", "Manifest repository defining the profiles of -profile.")
+	f.StringVar(&c.profileFile, "profile-file", defaultInitProfilesFile, "File of the -profile-remote repository defining the profiles.")
+	f.Var(&c.hostLimits, "host-limit", "Limit the concurrent jobs and, optionally, the average bandwidth in bytes per second used for a remote host, in the form <host>=<jobs>[,<bandwidth>], e.g. *.googlesource.com=4,10M. Zero jobs only caps the bandwidth. Repeatable; replaces any saved limits.")
 }
 
 func (c *initCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
-	return errToExitStatus(ctx, c.run(ctx, f.Args()))
+	return errToExitStatus(ctx, c.run(ctx, f.Args()), c.topLevelFlags.ErrorFormat)
 }
 
-func (c *initCmd) run(_ context.Context, args []string) error {
+func (c *initCmd) run(ctx context.Context, args []string) error {
 	if len(args) > 1 {
 		return fmt.Errorf("wrong number of arguments")
 	}
+	if c.profile != "" && c.profileRemote == "" {
+		return fmt.Errorf("-profile requires -profile-remote")
+	}
 
 	if c.showAnalyticsData {
 		fmt.Printf("%s\n", analytics_util.CollectedData)
@@ -124,6 +203,18 @@ func (c *initCmd) run(_ context.Context, args []string) error {
 		}
 	}
 
+	allowNested := false
+	if c.allowNested != "" {
+		if allowNested, err = strconv.ParseBool(c.allowNested); err != nil {
+			return fmt.Errorf("'allow-nested' should be true or false")
+		}
+	} else if config, err := jiri.ConfigFromFile(filepath.Join(dir, jiri.RootMetaDir, jiri.ConfigFile)); err == nil {
+		allowNested = config.AllowNested
+	}
+	if outer := jiri.EnclosingRoot(dir); outer != "" && !allowNested {
+		return fmt.Errorf("%s is inside the jiri root %s: create the root elsewhere, or pass -allow-nested=true if the nesting is intended", dir, outer)
+	}
+
 	d := filepath.Join(dir, jiri.RootMetaDir)
 	if _, err := os.Stat(d); err != nil {
 		if !os.IsNotExist(err) {
@@ -157,6 +248,32 @@ func (c *initCmd) run(_ context.Context, args []string) error {
 		return err
 	}
 
+	if c.profile != "" {
+		flags := c.topLevelFlags
+		flags.Root = dir
+		jirix, err := jiri.NewXFromContext(ctx, flags)
+		if err != nil {
+			return err
+		}
+		defer jirix.RunCleanup()
+		profile, err := fetchInitProfile(jirix, c.profileRemote, c.profileFile, c.profile)
+		if err != nil {
+			return err
+		}
+		if c.optionalAttrs == optionalAttrsNotSet && profile.FetchOptional != "" {
+			c.optionalAttrs = profile.FetchOptional
+		}
+		if c.groups == optionalAttrsNotSet && profile.Groups != "" {
+			c.groups = profile.Groups
+		}
+		if c.excludedGroups == optionalAttrsNotSet && profile.ExcludeGroups != "" {
+			c.excludedGroups = profile.ExcludeGroups
+		}
+		if err := addProfileImports(jirix, profile, c.profileRemote); err != nil {
+			return err
+		}
+	}
+
 	if c.cache != "" {
 		config.CachePath = c.cache
 	}
@@ -173,6 +290,26 @@ func (c *initCmd) run(_ context.Context, args []string) error {
 		}
 	}
 
+	if c.enableSubmodules != "" {
+		if val, err := strconv.ParseBool(c.enableSubmodules); err != nil {
+			return fmt.Errorf("'enable-submodules' should be true or false")
+		} else {
+			config.EnableSubmodules = val
+		}
+	}
+
+	if c.reuseConnections != "" {
+		if val, err := strconv.ParseBool(c.reuseConnections); err != nil {
+			return fmt.Errorf("'reuse-connections' should be true or false")
+		} else {
+			config.ReuseConnections = val
+		}
+	}
+
+	if c.allowNested != "" {
+		config.AllowNested = allowNested
+	}
+
 	if c.rewriteSsoToHttps != "" {
 		if val, err := strconv.ParseBool(c.rewriteSsoToHttps); err != nil {
 			return fmt.Errorf("'rewrite-sso-to-https' should be true or false")
@@ -197,6 +334,14 @@ func (c *initCmd) run(_ context.Context, args []string) error {
 		config.OffloadPackfiles = c.offloadPackfiles
 	}
 
+	if c.bundleURI != "" {
+		if val, err := strconv.ParseBool(c.bundleURI); err != nil {
+			return fmt.Errorf("'bundle-uri' should be true or false")
+		} else {
+			config.BundleURI = val
+		}
+	}
+
 	if c.ssoCookie != "" {
 		config.SsoCookiePath = c.ssoCookie
 	}
@@ -225,6 +370,17 @@ func (c *initCmd) run(_ context.Context, args []string) error {
 
 	config.CipdMaxThreads = c.cipdMaxThreads
 
+	if c.cipdCacheDir != "" {
+		cipdCacheDir, err := filepath.Abs(c.cipdCacheDir)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(cipdCacheDir, 0755); err != nil {
+			return err
+		}
+		config.CipdCacheDir = cipdCacheDir
+	}
+
 	if c.analyticsOpt != "" {
 		if val, err := strconv.ParseBool(c.analyticsOpt); err != nil {
 			return fmt.Errorf("'analytics-opt' should be true or false")
@@ -233,15 +389,10 @@ func (c *initCmd) run(_ context.Context, args []string) error {
 				config.AnalyticsOptIn = "yes"
 				config.AnalyticsVersion = analytics_util.Version
 
-				bytes := make([]byte, 16)
-				_, err = io.ReadFull(rand.Reader, bytes)
+				config.AnalyticsUserId, err = newAnalyticsUserID()
 				if err != nil {
 					return err
 				}
-				bytes[6] = (bytes[6] & 0x0f) | 0x40
-				bytes[8] = (bytes[8] & 0x3f) | 0x80
-
-				config.AnalyticsUserId = fmt.Sprintf("%x-%x-%x-%x-%x", bytes[0:4], bytes[4:6], bytes[6:8], bytes[8:10], bytes[10:])
 			} else {
 				config.AnalyticsOptIn = "no"
 				config.AnalyticsVersion = ""
@@ -258,6 +409,84 @@ func (c *initCmd) run(_ context.Context, args []string) error {
 		config.ExcludeDirs = append(config.ExcludeDirs, r)
 	}
 
+	if len(c.urlRewrites) != 0 || len(c.projectRewrites) != 0 {
+		config.URLRewrites = nil
+	}
+
+	for _, r := range c.urlRewrites {
+		prefix, base, ok := strings.Cut(r, "=")
+		if !ok || prefix == "" {
+			return fmt.Errorf("'url-rewrite' should be in the form <prefix>=<base>, got %q", r)
+		}
+		config.URLRewrites = append(config.URLRewrites, jiri.URLRewrite{Base: base, InsteadOf: prefix})
+	}
+
+	for _, r := range c.projectRewrites {
+		name, rule, _ := strings.Cut(r, "=")
+		prefix, base, ok := strings.Cut(rule, "=")
+		if !ok || name == "" || prefix == "" {
+			return fmt.Errorf("'project-url-rewrite' should be in the form <project>=<prefix>=<base>, got %q", r)
+		}
+		config.URLRewrites = append(config.URLRewrites, jiri.URLRewrite{Base: base, InsteadOf: prefix, Project: name})
+	}
+
+	if len(c.hostLimits) != 0 {
+		config.HostLimits = nil
+	}
+
+	for _, l := range c.hostLimits {
+		limit, err := parseHostLimit(l)
+		if err != nil {
+			return err
+		}
+		config.HostLimits = append(config.HostLimits, limit)
+	}
+
+	if c.historyKeep >= 0 {
+		config.HistoryKeep = c.historyKeep
+	}
+
+	if c.historyKeepDays >= 0 {
+		config.HistoryKeepDays = c.historyKeepDays
+	}
+
+	if c.groups != optionalAttrsNotSet {
+		config.Groups = c.groups
+	}
+
+	if c.excludedGroups != optionalAttrsNotSet {
+		config.ExcludedGroups = c.excludedGroups
+	}
+
+	if c.gitAttributesFile != optionalAttrsNotSet {
+		config.GitAttributesFile = c.gitAttributesFile
+	}
+
+	if c.codeOwnersFile != optionalAttrsNotSet {
+		config.CodeOwnersFile = c.codeOwnersFile
+	}
+
+	if c.reviewNotes != optionalAttrsNotSet {
+		for _, pattern := range strings.Split(c.reviewNotes, ",") {
+			if _, err := path.Match(strings.TrimSpace(pattern), ""); err != nil {
+				return fmt.Errorf("bad pattern %q in 'review-notes': %v", pattern, err)
+			}
+		}
+		config.ReviewNotes = c.reviewNotes
+	}
+
+	if len(c.codeOwners) != 0 {
+		config.CodeOwners = nil
+	}
+
+	for _, r := range c.codeOwners {
+		attr, owners, ok := strings.Cut(r, "=")
+		if !ok || attr == "" || strings.TrimSpace(owners) == "" {
+			return fmt.Errorf("'codeowner' should be in the form <attribute>=<owner>[ <owner>...], got %q", r)
+		}
+		config.CodeOwners = append(config.CodeOwners, jiri.CodeOwnerRule{Attribute: attr, Owners: owners})
+	}
+
 	if err := config.Write(configPath); err != nil {
 		return err
 	}
@@ -265,3 +494,22 @@ func (c *initCmd) run(_ context.Context, args []string) error {
 
 	return nil
 }
+
+// parseHostLimit parses the value of a -host-limit flag.
+func parseHostLimit(s string) (jiri.HostLimit, error) {
+	host, value, ok := strings.Cut(s, "=")
+	if !ok || host == "" {
+		return jiri.HostLimit{}, fmt.Errorf("'host-limit' should be in the form <host>=<jobs>[,<bandwidth>], got %q", s)
+	}
+	jobs, bandwidth, _ := strings.Cut(value, ",")
+	n, err := strconv.ParseUint(jobs, 10, 0)
+	if err != nil {
+		return jiri.HostLimit{}, fmt.Errorf("'host-limit' %q: bad number of jobs %q", s, jobs)
+	}
+	if bandwidth != "" {
+		if _, err := jiri.ParseBandwidth(bandwidth); err != nil {
+			return jiri.HostLimit{}, fmt.Errorf("'host-limit' %q: %v", s, err)
+		}
+	}
+	return jiri.HostLimit{Host: host, Jobs: uint(n), Bandwidth: bandwidth}, nil
+}
@@ -0,0 +1,146 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/gitutil"
+	"go.fuchsia.dev/jiri/project"
+)
+
+// defaultInitProfilesFile is the file of the manifest repository defining
+// the profiles of "jiri init -profile", unless -profile-file is given.
+const defaultInitProfilesFile = "jiri_profiles.json"
+
+// initProfiles is the content of the file defining the profiles of
+// "jiri init -profile".
+type initProfiles struct {
+	Profiles map[string]initProfile `json:"profiles"`
+}
+
+// initProfile is a named set of imports and settings for a new root.
+type initProfile struct {
+	Description string              `json:"description,omitempty"`
+	Imports     []initProfileImport `json:"imports"`
+	// FetchOptional, Groups and ExcludeGroups are the defaults of the
+	// -fetch-optional, -groups and -exclude-groups flags.
+	FetchOptional string `json:"fetch_optional,omitempty"`
+	Groups        string `json:"groups,omitempty"`
+	ExcludeGroups string `json:"exclude_groups,omitempty"`
+}
+
+// initProfileImport is an import added to .jiri_manifest by a profile. Its
+// remote defaults to the manifest repository defining the profile.
+type initProfileImport struct {
+	Manifest     string `json:"manifest"`
+	Name         string `json:"name"`
+	Remote       string `json:"remote,omitempty"`
+	RemoteBranch string `json:"remotebranch,omitempty"`
+	Revision     string `json:"revision,omitempty"`
+	Root         string `json:"root,omitempty"`
+}
+
+// parseInitProfile returns the profile called name from data, the content of
+// a profiles file.
+func parseInitProfile(data []byte, name string) (initProfile, error) {
+	var profiles initProfiles
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return initProfile{}, fmt.Errorf("invalid profiles file: %v", err)
+	}
+	profile, ok := profiles.Profiles[name]
+	if !ok {
+		var names []string
+		for n := range profiles.Profiles {
+			names = append(names, n)
+		}
+		slices.Sort(names)
+		return initProfile{}, fmt.Errorf("unknown profile %q, the profiles are: %s", name, strings.Join(names, ", "))
+	}
+	for _, imp := range profile.Imports {
+		if imp.Manifest == "" || imp.Name == "" {
+			return initProfile{}, fmt.Errorf("bad import in profile %q: both manifest and name must be specified", name)
+		}
+	}
+	return profile, nil
+}
+
+// fetchInitProfile reads the profile called name from file in the manifest
+// repository at remote.
+func fetchInitProfile(jirix *jiri.X, remote, file, name string) (initProfile, error) {
+	dir, err := os.MkdirTemp("", "jiri-init-profile")
+	if err != nil {
+		return initProfile{}, err
+	}
+	defer os.RemoveAll(dir)
+	if err := gitutil.New(jirix).Clone(remote, dir, gitutil.DepthOpt(1)); err != nil {
+		return initProfile{}, fmt.Errorf("cannot fetch the profiles from %q: %v", remote, err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, file))
+	if err != nil {
+		return initProfile{}, fmt.Errorf("cannot read the profiles of %q: %v", remote, err)
+	}
+	return parseInitProfile(data, name)
+}
+
+// addProfileImports adds the imports of profile that are not already there to
+// .jiri_manifest, creating it if needed. remote is the manifest repository
+// defining the profile.
+func addProfileImports(jirix *jiri.X, profile initProfile, remote string) error {
+	manifest := &project.Manifest{}
+	var content []byte
+	exists, err := isFile(jirix.JiriManifestFile())
+	if err != nil {
+		return err
+	}
+	if exists {
+		if manifest, err = project.ManifestFromFile(jirix, jirix.JiriManifestFile()); err != nil {
+			return err
+		}
+		if content, err = os.ReadFile(jirix.JiriManifestFile()); err != nil {
+			return err
+		}
+	}
+	var edits []importEdit
+	for _, pi := range profile.Imports {
+		imp := project.Import{
+			Manifest:     pi.Manifest,
+			Name:         pi.Name,
+			Remote:       pi.Remote,
+			RemoteBranch: pi.RemoteBranch,
+			Revision:     pi.Revision,
+			Root:         pi.Root,
+		}
+		if imp.Remote == "" {
+			imp.Remote = remote
+		}
+		if slices.ContainsFunc(manifest.Imports, func(i project.Import) bool {
+			return i.Manifest == imp.Manifest && i.Remote == imp.Remote && i.Name == imp.Name
+		}) {
+			continue
+		}
+		edits = append(edits, importEdit{index: -1, imp: &imp})
+	}
+	if len(edits) == 0 && exists {
+		return nil
+	}
+	data, err := editImports(content, manifest.Imports, edits)
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		manifest.Imports = applyImportEdits(manifest.Imports, edits)
+		if data, err = manifest.ToBytes(); err != nil {
+			return err
+		}
+	}
+	return project.SafeWriteFile(jirix, jirix.JiriManifestFile(), data)
+}
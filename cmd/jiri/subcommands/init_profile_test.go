@@ -0,0 +1,70 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"go.fuchsia.dev/jiri/jiritest/xtest"
+)
+
+func TestInitProfile(t *testing.T) {
+	t.Parallel()
+
+	data := []byte(`{
+  "profiles": {
+    "minimal": {
+      "imports": [
+        {"manifest": "minimal", "name": "integration"},
+        {"manifest": "tools", "name": "tools", "remote": "https://example.com/tools"}
+      ],
+      "groups": "build"
+    },
+    "full": {"imports": [{"manifest": "full", "name": "integration"}]}
+  }
+}`)
+	if _, err := parseInitProfile(data, "unknown"); err == nil || !strings.Contains(err.Error(), "full, minimal") {
+		t.Errorf("got error %v, want the list of profiles", err)
+	}
+	profile, err := parseInitProfile(data, "minimal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Groups != "build" {
+		t.Errorf("got groups %q, want %q", profile.Groups, "build")
+	}
+
+	jirix := xtest.NewX(t)
+	existing := `<manifest>
+  <!-- Local tools. -->
+  <imports>
+    <import manifest="tools" name="tools" remote="https://example.com/tools"/>
+  </imports>
+</manifest>
+`
+	if err := os.WriteFile(jirix.JiriManifestFile(), []byte(existing), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := addProfileImports(jirix, profile, "https://example.com/integration"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(jirix.JiriManifestFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<manifest>
+  <!-- Local tools. -->
+  <imports>
+    <import manifest="tools" name="tools" remote="https://example.com/tools"/>
+    <import manifest="minimal" name="integration" remote="https://example.com/integration"/>
+  </imports>
+</manifest>
+`
+	if string(got) != want {
+		t.Errorf("got .jiri_manifest:\n%s\nwant:\n%s", got, want)
+	}
+}
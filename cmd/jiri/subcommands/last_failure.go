@@ -0,0 +1,192 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/google/subcommands"
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/project"
+)
+
+type lastFailureCmd struct {
+	cmdBase
+
+	projects   arrayFlag
+	rerun      bool
+	jsonOutput bool
+	wait       bool
+}
+
+func (c *lastFailureCmd) Name() string     { return "last-failure" }
+func (c *lastFailureCmd) Synopsis() string { return "Show or retry the failures of the last update" }
+func (c *lastFailureCmd) Usage() string {
+	return `Shows the failures of the last "jiri update" that failed: for each project,
+the operation that failed (e.g. fetch, create or update) and its error. They
+are recorded in .jiri_root/last_failure.json, and forgotten once an update
+succeeds.
+
+With -rerun, the failed operations are run again: the projects that failed
+are fetched and updated to the manifest, without updating the other projects,
+fetching packages or running hooks. They are updated with the groups,
+attributes, local manifests and the rebase, -autostash and -reset-on-force-push
+flags of the last update. The failures of an update to a snapshot cannot be
+re-run. The failures that remain are recorded as those of the last update.
+
+Usage:
+  jiri last-failure [flags]
+`
+}
+
+func (c *lastFailureCmd) SetFlags(f *flag.FlagSet) {
+	f.Var(&c.projects, "project", "Only show or re-run the failures of this project, given by name or path. Repeatable.")
+	f.BoolVar(&c.rerun, "rerun", false, "Re-run the failed operations.")
+	f.BoolVar(&c.jsonOutput, "json", false, "Print the failures as JSON.")
+	f.BoolVar(&c.wait, "wait", false, "With -rerun, wait for other jiri commands changing the root to finish instead of failing.")
+}
+
+func (c *lastFailureCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	return executeWrapper(ctx, c.run, c.topLevelFlags, f.Args())
+}
+
+func (c *lastFailureCmd) run(jirix *jiri.X, args []string) error {
+	if len(args) != 0 {
+		return jirix.UsageErrorf("unexpected arguments")
+	}
+	last, err := project.ReadLastFailure(jirix)
+	if err != nil {
+		return err
+	}
+	if last == nil {
+		if c.jsonOutput {
+			fmt.Fprintln(jirix.Stdout(), "null")
+			return nil
+		}
+		fmt.Fprintln(jirix.Stdout(), "The last update did not fail.")
+		return nil
+	}
+	var selected, others []project.UpdateFailure
+	for _, f := range last.Failures {
+		if c.matches(jirix, f) {
+			selected = append(selected, f)
+		} else {
+			others = append(others, f)
+		}
+	}
+	if c.rerun {
+		return c.rerunFailures(jirix, last, selected, others)
+	}
+	if c.jsonOutput {
+		out := *last
+		out.Failures = selected
+		data, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(jirix.Stdout(), string(data))
+		return nil
+	}
+	fmt.Fprintf(jirix.Stdout(), "Update failed at %s: %s\n", last.Time.Format("2006-01-02 15:04:05"), last.Command)
+	for _, f := range selected {
+		if f.Path == "" {
+			fmt.Fprintf(jirix.Stdout(), "* %s\n", indentLines(f.Error))
+			continue
+		}
+		relativePath, err := filepath.Rel(jirix.Cwd, f.Path)
+		if err != nil {
+			relativePath = f.Path
+		}
+		fmt.Fprintf(jirix.Stdout(), "* %s(%s): %s failed\n  %s\n", f.Project, relativePath, f.Operation, indentLines(f.Error))
+	}
+	return nil
+}
+
+// matches reports whether f is a failure of one of the -project flags, or
+// whether there are none.
+func (c *lastFailureCmd) matches(jirix *jiri.X, f project.UpdateFailure) bool {
+	if len(c.projects) == 0 {
+		return true
+	}
+	for _, p := range c.projects {
+		if p == f.Project {
+			return true
+		}
+		path := p
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(jirix.Cwd, path)
+		}
+		if filepath.Clean(path) == f.Path {
+			return true
+		}
+	}
+	return false
+}
+
+// rerunFailures updates the projects of failures with the parameters of the
+// last update, and records the failures that remain, along with others, as
+// those of the last update.
+func (c *lastFailureCmd) rerunFailures(jirix *jiri.X, last *project.LastFailure, failures, others []project.UpdateFailure) error {
+	params := last.Params
+	if params == nil {
+		return fmt.Errorf("cannot re-run the failures: the parameters of the last update were not recorded, run %q again", last.Command)
+	}
+	if params.Snapshot != "" {
+		return fmt.Errorf("cannot re-run the failures of the checkout of snapshot %s, run %q again", params.Snapshot, last.Command)
+	}
+	var paths []string
+	for _, f := range failures {
+		if f.Path != "" && !slices.Contains(paths, f.Path) {
+			paths = append(paths, f.Path)
+		}
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("no failed project operation to re-run")
+	}
+	if err := jirix.LockRoot(c.Name(), c.wait); err != nil {
+		return err
+	}
+	jirix.Attempts = 3
+	jirix.Groups = params.Groups
+	jirix.ExcludedGroups = params.ExcludedGroups
+	jirix.FetchingAttrs = params.FetchingAttrs
+	jirix.OverrideOptional = params.OverrideOptional
+	jirix.AutoStash = params.AutoStash
+	jirix.ResetOnForcePush = params.ResetOnForcePush
+	err := project.UpdateUniverse(jirix, project.UpdateUniverseParams{
+		Paths:                 paths,
+		RebaseTracked:         params.RebaseTracked,
+		RebaseUntracked:       params.RebaseUntracked,
+		RebaseAll:             params.RebaseAll,
+		LocalManifestProjects: params.LocalManifestProjects,
+		RunHookTimeout:        project.DefaultHookTimeout,
+		FetchPackagesTimeout:  project.DefaultPackageTimeout,
+	})
+	remaining := append(others, project.UpdateFailures(append([]error{err}, jirix.FailureErrors()...)...)...)
+	var recordErr error
+	if len(remaining) == 0 {
+		recordErr = project.ClearLastFailure(jirix)
+	} else {
+		recordErr = project.WriteLastFailure(jirix, "jiri last-failure -rerun", *params, remaining)
+	}
+	if recordErr != nil {
+		jirix.Logger.Errorf("Failed to record the failures of the update: %v", recordErr)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(jirix.Stdout(), "Updated %d project(s).\n", len(paths))
+	return nil
+}
+
+func indentLines(s string) string {
+	return strings.ReplaceAll(strings.TrimRight(s, "\n"), "\n", "\n  ")
+}
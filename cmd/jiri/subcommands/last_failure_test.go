@@ -0,0 +1,80 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.fuchsia.dev/jiri/gitutil"
+	"go.fuchsia.dev/jiri/project"
+)
+
+func TestLastFailure(t *testing.T) {
+	localProjects, fake := setupUniverse(t)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	fake.X.Cwd = fake.X.Root
+
+	cmd := lastFailureCmd{}
+	stdout, _, err := collectStdio(fake.X, nil, cmd.run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "The last update did not fail.\n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+
+	p1, p2 := localProjects[1], localProjects[2]
+	failures := []project.UpdateFailure{
+		{Project: p1.Name, Path: p1.Path, Operation: "fetch", Error: "fetch failed for " + p1.Name},
+		{Project: p2.Name, Path: p2.Path, Operation: "update", Error: "cannot update " + p2.Name},
+	}
+	if err := project.WriteLastFailure(fake.X, "jiri update snapshot.xml", project.UpdateParams{Snapshot: "snapshot.xml"}, failures); err != nil {
+		t.Fatal(err)
+	}
+	cmd = lastFailureCmd{rerun: true}
+	if _, _, err := collectStdio(fake.X, nil, cmd.run); err == nil || !strings.Contains(err.Error(), "snapshot.xml") {
+		t.Errorf("re-running the failures of a snapshot got error %v, want it to refuse", err)
+	}
+	params := project.UpdateParams{RebaseTracked: true, Groups: fake.X.Groups}
+	if err := project.WriteLastFailure(fake.X, "jiri update -rebase-tracked", params, failures); err != nil {
+		t.Fatal(err)
+	}
+	cmd = lastFailureCmd{projects: arrayFlag{p1.Name}}
+	stdout, _, err = collectStdio(fake.X, nil, cmd.run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "* " + p1.Name + "(path-1): fetch failed\n  fetch failed for " + p1.Name + "\n"; !strings.HasSuffix(stdout, want) {
+		t.Errorf("got:\n%s\nwant it to end with:\n%s", stdout, want)
+	}
+
+	writeReadme(t, fake.X, fake.Projects[p1.Name], "new readme")
+	remoteRev, err := gitutil.New(fake.X, gitutil.RootDirOpt(fake.Projects[p1.Name])).CurrentRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd = lastFailureCmd{projects: arrayFlag{"path-1"}, rerun: true}
+	if _, _, err := collectStdio(fake.X, nil, cmd.run); err != nil {
+		t.Fatal(err)
+	}
+	if rev, err := gitutil.New(fake.X, gitutil.RootDirOpt(p1.Path)).CurrentRevision(); err != nil {
+		t.Fatal(err)
+	} else if rev != remoteRev {
+		t.Errorf("re-run project is at %s, want %s", rev, remoteRev)
+	}
+	last, err := project.ReadLastFailure(fake.X)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last == nil || len(last.Failures) != 1 || last.Failures[0].Project != p2.Name {
+		t.Errorf("got last failure %+v, want only the failure of %s", last, p2.Name)
+	} else if last.Params == nil || !reflect.DeepEqual(*last.Params, params) {
+		t.Errorf("got parameters %+v, want those of the last update %+v", last.Params, params)
+	}
+}
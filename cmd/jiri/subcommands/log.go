@@ -0,0 +1,184 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/subcommands"
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/log"
+	"go.fuchsia.dev/jiri/project"
+)
+
+type logCmd struct {
+	cmdBase
+
+	withCL     bool
+	jsonOutput bool
+}
+
+func (c *logCmd) Name() string     { return "log" }
+func (c *logCmd) Synopsis() string { return "Print the commits between two snapshots" }
+func (c *logCmd) Usage() string {
+	return `Prints the commits of the projects updated between two snapshots, most
+recent first, read from the local checkouts.
+
+With -with-cl, each commit is annotated with the Gerrit CL it was submitted
+in, looked up offline in the index of review notes. The index is updated by
+"jiri update" for the projects selected by "jiri init -review-notes", from
+the notes Gerrit records in refs/notes/review. Commits missing from the index,
+e.g. those not submitted through Gerrit, have no CL.
+
+Usage:
+  jiri log [flags] [<snapshot-1> <snapshot-2>]
+
+<snapshot-1/2> are files or urls containing snapshots, or snapshots of the
+update history, see "jiri history". They default to "second-latest" and
+"latest".
+`
+}
+
+func (c *logCmd) SetFlags(f *flag.FlagSet) {
+	f.BoolVar(&c.withCL, "with-cl", false, "Annotate the commits with their CL, from the index of review notes.")
+	f.BoolVar(&c.jsonOutput, "json", false, "Print the commits in json format.")
+}
+
+func (c *logCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	return executeWrapper(ctx, c.run, c.topLevelFlags, f.Args())
+}
+
+// logProject is a project updated between two snapshots, as printed by
+// "jiri log".
+type logProject struct {
+	Name         string      `json:"name"`
+	RelativePath string      `json:"relative_path"`
+	OldRevision  string      `json:"old_revision"`
+	Revision     string      `json:"revision"`
+	Commits      []logCommit `json:"commits"`
+	Error        string      `json:"error,omitempty"`
+}
+
+type logCommit struct {
+	project.ChangeCommit
+	CL *project.ReviewNote `json:"cl,omitempty"`
+}
+
+func (c *logCmd) run(jirix *jiri.X, args []string) error {
+	names := []string{"second-latest", "latest"}
+	switch len(args) {
+	case 0:
+	case 2:
+		names = args
+	default:
+		return jirix.UsageErrorf("Please provide zero or two snapshots")
+	}
+	var states [2]project.Projects
+	for i, name := range names {
+		snapshot, err := resolveLogSnapshot(jirix, name)
+		if err != nil {
+			return err
+		}
+		if states[i], err = loadLogSnapshot(jirix, snapshot); err != nil {
+			return err
+		}
+	}
+
+	var projects []logProject
+	for _, change := range project.DiffStates(states[0], states[1]).Updated {
+		if !change.RevisionChanged() {
+			continue
+		}
+		p := change.New
+		rp, err := filepath.Rel(jirix.Root, p.Path)
+		if err != nil {
+			rp = p.Path
+		}
+		lp := logProject{
+			Name:         p.Name,
+			RelativePath: rp,
+			OldRevision:  change.Old.Revision,
+			Revision:     p.Revision,
+			Commits:      []logCommit{},
+		}
+		commits, err := change.Commits(jirix, 0)
+		if err != nil {
+			lp.Error = fmt.Sprintf("commits not available locally: %v", err)
+			projects = append(projects, lp)
+			continue
+		}
+		var index *project.ReviewIndex
+		if c.withCL {
+			if index, err = project.LoadReviewIndex(jirix, *p); err != nil {
+				return err
+			}
+		}
+		for _, commit := range commits {
+			lc := logCommit{ChangeCommit: commit}
+			if note, ok := index.Lookup(commit.Revision); ok {
+				lc.CL = &note
+			}
+			lp.Commits = append(lp.Commits, lc)
+		}
+		projects = append(projects, lp)
+	}
+
+	if c.jsonOutput {
+		e := json.NewEncoder(jirix.Stdout())
+		e.SetIndent("", " ")
+		return e.Encode(projects)
+	}
+	for _, p := range projects {
+		fmt.Fprintf(jirix.Stdout(), "* project %s (%s) %s..%s\n", p.Name, p.RelativePath, shortRevision(p.OldRevision), shortRevision(p.Revision))
+		if p.Error != "" {
+			fmt.Fprintf(jirix.Stdout(), "  %s\n", jirix.Color.Red(p.Error))
+		}
+		for _, commit := range p.Commits {
+			line := fmt.Sprintf("  %s %s (%s)", jirix.Color.Yellow(shortRevision(commit.Revision)), commit.Subject, commit.Author)
+			if c.withCL {
+				switch {
+				case commit.CL == nil:
+					line += " [no CL]"
+				case commit.CL.URL != "":
+					line += fmt.Sprintf(" [%s]", jirix.Color.Green(commit.CL.URL))
+				default:
+					line += " [CL unknown]"
+				}
+			}
+			fmt.Fprintln(jirix.Stdout(), line)
+		}
+	}
+	return nil
+}
+
+// resolveLogSnapshot returns the snapshot file or url called name, which
+// is either a file, a url or a snapshot of the update history.
+func resolveLogSnapshot(jirix *jiri.X, name string) (string, error) {
+	if strings.Contains(name, "://") {
+		return name, nil
+	}
+	if _, err := os.Stat(name); err == nil {
+		return name, nil
+	}
+	return project.ResolveUpdateHistory(jirix, name)
+}
+
+// loadLogSnapshot returns the projects of snapshot, without logging the
+// loading of its imports.
+func loadLogSnapshot(jirix *jiri.X, snapshot string) (project.Projects, error) {
+	oldLogger := jirix.Logger
+	defer func() {
+		jirix.Logger = oldLogger
+	}()
+	jirix.Logger = log.NewLogger(log.NoLogLevel, jirix.Color, false, 0, oldLogger.TimeLogThreshold(), nil, nil)
+	projects, _, _, err := project.LoadSnapshotFile(jirix, snapshot)
+	return projects, err
+}
@@ -0,0 +1,84 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.fuchsia.dev/jiri/gitutil"
+	"go.fuchsia.dev/jiri/project"
+)
+
+func TestLogWithCL(t *testing.T) {
+	localProjects, fake := setupUniverse(t)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	snapshot1 := filepath.Join(t.TempDir(), "snapshot-1")
+	if err := project.CreateSnapshot(fake.X, snapshot1, nil, nil, false, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Submit two commits to a project, only the first one through Gerrit,
+	// which records a review note for it.
+	remote := fake.Projects[localProjects[1].Name]
+	writeReadme(t, fake.X, remote, "reviewed change")
+	reviewed, err := gitutil.New(fake.X, gitutil.RootDirOpt(remote)).CurrentRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	note := "Code-Review+2: Jane Doe <jane@example.com>\nReviewed-on: https://review.example.com/c/project/+/12345\nBranch: refs/heads/main\n"
+	cmd := exec.Command("git", "-c", "user.name=Jane Doe", "-c", "user.email=jane@example.com", "notes", "--ref=review", "add", "-m", note, reviewed)
+	cmd.Dir = remote
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("adding a review note: %v\n%s", err, out)
+	}
+	writeReadme(t, fake.X, remote, "direct push")
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	snapshot2 := filepath.Join(t.TempDir(), "snapshot-2")
+	if err := project.CreateSnapshot(fake.X, snapshot2, nil, nil, false, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	fake.X.ReviewNotes = localProjects[1].Name
+	localProjectsByKey, err := project.LocalProjects(fake.X, project.FastScan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := project.UpdateReviewIndexes(fake.X, localProjectsByKey); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, _, err := collectStdio(fake.X, []string{snapshot1, snapshot2}, (&logCmd{withCL: true}).run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected a project and two commits, got:\n%s", stdout)
+	}
+	if !strings.HasPrefix(lines[0], "* project "+localProjects[1].Name) {
+		t.Errorf("wrong project line %q", lines[0])
+	}
+	if !strings.HasSuffix(lines[1], "[no CL]") {
+		t.Errorf("the direct push should have no CL: %q", lines[1])
+	}
+	if !strings.Contains(lines[2], reviewed[:12]) || !strings.HasSuffix(lines[2], "[https://review.example.com/c/project/+/12345]") {
+		t.Errorf("the reviewed commit should have its CL: %q", lines[2])
+	}
+
+	index, err := project.LoadReviewIndex(fake.X, localProjects[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rn, ok := index.Lookup(reviewed); !ok || rn.Number != 12345 || rn.Branch != "refs/heads/main" {
+		t.Errorf("wrong review note of %s in the index: %+v", reviewed, rn)
+	}
+}
@@ -6,6 +6,7 @@ package subcommands
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
@@ -30,6 +31,13 @@ type manifestCmd struct {
 	// The invoker of Jiri is expected to form this template
 	// themselves.
 	Template string
+
+	// Format, if set, is the format the resolved manifest is printed in.
+	Format string
+	// localManifestProjects are the manifest projects whose local
+	// manifests are used with -format.
+	localManifestProjects arrayFlag
+	attrFlags
 }
 
 func (c *manifestCmd) Name() string { return "manifest" }
@@ -54,14 +62,28 @@ manifest -element=$PACKAGE_NAME -template="{{.Version}}"
 
 Usage:
   jiri manifest [flags] <manifest>
+  jiri manifest -format=json [flags] [<manifest>]
 
 <manifest> is the manifest file.
+
+With -format=json, the manifest, by default the .jiri_manifest of the root, is
+printed fully resolved, as "jiri update" sees it: after its imports, overrides
+and local manifests are applied, and its projects and packages are filtered by
+the optional attributes and groups of the root, or the attributes of -attrs.
+No manifest is fetched. The
+projects, packages and hooks are sorted, and each records the manifest file
+declaring it in "source", and projects the imports pulling them in in
+"import_chain", innermost first. The output is stable, for tools that need the
+manifest without reimplementing how jiri loads it.
 `
 }
 
 func (c *manifestCmd) SetFlags(f *flag.FlagSet) {
 	f.StringVar(&c.ElementName, "element", "", "Name of the <project>, <import> or <package>.")
 	f.StringVar(&c.Template, "template", "", "The template for the fields to display.")
+	f.StringVar(&c.Format, "format", "", `Print the resolved manifest in this format, only "json" is supported.`)
+	f.Var(&c.localManifestProjects, "local-manifest-project", "Import projects whose local manifests should be respected with -format. Repeatable.")
+	c.attrFlags.setFlags(f)
 }
 
 func (c *manifestCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
@@ -69,6 +91,9 @@ func (c *manifestCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) su
 }
 
 func (c *manifestCmd) run(jirix *jiri.X, args []string) error {
+	if c.Format != "" {
+		return c.printResolved(jirix, args)
+	}
 	if len(args) != 1 {
 		return jirix.UsageErrorf("Wrong number of args")
 	}
@@ -122,3 +147,38 @@ func (c *manifestCmd) readManifest(jirix *jiri.X, manifestPath string, tmpl *tem
 	// Found nothing.
 	return fmt.Errorf("found no project/import/package named %s", c.ElementName)
 }
+
+// printResolved prints the resolved manifest in the format of -format.
+func (c *manifestCmd) printResolved(jirix *jiri.X, args []string) error {
+	if c.Format != "json" {
+		return jirix.UsageErrorf("unsupported -format %q, only \"json\" is supported", c.Format)
+	}
+	if c.ElementName != "" || c.Template != "" {
+		return jirix.UsageErrorf("-format cannot be used with -element or -template")
+	}
+	if len(args) > 1 {
+		return jirix.UsageErrorf("expected at most one manifest")
+	}
+	file := jirix.JiriManifestFile()
+	if len(args) == 1 {
+		file = args[0]
+	}
+	c.attrFlags.apply(jirix)
+	localManifestProjects := []string(c.localManifestProjects)
+	if len(localManifestProjects) == 0 {
+		var err error
+		if localManifestProjects, err = getDefaultLocalManifestProjects(jirix); err != nil {
+			return err
+		}
+	}
+	m, err := project.ResolveManifest(jirix, file, localManifestProjects)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(jirix.Stdout(), "%s\n", data)
+	return err
+}
@@ -5,13 +5,18 @@
 package subcommands
 
 import (
+	"encoding/json"
 	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
 	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 	"go.fuchsia.dev/jiri/cipd"
 	"go.fuchsia.dev/jiri/jiritest"
+	"go.fuchsia.dev/jiri/project"
 )
 
 func TestManifest(t *testing.T) {
@@ -217,3 +222,112 @@ func TestManifest(t *testing.T) {
 		}
 	})
 }
+
+func TestManifestJSON(t *testing.T) {
+	t.Parallel()
+
+	fake := jiritest.NewFakeJiriRoot(t)
+	manifest := filepath.Join(fake.X.Root, "manifests", "main")
+	if err := os.MkdirAll(filepath.Dir(manifest), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(manifest, []byte(`<manifest>
+  <projects>
+    <project name="b" path="path/b" remote="https://example.com/b" revision="rev-b"/>
+    <project name="a" path="path/a" remote="https://example.com/a" groups="g"/>
+    <project name="optional" path="path/optional" remote="https://example.com/optional" attributes="extra"/>
+    <project name="full" path="path/full" remote="https://example.com/full" remotebranch="dev" remotename="upstream"
+             revision="rev-full" shallowsince="2024-01-01" bundleurl="https://example.com/full.bundle"
+             refspecs="refs/tags/v*" gerrithost="https://review.example.com" reviewers="r@example.com" hashtags="h"
+             description="Full project" owners="o@example.com" githooks="hooks/full" rebase="never"
+             gitconfig="core.fsmonitor=true" allowhooks="true" flag="flags/full|1|0">
+      <copyfile src="a.txt" dest="copied/a.txt"/>
+      <linkfile src="dir" dest="linked/dir"/>
+      <altremote remote="https://mirror.example.com/full"/>
+    </project>
+    <project name="vendored" path="path/vendored" remote="https://example.com/vendored.tar.gz" vcs="archive"
+             sha256="0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"/>
+  </projects>
+  <hooks>
+    <hook name="setup" project="a" action="setup.sh" inputs="data/"/>
+    <hook name="extra" project="optional" action="extra.sh"/>
+  </hooks>
+</manifest>
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, _, err := collectStdio(fake.X, []string{manifest}, (&manifestCmd{Format: "json"}).run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got project.ResolvedManifest
+	if err := json.Unmarshal([]byte(stdout), &got); err != nil {
+		t.Fatalf("invalid JSON %q: %v", stdout, err)
+	}
+	want := project.ResolvedManifest{
+		Projects: []project.ResolvedProject{
+			{Name: "a", Path: "path/a", Remote: "https://example.com/a", RemoteBranch: "main", Revision: "HEAD", Groups: "g", Source: "manifests/main"},
+			{Name: "b", Path: "path/b", Remote: "https://example.com/b", RemoteBranch: "main", Revision: "rev-b", Source: "manifests/main"},
+			{
+				Name: "full", Path: "path/full", Remote: "https://example.com/full", RemoteBranch: "dev", RemoteName: "upstream",
+				Revision: "rev-full", ShallowSince: "2024-01-01", BundleURL: "https://example.com/full.bundle",
+				Refspecs: "refs/tags/v*", GerritHost: "https://review.example.com", Reviewers: "r@example.com", Hashtags: "h",
+				Description: "Full project", Owners: "o@example.com", GitHooks: "hooks/full", Rebase: "never",
+				GitConfig: "core.fsmonitor=true", AllowHooks: true, Flag: "flags/full|1|0",
+				CopyFiles:  []project.ResolvedFile{{Src: "a.txt", Dest: "copied/a.txt"}},
+				LinkFiles:  []project.ResolvedFile{{Src: "dir", Dest: "linked/dir"}},
+				AltRemotes: []string{"https://mirror.example.com/full"},
+				Source:     "manifests/main",
+			},
+			{
+				Name: "vendored", Path: "path/vendored", Remote: "https://example.com/vendored.tar.gz", RemoteBranch: "main", Revision: "HEAD",
+				VCS: "archive", SHA256: "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", Source: "manifests/main",
+			},
+		},
+		Packages: []project.ResolvedPackage{},
+		Hooks: []project.ResolvedHook{
+			{Name: "setup", Project: "a", Action: "setup.sh", Inputs: "data/", Source: "manifests/main"},
+		},
+	}
+	// The git attributes of projects are computed from the manifests
+	// declaring them.
+	for i := range want.Projects {
+		want.Projects[i].GitAttributes = "main,manifests"
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Unexpected diff (-want +got):\n%s", diff)
+	}
+	// The projects above set every attribute and element of a project,
+	// which the resolved projects all hold.
+	resolved := reflect.TypeOf(project.ResolvedProject{})
+	for _, f := range reflect.VisibleFields(reflect.TypeOf(project.Project{})) {
+		if tag := f.Tag.Get("xml"); tag == "" || tag == "-" || f.Name == "XMLName" || f.Name == "Expires" {
+			continue
+		}
+		if _, ok := resolved.FieldByName(f.Name); !ok {
+			t.Errorf("ResolvedProject has no field for Project.%s", f.Name)
+		}
+	}
+
+	// -attrs selects the optional projects instead of the attributes of
+	// the root.
+	cmd := manifestCmd{Format: "json", attrFlags: attrFlags{attrs: "extra", attrsSet: true}}
+	stdout, _, err = collectStdio(fake.X, []string{manifest}, cmd.run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = project.ResolvedManifest{}
+	if err := json.Unmarshal([]byte(stdout), &got); err != nil {
+		t.Fatalf("invalid JSON %q: %v", stdout, err)
+	}
+	want.Projects = slices.Insert(want.Projects, 3, project.ResolvedProject{Name: "optional", Path: "path/optional", Remote: "https://example.com/optional", RemoteBranch: "main", Revision: "HEAD", Attributes: "extra", GitAttributes: "main,manifests", Source: "manifests/main"})
+	want.Hooks = append(want.Hooks, project.ResolvedHook{Name: "extra", Project: "optional", Action: "extra.sh", Source: "manifests/main"})
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Unexpected diff with -attrs (-want +got):\n%s", diff)
+	}
+
+	if _, _, err := collectStdio(fake.X, []string{manifest}, (&manifestCmd{Format: "yaml"}).run); err == nil {
+		t.Errorf("expected an error for an unsupported format")
+	}
+}
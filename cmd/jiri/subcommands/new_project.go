@@ -0,0 +1,120 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/subcommands"
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/project"
+)
+
+type newProjectCmd struct {
+	cmdBase
+
+	remote       string
+	remoteBranch string
+	template     string
+	owners       string
+	manifest     string
+	createRemote string
+}
+
+func (c *newProjectCmd) Name() string     { return "new-project" }
+func (c *newProjectCmd) Synopsis() string { return "Create a new project from a template" }
+func (c *newProjectCmd) Usage() string {
+	return `Creates the git repository of a new project, with an initial commit of the
+files of a template, and adds the project to a manifest.
+
+Usage:
+  jiri new-project [flags] <name> [<path>]
+
+<name> is the name of the project, and <path> its path relative to the root,
+which defaults to <name>. The directory must not exist or be empty.
+
+The files of the -template directory are copied into the project. Files named
+*.tmpl are Go text/templates, e.g. for license headers, and are written
+without their suffix. They are executed with the fields:
+  .Name, .Path, .Remote, .RemoteBranch, .Owners, .Year
+
+-owners writes an OWNERS file with the given owners. -manifest adds a
+<project> element for the project to the given manifest file, which is left
+unchanged if the project cannot be created.
+
+-create-remote is a command run with the name and the remote of the project as
+arguments, and JIRI_ROOT in its environment, to create the remote repository,
+e.g. with the API of the code host. The initial commit is then pushed to it.
+`
+}
+
+func (c *newProjectCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&c.remote, "remote", "", "Remote url of the project (required).")
+	f.StringVar(&c.remoteBranch, "remote-branch", "main", "Remote branch of the project.")
+	f.StringVar(&c.template, "template", "", "Directory of the files to create the project with.")
+	f.StringVar(&c.owners, "owners", "", "Comma-separated owners written to the OWNERS file of the project.")
+	f.StringVar(&c.manifest, "manifest", "", "Manifest file to add the project to.")
+	f.StringVar(&c.createRemote, "create-remote", "", "Command creating the remote repository of the project.")
+}
+
+func (c *newProjectCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	return executeWrapper(ctx, c.run, c.topLevelFlags, f.Args())
+}
+
+func (c *newProjectCmd) run(jirix *jiri.X, args []string) error {
+	if len(args) != 1 && len(args) != 2 {
+		return jirix.UsageErrorf("expected a project name and an optional path")
+	}
+	if c.remote == "" {
+		return jirix.UsageErrorf("-remote is required")
+	}
+	name, rel := args[0], args[0]
+	if len(args) == 2 {
+		rel = args[1]
+	}
+	if filepath.IsAbs(rel) {
+		return jirix.UsageErrorf("the path of the project must be relative to the root")
+	}
+	params := project.NewProjectParams{
+		Project: project.Project{
+			Name:         name,
+			Path:         filepath.Join(jirix.Root, rel),
+			Remote:       c.remote,
+			RemoteBranch: c.remoteBranch,
+		},
+		CreateRemote: c.createRemote,
+	}
+	var err error
+	if c.template != "" {
+		if params.Template, err = filepath.Abs(c.template); err != nil {
+			return err
+		}
+	}
+	if c.manifest != "" {
+		if params.Manifest, err = filepath.Abs(c.manifest); err != nil {
+			return err
+		}
+	}
+	for _, owner := range strings.Split(c.owners, ",") {
+		if owner = strings.TrimSpace(owner); owner != "" {
+			params.Owners = append(params.Owners, owner)
+		}
+	}
+	if err := jirix.LockRoot(c.Name(), false); err != nil {
+		return err
+	}
+	if err := project.NewProject(jirix, params); err != nil {
+		return err
+	}
+	fmt.Fprintf(jirix.Stdout(), "Created project %s in %s\n", name, params.Project.Path)
+	if params.Manifest != "" {
+		fmt.Fprintf(jirix.Stdout(), "Added project %s to %s\n", name, params.Manifest)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.fuchsia.dev/jiri/gitutil"
+	"go.fuchsia.dev/jiri/jiritest"
+	"go.fuchsia.dev/jiri/project"
+)
+
+func TestNewProject(t *testing.T) {
+	fake := jiritest.NewFakeJiriRoot(t)
+	template := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(template, "src"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(template, "src", "main.go.tmpl"), []byte("// Copyright {{.Year}}\n// {{.Name}} at {{.Path}}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(template, "README.md"), []byte("readme\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	manifest := filepath.Join(t.TempDir(), "manifest")
+	if err := os.WriteFile(manifest, []byte("<manifest>\n  <projects>\n    <project name=\"other\" path=\"other\" remote=\"https://example.com/other\"/>\n  </projects>\n</manifest>\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := &newProjectCmd{
+		remote:       "https://example.com/foo",
+		remoteBranch: "main",
+		template:     template,
+		owners:       "a@example.com, b@example.com",
+		manifest:     manifest,
+	}
+	if _, _, err := collectStdio(fake.X, []string{"foo", "path/to/foo"}, cmd.run); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(fake.X.Root, "path", "to", "foo")
+	got, err := os.ReadFile(filepath.Join(dir, "src", "main.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("// Copyright %d\n// foo at path/to/foo\n", time.Now().Year()); string(got) != want {
+		t.Errorf("got main.go %q, want %q", got, want)
+	}
+	if got, err := os.ReadFile(filepath.Join(dir, "OWNERS")); err != nil || string(got) != "a@example.com\nb@example.com\n" {
+		t.Errorf("got OWNERS %q, %v", got, err)
+	}
+	scm := gitutil.New(fake.X, gitutil.RootDirOpt(dir))
+	if files, err := scm.FilesWithUncommittedChanges(); err != nil || len(files) != 0 {
+		t.Errorf("got uncommitted files %v, %v", files, err)
+	}
+	if msg, err := scm.CommitMsg("HEAD"); err != nil || strings.TrimSpace(msg) != "Initial commit" {
+		t.Errorf("got commit message %q, %v", msg, err)
+	}
+	if url, err := scm.RemoteUrl("origin"); err != nil || url != cmd.remote {
+		t.Errorf("got remote %q, %v", url, err)
+	}
+	if ok, err := project.IsLocalProject(fake.X, dir); err != nil || !ok {
+		t.Errorf("%s is not a local project: %v", dir, err)
+	}
+	content, err := os.ReadFile(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), `<project name="foo" path="path/to/foo" remote="https://example.com/foo"/>`) {
+		t.Errorf("project not added to the manifest:\n%s", content)
+	}
+
+	// A project already in the manifest is not created.
+	if _, _, err := collectStdio(fake.X, []string{"other", "new/other"}, cmd.run); err == nil {
+		t.Fatalf("expected an error for a project already in the manifest")
+	}
+	if _, err := os.Stat(filepath.Join(fake.X.Root, "new", "other")); !os.IsNotExist(err) {
+		t.Errorf("project directory was created: %v", err)
+	}
+}
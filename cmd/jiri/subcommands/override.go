@@ -10,7 +10,9 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/google/subcommands"
 	"go.fuchsia.dev/jiri"
@@ -22,7 +24,10 @@ func (c *overrideCmd) SetFlags(f *flag.FlagSet) {
 	f.StringVar(&c.path, "path", "", `Path used to store the project locally.`)
 	f.StringVar(&c.revision, "revision", "", `Revision to check out for the remote (defaults to HEAD).`)
 	f.StringVar(&c.gerritHost, "gerrithost", "", `The project Gerrit host.`)
+	f.StringVar(&c.expires, "expires", "", `Date, as YYYY-MM-DD, after which the override is reported as expired.`)
 	f.BoolVar(&c.delete, "delete", false, `Delete existing override. Override is matched using <name> and <remote>, <remote> is optional.`)
+	f.BoolVar(&c.hook, "hook", false, `With -delete, delete the override of hook <name>, of the project given instead of <remote>.`)
+	f.BoolVar(&c.pkg, "package", false, `With -delete, delete the override of package <name>.`)
 	f.BoolVar(&c.list, "list", false, `List all the overrides from .jiri_manifest. This flag doesn't accept any arguments. -json-out flag can be used to specify json output file.`)
 	f.StringVar(&c.jsonOutput, "json-output", "", `JSON output file from -list flag.`)
 }
@@ -35,8 +40,11 @@ type overrideCmd struct {
 	gerritHost     string
 	path           string
 	revision       string
+	expires        string
 	// Flags controlling the behavior of the command.
 	delete     bool
+	hook       bool
+	pkg        bool
 	list       bool
 	jsonOutput string
 }
@@ -52,6 +60,16 @@ Example:
 
 Run "jiri help manifest" for details on manifests.
 
+With -expires, the override is a temporary pin: once the date passes, every
+command loading the manifest warns about it, and "jiri resolve" fails.
+
+With -list, the overrides of projects, imports, hooks and packages are listed
+with their expiry date and the manifest declaring what they override. The
+overrides which match nothing in the manifest are reported as unused.
+
+With -delete, the override matching <name> and <remote> is removed, or with
+-hook or -package the override of the hook or package named <name>.
+
 Usage:
   jiri override [flags] <name> <remote>
 
@@ -64,11 +82,25 @@ Usage:
 type overrideInfo struct {
 	Import         bool   `json:"import,omitempty"`
 	ImportManifest string `json:"import-manifest,omitempty"`
+	Hook           bool   `json:"hook,omitempty"`
+	Package        bool   `json:"package,omitempty"`
 	Name           string `json:"name"`
 	Path           string `json:"path,omitempty"`
-	Remote         string `json:"remote"`
+	Remote         string `json:"remote,omitempty"`
 	Revision       string `json:"revision,omitempty"`
 	GerritHost     string `json:"gerrithost,omitempty"`
+	// Project and Action are set for hook overrides.
+	Project string `json:"project,omitempty"`
+	Action  string `json:"action,omitempty"`
+	// Version is set for package overrides.
+	Version string `json:"version,omitempty"`
+	Expires string `json:"expires,omitempty"`
+	Expired bool   `json:"expired,omitempty"`
+	// Source is the manifest declaring the overridden project or package,
+	// relative to the jiri root.
+	Source string `json:"source,omitempty"`
+	// Unused is set for the overrides matching nothing in the manifest.
+	Unused bool `json:"unused,omitempty"`
 }
 
 func (c *overrideCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
@@ -79,6 +111,23 @@ func (c *overrideCmd) run(jirix *jiri.X, args []string) error {
 	if c.delete && c.list {
 		return jirix.UsageErrorf("cannot use -delete and -list together")
 	}
+	if (c.hook || c.pkg) && !c.delete {
+		return jirix.UsageErrorf("-hook and -package can only be used with -delete")
+	}
+	if c.hook && c.pkg {
+		return jirix.UsageErrorf("cannot use -hook and -package together")
+	}
+	if c.pkg && len(args) != 1 {
+		return jirix.UsageErrorf("wrong number of arguments for the package flag")
+	}
+	if c.expires != "" {
+		if c.delete || c.list {
+			return jirix.UsageErrorf("-expires can only be used when adding an override")
+		}
+		if _, err := project.OverrideExpired(c.expires, time.Now()); err != nil {
+			return jirix.UsageErrorf("%v", err)
+		}
+	}
 
 	if c.list && len(args) != 0 {
 		return jirix.UsageErrorf("wrong number of arguments for the list flag")
@@ -102,27 +151,7 @@ func (c *overrideCmd) run(jirix *jiri.X, args []string) error {
 	}
 
 	if c.list {
-		overrides := make([]overrideInfo, 0)
-		for _, p := range manifest.ProjectOverrides {
-			overrides = append(overrides, overrideInfo{
-				Name:       p.Name,
-				Path:       p.Path,
-				Remote:     p.Remote,
-				Revision:   p.Revision,
-				GerritHost: p.GerritHost,
-			})
-		}
-
-		for _, p := range manifest.ImportOverrides {
-			overrides = append(overrides, overrideInfo{
-				Import:         true,
-				ImportManifest: p.Manifest,
-				Name:           p.Name,
-				Remote:         p.Remote,
-				Revision:       p.Revision,
-			})
-		}
-
+		overrides := listOverrides(jirix, manifest)
 		if c.jsonOutput == "" {
 			for _, o := range overrides {
 				fmt.Fprintf(jirix.Stdout(), "* override %s\n", o.Name)
@@ -130,8 +159,25 @@ func (c *overrideCmd) run(jirix *jiri.X, args []string) error {
 					fmt.Fprintf(jirix.Stdout(), "  IsImport: %v\n", o.Import)
 					fmt.Fprintf(jirix.Stdout(), "  ImportManifest: %s\n", o.ImportManifest)
 				}
+				if o.Hook {
+					fmt.Fprintf(jirix.Stdout(), "  IsHook:      %v\n", o.Hook)
+				}
+				if o.Package {
+					fmt.Fprintf(jirix.Stdout(), "  IsPackage:   %v\n", o.Package)
+				}
 				fmt.Fprintf(jirix.Stdout(), "  Name:        %s\n", o.Name)
-				fmt.Fprintf(jirix.Stdout(), "  Remote:      %s\n", o.Remote)
+				if o.Remote != "" {
+					fmt.Fprintf(jirix.Stdout(), "  Remote:      %s\n", o.Remote)
+				}
+				if o.Project != "" {
+					fmt.Fprintf(jirix.Stdout(), "  Project:     %s\n", o.Project)
+				}
+				if o.Action != "" {
+					fmt.Fprintf(jirix.Stdout(), "  Action:      %s\n", o.Action)
+				}
+				if o.Version != "" {
+					fmt.Fprintf(jirix.Stdout(), "  Version:     %s\n", o.Version)
+				}
 				if o.Path != "" {
 					fmt.Fprintf(jirix.Stdout(), "  Path:        %s\n", o.Path)
 				}
@@ -141,6 +187,19 @@ func (c *overrideCmd) run(jirix *jiri.X, args []string) error {
 				if o.GerritHost != "" {
 					fmt.Fprintf(jirix.Stdout(), "  Gerrit Host: %s\n", o.GerritHost)
 				}
+				if o.Expires != "" {
+					expired := ""
+					if o.Expired {
+						expired = " (EXPIRED)"
+					}
+					fmt.Fprintf(jirix.Stdout(), "  Expires:     %s%s\n", o.Expires, expired)
+				}
+				if o.Source != "" {
+					fmt.Fprintf(jirix.Stdout(), "  Source:      %s\n", o.Source)
+				}
+				if o.Unused {
+					fmt.Fprintf(jirix.Stdout(), "  Unused:      %v\n", o.Unused)
+				}
 			}
 		} else {
 			file, err := os.Create(c.jsonOutput)
@@ -158,7 +217,11 @@ func (c *overrideCmd) run(jirix *jiri.X, args []string) error {
 	}
 
 	name := args[0]
-	if c.delete {
+	if c.delete && (c.hook || c.pkg) {
+		if err := c.deleteHookOrPackageOverride(jirix, manifest, args); err != nil {
+			return err
+		}
+	} else if c.delete {
 		var projectOverrides []project.Project
 		var importOverrides []project.Import
 		var deletedProjectOverrides []project.Project
@@ -209,6 +272,7 @@ func (c *overrideCmd) run(jirix *jiri.X, args []string) error {
 					Remote:   remote,
 					Manifest: c.importManifest,
 					Revision: c.revision,
+					Expires:  c.expires,
 				}
 				manifest.ImportOverrides = append(manifest.ImportOverrides, importOverride)
 			} else {
@@ -218,6 +282,7 @@ func (c *overrideCmd) run(jirix *jiri.X, args []string) error {
 					Path:       c.path,
 					Revision:   c.revision,
 					GerritHost: c.gerritHost,
+					Expires:    c.expires,
 					// We deliberately omit RemoteBranch, HistoryDepth and
 					// GitHooks. Those fields are effectively deprecated and
 					// will likely be removed in the future.
@@ -233,3 +298,147 @@ func (c *overrideCmd) run(jirix *jiri.X, args []string) error {
 	// errors will be reported when "jiri update" is run.
 	return manifest.ToFile(jirix, jirix.JiriManifestFile())
 }
+
+// deleteHookOrPackageOverride deletes the override of the hook or package
+// named args[0] from manifest, matching the project of hooks with args[1] if
+// set.
+func (c *overrideCmd) deleteHookOrPackageOverride(jirix *jiri.X, manifest *project.Manifest, args []string) error {
+	name := args[0]
+	var deleted []string
+	if c.hook {
+		var hookOverrides []project.Hook
+		for _, h := range manifest.HookOverrides {
+			if h.Name != name || (len(args) == 2 && h.ProjectName != args[1]) {
+				hookOverrides = append(hookOverrides, h)
+				continue
+			}
+			deleted = append(deleted, h.Name+"(project: "+h.ProjectName+")")
+		}
+		if len(deleted) > 1 {
+			return fmt.Errorf("more than one override matches")
+		}
+		manifest.HookOverrides = hookOverrides
+	} else {
+		var packageOverrides []project.Package
+		for _, p := range manifest.PackageOverrides {
+			if p.Name != name {
+				packageOverrides = append(packageOverrides, p)
+				continue
+			}
+			deleted = append(deleted, p.Name)
+		}
+		manifest.PackageOverrides = packageOverrides
+	}
+	if len(deleted) == 0 {
+		return fmt.Errorf("no such override")
+	}
+	jirix.Logger.Infof("Deleted overrides: %s\n", strings.Join(deleted, " "))
+	return nil
+}
+
+// listOverrides returns the overrides of manifest, the .jiri_manifest file.
+// The manifest is loaded to find the sources of the overrides, without
+// fetching anything and on a best-effort basis: if it cannot be loaded from
+// the local checkouts, the sources are omitted.
+func listOverrides(jirix *jiri.X, manifest *project.Manifest) []overrideInfo {
+	now := time.Now()
+	expired := func(expires string) bool {
+		ok, _ := project.OverrideExpired(expires, now)
+		return ok
+	}
+	overrides := make([]overrideInfo, 0)
+	for _, p := range manifest.ProjectOverrides {
+		overrides = append(overrides, overrideInfo{
+			Name:       p.Name,
+			Path:       p.Path,
+			Remote:     p.Remote,
+			Revision:   p.Revision,
+			GerritHost: p.GerritHost,
+			Expires:    p.Expires,
+			Expired:    expired(p.Expires),
+		})
+	}
+	for _, p := range manifest.ImportOverrides {
+		overrides = append(overrides, overrideInfo{
+			Import:         true,
+			ImportManifest: p.Manifest,
+			Name:           p.Name,
+			Remote:         p.Remote,
+			Revision:       p.Revision,
+			Expires:        p.Expires,
+			Expired:        expired(p.Expires),
+		})
+	}
+	for _, h := range manifest.HookOverrides {
+		overrides = append(overrides, overrideInfo{
+			Hook:    true,
+			Name:    h.Name,
+			Project: h.ProjectName,
+			Action:  h.Action,
+			Expires: h.Expires,
+			Expired: expired(h.Expires),
+		})
+	}
+	for _, p := range manifest.PackageOverrides {
+		overrides = append(overrides, overrideInfo{
+			Package: true,
+			Name:    p.Name,
+			Version: p.Version,
+			Path:    p.Path,
+			Expires: p.Expires,
+			Expired: expired(p.Expires),
+		})
+	}
+	if len(overrides) == 0 {
+		return overrides
+	}
+
+	// Overrides were already warned about, and listing them must not fetch
+	// missing manifests.
+	offline, warned := jirix.Offline, jirix.OverrideWarned
+	jirix.Offline, jirix.OverrideWarned = true, true
+	defer func() {
+		jirix.Offline, jirix.OverrideWarned = offline, warned
+	}()
+	localProjects, err := project.LocalProjects(jirix, project.FastScan)
+	if err != nil {
+		jirix.Logger.Debugf("Cannot find the sources of overrides: %v", err)
+		return overrides
+	}
+	projects, hooks, pkgs, err := project.LoadManifestFile(jirix, jirix.JiriManifestFile(), localProjects, nil)
+	if err != nil {
+		jirix.Logger.Debugf("Cannot find the sources of overrides: %v", err)
+		return overrides
+	}
+	relPath := func(path string) string {
+		if rel, err := filepath.Rel(jirix.Root, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return filepath.ToSlash(rel)
+		}
+		return path
+	}
+	for i, o := range overrides {
+		switch {
+		case o.Import:
+			// Imports are not recorded by the loader.
+		case o.Hook:
+			_, ok := hooks[project.MakeHookKey(o.Name, o.Project)]
+			overrides[i].Unused = !ok
+		case o.Package:
+			overrides[i].Unused = true
+			for _, pkg := range pkgs {
+				if pkg.Name == o.Name {
+					overrides[i].Source = relPath(pkg.ManifestPath)
+					overrides[i].Unused = false
+					break
+				}
+			}
+		default:
+			if p, ok := projects[project.MakeProjectKey(o.Name, o.Remote)]; ok {
+				overrides[i].Source = relPath(p.ManifestPath)
+			} else {
+				overrides[i].Unused = true
+			}
+		}
+	}
+	return overrides
+}
@@ -5,9 +5,11 @@
 package subcommands
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -135,6 +137,139 @@ func TestOverride(t *testing.T) {
   Revision:    HEAD
 `,
 		},
+		{
+			Name: "expires specified",
+			Flags: overrideCmd{
+				expires: "2030-01-31",
+			},
+			Args: []string{"foo", "https://github.com/new.git"},
+			Want: `<manifest>
+  <imports>
+    <import manifest="manifest" name="foo" remote="https://github.com/new.git"/>
+  </imports>
+  <overrides>
+    <project name="foo" remote="https://github.com/new.git" expires="2030-01-31"/>
+  </overrides>
+</manifest>
+`,
+		},
+		{
+			Name: "bad expires",
+			Flags: overrideCmd{
+				expires: "31/01/2030",
+			},
+			Args:    []string{"foo", "https://github.com/new.git"},
+			WantErr: `bad expires date "31/01/2030", should be YYYY-MM-DD`,
+		},
+		{
+			Name: "list hooks, packages and expiry",
+			Flags: overrideCmd{
+				list: true,
+			},
+			Exist: `<manifest>
+  <imports>
+    <import manifest="manifest" name="orig" remote="https://github.com/orig.git"/>
+  </imports>
+  <overrides>
+    <project name="foo" remote="https://github.com/new.git" expires="2000-01-01"/>
+    <hook name="gen" project="foo" action="gen.sh" expires="2999-01-01"/>
+    <package name="tools/bar" version="version:2"/>
+  </overrides>
+</manifest>
+`,
+			Stdout: `* override foo
+  Name:        foo
+  Remote:      https://github.com/new.git
+  Revision:    HEAD
+  Expires:     2000-01-01 (EXPIRED)
+* override gen
+  IsHook:      true
+  Name:        gen
+  Project:     foo
+  Action:      gen.sh
+  Expires:     2999-01-01
+* override tools/bar
+  IsPackage:   true
+  Name:        tools/bar
+  Version:     version:2
+`,
+		},
+		{
+			Name: "delete package",
+			Flags: overrideCmd{
+				delete: true,
+				pkg:    true,
+			},
+			Args:    []string{"tools/bar"},
+			runOnce: true,
+			Exist: `<manifest>
+  <overrides>
+    <hook name="gen" project="foo" action="gen.sh"/>
+    <package name="tools/bar" version="version:2"/>
+  </overrides>
+</manifest>
+`,
+			Want: `<manifest>
+  <overrides>
+    <hook name="gen" action="gen.sh" project="foo"/>
+  </overrides>
+</manifest>
+`,
+		},
+		{
+			Name: "delete hook",
+			Flags: overrideCmd{
+				delete: true,
+				hook:   true,
+			},
+			Args:    []string{"gen", "foo"},
+			runOnce: true,
+			Exist: `<manifest>
+  <overrides>
+    <hook name="gen" project="foo" action="gen.sh"/>
+    <hook name="gen" project="bar" action="gen.sh"/>
+  </overrides>
+</manifest>
+`,
+			Want: `<manifest>
+  <overrides>
+    <hook name="gen" action="gen.sh" project="bar"/>
+  </overrides>
+</manifest>
+`,
+		},
+		{
+			Name: "delete missing hook",
+			Flags: overrideCmd{
+				delete: true,
+				hook:   true,
+			},
+			Args:    []string{"gen", "baz"},
+			runOnce: true,
+			Exist: `<manifest>
+  <overrides>
+    <hook name="gen" project="foo" action="gen.sh"/>
+  </overrides>
+</manifest>
+`,
+			WantErr: `no such override`,
+		},
+		{
+			Name: "delete missing package",
+			Flags: overrideCmd{
+				delete: true,
+				pkg:    true,
+			},
+			Args:    []string{"tools/baz"},
+			runOnce: true,
+			Exist: `<manifest>
+  <overrides>
+    <package name="tools/bar" version="version:2"/>
+  </overrides>
+</manifest>
+`,
+			WantErr: `no such override`,
+		},
 		{
 			Name: "existing overrides",
 			Args: []string{"bar", "https://github.com/bar.git"},
@@ -371,3 +506,51 @@ func testOverride(t *testing.T, test overrideTestCase) error {
 
 	return nil
 }
+
+// TestOverrideListSources tests that listed overrides report the manifest
+// declaring what they override, and that "jiri resolve" fails on expired
+// overrides.
+func TestOverrideListSources(t *testing.T) {
+	t.Parallel()
+
+	projects, fake := setupUniverse(t)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	m, err := project.ManifestFromFile(fake.X, fake.X.JiriManifestFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.ProjectOverrides = append(m.ProjectOverrides,
+		project.Project{Name: projects[0].Name, Remote: projects[0].Remote, Expires: "2000-01-01"},
+		project.Project{Name: "gone", Remote: "https://example.com/gone"})
+	if err := m.ToFile(fake.X, fake.X.JiriManifestFile()); err != nil {
+		t.Fatal(err)
+	}
+
+	jsonOutput := filepath.Join(t.TempDir(), "overrides.json")
+	if _, _, err := collectStdio(fake.X, nil, (&overrideCmd{list: true, jsonOutput: jsonOutput}).run); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(jsonOutput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []overrideInfo
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []overrideInfo{
+		{Name: projects[0].Name, Remote: projects[0].Remote, Revision: "HEAD", Expires: "2000-01-01", Expired: true, Source: "manifest/public"},
+		{Name: "gone", Remote: "https://example.com/gone", Revision: "HEAD", Unused: true},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Unexpected diff in overrides (-want +got):\n%s", diff)
+	}
+
+	lockfile := filepath.Join(t.TempDir(), "jiri.lock")
+	err = (&resolveCmd{lockFilePath: lockfile, enableProjectLock: true}).run(fake.X, nil)
+	if err == nil || !strings.Contains(err.Error(), "has expired overrides") {
+		t.Fatalf("expected an error about expired overrides, got %v", err)
+	}
+}
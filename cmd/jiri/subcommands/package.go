@@ -23,8 +23,10 @@ import (
 type packageCmd struct {
 	cmdBase
 
-	jsonOutput string
-	regexp     bool
+	jsonOutput     string
+	regexp         bool
+	checkInstalled bool
+	redeploy       bool
 }
 
 func (c *packageCmd) Name() string     { return "package" }
@@ -36,15 +38,39 @@ expressions that are matched against package names. If no command line
 arguments are provided all projects will be used.
 
 Usage:
-  jiri package [flags] <package ...>
-
-<package ...> is a list of packages to give info about.
+  jiri package [flags] [info] <package ...>
+  jiri package [flags] list [<package ...>]
+  jiri package [flags] verify [<package ...>]
+
+<package ...> is a list of packages to give info about. As the first argument
+is taken as a verb if it is info, list or verify, give info about packages
+named like one of them with an explicit info, e.g. "jiri package info list".
+
+"jiri package list" lists the packages with their deployment status: the
+instance locked in jiri.lock for this platform, whether their path exists, and
+whether the instance deployed there, according to "cipd installed", is the
+locked one. The status of a package is one of:
+  installed    the locked instance is deployed
+  mismatch     another instance is deployed
+  missing      no instance is deployed
+  unlocked     the package has no instance for this platform in jiri.lock,
+               whether it is deployed or not
+  unsupported  the package is not available for this platform
+  unknown      the deployed instances could not be listed
+
+"jiri package verify" checks the files of the deployed packages against the
+manifest cipd keeps of each deployed instance, and reports the files which
+are missing or were modified, e.g. by a build writing into a package. It fails
+if there are any, unless -redeploy is given, in which case the modified files
+are removed and cipd deploys the packages again.
 `
 }
 
 func (c *packageCmd) SetFlags(f *flag.FlagSet) {
 	f.StringVar(&c.jsonOutput, "json-output", "", "Path to write operation results to.")
 	f.BoolVar(&c.regexp, "regexp", false, "Use argument as regular expression.")
+	f.BoolVar(&c.checkInstalled, "check-installed", true, "With list, compare the deployed instances with jiri.lock, which needs the cipd binary.")
+	f.BoolVar(&c.redeploy, "redeploy", false, "With verify, redeploy the packages with missing or modified files.")
 }
 
 func (c *packageCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
@@ -53,64 +79,34 @@ func (c *packageCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) sub
 
 // runPackageInfo provides structured info on packages.
 func (c *packageCmd) run(jirix *jiri.X, args []string) error {
-	var err error
-
-	regexps := make([]*regexp.Regexp, 0)
-	for _, arg := range args {
-		if !c.regexp {
-			arg = "^" + regexp.QuoteMeta(arg) + "$"
-		}
-		if re, err := regexp.Compile(arg); err != nil {
-			return fmt.Errorf("failed to compile regexp %v: %v", arg, err)
-		} else {
-			regexps = append(regexps, re)
+	verb := "info"
+	if len(args) > 0 {
+		switch args[0] {
+		case "info", "list", "verify":
+			verb, args = args[0], args[1:]
 		}
 	}
-
-	projects, err := project.LocalProjects(jirix, project.FastScan)
-	if err != nil {
-		return err
+	switch verb {
+	case "list":
+		return c.runList(jirix, args)
+	case "verify":
+		return c.runVerify(jirix, args)
 	}
-	localManifestProjects, err := getDefaultLocalManifestProjects(jirix)
-	if err != nil {
-		return err
+	if c.redeploy {
+		return jirix.UsageErrorf("-redeploy can only be used with verify")
 	}
-	_, _, pkgs, err := project.LoadManifestFile(jirix, jirix.JiriManifestFile(), projects, localManifestProjects)
+	pkgs, keys, err := c.matchingPackages(jirix, args)
 	if err != nil {
 		return err
 	}
-	var keys project.PackageKeys
-	for k, v := range pkgs {
-		if len(args) == 0 {
-			keys = append(keys, k)
-		} else {
-			for _, re := range regexps {
-				if re.MatchString(v.Name) {
-					keys = append(keys, k)
-					break
-				}
-			}
-		}
-	}
-
-	sort.Sort(keys)
 
 	info := make([]packageInfoOutput, 0)
 	for _, key := range keys {
 		pkg := pkgs[key]
-		pkgPath, err := pkg.ResolvePath()
+		pkgPath, err := packageLocalPath(jirix, pkg)
 		if err != nil {
 			return err
 		}
-		tmpl, err := template.New("pack").Parse(pkgPath)
-		if err != nil {
-			return fmt.Errorf("parsing package path %q failed", pkgPath)
-		}
-		var subdirBuf bytes.Buffer
-		// subdir is using fuchsia platform format instead of
-		// using cipd platform format
-		tmpl.Execute(&subdirBuf, cipd.FuchsiaPlatform(cipd.CurrentPlatform))
-		pkgPath = filepath.Join(jirix.Root, subdirBuf.String())
 
 		platforms, err := pkg.GetPlatforms()
 		if err != nil {
@@ -148,6 +144,70 @@ func (c *packageCmd) run(jirix *jiri.X, args []string) error {
 	return nil
 }
 
+// matchingPackages loads the packages of the manifest, and returns them with
+// the sorted keys of those matching args, names or regular expressions with
+// -regexp, or of all of them if args is empty.
+func (c *packageCmd) matchingPackages(jirix *jiri.X, args []string) (project.Packages, project.PackageKeys, error) {
+	regexps := make([]*regexp.Regexp, 0)
+	for _, arg := range args {
+		if !c.regexp {
+			arg = "^" + regexp.QuoteMeta(arg) + "$"
+		}
+		if re, err := regexp.Compile(arg); err != nil {
+			return nil, nil, fmt.Errorf("failed to compile regexp %v: %v", arg, err)
+		} else {
+			regexps = append(regexps, re)
+		}
+	}
+
+	projects, err := project.LocalProjects(jirix, project.FastScan)
+	if err != nil {
+		return nil, nil, err
+	}
+	localManifestProjects, err := getDefaultLocalManifestProjects(jirix)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, _, pkgs, err := project.LoadManifestFile(jirix, jirix.JiriManifestFile(), projects, localManifestProjects)
+	if err != nil {
+		return nil, nil, err
+	}
+	var keys project.PackageKeys
+	for k, v := range pkgs {
+		if len(args) == 0 {
+			keys = append(keys, k)
+		} else {
+			for _, re := range regexps {
+				if re.MatchString(v.Name) {
+					keys = append(keys, k)
+					break
+				}
+			}
+		}
+	}
+
+	sort.Sort(keys)
+	return pkgs, keys, nil
+}
+
+// packageLocalPath returns the absolute path pkg is deployed to on this
+// platform.
+func packageLocalPath(jirix *jiri.X, pkg project.Package) (string, error) {
+	pkgPath, err := pkg.ResolvePath()
+	if err != nil {
+		return "", err
+	}
+	tmpl, err := template.New("pack").Parse(pkgPath)
+	if err != nil {
+		return "", fmt.Errorf("parsing package path %q failed", pkgPath)
+	}
+	var subdirBuf bytes.Buffer
+	// subdir is using fuchsia platform format instead of
+	// using cipd platform format
+	tmpl.Execute(&subdirBuf, cipd.FuchsiaPlatform(cipd.CurrentPlatform))
+	return filepath.Join(jirix.Root, subdirBuf.String()), nil
+}
+
 // packageInfoOutput defines JSON format for 'project info' output.
 type packageInfoOutput struct {
 	Name      string   `json:"name"`
@@ -0,0 +1,151 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/cipd"
+	"go.fuchsia.dev/jiri/project"
+)
+
+// Deployment status of a package, see packageListOutput.Status.
+const (
+	packageInstalled   = "installed"
+	packageMismatch    = "mismatch"
+	packageMissing     = "missing"
+	packageUnlocked    = "unlocked"
+	packageUnsupported = "unsupported"
+	packageUnknown     = "unknown"
+)
+
+// packageListOutput defines JSON format for 'package list' output.
+type packageListOutput struct {
+	Name      string   `json:"name"`
+	Version   string   `json:"version"`
+	Path      string   `json:"path"`
+	Platforms []string `json:"platforms,omitempty"`
+	// Package is the name of the package for this platform.
+	Package string `json:"package,omitempty"`
+	// InstanceID is the instance locked in jiri.lock for this platform.
+	InstanceID string `json:"instance_id,omitempty"`
+	// Installed is the instance deployed at Path, if known.
+	Installed string `json:"installed_instance_id,omitempty"`
+	Exists    bool   `json:"exists"`
+	Status    string `json:"status"`
+}
+
+// runList lists the packages matching args with their deployment status.
+func (c *packageCmd) runList(jirix *jiri.X, args []string) error {
+	pkgs, keys, err := c.matchingPackages(jirix, args)
+	if err != nil {
+		return err
+	}
+
+	var installed map[string]cipd.InstalledPackage
+	if c.checkInstalled && len(keys) > 0 {
+		deployed, err := cipd.Installed(jirix, jirix.Root)
+		if err != nil {
+			jirix.Logger.Warningf("Cannot list the deployed packages, their status is unknown: %v\n\n", err)
+		} else {
+			installed = make(map[string]cipd.InstalledPackage)
+			for _, p := range deployed {
+				installed[filepath.Join(jirix.Root, p.Subdir)+"\x00"+p.PackageName] = p
+			}
+		}
+	}
+
+	list := make([]packageListOutput, 0, len(keys))
+	for _, key := range keys {
+		pkg := pkgs[key]
+		pkgPath, err := packageLocalPath(jirix, pkg)
+		if err != nil {
+			return err
+		}
+		platforms, err := pkg.GetPlatforms()
+		if err != nil {
+			return fmt.Errorf("parsing %s platforms failed", pkg.Name)
+		}
+		out := packageListOutput{
+			Name:    pkg.Name,
+			Version: pkg.Version,
+			Path:    pkgPath,
+		}
+		for _, p := range platforms {
+			out.Platforms = append(out.Platforms, p.String())
+		}
+		if _, err := os.Stat(pkgPath); err == nil {
+			out.Exists = true
+		}
+		out.Status = packageStatus(pkg, platforms, installed, &out)
+		list = append(list, out)
+	}
+
+	for _, p := range list {
+		rel, err := filepath.Rel(jirix.Root, p.Path)
+		if err != nil {
+			rel = p.Path
+		}
+		fmt.Fprintf(jirix.Stdout(), "* package %s\n", p.Name)
+		fmt.Fprintf(jirix.Stdout(), "  Version:   %s\n", p.Version)
+		fmt.Fprintf(jirix.Stdout(), "  Path:      %s (exists: %t)\n", rel, p.Exists)
+		fmt.Fprintf(jirix.Stdout(), "  Platforms: %v\n", p.Platforms)
+		if p.InstanceID != "" {
+			fmt.Fprintf(jirix.Stdout(), "  Instance:  %s %s\n", p.Package, p.InstanceID)
+		}
+		status := p.Status
+		if p.Status == packageMismatch {
+			status += fmt.Sprintf(" (deployed %s)", p.Installed)
+		}
+		fmt.Fprintf(jirix.Stdout(), "  Status:    %s\n", status)
+	}
+
+	if c.jsonOutput != "" {
+		if err := writeJSONOutput(c.jsonOutput, list); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// packageStatus fills the package, locked and deployed instances of pkg for
+// the current platform in out, and returns its deployment status. installed
+// maps the deployed packages by path and name, and is nil if they are not
+// known.
+func packageStatus(pkg project.Package, platforms []cipd.Platform, installed map[string]cipd.InstalledPackage, out *packageListOutput) string {
+	if cipd.IsPlatformSpecific(pkg.Name) && !slices.Contains(platforms, cipd.CurrentPlatform) {
+		return packageUnsupported
+	}
+	names, err := cipd.ResolvePlatforms(pkg.Name, []cipd.Platform{cipd.CurrentPlatform})
+	if err != nil || len(names) == 0 {
+		return packageUnsupported
+	}
+	out.Package = names[0]
+	for _, ins := range pkg.Instances {
+		if ins.Name == out.Package {
+			out.InstanceID = ins.ID
+		}
+	}
+	deployed, ok := installed[out.Path+"\x00"+out.Package]
+	if ok {
+		out.Installed = deployed.InstanceID
+	}
+	switch {
+	case out.InstanceID == "":
+		// Whether it is deployed or not, there is nothing to compare with.
+		return packageUnlocked
+	case installed == nil:
+		return packageUnknown
+	case !ok:
+		return packageMissing
+	case deployed.InstanceID != out.InstanceID:
+		return packageMismatch
+	}
+	return packageInstalled
+}
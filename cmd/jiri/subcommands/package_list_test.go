@@ -0,0 +1,48 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"testing"
+
+	"go.fuchsia.dev/jiri/cipd"
+	"go.fuchsia.dev/jiri/project"
+)
+
+func TestPackageStatus(t *testing.T) {
+	const path = "/root/prebuilt/tool"
+	name := "fuchsia/tool/" + cipd.CurrentPlatform.String()
+	pkg := project.Package{
+		Name:      "fuchsia/tool/${platform}",
+		Instances: []project.PackageInstance{{Name: name, ID: "locked"}},
+	}
+	deployed := func(id string) map[string]cipd.InstalledPackage {
+		return map[string]cipd.InstalledPackage{path + "\x00" + name: {PackageName: name, InstanceID: id}}
+	}
+	other := cipd.Platform{OS: "plan9", Arch: cipd.CurrentPlatform.Arch}
+
+	tests := []struct {
+		name      string
+		pkg       project.Package
+		platforms []cipd.Platform
+		installed map[string]cipd.InstalledPackage
+		want      string
+	}{
+		{"installed", pkg, []cipd.Platform{cipd.CurrentPlatform}, deployed("locked"), packageInstalled},
+		{"mismatch", pkg, []cipd.Platform{cipd.CurrentPlatform}, deployed("other"), packageMismatch},
+		{"missing", pkg, []cipd.Platform{cipd.CurrentPlatform}, map[string]cipd.InstalledPackage{}, packageMissing},
+		{"unknown", pkg, []cipd.Platform{cipd.CurrentPlatform}, nil, packageUnknown},
+		{"unsupported", pkg, []cipd.Platform{other}, deployed("locked"), packageUnsupported},
+		{"unlocked", project.Package{Name: pkg.Name}, []cipd.Platform{cipd.CurrentPlatform}, deployed("locked"), packageUnlocked},
+		{"unlocked and missing", project.Package{Name: pkg.Name}, []cipd.Platform{cipd.CurrentPlatform}, map[string]cipd.InstalledPackage{}, packageUnlocked},
+		{"unlocked and unknown", project.Package{Name: pkg.Name}, []cipd.Platform{cipd.CurrentPlatform}, nil, packageUnlocked},
+	}
+	for _, test := range tests {
+		out := packageListOutput{Path: path}
+		if got := packageStatus(test.pkg, test.platforms, test.installed, &out); got != test.want {
+			t.Errorf("%s: got status %q, want %q", test.name, got, test.want)
+		}
+	}
+}
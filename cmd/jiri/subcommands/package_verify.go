@@ -0,0 +1,166 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/cipd"
+	"go.fuchsia.dev/jiri/project"
+)
+
+// packageVerifyOutput defines JSON format for 'package verify' output.
+type packageVerifyOutput struct {
+	Name       string             `json:"name"`
+	Package    string             `json:"package"`
+	InstanceID string             `json:"instance_id"`
+	Path       string             `json:"path"`
+	Problems   []cipd.FileProblem `json:"problems"`
+	Redeployed bool               `json:"redeployed,omitempty"`
+}
+
+// runVerify checks the files of the deployed packages matching args against
+// the manifests of their instances, and redeploys those which are modified
+// with -redeploy.
+func (c *packageCmd) runVerify(jirix *jiri.X, args []string) error {
+	pkgs, keys, err := c.matchingPackages(jirix, args)
+	if err != nil {
+		return err
+	}
+	deployed, err := cipd.Deployed(jirix.Root)
+	if err != nil {
+		return err
+	}
+	byPath := make(map[string]cipd.DeployedPackage)
+	for _, d := range deployed {
+		byPath[filepath.Join(jirix.Root, d.Subdir)+"\x00"+d.PackageName] = d
+	}
+
+	var modified []packageVerifyOutput
+	for _, key := range keys {
+		pkg := pkgs[key]
+		pkgPath, err := packageLocalPath(jirix, pkg)
+		if err != nil {
+			return err
+		}
+		names, err := cipd.ResolvePlatforms(pkg.Name, []cipd.Platform{cipd.CurrentPlatform})
+		if err != nil || len(names) == 0 {
+			continue
+		}
+		d, ok := byPath[pkgPath+"\x00"+names[0]]
+		if !ok {
+			continue
+		}
+		problems, err := cipd.VerifyDeployed(jirix.Root, d)
+		if err != nil {
+			return fmt.Errorf("verifying package %s: %v", pkg.Name, err)
+		}
+		if len(problems) != 0 {
+			modified = append(modified, packageVerifyOutput{
+				Name:       pkg.Name,
+				Package:    d.PackageName,
+				InstanceID: d.InstanceID,
+				Path:       pkgPath,
+				Problems:   problems,
+			})
+		}
+	}
+
+	if c.redeploy && len(modified) != 0 {
+		if err := c.redeployPackages(jirix, modified); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range modified {
+		rel, err := filepath.Rel(jirix.Root, p.Path)
+		if err != nil {
+			rel = p.Path
+		}
+		fmt.Fprintf(jirix.Stdout(), "* package %s (%s)\n", p.Name, rel)
+		for _, problem := range p.Problems {
+			fmt.Fprintf(jirix.Stdout(), "  %-9s %s\n", problem.Problem+":", problem.Name)
+		}
+		if p.Redeployed {
+			fmt.Fprintf(jirix.Stdout(), "  %s\n", jirix.Color.Green("redeployed"))
+		}
+	}
+
+	if c.jsonOutput != "" {
+		if err := writeJSONOutput(c.jsonOutput, modified); err != nil {
+			return err
+		}
+	}
+	if len(modified) != 0 && !c.redeploy {
+		return fmt.Errorf("%d package(s) have missing or modified files, run with -redeploy to redeploy them", len(modified))
+	}
+	return nil
+}
+
+// redeployPackages removes the modified files of packages, and runs cipd
+// again to deploy the missing files, like "jiri fetch-packages". It then
+// verifies the packages again.
+func (c *packageCmd) redeployPackages(jirix *jiri.X, packages []packageVerifyOutput) error {
+	if err := jirix.LockRoot(c.Name(), false); err != nil {
+		return err
+	}
+	for _, p := range packages {
+		for _, problem := range p.Problems {
+			if problem.Problem != cipd.FileModified {
+				continue
+			}
+			if err := os.Remove(filepath.Join(jirix.Root, filepath.FromSlash(problem.Name))); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	localProjects, err := project.LocalProjects(jirix, project.FastScan)
+	if err != nil {
+		return err
+	}
+	localManifestProjects, err := getDefaultLocalManifestProjects(jirix)
+	if err != nil {
+		return err
+	}
+	projects, _, pkgs, err := project.LoadManifestFile(jirix, jirix.JiriManifestFile(), localProjects, localManifestProjects)
+	if err != nil {
+		return err
+	}
+	if err := project.FilterOptionalProjectsPackages(jirix, jirix.FetchingAttrs, nil, pkgs); err != nil {
+		return err
+	}
+	project.FilterProjectsPackagesByGroup(jirix, projects, pkgs)
+	// cipd only deploys again the missing files of packages which are
+	// already deployed in paranoid mode.
+	jirix.CipdParanoidMode = true
+	if err := project.FetchPackages(jirix, pkgs, project.DefaultPackageTimeout); err != nil {
+		return err
+	}
+
+	deployed, err := cipd.Deployed(jirix.Root)
+	if err != nil {
+		return err
+	}
+	for i, p := range packages {
+		for _, d := range deployed {
+			if filepath.Join(jirix.Root, d.Subdir) != p.Path || d.PackageName != p.Package {
+				continue
+			}
+			problems, err := cipd.VerifyDeployed(jirix.Root, d)
+			if err != nil {
+				return err
+			}
+			if len(problems) != 0 {
+				return fmt.Errorf("package %s still has missing or modified files after redeploying it", p.Name)
+			}
+			packages[i].Redeployed = true
+		}
+	}
+	return nil
+}
@@ -337,7 +337,7 @@ func (c *patchCmd) patchProject(jirix *jiri.X, local project.Project, ref, branc
 					return false, err
 				}
 				if currentBranch == branch {
-					if err := scm.Checkout("remotes/origin/"+remote, gitutil.DetachOpt(true)); err != nil {
+					if err := scm.Checkout("remotes/"+local.PrimaryRemote()+"/"+remote, gitutil.DetachOpt(true)); err != nil {
 						return false, err
 					}
 				}
@@ -355,7 +355,7 @@ func (c *patchCmd) patchProject(jirix *jiri.X, local project.Project, ref, branc
 	} else {
 		jirix.Logger.Infof("Patching project %s(%s) to ref %q\n", local.Name, local.Path, ref)
 	}
-	if err := scm.FetchRefspec("origin", ref); err != nil {
+	if err := scm.FetchRefspec(local.PrimaryRemote(), ref); err != nil {
 		return false, err
 	}
 	branchBase := "FETCH_HEAD"
@@ -377,8 +377,8 @@ func (c *patchCmd) patchProject(jirix *jiri.X, local project.Project, ref, branc
 		}
 		// Fetch the remote branch before trying to set it as upstream, in case
 		// it hasn't yet been fetched.
-		if err := scm.FetchRefspec("origin", remote); err != nil {
-			return false, fmt.Errorf("failed to fetch 'origin/%s': %s", remote, err)
+		if err := scm.FetchRefspec(local.PrimaryRemote(), remote); err != nil {
+			return false, fmt.Errorf("failed to fetch '%s/%s': %s", local.PrimaryRemote(), remote, err)
 		}
 		branchBase = branch
 	}
@@ -400,7 +400,7 @@ func (c *patchCmd) patchProject(jirix *jiri.X, local project.Project, ref, branc
 		// FETCH_HEAD. This will not be true after a rebase, as the rebase
 		// functions perform fetches of their own.
 		if c.cherryPick {
-			if err := scm.FetchRefspec("origin", ref); err != nil {
+			if err := scm.FetchRefspec(local.PrimaryRemote(), ref); err != nil {
 				return false, err
 			}
 		}
@@ -449,12 +449,12 @@ func (c *patchCmd) rebaseProject(jirix *jiri.X, project project.Project, branch,
 	}
 	// TODO: provide a way to set username and email
 	scm = gitutil.New(jirix, gitutil.UserNameOpt(name), gitutil.UserEmailOpt(email), gitutil.RootDirOpt(project.Path))
-	if err := scm.FetchRefspec("origin", remoteBranch); err != nil {
+	if err := scm.FetchRefspec(project.PrimaryRemote(), remoteBranch); err != nil {
 		jirix.Logger.Errorf("Not able to fetch branch %q: %s", remoteBranch, err)
 		jirix.IncrementFailures()
 		return nil
 	}
-	if err := scm.RebaseBranch(branch, "remotes/origin/"+remoteBranch, gitutil.RebaseMerges(true)); err != nil {
+	if err := scm.RebaseBranch(branch, "remotes/"+project.PrimaryRemote()+"/"+remoteBranch, gitutil.RebaseMerges(true)); err != nil {
 		if err2 := scm.RebaseAbort(); err2 != nil {
 			return err2
 		}
@@ -476,12 +476,12 @@ func (c *patchCmd) rebaseProjectWRevision(jirix *jiri.X, project project.Project
 		return fmt.Errorf("Rebase: cannot get user info for HEAD: %s", err)
 	}
 	scm = gitutil.New(jirix, gitutil.UserNameOpt(name), gitutil.UserEmailOpt(email), gitutil.RootDirOpt(project.Path))
-	if err := scm.Fetch("origin", gitutil.PruneOpt(true)); err != nil {
-		jirix.Logger.Errorf("Not able to fetch origin: %v", err)
+	if err := scm.Fetch(project.PrimaryRemote(), gitutil.PruneOpt(true)); err != nil {
+		jirix.Logger.Errorf("Not able to fetch %s: %v", project.PrimaryRemote(), err)
 		jirix.IncrementFailures()
 		return nil
 	}
-	if err := scm.FetchRefspec("origin", revision); err != nil {
+	if err := scm.FetchRefspec(project.PrimaryRemote(), revision); err != nil {
 		jirix.Logger.Errorf("Not able to fetch revision %q: %s", revision, err)
 		jirix.IncrementFailures()
 		return nil
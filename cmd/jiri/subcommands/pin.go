@@ -0,0 +1,110 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/google/subcommands"
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/project"
+)
+
+type pinCmd struct {
+	cmdBase
+
+	delete bool
+}
+
+func (c *pinCmd) Name() string     { return "pin" }
+func (c *pinCmd) Synopsis() string { return "Pin a project to a revision" }
+func (c *pinCmd) Usage() string {
+	return `Pins a project to a revision in its local config. "jiri update" checks out
+pinned projects at the pinned revision regardless of the revision in the
+manifest, which avoids editing manifests for temporary bisects.
+
+Pinned projects are reported by "jiri update" and "jiri status".
+
+Usage:
+  jiri pin [flags] [<project> [<revision>]]
+
+<project> is the name or path of a project. With no arguments, the pinned
+projects are listed.
+`
+}
+
+func (c *pinCmd) SetFlags(f *flag.FlagSet) {
+	f.BoolVar(&c.delete, "delete", false, "Unpin the project.")
+}
+
+func (c *pinCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	return executeWrapper(ctx, c.run, c.topLevelFlags, f.Args())
+}
+
+func (c *pinCmd) run(jirix *jiri.X, args []string) error {
+	localProjects, err := project.LocalProjects(jirix, project.FastScan)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		if c.delete {
+			return jirix.UsageErrorf("-delete requires a project")
+		}
+		return c.list(jirix, localProjects)
+	}
+	if c.delete {
+		if len(args) != 1 {
+			return jirix.UsageErrorf("-delete takes exactly one project")
+		}
+	} else if len(args) != 2 {
+		return jirix.UsageErrorf("expected a project and a revision")
+	}
+	p, err := localProjects.FindUnique(args[0])
+	if err != nil {
+		return err
+	}
+	lc := p.LocalConfig
+	if c.delete {
+		if lc.Pin == "" {
+			return fmt.Errorf("project %s(%s) is not pinned", p.Name, p.Path)
+		}
+		lc.Pin = ""
+	} else {
+		lc.Pin = args[1]
+	}
+	if err := project.WriteLocalConfig(jirix, p, lc); err != nil {
+		return err
+	}
+	if c.delete {
+		jirix.Logger.Infof("Unpinned project %s(%s), run \"jiri update\" to check out the manifest revision.\n", p.Name, p.Path)
+	} else {
+		jirix.Logger.Infof("Pinned project %s(%s) to %s, run \"jiri update\" to check it out.\n", p.Name, p.Path, lc.Pin)
+	}
+	return nil
+}
+
+func (c *pinCmd) list(jirix *jiri.X, localProjects project.Projects) error {
+	var pinned []project.Project
+	for _, p := range localProjects {
+		if p.LocalConfig.Pin != "" {
+			pinned = append(pinned, p)
+		}
+	}
+	sort.Slice(pinned, func(i, j int) bool {
+		return pinned[i].Path < pinned[j].Path
+	})
+	for _, p := range pinned {
+		relativePath, err := filepath.Rel(jirix.Root, p.Path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(jirix.Stdout(), "%s(%s): %s\n", p.Name, relativePath, p.LocalConfig.Pin)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"strings"
+	"testing"
+
+	"go.fuchsia.dev/jiri/gitutil"
+)
+
+func TestPin(t *testing.T) {
+	localProjects, fake := setupUniverse(t)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	p := localProjects[1]
+	pinnedRev, err := gitutil.New(fake.X, gitutil.RootDirOpt(p.Path)).CurrentRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeReadme(t, fake.X, fake.Projects[p.Name], "new readme")
+
+	headRev := func() string {
+		t.Helper()
+		rev, err := gitutil.New(fake.X, gitutil.RootDirOpt(p.Path)).CurrentRevision()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rev
+	}
+
+	cmd := pinCmd{}
+	if err := cmd.run(fake.X, []string{p.Name, pinnedRev}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	if got := headRev(); got != pinnedRev {
+		t.Errorf("pinned project is at %s, want %s", got, pinnedRev)
+	}
+
+	stdout, _, err := collectStdio(fake.X, nil, cmd.run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := p.Name + "(path-1): " + pinnedRev + "\n"; stdout != want {
+		t.Errorf("pin list: got %q, want %q", stdout, want)
+	}
+
+	fake.X.Cwd = fake.X.Root
+	status := statusCmd{changes: true, checkHead: true, commits: true}
+	stdout, _, err = collectStdio(fake.X, nil, status.run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout, "Pinned: "+pinnedRev) {
+		t.Errorf("status should report the pinned project, got:\n%s", stdout)
+	}
+
+	cmd = pinCmd{delete: true}
+	if err := cmd.run(fake.X, []string{p.Name}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.run(fake.X, []string{p.Name}); err == nil {
+		t.Errorf("unpinning a project that is not pinned should fail")
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	if got := headRev(); got == pinnedRev {
+		t.Errorf("unpinned project should have been updated")
+	}
+}
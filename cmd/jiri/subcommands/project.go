@@ -10,10 +10,12 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strings"
 	"text/template"
 
 	"github.com/google/subcommands"
@@ -26,8 +28,18 @@ type projectCmd struct {
 
 	cleanAll              bool
 	cleanup               bool
+	configAttrs           string
+	configList            bool
+	configPathGlob        string
+	configSet             arrayFlag
+	configUnset           arrayFlag
+	graph                 string
 	jsonOutput            string
+	listFormat            string
+	listSort              string
 	regexp                bool
+	rename                string
+	shell                 bool
 	template              string
 	useLocalManifest      bool
 	useRemoteProjects     bool
@@ -47,18 +59,81 @@ all projects will be used. The information to be displayed can be
 specified using a Go template, supplied via
 the -template flag.
 
+With -rename, renames the given project: its entries in the manifests, the
+imports and hooks that refer to it, and its metadata are updated together.
+Manifests that cannot be written, e.g. in read-only checkouts, are skipped with
+a warning. The checkout, including local branches, is left untouched.
+
+With -graph=dot or -graph=json, prints the projects of the manifest instead,
+along with the manifest file declaring each of them, the import that pulled it
+in, and the project it is nested in. In the DOT output, solid edges go from
+imports to the projects they pull in, and dashed edges from projects to the
+projects nested in them, e.g. "jiri project -graph=dot | dot -Tsvg".
+
+With -shell, starts the shell of $SHELL, or "sh" if that's not set, in the
+given project, to quickly switch to it. The environment of the shell has
+JIRI_ROOT, JIRI_PROJECT, JIRI_GERRIT_HOST and JIRI_HEAD set for the project,
+along with the variables defined by <env> elements of the manifest, see
+"jiri env". Leaving the shell returns to the previous directory.
+
+"jiri project config" prints or changes the local config of projects, which
+overrides the manifest on this checkout only, see "jiri project-config". It
+applies to the given projects, to those whose path matches -path-glob or
+having one of the manifest attributes of -with-attrs, or to the current project
+if none are selected. Without -set or -unset, it prints the fields which are
+not at their default. The fields are:
+  ignore     true to skip the project entirely while updating
+  no-update  true to not update the project
+  no-rebase  true to not rebase or merge local branches
+  rebase     rebase policy: "never", "tracked" or "all"
+  pin        revision the project is checked out at, see "jiri pin"
+  reviewers  comma-separated reviewers added by "jiri upload"
+  hashtags   comma-separated hashtags added by "jiri upload"
+For example, to ignore all the projects under third_party:
+  jiri project -path-glob 'third_party/*' -set ignore=true config
+
+The information printed about projects includes their local config.
+
+"jiri project list" prints the projects of the manifest, checked out or not,
+with their path, description, owners, gerrit host and attributes, to help
+finding one's way in a large manifest. The description and owners come from
+the "description" and "owners" attributes of the projects in the manifest,
+and a project without a description is described by the first line of its
+README if it is checked out. It lists the given projects, those whose path
+matches -path-glob or having one of the attributes of -with-attrs, or all the
+projects if none are selected. -sort orders them by name, path, gerrithost or
+owners, and -format=json prints them as JSON instead of a table.
+
+The flags of config and list may also be given after them, among the
+projects, e.g. "jiri project config foo -set ignore=true". As config and list
+are taken as verbs, give info about a project named "config" or "list" by
+selecting it with -regexp, e.g. "jiri project -regexp '^config$'".
+
 Usage:
   jiri project [flags] <project ...>
+  jiri project [flags] config [flags] [<project ...>]
+  jiri project [flags] list [flags] [<project ...>]
 
-<project ...> is a list of projects to clean up or give info about.
+<project ...> is a list of projects to clean up, give info about or configure,
+or the project to rename or start a shell in.
 `
 }
 
 func (c *projectCmd) SetFlags(f *flag.FlagSet) {
 	f.BoolVar(&c.cleanAll, "clean-all", false, "Restore jiri projects to their pristine state and delete all branches.")
 	f.BoolVar(&c.cleanup, "clean", false, "Restore jiri projects to their pristine state.")
+	f.StringVar(&c.configAttrs, "with-attrs", "", "With config or list, also select the projects having one of these comma-separated manifest attributes.")
+	f.BoolVar(&c.configList, "list", false, "With config, print the local config of the projects after changing it.")
+	f.StringVar(&c.configPathGlob, "path-glob", "", "With config or list, also select the projects whose path, relative to the root, matches this glob.")
+	f.Var(&c.configSet, "set", "With config, set a field of the local config, as <field>=<value>. Repeatable.")
+	f.Var(&c.configUnset, "unset", "With config, reset a field of the local config to its default. Repeatable.")
+	f.StringVar(&c.graph, "graph", "", "Print the import and nesting graph of the manifest projects, in dot or json format.")
 	f.StringVar(&c.jsonOutput, "json-output", "", "Path to write operation results to.")
+	f.StringVar(&c.listFormat, "format", "", "With list, the output format: table (default) or json.")
+	f.StringVar(&c.listSort, "sort", "", "With list, sort the projects by name, path (default), gerrithost or owners.")
 	f.BoolVar(&c.regexp, "regexp", false, "Use argument as regular expression.")
+	f.StringVar(&c.rename, "rename", "", "Rename the project given as argument to this name.")
+	f.BoolVar(&c.shell, "shell", false, "Start a shell in the project given as argument, with its environment exported.")
 	f.StringVar(&c.template, "template", "", "The template for the fields to display.")
 	f.BoolVar(&c.useLocalManifest, "local-manifest", false, "List project status based on local manifest.")
 	f.Var(&c.localManifestProjects, "local-manifest-project", "Import projects whose local manifests should be respected. Repeatable.")
@@ -70,13 +145,74 @@ func (c *projectCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) sub
 }
 
 func (c *projectCmd) run(jirix *jiri.X, args []string) (e error) {
-	if c.cleanup || c.cleanAll {
+	if len(args) > 0 && (args[0] == "config" || args[0] == "list") {
+		verb := args[0]
+		if args, e = c.parseVerbFlags(verb, args[1:]); e != nil {
+			return jirix.UsageErrorf("%v", e)
+		}
+		if verb == "list" {
+			if len(c.configSet) != 0 || len(c.configUnset) != 0 || c.configList {
+				return jirix.UsageErrorf("-set, -unset and -list are only valid with config")
+			}
+			return c.runProjectList(jirix, args)
+		}
+		if c.listFormat != "" || c.listSort != "" {
+			return jirix.UsageErrorf("-format and -sort are only valid with list")
+		}
+		return c.runProjectConfig(jirix, args)
+	} else if len(c.configSet) != 0 || len(c.configUnset) != 0 || c.configList {
+		return jirix.UsageErrorf("-set, -unset and -list are only valid with config")
+	} else if c.configPathGlob != "" || c.configAttrs != "" {
+		return jirix.UsageErrorf("-path-glob and -with-attrs are only valid with config and list")
+	} else if c.listFormat != "" || c.listSort != "" {
+		return jirix.UsageErrorf("-format and -sort are only valid with list")
+	} else if c.rename != "" {
+		return c.runProjectRename(jirix, args)
+	} else if c.shell {
+		return c.runProjectShell(jirix, args)
+	} else if c.graph != "" {
+		return c.runProjectGraph(jirix, args)
+	} else if c.cleanup || c.cleanAll {
 		return c.runProjectClean(jirix, args)
 	} else {
 		return c.runProjectInfo(jirix, args)
 	}
 }
 
+// parseVerbFlags parses the flags of the config or list verb, which the
+// standard flag parsing of the command leaves in args as it stops at the
+// verb. The flags may be interleaved with the projects, which are returned.
+func (c *projectCmd) parseVerbFlags(verb string, args []string) ([]string, error) {
+	f := flag.NewFlagSet("project "+verb, flag.ContinueOnError)
+	f.SetOutput(io.Discard)
+	f.StringVar(&c.configAttrs, "with-attrs", c.configAttrs, "")
+	f.StringVar(&c.configPathGlob, "path-glob", c.configPathGlob, "")
+	if verb == "config" {
+		f.BoolVar(&c.configList, "list", c.configList, "")
+		f.Var(&c.configSet, "set", "")
+		f.Var(&c.configUnset, "unset", "")
+	} else {
+		f.StringVar(&c.listFormat, "format", c.listFormat, "")
+		f.StringVar(&c.listSort, "sort", c.listSort, "")
+	}
+	var projects []string
+	for len(args) > 0 {
+		if err := f.Parse(args); err != nil {
+			return nil, err
+		}
+		// Everything after "--" is a project.
+		if i := len(args) - f.NArg(); i > 0 && args[i-1] == "--" {
+			return append(projects, f.Args()...), nil
+		}
+		if f.NArg() == 0 {
+			break
+		}
+		projects = append(projects, f.Arg(0))
+		args = f.Args()[1:]
+	}
+	return projects, nil
+}
+
 func (c *projectCmd) runProjectClean(jirix *jiri.X, args []string) (e error) {
 	localProjects, err := project.LocalProjects(jirix, project.FullScan)
 	if err != nil {
@@ -115,6 +251,145 @@ func (c *projectCmd) runProjectClean(jirix *jiri.X, args []string) (e error) {
 	return nil
 }
 
+// runProjectRename renames the project given in args to c.rename.
+func (c *projectCmd) runProjectRename(jirix *jiri.X, args []string) error {
+	if len(args) != 1 {
+		return jirix.UsageErrorf("-rename takes exactly one project")
+	}
+	if strings.Contains(c.rename, project.KeySeparator) {
+		return fmt.Errorf("project name cannot contain %q", project.KeySeparator)
+	}
+	localProjects, err := project.LocalProjects(jirix, project.FullScan)
+	if err != nil {
+		return err
+	}
+	p, err := localProjects.FindUnique(args[0])
+	if err != nil {
+		return err
+	}
+	if p.Name == c.rename {
+		return nil
+	}
+	renamed := p
+	renamed.Name = c.rename
+	if _, ok := localProjects[renamed.Key()]; ok {
+		return fmt.Errorf("project %q with remote %q already exists", c.rename, p.Remote)
+	}
+
+	// Find the manifests that may refer to the project.
+	remoteProjects, _, pkgs, err := project.LoadManifestFile(jirix, jirix.JiriManifestFile(), localProjects, nil)
+	if err != nil {
+		return err
+	}
+	files := map[string]bool{jirix.JiriManifestFile(): true}
+	for _, rp := range remoteProjects {
+		files[rp.ManifestPath] = true
+	}
+	for _, pkg := range pkgs {
+		files[pkg.ManifestPath] = true
+	}
+	var sorted []string
+	for file := range files {
+		sorted = append(sorted, file)
+	}
+	sort.Strings(sorted)
+
+	// Rename in all writable manifests, restoring them if anything fails.
+	originals := make(map[string][]byte)
+	restore := func() {
+		for file, data := range originals {
+			if err := os.WriteFile(file, data, 0644); err != nil {
+				jirix.Logger.Errorf("failed to restore %q: %v", file, err)
+			}
+		}
+	}
+	for _, file := range sorted {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		content, changed := renameInManifest(string(data), p.Name, c.rename, p.Remote)
+		if !changed {
+			continue
+		}
+		if f, err := os.OpenFile(file, os.O_WRONLY, 0); err != nil {
+			jirix.Logger.Warningf("Not renaming project %q in %s: %v\n\n", p.Name, file, err)
+			continue
+		} else {
+			f.Close()
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			restore()
+			return err
+		}
+		originals[file] = data
+		if err := project.SafeWriteFile(jirix, file, []byte(content)); err != nil {
+			restore()
+			return err
+		}
+		if err := os.Chmod(file, info.Mode()); err != nil {
+			restore()
+			return err
+		}
+		fmt.Fprintf(jirix.Stdout(), "Updated %s\n", file)
+	}
+
+	gitDir, err := p.AbsoluteGitDir(jirix)
+	if err != nil {
+		restore()
+		return err
+	}
+	metadataFile := filepath.Join(gitDir, jiri.ProjectMetaDir, jiri.ProjectMetaFile)
+	metadata, err := project.ProjectFromFile(jirix, metadataFile)
+	if err != nil {
+		restore()
+		return err
+	}
+	metadata.Name = c.rename
+	if err := metadata.ToFile(jirix, metadataFile); err != nil {
+		restore()
+		return err
+	}
+	return nil
+}
+
+var (
+	manifestElementRE = regexp.MustCompile(`<(project|import|hook)\s[^>]*>`)
+	manifestAttrRE    = regexp.MustCompile(`([\w-]+)\s*=\s*"([^"]*)"`)
+)
+
+// renameInManifest renames the project oldName with the given remote to
+// newName in the projects, imports and hooks of the manifest content, and
+// reports whether anything changed. Elements without a remote, e.g. group
+// members, are matched by name only.
+func renameInManifest(content, oldName, newName, remote string) (string, bool) {
+	changed := false
+	content = manifestElementRE.ReplaceAllStringFunc(content, func(elem string) string {
+		attrs := make(map[string]string)
+		for _, m := range manifestAttrRE.FindAllStringSubmatch(elem, -1) {
+			attrs[m[1]] = m[2]
+		}
+		attr := "name"
+		if strings.HasPrefix(elem, "<hook") {
+			attr = "project"
+		} else if r, ok := attrs["remote"]; ok && strings.TrimSuffix(r, "/") != strings.TrimSuffix(remote, "/") {
+			return elem
+		}
+		if attrs[attr] != oldName {
+			return elem
+		}
+		changed = true
+		return manifestAttrRE.ReplaceAllStringFunc(elem, func(a string) string {
+			if m := manifestAttrRE.FindStringSubmatch(a); m[1] == attr {
+				return fmt.Sprintf("%s=%q", attr, newName)
+			}
+			return a
+		})
+	})
+	return content, changed
+}
+
 // projectInfoOutput defines JSON format for 'project info' output.
 type projectInfoOutput struct {
 	Name string `json:"name"`
@@ -128,6 +403,9 @@ type projectInfoOutput struct {
 	Branches      []string `json:"branches,omitempty"`
 	Manifest      string   `json:"manifest,omitempty"`
 	GerritHost    string   `json:"gerrithost,omitempty"`
+	// LocalConfig lists the fields of the local config of the project
+	// which are not at their default, as <field>=<value>.
+	LocalConfig []string `json:"local_config,omitempty"`
 }
 
 // runProjectInfo provides structured info on local projects.
@@ -263,6 +541,7 @@ func (c *projectCmd) runProjectInfo(jirix *jiri.X, args []string) error {
 			CurrentBranch: state.CurrentBranch.Name,
 			Manifest:      state.Project.ManifestPath,
 			GerritHost:    state.Project.GerritHost,
+			LocalConfig:   localConfigSettings(state.Project.LocalConfig),
 		}
 		for _, b := range state.Branches {
 			info[i].Branches = append(info[i].Branches, b.Name)
@@ -284,6 +563,9 @@ func (c *projectCmd) runProjectInfo(jirix *jiri.X, args []string) error {
 			if c.useRemoteProjects {
 				fmt.Fprintf(jirix.Stdout(), "  Manifest: %s\n", i.Manifest)
 			}
+			if len(i.LocalConfig) != 0 {
+				fmt.Fprintf(jirix.Stdout(), "  Config:   %s\n", strings.Join(i.LocalConfig, ", "))
+			}
 			if len(i.Branches) != 0 {
 				fmt.Fprintf(jirix.Stdout(), "  Branches:\n")
 				width := 0
@@ -8,7 +8,11 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/google/subcommands"
 	"go.fuchsia.dev/jiri"
@@ -21,6 +25,10 @@ type projectConfigCmd struct {
 	ignore   string
 	noUpdate string
 	noRebase string
+	rebase   string
+
+	reviewers string
+	hashtags  string
 }
 
 func (c *projectConfigCmd) Name() string     { return "project-config" }
@@ -28,6 +36,7 @@ func (c *projectConfigCmd) Synopsis() string { return "Prints/sets project's loc
 func (c *projectConfigCmd) Usage() string {
 	return `Prints/Manages local project config. This command should be run from inside a
 project. It will print config if no flags are provided otherwise set it.
+To configure several projects at once, see "jiri project config".
 
 Usage:
   jiri project-config [flags]
@@ -38,6 +47,9 @@ func (c *projectConfigCmd) SetFlags(f *flag.FlagSet) {
 	f.StringVar(&c.ignore, "ignore", "", `This can be true or false. If set to true project would be completely ignored while updating`)
 	f.StringVar(&c.noUpdate, "no-update", "", `This can be true or false. If set to true project won't be updated`)
 	f.StringVar(&c.noRebase, "no-rebase", "", `This can be true or false. If set to true local branch won't be rebased or merged.`)
+	f.StringVar(&c.rebase, "rebase", "", `Rebase policy of the project, overriding the one of the manifest: "never", "tracked" or "all". "-" clears it.`)
+	f.StringVar(&c.reviewers, "reviewers", "", `Comma-separated list of reviewers "jiri upload" adds to the CLs of the project. "-" clears it.`)
+	f.StringVar(&c.hashtags, "hashtags", "", `Comma-separated list of hashtags "jiri upload" adds to the CLs of the project. "-" clears it.`)
 }
 
 func (c *projectConfigCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
@@ -49,7 +61,7 @@ func (c *projectConfigCmd) run(jirix *jiri.X, args []string) error {
 	if err != nil {
 		return err
 	}
-	if c.ignore == "" && c.noUpdate == "" && c.noRebase == "" {
+	if c.ignore == "" && c.noUpdate == "" && c.noRebase == "" && c.rebase == "" && c.reviewers == "" && c.hashtags == "" {
 		displayConfig(jirix, p.LocalConfig)
 		return nil
 	}
@@ -63,6 +75,12 @@ func (c *projectConfigCmd) run(jirix *jiri.X, args []string) error {
 	if err := setBoolVar(c.noRebase, &lc.NoRebase, "no-rebase"); err != nil {
 		return err
 	}
+	setListVar(c.rebase, &lc.Rebase)
+	if err := project.ValidateRebasePolicy(lc.Rebase); err != nil {
+		return err
+	}
+	setListVar(c.reviewers, &lc.Reviewers)
+	setListVar(c.hashtags, &lc.Hashtags)
 	return project.WriteLocalConfig(jirix, p, lc)
 }
 
@@ -78,9 +96,263 @@ func setBoolVar(value string, b *bool, flagName string) error {
 	return nil
 }
 
+// setListVar sets list to value, unless value is empty. "-" clears it.
+func setListVar(value string, list *string) {
+	switch value {
+	case "":
+	case "-":
+		*list = ""
+	default:
+		*list = value
+	}
+}
+
 func displayConfig(jirix *jiri.X, lc project.LocalConfig) {
 	fmt.Fprintf(jirix.Stdout(), "Config:\n")
 	fmt.Fprintf(jirix.Stdout(), "ignore: %t\n", lc.Ignore)
 	fmt.Fprintf(jirix.Stdout(), "no-update: %t\n", lc.NoUpdate)
 	fmt.Fprintf(jirix.Stdout(), "no-rebase: %t\n", lc.NoRebase)
+	if lc.Rebase != "" {
+		fmt.Fprintf(jirix.Stdout(), "rebase: %s\n", lc.Rebase)
+	}
+	if lc.Pin != "" {
+		fmt.Fprintf(jirix.Stdout(), "pin: %s\n", lc.Pin)
+	}
+	if lc.Reviewers != "" {
+		fmt.Fprintf(jirix.Stdout(), "reviewers: %s\n", lc.Reviewers)
+	}
+	if lc.Hashtags != "" {
+		fmt.Fprintf(jirix.Stdout(), "hashtags: %s\n", lc.Hashtags)
+	}
+}
+
+// localConfigField is a field of the local config of projects, as set by
+// "jiri project config".
+type localConfigField struct {
+	name string
+	get  func(lc project.LocalConfig) string
+	// set sets the field to value, or resets it to its default if value is
+	// empty.
+	set func(lc *project.LocalConfig, value string) error
+}
+
+func boolConfigField(name string, field func(lc *project.LocalConfig) *bool) localConfigField {
+	return localConfigField{
+		name: name,
+		get: func(lc project.LocalConfig) string {
+			if *field(&lc) {
+				return "true"
+			}
+			return ""
+		},
+		set: func(lc *project.LocalConfig, value string) error {
+			if value == "" {
+				*field(lc) = false
+				return nil
+			}
+			return setBoolVar(value, field(lc), name)
+		},
+	}
+}
+
+func stringConfigField(name string, field func(lc *project.LocalConfig) *string) localConfigField {
+	return localConfigField{
+		name: name,
+		get:  func(lc project.LocalConfig) string { return *field(&lc) },
+		set: func(lc *project.LocalConfig, value string) error {
+			*field(lc) = value
+			return nil
+		},
+	}
+}
+
+// localConfigFields lists the fields of the local config, in the order they
+// are printed.
+var localConfigFields = []localConfigField{
+	boolConfigField("ignore", func(lc *project.LocalConfig) *bool { return &lc.Ignore }),
+	boolConfigField("no-update", func(lc *project.LocalConfig) *bool { return &lc.NoUpdate }),
+	boolConfigField("no-rebase", func(lc *project.LocalConfig) *bool { return &lc.NoRebase }),
+	stringConfigField("rebase", func(lc *project.LocalConfig) *string { return &lc.Rebase }),
+	stringConfigField("pin", func(lc *project.LocalConfig) *string { return &lc.Pin }),
+	stringConfigField("reviewers", func(lc *project.LocalConfig) *string { return &lc.Reviewers }),
+	stringConfigField("hashtags", func(lc *project.LocalConfig) *string { return &lc.Hashtags }),
+}
+
+func findLocalConfigField(name string) (localConfigField, error) {
+	for _, f := range localConfigFields {
+		if f.name == name {
+			return f, nil
+		}
+	}
+	var names []string
+	for _, f := range localConfigFields {
+		names = append(names, f.name)
+	}
+	return localConfigField{}, fmt.Errorf("unknown local config field %q, should be one of %s", name, strings.Join(names, ", "))
+}
+
+// localConfigSettings returns the fields of lc which are not at their
+// default, as <field>=<value>.
+func localConfigSettings(lc project.LocalConfig) []string {
+	var settings []string
+	for _, f := range localConfigFields {
+		if value := f.get(lc); value != "" {
+			settings = append(settings, f.name+"="+value)
+		}
+	}
+	return settings
+}
+
+// runProjectConfig prints or changes the local config of the projects given
+// in args, of the projects matching -path-glob and -with-attrs, or of the
+// current project if there are none.
+func (c *projectCmd) runProjectConfig(jirix *jiri.X, args []string) error {
+	projects, err := c.configProjects(jirix, args)
+	if err != nil {
+		return err
+	}
+	if len(projects) == 0 {
+		return fmt.Errorf("no project matches")
+	}
+
+	// Check all the changes before writing any config.
+	type change struct {
+		field localConfigField
+		value string
+	}
+	var changes []change
+	for _, s := range c.configSet {
+		name, value, ok := strings.Cut(s, "=")
+		if !ok || value == "" {
+			return jirix.UsageErrorf("-set %q should be <field>=<value>", s)
+		}
+		field, err := findLocalConfigField(name)
+		if err != nil {
+			return err
+		}
+		changes = append(changes, change{field, value})
+	}
+	for _, name := range c.configUnset {
+		field, err := findLocalConfigField(name)
+		if err != nil {
+			return err
+		}
+		changes = append(changes, change{field, ""})
+	}
+	for i, p := range projects {
+		lc := p.LocalConfig
+		for _, ch := range changes {
+			if err := ch.field.set(&lc, ch.value); err != nil {
+				return err
+			}
+		}
+		if err := project.ValidateRebasePolicy(lc.Rebase); err != nil {
+			return err
+		}
+		projects[i].LocalConfig = lc
+	}
+
+	for _, p := range projects {
+		if len(changes) != 0 {
+			if err := project.WriteLocalConfig(jirix, p, p.LocalConfig); err != nil {
+				return fmt.Errorf("writing the local config of project %s(%s): %v", p.Name, p.Path, err)
+			}
+		}
+		if len(changes) == 0 || c.configList {
+			printLocalConfig(jirix, p)
+		}
+	}
+	if len(changes) != 0 {
+		fmt.Fprintf(jirix.Stdout(), "Updated the local config of %d project(s)\n", len(projects))
+	}
+	return nil
+}
+
+// configProjects returns the local projects selected by args, -path-glob
+// and -with-attrs, sorted by path.
+func (c *projectCmd) configProjects(jirix *jiri.X, args []string) ([]project.Project, error) {
+	if len(args) == 0 && c.configPathGlob == "" && c.configAttrs == "" {
+		p, err := currentProject(jirix)
+		if err != nil {
+			return nil, err
+		}
+		return []project.Project{p}, nil
+	}
+	localProjects, err := project.LocalProjects(jirix, project.FastScan)
+	if err != nil {
+		return nil, err
+	}
+	if c.configPathGlob != "" {
+		if _, err := filepath.Match(c.configPathGlob, ""); err != nil {
+			return nil, fmt.Errorf("bad -path-glob %q: %v", c.configPathGlob, err)
+		}
+	}
+	var regexps []*regexp.Regexp
+	if c.regexp {
+		for _, a := range args {
+			re, err := regexp.Compile(a)
+			if err != nil {
+				return nil, fmt.Errorf("failed to compile regexp %v: %v", a, err)
+			}
+			regexps = append(regexps, re)
+		}
+	}
+	selected := make(project.Projects)
+	if !c.regexp {
+		for _, arg := range args {
+			p, err := localProjects.FindUnique(arg)
+			if err != nil {
+				return nil, err
+			}
+			selected[p.Key()] = p
+		}
+	}
+	attrs := make(map[string]bool)
+	for _, a := range strings.Split(c.configAttrs, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			attrs[a] = true
+		}
+	}
+	for key, p := range localProjects {
+		for _, re := range regexps {
+			if re.MatchString(p.Name) {
+				selected[key] = p
+			}
+		}
+		if c.configPathGlob != "" {
+			if rel, err := filepath.Rel(jirix.Root, p.Path); err == nil {
+				if ok, _ := filepath.Match(c.configPathGlob, filepath.ToSlash(rel)); ok {
+					selected[key] = p
+				}
+			}
+		}
+		for _, a := range strings.Split(p.Attributes, ",") {
+			if attrs[strings.TrimSpace(a)] {
+				selected[key] = p
+			}
+		}
+	}
+	var projects []project.Project
+	for _, p := range selected {
+		projects = append(projects, p)
+	}
+	sort.Sort(project.ProjectsByPath(projects))
+	return projects, nil
+}
+
+// printLocalConfig prints the fields of the local config of p which are not
+// at their default.
+func printLocalConfig(jirix *jiri.X, p project.Project) {
+	rel, err := filepath.Rel(jirix.Root, p.Path)
+	if err != nil {
+		rel = p.Path
+	}
+	fmt.Fprintf(jirix.Stdout(), "* project %s(%s)\n", p.Name, rel)
+	settings := localConfigSettings(p.LocalConfig)
+	if len(settings) == 0 {
+		fmt.Fprintf(jirix.Stdout(), "  (default)\n")
+	}
+	for _, s := range settings {
+		fmt.Fprintf(jirix.Stdout(), "  %s\n", s)
+	}
 }
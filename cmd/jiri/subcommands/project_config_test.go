@@ -6,6 +6,7 @@ package subcommands
 
 import (
 	"strconv"
+	"strings"
 	"testing"
 
 	"go.fuchsia.dev/jiri/jiritest"
@@ -91,3 +92,83 @@ func TestConfig(t *testing.T) {
 		ignore:   "false",
 	})
 }
+
+func TestProjectCmdConfig(t *testing.T) {
+	localProjects, fake := setupUniverse(t)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	localConfig := func(p project.Project) project.LocalConfig {
+		t.Helper()
+		p, err := project.ProjectAtPath(fake.X, p.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p.LocalConfig
+	}
+
+	// Bulk application by path glob.
+	cmd := projectCmd{configPathGlob: "path-[12]"}
+	cmd.configSet.Set("ignore=true")
+	cmd.configSet.Set("reviewers=a@example.com")
+	if _, _, err := collectStdio(fake.X, []string{"config"}, cmd.run); err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range localProjects {
+		lc := localConfig(p)
+		if got, want := lc.Ignore, i != 0; got != want {
+			t.Errorf("project %s: ignore = %t, want %t", p.Name, got, want)
+		}
+		if got, want := lc.Reviewers != "", i != 0; got != want {
+			t.Errorf("project %s: reviewers = %q", p.Name, lc.Reviewers)
+		}
+	}
+
+	// Unset a field of one project, and print its config, with the flags
+	// given after the verb and the project.
+	stdout, _, err := collectStdio(fake.X, []string{"config", localProjects[1].Name, "-unset", "ignore", "-list"}, (&projectCmd{}).run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lc := localConfig(localProjects[1]); lc.Ignore || lc.Reviewers != "a@example.com" {
+		t.Errorf("project %s: wrong config after unset: %+v", localProjects[1].Name, lc)
+	}
+	if !localConfig(localProjects[2]).Ignore {
+		t.Errorf("project %s: ignore was unset", localProjects[2].Name)
+	}
+	if !strings.Contains(stdout, "  reviewers=a@example.com\n") || strings.Contains(stdout, "ignore") {
+		t.Errorf("wrong config printed:\n%s", stdout)
+	}
+
+	// The current project is used by default.
+	fake.X.Cwd = localProjects[0].Path
+	stdout, _, err = collectStdio(fake.X, []string{"config"}, (&projectCmd{}).run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout, "* project "+localProjects[0].Name) || !strings.Contains(stdout, "(default)") {
+		t.Errorf("wrong config printed:\n%s", stdout)
+	}
+
+	// Bad changes are rejected before anything is written.
+	for _, set := range []string{"rebase=sometimes", "unknown=1", "ignore=maybe", "ignore"} {
+		cmd = projectCmd{}
+		cmd.configSet.Set(set)
+		if _, _, err := collectStdio(fake.X, []string{"config"}, cmd.run); err == nil {
+			t.Errorf("-set %s: expected an error", set)
+		}
+	}
+	if lc := localConfig(localProjects[0]); lc != (project.LocalConfig{}) {
+		t.Errorf("project %s: config changed by failed commands: %+v", localProjects[0].Name, lc)
+	}
+
+	// The project info includes the local config.
+	fake.X.Cwd = fake.X.Root
+	stdout, _, err = collectStdio(fake.X, []string{localProjects[2].Name}, (&projectCmd{}).run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout, "  Config:   ignore=true, reviewers=a@example.com\n") {
+		t.Errorf("project info doesn't include the local config:\n%s", stdout)
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/project"
+)
+
+// graphNode is a project of the graph printed by "jiri project -graph".
+type graphNode struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"`
+	Remote string `json:"remote"`
+	// Manifest is the manifest file declaring the project, relative to the
+	// jiri root when it is under it.
+	Manifest string `json:"manifest,omitempty"`
+	// ImportedBy is the name of the import that pulled in the project.
+	ImportedBy string `json:"imported_by,omitempty"`
+	// NestedIn is the name of the project whose directory contains this
+	// project.
+	NestedIn string `json:"nested_in,omitempty"`
+}
+
+// runProjectGraph prints the projects of the manifest with the imports and
+// the projects they are nested in, in format c.graph.
+func (c *projectCmd) runProjectGraph(jirix *jiri.X, args []string) error {
+	if len(args) != 0 {
+		return jirix.UsageErrorf("-graph does not take projects")
+	}
+	if c.graph != "dot" && c.graph != "json" {
+		return jirix.UsageErrorf("-graph should be dot or json, got %q", c.graph)
+	}
+	localProjects, err := project.LocalProjects(jirix, project.FastScan)
+	if err != nil {
+		return err
+	}
+	projects, _, _, err := project.LoadManifestFile(jirix, jirix.JiriManifestFile(), localProjects, c.localManifestProjects)
+	if err != nil {
+		return err
+	}
+	if err := project.FilterOptionalProjectsPackages(jirix, jirix.FetchingAttrs, projects, nil); err != nil {
+		return err
+	}
+	nodes, err := projectGraph(jirix, projects)
+	if err != nil {
+		return err
+	}
+	if c.graph == "json" {
+		out, err := json.MarshalIndent(nodes, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(jirix.Stdout(), "%s\n", out)
+		return nil
+	}
+	writeGraphDOT(jirix.Stdout(), nodes)
+	return nil
+}
+
+// projectGraph returns the nodes of projects, sorted by path.
+func projectGraph(jirix *jiri.X, projects project.Projects) ([]graphNode, error) {
+	var nodes []graphNode
+	for _, p := range projects {
+		path, err := filepath.Rel(jirix.Root, p.Path)
+		if err != nil {
+			return nil, err
+		}
+		manifest := p.ManifestPath
+		if rel, err := filepath.Rel(jirix.Root, manifest); err == nil && !strings.HasPrefix(rel, "..") {
+			manifest = rel
+		}
+		nodes = append(nodes, graphNode{
+			Name:       p.Name,
+			Path:       filepath.ToSlash(path),
+			Remote:     p.Remote,
+			Manifest:   filepath.ToSlash(manifest),
+			ImportedBy: p.ImportedBy,
+		})
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Path+"/" < nodes[j].Path+"/"
+	})
+	// Sorted by path, the projects containing a project come before it, the
+	// closest one last.
+	for i := range nodes {
+		for j := i - 1; j >= 0; j-- {
+			if nodes[j].Path == "." || strings.HasPrefix(nodes[i].Path, nodes[j].Path+"/") {
+				nodes[i].NestedIn = nodes[j].Name
+				break
+			}
+		}
+	}
+	return nodes, nil
+}
+
+// writeGraphDOT writes nodes as a graphviz graph. Solid edges go from imports
+// to the projects they pull in, dashed ones from projects to the projects
+// nested in them.
+func writeGraphDOT(w io.Writer, nodes []graphNode) {
+	fmt.Fprintln(w, "digraph jiri {")
+	fmt.Fprintln(w, "  node [shape=box];")
+	for _, n := range nodes {
+		label := n.Name + `\n` + n.Path
+		if n.Manifest != "" {
+			label += `\n` + n.Manifest
+		}
+		fmt.Fprintf(w, "  %s [label=%s];\n", dotQuote(n.Name), dotQuote(label))
+	}
+	for _, n := range nodes {
+		if n.ImportedBy != "" {
+			fmt.Fprintf(w, "  %s -> %s;\n", dotQuote(n.ImportedBy), dotQuote(n.Name))
+		}
+		if n.NestedIn != "" {
+			fmt.Fprintf(w, "  %s -> %s [style=dashed];\n", dotQuote(n.NestedIn), dotQuote(n.Name))
+		}
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// dotQuote quotes s as a DOT identifier, keeping the \n escapes of labels.
+func dotQuote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
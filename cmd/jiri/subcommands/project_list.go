@@ -0,0 +1,217 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/project"
+)
+
+// projectReadmeFiles are the files, in order of preference, that describe a
+// project without a description in the manifest.
+var projectReadmeFiles = []string{"README.md", "README", "README.txt"}
+
+// projectListOutput defines JSON format for 'project list' output.
+type projectListOutput struct {
+	Name string `json:"name"`
+	// Path is relative to the root.
+	Path        string   `json:"path"`
+	Remote      string   `json:"remote"`
+	Description string   `json:"description,omitempty"`
+	Owners      []string `json:"owners,omitempty"`
+	GerritHost  string   `json:"gerrithost,omitempty"`
+	Attributes  []string `json:"attributes,omitempty"`
+}
+
+// runProjectList lists the manifest projects selected by args, -path-glob
+// and -with-attrs with their description and owners.
+func (c *projectCmd) runProjectList(jirix *jiri.X, args []string) error {
+	switch c.listFormat {
+	case "", "table", "json":
+	default:
+		return jirix.UsageErrorf("-format should be table or json, got %q", c.listFormat)
+	}
+	less, ok := projectListOrders[c.listSort]
+	if !ok {
+		return jirix.UsageErrorf("-sort should be name, path, gerrithost or owners, got %q", c.listSort)
+	}
+	if c.configPathGlob != "" {
+		if _, err := filepath.Match(c.configPathGlob, ""); err != nil {
+			return fmt.Errorf("bad -path-glob %q: %v", c.configPathGlob, err)
+		}
+	}
+	localProjects, err := project.LocalProjects(jirix, project.FastScan)
+	if err != nil {
+		return err
+	}
+	projects, _, _, err := project.LoadManifestFile(jirix, jirix.JiriManifestFile(), localProjects, nil)
+	if err != nil {
+		return err
+	}
+
+	var regexps []*regexp.Regexp
+	names := make(map[string]bool)
+	for _, a := range args {
+		if !c.regexp {
+			names[a] = true
+			continue
+		}
+		re, err := regexp.Compile(a)
+		if err != nil {
+			return fmt.Errorf("failed to compile regexp %v: %v", a, err)
+		}
+		regexps = append(regexps, re)
+	}
+	attrs := make(map[string]bool)
+	for _, a := range strings.Split(c.configAttrs, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			attrs[a] = true
+		}
+	}
+	all := len(args) == 0 && c.configPathGlob == "" && len(attrs) == 0
+	selected := func(p project.Project, rel string) bool {
+		if all || names[p.Name] {
+			return true
+		}
+		for _, re := range regexps {
+			if re.MatchString(p.Name) {
+				return true
+			}
+		}
+		if c.configPathGlob != "" {
+			if ok, _ := filepath.Match(c.configPathGlob, filepath.ToSlash(rel)); ok {
+				return true
+			}
+		}
+		for _, a := range strings.Split(p.Attributes, ",") {
+			if attrs[strings.TrimSpace(a)] {
+				return true
+			}
+		}
+		return false
+	}
+
+	list := []projectListOutput{}
+	found := make(map[string]bool)
+	for _, p := range projects {
+		rel, err := filepath.Rel(jirix.Root, p.Path)
+		if err != nil {
+			rel = p.Path
+		}
+		if !selected(p, rel) {
+			continue
+		}
+		found[p.Name] = true
+		out := projectListOutput{
+			Name:        p.Name,
+			Path:        rel,
+			Remote:      p.Remote,
+			Description: p.Description,
+			Owners:      splitList(p.Owners),
+			GerritHost:  p.GerritHost,
+			Attributes:  splitList(p.Attributes),
+		}
+		if out.Description == "" {
+			out.Description = readmeDescription(p.Path)
+		}
+		list = append(list, out)
+	}
+	for name := range names {
+		if !found[name] {
+			return fmt.Errorf("project %q is not in the manifest", name)
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		if less(list[i], list[j]) || less(list[j], list[i]) {
+			return less(list[i], list[j])
+		}
+		return list[i].Path < list[j].Path
+	})
+
+	if c.listFormat == "json" {
+		out, err := json.MarshalIndent(list, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to serialize JSON output: %s", err)
+		}
+		fmt.Fprintln(jirix.Stdout(), string(out))
+	} else {
+		printProjectList(jirix, list)
+	}
+	if c.jsonOutput != "" {
+		if err := writeJSONOutput(c.jsonOutput, list); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// projectListOrders are the orders of "jiri project list -sort", by name.
+var projectListOrders = map[string]func(a, b projectListOutput) bool{
+	"":           func(a, b projectListOutput) bool { return a.Path < b.Path },
+	"path":       func(a, b projectListOutput) bool { return a.Path < b.Path },
+	"name":       func(a, b projectListOutput) bool { return a.Name < b.Name },
+	"gerrithost": func(a, b projectListOutput) bool { return a.GerritHost < b.GerritHost },
+	"owners": func(a, b projectListOutput) bool {
+		return strings.Join(a.Owners, ",") < strings.Join(b.Owners, ",")
+	},
+}
+
+// printProjectList prints list as a table, with the descriptions last as
+// they are the longest.
+func printProjectList(jirix *jiri.X, list []projectListOutput) {
+	rows := [][]string{{"PATH", "NAME", "OWNERS", "GERRIT HOST", "ATTRIBUTES", "DESCRIPTION"}}
+	for _, p := range list {
+		rows = append(rows, []string{p.Path, p.Name, strings.Join(p.Owners, ","), p.GerritHost, strings.Join(p.Attributes, ","), p.Description})
+	}
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		for i, cell := range row[:len(row)-1] {
+			widths[i] = max(widths[i], len(cell))
+		}
+	}
+	for r, row := range rows {
+		var line []string
+		for i, cell := range row {
+			if i < len(row)-1 {
+				cell = fmt.Sprintf("%-*s", widths[i], cell)
+			}
+			if r == 0 {
+				cell = jirix.Color.Yellow("%s", cell)
+			}
+			line = append(line, cell)
+		}
+		fmt.Fprintln(jirix.Stdout(), strings.TrimRight(strings.Join(line, "  "), " "))
+	}
+}
+
+// readmeDescription returns the first line of text of the README of the
+// project checked out in dir, without markdown heading markers, or "" if
+// there is none.
+func readmeDescription(dir string) string {
+	for _, name := range projectReadmeFiles {
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(strings.TrimLeft(scanner.Text(), "#")); line != "" {
+				return line
+			}
+		}
+		return ""
+	}
+	return ""
+}
@@ -0,0 +1,91 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"runtime"
+
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/envvar"
+	"go.fuchsia.dev/jiri/gitutil"
+	"go.fuchsia.dev/jiri/project"
+)
+
+// runProjectShell starts the shell of the user in the project given in args,
+// with the environment of projectShellEnv.
+func (c *projectCmd) runProjectShell(jirix *jiri.X, args []string) error {
+	if len(args) != 1 {
+		return jirix.UsageErrorf("-shell takes exactly one project")
+	}
+	localProjects, err := project.LocalProjects(jirix, project.FastScan)
+	if err != nil {
+		return err
+	}
+	p, err := localProjects.FindUnique(args[0])
+	if err != nil {
+		return err
+	}
+	env, err := projectShellEnv(jirix, p, localProjects)
+	if err != nil {
+		return err
+	}
+	return runShell(jirix, p.Path, env)
+}
+
+// runShell runs the shell of the user in dir, with env, until the user
+// exits it.
+func runShell(jirix *jiri.X, dir string, env map[string]string) error {
+	shell := os.Getenv("SHELL")
+	if shell == "" {
+		shell = "sh"
+		if runtime.GOOS == "windows" {
+			shell = os.Getenv("COMSPEC")
+		}
+	}
+	cmd := exec.Command(shell)
+	cmd.Env = envvar.MapToSlice(env)
+	cmd.Dir = dir
+	cmd.Stdin = jirix.Stdin()
+	cmd.Stdout = jirix.Stdout()
+	cmd.Stderr = jirix.Stderr()
+	// The exit code of the shell is the one of the last command run in it,
+	// which is not a failure of jiri.
+	var exitErr *exec.ExitError
+	if err := cmd.Run(); err != nil && !errors.As(err, &exitErr) {
+		return fmt.Errorf("running %s: %v", shell, err)
+	}
+	return nil
+}
+
+// projectShellEnv returns the environment of the shell of "jiri project
+// -shell" in p: the one of jiri, the variables defined by the manifest, and
+// JIRI_ROOT, JIRI_PROJECT, JIRI_GERRIT_HOST and JIRI_HEAD describing p.
+func projectShellEnv(jirix *jiri.X, p project.Project, localProjects project.Projects) (map[string]string, error) {
+	env := maps.Clone(jirix.Env())
+	envs, pkgs, err := project.LoadManifestEnvs(jirix, jirix.JiriManifestFile(), localProjects)
+	if err != nil {
+		return nil, err
+	}
+	for name, e := range envs {
+		value, err := e.Expand(jirix, pkgs)
+		if err != nil {
+			return nil, err
+		}
+		env[name] = value
+	}
+	env["JIRI_ROOT"] = jirix.Root
+	env["JIRI_PROJECT"] = p.Name
+	env["JIRI_GERRIT_HOST"] = p.GerritHost
+	// JIRI_HEAD is missing in projects that were never updated by jiri.
+	if head, err := gitutil.New(jirix, gitutil.RootDirOpt(p.Path)).CurrentRevisionForRef("JIRI_HEAD"); err == nil {
+		env["JIRI_HEAD"] = head
+	}
+	return env, nil
+}
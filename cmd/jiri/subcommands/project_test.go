@@ -9,8 +9,11 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
 
+	"go.fuchsia.dev/jiri/gitutil"
 	"go.fuchsia.dev/jiri/jiritest"
 	"go.fuchsia.dev/jiri/project"
 )
@@ -110,3 +113,236 @@ func TestProjectIgnoresByAttribute(t *testing.T) {
 		t.Errorf("Unexpected number of projects returned (%d, %d) (want, got)\n%v", expectedProjects, len(projectInfo), projectInfo)
 	}
 }
+
+func TestRenameInManifest(t *testing.T) {
+	content := `<manifest>
+  <projects>
+    <project name="foo" path="a" remote="https://host/foo"/>
+    <project name="foo" path="b" remote="https://other/foo"/>
+    <project name="foobar" path="c" remote="https://host/foo"/>
+  </projects>
+  <hooks>
+    <hook name="setup" project="foo" action="setup.sh"/>
+  </hooks>
+</manifest>`
+	want := `<manifest>
+  <projects>
+    <project name="bar" path="a" remote="https://host/foo"/>
+    <project name="foo" path="b" remote="https://other/foo"/>
+    <project name="foobar" path="c" remote="https://host/foo"/>
+  </projects>
+  <hooks>
+    <hook name="setup" project="bar" action="setup.sh"/>
+  </hooks>
+</manifest>`
+	got, changed := renameInManifest(content, "foo", "bar", "https://host/foo/")
+	if !changed || got != want {
+		t.Errorf("got changed %v and content:\n%s\nwant:\n%s", changed, got, want)
+	}
+	if _, changed := renameInManifest(content, "baz", "bar", "https://host/foo"); changed {
+		t.Errorf("renaming a missing project should not change the manifest")
+	}
+}
+
+func TestProjectRename(t *testing.T) {
+	localProjects, fake := setupUniverse(t)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	p := localProjects[0]
+	scm := gitutil.New(fake.X, gitutil.RootDirOpt(p.Path))
+	if err := scm.CreateBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := projectCmd{rename: "renamed"}
+	if _, _, err := collectStdio(fake.X, []string{p.Name}, cmd.run); err != nil {
+		t.Fatal(err)
+	}
+	manifest := filepath.Join(fake.X.Root, jiritest.ManifestProjectPath, jiritest.ManifestFileName)
+	data, err := os.ReadFile(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `name="renamed"`) || strings.Contains(string(data), fmt.Sprintf("name=%q", p.Name)) {
+		t.Errorf("project not renamed in manifest:\n%s", data)
+	}
+	projects, err := project.LocalProjects(fake.X, project.FullScan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	renamed, err := projects.FindUnique("renamed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renamed.Path != p.Path {
+		t.Errorf("got path %q, want %q", renamed.Path, p.Path)
+	}
+	if exists, err := scm.BranchExists("feature"); err != nil || !exists {
+		t.Errorf("local branch was not preserved: %v", err)
+	}
+
+	cmd = projectCmd{rename: "renamed"}
+	if _, _, err := collectStdio(fake.X, []string{localProjects[1].Name}, cmd.run); err != nil {
+		t.Errorf("renaming a project whose remote differs should not conflict: %v", err)
+	}
+}
+
+func TestProjectGraph(t *testing.T) {
+	localProjects, fake := setupUniverse(t)
+	if err := fake.CreateRemoteProject("nested"); err != nil {
+		t.Fatal(err)
+	}
+	writeReadme(t, fake.X, fake.Projects["nested"], "initial readme")
+	if err := fake.AddProject(project.Project{
+		Name:   "nested",
+		Path:   filepath.Join(localProjects[0].Path, "nested"),
+		Remote: fake.Projects["nested"],
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := projectCmd{graph: "json"}
+	stdout, _, err := collectStdio(fake.X, nil, cmd.run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nodes []graphNode
+	if err := json.Unmarshal([]byte(stdout), &nodes); err != nil {
+		t.Fatalf("bad json output %q: %v", stdout, err)
+	}
+	byName := make(map[string]graphNode)
+	for _, n := range nodes {
+		byName[n.Name] = n
+	}
+	manifest := filepath.ToSlash(filepath.Join(jiritest.ManifestProjectPath, jiritest.ManifestFileName))
+	want := graphNode{
+		Name:       "nested",
+		Path:       "path-0/nested",
+		Remote:     fake.Projects["nested"],
+		Manifest:   manifest,
+		ImportedBy: jiritest.ManifestProjectName,
+		NestedIn:   localProjects[0].Name,
+	}
+	if got := byName["nested"]; got != want {
+		t.Errorf("got node %+v, want %+v", got, want)
+	}
+	if got := byName[localProjects[1].Name]; got.NestedIn != "" || got.ImportedBy != jiritest.ManifestProjectName {
+		t.Errorf("got node %+v, want a top-level project imported by %q", got, jiritest.ManifestProjectName)
+	}
+
+	cmd = projectCmd{graph: "dot"}
+	stdout, _, err = collectStdio(fake.X, nil, cmd.run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, edge := range []string{
+		fmt.Sprintf("%q -> \"nested\";", jiritest.ManifestProjectName),
+		fmt.Sprintf("%q -> \"nested\" [style=dashed];", localProjects[0].Name),
+	} {
+		if !strings.Contains(stdout, edge) {
+			t.Errorf("dot output is missing %s:\n%s", edge, stdout)
+		}
+	}
+}
+
+func TestProjectList(t *testing.T) {
+	localProjects, fake := setupUniverse(t)
+	if err := fake.CreateRemoteProject("tool"); err != nil {
+		t.Fatal(err)
+	}
+	// An optional project, which is listed without being checked out.
+	if err := fake.AddProject(project.Project{
+		Name:        "tool",
+		Path:        "tools/tool",
+		Remote:      fake.Projects["tool"],
+		Description: "Builds the tool",
+		Owners:      "a@example.com, b@example.com",
+		GerritHost:  "https://review.example.com",
+		Attributes:  "tools",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := projectCmd{listFormat: "json", listSort: "name"}
+	stdout, _, err := collectStdio(fake.X, []string{"list"}, cmd.run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []projectListOutput
+	if err := json.Unmarshal([]byte(stdout), &list); err != nil {
+		t.Fatalf("bad json output %q: %v", stdout, err)
+	}
+	if len(list) != len(localProjects)+2 {
+		t.Fatalf("got %d projects, want %d: %+v", len(list), len(localProjects)+2, list)
+	}
+	for i := 1; i < len(list); i++ {
+		if list[i-1].Name > list[i].Name {
+			t.Errorf("projects are not sorted by name: %+v", list)
+		}
+	}
+	byName := make(map[string]projectListOutput)
+	for _, p := range list {
+		byName[p.Name] = p
+	}
+	tool := byName["tool"]
+	if tool.Path != filepath.Join("tools", "tool") || tool.Description != "Builds the tool" || strings.Join(tool.Owners, " ") != "a@example.com b@example.com" || tool.GerritHost != "https://review.example.com" || strings.Join(tool.Attributes, ",") != "tools" {
+		t.Errorf("unexpected listing of tool: %+v", tool)
+	}
+	// Checked out projects without a description are described by their
+	// README.
+	if got := byName[localProjects[0].Name].Description; got != "initial readme" {
+		t.Errorf("got description %q, want the README", got)
+	}
+
+	cmd = projectCmd{configAttrs: "tools"}
+	stdout, _, err = collectStdio(fake.X, []string{"list"}, cmd.run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "PATH") || !strings.Contains(lines[1], "tool") || !strings.HasSuffix(lines[1], "Builds the tool") {
+		t.Errorf("unexpected table:\n%s", stdout)
+	}
+
+	cmd = projectCmd{listSort: "size"}
+	if _, _, err := collectStdio(fake.X, []string{"list"}, cmd.run); err == nil {
+		t.Errorf("expected -sort=size to fail")
+	}
+}
+
+func TestProjectShell(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test shell is a shell script")
+	}
+	localProjects, fake := setupUniverse(t)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	shell := filepath.Join(t.TempDir(), "shell")
+	script := "#!/bin/sh\necho \"$PWD $JIRI_ROOT $JIRI_PROJECT $JIRI_HEAD\"\nexit 3\n"
+	if err := os.WriteFile(shell, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SHELL", shell)
+
+	p := localProjects[1]
+	cmd := projectCmd{shell: true}
+	stdout, _, err := collectStdio(fake.X, []string{p.Name}, cmd.run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := gitutil.New(fake.X, gitutil.RootDirOpt(p.Path)).CurrentRevisionForRef("JIRI_HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("%s %s %s %s\n", p.Path, fake.X.Root, p.Name, head); stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+}
@@ -6,7 +6,9 @@ package subcommands
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
+	"fmt"
 	"strings"
 
 	"github.com/google/subcommands"
@@ -24,8 +26,12 @@ type resolveCmd struct {
 	enablePackageVersion  bool
 	allowFloatingRefs     bool
 	fullResolve           bool
+	diffRange             string
 	hostnameAllowList     string
 	localManifestProjects arrayFlag
+	listFlags             bool
+	groupFlags
+	attrFlags
 }
 
 func (c *resolveCmd) AllowFloatingRefs() bool {
@@ -69,6 +75,14 @@ func (c *resolveCmd) FullResolve() bool {
 	return c.fullResolve
 }
 
+func (c *resolveCmd) DiffRange() string {
+	return c.diffRange
+}
+
+func (c *resolveCmd) FetchingAttrs() (string, bool) {
+	return c.attrs, c.attrsSet
+}
+
 func (c *resolveCmd) Name() string     { return "resolve" }
 func (c *resolveCmd) Synopsis() string { return "Generate jiri lockfile" }
 func (c *resolveCmd) Usage() string {
@@ -79,6 +93,28 @@ Usage:
   jiri resolve [flags] <manifest ...>
 
 <manifest ...> is a list of manifest files for lockfile generation
+
+The groups and attributes set by "jiri init" are not applied, use -group,
+-exclude-group and -attrs to resolve a subset of the manifest. With -attrs,
+the optional projects and packages whose attributes are not listed are left
+out.
+
+Full resolves write lockfiles of version 2.0, which also record the sha256 of
+each hook script and of each manifest file that was loaded. Partial resolves
+keep the version of the existing lockfile.
+
+With -diff-range, e.g. -diff-range=HEAD~1 in presubmits, a partial resolve
+compares the manifests of the repository of <manifest ...> before and after
+the given commits, and only resolves the projects and packages they add or
+change. The locks of the other ones are kept, and those of removed packages are
+dropped. Commits changing imports fall back to a full resolve.
+
+With -list-flags, jiri does not generate the lockfile but prints, as JSON,
+the flag files that "jiri update" writes for the "flag" attributes of the
+projects and packages of <manifest ...>, and which project or package of
+which manifest writes each. It fails if some of them conflict: if elements
+write different contents to the same file, or if both a project and a
+package write it. Packages are assumed to be accessible.
 `
 }
 
@@ -90,7 +126,11 @@ func (c *resolveCmd) SetFlags(f *flag.FlagSet) {
 	f.BoolVar(&c.allowFloatingRefs, "allow-floating-refs", false, "Allow packages to be pinned to floating refs such as \"latest\"")
 	f.StringVar(&c.hostnameAllowList, "allow-hosts", "", "List of hostnames that can be used in the url of a repository, separated by comma. It will not be enforced if it is left empty.")
 	f.BoolVar(&c.fullResolve, "full-resolve", false, "Resolve all project and packages, not just those are changed.")
+	f.StringVar(&c.diffRange, "diff-range", "", "Git revision range, <base>..<head> or <base> for <base>..HEAD, of the manifest changes to resolve. The locks of the projects and packages they don't change are kept.")
 	f.Var(&c.localManifestProjects, "local-manifest-project", "Import projects whose local manifests should be respected. Repeatable.")
+	f.BoolVar(&c.listFlags, "list-flags", false, "Print the flag files of the projects and packages as JSON instead of generating the lockfile, and fail on conflicting flags.")
+	c.groupFlags.setFlags(f)
+	c.attrFlags.setFlags(f)
 }
 
 func (c *resolveCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
@@ -105,6 +145,8 @@ func (c *resolveCmd) run(jirix *jiri.X, args []string) error {
 	} else {
 		manifestFiles = append(manifestFiles, args...)
 	}
+	jirix.Groups, jirix.ExcludedGroups = "", ""
+	c.groupFlags.apply(jirix)
 	if c.localManifestFlag && len(c.localManifestProjects) == 0 {
 		var err error
 		c.localManifestProjects, err = getDefaultLocalManifestProjects(jirix)
@@ -113,6 +155,10 @@ func (c *resolveCmd) run(jirix *jiri.X, args []string) error {
 		}
 	}
 
+	if c.listFlags {
+		return c.printFlags(jirix, manifestFiles)
+	}
+
 	// While revision pins for projects can be updated by 'jiri edit',
 	// instance IDs of packages can only be updated by 'jiri resolve' due
 	// to the way how cipd works. Since roller is using 'jiri resolve'
@@ -121,5 +167,23 @@ func (c *resolveCmd) run(jirix *jiri.X, args []string) error {
 	// Jiri will halt when detecting conflicts in locks. So to make it work,
 	// we need to temporarily disable the conflicts detection.
 	jirix.IgnoreLockConflicts = true
+	// Expired overrides are temporary pins which must not make it into
+	// lockfiles.
+	jirix.FailExpiredOverrides = true
 	return project.GenerateJiriLockFile(jirix, manifestFiles, c)
 }
+
+func (c *resolveCmd) printFlags(jirix *jiri.X, manifestFiles []string) error {
+	report, err := project.ListFlags(jirix, manifestFiles, c)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(jirix.Stdout(), "%s\n", data); err != nil {
+		return err
+	}
+	return report.Err()
+}
@@ -0,0 +1,250 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"path/filepath"
+
+	"github.com/google/subcommands"
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/gitutil"
+	"go.fuchsia.dev/jiri/project"
+)
+
+type resolveConflictsCmd struct {
+	cmdBase
+
+	open        bool
+	continueOps bool
+	abortAll    bool
+}
+
+func (c *resolveConflictsCmd) Name() string { return "resolve-conflicts" }
+func (c *resolveConflictsCmd) Synopsis() string {
+	return "Resolve the rebases and cherry-picks stopped on conflicts"
+}
+func (c *resolveConflictsCmd) Usage() string {
+	return `Lists the projects with a rebase or a cherry-pick stopped on conflicts, and
+those with local branches that "jiri update" could not rebase onto their
+upstream, or onto JIRI_HEAD with -rebase-untracked, as rebasing them
+conflicts. The update aborts those rebases, and records the branches in the
+metadata of the project until they are rebased or deleted.
+
+With -open, each project is opened in turn, in a shell started in the
+project with the environment of "jiri project -shell". The branches that
+failed to rebase are checked out and rebased again first, stopping on the
+conflicts, to resolve them in the shell, e.g. with "git mergetool" and
+"git rebase --continue". Exiting the shell moves on to the next project.
+
+With -continue, the rebases and cherry-picks stopped in projects are
+continued, once their conflicts are resolved and staged, keeping the messages
+of the commits. The projects where conflicts remain are listed.
+
+With -abort-all, the rebases and cherry-picks stopped in projects are
+aborted, restoring their branches as they were. The branches that failed to
+rebase are still listed, until they are rebased or deleted.
+
+Usage:
+  jiri resolve-conflicts [flags]
+`
+}
+
+func (c *resolveConflictsCmd) SetFlags(f *flag.FlagSet) {
+	f.BoolVar(&c.open, "open", false, "Open a shell in each project with conflicts, one after the other, starting the rebases that failed.")
+	f.BoolVar(&c.continueOps, "continue", false, "Continue the rebases and cherry-picks stopped in projects.")
+	f.BoolVar(&c.abortAll, "abort-all", false, "Abort the rebases and cherry-picks stopped in projects.")
+}
+
+func (c *resolveConflictsCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	return executeWrapper(ctx, c.run, c.topLevelFlags, f.Args())
+}
+
+func (c *resolveConflictsCmd) run(jirix *jiri.X, args []string) error {
+	if len(args) != 0 {
+		return jirix.UsageErrorf("unexpected arguments")
+	}
+	modes := 0
+	for _, set := range []bool{c.open, c.continueOps, c.abortAll} {
+		if set {
+			modes++
+		}
+	}
+	if modes > 1 {
+		return jirix.UsageErrorf("-open, -continue and -abort-all are mutually exclusive")
+	}
+	localProjects, err := project.LocalProjects(jirix, project.FastScan)
+	if err != nil {
+		return err
+	}
+	conflicts, err := project.FindConflicts(jirix, localProjects)
+	if err != nil {
+		return err
+	}
+	switch {
+	case c.open:
+		if err := jirix.LockRoot(c.Name(), false); err != nil {
+			return err
+		}
+		return c.openAll(jirix, localProjects, conflicts)
+	case c.continueOps:
+		if err := jirix.LockRoot(c.Name(), false); err != nil {
+			return err
+		}
+		return c.continueAll(jirix, conflicts)
+	case c.abortAll:
+		if err := jirix.LockRoot(c.Name(), false); err != nil {
+			return err
+		}
+		return c.abortAllInProgress(jirix, conflicts)
+	}
+	if len(conflicts) == 0 {
+		fmt.Fprintln(jirix.Stdout(), "No conflicts to resolve.")
+		return nil
+	}
+	for _, conflict := range conflicts {
+		printConflict(jirix, conflict)
+	}
+	fmt.Fprintf(jirix.Stdout(), "\nRun %s to resolve them one project after the other.\n", jirix.Color.Yellow("jiri resolve-conflicts -open"))
+	return nil
+}
+
+// printConflict prints what there is to resolve in the project of conflict.
+func printConflict(jirix *jiri.X, conflict project.Conflict) {
+	name := fmt.Sprintf("%s (%s)", relativeToCwd(jirix, conflict.Project.Path), conflict.Project.Name)
+	if conflict.InProgress != "" {
+		fmt.Fprintf(jirix.Stdout(), "%s: %s stopped on conflicts\n", name, conflict.InProgress)
+	}
+	for _, r := range conflict.FailedRebases {
+		fmt.Fprintf(jirix.Stdout(), "%s: branch %q failed to rebase onto %s\n", name, r.Branch, r.Onto)
+	}
+}
+
+// relativeToCwd returns path relative to the working directory of jiri, or
+// path itself if it cannot be.
+func relativeToCwd(jirix *jiri.X, path string) string {
+	if rel, err := filepath.Rel(jirix.Cwd, path); err == nil {
+		return rel
+	}
+	return path
+}
+
+// openAll opens a shell in each project of conflicts in turn, starting its
+// failed rebases one after the other until one stops on conflicts.
+func (c *resolveConflictsCmd) openAll(jirix *jiri.X, localProjects project.Projects, conflicts []project.Conflict) error {
+	if len(conflicts) == 0 {
+		fmt.Fprintln(jirix.Stdout(), "No conflicts to resolve.")
+		return nil
+	}
+	for i, conflict := range conflicts {
+		p := conflict.Project
+		scm := gitutil.New(jirix, gitutil.RootDirOpt(p.Path))
+		rebases := conflict.FailedRebases
+		for {
+			inProgress, err := scm.InProgress()
+			if err != nil {
+				return err
+			}
+			if inProgress == "" {
+				if len(rebases) == 0 {
+					break
+				}
+				r := rebases[0]
+				rebases = rebases[1:]
+				stopped, err := project.StartRebase(jirix, p, r)
+				if err != nil {
+					jirix.Logger.Errorf("For project %s(%s), cannot rebase branch %q onto %s: %v\n\n", p.Name, relativeToCwd(jirix, p.Path), r.Branch, r.Onto, err)
+					jirix.IncrementFailures()
+					continue
+				}
+				if !stopped {
+					fmt.Fprintf(jirix.Stdout(), "%s (%s): rebased branch %q onto %s without conflicts\n", relativeToCwd(jirix, p.Path), p.Name, r.Branch, r.Onto)
+					continue
+				}
+				inProgress = gitutil.RebaseInProgress
+			}
+			fmt.Fprintf(jirix.Stdout(), "[%d/%d] %s (%s): %s stopped on conflicts. Resolve them in this shell, then exit it to move on.\n",
+				i+1, len(conflicts), relativeToCwd(jirix, p.Path), p.Name, inProgress)
+			env, err := projectShellEnv(jirix, p, localProjects)
+			if err != nil {
+				return err
+			}
+			if err := runShell(jirix, p.Path, env); err != nil {
+				return err
+			}
+			if still, err := scm.InProgress(); err != nil {
+				return err
+			} else if still != "" {
+				fmt.Fprintf(jirix.Stdout(), "%s (%s): the %s is still stopped, finish it with %s or %s\n",
+					relativeToCwd(jirix, p.Path), p.Name, still, jirix.Color.Yellow("jiri resolve-conflicts -continue"), jirix.Color.Yellow("jiri resolve-conflicts -abort-all"))
+				break
+			}
+		}
+	}
+	if jirix.Failures() != 0 {
+		return fmt.Errorf("some branches could not be rebased")
+	}
+	return nil
+}
+
+// continueAll continues the rebases and cherry-picks stopped in the projects
+// of conflicts.
+func (c *resolveConflictsCmd) continueAll(jirix *jiri.X, conflicts []project.Conflict) error {
+	remaining := 0
+	for _, conflict := range conflicts {
+		if conflict.InProgress == "" {
+			continue
+		}
+		p := conflict.Project
+		scm := gitutil.New(jirix, gitutil.RootDirOpt(p.Path))
+		var err error
+		if conflict.InProgress == gitutil.RebaseInProgress {
+			err = scm.RebaseContinue()
+		} else {
+			err = scm.CherryPickContinue()
+		}
+		if err != nil {
+			remaining++
+			jirix.Logger.Errorf("For project %s(%s), cannot continue the %s: %v\n\n", p.Name, relativeToCwd(jirix, p.Path), conflict.InProgress, err)
+			continue
+		}
+		fmt.Fprintf(jirix.Stdout(), "%s (%s): continued the %s\n", relativeToCwd(jirix, p.Path), p.Name, conflict.InProgress)
+	}
+	if remaining > 0 {
+		return fmt.Errorf("conflicts remain in %d projects", remaining)
+	}
+	return nil
+}
+
+// abortAllInProgress aborts the rebases and cherry-picks stopped in the
+// projects of conflicts.
+func (c *resolveConflictsCmd) abortAllInProgress(jirix *jiri.X, conflicts []project.Conflict) error {
+	failed := 0
+	for _, conflict := range conflicts {
+		if conflict.InProgress == "" {
+			continue
+		}
+		p := conflict.Project
+		scm := gitutil.New(jirix, gitutil.RootDirOpt(p.Path))
+		var err error
+		if conflict.InProgress == gitutil.RebaseInProgress {
+			err = scm.RebaseAbort()
+		} else {
+			err = scm.CherryPickAbort()
+		}
+		if err != nil {
+			failed++
+			jirix.Logger.Errorf("For project %s(%s), cannot abort the %s: %v\n\n", p.Name, relativeToCwd(jirix, p.Path), conflict.InProgress, err)
+			continue
+		}
+		fmt.Fprintf(jirix.Stdout(), "%s (%s): aborted the %s\n", relativeToCwd(jirix, p.Path), p.Name, conflict.InProgress)
+	}
+	if failed > 0 {
+		return fmt.Errorf("cannot abort the operations of %d projects", failed)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.fuchsia.dev/jiri/gitutil"
+	"go.fuchsia.dev/jiri/project"
+)
+
+func TestResolveConflicts(t *testing.T) {
+	t.Parallel()
+
+	localProjects, fake := setupUniverse(t)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	p := localProjects[1]
+	scm := gitutil.New(fake.X, gitutil.RootDirOpt(p.Path), gitutil.UserNameOpt("John Doe"), gitutil.UserEmailOpt("john.doe@example.com"))
+	// The rebases run by jiri commit too.
+	if err := scm.Config("user.name", "John Doe"); err != nil {
+		t.Fatal(err)
+	}
+	if err := scm.Config("user.email", "john.doe@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := scm.CreateBranchWithUpstream("feature", "origin/main"); err != nil {
+		t.Fatal(err)
+	}
+	if err := scm.Checkout("feature"); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, scm, "README", "local readme")
+	writeReadme(t, fake.X, fake.Projects[p.Name], "remote readme")
+	if err := project.UpdateUniverse(fake.X, project.UpdateUniverseParams{
+		RebaseTracked:        true,
+		RunHookTimeout:       project.DefaultHookTimeout,
+		FetchPackagesTimeout: project.DefaultPackageTimeout,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if fake.X.Failures() == 0 {
+		t.Fatalf("expected the rebase of the feature branch to fail")
+	}
+
+	list := func(want string) {
+		t.Helper()
+		stdout, _, err := collectStdio(fake.X, nil, (&resolveConflictsCmd{}).run)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(stdout, want) {
+			t.Errorf("expected %q in the list, got:\n%s", want, stdout)
+		}
+	}
+	list(`branch "feature" failed to rebase onto origin/main`)
+
+	conflicts, err := project.FindConflicts(fake.X, project.Projects{p.Key(): p})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conflicts) != 1 || len(conflicts[0].FailedRebases) != 1 {
+		t.Fatalf("got conflicts %+v, want the failed rebase of feature", conflicts)
+	}
+	rebase := conflicts[0].FailedRebases[0]
+	if stopped, err := project.StartRebase(fake.X, p, rebase); err != nil || !stopped {
+		t.Fatalf("expected the rebase to stop on conflicts, got %t, %v", stopped, err)
+	}
+	list("rebase stopped on conflicts")
+
+	if _, _, err := collectStdio(fake.X, nil, (&resolveConflictsCmd{abortAll: true}).run); err != nil {
+		t.Fatal(err)
+	}
+	if inProgress, err := scm.InProgress(); err != nil || inProgress != "" {
+		t.Fatalf("expected the rebase to be aborted, got %q, %v", inProgress, err)
+	}
+	list(`branch "feature" failed to rebase onto origin/main`)
+
+	// Continuing fails until the conflicts are resolved.
+	if _, err := project.StartRebase(fake.X, p, rebase); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := collectStdio(fake.X, nil, (&resolveConflictsCmd{continueOps: true}).run); err == nil {
+		t.Errorf("expected -continue to fail with unresolved conflicts")
+	}
+	if err := os.WriteFile(filepath.Join(p.Path, "README"), []byte("merged readme"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := scm.Add("README"); err != nil {
+		t.Fatal(err)
+	}
+	// The editor of the user is not run.
+	fake.X.Env()["GIT_EDITOR"] = "false"
+	if _, _, err := collectStdio(fake.X, nil, (&resolveConflictsCmd{continueOps: true}).run); err != nil {
+		t.Fatal(err)
+	}
+	list("No conflicts to resolve.")
+
+	if _, _, err := collectStdio(fake.X, nil, (&resolveConflictsCmd{open: true, abortAll: true}).run); err == nil {
+		t.Errorf("expected -open and -abort-all to be mutually exclusive")
+	}
+}
@@ -5,10 +5,16 @@
 package subcommands
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
+	"go.fuchsia.dev/jiri/gitutil"
 	"go.fuchsia.dev/jiri/jiritest"
 	"go.fuchsia.dev/jiri/project"
 )
@@ -58,6 +64,109 @@ func TestResolveProjects(t *testing.T) {
 	}
 }
 
+func TestResolveTagRevision(t *testing.T) {
+	t.Parallel()
+
+	localProjects, fakeroot := setupUniverse(t)
+	remoteDir := fakeroot.Projects[localProjects[0].Name]
+	writeReadme(t, fakeroot.X, remoteDir, "tagged commit")
+	git := gitutil.New(fakeroot.X, gitutil.RootDirOpt(remoteDir), gitutil.UserNameOpt("John Doe"), gitutil.UserEmailOpt("john.doe@example.com"))
+	taggedRev, err := git.CurrentRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := git.CreateAnnotatedTag("v1", "release v1"); err != nil {
+		t.Fatal(err)
+	}
+	m, err := fakeroot.ReadRemoteManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range m.Projects {
+		if p.Name == localProjects[0].Name {
+			m.Projects[i].Revision = "refs/tags/v1"
+		}
+	}
+	if err := fakeroot.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := fakeroot.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+
+	lockPath := filepath.Join(fakeroot.X.Root, "jiri.lock")
+	cmd := resolveCmd{
+		lockFilePath:      lockPath,
+		enableProjectLock: true,
+	}
+	if err := cmd.run(fakeroot.X, nil); err != nil {
+		t.Fatalf("resolve failed due to error %v", err)
+	}
+	data, err := os.ReadFile(lockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	projLocks, _, _, err := project.UnmarshalLockEntries(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, lock := range projLocks {
+		if lock.Name == localProjects[0].Name && lock.Revision != taggedRev {
+			t.Errorf("expecting revision %q for project %q, got %q", taggedRev, lock.Name, lock.Revision)
+		}
+	}
+}
+
+func TestResolveDigests(t *testing.T) {
+	t.Parallel()
+
+	localProjects, fakeroot := setupUniverse(t)
+	remoteDir := fakeroot.Projects[localProjects[0].Name]
+	script := []byte("#!/bin/sh\nexit 0\n")
+	if err := os.WriteFile(filepath.Join(remoteDir, "hook.sh"), script, 0755); err != nil {
+		t.Fatal(err)
+	}
+	git := gitutil.New(fakeroot.X, gitutil.RootDirOpt(remoteDir), gitutil.UserNameOpt("John Doe"), gitutil.UserEmailOpt("john.doe@example.com"))
+	if err := git.CommitFile("hook.sh", "add hook"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fakeroot.AddHook(project.Hook{Name: "hook", Action: "hook.sh", ProjectName: localProjects[0].Name}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fakeroot.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+
+	lockPath := filepath.Join(fakeroot.X.Root, "jiri.lock")
+	cmd := resolveCmd{
+		lockFilePath:      lockPath,
+		enableProjectLock: true,
+	}
+	if err := cmd.run(fakeroot.X, nil); err != nil {
+		t.Fatalf("resolve failed due to error %v", err)
+	}
+	data, err := os.ReadFile(lockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, version, err := project.UnmarshalLockEntries(data); err != nil || version != "2.0" {
+		t.Fatalf("expecting a lockfile of version 2.0, got %q, %v", version, err)
+	}
+	digests, err := project.UnmarshalLockDigests(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(script)
+	hookLock := digests.Hooks[project.MakeHookKey("hook", localProjects[0].Name)]
+	if want := hex.EncodeToString(sum[:]); hookLock.Digest != want {
+		t.Errorf("expecting digest %q for the hook, got %+v", want, hookLock)
+	}
+	for _, path := range []string{".jiri_manifest", "manifest/public"} {
+		if m, ok := digests.Manifests[path]; !ok || m.Digest == "" {
+			t.Errorf("expecting a digest for manifest %q, got %+v", path, digests.Manifests)
+		}
+	}
+}
 func TestResolvePackages(t *testing.T) {
 	t.Parallel()
 
@@ -245,3 +354,145 @@ func TestResolvePackagesPartial(t *testing.T) {
 		}
 	}
 }
+
+func TestResolveDiffRange(t *testing.T) {
+	t.Parallel()
+
+	localProjects, fakeroot := setupUniverse(t)
+	if err := fakeroot.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	manifestFile := filepath.Join(fakeroot.X.Root, jiritest.ManifestProjectPath, jiritest.ManifestFileName)
+	lockPath := filepath.Join(fakeroot.X.Root, "jiri.lock")
+	cmd := resolveCmd{
+		lockFilePath:      lockPath,
+		enableProjectLock: true,
+	}
+	if err := cmd.run(fakeroot.X, []string{manifestFile}); err != nil {
+		t.Fatal(err)
+	}
+	readLocks := func() map[string]string {
+		data, err := os.ReadFile(lockPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		projLocks, _, _, err := project.UnmarshalLockEntries(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		revisions := make(map[string]string)
+		for _, lock := range projLocks {
+			revisions[lock.Name] = lock.Revision
+		}
+		return revisions
+	}
+
+	// Mark the lock of a project not changed by the manifest commit, to check
+	// that it is kept as is.
+	p0, p1 := localProjects[0], localProjects[1]
+	data, err := os.ReadFile(lockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	projLocks, pkgLocks, version, err := project.UnmarshalLockEntries(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digests, err := project.UnmarshalLockDigests(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, lock := range projLocks {
+		if lock.Name == p1.Name {
+			lock.Revision = "kept"
+			projLocks[k] = lock
+		}
+	}
+	if data, err = project.MarshalLockFile(projLocks, pkgLocks, digests, version); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(lockPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	writeReadme(t, fakeroot.X, fakeroot.Projects[p0.Name], "new readme")
+	newRev, err := gitutil.New(fakeroot.X, gitutil.RootDirOpt(fakeroot.Projects[p0.Name])).CurrentRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := project.ManifestFromFile(fakeroot.X, manifestFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range m.Projects {
+		if m.Projects[i].Name == p0.Name {
+			m.Projects[i].Revision = newRev
+		}
+	}
+	if err := m.ToFile(fakeroot.X, manifestFile); err != nil {
+		t.Fatal(err)
+	}
+	git := gitutil.New(fakeroot.X, gitutil.RootDirOpt(filepath.Dir(manifestFile)), gitutil.UserNameOpt("John Doe"), gitutil.UserEmailOpt("john.doe@example.com"))
+	if err := git.CommitFile(manifestFile, "pin project"); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd = resolveCmd{
+		lockFilePath:      lockPath,
+		enableProjectLock: true,
+		diffRange:         "HEAD~1",
+	}
+	if err := cmd.run(fakeroot.X, []string{manifestFile}); err != nil {
+		t.Fatal(err)
+	}
+	revisions := readLocks()
+	if got := revisions[p0.Name]; got != newRev {
+		t.Errorf("got revision %q for changed project %q, want %q", got, p0.Name, newRev)
+	}
+	if got := revisions[p1.Name]; got != "kept" {
+		t.Errorf("got revision %q for unchanged project %q, want the existing lock", got, p1.Name)
+	}
+}
+
+func TestResolveListFlags(t *testing.T) {
+	t.Parallel()
+
+	fakeroot := jiritest.NewFakeJiriRoot(t)
+	manifest := []byte(`
+<manifest>
+	<projects>
+		<project name="a" path="a" remote="https://example.com/a" flag="build/a.gni|a = true|a = false"/>
+		<project name="b" path="b" remote="https://example.com/b" flag="build/shared.gni|b = true|b = false"/>
+	</projects>
+	<packages>
+		<package name="pkg/c" version="version:1" path="c" flag="build/shared.gni|c = true|c = false"/>
+	</packages>
+</manifest>
+`)
+	if err := os.WriteFile(fakeroot.X.JiriManifestFile(), manifest, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := resolveCmd{listFlags: true}
+	stdout, _, err := collectStdio(fakeroot.X, nil, cmd.run)
+	if err == nil || !strings.Contains(err.Error(), `"build/shared.gni"`) {
+		t.Errorf("expected a conflict for build/shared.gni, got %v", err)
+	}
+	var report project.FlagReport
+	if err := json.Unmarshal([]byte(stdout), &report); err != nil {
+		t.Fatalf("invalid report %q: %v", stdout, err)
+	}
+	want := []project.FlagWrite{
+		{File: "build/a.gni", Content: "a = true", Kind: "project", Name: "a", Source: ".jiri_manifest"},
+		{File: "build/shared.gni", Content: "c = true", Kind: "package", Name: "pkg/c", Source: ".jiri_manifest"},
+		{File: "build/shared.gni", Content: "b = true", Kind: "project", Name: "b", Source: ".jiri_manifest"},
+	}
+	if !reflect.DeepEqual(report.Writes, want) {
+		t.Errorf("got writes %+v, want %+v", report.Writes, want)
+	}
+	if len(report.Conflicts) != 1 || report.Conflicts[0].File != "build/shared.gni" {
+		t.Errorf("got conflicts %+v", report.Conflicts)
+	}
+	if _, err := os.Stat(filepath.Join(fakeroot.X.Root, "jiri.lock")); !os.IsNotExist(err) {
+		t.Errorf("no lockfile should be written with -list-flags: %v", err)
+	}
+}
@@ -0,0 +1,319 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/subcommands"
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/gerrit"
+	"go.fuchsia.dev/jiri/gitutil"
+	"go.fuchsia.dev/jiri/project"
+)
+
+type rollImportCmd struct {
+	cmdBase
+
+	revision  string
+	branch    string
+	lockfile  bool
+	upload    bool
+	reviewers string
+	dryRun    bool
+}
+
+func (c *rollImportCmd) Name() string { return "roll-import" }
+func (c *rollImportCmd) Synopsis() string {
+	return "Roll an import of a manifest to a new revision"
+}
+func (c *rollImportCmd) Usage() string {
+	return `Rolls the <import> of a manifest to the latest revision of its remote
+branch, or to the revision given with -revision, and commits the change to a
+new branch of the git repository containing the manifest.
+
+The lockfile next to the manifest, if any, is regenerated. The commit message
+lists the projects added, deleted or updated by the roll, including those
+imported transitively. With -upload, the commit is then sent to Gerrit for
+review.
+
+The manifest repository must not have uncommitted changes. If the roll fails
+before it is committed, the branch checked out before is checked out again.
+
+Usage:
+  jiri roll-import [flags] <manifest> <import>
+
+<manifest> is the path of the manifest containing the import.
+<import> is the name of the import to roll.
+`
+}
+
+func (c *rollImportCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&c.revision, "revision", "", "Revision to roll the import to, instead of the latest revision of its remote branch.")
+	f.StringVar(&c.branch, "branch", "", "Name of the branch to create for the roll. Defaults to roll-<import>-<revision>.")
+	f.BoolVar(&c.lockfile, "lockfile", true, "Regenerate the lockfile next to the manifest, if there is one.")
+	f.BoolVar(&c.upload, "upload", false, "Upload the roll to Gerrit.")
+	f.StringVar(&c.reviewers, "r", "", "Comma-separated list of emails or LDAPs to request review of the upload.")
+	f.BoolVar(&c.dryRun, "dry-run", false, "Print the commit message of the roll without changing anything.")
+}
+
+func (c *rollImportCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	return executeWrapper(ctx, c.run, c.topLevelFlags, f.Args())
+}
+
+func (c *rollImportCmd) run(jirix *jiri.X, args []string) error {
+	if len(args) != 2 {
+		return jirix.UsageErrorf("wrong number of arguments")
+	}
+	manifestPath, err := filepath.Abs(args[0])
+	if err != nil {
+		return err
+	}
+	m, err := project.ManifestFromFile(jirix, manifestPath)
+	if err != nil {
+		return err
+	}
+	var imp *project.Import
+	for i := range m.Imports {
+		if m.Imports[i].Name == args[1] {
+			imp = &m.Imports[i]
+			break
+		}
+	}
+	if imp == nil {
+		return fmt.Errorf("import %q not found in %s", args[1], manifestPath)
+	}
+
+	scm := gitutil.New(jirix, gitutil.RootDirOpt(filepath.Dir(manifestPath)), gitutil.ProjectOpt(imp.Name))
+	newRevision := c.revision
+	if newRevision == "" {
+		branch := "main"
+		if imp.RemoteBranch != "" {
+			branch = imp.RemoteBranch
+		}
+		out, err := scm.LsRemote(imp.Remote, fmt.Sprintf("refs/heads/%s", branch))
+		if err != nil {
+			return err
+		}
+		newRevision = strings.Fields(out)[0]
+	}
+	if imp.Revision == newRevision {
+		fmt.Fprintf(jirix.Stdout(), "Import %s is already at %s\n", imp.Name, newRevision)
+		return nil
+	}
+
+	diff, err := importDiff(jirix, *imp, newRevision)
+	if err != nil {
+		return err
+	}
+	message := rollMessage(*imp, newRevision, diff)
+	if c.dryRun {
+		fmt.Fprint(jirix.Stdout(), message)
+		return nil
+	}
+
+	if changes, err := scm.HasUncommittedChanges(); err != nil {
+		return err
+	} else if changes {
+		return fmt.Errorf("the repository of %s has uncommitted changes", manifestPath)
+	}
+	remoteBranch, err := scm.RemoteBranchName()
+	if err != nil {
+		return err
+	}
+	if remoteBranch == "" {
+		remoteBranch = "main"
+	}
+	branch := c.branch
+	if branch == "" {
+		branch = fmt.Sprintf("roll-%s-%s", strings.ReplaceAll(imp.Name, "/", "-"), shortRevision(newRevision))
+	}
+	// The branch or, if HEAD is detached, the revision to go back to if the
+	// roll fails.
+	detached := !scm.IsOnBranch()
+	var original string
+	if detached {
+		original, err = scm.CurrentRevision()
+	} else {
+		original, err = scm.CurrentBranchName()
+	}
+	if err != nil {
+		return err
+	}
+	if err := scm.CreateAndCheckoutBranch(branch); err != nil {
+		return err
+	}
+	if err := c.commitRoll(jirix, scm, manifestPath, *imp, newRevision, message); err != nil {
+		if err2 := scm.Checkout(original, gitutil.ForceOpt(true), gitutil.DetachOpt(detached)); err2 != nil {
+			jirix.Logger.Warningf("Cannot check out %s again: %v\n\n", original, err2)
+		} else if err2 := scm.DeleteBranch(branch, gitutil.ForceOpt(true)); err2 != nil {
+			jirix.Logger.Warningf("Cannot delete branch %s: %v\n\n", branch, err2)
+		}
+		return err
+	}
+	fmt.Fprintf(jirix.Stdout(), "Committed the roll of %s to branch %s\n", imp.Name, branch)
+
+	if !c.upload {
+		return nil
+	}
+	topLevel, err := scm.TopLevel()
+	if err != nil {
+		return err
+	}
+	opts := gerrit.CLOpts{
+		Presubmit:    gerrit.PresubmitTestTypeAll,
+		RemoteBranch: remoteBranch,
+		Remote:       "origin",
+		Reviewers:    parseEmails(c.reviewers),
+		Verify:       true,
+	}
+	if err := gerrit.Push(jirix, topLevel, opts); err != nil {
+		return uploadError(err.Error())
+	}
+	return nil
+}
+
+// commitRoll sets the revision of imp to newRevision in the manifest at
+// manifestPath, updates the lockfile next to it, if any, and commits them with
+// message.
+func (c *rollImportCmd) commitRoll(jirix *jiri.X, scm *gitutil.Git, manifestPath string, imp project.Import, newRevision, message string) error {
+	info, err := os.Stat(manifestPath)
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return err
+	}
+	manifestContent, err := updateRevision(string(content), "import", imp.Revision, newRevision, imp.Name)
+	if err != nil {
+		return err
+	}
+	if err := project.SafeWriteFileMode(jirix, manifestPath, []byte(manifestContent), info.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := scm.Add(manifestPath); err != nil {
+		return err
+	}
+	lockfile := filepath.Join(filepath.Dir(manifestPath), jirix.LockfileName)
+	if info, err := os.Stat(lockfile); err == nil && info.Mode().IsRegular() {
+		// Without regenerating the lockfile, its digest of the manifest
+		// still has to match the rolled manifest.
+		if c.lockfile {
+			err = regenerateLockfile(jirix, manifestPath, lockfile)
+		} else {
+			err = project.UpdateLockfileManifestDigest(jirix, manifestPath)
+		}
+		if err != nil {
+			return err
+		}
+		if err := scm.Add(lockfile); err != nil {
+			return err
+		}
+	}
+	return scm.CommitWithMessage(message)
+}
+
+// importDiff returns the projects changed by rolling imp to newRevision,
+// including the ones it imports transitively.
+func importDiff(jirix *jiri.X, imp project.Import, newRevision string) (*Diff, error) {
+	localProjects, err := project.LocalProjects(jirix, project.FastScan)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp("", "jiri-roll-import")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	var projects []project.Projects
+	for i, rev := range []string{imp.Revision, newRevision} {
+		imp.Revision = rev
+		m := project.Manifest{Imports: []project.Import{imp}}
+		file := filepath.Join(dir, fmt.Sprintf("manifest-%d", i))
+		if err := m.ToFile(jirix, file); err != nil {
+			return nil, err
+		}
+		p, _, _, err := project.LoadUpdatedManifestFile(jirix, file, localProjects, nil)
+		if err != nil {
+			return nil, err
+		}
+		projects = append(projects, p)
+	}
+	return (&diffCmd{}).diffProjects(jirix, projects[0], projects[1])
+}
+
+// rollMessage returns the commit message of the roll of imp to newRevision.
+func rollMessage(imp project.Import, newRevision string, diff *Diff) string {
+	var b strings.Builder
+	oldRevision := imp.Revision
+	if oldRevision == "" {
+		oldRevision = "HEAD"
+	}
+	fmt.Fprintf(&b, "[roll] Roll %s %s..%s\n\n", imp.Name, shortRevision(oldRevision), shortRevision(newRevision))
+	if len(diff.NewProjects)+len(diff.DeletedProjects)+len(diff.UpdatedProjects) == 0 {
+		b.WriteString("No projects changed.\n")
+		return b.String()
+	}
+	if len(diff.UpdatedProjects) != 0 {
+		b.WriteString("Updated projects:\n")
+		for _, p := range diff.UpdatedProjects {
+			fmt.Fprintf(&b, "  %s", p.Name)
+			if p.OldRevision != "" {
+				fmt.Fprintf(&b, " %s..%s", shortRevision(p.OldRevision), shortRevision(p.Revision))
+			}
+			if p.OldRelativePath != "" {
+				fmt.Fprintf(&b, " moved from %s to %s", p.OldRelativePath, p.RelativePath)
+			}
+			b.WriteString("\n")
+		}
+	}
+	for _, section := range []struct {
+		title    string
+		projects []DiffProject
+	}{{"Added projects", diff.NewProjects}, {"Deleted projects", diff.DeletedProjects}} {
+		if len(section.projects) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "%s:\n", section.title)
+		for _, p := range section.projects {
+			fmt.Fprintf(&b, "  %s\n", p.Name)
+		}
+	}
+	return b.String()
+}
+
+// regenerateLockfile resolves the manifest into lockfile, keeping the kinds
+// of locks it already has.
+func regenerateLockfile(jirix *jiri.X, manifestPath, lockfile string) error {
+	data, err := os.ReadFile(lockfile)
+	if err != nil {
+		return err
+	}
+	projectLocks, pkgLocks, _, err := project.UnmarshalLockEntries(data)
+	if err != nil {
+		return err
+	}
+	config := &resolveCmd{
+		lockFilePath:      lockfile,
+		enableProjectLock: len(projectLocks) != 0,
+		enablePackageLock: len(pkgLocks) != 0,
+	}
+	// See resolveCmd.run.
+	jirix.IgnoreLockConflicts = true
+	return project.GenerateJiriLockFile(jirix, []string{manifestPath}, config)
+}
+
+func shortRevision(rev string) string {
+	if len(rev) > 12 {
+		return rev[:12]
+	}
+	return rev
+}
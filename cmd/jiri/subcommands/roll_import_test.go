@@ -0,0 +1,131 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.fuchsia.dev/jiri/gitutil"
+	"go.fuchsia.dev/jiri/jiritest"
+	"go.fuchsia.dev/jiri/project"
+)
+
+func TestRollImport(t *testing.T) {
+	_, fake := setupUniverse(t)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	manifestRemote := fake.Projects[jiritest.ManifestProjectName]
+	oldRevision, err := gitutil.New(fake.X, gitutil.RootDirOpt(manifestRemote)).CurrentRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Add a project to the imported manifest after the pinned revision.
+	if err := fake.CreateRemoteProject("added"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.AddProject(project.Project{Name: "added", Path: "added", Remote: fake.Projects["added"]}); err != nil {
+		t.Fatal(err)
+	}
+	newRevision, err := gitutil.New(fake.X, gitutil.RootDirOpt(manifestRemote)).CurrentRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Create a manifest repository that pins the import.
+	if err := fake.CreateRemoteProject("top"); err != nil {
+		t.Fatal(err)
+	}
+	topDir := fake.Projects["top"]
+	manifestPath := filepath.Join(topDir, "manifest")
+	m := project.Manifest{Imports: []project.Import{{
+		Name:     jiritest.ManifestProjectName,
+		Remote:   manifestRemote,
+		Manifest: jiritest.ManifestFileName,
+		Revision: oldRevision,
+	}}}
+	if err := m.ToFile(fake.X, manifestPath); err != nil {
+		t.Fatal(err)
+	}
+	scm := gitutil.New(fake.X, gitutil.RootDirOpt(topDir))
+	if err := scm.CommitFile(manifestPath, "add manifest"); err != nil {
+		t.Fatal(err)
+	}
+
+	original, err := scm.CurrentBranchName()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A lockfile which cannot be regenerated makes the roll fail, which
+	// goes back to the original branch.
+	fake.X.LockfileName = "jiri.lock"
+	lockfile := filepath.Join(topDir, fake.X.LockfileName)
+	if err := os.WriteFile(lockfile, []byte("not a lockfile"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := scm.CommitFile(lockfile, "add lockfile"); err != nil {
+		t.Fatal(err)
+	}
+	cmd := rollImportCmd{lockfile: true}
+	if _, _, err := collectStdio(fake.X, []string{manifestPath, jiritest.ManifestProjectName}, cmd.run); err == nil {
+		t.Fatalf("the roll should fail with a bad lockfile")
+	}
+	if branch, err := scm.CurrentBranchName(); err != nil || branch != original {
+		t.Errorf("got branch %q (%v) after a failed roll, want %s", branch, err, original)
+	}
+	if exists, err := scm.BranchExists("roll-manifest-" + newRevision[:12]); err != nil || exists {
+		t.Errorf("the branch of the failed roll should be deleted: %v", err)
+	}
+	if changes, err := scm.HasUncommittedChanges(); err != nil || changes {
+		t.Errorf("the changes of the failed roll should be discarded: %v", err)
+	}
+	if err := scm.Remove(lockfile); err != nil {
+		t.Fatal(err)
+	}
+	if err := scm.CommitWithMessage("remove lockfile"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The roll keeps the mode of the manifest.
+	if err := os.Chmod(manifestPath, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := collectStdio(fake.X, []string{manifestPath, jiritest.ManifestProjectName}, cmd.run); err != nil {
+		t.Fatal(err)
+	}
+	rolled, err := project.ManifestFromFile(fake.X, manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rolled.Imports[0].Revision; got != newRevision {
+		t.Errorf("got import revision %s, want %s", got, newRevision)
+	}
+	if branch, err := scm.CurrentBranchName(); err != nil || branch != "roll-manifest-"+newRevision[:12] {
+		t.Errorf("got branch %q (%v), want roll-manifest-%s", branch, err, newRevision[:12])
+	}
+	msg, err := scm.CommitMsg("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(msg, "[roll] Roll manifest ") || !strings.Contains(msg, "Added projects:\n  added") {
+		t.Errorf("unexpected commit message:\n%s", msg)
+	}
+	if changes, err := scm.HasUncommittedChanges(); err != nil || changes {
+		t.Errorf("the roll should be committed: %v", err)
+	}
+	if info, err := os.Stat(manifestPath); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("the manifest should keep its mode, got %v, %v", info, err)
+	}
+
+	// Rolling again is a no-op.
+	if stdout, _, err := collectStdio(fake.X, []string{manifestPath, jiritest.ManifestProjectName}, cmd.run); err != nil || !strings.Contains(stdout, "already at") {
+		t.Errorf("got %q, %v, want no-op", stdout, err)
+	}
+}
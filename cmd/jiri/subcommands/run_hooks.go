@@ -22,6 +22,8 @@ type runHooksCmd struct {
 	attempts              uint
 	fetchPackages         bool
 	packagesToSkip        arrayFlag
+	wait                  bool
+	attrFlags
 }
 
 func (c *runHooksCmd) Name() string     { return "run-hooks" }
@@ -30,6 +32,9 @@ func (c *runHooksCmd) Usage() string {
 	return `Run hooks using local manifest JIRI_HEAD version if -local-manifest flag is
 false, else it runs hooks using current manifest checkout version.
 
+Unlike "jiri update", it runs all hooks, even those whose inputs did not change
+since they last ran successfully.
+
 Usage:
   jiri run-hooks [flags]
 `
@@ -42,6 +47,8 @@ func (c *runHooksCmd) SetFlags(f *flag.FlagSet) {
 	f.BoolVar(&c.fetchPackages, "fetch-packages", true, "Use fetching packages using jiri.")
 	f.Var(&c.packagesToSkip, "package-to-skip", "Skip fetching this package. Repeatable.")
 	f.Var(&c.localManifestProjects, "local-manifest-project", "Import projects whose local manifests should be respected. Repeatable.")
+	f.BoolVar(&c.wait, "wait", false, "Wait for other jiri commands changing the root to finish instead of failing.")
+	c.attrFlags.setFlags(f)
 }
 
 func (c *runHooksCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
@@ -49,6 +56,9 @@ func (c *runHooksCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) su
 }
 
 func (c *runHooksCmd) run(jirix *jiri.X, args []string) (err error) {
+	if err := jirix.LockRoot(c.Name(), c.wait); err != nil {
+		return err
+	}
 	localProjects, err := project.LocalProjects(jirix, project.FastScan)
 	if err != nil {
 		return err
@@ -57,6 +67,7 @@ func (c *runHooksCmd) run(jirix *jiri.X, args []string) (err error) {
 		return jirix.UsageErrorf("Number of attempts should be >= 1")
 	}
 	jirix.Attempts = c.attempts
+	c.attrFlags.apply(jirix)
 
 	// Get hooks.
 	var hooks project.Hooks
@@ -92,5 +103,8 @@ func (c *runHooksCmd) run(jirix *jiri.X, args []string) (err error) {
 		}
 	}
 
-	return project.RunHooks(jirix, hooks, c.hookTimeout)
+	if err := project.CheckHookPackages(jirix, hooks, pkgs); err != nil {
+		return err
+	}
+	return project.RunHooks(jirix, hooks, c.hookTimeout, true)
 }
@@ -13,14 +13,17 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
 	"regexp"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/google/subcommands"
 	"go.fuchsia.dev/jiri"
 	"go.fuchsia.dev/jiri/envvar"
+	"go.fuchsia.dev/jiri/gitutil"
 	"go.fuchsia.dev/jiri/project"
 	"go.fuchsia.dev/jiri/simplemr"
 	"go.fuchsia.dev/jiri/tool"
@@ -42,6 +45,9 @@ type runpCmd struct {
 	collateOutput  bool
 	branch         string
 	remote         string
+	ordered        bool
+	orderedJobs    uint
+	env            arrayFlag
 }
 
 func (c *runpCmd) Name() string     { return "runp" }
@@ -58,6 +64,19 @@ Usage:
 line flags. Any environment variables intended to be evaluated when the
 command line is run must be quoted to avoid expansion before being passed to
 runp by the shell.
+
+The placeholders {name}, {path}, {relpath}, {remote} and {revision} in the
+command line are replaced, in each project, by its name, its absolute path,
+its path relative to the root, its remote and its checked out revision. They
+are not quoted. -env sets an environment variable of the command from the
+same placeholders, e.g.:
+  jiri runp -env REV={revision} 'echo {relpath} $REV'
+
+With -ordered, projects are run in dependency order: a project only runs
+once every project that contains it (by path) or that imports the manifest
+declaring it has finished. Projects at the same depth run in parallel, up to
+-ordered-jobs at a time. If a command fails, projects at greater depths are
+not run.
 `
 }
 
@@ -75,6 +94,9 @@ func (c *runpCmd) SetFlags(f *flag.FlagSet) {
 	f.BoolVar(&c.exitOnError, "exit-on-error", false, "If set, all commands will killed as soon as one reports an error, otherwise, each will run to completion.")
 	f.StringVar(&c.branch, "branch", "", "A regular expression specifying branch names to use in matching projects. A project will match if the specified branch exists, even if it is not checked out.")
 	f.StringVar(&c.remote, "remote", "", "A Regular expression specifying projects to run commands in by matching against their remote URLs.")
+	f.BoolVar(&c.ordered, "ordered", false, "If set, run the command in parents before nested and imported projects, see above.")
+	f.UintVar(&c.orderedJobs, "ordered-jobs", 0, "Number of projects of the same depth to run in parallel with -ordered. Defaults to -j.")
+	f.Var(&c.env, "env", "Set an environment variable of the command in each project, as <name>=<value>, where the value may contain placeholders, see above. Repeatable.")
 }
 
 func (c *runpCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
@@ -99,6 +121,40 @@ func newmapInput(jirix *jiri.X, project project.Project, key project.ProjectKey,
 	}
 }
 
+// runpPlaceholderRE matches the placeholders of the per-project variables
+// in the command line and -env values of runp.
+var runpPlaceholderRE = regexp.MustCompile(`\{(name|path|relpath|remote|revision)\}`)
+
+// expand replaces the placeholders of s with the variables of the project
+// of mi. The revision is only looked up if s refers to it.
+func (mi *mapInput) expand(s string) (string, error) {
+	var err error
+	expanded := runpPlaceholderRE.ReplaceAllStringFunc(s, func(placeholder string) string {
+		switch placeholder {
+		case "{name}":
+			return mi.Project.Name
+		case "{path}":
+			return mi.Project.Path
+		case "{relpath}":
+			rel, err2 := filepath.Rel(mi.jirix.Root, mi.Project.Path)
+			if err2 != nil {
+				return mi.Project.Path
+			}
+			return rel
+		case "{remote}":
+			return mi.Project.Remote
+		case "{revision}":
+			rev, err2 := gitutil.New(mi.jirix, gitutil.RootDirOpt(mi.Project.Path)).CurrentRevision()
+			if err2 != nil {
+				err = fmt.Errorf("getting the revision of project %s(%s): %v", mi.Project.Name, mi.Project.Path, err2)
+			}
+			return rev
+		}
+		return placeholder
+	})
+	return expanded, err
+}
+
 func projectNames(mapInputs map[project.ProjectKey]*mapInput) []string {
 	n := []string{}
 	for _, mi := range mapInputs {
@@ -128,6 +184,9 @@ type runner struct {
 	showNamePrefix       bool
 	showKeyPrefix        bool
 	showPathPrefix       bool
+	// env are the <name>=<value> environment variables set by -env.
+	env      []string
+	failures atomic.Int32
 }
 
 func (r *runner) serializedWriter(w io.Writer) io.Writer {
@@ -177,8 +236,19 @@ func (r *runner) Map(mr *simplemr.MR, key string, val any) error {
 		path = "sh"
 	}
 	var wg sync.WaitGroup
-	cmd := exec.Command(path, "-c", strings.Join(r.args, " "))
-	cmd.Env = envvar.MapToSlice(jirix.Env())
+	cmdLine, err := mi.expand(strings.Join(r.args, " "))
+	if err != nil {
+		return err
+	}
+	env := jirix.Env()
+	for _, v := range r.env {
+		name, value, _ := strings.Cut(v, "=")
+		if env[name], err = mi.expand(value); err != nil {
+			return err
+		}
+	}
+	cmd := exec.Command(path, "-c", cmdLine)
+	cmd.Env = envvar.MapToSlice(env)
 	cmd.Dir = mi.Project.Path
 	cmd.Stdin = mi.jirix.Stdin()
 	var stdoutCloser, stderrCloser io.Closer
@@ -275,6 +345,7 @@ func (r *runner) Reduce(mr *simplemr.MR, key string, values []any) error {
 	for _, v := range values {
 		mo := v.(*mapOutput)
 		if mo.err != nil {
+			r.failures.Add(1)
 			fmt.Fprintf(r.jirix.Stdout(), "FAILED: %v: %s %v\n", mo.key, strings.Join(r.args, " "), mo.err)
 			return nil
 		} else {
@@ -328,6 +399,12 @@ func (c *runpCmd) run(jirix *jiri.X, args []string) error {
 		}
 	}
 
+	for _, v := range c.env {
+		if name, _, ok := strings.Cut(v, "="); !ok || name == "" {
+			return jirix.UsageErrorf("invalid -env %q, expected <name>=<value>", v)
+		}
+	}
+
 	if (c.showKeyPrefix || c.showNamePrefix || c.showPathPrefix) && c.interactive {
 		fmt.Fprintf(jirix.Stderr(), "WARNING: interactive mode being disabled because show-key-prefix or show-name-prefix or show-path-prefix was set\n")
 		c.interactive = false
@@ -417,21 +494,60 @@ func (c *runpCmd) run(jirix *jiri.X, args []string) error {
 		showNamePrefix: c.showNamePrefix,
 		showKeyPrefix:  c.showKeyPrefix,
 		showPathPrefix: c.showPathPrefix,
+		env:            c.env,
 	}
-	mr := simplemr.MR{}
+	numMappers := int(jirix.Jobs)
 	if c.interactive {
 		// Run one mapper at a time.
-		mr.NumMappers = 1
+		numMappers = 1
 		sort.Sort(keys)
-	} else {
-		mr.NumMappers = int(jirix.Jobs)
 	}
-	in, out := make(chan *simplemr.Record, len(mapInputs)), make(chan *simplemr.Record, len(mapInputs))
+	if !c.ordered {
+		return runner.mapReduce(keys, mapInputs, numMappers)
+	}
+
+	if !c.interactive && c.orderedJobs > 0 {
+		numMappers = int(c.orderedJobs)
+	}
+	manifestProjects, _, _, err := project.LoadManifestFile(jirix, jirix.JiriManifestFile(), projects, nil)
+	if err != nil {
+		return err
+	}
+	levels := projectLevels(projects, manifestProjects)
+	var byLevel []project.ProjectKeys
+	for _, key := range keys {
+		l := levels[key]
+		for len(byLevel) <= l {
+			byLevel = append(byLevel, nil)
+		}
+		byLevel[l] = append(byLevel[l], key)
+	}
+	for l, keys := range byLevel {
+		if len(keys) == 0 {
+			continue
+		}
+		sort.Sort(keys)
+		if err := runner.mapReduce(keys, mapInputs, numMappers); err != nil {
+			return err
+		}
+		if n := runner.failures.Load(); n > 0 && l < len(byLevel)-1 {
+			return fmt.Errorf("%d commands failed at depth %d, not running projects at greater depths", n, l)
+		}
+	}
+	return nil
+}
+
+// mapReduce runs the command in the projects of keys, numMappers at a time.
+func (r *runner) mapReduce(keys project.ProjectKeys, mapInputs map[project.ProjectKey]*mapInput, numMappers int) error {
+	jirix := r.jirix
+	mr := simplemr.MR{NumMappers: numMappers}
+	in, out := make(chan *simplemr.Record, len(keys)), make(chan *simplemr.Record, len(keys))
 	sigch := make(chan os.Signal, 1)
 	signal.Notify(sigch, os.Interrupt)
+	defer signal.Stop(sigch)
 	jirix.TimerPush("Map and Reduce")
 	go func() { <-sigch; mr.Cancel() }()
-	go mr.Run(in, out, runner, runner)
+	go mr.Run(in, out, r, r)
 	for _, key := range keys {
 		in <- &simplemr.Record{Key: key.String(), Values: []any{mapInputs[key]}}
 	}
@@ -440,3 +556,74 @@ func (c *runpCmd) run(jirix *jiri.X, args []string) error {
 	jirix.TimerPop()
 	return mr.Error()
 }
+
+// projectLevels returns the depth of each of the local projects in the
+// dependency graph formed by nesting and manifest imports. A project depends
+// on the innermost project containing it, and on the manifest project whose
+// import declares it, as found in manifestProjects. Projects without
+// dependencies have depth 0.
+func projectLevels(projects, manifestProjects project.Projects) map[project.ProjectKey]int {
+	byName := make(map[string][]project.ProjectKey)
+	for key, p := range projects {
+		byName[p.Name] = append(byName[p.Name], key)
+	}
+	// importer returns the key of the manifest project of the import which
+	// declares mp. Imports are only known by name, so among the projects
+	// with that name, it is the one with the manifest declaring mp.
+	importer := func(mp project.Project) (project.ProjectKey, bool) {
+		keys := byName[mp.ImportedBy]
+		if len(keys) == 1 {
+			return keys[0], true
+		}
+		for _, key := range keys {
+			if strings.HasPrefix(mp.ManifestPath, projects[key].Path+string(filepath.Separator)) {
+				return key, true
+			}
+		}
+		return project.ProjectKey{}, false
+	}
+	parents := make(map[project.ProjectKey][]project.ProjectKey)
+	for key, p := range projects {
+		var container project.ProjectKey
+		longest := -1
+		for otherKey, other := range projects {
+			if otherKey != key && strings.HasPrefix(p.Path, other.Path+string(filepath.Separator)) && len(other.Path) > longest {
+				container, longest = otherKey, len(other.Path)
+			}
+		}
+		if longest >= 0 {
+			parents[key] = append(parents[key], container)
+		}
+		if mp, ok := manifestProjects[key]; ok && mp.ImportedBy != "" {
+			if importer, ok := importer(mp); ok && importer != key {
+				parents[key] = append(parents[key], importer)
+			}
+		}
+	}
+
+	levels := make(map[project.ProjectKey]int)
+	visiting := make(map[project.ProjectKey]bool)
+	var level func(key project.ProjectKey) int
+	level = func(key project.ProjectKey) int {
+		if l, ok := levels[key]; ok {
+			return l
+		}
+		if visiting[key] {
+			// Import cycles are rejected by the loader, so this should
+			// not happen; break the cycle rather than recursing forever.
+			return 0
+		}
+		visiting[key] = true
+		l := 0
+		for _, parent := range parents[key] {
+			l = max(l, level(parent)+1)
+		}
+		visiting[key] = false
+		levels[key] = l
+		return l
+	}
+	for key := range projects {
+		level(key)
+	}
+	return levels
+}
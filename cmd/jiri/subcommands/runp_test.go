@@ -265,3 +265,117 @@ func TestRunP(t *testing.T) {
 		t.Errorf("got %q, want %q", got, want)
 	}
 }
+
+func TestRunPPlaceholders(t *testing.T) {
+	t.Parallel()
+
+	fake := jiritest.NewFakeJiriRoot(t)
+	projects := addProjects(t, fake)
+	rev, err := gitutil.New(fake.X, gitutil.RootDirOpt(projects[3].Path)).CurrentRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := runpCmd{
+		projectKeys:   "r.t1",
+		collateOutput: true,
+		env:           arrayFlag{"REV={revision}", "WHERE={name} at {relpath}"},
+	}
+	got := executeRunp(t, fake, cmd, "echo", "{name} {path} {remote} {unknown}", "\"$WHERE\"", "$REV")
+	want := strings.Join([]string{projects[3].Name, projects[3].Path, projects[3].Remote, "{unknown}", "sub/r.t1 at sub/r.t1", rev}, " ")
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	cmd = runpCmd{env: arrayFlag{"NOVALUE"}}
+	if _, _, err := collectStdio(fake.X, []string{"true"}, cmd.run); err == nil {
+		t.Errorf("expected an error for an -env without a value")
+	}
+}
+
+func TestProjectLevels(t *testing.T) {
+	root := "/jiri"
+	newProject := func(name, path string) project.Project {
+		return project.Project{Name: name, Path: filepath.Join(root, path), Remote: "https://example.com/" + name}
+	}
+	manifest := newProject("manifest", "integration")
+	top := newProject("top", "top")
+	nested := newProject("nested", "top/nested")
+	deeper := newProject("deeper", "top/nested/third_party/deeper")
+	imported := newProject("imported", "imported")
+	sibling := newProject("sibling", "top-sibling")
+
+	projects := project.Projects{}
+	for _, p := range []project.Project{manifest, top, nested, deeper, imported, sibling} {
+		projects[p.Key()] = p
+	}
+	manifestProjects := project.Projects{}
+	for key, p := range projects {
+		if p.Name == "imported" || p.Name == "top" {
+			p.ImportedBy = "manifest"
+		}
+		manifestProjects[key] = p
+	}
+
+	got := map[string]int{}
+	for key, l := range projectLevels(projects, manifestProjects) {
+		got[projects[key].Name] = l
+	}
+	want := map[string]int{
+		"manifest": 0,
+		"sibling":  0,
+		"top":      1,
+		"imported": 1,
+		"nested":   2,
+		"deeper":   3,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected levels (-want +got):\n%s", diff)
+	}
+}
+
+// TestProjectLevelsSameName checks that the importer of a project is found
+// by its manifest when several projects have the name of the import.
+func TestProjectLevelsSameName(t *testing.T) {
+	root := "/jiri"
+	newProject := func(name, path, remote string) project.Project {
+		return project.Project{Name: name, Path: filepath.Join(root, path), Remote: remote}
+	}
+	manifest := newProject("manifest", "integration", "https://example.com/manifest")
+	vendor := newProject("vendor", "vendor", "https://example.com/vendor")
+	vendorManifest := newProject("manifest", "vendor/integration", "https://vendor.example.com/manifest")
+	imported := newProject("imported", "imported", "https://example.com/imported")
+	vendorImported := newProject("vendor-imported", "vendor-imported", "https://vendor.example.com/imported")
+
+	projects := project.Projects{}
+	for _, p := range []project.Project{manifest, vendor, vendorManifest, imported, vendorImported} {
+		projects[p.Key()] = p
+	}
+	manifestProjects := project.Projects{}
+	for key, p := range projects {
+		switch p.Name {
+		case "imported":
+			p.ImportedBy = "manifest"
+			p.ManifestPath = filepath.Join(manifest.Path, "default")
+		case "vendor-imported":
+			p.ImportedBy = "manifest"
+			p.ManifestPath = filepath.Join(vendorManifest.Path, "default")
+		}
+		manifestProjects[key] = p
+	}
+
+	got := map[string]int{}
+	for key, l := range projectLevels(projects, manifestProjects) {
+		got[projects[key].Path] = l
+	}
+	want := map[string]int{
+		manifest.Path:       0,
+		vendor.Path:         0,
+		vendorManifest.Path: 1,
+		imported.Path:       1,
+		vendorImported.Path: 2,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected levels (-want +got):\n%s", diff)
+	}
+}
@@ -30,7 +30,7 @@ Usage:
 func (c *selfUpdateCmd) SetFlags(f *flag.FlagSet) {}
 
 func (c *selfUpdateCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
-	return errToExitStatus(ctx, c.run(ctx, f.Args()))
+	return errToExitStatus(ctx, c.run(ctx, f.Args()), c.topLevelFlags.ErrorFormat)
 }
 
 func (c *selfUpdateCmd) run(_ context.Context, args []string) error {
@@ -0,0 +1,300 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/google/subcommands"
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/gitutil"
+	"go.fuchsia.dev/jiri/project"
+)
+
+type serveCmd struct {
+	cmdBase
+
+	addr      string
+	unix      string
+	tokenFile string
+}
+
+func (c *serveCmd) Name() string     { return "serve" }
+func (c *serveCmd) Synopsis() string { return "Serve read-only checkout state over HTTP" }
+func (c *serveCmd) Usage() string {
+	return `Runs an HTTP server that exposes the state of the checkout as JSON, so that
+dashboards and editor plugins can query it without running jiri repeatedly.
+The server is read-only and never modifies the checkout.
+
+Endpoints:
+  /projects  All local projects with their branches and working tree state.
+  /snapshot  A snapshot manifest of the current checkout, as "jiri snapshot"
+             would write it.
+  /status    Projects that are dirty, not on JIRI_HEAD, pinned or no longer
+             in the manifest, as reported by "jiri status".
+
+If -token-file is given, every request must carry the token read from that
+file in an "Authorization: Bearer <token>" header.
+
+Usage:
+  jiri serve [flags]
+`
+}
+
+func (c *serveCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&c.addr, "addr", "localhost:8080", "TCP address to listen on.")
+	f.StringVar(&c.unix, "unix", "", "Path of a unix socket to listen on instead of -addr.")
+	f.StringVar(&c.tokenFile, "token-file", "", "File containing a token that clients must send as a bearer token.")
+}
+
+func (c *serveCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	return executeWrapper(ctx, c.run, c.topLevelFlags, f.Args())
+}
+
+// removeStaleSocket removes the unix socket at path if it was left behind by
+// a server that did not shut down cleanly, i.e. if no server accepts
+// connections on it.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if err != nil || fi.Mode()&os.ModeSocket == 0 {
+		return nil
+	}
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("already serving on %s", path)
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) && !errors.Is(err, syscall.ENOENT) {
+		return fmt.Errorf("%s may be already serving: %v", path, err)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (c *serveCmd) run(jirix *jiri.X, args []string) error {
+	if len(args) != 0 {
+		return jirix.UsageErrorf("unexpected arguments")
+	}
+	token := ""
+	if c.tokenFile != "" {
+		b, err := os.ReadFile(c.tokenFile)
+		if err != nil {
+			return fmt.Errorf("reading token file: %v", err)
+		}
+		if token = strings.TrimSpace(string(b)); token == "" {
+			return fmt.Errorf("token file %q is empty", c.tokenFile)
+		}
+	}
+
+	var l net.Listener
+	var err error
+	if c.unix != "" {
+		if err := removeStaleSocket(c.unix); err != nil {
+			return err
+		}
+		l, err = net.Listen("unix", c.unix)
+	} else {
+		l, err = net.Listen("tcp", c.addr)
+	}
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{Handler: newServeHandler(jirix, token)}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+	jirix.Logger.Infof("Serving checkout state on %s\n", l.Addr())
+	if err := server.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// newServeHandler returns the handler serving the endpoints of "jiri serve".
+// Requests are served one at a time, as jiri.X is not safe for concurrent use.
+func newServeHandler(jirix *jiri.X, token string) http.Handler {
+	var mu sync.Mutex
+	mux := http.NewServeMux()
+	handle := func(path string, f func(*jiri.X) (any, error)) {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			mu.Lock()
+			v, err := f(jirix)
+			mu.Unlock()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if b, ok := v.([]byte); ok {
+				w.Header().Set("Content-Type", "application/xml")
+				w.Write(b)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			enc.Encode(v)
+		})
+	}
+	handle("/projects", serveProjects)
+	handle("/snapshot", serveSnapshot)
+	handle("/status", serveStatus)
+	if token == "" {
+		return mux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+type serveProjectOutput struct {
+	projectInfoOutput
+	Uncommitted bool `json:"uncommitted"`
+	Untracked   bool `json:"untracked"`
+}
+
+func serveProjects(jirix *jiri.X) (any, error) {
+	projects, err := project.LocalProjects(jirix, project.FastScan)
+	if err != nil {
+		return nil, err
+	}
+	states, err := project.GetProjectStates(jirix, projects, true)
+	if err != nil {
+		return nil, err
+	}
+	var keys project.ProjectKeys
+	for key := range states {
+		keys = append(keys, key)
+	}
+	sort.Sort(keys)
+	output := make([]serveProjectOutput, 0, len(keys))
+	for _, key := range keys {
+		state := states[key]
+		rp, err := filepath.Rel(jirix.Root, state.Project.Path)
+		if err != nil {
+			return nil, err
+		}
+		info := serveProjectOutput{
+			projectInfoOutput: projectInfoOutput{
+				Name:          state.Project.Name,
+				Path:          state.Project.Path,
+				RelativePath:  rp,
+				Remote:        state.Project.Remote,
+				Revision:      state.CurrentBranch.Revision,
+				CurrentBranch: state.CurrentBranch.Name,
+				Manifest:      state.Project.ManifestPath,
+				GerritHost:    state.Project.GerritHost,
+			},
+			Uncommitted: state.HasUncommitted,
+			Untracked:   state.HasUntracked,
+		}
+		for _, b := range state.Branches {
+			info.Branches = append(info.Branches, b.Name)
+		}
+		output = append(output, info)
+	}
+	return output, nil
+}
+
+func serveSnapshot(jirix *jiri.X) (any, error) {
+	dir, err := os.MkdirTemp("", "jiri-serve")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "snapshot")
+	if err := project.CreateSnapshot(jirix, file, nil, nil, false, nil, nil); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(file)
+}
+
+type serveStatusOutput struct {
+	Name          string `json:"name"`
+	RelativePath  string `json:"relativePath"`
+	CurrentBranch string `json:"current_branch,omitempty"`
+	Revision      string `json:"revision"`
+	JiriHead      string `json:"jiri_head,omitempty"`
+	Uncommitted   bool   `json:"uncommitted"`
+	Untracked     bool   `json:"untracked"`
+	Pin           string `json:"pin,omitempty"`
+	Deleted       bool   `json:"deleted"`
+}
+
+func serveStatus(jirix *jiri.X) (any, error) {
+	localProjects, err := project.LocalProjects(jirix, project.FastScan)
+	if err != nil {
+		return nil, err
+	}
+	remoteProjects, _, _, err := project.LoadManifestFile(jirix, jirix.JiriManifestFile(), localProjects, nil)
+	if err != nil {
+		return nil, err
+	}
+	states, err := project.GetProjectStates(jirix, localProjects, true)
+	if err != nil {
+		return nil, err
+	}
+	var keys project.ProjectKeys
+	for key := range states {
+		keys = append(keys, key)
+	}
+	sort.Sort(keys)
+	output := []serveStatusOutput{}
+	for _, key := range keys {
+		state := states[key]
+		rp, err := filepath.Rel(jirix.Root, state.Project.Path)
+		if err != nil {
+			return nil, err
+		}
+		s := serveStatusOutput{
+			Name:          state.Project.Name,
+			RelativePath:  rp,
+			CurrentBranch: state.CurrentBranch.Name,
+			Revision:      state.CurrentBranch.Revision,
+			Uncommitted:   state.HasUncommitted,
+			Untracked:     state.HasUntracked,
+			Pin:           state.Project.LocalConfig.Pin,
+		}
+		_, inManifest := remoteProjects[key]
+		s.Deleted = !inManifest
+		scm := gitutil.New(jirix, gitutil.RootDirOpt(state.Project.Path))
+		if head, err := scm.CurrentRevisionForRef("JIRI_HEAD"); err == nil {
+			s.JiriHead = head
+		}
+		offHead := s.JiriHead != "" && s.JiriHead != s.Revision
+		if s.Uncommitted || s.Untracked || offHead || s.Pin != "" || s.Deleted {
+			output = append(output, s)
+		}
+	}
+	return output, nil
+}
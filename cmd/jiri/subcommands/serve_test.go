@@ -0,0 +1,128 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestServe(t *testing.T) {
+	localProjects, fake := setupUniverse(t)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	dirty := localProjects[1]
+	if err := os.WriteFile(filepath.Join(dirty.Path, "untracked"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(newServeHandler(fake.X, "secret"))
+	defer server.Close()
+	get := func(path, token string) (*http.Response, string) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, string(body)
+	}
+
+	for _, token := range []string{"", "wrong"} {
+		if resp, _ := get("/projects", token); resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("token %q: got status %d, want %d", token, resp.StatusCode, http.StatusUnauthorized)
+		}
+	}
+
+	resp, body := get("/projects", "secret")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("/projects: got status %d: %s", resp.StatusCode, body)
+	}
+	var projects []serveProjectOutput
+	if err := json.Unmarshal([]byte(body), &projects); err != nil {
+		t.Fatal(err)
+	}
+	// The manifest project is checked out as well.
+	if got, want := len(projects), len(localProjects)+1; got != want {
+		t.Errorf("/projects: got %d projects, want %d", got, want)
+	}
+
+	resp, body = get("/status", "secret")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("/status: got status %d: %s", resp.StatusCode, body)
+	}
+	var status []serveStatusOutput
+	if err := json.Unmarshal([]byte(body), &status); err != nil {
+		t.Fatal(err)
+	}
+	if len(status) != 1 || status[0].Name != dirty.Name || !status[0].Untracked {
+		t.Errorf("/status: got %+v, want only %s with untracked files", status, dirty.Name)
+	}
+
+	resp, body = get("/snapshot", "secret")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("/snapshot: got status %d: %s", resp.StatusCode, body)
+	}
+	if !strings.Contains(body, `name="`+dirty.Name+`"`) {
+		t.Errorf("/snapshot: expected project %s in:\n%s", dirty.Name, body)
+	}
+}
+
+func TestRemoveStaleSocket(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix sockets are not supported on windows")
+	}
+	// Unix socket paths are short, so t.TempDir() may be too long.
+	dir, err := os.MkdirTemp("", "jiri-serve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sock")
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := removeStaleSocket(path); err == nil || !strings.Contains(err.Error(), "already serving") {
+		t.Errorf("expected the socket of a live server to be kept, got %v", err)
+	}
+	if _, err := os.Lstat(path); err != nil {
+		t.Errorf("the socket of a live server was removed: %v", err)
+	}
+
+	// Leave the socket behind, as a server which did not shut down cleanly.
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	if err := removeStaleSocket(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Errorf("stale socket was not removed: %v", err)
+	}
+	if err := removeStaleSocket(path); err != nil {
+		t.Errorf("missing socket: %v", err)
+	}
+}
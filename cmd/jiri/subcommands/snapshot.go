@@ -5,18 +5,39 @@
 package subcommands
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/google/subcommands"
 	"go.fuchsia.dev/jiri"
 	"go.fuchsia.dev/jiri/project"
+	"go.fuchsia.dev/jiri/retry"
 )
 
 type snapshotCmd struct {
 	cmdBase
 
-	cipdEnsure bool
+	cipdEnsure      bool
+	format          string
+	promote         bool
+	pinFloating     bool
+	upload          string
+	uploadTokenFile string
+	filterAttrs     string
+	filterPaths     string
+	filterNames     string
+	groupFlags
+	attrFlags
 }
 
 func (c *snapshotCmd) Name() string     { return "snapshot" }
@@ -27,13 +48,52 @@ in a manifest.
 
 Usage:
   jiri snapshot [flags] <snapshot>
+  jiri snapshot -promote <snapshot> <manifest-dir>
 
 <snapshot> is the snapshot manifest file.
+
+With -promote, the revisions pinned by an existing snapshot are promoted to the
+manifests in <manifest-dir>, e.g. a checkout of the manifest repository: the
+"revision" attribute of every project of these manifests which is in the
+snapshot is set to its revision in the snapshot. Projects which follow their
+remote branch, without a revision or at HEAD, are left unpinned unless
+-pin-floating is given. Only the revision attributes are changed, so that the
+formatting and comments of the manifests are preserved, and the changes can be
+reviewed as a manifest change.
+
+With -format=source-manifest, the snapshot is written instead in the JSON
+format of the LUCI SourceManifest proto, with the revision of each project and
+the instance of each CIPD package deployed in the jiri root, like "jiri
+source-manifest" does.
+
+With -upload, the snapshot, and the cipd ensure and version files generated
+with -cipd, are also uploaded with HTTP PUT to a directory named after the
+sha256 of the snapshot under the given URL, and the URL of the uploaded
+snapshot is printed. The URL is either "gs://<bucket>/<prefix>", which uploads
+to Google Cloud Storage, or "https://<host>/<prefix>".
+
+With -filter-attrs, -filter-paths or -filter-names, the snapshot is partial: it
+only records the projects and packages that have one of the given attributes,
+are under one of the given paths, and are among the given names, for the
+downstream repositories that only consume a slice of the tree. Hooks are
+recorded with their project. The selected projects and packages must not be
+inside a project which is not selected, so that the snapshot can be checked
+out on its own.
 `
 }
 
 func (c *snapshotCmd) SetFlags(f *flag.FlagSet) {
 	f.BoolVar(&c.cipdEnsure, "cipd", false, "Generate a cipd.ensure (packages only) snapshot.")
+	f.StringVar(&c.format, "format", "manifest", "Format of the snapshot: manifest or source-manifest.")
+	f.BoolVar(&c.promote, "promote", false, "Set the revisions of the projects of the manifests in a directory to those of the snapshot.")
+	f.BoolVar(&c.pinFloating, "pin-floating", false, "With -promote, also pin the projects without a revision or at HEAD.")
+	f.StringVar(&c.upload, "upload", "", "Upload the snapshot to this gs:// or http(s):// URL.")
+	f.StringVar(&c.uploadTokenFile, "upload-token-file", "", "File containing an OAuth2 access token to send as a bearer token when uploading.")
+	f.StringVar(&c.filterAttrs, "filter-attrs", "", "Only record the projects and packages with one of these comma separated attributes.")
+	f.StringVar(&c.filterPaths, "filter-paths", "", "Only record the projects and packages under one of these comma separated paths, relative to the root.")
+	f.StringVar(&c.filterNames, "filter-names", "", "Only record the projects and packages with these comma separated names.")
+	c.groupFlags.setFlags(f)
+	c.attrFlags.setFlags(f)
 }
 
 func (c *snapshotCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
@@ -41,12 +101,149 @@ func (c *snapshotCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) su
 }
 
 func (c *snapshotCmd) run(jirix *jiri.X, args []string) error {
+	if c.promote {
+		return c.runPromote(jirix, args)
+	}
+	if c.pinFloating {
+		return jirix.UsageErrorf("-pin-floating can only be used with -promote")
+	}
 	if len(args) != 1 {
 		return jirix.UsageErrorf("unexpected number of arguments")
 	}
+	c.groupFlags.apply(jirix)
+	c.attrFlags.apply(jirix)
 	localManifestProjects, err := getDefaultLocalManifestProjects(jirix)
 	if err != nil {
 		return err
 	}
-	return project.CreateSnapshot(jirix, args[0], nil, nil, c.cipdEnsure, localManifestProjects)
+	filter := &project.SnapshotFilter{
+		Attributes: c.filterAttrs,
+		Paths:      splitList(c.filterPaths),
+		Names:      splitList(c.filterNames),
+	}
+	switch c.format {
+	case "", "manifest":
+		if err := project.CreateSnapshot(jirix, args[0], nil, nil, c.cipdEnsure, localManifestProjects, filter); err != nil {
+			return err
+		}
+	case "source-manifest":
+		if c.cipdEnsure {
+			return jirix.UsageErrorf("-cipd cannot be used with -format=source-manifest")
+		}
+		if !filter.IsEmpty() {
+			return jirix.UsageErrorf("-filter-attrs, -filter-paths and -filter-names cannot be used with -format=source-manifest")
+		}
+		if err := writeSourceManifest(jirix, args[0], localManifestProjects); err != nil {
+			return err
+		}
+	default:
+		return jirix.UsageErrorf("-format should be manifest or source-manifest, got %q", c.format)
+	}
+	if c.upload == "" {
+		return nil
+	}
+	token := ""
+	if c.uploadTokenFile != "" {
+		data, err := os.ReadFile(c.uploadTokenFile)
+		if err != nil {
+			return err
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	u, err := uploadSnapshot(jirix, args[0], c.upload, token)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(jirix.Stdout(), u)
+	return nil
+}
+
+// splitList returns the non-empty elements of the comma separated list s.
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// snapshotCompanionSuffixes are the suffixes of the files that "jiri snapshot
+// -cipd" creates next to the snapshot.
+var snapshotCompanionSuffixes = []string{".ensure", ".version", "_internal.ensure", "_internal.version"}
+
+// uploadSnapshot uploads file, and its companion files if any, to a directory
+// named after the sha256 of file under target, and returns the URL of the
+// uploaded snapshot. The files keep their names so that the ensure files still
+// refer to their version files.
+func uploadSnapshot(jirix *jiri.X, file, target, token string) (string, error) {
+	base, err := snapshotUploadBase(target)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	dir := base + "/" + hex.EncodeToString(sum[:])
+	files := []string{file}
+	for _, suffix := range snapshotCompanionSuffixes {
+		if _, err := os.Stat(file + suffix); err == nil {
+			files = append(files, file+suffix)
+		}
+	}
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return "", err
+		}
+		u := dir + "/" + url.PathEscape(filepath.Base(f))
+		if err := retry.Function(jirix, func() error {
+			return putFile(u, data, token)
+		}, fmt.Sprintf("Uploading %s", u), retry.AttemptsOpt(jirix.Attempts)); err != nil {
+			return "", &jiri.NetworkError{Err: err}
+		}
+	}
+	return dir + "/" + url.PathEscape(filepath.Base(file)), nil
+}
+
+// snapshotUploadBase returns the http(s) URL to upload files under for
+// target, translating gs:// URLs to the Cloud Storage XML API.
+func snapshotUploadBase(target string) (string, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "gs":
+		if u.Host == "" {
+			return "", fmt.Errorf("bad upload URL %q: missing bucket", target)
+		}
+		return strings.TrimSuffix("https://storage.googleapis.com/"+u.Host+u.Path, "/"), nil
+	case "http", "https":
+		return strings.TrimSuffix(u.String(), "/"), nil
+	}
+	return "", fmt.Errorf("bad upload URL %q: scheme should be gs, http or https", target)
+}
+
+func putFile(u string, data []byte, token string) error {
+	req, err := http.NewRequest(http.MethodPut, u, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("uploading to %s failed: %s: %s", u, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
 }
@@ -0,0 +1,127 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/project"
+)
+
+// runPromote sets the revisions of the projects of the manifests in the
+// directory args[1] to their revisions in the snapshot args[0]. Floating
+// projects are only pinned with -pin-floating.
+func (c *snapshotCmd) runPromote(jirix *jiri.X, args []string) error {
+	if len(args) != 2 {
+		return jirix.UsageErrorf("-promote takes a snapshot and a manifest directory")
+	}
+	snapshot, err := project.ManifestFromFile(jirix, args[0])
+	if err != nil {
+		return err
+	}
+	pins := make(map[project.ProjectKey]string)
+	for _, p := range snapshot.Projects {
+		if p.Revision != "" {
+			pins[p.Key()] = p.Revision
+		}
+	}
+	snapshotPath, err := filepath.Abs(args[0])
+	if err != nil {
+		return err
+	}
+	var files []string
+	err = filepath.WalkDir(args[1], func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if abs, err := filepath.Abs(path); err != nil || abs != snapshotPath {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	promoted := 0
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		m, err := project.ManifestFromBytes(data)
+		if err != nil {
+			// Not a manifest.
+			continue
+		}
+		content, changes, err := promoteRevisions(string(data), m, pins, c.pinFloating)
+		if err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		if len(changes) == 0 {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		if err := project.SafeWriteFile(jirix, file, []byte(content)); err != nil {
+			return err
+		}
+		if err := os.Chmod(file, info.Mode()); err != nil {
+			return err
+		}
+		if err := project.UpdateLockfileManifestDigest(jirix, file); err != nil {
+			return err
+		}
+		for _, change := range changes {
+			fmt.Fprintf(jirix.Stdout(), "%s: %s %s -> %s\n", file, change.Name, change.OldRev, change.NewRev)
+		}
+		promoted += len(changes)
+	}
+	if promoted == 0 {
+		fmt.Fprintln(jirix.Stdout(), "All projects are already at the revisions of the snapshot.")
+	}
+	return nil
+}
+
+// promoteRevisions sets the revisions of the projects of m, parsed from
+// content, to their revision in pins, skipping the projects without a
+// revision or at HEAD unless pinFloating is set. Only the revision attributes
+// are changed in content, so that the formatting and comments of the manifest
+// are preserved.
+func promoteRevisions(content string, m *project.Manifest, pins map[project.ProjectKey]string, pinFloating bool) (string, []projectChanges, error) {
+	var changes []projectChanges
+	for _, p := range m.Projects {
+		rev, ok := pins[p.Key()]
+		if !ok || rev == p.Revision {
+			continue
+		}
+		if !pinFloating && (p.Revision == "" || p.Revision == "HEAD") {
+			continue
+		}
+		var err error
+		if content, err = updateRevision(content, "project", p.Revision, rev, p.Name); err != nil {
+			return "", nil, err
+		}
+		changes = append(changes, projectChanges{
+			Name:   p.Name,
+			Remote: p.Remote,
+			Path:   p.Path,
+			OldRev: p.Revision,
+			NewRev: rev,
+		})
+	}
+	return content, changes, nil
+}
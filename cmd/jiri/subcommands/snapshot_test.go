@@ -6,14 +6,23 @@ package subcommands
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
 	"go.fuchsia.dev/jiri"
 	"go.fuchsia.dev/jiri/gitutil"
 	"go.fuchsia.dev/jiri/jiritest"
+	"go.fuchsia.dev/jiri/jiritest/xtest"
 	"go.fuchsia.dev/jiri/project"
 	"go.fuchsia.dev/jiri/tool"
 )
@@ -118,6 +127,96 @@ func TestSnapshot(t *testing.T) {
 	}
 }
 
+// TestSnapshotAttrs checks that snapshots record the attributes of -attrs
+// instead of those of the root.
+func TestSnapshotAttrs(t *testing.T) {
+	t.Parallel()
+
+	fake := jiritest.NewFakeJiriRoot(t)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	fake.X.FetchingAttrs = "stored"
+	file := filepath.Join(t.TempDir(), "snapshot")
+	cmd := snapshotCmd{attrFlags: attrFlags{attrs: "profile1,profile2", attrsSet: true}}
+	if err := cmd.run(fake.X, []string{file}); err != nil {
+		t.Fatal(err)
+	}
+	m, err := project.ManifestFromFile(fake.X, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := m.Attributes, "profile1,profile2"; got != want {
+		t.Errorf("got attributes %q, want %q", got, want)
+	}
+}
+
+// TestSnapshotFilter checks that -filter-paths and -filter-names make partial
+// snapshots, which must be closed under nesting.
+func TestSnapshotFilter(t *testing.T) {
+	t.Parallel()
+
+	fake := jiritest.NewFakeJiriRoot(t)
+	for name, path := range map[string]string{"a": "a", "b": "a/b", "c": "c"} {
+		if err := fake.CreateRemoteProject(name); err != nil {
+			t.Fatal(err)
+		}
+		if err := fake.AddProject(project.Project{
+			Name:   name,
+			Path:   path,
+			Remote: fake.Projects[name],
+		}); err != nil {
+			t.Fatal(err)
+		}
+		writeReadme(t, fake.X, fake.Projects[name], "revision 1")
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshotNames := func(cmd snapshotCmd) ([]string, error) {
+		file := filepath.Join(t.TempDir(), "snapshot")
+		if err := cmd.run(fake.X, []string{file}); err != nil {
+			return nil, err
+		}
+		m, err := project.ManifestFromFile(fake.X, file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, p := range m.Projects {
+			names = append(names, p.Name)
+		}
+		return names, nil
+	}
+	for _, test := range []struct {
+		cmd  snapshotCmd
+		want []string
+	}{
+		{snapshotCmd{filterPaths: "c"}, []string{"c"}},
+		{snapshotCmd{filterPaths: "a, c"}, []string{"a", "b", "c"}},
+		{snapshotCmd{filterPaths: "a", filterNames: "a"}, []string{"a"}},
+		{snapshotCmd{filterNames: "a,c"}, []string{"a", "c"}},
+	} {
+		got, err := snapshotNames(test.cmd)
+		if err != nil {
+			t.Errorf("-filter-paths=%q -filter-names=%q: %v", test.cmd.filterPaths, test.cmd.filterNames, err)
+			continue
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("-filter-paths=%q -filter-names=%q: unexpected projects (-want +got):\n%s", test.cmd.filterPaths, test.cmd.filterNames, diff)
+		}
+	}
+
+	// b cannot be checked out without a.
+	if _, err := snapshotNames(snapshotCmd{filterNames: "b"}); err == nil || !strings.Contains(err.Error(), `project "b" is inside project "a"`) {
+		t.Errorf("got error %v, want a nesting error", err)
+	}
+	if _, err := snapshotNames(snapshotCmd{filterNames: "a,unknown"}); err == nil {
+		t.Errorf("expected an unknown name to fail")
+	}
+}
+
 // TestCipdSnapshot tests creating cipd snapshot files.
 func TestCipdSnapshot(t *testing.T) {
 	t.Parallel()
@@ -149,7 +248,7 @@ func TestCipdSnapshot(t *testing.T) {
 	}
 	defer os.Remove(tmpfile.Name())
 
-	if err := project.CreateSnapshot(fake.X, tmpfile.Name(), nil, nil, true /*cipdEnsureFlag*/, nil); err != nil {
+	if err := project.CreateSnapshot(fake.X, tmpfile.Name(), nil, nil, true /*cipdEnsureFlag*/, nil, nil); err != nil {
 		t.Fatalf("%v", err)
 	}
 	pathExists := func(pkgPath string) bool {
@@ -181,3 +280,161 @@ func TestCipdSnapshot(t *testing.T) {
 	os.Remove(versionFilePath)
 	os.Remove(versionFileIntPath)
 }
+
+func TestUploadSnapshot(t *testing.T) {
+	t.Parallel()
+
+	uploaded := make(map[string]string)
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		mu.Lock()
+		uploaded[r.URL.Path] = string(data)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "snap")
+	for name, content := range map[string]string{"snap": "<manifest/>", "snap.ensure": "$ResolvedVersions snap.version"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sum := sha256.Sum256([]byte("<manifest/>"))
+	hash := hex.EncodeToString(sum[:])
+
+	jirix := xtest.NewX(t)
+	got, err := uploadSnapshot(jirix, file, server.URL+"/snapshots/", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := server.URL + "/snapshots/" + hash + "/snap"; got != want {
+		t.Errorf("got URL %q, want %q", got, want)
+	}
+	want := map[string]string{
+		"/snapshots/" + hash + "/snap":        "<manifest/>",
+		"/snapshots/" + hash + "/snap.ensure": "$ResolvedVersions snap.version",
+	}
+	if diff := cmp.Diff(want, uploaded); diff != "" {
+		t.Errorf("unexpected uploads (-want +got):\n%s", diff)
+	}
+
+	if _, err := uploadSnapshot(jirix, file, server.URL, "wrong"); err == nil {
+		t.Errorf("upload with a bad token should fail")
+	}
+	if base, err := snapshotUploadBase("gs://bucket/dir/"); err != nil || base != "https://storage.googleapis.com/bucket/dir" {
+		t.Errorf("got %q, %v for a gs:// URL", base, err)
+	}
+	if _, err := snapshotUploadBase("ftp://host/dir"); err == nil {
+		t.Errorf("an ftp:// URL should be rejected")
+	}
+}
+
+func TestSnapshotPromote(t *testing.T) {
+	t.Parallel()
+
+	jirix := xtest.NewX(t)
+	dir := t.TempDir()
+	manifest := `<?xml version="1.0" encoding="UTF-8"?>
+<manifest>
+  <projects>
+    <!-- Pinned by the release process. -->
+    <project name="a" path="a" remote="https://example.com/a"
+             revision="1111111111111111111111111111111111111111"/>
+    <project name="b"   path="b" remote="https://example.com/b"/>
+    <project name="c" path="c" remote="https://example.com/c"/>
+  </projects>
+</manifest>
+`
+	if err := os.MkdirAll(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	manifestPath := filepath.Join(dir, "sub", "manifest")
+	if err := os.WriteFile(manifestPath, []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "README.md"), []byte("not a manifest\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := `<manifest>
+  <projects>
+    <project name="a" path="a" remote="https://example.com/a" revision="2222222222222222222222222222222222222222"/>
+    <project name="b" path="b" remote="https://example.com/b" revision="3333333333333333333333333333333333333333"/>
+    <project name="c" path="c" remote="https://example.com/other" revision="4444444444444444444444444444444444444444"/>
+  </projects>
+</manifest>
+`
+	snapshotPath := filepath.Join(t.TempDir(), "snapshot")
+	if err := os.WriteFile(snapshotPath, []byte(snapshot), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The lockfile next to the manifest records the digest of its old
+	// content.
+	jirix.LockfileName = "jiri.lock"
+	lockfile := filepath.Join(dir, "sub", jirix.LockfileName)
+	manifestLock := project.ManifestLock{Path: filepath.ToSlash(manifestPath), Digest: "stale"}
+	data, err := project.MarshalLockFile(nil, nil, project.LockDigests{Manifests: project.ManifestLocks{manifestLock.Path: manifestLock}}, "2.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(lockfile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := snapshotCmd{promote: true}
+	if err := cmd.run(jirix, []string{snapshotPath, dir}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<manifest>
+  <projects>
+    <!-- Pinned by the release process. -->
+    <project name="a" path="a" remote="https://example.com/a"
+             revision="2222222222222222222222222222222222222222"/>
+    <project name="b"   path="b" remote="https://example.com/b"/>
+    <project name="c" path="c" remote="https://example.com/c"/>
+  </projects>
+</manifest>
+`
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Errorf("promoted manifest differs (-want +got):\n%s", diff)
+	}
+
+	// With -pin-floating, the projects following their remote branch are
+	// pinned too.
+	cmd = snapshotCmd{promote: true, pinFloating: true}
+	if err := cmd.run(jirix, []string{snapshotPath, dir}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = os.ReadFile(manifestPath); err != nil {
+		t.Fatal(err)
+	}
+	want = strings.Replace(want, `remote="https://example.com/b"/>`, `remote="https://example.com/b" revision="3333333333333333333333333333333333333333"/>`, 1)
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Errorf("promoted manifest with -pin-floating differs (-want +got):\n%s", diff)
+	}
+	if data, err = os.ReadFile(lockfile); err != nil {
+		t.Fatal(err)
+	}
+	digests, err := project.UnmarshalLockDigests(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(got)
+	if got, want := digests.Manifests[manifestLock.Path].Digest, hex.EncodeToString(sum[:]); got != want {
+		t.Errorf("got manifest digest %q in the lockfile, want %q", got, want)
+	}
+}
@@ -23,6 +23,10 @@ func (c *sourceManifestCmd) Synopsis() string {
 }
 func (c *sourceManifestCmd) Usage() string {
 	return `This command captures the current project state in a source-manifest format.
+The source-manifest is the JSON form of the LUCI SourceManifest proto, and
+lists the revision of each project and the instance of each CIPD package
+deployed in the jiri root. It is also created by "jiri snapshot
+-format=source-manifest".
 
 Usage:
   jiri source-manifest <source-manifest>
@@ -44,15 +48,30 @@ func (c *sourceManifestCmd) run(jirix *jiri.X, args []string) error {
 	if len(args) != 1 {
 		return jirix.UsageErrorf("unexpected number of arguments")
 	}
+	localManifestProjects, err := getDefaultLocalManifestProjects(jirix)
+	if err != nil {
+		return err
+	}
+	return writeSourceManifest(jirix, args[0], localManifestProjects)
+}
 
+// writeSourceManifest writes the source-manifest of the projects and CIPD
+// packages of the jiri root to file.
+func writeSourceManifest(jirix *jiri.X, file string, localManifestProjects []string) error {
 	localProjects, err := project.LocalProjects(jirix, project.FullScan)
 	if err != nil {
 		return err
 	}
-
+	_, _, pkgs, err := project.LoadManifestFile(jirix, jirix.JiriManifestFile(), localProjects, localManifestProjects)
+	if err != nil {
+		return err
+	}
 	sm, err := project.NewSourceManifest(jirix, localProjects)
 	if err != nil {
 		return err
 	}
-	return sm.ToFile(jirix, args[0])
+	if err := sm.AddCIPDPackages(jirix, pkgs); err != nil {
+		return err
+	}
+	return sm.ToFile(jirix, file)
 }
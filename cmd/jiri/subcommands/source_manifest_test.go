@@ -131,4 +131,17 @@ func TestSourceManifest(t *testing.T) {
 	if string(got) != string(want) {
 		t.Fatalf("GOT:\n%s, \nWANT:\n%s", (string(got)), string(want))
 	}
+
+	// "jiri snapshot -format=source-manifest" creates the same file.
+	snapshotFile := filepath.Join(t.TempDir(), "snapshot.json")
+	if err := (&snapshotCmd{format: "source-manifest"}).run(fake.X, []string{snapshotFile}); err != nil {
+		t.Fatal(err)
+	}
+	got, _ = os.ReadFile(snapshotFile)
+	if string(got) != string(want) {
+		t.Fatalf("snapshot -format=source-manifest GOT:\n%s, \nWANT:\n%s", (string(got)), string(want))
+	}
+	if err := (&snapshotCmd{format: "xml"}).run(fake.X, []string{snapshotFile}); err == nil {
+		t.Fatalf("expected an error for an unknown format")
+	}
 }
@@ -34,7 +34,12 @@ func (c *statusCmd) Synopsis() string { return "Prints status of all the project
 func (c *statusCmd) Usage() string {
 	return `Prints status for the the projects. It runs git status -s across all the projects
 and prints it if there are some changes. It also shows status if the project is on
-a rev other then the one according to manifest(Named as JIRI_HEAD in git)
+a rev other then the one according to manifest(Named as JIRI_HEAD in git), or
+if its git hooks differ from those of the githooks directory of the manifest.
+
+With "jiri init -enable-submodules", the git submodules checked out in
+projects are shown too, and are not on HEAD if they are not at the revision
+recorded in their superproject.
 
 Usage:
   jiri status [flags]
@@ -89,6 +94,10 @@ func (c *statusCmd) run(jirix *jiri.X, args []string) error {
 		}
 		return nil
 	}
+	if localProjects, err = project.WithSubmodules(jirix, localProjects); err != nil {
+		return err
+	}
+	addSubmoduleRemotes(localProjects, remoteProjects)
 	states, err := project.GetProjectStates(jirix, localProjects, false)
 	if err != nil {
 		return err
@@ -99,6 +108,7 @@ func (c *statusCmd) run(jirix *jiri.X, args []string) error {
 	}
 	sort.Sort(keys)
 	deletedProjects := 0
+	pinnedProjects := 0
 	for _, key := range keys {
 		localProject := localProjects[key]
 		remoteProject, foundRemote := remoteProjects[key]
@@ -142,20 +152,41 @@ func (c *statusCmd) run(jirix *jiri.X, args []string) error {
 					jirix.IncrementFailures()
 					continue
 				}
-				headLog = colorFormatGitLog(jirix, headLog)
+				headLog = colorFormatGitLog(jirix, headLog) + tagSuffix(remoteProject)
 				revisionMessage = fmt.Sprintf("\n%s: %s", jirix.Color.Yellow("JIRI_HEAD"), headLog)
 				revisionMessage = fmt.Sprintf("%s\n%s: %s", revisionMessage, jirix.Color.Yellow("Current Revision"), currentLog)
 			}
 		}
+		pin := localProject.LocalConfig.Pin
+		if pin != "" {
+			pinnedProjects++
+		}
+		withHooks := localProject
+		withHooks.GitHooks = remoteProject.GitHooks
+		driftedHooks, err := project.GitHooksDrift(jirix, withHooks)
+		if err != nil {
+			jirix.Logger.Errorf("%s :%s\n\n", errorMsg, err)
+			jirix.IncrementFailures()
+			continue
+		}
 		if c.branch != "" || changes != "" || revisionMessage != "" ||
-			len(extraCommits) != 0 {
+			len(extraCommits) != 0 || pin != "" || len(driftedHooks) != 0 {
 			fmt.Fprintf(jirix.Stdout(), "%s: %s", jirix.Color.Yellow(relativePath), revisionMessage)
 			fmt.Fprintln(jirix.Stdout())
 			branch := state.CurrentBranch.Name
 			if branch == "" {
 				branch = fmt.Sprintf("DETACHED-HEAD(%s)", currentLog)
+				if headRev == state.CurrentBranch.Revision {
+					branch += tagSuffix(remoteProject)
+				}
 			}
 			fmt.Fprintf(jirix.Stdout(), "%s: %s\n", jirix.Color.Yellow("Branch"), branch)
+			if pin != "" {
+				fmt.Fprintf(jirix.Stdout(), "%s: %s (run \"jiri pin -delete %s\" to unpin)\n", jirix.Color.Red("Pinned"), pin, localProject.Name)
+			}
+			if len(driftedHooks) != 0 {
+				fmt.Fprintf(jirix.Stdout(), "%s: %s differ from the manifest (run \"jiri update -force-githooks\" to reinstall them)\n", jirix.Color.Red("Git hooks"), strings.Join(driftedHooks, ", "))
+			}
 			if len(extraCommits) != 0 {
 				fmt.Fprintf(jirix.Stdout(), "%s: %d commit(s) not merged to remote\n", jirix.Color.Yellow("Commits"), len(extraCommits))
 				for _, commitLog := range extraCommits {
@@ -175,12 +206,24 @@ func (c *statusCmd) run(jirix *jiri.X, args []string) error {
 	if deletedProjects != 0 {
 		jirix.Logger.Warningf("Found %d deleted project(s), run with -d flag to list them.\n\n", deletedProjects)
 	}
+	if pinnedProjects != 0 {
+		jirix.Logger.Warningf("Found %d project(s) pinned by local config, run \"jiri pin\" to list them.\n\n", pinnedProjects)
+	}
 	if jirix.Failures() != 0 {
 		return fmt.Errorf("completed with non-fatal errors")
 	}
 	return nil
 }
 
+// tagSuffix returns the tag p is pinned to, formatted for display after a
+// revision, or "" if p is not pinned to a tag.
+func tagSuffix(p project.Project) string {
+	if !project.IsTagRevision(p.Revision) {
+		return ""
+	}
+	return fmt.Sprintf(" (tag: %s)", strings.TrimPrefix(p.Revision, "refs/tags/"))
+}
+
 func (c *statusCmd) getStatus(jirix *jiri.X, local project.Project, remote project.Project, currentBranch project.BranchState) (string, string, []string, error) {
 	var extraCommits []string
 	headRev := ""
@@ -211,7 +254,7 @@ func (c *statusCmd) getStatus(jirix *jiri.X, local project.Project, remote proje
 	}
 
 	if currentBranch.Name != "" && c.commits {
-		remoteBranch := "remotes/origin/" + remote.RemoteBranch
+		remoteBranch := "remotes/" + remote.PrimaryRemote() + "/" + remote.RemoteBranch
 		if currentBranch.Tracking != nil {
 			remoteBranch = currentBranch.Tracking.Name
 		}
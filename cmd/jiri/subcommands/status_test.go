@@ -7,6 +7,7 @@ package subcommands
 import (
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"sort"
 	"strconv"
@@ -439,3 +440,55 @@ func newfile(t *testing.T, dir, file string) {
 		t.Errorf("failed to create %s: %s", testfile, err)
 	}
 }
+
+func TestStatusSubmodules(t *testing.T) {
+	t.Parallel()
+
+	fake := jiritest.NewFakeJiriRoot(t)
+	localProjects := createProjects(t, fake, 2)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	superproject := localProjects[0].Path
+	for _, args := range [][]string{
+		{"-c", "protocol.file.allow=always", "submodule", "add", fake.Projects[localProjects[1].Name], "sub"},
+		{"-c", "user.name=John Doe", "-c", "user.email=john.doe@example.com", "commit", "-m", "add submodule"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = superproject
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	submodule := filepath.Join(superproject, "sub")
+	newfile(t, submodule, "untracked")
+	if err := gitutil.New(fake.X, gitutil.RootDirOpt(submodule)).CreateAndCheckoutBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+
+	fake.X.EnableSubmodules = true
+	got := executeStatus(t, fake, defaultStatusFlags(), "")
+	relativePath, err := filepath.Rel(fake.X.Cwd, submodule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got, relativePath+": ") || !strings.Contains(got, "?? untracked") || !strings.Contains(got, "Branch: feature") {
+		t.Errorf("status should show the submodule %s, got:\n%s", relativePath, got)
+	}
+
+	stdout, _, err := collectStdio(fake.X, []string{"feature"}, (&branchCmd{}).run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := localProjects[0].Name + "/sub(" + relativePath + ")"; strings.TrimSpace(stdout) != want {
+		t.Errorf("got branch output %q, want %q", stdout, want)
+	}
+
+	p, err := project.SubmoduleAtPath(fake.X, localProjects[0], submodule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Path != submodule || p.Superproject != superproject {
+		t.Errorf("got project %s(%s) of superproject %q, want the submodule", p.Name, p.Path, p.Superproject)
+	}
+}
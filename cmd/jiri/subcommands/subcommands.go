@@ -6,6 +6,7 @@ package subcommands
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
@@ -42,11 +43,19 @@ func NewCommander(args []string) (*subcommands.Commander, error) {
 
 	cdr.Register(cdr.HelpCommand(), "")
 	cdr.Register(cdr.FlagsCommand(), "")
+	cdr.Register(&analyticsCmd{cmdBase: b}, "")
+	cdr.Register(&applyPatchsetCmd{cmdBase: b}, "")
+	cdr.Register(&bisectCmd{cmdBase: b}, "")
 	cdr.Register(&branchCmd{cmdBase: b}, "")
 	cdr.Register(&diffCmd{cmdBase: b}, "")
+	cdr.Register(&doctorCmd{cmdBase: b}, "")
 	cdr.Register(&grepCmd{cmdBase: b}, "")
+	cdr.Register(&historyCmd{cmdBase: b}, "")
 	cdr.Register(&initCmd{cmdBase: b}, "")
+	cdr.Register(&logCmd{cmdBase: b}, "")
+	cdr.Register(&newProjectCmd{cmdBase: b}, "")
 	cdr.Register(&patchCmd{cmdBase: b}, "")
+	cdr.Register(&resolveConflictsCmd{cmdBase: b}, "")
 	cdr.Register(&runpCmd{cmdBase: b}, "")
 	cdr.Register(&selfUpdateCmd{cmdBase: b}, "")
 	cdr.Register(&statusCmd{cmdBase: b}, "")
@@ -56,20 +65,30 @@ func NewCommander(args []string) (*subcommands.Commander, error) {
 
 	lowLevelGroup := "advanced operations"
 	cdr.Register(&bootstrapCmd{cmdBase: b}, lowLevelGroup)
+	cdr.Register(&checkAttributesCmd{cmdBase: b}, lowLevelGroup)
 	cdr.Register(&checkCleanCmd{cmdBase: b}, lowLevelGroup)
+	cdr.Register(&cipdCmd{cmdBase: b}, lowLevelGroup)
 	cdr.Register(&editCmd{cmdBase: b}, lowLevelGroup)
+	cdr.Register(&envCmd{cmdBase: b}, lowLevelGroup)
 	cdr.Register(&fetchPkgsCmd{cmdBase: b}, lowLevelGroup)
+	cdr.Register(&gcCmd{cmdBase: b}, lowLevelGroup)
 	cdr.Register(&genGitModuleCmd{cmdBase: b}, lowLevelGroup)
 	cdr.Register(&importCmd{cmdBase: b}, lowLevelGroup)
+	cdr.Register(&inferManifestCmd{cmdBase: b}, lowLevelGroup)
+	cdr.Register(&lastFailureCmd{cmdBase: b}, lowLevelGroup)
 	cdr.Register(&manifestCmd{cmdBase: b}, lowLevelGroup)
 	cdr.Register(&overrideCmd{cmdBase: b}, lowLevelGroup)
 	cdr.Register(&packageCmd{cmdBase: b}, lowLevelGroup)
+	cdr.Register(&pinCmd{cmdBase: b}, lowLevelGroup)
 	cdr.Register(&projectCmd{cmdBase: b}, lowLevelGroup)
 	cdr.Register(&projectConfigCmd{cmdBase: b}, lowLevelGroup)
 	cdr.Register(&resolveCmd{cmdBase: b}, lowLevelGroup)
+	cdr.Register(&rollImportCmd{cmdBase: b}, lowLevelGroup)
 	cdr.Register(&runHooksCmd{cmdBase: b}, lowLevelGroup)
+	cdr.Register(&serveCmd{cmdBase: b}, lowLevelGroup)
 	cdr.Register(&snapshotCmd{cmdBase: b}, lowLevelGroup)
 	cdr.Register(&sourceManifestCmd{cmdBase: b}, lowLevelGroup)
+	cdr.Register(&validateRemotesCmd{cmdBase: b}, lowLevelGroup)
 
 	// Register "jiri help <topic>" subcommands.
 	helpTopicsGroup := "jiri help"
@@ -97,24 +116,40 @@ func executeWrapper(ctx context.Context, f func(jirix *jiri.X, args []string) er
 			return err
 		}
 		defer jirix.RunCleanup()
-		return f(jirix, args)
+		err = f(jirix, args)
+		jirix.SetCommandResult(err)
+		return err
 	}()
-	return errToExitStatus(ctx, err)
+	return errToExitStatus(ctx, err, topLevelFlags.ErrorFormat)
 }
 
-func errToExitStatus(ctx context.Context, err error) subcommands.ExitStatus {
-	if err != nil {
-		env := cmdline.EnvFromContext(ctx)
-		if env != nil && env.Stderr != nil {
+func errToExitStatus(ctx context.Context, err error, errorFormat string) subcommands.ExitStatus {
+	if err == nil {
+		return subcommands.ExitSuccess
+	}
+	status := subcommands.ExitFailure
+	class := ""
+	var exitCodeErr cmdline.ErrExitCode
+	var classifiedErr jiri.ClassifiedError
+	if errors.As(err, &exitCodeErr) {
+		status = subcommands.ExitStatus(int(exitCodeErr))
+	} else if errors.As(err, &classifiedErr) {
+		status = subcommands.ExitStatus(classifiedErr.ExitCode())
+		class = classifiedErr.Class()
+	}
+	env := cmdline.EnvFromContext(ctx)
+	if env != nil && env.Stderr != nil {
+		if errorFormat == "json" {
+			json.NewEncoder(env.Stderr).Encode(struct {
+				Error    string `json:"error"`
+				Class    string `json:"class,omitempty"`
+				ExitCode int    `json:"exit_code"`
+			}{err.Error(), class, int(status)})
+		} else {
 			fmt.Fprintf(env.Stderr, "ERROR: %s\n", err)
 		}
-		var exitCodeErr cmdline.ErrExitCode
-		if errors.As(err, &exitCodeErr) {
-			return subcommands.ExitStatus(int(exitCodeErr))
-		}
-		return subcommands.ExitFailure
 	}
-	return subcommands.ExitSuccess
+	return status
 }
 
 type topic struct {
@@ -5,11 +5,15 @@
 package subcommands
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"testing"
 
 	"github.com/google/subcommands"
+	"go.fuchsia.dev/jiri"
 	"go.fuchsia.dev/jiri/cmdline"
 )
 
@@ -35,15 +39,58 @@ func TestErrToExitStatus(t *testing.T) {
 			err:  cmdline.ErrExitCode(42),
 			want: 42,
 		},
+		{
+			name: "network error",
+			err:  fmt.Errorf("fetch failed: %w", &jiri.NetworkError{Err: fmt.Errorf("foo")}),
+			want: jiri.ExitCodeNetwork,
+		},
+		{
+			name: "dirty tree error",
+			err:  errors.Join(fmt.Errorf("non-fatal errors"), &jiri.DirtyTreeError{Project: "foo", Path: "foo"}),
+			want: jiri.ExitCodeDirtyTree,
+		},
+		{
+			name: "manifest error",
+			err:  &jiri.ManifestError{Err: fmt.Errorf("foo")},
+			want: jiri.ExitCodeManifest,
+		},
+		{
+			name: "hook error",
+			err:  &jiri.HookError{Err: fmt.Errorf("foo")},
+			want: jiri.ExitCodeHook,
+		},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
-			got := errToExitStatus(context.Background(), tc.err)
+			got := errToExitStatus(context.Background(), tc.err, "text")
 			if got != tc.want {
 				t.Errorf("Wrong exit code %d, wanted %d", got, tc.want)
 			}
 		})
 	}
 }
+
+func TestErrToExitStatusJSON(t *testing.T) {
+	t.Parallel()
+
+	var stderr bytes.Buffer
+	env := &cmdline.Env{Stderr: &stderr}
+	ctx := cmdline.AddEnvToContext(context.Background(), env)
+	err := fmt.Errorf("update failed: %w", &jiri.HookError{Err: fmt.Errorf("hook foo failed")})
+	if got := errToExitStatus(ctx, err, "json"); got != jiri.ExitCodeHook {
+		t.Errorf("Wrong exit code %d, wanted %d", got, jiri.ExitCodeHook)
+	}
+	var out struct {
+		Error    string `json:"error"`
+		Class    string `json:"class"`
+		ExitCode int    `json:"exit_code"`
+	}
+	if err := json.Unmarshal(stderr.Bytes(), &out); err != nil {
+		t.Fatalf("cannot parse %q: %v", stderr.String(), err)
+	}
+	if out.Error != err.Error() || out.Class != "hook" || out.ExitCode != jiri.ExitCodeHook {
+		t.Errorf("unexpected error output: %+v", out)
+	}
+}
@@ -6,9 +6,12 @@ package subcommands
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/google/subcommands"
@@ -37,14 +40,29 @@ type updateCmd struct {
 	rebaseCurrent         bool
 	rebaseTracked         bool
 	runHooks              bool
+	forceHooks            bool
+	fetchCheckpoint       bool
 	fetchPkgs             bool
 	overrideOptional      bool
+	offline               bool
+	validateRemotes       bool
+	resetOnForcePush      bool
+	autoStash             bool
+	forceFlags            bool
+	dryRun                bool
+	noCheckout            bool
+	forceGitHooks         bool
+	migrateDefaultBranch  bool
 	packagesToSkip        arrayFlag
 	localManifestProjects arrayFlag
+	profile               string
+	wait                  bool
+	groupFlags
+	attrFlags
 }
 
 func (c *updateCmd) SetFlags(f *flag.FlagSet) {
-	f.BoolVar(&c.gc, "gc", true, "Garbage collect obsolete repositories.")
+	f.BoolVar(&c.gc, "gc", true, "Garbage collect obsolete repositories. See also \"jiri gc\".")
 	f.BoolVar(&c.localManifest, "local-manifest", false, "Use local manifest")
 	f.UintVar(&c.attempts, "attempts", 3, "Number of attempts before failing.")
 	f.BoolVar(&c.autoupdate, "autoupdate", true, "Automatically update to the new version.")
@@ -56,10 +74,25 @@ func (c *updateCmd) SetFlags(f *flag.FlagSet) {
 	f.BoolVar(&c.rebaseCurrent, "rebase-current", false, "Deprecated. Implies -rebase-tracked. Would be removed in future.")
 	f.BoolVar(&c.rebaseTracked, "rebase-tracked", false, "Rebase current tracked branches instead of fast-forwarding them.")
 	f.BoolVar(&c.runHooks, "run-hooks", true, "Run hooks after updating sources.")
+	f.BoolVar(&c.forceHooks, "force-hooks", false, "Run all hooks, even those whose inputs did not change since they last ran successfully.")
 	f.BoolVar(&c.fetchPkgs, "fetch-packages", true, "Use cipd to fetch packages.")
 	f.BoolVar(&c.overrideOptional, "override-optional", false, "Override existing optional attributes in the snapshot file with current jiri settings")
+	f.BoolVar(&c.offline, "offline", false, "Update without network access, from the objects and packages already present locally. Fails with the list of what is missing if that is not possible.")
+	f.BoolVar(&c.resetOnForcePush, "reset-on-force-push", false, "Reset local branches whose upstream was force-pushed onto the new upstream, saving them to refs/jiri/backups/<branch>/<time> first. By default such branches are left alone with a warning.")
+	f.BoolVar(&c.autoStash, "autostash", false, "Stash the uncommitted changes of projects, update them and restore the changes, instead of not updating projects with uncommitted changes. The changes are kept in the stash if restoring them conflicts.")
+	f.BoolVar(&c.forceFlags, "force-flags", false, "Write the flag files of projects and packages even when manifest elements conflict on them, the last one in file, kind and name order winning. By default conflicting flag files are not written.")
+	f.BoolVar(&c.dryRun, "dry-run", false, "Print what the update would create, move, delete and update, and the packages it would download, without changing anything.")
+	f.BoolVar(&c.forceGitHooks, "force-githooks", false, "Reinstall the git hooks of the manifest in all projects, even if jiri is configured to keep existing git hooks, e.g. to discard local modifications.")
+	f.BoolVar(&c.noCheckout, "no-checkout", false, "Only fetch projects, into the cache if one is configured, without changing working trees, packages or hooks.")
+	f.BoolVar(&c.migrateDefaultBranch, "migrate-default-branch", false, "Move projects whose remote branch no longer exists to the new default branch of their remote, and fix their remotebranch in local manifests.")
+	f.BoolVar(&c.fetchCheckpoint, "fetch-checkpoint", false, "Record the projects fetched until the update succeeds, so that retrying a failed update soon after with the same manifest only fetches the projects which failed to fetch.")
+	f.BoolVar(&c.validateRemotes, "validate-remotes", false, "Check that the remotes and refs of all projects exist before updating. See \"jiri validate-remotes\".")
 	f.Var(&c.packagesToSkip, "package-to-skip", "Skip fetching this package. Repeatable.")
 	f.Var(&c.localManifestProjects, "local-manifest-project", "Import projects whose local manifests should be respected. Repeatable.")
+	c.groupFlags.setFlags(f)
+	c.attrFlags.setFlags(f)
+	f.BoolVar(&c.wait, "wait", false, "Wait for other jiri commands changing the root to finish instead of failing.")
+	f.StringVar(&c.profile, "profile", "", "Write the time spent fetching, checking out and rebasing each project, and the totals per host, to this file as JSON.")
 }
 
 func (c *updateCmd) Name() string     { return "update" }
@@ -75,6 +108,89 @@ Usage:
   jiri update [flags] <file or url>
 
 <file or url> points to snapshot to checkout.
+
+The url may be http://, https://, gs://, which is read with gcloud or gsutil,
+or file://. When an https server requires authentication, the credential is
+asked to the git credential helpers, as for git remotes. Downloaded snapshots
+are cached by the sha256 hash of their content in .jiri_root/snapshots,
+readable only by the user. A "#sha256=<hex>" fragment pins the hash of the
+snapshot: it is then read from the cache when already downloaded, even with
+-offline, and the update fails if the downloaded content does not match.
+Offline, a snapshot without a pin is read from the content last downloaded
+from its url.
+
+The -rebase-tracked, -rebase-all and -rebase-untracked flags only apply to
+projects without a rebase policy. A project's "rebase" attribute in the
+manifest, or "jiri project-config -rebase" locally, sets its policy: "never"
+only fast-forwards the current branch, "tracked" rebases it, and "all" rebases
+all tracked branches.
+
+The -group and -exclude-group flags override the manifest groups set by
+"jiri init" for this update, and -attrs overrides the attributes of the
+optional projects and packages to fetch set by "jiri init -fetch-optional",
+including those recorded in <file or url>. The attributes of the update are
+written to .jiri_root/attributes.json.
+
+With -offline, jiri does not fetch projects, manifests or packages, and does
+not update itself. It still moves and checks out projects to revisions that
+were fetched before, updates JIRI_HEAD and runs hooks. Before changing
+anything, it fails with the list of projects, revisions and packages that are
+missing locally, if any.
+
+Hooks only run when their inputs changed since they last ran successfully:
+the paths listed in their "inputs" attribute, relative to their project, or
+their whole project if they have none. -force-hooks runs all hooks.
+
+Projects with uncommitted changes are not updated. With -autostash, their
+changes are stashed, the projects are updated, and the changes are restored.
+The stash is recorded in the metadata of the project until then. If restoring
+the changes conflicts with the update, the conflicts are left in the working
+tree, the changes are kept in the stash and the project is reported as
+failed; it is not stashed again until that stash is applied or dropped.
+
+Patchsets applied by "jiri apply-patchset" are reverted first, and jiri fails
+if one cannot be reverted cleanly.
+
+If review notes are enabled with "jiri init -review-notes", jiri then fetches
+the Gerrit review notes of the selected projects and indexes them, for
+"jiri log -with-cl".
+
+With -validate-remotes, jiri first checks with "git ls-remote" that the remote
+of every project is reachable and that its branch and pinned revision exist,
+and fails without changing anything if they do not.
+
+With -fetch-checkpoint, e.g. on builders retrying failed updates, jiri records
+the projects it fetched in .jiri_root/fetch_checkpoint.json until the update
+succeeds. Retrying the update within an hour, with the same projects and
+revisions in the manifest, does not fetch these projects again.
+
+Flag files, written for the "flag" attribute of projects and packages, are
+not written when manifest elements disagree on their content, or when both a
+project and a package write the same file. -force-flags writes them anyway.
+"jiri resolve -list-flags" lists which element writes which flag file.
+
+With -dry-run, jiri loads the manifest and fetches projects as usual, then
+prints the plan of the update instead of running it: the projects to create,
+move, delete and update, with the number of commits each one moves, and the
+packages that are not present locally. jiri does not update itself, and
+neither checks out projects, fetches packages nor runs hooks.
+
+With -no-checkout, jiri only fetches the objects of all projects, into the
+cache if one is set with "jiri init -cache", so that later updates need little
+network access, e.g. for nightly cache warmers on shared builders. Working
+trees, JIRI_HEAD, packages and hooks are left as they are, and projects that
+are not checked out yet are only fetched into the cache.
+
+If the remote branch of a project no longer exists because the default branch
+of its remote was renamed, e.g. from "master" to "main", jiri warns with the
+remotebranch to set in the manifest. With -migrate-default-branch, jiri
+instead updates the project to the new default branch, makes the local
+branches tracking the old one track the new one, and sets remotebranch for
+the projects declared in the root manifest or in a -local-manifest-project.
+
+Only one command changing the root runs at a time. If another one, e.g.
+another "jiri update", holds the root lock, jiri fails unless -wait is
+given, in which case it waits for the lock to be released.
 `
 }
 
@@ -95,8 +211,33 @@ func (c *updateCmd) run(jirix *jiri.X, args []string) error {
 		return jirix.UsageErrorf("Number of attempts should be >= 1")
 	}
 	jirix.Attempts = c.attempts
+	c.groupFlags.apply(jirix)
+	c.attrFlags.apply(jirix)
+	jirix.Offline = c.offline
+	jirix.ResetOnForcePush = c.resetOnForcePush
+	jirix.AutoStash = c.autoStash
+	jirix.ForceFlags = c.forceFlags
+	if c.offline && c.validateRemotes {
+		return jirix.UsageErrorf("-validate-remotes cannot be used with -offline")
+	}
+	if c.dryRun && len(args) > 0 {
+		return jirix.UsageErrorf("-dry-run cannot be used with a snapshot")
+	}
+	if c.noCheckout && (len(args) > 0 || c.offline || c.dryRun) {
+		return jirix.UsageErrorf("-no-checkout cannot be used with a snapshot, -offline or -dry-run")
+	}
+	// Updates which don't change checkouts are not recorded.
+	recorded := !c.dryRun && !c.noCheckout
+
+	if c.profile != "" {
+		defer func() {
+			if err := writeUpdateProfile(jirix, c.profile); err != nil {
+				jirix.Logger.Errorf("Failed to write update profile: %v", err)
+			}
+		}()
+	}
 
-	if c.autoupdate {
+	if c.autoupdate && !c.offline && !c.dryRun {
 		// Try to update Jiri itself.
 		if err := retry.Function(jirix, func() error {
 			return jiri.UpdateAndExecute(jirix, c.forceAutoupdate)
@@ -104,14 +245,65 @@ func (c *updateCmd) run(jirix *jiri.X, args []string) error {
 			fmt.Fprintf(jirix.Stdout(), "warning: automatic update failed: %v\n", err)
 		}
 	}
+	if err := jirix.LockRoot(c.Name(), c.wait); err != nil {
+		return err
+	}
 	if c.rebaseCurrent {
 		jirix.Logger.Warningf("c. -rebase-current has been deprecated, please use -rebase-tracked.\n\n")
 		c.rebaseTracked = true
 	}
 
+	// Hacky workaround to reduce redundancy while maintaining compatibility
+	// with legacy manifest loading logic and minimizing developer workflow
+	// disruption. Previously, localManifest was a boolean value set by the
+	// -local-manifest flag. To extend functionality for using the local
+	// manifests of import projects, we are passing a list of projects to
+	// use the local manifest for.
+	if c.localManifest && len(c.localManifestProjects) == 0 {
+		defaultLocalManifestProjects, err := getDefaultLocalManifestProjects(jirix)
+		if err != nil {
+			return err
+		}
+		c.localManifestProjects = defaultLocalManifestProjects
+	}
+	params := c.updateParams(jirix, args)
+
+	if c.validateRemotes {
+		snapshot := ""
+		if len(args) > 0 {
+			snapshot = args[0]
+		}
+		problems, err := validateManifestRemotes(jirix, snapshot, c.localManifestProjects, defaultJobsPerHost)
+		if err != nil {
+			return err
+		}
+		if err := reportRemoteProblems(jirix, problems); err != nil {
+			return err
+		}
+	}
+
+	if c.forceGitHooks {
+		jirix.KeepGitHooks = false
+	}
+
+	if recorded {
+		// Patchsets applied by "jiri apply-patchset" are reverted so that
+		// projects are updated from the revisions they were applied on.
+		localProjects, err := project.LocalProjects(jirix, project.FastScan)
+		if err != nil {
+			return err
+		}
+		if err := project.RevertPatchsets(jirix, localProjects); err != nil {
+			return fmt.Errorf("failed to revert the applied patchsets: %v", err)
+		}
+	}
+
 	if len(args) > 0 {
-		jirix.OverrideOptional = c.overrideOptional
+		if c.overrideOptional {
+			jirix.OverrideOptional = true
+		}
 		if err := project.CheckoutSnapshot(jirix, args[0], c.gc, c.runHooks, c.fetchPkgs, c.hookTimeout, c.fetchPkgsTimeout, c.packagesToSkip); err != nil {
+			recordUpdateFailures(jirix, params, append([]error{err}, jirix.FailureErrors()...)...)
 			return err
 		}
 	} else {
@@ -124,48 +316,160 @@ func (c *updateCmd) run(jirix *jiri.X, args []string) error {
 			}
 		}
 
-		// Hacky workaround to reduce redundancy while maintaining compatibility
-		// with legacy manifest loading logic and minimizing developer workflow
-		// disruption. Previously, localManifest was a boolean value set by the
-		// -local-manifest flag. To extend functionality for using the local
-		// manifests of import projects, we are passing a list of projects to
-		// use the local manifest for.
-		if c.localManifest && len(c.localManifestProjects) == 0 {
-			defaultLocalManifestProjects, err := getDefaultLocalManifestProjects(jirix)
-			if err != nil {
-				return err
-			}
-			c.localManifestProjects = defaultLocalManifestProjects
-		}
-
 		err := project.UpdateUniverse(jirix, project.UpdateUniverseParams{
 			GC:                    c.gc,
 			RebaseTracked:         c.rebaseTracked,
 			RebaseUntracked:       c.rebaseUntracked,
 			RebaseAll:             c.rebaseAll,
 			RunHooks:              c.runHooks,
+			ForceHooks:            c.forceHooks,
+			FetchCheckpoint:       c.fetchCheckpoint,
 			FetchPackages:         c.fetchPkgs,
 			RunHookTimeout:        c.hookTimeout,
 			FetchPackagesTimeout:  c.fetchPkgsTimeout,
 			PackagesToSkip:        c.packagesToSkip,
 			LocalManifestProjects: c.localManifestProjects,
+			DryRun:                c.dryRun,
+			NoCheckout:            c.noCheckout,
+			MigrateDefaultBranch:  c.migrateDefaultBranch,
 		})
 		if err != nil {
+			if recorded {
+				recordUpdateFailures(jirix, params, append([]error{err}, jirix.FailureErrors()...)...)
+			}
 			return err
 		}
 
 		// Only track on successful update
-		if duration.Nanoseconds() > 0 {
+		if duration.Nanoseconds() > 0 && recorded {
 			jirix.AnalyticsSession.AddCommandExecutionTiming("update", duration)
 		}
 	}
 
+	if jirix.ReviewNotes != "" && !c.offline && !c.dryRun {
+		// The index is an aid for auditing, so failing to update it doesn't
+		// fail the update.
+		if localProjects, err := project.LocalProjects(jirix, project.FastScan); err != nil {
+			jirix.Logger.Warningf("Not indexing review notes: %v\n\n", err)
+		} else if err := project.UpdateReviewIndexes(jirix, localProjects); err != nil {
+			jirix.Logger.Warningf("%v\n\n", err)
+		}
+	}
+
 	if jirix.Failures() != 0 {
-		return fmt.Errorf("Project update completed with non-fatal errors")
+		// Include the recorded failures so that their class (e.g. a dirty
+		// tree) determines the exit code.
+		errs := append([]error{fmt.Errorf("Project update completed with non-fatal errors")}, jirix.FailureErrors()...)
+		if recorded {
+			recordUpdateFailures(jirix, params, errs...)
+		}
+		return errors.Join(errs...)
 	}
+	if !recorded {
+		return nil
+	}
+	recordUpdateFailures(jirix, params)
 
 	if err := project.WriteUpdateHistoryLog(jirix); err != nil {
 		jirix.Logger.Errorf("Failed to save jiri logs: %v", err)
 	}
 	return nil
 }
+
+// updateParams returns the parameters of the update for
+// "jiri last-failure -rerun", once the flags are applied to jirix.
+func (c *updateCmd) updateParams(jirix *jiri.X, args []string) project.UpdateParams {
+	params := project.UpdateParams{
+		LocalManifestProjects: c.localManifestProjects,
+		Groups:                jirix.Groups,
+		ExcludedGroups:        jirix.ExcludedGroups,
+		FetchingAttrs:         jirix.FetchingAttrs,
+		OverrideOptional:      jirix.OverrideOptional,
+		RebaseTracked:         c.rebaseTracked,
+		RebaseUntracked:       c.rebaseUntracked,
+		RebaseAll:             c.rebaseAll,
+		AutoStash:             c.autoStash,
+		ResetOnForcePush:      c.resetOnForcePush,
+	}
+	if len(args) > 0 {
+		params.Snapshot = args[0]
+	}
+	return params
+}
+
+// recordUpdateFailures records the failures of an update run with params,
+// errs, for "jiri last-failure", or forgets those of the previous update if
+// there are none.
+func recordUpdateFailures(jirix *jiri.X, params project.UpdateParams, errs ...error) {
+	failures := project.UpdateFailures(errs...)
+	var err error
+	if len(failures) == 0 {
+		err = project.ClearLastFailure(jirix)
+	} else {
+		err = project.WriteLastFailure(jirix, strings.Join(os.Args, " "), params, failures)
+	}
+	if err != nil {
+		jirix.Logger.Errorf("Failed to record the failures of the update: %v", err)
+	}
+}
+
+// groupFlags are the flags of the commands that select manifest groups.
+type groupFlags struct {
+	groups         arrayFlag
+	excludedGroups arrayFlag
+}
+
+func (g *groupFlags) setFlags(f *flag.FlagSet) {
+	f.Var(&g.groups, "group", "Only include projects and packages of this manifest group, and those of no group. Repeatable.")
+	f.Var(&g.excludedGroups, "exclude-group", "Leave out projects and packages of this manifest group. Repeatable.")
+}
+
+// apply overrides the groups configured for jirix with the flags that are
+// set.
+func (g *groupFlags) apply(jirix *jiri.X) {
+	if len(g.groups) != 0 {
+		jirix.Groups = strings.Join(g.groups, ",")
+	}
+	if len(g.excludedGroups) != 0 {
+		jirix.ExcludedGroups = strings.Join(g.excludedGroups, ",")
+	}
+}
+
+// attrFlags are the flags of the commands that select optional projects and
+// packages by attribute.
+type attrFlags struct {
+	attrs string
+	// attrsSet is true if -attrs was passed, as an empty value selects no
+	// optional projects and packages.
+	attrsSet bool
+}
+
+func (a *attrFlags) setFlags(f *flag.FlagSet) {
+	f.Func("attrs", `Comma separated attributes of the optional projects and packages to select, instead of those set by "jiri init -fetch-optional".`, func(value string) error {
+		a.attrs, a.attrsSet = value, true
+		return nil
+	})
+}
+
+// apply overrides the attributes configured for jirix with the flag if it is
+// set, including those recorded in snapshots.
+func (a *attrFlags) apply(jirix *jiri.X) {
+	if a.attrsSet {
+		jirix.FetchingAttrs = a.attrs
+		jirix.OverrideOptional = true
+	}
+}
+
+// writeUpdateProfile writes the project timings recorded by the update to
+// file as JSON.
+func writeUpdateProfile(jirix *jiri.X, file string) error {
+	profile := jirix.UpdateProfile()
+	if profile == nil {
+		profile = &jiri.UpdateProfile{Projects: []jiri.ProjectTiming{}, Hosts: []jiri.HostTiming{}}
+	}
+	data, err := json.MarshalIndent(profile, "", "  ")
+	if err != nil {
+		return err
+	}
+	return project.SafeWriteFile(jirix, file, append(data, '\n'))
+}
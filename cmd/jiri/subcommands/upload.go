@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/google/subcommands"
@@ -27,6 +28,7 @@ type uploadCmd struct {
 	reviewers      string
 	topic          string
 	verify         bool
+	noVerify       bool
 	rebase         bool
 	setTopic       bool
 	multipart      bool
@@ -34,6 +36,10 @@ type uploadCmd struct {
 	remoteBranch   string
 	labels         string
 	gitOptions     string
+	wip            bool
+	ready          bool
+	hashtags       arrayFlag
+	pushOptions    arrayFlag
 	rebaseFailures uint32
 }
 
@@ -55,6 +61,21 @@ Usage:
 
 <ref> is the valid git ref to upload. It is optional and HEAD is used by
 default. This cannot be used with -multipart flag.
+
+The reviewers and hashtags set on the project by the "reviewers" and
+"hashtags" attributes of the manifest, or by "jiri project-config", are added
+to those of the flags.
+
+With "jiri init -enable-submodules", commits of a git submodule checked out in
+a project are uploaded from the submodule, to the branch of the submodule in
+.gitmodules or "main", and -multipart includes the submodules on the branch.
+
+Before pushing, jiri runs the upload checks that apply to the uploaded
+projects, in parallel: those declared in the <uploadchecks> of the manifest,
+and those a project declares in its .jiri/upload_checks.xml file, if the
+manifest sets allowhooks="true" for it. Nothing is pushed if one of them
+fails. -no-verify skips them, along with the pre-push
+git hooks.
 `
 }
 
@@ -63,10 +84,16 @@ func (c *uploadCmd) SetFlags(f *flag.FlagSet) {
 	f.StringVar(&c.presubmit, "presubmit", string(gerrit.PresubmitTestTypeAll),
 		fmt.Sprintf("The type of presubmit tests to run. Valid values: %s.", strings.Join(gerrit.PresubmitTestTypes(), ",")))
 	f.StringVar(&c.reviewers, "r", "", `Comma-separated list of emails or LDAPs to request review.`)
+	f.StringVar(&c.reviewers, "reviewers", "", `Same as -r.`)
+	f.BoolVar(&c.wip, "wip", false, `Mark the CL as work in progress.`)
+	f.BoolVar(&c.ready, "ready", false, `Mark the CL as ready for review.`)
+	f.Var(&c.hashtags, "hashtag", `Hashtag to add to the CL. Repeatable.`)
+	f.Var(&c.pushOptions, "push-option", `Gerrit push option to pass through, as key=value or a bare flag, e.g. "notify=OWNER". Repeatable.`)
 	f.StringVar(&c.labels, "l", "", `Comma-separated list of review labels.`)
 	f.StringVar(&c.topic, "topic", "", `CL topic. Default is <username>-<branchname>. If this flag is set, upload will ignore -set-topic and will set a topic.`)
 	f.BoolVar(&c.setTopic, "set-topic", false, `Set topic. This flag would be ignored if -topic passed.`)
-	f.BoolVar(&c.verify, "verify", true, `Run pre-push git hooks.`)
+	f.BoolVar(&c.verify, "verify", true, `Run pre-push git hooks and upload checks.`)
+	f.BoolVar(&c.noVerify, "no-verify", false, `Skip pre-push git hooks and upload checks. Same as -verify=false.`)
 	f.BoolVar(&c.rebase, "rebase", false, `Run rebase before pushing.`)
 	f.BoolVar(&c.multipart, "multipart", false, `Send multipart CL. Use -set-topic or -topic flag if you want to set a topic.`)
 	f.StringVar(&c.branch, "branch", "", `Used when multipart flag is true and this command is executed from root folder`)
@@ -89,6 +116,9 @@ func (c *uploadCmd) run(jirix *jiri.X, args []string) error {
 	if c.multipart && refToUpload != "HEAD" {
 		return jirix.UsageErrorf("can only use HEAD as <ref> when using -multipart flag.")
 	}
+	if c.wip && c.ready {
+		return jirix.UsageErrorf("-wip and -ready cannot be used together")
+	}
 	cwd := jirix.Cwd
 	var p *project.Project
 	// Walk up the path until we find a project at that path, or hit the jirix.Root parent.
@@ -107,6 +137,14 @@ func (c *uploadCmd) run(jirix *jiri.X, args []string) error {
 		p = &project
 		break
 	}
+	if p != nil {
+		// The current directory may be in a submodule of the project.
+		submodule, err := project.SubmoduleAtPath(jirix, *p, jirix.Cwd)
+		if err != nil {
+			return err
+		}
+		p = &submodule
+	}
 
 	setTopic := c.setTopic
 
@@ -151,6 +189,14 @@ func (c *uploadCmd) run(jirix *jiri.X, args []string) error {
 	if err != nil {
 		return err
 	}
+	remoteProjects, checks, err := project.LoadManifestUploadChecks(jirix, jirix.JiriManifestFile(), localProjects)
+	if err != nil {
+		return err
+	}
+	if localProjects, err = project.WithSubmodules(jirix, localProjects); err != nil {
+		return err
+	}
+	addSubmoduleRemotes(localProjects, remoteProjects)
 	if c.multipart {
 		for _, project := range localProjects {
 			scm := gitutil.New(jirix, gitutil.RootDirOpt(project.Path))
@@ -176,11 +222,8 @@ func (c *uploadCmd) run(jirix *jiri.X, args []string) error {
 		CLOpts       gerrit.CLOpts
 		relativePath string
 	}
+	verify := c.verify && !c.noVerify
 	var gerritPushOptions []GerritPushOption
-	remoteProjects, _, _, err := project.LoadManifestFile(jirix, jirix.JiriManifestFile(), localProjects, nil)
-	if err != nil {
-		return err
-	}
 	for _, project := range projectsToProcess {
 		scm := gitutil.New(jirix, gitutil.RootDirOpt(project.Path))
 		relativePath, err := filepath.Rel(cwd, project.Path)
@@ -211,15 +254,30 @@ func (c *uploadCmd) run(jirix *jiri.X, args []string) error {
 			}
 		}
 
+		// The defaults of the manifest and of the local config of the
+		// project are added to the flags.
+		reviewers := parseEmails(c.reviewers)
+		hashtags := parseLabels(strings.Join(c.hashtags, ","))
+		if r, ok := remoteProjects[project.Key()]; ok {
+			reviewers = appendUnique(reviewers, parseEmails(r.Reviewers)...)
+			hashtags = appendUnique(hashtags, parseLabels(r.Hashtags)...)
+		}
+		reviewers = appendUnique(reviewers, parseEmails(project.LocalConfig.Reviewers)...)
+		hashtags = appendUnique(hashtags, parseLabels(project.LocalConfig.Hashtags)...)
+
 		opts := gerrit.CLOpts{
 			Ccs:          parseEmails(c.ccs),
 			GitOptions:   c.gitOptions,
+			Hashtags:     hashtags,
 			Presubmit:    gerrit.PresubmitTestType(c.presubmit),
+			PushOptions:  c.pushOptions,
+			Ready:        c.ready,
 			RemoteBranch: remoteBranch,
-			Remote:       "origin",
-			Reviewers:    parseEmails(c.reviewers),
+			Remote:       project.PrimaryRemote(),
+			Reviewers:    reviewers,
 			Labels:       parseLabels(c.labels),
-			Verify:       c.verify,
+			Verify:       verify,
+			WIP:          c.wip,
 			Topic:        topic,
 			RefToUpload:  refToUpload,
 		}
@@ -234,10 +292,10 @@ func (c *uploadCmd) run(jirix *jiri.X, args []string) error {
 	if c.rebase {
 		for _, gerritPushOption := range gerritPushOptions {
 			scm := gitutil.New(jirix, gitutil.RootDirOpt(gerritPushOption.Project.Path))
-			if err := scm.Fetch("origin"); err != nil {
+			if err := scm.Fetch(gerritPushOption.CLOpts.Remote); err != nil {
 				return err
 			}
-			remoteBranch := "remotes/origin/" + gerritPushOption.CLOpts.RemoteBranch
+			remoteBranch := "remotes/" + gerritPushOption.CLOpts.Remote + "/" + gerritPushOption.CLOpts.RemoteBranch
 			if err = scm.Rebase(remoteBranch); err != nil {
 				if err2 := scm.RebaseAbort(); err2 != nil {
 					return err2
@@ -247,6 +305,20 @@ func (c *uploadCmd) run(jirix *jiri.X, args []string) error {
 		}
 	}
 
+	if verify {
+		var targets []project.UploadTarget
+		for _, gerritPushOption := range gerritPushOptions {
+			targets = append(targets, project.UploadTarget{
+				Project: gerritPushOption.Project,
+				Ref:     gerritPushOption.CLOpts.RefToUpload,
+				Base:    gerritPushOption.CLOpts.Remote + "/" + gerritPushOption.CLOpts.RemoteBranch,
+			})
+		}
+		if err := project.RunUploadChecks(jirix, checks, remoteProjects, targets); err != nil {
+			return uploadError(err.Error() + "\n\nFix them, or run with -no-verify to upload anyway.")
+		}
+	}
+
 	for _, gerritPushOption := range gerritPushOptions {
 		fmt.Fprintf(jirix.Stdout(), "Pushing project %s(%s)\n", gerritPushOption.Project.Name, gerritPushOption.relativePath)
 		if err := gerrit.Push(jirix, gerritPushOption.Project.Path, gerritPushOption.CLOpts); err != nil {
@@ -299,3 +371,13 @@ func parseLabels(value string) []string {
 	}
 	return ret
 }
+
+// appendUnique appends the items of values that are not in list yet.
+func appendUnique(list []string, values ...string) []string {
+	for _, v := range values {
+		if !slices.Contains(list, v) {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -9,6 +9,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
 
 	"go.fuchsia.dev/jiri"
@@ -412,6 +413,67 @@ func TestUploadUntrackedBranch(t *testing.T) {
 	assertUploadPushedFilesToRef(t, fake.X, gerritPath, expectedRef, files)
 }
 
+// TestUploadPushOptions checks that push options of the flags are combined
+// with the defaults of the manifest and of the local config of the project.
+func TestUploadPushOptions(t *testing.T) {
+	t.Parallel()
+
+	localProjects, fake := setupUniverse(t)
+	m, err := fake.ReadRemoteManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range m.Projects {
+		if p.Name == localProjects[1].Name {
+			m.Projects[i].Reviewers = "a@example.com"
+		}
+	}
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	p, err := project.ProjectAtPath(fake.X, localProjects[1].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lc := p.LocalConfig
+	lc.Hashtags = "local"
+	if err := project.WriteLocalConfig(fake.X, p, lc); err != nil {
+		t.Fatal(err)
+	}
+
+	git := gitutil.New(fake.X,
+		gitutil.RootDirOpt(localProjects[1].Path),
+		gitutil.UserNameOpt("John Doe"),
+		gitutil.UserEmailOpt("john.doe@example.com"))
+	if err := git.CreateBranchWithUpstream("my-branch", "origin/main"); err != nil {
+		t.Fatal(err)
+	}
+	if err := git.Checkout("my-branch"); err != nil {
+		t.Fatal(err)
+	}
+	files := []string{"file1"}
+	commitFiles(t, git, files)
+
+	fake.X.Cwd = git.RootDir()
+	cmd := defaultUploadFlags()
+	cmd.wip = true
+	cmd.ready = true
+	if err := cmd.run(fake.X, nil); err == nil {
+		t.Errorf("-wip and -ready should not be allowed together")
+	}
+	cmd.ready = false
+	cmd.hashtags = arrayFlag{"flag"}
+	cmd.pushOptions = arrayFlag{"notify=OWNER"}
+	if err := cmd.run(fake.X, nil); err != nil {
+		t.Fatal(err)
+	}
+	expectedRef := "refs/for/main%wip,r=a@example.com,t=flag,t=local,notify=OWNER"
+	assertUploadPushedFilesToRef(t, fake.X, fake.Projects[localProjects[1].Name], expectedRef, files)
+}
+
 func TestGitOptions(t *testing.T) {
 	t.Parallel()
 
@@ -442,6 +504,114 @@ func TestGitOptions(t *testing.T) {
 	assertUploadFilesNotPushedToRef(t, fake.X, gerritPath, expectedRef, files)
 }
 
+// TestUploadChecks checks that failing upload checks of the manifest and of
+// the project block the upload, unless -no-verify is passed.
+func TestUploadChecks(t *testing.T) {
+	t.Parallel()
+
+	localProjects, fake := setupUniverse(t)
+	m, err := fake.ReadRemoteManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.UploadChecks = []project.UploadCheck{
+		{Name: "no-bad-files", ProjectName: localProjects[0].Name, Action: "check.sh", Projects: "project-1"},
+		{Name: "never", ProjectName: localProjects[0].Name, Action: "never.sh", Projects: "project-2"},
+	}
+	for i := range m.Projects {
+		if m.Projects[i].Name == localProjects[1].Name {
+			m.Projects[i].AllowHooks = true
+		}
+	}
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+
+	checkScript := "#!/bin/sh\nif git diff --name-only $JIRI_UPLOAD_BASE..$JIRI_UPLOAD_REF | grep -q bad; then\n  echo found a bad file\n  exit 1\nfi\n"
+	if err := os.WriteFile(filepath.Join(localProjects[0].Path, "check.sh"), []byte(checkScript), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(localProjects[0].Path, "never.sh"), []byte("#!/bin/sh\nexit 1\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// The project records the project and base it is uploaded to.
+	recordScript := "#!/bin/sh\necho $JIRI_UPLOAD_PROJECT $JIRI_UPLOAD_BASE > $JIRI_ROOT/record\n"
+	if err := os.MkdirAll(filepath.Join(localProjects[1].Path, ".jiri"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	checks := `<uploadchecks><check name="record" action="record.sh"/></uploadchecks>`
+	if err := os.WriteFile(filepath.Join(localProjects[1].Path, ".jiri", "upload_checks.xml"), []byte(checks), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(localProjects[1].Path, "record.sh"), []byte(recordScript), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	git := gitutil.New(fake.X,
+		gitutil.RootDirOpt(localProjects[1].Path),
+		gitutil.UserNameOpt("John Doe"),
+		gitutil.UserEmailOpt("john.doe@example.com"))
+	if err := git.CreateBranchWithUpstream("my-branch", "origin/main"); err != nil {
+		t.Fatal(err)
+	}
+	if err := git.Checkout("my-branch"); err != nil {
+		t.Fatal(err)
+	}
+	files := []string{"file1", "bad"}
+	commitFiles(t, git, files)
+
+	gerritPath := fake.Projects[localProjects[1].Name]
+	fake.X.Cwd = localProjects[1].Path
+	cmd := defaultUploadFlags()
+	err = cmd.run(fake.X, []string{})
+	if err == nil {
+		t.Fatal("expected the upload checks to fail")
+	}
+	if !strings.Contains(err.Error(), `check "no-bad-files" failed for project project-1`) || !strings.Contains(err.Error(), "found a bad file") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(err.Error(), `"never"`) || strings.Contains(err.Error(), `"record"`) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(fake.X.Root, "record")); err != nil {
+		t.Fatal(err)
+	} else if got, want := strings.TrimSpace(string(data)), "project-1 origin/main"; got != want {
+		t.Fatalf("got record %q, want %q", got, want)
+	}
+	if _, err := gitutil.New(fake.X, gitutil.RootDirOpt(gerritPath)).CurrentRevisionForRef("refs/for/main"); err == nil {
+		t.Fatal("expected nothing to be pushed")
+	}
+
+	// The checks of the project only run if the manifest allows them.
+	for i := range m.Projects {
+		m.Projects[i].AllowHooks = false
+	}
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(fake.X.Root, "record")); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.run(fake.X, []string{}); err == nil {
+		t.Fatal("expected the upload checks to fail")
+	}
+	if _, err := os.Stat(filepath.Join(fake.X.Root, "record")); !os.IsNotExist(err) {
+		t.Errorf("the checks of a project the manifest does not allow should not run: %v", err)
+	}
+
+	cmd.noVerify = true
+	if err := cmd.run(fake.X, []string{}); err != nil {
+		t.Fatal(err)
+	}
+	assertUploadPushedFilesToRef(t, fake.X, gerritPath, "refs/for/main", files)
+}
+
 // commitFile commits a file with the specified content into a branch
 func commitFile(t *testing.T, git *gitutil.Git, filename string, content string) {
 	t.Helper()
@@ -0,0 +1,251 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/google/subcommands"
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/gitutil"
+	"go.fuchsia.dev/jiri/project"
+)
+
+// defaultJobsPerHost is the default number of concurrent ls-remote requests
+// sent to a single host.
+const defaultJobsPerHost = 4
+
+type validateRemotesCmd struct {
+	cmdBase
+
+	jobsPerHost uint
+	jsonOutput  string
+}
+
+func (c *validateRemotesCmd) Name() string { return "validate-remotes" }
+func (c *validateRemotesCmd) Synopsis() string {
+	return "Check that the remotes and refs of the manifest exist"
+}
+func (c *validateRemotesCmd) Usage() string {
+	return `Checks, with "git ls-remote", that the remote of every project in the
+manifest is reachable, that its remote branch exists, and that its pinned
+revision, if any, can be found. Nothing is fetched or checked out, so this is
+much cheaper than a failed "jiri update".
+
+A pinned revision is found if it is the tip of a remote branch or tag, or if it
+is already present in the local checkout. Pinned revisions that cannot be
+verified this way are reported as warnings, since ls-remote can only see the
+tips of refs.
+
+Requests run in parallel, with at most -jobs-per-host of them sent to the same
+host at a time, or fewer if the host has a lower limit in the jiri config.
+
+The same check runs before updating with "jiri update -validate-remotes".
+
+Usage:
+  jiri validate-remotes [flags] [<snapshot>]
+
+<snapshot> is a snapshot file or url to check instead of the manifest.
+`
+}
+
+func (c *validateRemotesCmd) SetFlags(f *flag.FlagSet) {
+	f.UintVar(&c.jobsPerHost, "jobs-per-host", defaultJobsPerHost, "Maximum number of concurrent requests to a single host.")
+	f.StringVar(&c.jsonOutput, "json-output", "", "File to write the problems found to, in json format.")
+}
+
+func (c *validateRemotesCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	return executeWrapper(ctx, c.run, c.topLevelFlags, f.Args())
+}
+
+func (c *validateRemotesCmd) run(jirix *jiri.X, args []string) error {
+	if len(args) > 1 {
+		return jirix.UsageErrorf("unexpected number of arguments")
+	}
+	if c.jobsPerHost == 0 {
+		return jirix.UsageErrorf("-jobs-per-host should be >= 1")
+	}
+	snapshot := ""
+	if len(args) == 1 {
+		snapshot = args[0]
+	}
+	problems, err := validateManifestRemotes(jirix, snapshot, nil, c.jobsPerHost)
+	if err != nil {
+		return err
+	}
+	if c.jsonOutput != "" {
+		if problems == nil {
+			problems = []remoteProblem{}
+		}
+		if err := writeJSONOutput(c.jsonOutput, problems); err != nil {
+			return err
+		}
+	}
+	return reportRemoteProblems(jirix, problems)
+}
+
+// remoteProblem describes a remote or ref of a project that could not be
+// found.
+type remoteProblem struct {
+	Name    string `json:"name"`
+	Remote  string `json:"remote"`
+	Ref     string `json:"ref,omitempty"`
+	Error   string `json:"error"`
+	Warning bool   `json:"warning,omitempty"`
+}
+
+// validateManifestRemotes loads the projects of snapshot, or of the manifest
+// if it is empty, and validates their remotes.
+func validateManifestRemotes(jirix *jiri.X, snapshot string, localManifestProjects []string, jobsPerHost uint) ([]remoteProblem, error) {
+	localProjects, err := project.LocalProjects(jirix, project.FastScan)
+	if err != nil {
+		return nil, err
+	}
+	var projects project.Projects
+	if snapshot != "" {
+		projects, _, _, err = project.LoadSnapshotFile(jirix, snapshot)
+	} else {
+		projects, _, _, err = project.LoadUpdatedManifest(jirix, localProjects, localManifestProjects)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return validateRemotes(jirix, projects, localProjects, jobsPerHost), nil
+}
+
+// validateRemotes checks the remotes, remote branches and pinned revisions of
+// projects with ls-remote, and returns the problems found sorted by project
+// name. Revisions missing from the remote refs are looked up in localProjects.
+func validateRemotes(jirix *jiri.X, projects, localProjects project.Projects, jobsPerHost uint) []remoteProblem {
+	// Query each remote once, for the branches of all its projects. The
+	// remotes are grouped as rewritten by the URL rewrite rules, but git
+	// applies the rules itself when querying them.
+	byRemote := make(map[string][]project.Project)
+	for _, p := range projects {
+		remote := jirix.RewriteURL(p.Name, p.Remote)
+		byRemote[remote] = append(byRemote[remote], p)
+	}
+	hostLimits := make(map[string]chan struct{})
+	for remote := range byRemote {
+		if host := jiri.RemoteHost(remote); hostLimits[host] == nil {
+			jobs := jobsPerHost
+			if limit := jirix.HostLimitFor(host); limit.Jobs != 0 && limit.Jobs < jobs {
+				jobs = limit.Jobs
+			}
+			hostLimits[host] = make(chan struct{}, jobs)
+		}
+	}
+
+	var mu sync.Mutex
+	var problems []remoteProblem
+	limit := make(chan struct{}, jirix.Jobs)
+	var wg sync.WaitGroup
+	for remote, ps := range byRemote {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hostLimit := hostLimits[jiri.RemoteHost(remote)]
+			limit <- struct{}{}
+			hostLimit <- struct{}{}
+			found := validateRemote(jirix, ps, localProjects)
+			<-hostLimit
+			<-limit
+			mu.Lock()
+			problems = append(problems, found...)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	sort.Slice(problems, func(i, j int) bool {
+		if problems[i].Name != problems[j].Name {
+			return problems[i].Name < problems[j].Name
+		}
+		return problems[i].Ref < problems[j].Ref
+	})
+	return problems
+}
+
+// validateRemote validates projects, which share the same rewritten remote.
+func validateRemote(jirix *jiri.X, projects []project.Project, localProjects project.Projects) []remoteProblem {
+	patterns := []string{"HEAD"}
+	for _, p := range projects {
+		if p.Revision != "" && p.Revision != "HEAD" {
+			// Pinned revisions are looked up among the tips of all branches
+			// and tags.
+			patterns = []string{"HEAD", "refs/heads/*", "refs/tags/*"}
+			break
+		}
+		patterns = append(patterns, "refs/heads/"+remoteBranch(p))
+	}
+	refs, err := gitutil.New(jirix, gitutil.RootDirOpt(jirix.Root), gitutil.ProjectOpt(projects[0].Name)).LsRemoteRefs(projects[0].Remote, patterns...)
+	var problems []remoteProblem
+	for _, p := range projects {
+		if err != nil {
+			problems = append(problems, remoteProblem{Name: p.Name, Remote: p.Remote, Error: fmt.Sprintf("remote is unreachable: %v", err)})
+			continue
+		}
+		// Projects pinned to a revision only need their branch if it is
+		// given explicitly.
+		if p.Revision == "" || p.Revision == "HEAD" || p.RemoteBranch != "" {
+			branch := remoteBranch(p)
+			if _, ok := refs["refs/heads/"+branch]; !ok {
+				problems = append(problems, remoteProblem{Name: p.Name, Remote: p.Remote, Ref: branch, Error: "remote branch not found"})
+			}
+		}
+		if p.Revision != "" && p.Revision != "HEAD" && !revisionFound(jirix, p, refs, localProjects) {
+			problems = append(problems, remoteProblem{Name: p.Name, Remote: p.Remote, Ref: p.Revision, Error: "revision is not the tip of a remote ref nor present locally, it could not be verified", Warning: true})
+		}
+	}
+	return problems
+}
+
+// revisionFound reports whether the pinned revision of p is one of refs, or is
+// present in the local checkout of p.
+func revisionFound(jirix *jiri.X, p project.Project, refs map[string]string, localProjects project.Projects) bool {
+	for _, rev := range refs {
+		if rev == p.Revision {
+			return true
+		}
+	}
+	if local, ok := localProjects[p.Key()]; ok {
+		scm := gitutil.New(jirix, gitutil.RootDirOpt(local.Path))
+		return scm.IsRevAvailable(jirix, p.Remote, p.Revision)
+	}
+	return false
+}
+
+// remoteBranch returns the remote branch of p.
+func remoteBranch(p project.Project) string {
+	if p.RemoteBranch != "" {
+		return p.RemoteBranch
+	}
+	return "main"
+}
+
+// reportRemoteProblems prints problems and returns an error if any of them is
+// not a warning.
+func reportRemoteProblems(jirix *jiri.X, problems []remoteProblem) error {
+	errors := 0
+	for _, p := range problems {
+		ref := ""
+		if p.Ref != "" {
+			ref = " " + p.Ref
+		}
+		if p.Warning {
+			jirix.Logger.Warningf("project %s (%s)%s: %s\n\n", p.Name, p.Remote, ref, p.Error)
+			continue
+		}
+		errors++
+		fmt.Fprintf(jirix.Stdout(), "project %s (%s)%s: %s\n", p.Name, p.Remote, ref, p.Error)
+	}
+	if errors != 0 {
+		return fmt.Errorf("%d problem(s) found with the remotes of the manifest", errors)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package subcommands
+
+import (
+	"path/filepath"
+	"testing"
+
+	"go.fuchsia.dev/jiri/gitutil"
+	"go.fuchsia.dev/jiri/project"
+)
+
+func TestValidateRemotes(t *testing.T) {
+	localProjects, fake := setupUniverse(t)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	cmd := validateRemotesCmd{jobsPerHost: 1}
+	if _, _, err := collectStdio(fake.X, nil, cmd.run); err != nil {
+		t.Fatalf("manifest should be valid: %v", err)
+	}
+
+	rev, err := gitutil.New(fake.X, gitutil.RootDirOpt(localProjects[0].Path)).CurrentRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	projects := project.Projects{}
+	for _, p := range []project.Project{
+		{Name: "ok", Remote: localProjects[0].Remote, Revision: rev},
+		{Name: "no-branch", Remote: localProjects[1].Remote, RemoteBranch: "missing"},
+		{Name: "no-remote", Remote: filepath.Join(t.TempDir(), "missing")},
+		{Name: "unknown-rev", Remote: localProjects[2].Remote, Revision: "0123456789012345678901234567890123456789"},
+	} {
+		projects[p.Key()] = p
+	}
+	problems := validateRemotes(fake.X, projects, nil, 2)
+	if len(problems) != 3 {
+		t.Fatalf("got problems %+v, want 3", problems)
+	}
+	if p := problems[0]; p.Name != "no-branch" || p.Ref != "missing" || p.Warning {
+		t.Errorf("got %+v, want missing branch of no-branch", p)
+	}
+	if p := problems[1]; p.Name != "no-remote" || p.Warning {
+		t.Errorf("got %+v, want unreachable no-remote", p)
+	}
+	if p := problems[2]; p.Name != "unknown-rev" || !p.Warning {
+		t.Errorf("got %+v, want unverified revision warning for unknown-rev", p)
+	}
+	if err := reportRemoteProblems(fake.X, problems[2:]); err != nil {
+		t.Errorf("warnings should not fail: %v", err)
+	}
+	if err := reportRemoteProblems(fake.X, problems); err == nil {
+		t.Errorf("missing refs should fail")
+	}
+}
@@ -31,7 +31,7 @@ Usage:
 func (c *versionCmd) SetFlags(f *flag.FlagSet) {}
 
 func (c *versionCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
-	return errToExitStatus(ctx, c.run(ctx, f.Args()))
+	return errToExitStatus(ctx, c.run(ctx, f.Args()), c.topLevelFlags.ErrorFormat)
 }
 
 func (c *versionCmd) run(_ context.Context, _ []string) error {
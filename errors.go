@@ -0,0 +1,72 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jiri
+
+import "fmt"
+
+// Process exit codes for each class of failure. They are chosen not to clash
+// with the codes used by individual commands (see cmdline.ErrExitCode).
+const (
+	ExitCodeNetwork   = 30
+	ExitCodeDirtyTree = 31
+	ExitCodeManifest  = 32
+	ExitCodeHook      = 33
+	ExitCodeLocked    = 34
+)
+
+// ClassifiedError is implemented by errors that belong to a failure class
+// that automation may want to tell apart, e.g. to retry network failures but
+// not local ones.
+type ClassifiedError interface {
+	error
+	// Class is a short, stable name for the failure class.
+	Class() string
+	// ExitCode is the process exit code used for this failure class.
+	ExitCode() int
+}
+
+// NetworkError is returned when talking to a remote (git host, CIPD) failed.
+type NetworkError struct {
+	Err error
+}
+
+func (e *NetworkError) Error() string { return e.Err.Error() }
+func (e *NetworkError) Unwrap() error { return e.Err }
+func (e *NetworkError) Class() string { return "network" }
+func (e *NetworkError) ExitCode() int { return ExitCodeNetwork }
+
+// DirtyTreeError is returned when a project could not be updated because it
+// contains local changes.
+type DirtyTreeError struct {
+	Project string
+	Path    string
+}
+
+func (e *DirtyTreeError) Error() string {
+	return fmt.Sprintf("project %s(%s) contains uncommitted changes", e.Project, e.Path)
+}
+func (e *DirtyTreeError) Class() string { return "dirty-tree" }
+func (e *DirtyTreeError) ExitCode() int { return ExitCodeDirtyTree }
+
+// ManifestError is returned when the manifest could not be loaded or is
+// invalid.
+type ManifestError struct {
+	Err error
+}
+
+func (e *ManifestError) Error() string { return e.Err.Error() }
+func (e *ManifestError) Unwrap() error { return e.Err }
+func (e *ManifestError) Class() string { return "manifest" }
+func (e *ManifestError) ExitCode() int { return ExitCodeManifest }
+
+// HookError is returned when running hooks failed.
+type HookError struct {
+	Err error
+}
+
+func (e *HookError) Error() string { return e.Err.Error() }
+func (e *HookError) Unwrap() error { return e.Err }
+func (e *HookError) Class() string { return "hook" }
+func (e *HookError) ExitCode() int { return ExitCodeHook }
@@ -55,6 +55,8 @@ type CLOpts struct {
 	Autosubmit bool
 	// Ccs records a list of email addresses to cc on the CL.
 	Ccs []string
+	// Hashtags records a list of hashtags to add to the CL.
+	Hashtags []string
 	// Draft determines if this CL is a draft.
 	Draft bool
 	// Edit determines if the user should be prompted to edit the commit
@@ -68,6 +70,12 @@ type CLOpts struct {
 	Remote string
 	// Presubmit determines what presubmit tests to run.
 	Presubmit PresubmitTestType
+	// PushOptions records additional Gerrit push options, as "key=value"
+	// or bare flags such as "private", appended to the reference as is
+	// apart from the escaping of their values.
+	PushOptions []string
+	// Ready marks the CL as ready for review.
+	Ready bool
 	// RemoteBranch identifies the remote branch the CL pertains to.
 	RemoteBranch string
 	// Reviewers records a list of email addresses of CL reviewers.
@@ -76,6 +84,8 @@ type CLOpts struct {
 	Topic string
 	// Verify controls whether git pre-push hooks should be run before uploading.
 	Verify bool
+	// WIP marks the CL as a work in progress.
+	WIP bool
 	//Ref to upload. Default is HEAD
 	RefToUpload string
 }
@@ -535,11 +545,29 @@ func (g *Gerrit) Submit(changeID string) (e error) {
 func formatParams(params []string, key string) []string {
 	var keyedParams []string
 	for _, param := range params {
-		keyedParams = append(keyedParams, key+"="+param)
+		keyedParams = append(keyedParams, key+"="+escapePushOption(param))
 	}
 	return keyedParams
 }
 
+// escapePushOption percent-encodes the characters of a push option value
+// that Gerrit would otherwise parse, such as the commas separating options,
+// as well as spaces and non-printable characters.
+func escapePushOption(value string) string {
+	var b strings.Builder
+	for _, c := range []byte(value) {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+			b.WriteByte(c)
+		case strings.IndexByte("@.-_+:/~^", c) >= 0:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
 // Reference inputs CL options and returns a matching string
 // representation of a Gerrit reference.
 func Reference(opts CLOpts) string {
@@ -550,11 +578,24 @@ func Reference(opts CLOpts) string {
 		ref = "refs/for/" + opts.RemoteBranch
 	}
 	var params []string
+	if opts.WIP {
+		params = append(params, "wip")
+	}
+	if opts.Ready {
+		params = append(params, "ready")
+	}
 	params = append(params, formatParams(opts.Labels, "l")...)
 	params = append(params, formatParams(opts.Reviewers, "r")...)
 	params = append(params, formatParams(opts.Ccs, "cc")...)
 	if opts.Topic != "" {
-		params = append(params, "topic="+opts.Topic)
+		params = append(params, "topic="+escapePushOption(opts.Topic))
+	}
+	params = append(params, formatParams(opts.Hashtags, "t")...)
+	for _, option := range opts.PushOptions {
+		if key, value, ok := strings.Cut(option, "="); ok {
+			option = key + "=" + escapePushOption(value)
+		}
+		params = append(params, option)
 	}
 	if len(params) > 0 {
 		ref = ref + "%" + strings.Join(params, ",")
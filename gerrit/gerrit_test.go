@@ -379,5 +379,21 @@ func TestReference(t *testing.T) {
 	}
 }
 
+func TestReferencePushOptions(t *testing.T) {
+	t.Parallel()
+	testOpts := CLOpts{
+		RemoteBranch: "main",
+		WIP:          true,
+		Reviewers:    []string{"foo@example.com"},
+		Topic:        "my topic",
+		Hashtags:     []string{"bar", "a,b"},
+		PushOptions:  []string{"notify=OWNER", "m=100%_done", "private"},
+	}
+	gold := "refs/for/main%wip,r=foo@example.com,topic=my%20topic,t=bar,t=a%2Cb,notify=OWNER,m=100%25_done,private"
+	if ref := Reference(testOpts); gold != ref {
+		t.Errorf("expecting %q, got %q", gold, ref)
+	}
+}
+
 // TODO(jsimsa): Add a test for the hostCredentials function that
 // exercises the logic that reads the .netrc and git cookie files.
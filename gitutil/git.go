@@ -9,12 +9,16 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"go.fuchsia.dev/jiri"
 	"go.fuchsia.dev/jiri/envvar"
@@ -56,9 +60,13 @@ type Git struct {
 	rootDir   string
 	userName  string
 	userEmail string
+	project   string
+	// env are environment variables of the git commands overriding those
+	// of jiri.
+	env map[string]string
 }
 
-type gitOpt interface {
+type GitOpt interface {
 	gitOpt()
 }
 type AuthorDateOpt string
@@ -67,11 +75,16 @@ type RootDirOpt string
 type UserNameOpt string
 type UserEmailOpt string
 
+// ProjectOpt is the name of the project that git runs for, so that the URL
+// rewrite rules of this project apply, see jiri.X.URLRewritesFor.
+type ProjectOpt string
+
 func (AuthorDateOpt) gitOpt()    {}
 func (CommitterDateOpt) gitOpt() {}
 func (RootDirOpt) gitOpt()       {}
 func (UserNameOpt) gitOpt()      {}
 func (UserEmailOpt) gitOpt()     {}
+func (ProjectOpt) gitOpt()       {}
 
 type Reference struct {
 	Name     string
@@ -93,10 +106,11 @@ const (
 )
 
 // New is the Git factory.
-func New(jirix *jiri.X, opts ...gitOpt) *Git {
+func New(jirix *jiri.X, opts ...GitOpt) *Git {
 	rootDir := jirix.Cwd
 	userName := ""
 	userEmail := ""
+	project := ""
 	env := map[string]string{}
 	for _, opt := range opts {
 		switch typedOpt := opt.(type) {
@@ -110,6 +124,8 @@ func New(jirix *jiri.X, opts ...gitOpt) *Git {
 			userName = string(typedOpt)
 		case UserEmailOpt:
 			userEmail = string(typedOpt)
+		case ProjectOpt:
+			project = string(typedOpt)
 		}
 	}
 	return &Git{
@@ -118,6 +134,7 @@ func New(jirix *jiri.X, opts ...gitOpt) *Git {
 		rootDir:   rootDir,
 		userName:  userName,
 		userEmail: userEmail,
+		project:   project,
 	}
 }
 
@@ -254,6 +271,53 @@ func (g *Git) GetAllBranchesInfo() ([]Branch, error) {
 	return branches, nil
 }
 
+// BranchDetail describes a local branch, see BranchDetails.
+type BranchDetail struct {
+	Name     string
+	Revision string
+	IsHead   bool
+	// Upstream is the short name of the upstream branch, e.g.
+	// "origin/main", or empty if the branch has no upstream.
+	Upstream string
+	// UpstreamGone is set if the upstream branch no longer exists.
+	UpstreamGone bool
+	// CommitTime is the committer date of the tip of the branch.
+	CommitTime time.Time
+	// Subject is the subject of the tip of the branch.
+	Subject string
+}
+
+// BranchDetails returns the details of the local branches, sorted by name.
+// Unlike GetAllBranchesInfo, the upstream of branches is reported even if
+// it no longer exists.
+func (g *Git) BranchDetails() ([]BranchDetail, error) {
+	out, err := g.runOutput("for-each-ref", "--format", "%(refname:short)%00%(objectname)%00%(HEAD)%00%(upstream:short)%00%(upstream:track)%00%(committerdate:unix)%00%(contents:subject)", "refs/heads")
+	if err != nil {
+		return nil, err
+	}
+	var branches []BranchDetail
+	for _, line := range out {
+		s := strings.SplitN(line, "\x00", 7)
+		if len(s) != 7 {
+			return nil, fmt.Errorf("unexpected for-each-ref output %q", line)
+		}
+		seconds, err := strconv.ParseInt(s[5], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected commit time %q of branch %q", s[5], s[0])
+		}
+		branches = append(branches, BranchDetail{
+			Name:         s[0],
+			Revision:     s[1],
+			IsHead:       s[2] == "*",
+			Upstream:     s[3],
+			UpstreamGone: s[4] == "[gone]",
+			CommitTime:   time.Unix(seconds, 0),
+			Subject:      s[6],
+		})
+	}
+	return branches, nil
+}
+
 // IsRevAvailable checks if a commit hash is available locally.
 func (g *Git) IsRevAvailable(jirix *jiri.X, remote, rev string) bool {
 	// If it wants HEAD, always fetch.
@@ -264,12 +328,12 @@ func (g *Git) IsRevAvailable(jirix *jiri.X, remote, rev string) bool {
 	if jirix.UsePartialClone(remote) {
 		currentRevision, err := g.CurrentRevision()
 		if err != nil {
-			jirix.Logger.Errorf("could not get current revision\n")
+			jirix.Logger.Component("gitutil").Errorf("could not get current revision\n")
 			return false
 		}
 		expectedRevision, err := g.CurrentRevisionForRef(rev)
 		if err != nil {
-			jirix.Logger.Errorf("could not get revision\n")
+			jirix.Logger.Component("gitutil").Errorf("could not get revision\n")
 			return false
 		}
 		if currentRevision != expectedRevision {
@@ -312,6 +376,7 @@ func (g *Git) Checkout(ref string, opts ...CheckoutOpt) error {
 // Clone clones the given repository to the given local path.  If reference is
 // not empty it uses the given path as a reference/shared repo.
 func (g *Git) Clone(repo, path string, opts ...CloneOpt) error {
+	var config []string
 	args := []string{"clone"}
 	for _, opt := range opts {
 		switch typedOpt := opt.(type) {
@@ -336,6 +401,10 @@ func (g *Git) Clone(repo, path string, opts ...CloneOpt) error {
 			if typedOpt > 0 {
 				args = append(args, []string{"--depth", strconv.Itoa(int(typedOpt))}...)
 			}
+		case ShallowSinceOpt:
+			if typedOpt != "" {
+				args = append(args, "--shallow-since="+string(typedOpt))
+			}
 		case OmitBlobsOpt:
 			if typedOpt {
 				args = append(args, "--filter=blob:none")
@@ -344,11 +413,23 @@ func (g *Git) Clone(repo, path string, opts ...CloneOpt) error {
 			if typedOpt {
 				args = append(args, "--dissociate")
 			}
+		case BundleURIOpt:
+			if typedOpt != "" {
+				args = append(args, "--bundle-uri="+string(typedOpt))
+			}
+		case ServerBundlesOpt:
+			if typedOpt {
+				config = append(config, "-c", "transfer.bundleURI=true")
+			}
+		case OriginOpt:
+			if typedOpt != "" {
+				args = append(args, "--origin", string(typedOpt))
+			}
 		}
 	}
 	args = append(args, repo)
 	args = append(args, path)
-	return g.run(args...)
+	return g.run(append(config, args...)...)
 }
 
 // CloneMirror clones the given repository using mirror flag.
@@ -474,7 +555,7 @@ func (g *Git) OneLineLog(rev string) (string, error) {
 		return "", err
 	}
 	if got, want := len(out), 1; got != want {
-		g.jirix.Logger.Warningf("wanted one line log, got %d line log: %q", got, out)
+		g.jirix.Logger.Component("gitutil").Warningf("wanted one line log, got %d line log: %q", got, out)
 	}
 	return out[0], nil
 }
@@ -528,6 +609,43 @@ func (g *Git) LsRemote(args ...string) (string, error) {
 	return out[0], nil
 }
 
+// RemoteDefaultBranch returns the branch that HEAD of the given remote
+// points to, e.g. "main".
+func (g *Git) RemoteDefaultBranch(remote string) (string, error) {
+	out, err := g.runOutput("ls-remote", "--symref", remote, "HEAD")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range out {
+		ref, name, ok := strings.Cut(line, "\t")
+		if !ok || name != "HEAD" || !strings.HasPrefix(ref, "ref: ") {
+			continue
+		}
+		return strings.TrimPrefix(strings.TrimPrefix(ref, "ref: "), "refs/heads/"), nil
+	}
+	return "", fmt.Errorf("HEAD of %s is not a symbolic reference", remote)
+}
+
+// LsRemoteRefs lists the references of a remote repository that match the
+// given patterns, all of them if there are none, and returns their revisions
+// keyed by reference name.
+func (g *Git) LsRemoteRefs(remote string, patterns ...string) (map[string]string, error) {
+	args := append([]string{"ls-remote", remote}, patterns...)
+	out, err := g.runOutput(args...)
+	if err != nil {
+		return nil, err
+	}
+	refs := make(map[string]string)
+	for _, line := range out {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("git ls-remote %s: unexpected line %q", remote, line)
+		}
+		refs[fields[1]] = fields[0]
+	}
+	return refs, nil
+}
+
 // CreateBranchWithUpstream creates a new branch and sets the upstream
 // repository to the given upstream.
 func (g *Git) CreateBranchWithUpstream(branch, upstream string) error {
@@ -546,6 +664,24 @@ func (g *Git) ShortHash(ref string) (string, error) {
 	return out[0], nil
 }
 
+// PathObjectID returns the object name of the file or directory at path,
+// relative to the root of the repository, in ref, or "" if it does not exist
+// in ref.
+func (g *Git) PathObjectID(ref, path string) (string, error) {
+	out, err := g.runOutput("ls-tree", ref, "--", strings.TrimSuffix(path, "/"))
+	if err != nil {
+		return "", err
+	}
+	if len(out) == 0 {
+		return "", nil
+	}
+	fields := strings.Fields(out[0])
+	if len(fields) < 3 {
+		return "", fmt.Errorf("unexpected output of git ls-tree: %q", out[0])
+	}
+	return fields[2], nil
+}
+
 // UserInfoForCommit returns user name and email for a given reference.
 func (g *Git) UserInfoForCommit(ref string) (string, string, error) {
 	out, err := g.runOutput("log", "-n", "1", "--format=format:%cn:%ce", ref)
@@ -737,6 +873,11 @@ func (g *Git) CreateLightweightTag(name string) error {
 	return g.run("tag", name)
 }
 
+// CreateAnnotatedTag creates an annotated tag with the given message.
+func (g *Git) CreateAnnotatedTag(name, message string) error {
+	return g.run("tag", "-a", "-m", message, name)
+}
+
 // Fetch fetches refs and tags from the given remote.
 func (g *Git) Fetch(remote string, opts ...FetchOpt) error {
 	return g.FetchRefspec(remote, "", opts...)
@@ -749,9 +890,11 @@ func (g *Git) FetchRefspec(remote, refspec string, opts ...FetchOpt) error {
 	prune := false
 	updateShallow := false
 	depth := 0
+	shallowSince := ""
 	fetchTag := ""
 	updateHeadOk := false
 	jobs := uint(0)
+	var refspecs []string
 	for _, opt := range opts {
 		switch typedOpt := opt.(type) {
 		case TagsOpt:
@@ -762,6 +905,8 @@ func (g *Git) FetchRefspec(remote, refspec string, opts ...FetchOpt) error {
 			prune = bool(typedOpt)
 		case DepthOpt:
 			depth = int(typedOpt)
+		case ShallowSinceOpt:
+			shallowSince = string(typedOpt)
 		case UpdateShallowOpt:
 			updateShallow = bool(typedOpt)
 		case FetchTagOpt:
@@ -770,6 +915,8 @@ func (g *Git) FetchRefspec(remote, refspec string, opts ...FetchOpt) error {
 			updateHeadOk = bool(typedOpt)
 		case JobsOpt:
 			jobs = uint(typedOpt)
+		case RefspecsOpt:
+			refspecs = typedOpt
 		}
 	}
 	args := []string{}
@@ -783,6 +930,9 @@ func (g *Git) FetchRefspec(remote, refspec string, opts ...FetchOpt) error {
 	if depth > 0 {
 		args = append(args, "--depth", strconv.Itoa(depth))
 	}
+	if shallowSince != "" {
+		args = append(args, "--shallow-since="+shallowSince)
+	}
 	if updateShallow {
 		args = append(args, "--update-shallow")
 	}
@@ -804,22 +954,74 @@ func (g *Git) FetchRefspec(remote, refspec string, opts ...FetchOpt) error {
 	if refspec != "" {
 		args = append(args, refspec)
 	}
+	args = append(args, refspecs...)
 
 	return g.run(args...)
 }
 
+// WorkingTreeStatus is the state of the working tree of a repository, as
+// reported by "git status".
+type WorkingTreeStatus struct {
+	// Changed lists the files with uncommitted changes, staged or not.
+	Changed []string
+	// Untracked lists the untracked files which are not ignored.
+	Untracked []string
+}
+
+// Status returns the state of the working tree. It runs a single "git
+// status", which uses the fsmonitor and the untracked cache of the
+// repository if they are enabled, rather than a diff of the index, a diff
+// of the working tree and a listing of the untracked files.
+func (g *Git) Status() (*WorkingTreeStatus, error) {
+	args := []string{"status", "--porcelain=v2", "-uall", "--no-renames", "-z"}
+	var stdout, stderr bytes.Buffer
+	if err := g.runGit(&stdout, &stderr, args...); err != nil {
+		return nil, Error(stdout.String(), stderr.String(), err, g.rootDir, args...)
+	}
+	return parseStatus(stdout.String())
+}
+
+// parseStatus parses the output of "git status --porcelain=v2 -z
+// --no-renames".
+func parseStatus(out string) (*WorkingTreeStatus, error) {
+	status := &WorkingTreeStatus{}
+	for _, entry := range strings.Split(out, "\x00") {
+		if entry == "" {
+			continue
+		}
+		var fields int
+		switch entry[0] {
+		case '1':
+			// 1 <XY> <sub> <mH> <mI> <mW> <hH> <hI> <path>
+			fields = 9
+		case 'u':
+			// u <XY> <sub> <m1> <m2> <m3> <mW> <h1> <h2> <h3> <path>
+			fields = 11
+		case '?':
+			status.Untracked = append(status.Untracked, strings.TrimPrefix(entry, "? "))
+			continue
+		case '#', '!':
+			continue
+		default:
+			return nil, fmt.Errorf("unexpected git status entry %q", entry)
+		}
+		parts := strings.SplitN(entry, " ", fields)
+		if len(parts) != fields {
+			return nil, fmt.Errorf("unexpected git status entry %q", entry)
+		}
+		status.Changed = append(status.Changed, parts[fields-1])
+	}
+	return status, nil
+}
+
 // FilesWithUncommittedChanges returns the list of files that have
 // uncommitted changes.
 func (g *Git) FilesWithUncommittedChanges() ([]string, error) {
-	out, err := g.runOutput("diff", "--name-only", "--no-ext-diff")
-	if err != nil {
-		return nil, err
-	}
-	out2, err := g.runOutput("diff", "--cached", "--name-only", "--no-ext-diff")
+	status, err := g.Status()
 	if err != nil {
 		return nil, err
 	}
-	return append(out, out2...), nil
+	return status.Changed, nil
 }
 
 // MergedBranches returns the list of all branches that were already merged.
@@ -924,11 +1126,11 @@ func (g *Git) HasUncommittedChanges() (bool, error) {
 // HasUntrackedFiles checks whether the current branch contains any
 // untracked files.
 func (g *Git) HasUntrackedFiles() (bool, error) {
-	out, err := g.UntrackedFiles()
+	status, err := g.Status()
 	if err != nil {
 		return false, err
 	}
-	return len(out) != 0, nil
+	return len(status.Untracked) != 0, nil
 }
 
 // Init initializes a new git repository.
@@ -954,6 +1156,12 @@ func (g *Git) Init(path string, opts ...CloneOpt) error {
 	return g.run(args...)
 }
 
+// SetHeadBranch makes HEAD point to branch, without checking it out, e.g. to
+// name the branch of the first commit of a new repository.
+func (g *Git) SetHeadBranch(branch string) error {
+	return g.run("symbolic-ref", "HEAD", "refs/heads/"+branch)
+}
+
 // IsFileCommitted tests whether the given file has been committed to
 // the repository.
 func (g *Git) IsFileCommitted(file string) bool {
@@ -1012,6 +1220,135 @@ func (g *Git) Log(branch, base, format string) ([][]string, error) {
 	return result, nil
 }
 
+// LogRange returns the commits of <base>..<head>, most recent first, one
+// line per commit in the specified format. At most max commits are
+// returned, or all of them if max is 0.
+func (g *Git) LogRange(base, head, format string, max int) ([]string, error) {
+	args := []string{"log", "--format=" + format}
+	if max > 0 {
+		args = append(args, fmt.Sprintf("--max-count=%d", max))
+	}
+	return g.runOutput(append(args, base+".."+head)...)
+}
+
+// Submodule is a checked out submodule of a repository.
+type Submodule struct {
+	// Name is the name of the submodule in its .gitmodules.
+	Name string
+	// Path is the path of the submodule relative to the root of the
+	// repository, with slashes.
+	Path string
+	// Revision is the revision of the submodule recorded in its
+	// superproject.
+	Revision string
+	// Branch is the branch of the submodule in its .gitmodules, if any.
+	Branch string
+	// Remote is the url of the origin remote of the submodule.
+	Remote string
+}
+
+// Submodules returns the checked out submodules of the repository,
+// including the submodules of submodules.
+func (g *Git) Submodules() ([]Submodule, error) {
+	// The path is last, since the output is trimmed and the other fields
+	// may be empty.
+	script := `printf '%s\t%s\t%s\t%s\t%s\n' "$name" "$sha1" "$(git config -f "$toplevel/.gitmodules" "submodule.$name.branch")" "$(git config remote.origin.url)" "$displaypath"`
+	out, err := g.runOutput("submodule", "foreach", "--quiet", "--recursive", script)
+	if err != nil {
+		return nil, err
+	}
+	var submodules []Submodule
+	for _, line := range out {
+		fields := strings.SplitN(line, "\t", 5)
+		if len(fields) != 5 {
+			return nil, fmt.Errorf("unexpected submodule line %q", line)
+		}
+		submodules = append(submodules, Submodule{
+			Name:     fields[0],
+			Revision: fields[1],
+			Branch:   fields[2],
+			Remote:   fields[3],
+			Path:     fields[4],
+		})
+	}
+	return submodules, nil
+}
+
+// ListNotes returns the notes of the notes ref, e.g. "refs/notes/review",
+// as a map from the annotated objects to the blobs of their notes.
+func (g *Git) ListNotes(ref string) (map[string]string, error) {
+	out, err := g.runOutput("notes", "--ref="+ref, "list")
+	if err != nil {
+		return nil, err
+	}
+	notes := make(map[string]string)
+	for _, line := range out {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("unexpected git notes output %q", line)
+		}
+		notes[fields[1]] = fields[0]
+	}
+	return notes, nil
+}
+
+// ReadBlobs returns the contents of the given blobs, read with a single
+// "git cat-file --batch".
+func (g *Git) ReadBlobs(blobs []string) (map[string]string, error) {
+	if len(blobs) == 0 {
+		return nil, nil
+	}
+	args := []string{"cat-file", "--batch"}
+	var stdout, stderr bytes.Buffer
+	stdin := strings.NewReader(strings.Join(blobs, "\n") + "\n")
+	if err := g.runGitWithStdin(stdin, &stdout, &stderr, args...); err != nil {
+		return nil, Error("", stderr.String(), err, g.rootDir, args...)
+	}
+	// The output is "<object> <type> <size>\n<contents>\n" for each blob,
+	// or "<blob> missing\n".
+	contents := make(map[string]string)
+	out := stdout.Bytes()
+	for _, blob := range blobs {
+		i := bytes.IndexByte(out, '\n')
+		if i < 0 {
+			return nil, fmt.Errorf("unexpected end of git cat-file output for %s", blob)
+		}
+		header := strings.Fields(string(out[:i]))
+		out = out[i+1:]
+		if len(header) != 3 {
+			continue
+		}
+		size, err := strconv.Atoi(header[2])
+		if err != nil || size+1 > len(out) {
+			return nil, fmt.Errorf("unexpected git cat-file header %q", strings.Join(header, " "))
+		}
+		contents[blob] = string(out[:size])
+		out = out[size+1:]
+	}
+	return contents, nil
+}
+
+// Am applies the patches in the given mailbox files as commits, falling
+// back on a three-way merge if they don't apply cleanly.
+func (g *Git) Am(files ...string) error {
+	return g.run(append([]string{"am", "--3way"}, files...)...)
+}
+
+// AmAbort aborts a failed "git am", restoring the branch as it was.
+func (g *Git) AmAbort() error {
+	return g.run("am", "--abort")
+}
+
+// ApplyPatch applies the patch in file to the working tree and the index,
+// or reverts it if reverse is set.
+func (g *Git) ApplyPatch(file string, reverse bool) error {
+	args := []string{"apply", "--index"}
+	if reverse {
+		args = append(args, "-R")
+	}
+	return g.run(append(args, file)...)
+}
+
 // Merge merges all commits from <branch> to the current branch. If
 // <squash> is set, then all merged commits are squashed into a single
 // commit.
@@ -1062,6 +1399,21 @@ func (g *Git) ModifiedFiles(baseBranch, currentBranch string) ([]string, error)
 	return out, nil
 }
 
+// Patch returns the changes of the working tree since base, or between base
+// and head if head is set, as a patch that git apply accepts. The paths of
+// the patch are prefixed with prefix.
+func (g *Git) Patch(prefix, base, head string) (string, error) {
+	args := []string{"diff", "--no-color", "--no-ext-diff", "--binary", "--src-prefix=a/" + prefix, "--dst-prefix=b/" + prefix, base}
+	if head != "" {
+		args = append(args, head)
+	}
+	var stdout, stderr bytes.Buffer
+	if err := g.runGit(&stdout, &stderr, args...); err != nil {
+		return "", Error(stdout.String(), stderr.String(), err, g.rootDir, args...)
+	}
+	return stdout.String(), nil
+}
+
 // Pull pulls the given branch from the given remote.
 func (g *Git) Pull(remote, branch string) error {
 	if out, err := g.runOutput("pull", remote, branch); err != nil {
@@ -1166,6 +1518,59 @@ func (g *Git) CherryPickAbort() error {
 	return g.run("cherry-pick", "--abort")
 }
 
+// Operations that git can stop on conflicts, see InProgress.
+const (
+	RebaseInProgress     = "rebase"
+	CherryPickInProgress = "cherry-pick"
+)
+
+// InProgress returns RebaseInProgress or CherryPickInProgress if a rebase or
+// a cherry-pick is stopped in the repository, e.g. on conflicts, or "" if
+// there is none.
+func (g *Git) InProgress() (string, error) {
+	gitDir, err := g.AbsoluteGitDir()
+	if err != nil {
+		return "", err
+	}
+	for _, p := range []struct{ path, op string }{
+		{"rebase-merge", RebaseInProgress},
+		{"rebase-apply", RebaseInProgress},
+		{"CHERRY_PICK_HEAD", CherryPickInProgress},
+	} {
+		if _, err := os.Stat(filepath.Join(gitDir, p.path)); err == nil {
+			return p.op, nil
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+// RebaseContinue continues a stopped rebase once its conflicts are
+// resolved, keeping the messages of the commits as they are.
+func (g *Git) RebaseContinue() error {
+	return g.withEnv(noEditorEnv).run("rebase", "--continue")
+}
+
+// CherryPickContinue continues a stopped cherry-pick once its conflicts are
+// resolved, keeping the message of the commit as it is.
+func (g *Git) CherryPickContinue() error {
+	return g.withEnv(noEditorEnv).run("cherry-pick", "--continue")
+}
+
+// noEditorEnv keeps commit messages as they are. GIT_EDITOR takes precedence
+// over core.editor, which it would not if set with -c, e.g. if the user set
+// GIT_EDITOR.
+var noEditorEnv = map[string]string{"GIT_EDITOR": "true"}
+
+// withEnv returns a copy of g running git with the environment variables env,
+// overriding those of jiri.
+func (g *Git) withEnv(env map[string]string) *Git {
+	c := *g
+	c.env = env
+	return &c
+}
+
 // RebaseAbort aborts an in-progress rebase operation. It should
 // only be used after invoking Rebase().
 func (g *Git) RebaseAbort() error {
@@ -1182,6 +1587,65 @@ func (g *Git) RebaseAbort() error {
 	return g.run("rebase", "--abort")
 }
 
+// Credential is a username and password of the git credential helpers, see
+// git-credential(1).
+type Credential struct {
+	Username string
+	Password string
+}
+
+// credentialDescription returns the description of the credential of u, and
+// of c if not nil, in the input format of "git credential".
+func credentialDescription(u *url.URL, c *Credential) string {
+	desc := fmt.Sprintf("protocol=%s\nhost=%s\npath=%s\n", u.Scheme, u.Host, strings.TrimPrefix(u.Path, "/"))
+	if c != nil {
+		desc += fmt.Sprintf("username=%s\npassword=%s\n", c.Username, c.Password)
+	}
+	return desc + "\n"
+}
+
+// CredentialFill returns the credential of u from the git credential
+// helpers, which may prompt the user for it.
+func (g *Git) CredentialFill(u *url.URL) (Credential, error) {
+	args := []string{"credential", "fill"}
+	var stdout, stderr bytes.Buffer
+	if err := g.runGitWithStdin(strings.NewReader(credentialDescription(u, nil)), &stdout, &stderr, args...); err != nil {
+		return Credential{}, Error("", stderr.String(), err, g.rootDir, args...)
+	}
+	var c Credential
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		key, value, _ := strings.Cut(line, "=")
+		switch key {
+		case "username":
+			c.Username = value
+		case "password":
+			c.Password = value
+		}
+	}
+	return c, nil
+}
+
+// CredentialApprove tells the git credential helpers that c, the credential
+// of u, was accepted, so that they can store it.
+func (g *Git) CredentialApprove(u *url.URL, c Credential) error {
+	return g.runCredential("approve", u, c)
+}
+
+// CredentialReject tells the git credential helpers that c, the credential
+// of u, was rejected, so that they can forget it.
+func (g *Git) CredentialReject(u *url.URL, c Credential) error {
+	return g.runCredential("reject", u, c)
+}
+
+func (g *Git) runCredential(action string, u *url.URL, c Credential) error {
+	args := []string{"credential", action}
+	var stdout, stderr bytes.Buffer
+	if err := g.runGitWithStdin(strings.NewReader(credentialDescription(u, &c)), &stdout, &stderr, args...); err != nil {
+		return Error(stdout.String(), stderr.String(), err, g.rootDir, args...)
+	}
+	return nil
+}
+
 // Remove removes the given files.
 func (g *Git) Remove(fileNames ...string) error {
 	args := []string{"rm"}
@@ -1201,11 +1665,47 @@ func (g *Git) ConfigGetKey(key string) (string, error) {
 		return "", err
 	}
 	if got, want := len(out), 1; got != want {
-		g.jirix.Logger.Warningf("wanted one line log, got %d line log: %q", got, out)
+		g.jirix.Logger.Component("gitutil").Warningf("wanted one line log, got %d line log: %q", got, out)
+	}
+	return out[0], nil
+}
+
+// ConfigGetPath returns the value of the config key holding a path, with a
+// leading "~/" expanded to the home directory, as git does when using it.
+func (g *Git) ConfigGetPath(key string) (string, error) {
+	out, err := g.runOutput("config", "--type=path", "--get", key)
+	if err != nil {
+		return "", err
 	}
 	return out[0], nil
 }
 
+// LocalConfig returns the entries of the local config of the repository,
+// as key and value pairs in the order of the config file.
+func (g *Git) LocalConfig() ([][2]string, error) {
+	args := []string{"config", "--local", "--list", "-z"}
+	var stdout, stderr bytes.Buffer
+	if err := g.runGit(&stdout, &stderr, args...); err != nil {
+		return nil, Error(stdout.String(), stderr.String(), err, g.rootDir, args...)
+	}
+	var entries [][2]string
+	for _, entry := range strings.Split(stdout.String(), "\x00") {
+		if entry == "" {
+			continue
+		}
+		// Keys without a value have no newline.
+		key, value, _ := strings.Cut(entry, "\n")
+		entries = append(entries, [2]string{key, value})
+	}
+	return entries, nil
+}
+
+// WorktreeRepair repairs the links between the repository and its linked
+// worktrees, e.g. once the repository moved.
+func (g *Git) WorktreeRepair() error {
+	return g.run("worktree", "repair")
+}
+
 // RemoteUrl gets the url of the remote with the given name.
 func (g *Git) RemoteUrl(name string) (string, error) {
 	configKey := fmt.Sprintf("remote.%s.url", name)
@@ -1219,6 +1719,13 @@ func (g *Git) RemoteUrl(name string) (string, error) {
 	return out[0], nil
 }
 
+// CheckConnectivity runs a quick `git fsck` that only checks that all the
+// objects reachable from the refs are present, which catches truncated or
+// interrupted clones.
+func (g *Git) CheckConnectivity() error {
+	return g.run("fsck", "--connectivity-only", "--no-dangling", "--no-progress")
+}
+
 // RemoveUntrackedFiles removes untracked files and directories.
 func (g *Git) RemoveUntrackedFiles() error {
 	return g.run("clean", "-d", "-f")
@@ -1242,6 +1749,21 @@ func (g *Git) Repack(opts ...RepackOpt) error {
 	return g.run(args...)
 }
 
+// IsAncestor reports whether ancestor is an ancestor of, or the same commit
+// as, descendant.
+func (g *Git) IsAncestor(ancestor, descendant string) (bool, error) {
+	err := g.run("merge-base", "--is-ancestor", ancestor, descendant)
+	if err == nil {
+		return true, nil
+	}
+	if gitErr, ok := err.(GitError); ok {
+		if exitError, ok := gitErr.err.(*exec.ExitError); ok && exitError.ExitCode() == 1 {
+			return false, nil
+		}
+	}
+	return false, err
+}
+
 // Reset resets the current branch to the target, discarding any
 // uncommitted changes.
 func (g *Git) Reset(target string, opts ...ResetOpt) error {
@@ -1267,6 +1789,12 @@ func (g *Git) SetRemoteHead() error {
 	return g.run("remote", "set-head", "origin", "-a")
 }
 
+// RenameRemote renames a remote, along with its remote-tracking branches and
+// the branches configured to track them.
+func (g *Git) RenameRemote(oldName, newName string) error {
+	return g.run("remote", "rename", oldName, newName)
+}
+
 // DeleteRemote deletes the named remote
 func (g *Git) DeleteRemote(name string) error {
 	return g.run("remote", "rm", name)
@@ -1309,6 +1837,52 @@ func (g *Git) StashPop() error {
 	return g.run("stash", "pop")
 }
 
+// StashPush stashes the uncommitted changes of the working tree and the index
+// with the given message. It returns the stash commit, or "" if there was
+// nothing to stash.
+func (g *Git) StashPush(message string) (string, error) {
+	before, _ := g.CurrentRevisionForRef("refs/stash")
+	if err := g.run("stash", "push", "-m", message); err != nil {
+		return "", err
+	}
+	after, err := g.CurrentRevisionForRef("refs/stash")
+	if err != nil || after == before {
+		return "", nil
+	}
+	return after, nil
+}
+
+// StashApply applies the changes of the stash commit rev to the working tree,
+// keeping the stash entry.
+func (g *Git) StashApply(rev string) error {
+	return g.run("stash", "apply", rev)
+}
+
+// StashIndex returns n for the stash entry stash@{n} of the stash commit rev,
+// or -1 if rev is not in the stash.
+func (g *Git) StashIndex(rev string) (int, error) {
+	out, err := g.runOutput("stash", "list", "--format=%H")
+	if err != nil {
+		return -1, err
+	}
+	for i, commit := range out {
+		if commit == rev {
+			return i, nil
+		}
+	}
+	return -1, nil
+}
+
+// StashDrop drops the stash entry of the stash commit rev, if it is in the
+// stash.
+func (g *Git) StashDrop(rev string) error {
+	i, err := g.StashIndex(rev)
+	if err != nil || i < 0 {
+		return err
+	}
+	return g.run("stash", "drop", fmt.Sprintf("stash@{%d}", i))
+}
+
 // TopLevel returns the top level path of the current repository.
 func (g *Git) TopLevel() (string, error) {
 	// TODO(sadovsky): If g.rootDir is set, perhaps simply return that?
@@ -1372,6 +1946,20 @@ func (g *Git) UntrackedFiles() ([]string, error) {
 	return out, nil
 }
 
+// SupportsBuiltinFSMonitor returns true if git has a builtin file system
+// monitor on this host, which "core.fsmonitor=true" enables. It was added in
+// git 2.36 for macOS and Windows only.
+func (g *Git) SupportsBuiltinFSMonitor() bool {
+	if runtime.GOOS != "darwin" && runtime.GOOS != "windows" {
+		return false
+	}
+	major, minor, err := g.Version()
+	if err != nil {
+		return false
+	}
+	return major > 2 || (major == 2 && minor >= 36)
+}
+
 // Version returns the major and minor git version.
 func (g *Git) Version() (int, int, error) {
 	out, err := g.runOutput("version")
@@ -1435,6 +2023,10 @@ func (g *Git) runInteractive(args ...string) error {
 }
 
 func (g *Git) runGit(stdout, stderr io.Writer, args ...string) error {
+	return g.runGitWithStdin(os.Stdin, stdout, stderr, args...)
+}
+
+func (g *Git) runGitWithStdin(stdin io.Reader, stdout, stderr io.Writer, args ...string) error {
 	config := make(map[string]string)
 	if g.userName != "" {
 		config["user.name"] = g.userName
@@ -1451,18 +2043,22 @@ func (g *Git) runGit(stdout, stderr io.Writer, args ...string) error {
 	var errbuf bytes.Buffer
 	command := exec.Command("git", args...)
 	command.Dir = g.rootDir
-	command.Stdin = os.Stdin
+	command.Stdin = stdin
 	command.Stdout = io.MultiWriter(stdout, &outbuf)
 	command.Stderr = io.MultiWriter(stderr, &errbuf)
 	env := g.jirix.Env()
-	env = envvar.MergeMaps(g.opts, env, GitConfigEnvVars(config))
+	env = envvar.MergeMaps(g.opts, env, GitConfigEnvVars(config), g.env)
+	g.addURLRewrites(env)
 	// Disable git's advice notices that suggest trying different operations.
 	// Such information isn't useful to show to users, since users aren't
 	// running the commands directly.
 	env["GIT_ADVICE"] = "0"
+	if ssh := sshCommand(g.jirix, env); ssh != "" && !hasSSHCommandConfig(g.rootDir, envvar.MapToSlice(env)) {
+		env["GIT_SSH_COMMAND"] = ssh
+	}
 	command.Env = envvar.MapToSlice(env)
 	dir := g.rootDir
-	g.jirix.Logger.Tracef("Run: git %s (%s)", strings.Join(args, " "), dir)
+	g.jirix.Logger.Component("gitutil").Tracef("Run: git %s (%s)", strings.Join(args, " "), dir)
 	err := command.Run()
 	exitCode := 0
 	if err != nil {
@@ -1470,10 +2066,92 @@ func (g *Git) runGit(stdout, stderr io.Writer, args ...string) error {
 			exitCode = exitError.ExitCode()
 		}
 	}
-	g.jirix.Logger.Tracef("Finished: git %s (%s), \nstdout: %s\nstderr: %s\nexit code: %v\n", strings.Join(args, " "), dir, outbuf.String(), errbuf.String(), exitCode)
+	g.jirix.Logger.Component("gitutil").Tracef("Finished: git %s (%s), \nstdout: %s\nstderr: %s\nexit code: %v\n", strings.Join(args, " "), dir, outbuf.String(), errbuf.String(), exitCode)
 	return err
 }
 
+// addURLRewrites adds the URL rewrite rules of jiri to the git config passed
+// in env, as "url.<base>.insteadOf" entries.  git then rewrites the remotes
+// of this command only, so that the rewritten remotes, which may hold
+// credentials, are neither stored in the repository nor shown in the
+// command line.
+func (g *Git) addURLRewrites(env map[string]string) {
+	rules := g.jirix.URLRewritesFor(g.project)
+	if len(rules) == 0 {
+		return
+	}
+	n, _ := strconv.Atoi(env["GIT_CONFIG_COUNT"])
+	for _, r := range rules {
+		env[fmt.Sprintf("GIT_CONFIG_KEY_%d", n)] = "url." + r.Base + ".insteadOf"
+		env[fmt.Sprintf("GIT_CONFIG_VALUE_%d", n)] = r.InsteadOf
+		n++
+	}
+	env["GIT_CONFIG_COUNT"] = strconv.Itoa(n)
+}
+
+// sshControlPersist is how long the master connection shared by the git
+// commands of jiri stays open after the last of them, so that the commands
+// run one after the other, e.g. the fetches of an update, reuse it.
+const sshControlPersist = "60s"
+
+// sshCommand returns the ssh command that makes ssh check host keys against
+// the known_hosts file of jiri as well as those of the user, and share
+// connections if jirix.ReuseConnections is set. It returns "" if there is
+// nothing to configure or ssh is already configured in env.
+func sshCommand(jirix *jiri.X, env map[string]string) string {
+	if jirix.Root == "" || env["GIT_SSH_COMMAND"] != "" || env["GIT_SSH"] != "" {
+		return ""
+	}
+	var opts []string
+	knownHosts := jirix.KnownHostsFile()
+	if _, err := os.Stat(knownHosts); err == nil {
+		// The files are quoted for ssh, which splits the value on spaces,
+		// and the option for the shell running the command.
+		files := sshConfigQuote(filepath.ToSlash(knownHosts)) + " ~/.ssh/known_hosts ~/.ssh/known_hosts2"
+		opts = append(opts, "-o "+shellQuote("UserKnownHostsFile="+files))
+	}
+	// The ssh of Windows cannot multiplex connections. The control sockets
+	// go in ~/.ssh, as unix socket paths are short and the directory is
+	// private. %C is a hash of the host, port and user of the connection.
+	if jirix.ReuseConnections && runtime.GOOS != "windows" {
+		opts = append(opts, "-o ControlMaster=auto", "-o ControlPath='~/.ssh/jiri-%C'", "-o ControlPersist="+sshControlPersist)
+	}
+	if len(opts) == 0 {
+		return ""
+	}
+	return "ssh " + strings.Join(opts, " ")
+}
+
+// sshConfigQuote quotes s as an argument of an ssh_config option.
+func sshConfigQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// shellQuote quotes s as a single word for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// sshCommandConfigs caches whether core.sshCommand is set in the git config
+// of the directories git runs in, see hasSSHCommandConfig.
+var sshCommandConfigs sync.Map
+
+// hasSSHCommandConfig returns whether core.sshCommand is set in the git
+// config of dir, as GIT_SSH_COMMAND would override the ssh command of the
+// user.
+func hasSSHCommandConfig(dir string, env []string) bool {
+	if set, ok := sshCommandConfigs.Load(dir); ok {
+		return set.(bool)
+	}
+	command := exec.Command("git", "config", "--get", "core.sshCommand")
+	command.Dir = dir
+	command.Env = env
+	out, err := command.Output()
+	set := err == nil && strings.TrimSpace(string(out)) != ""
+	sshCommandConfigs.Store(dir, set)
+	return set
+}
+
 // GitConfigEnvVars converts a git config key-value mapping into corresponding
 // environment variables to pass to git.
 //
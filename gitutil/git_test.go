@@ -0,0 +1,170 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gitutil
+
+import (
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/cmdline"
+	"go.fuchsia.dev/jiri/color"
+	"go.fuchsia.dev/jiri/log"
+	"go.fuchsia.dev/jiri/tool"
+)
+
+func TestSSHCommand(t *testing.T) {
+	jirix := &jiri.X{Root: t.TempDir()}
+	if got := sshCommand(jirix, nil); got != "" {
+		t.Errorf("got ssh command %q, want none", got)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(jirix.KnownHostsFile()), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(jirix.KnownHostsFile(), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	got := sshCommand(jirix, nil)
+	if !strings.Contains(got, "UserKnownHostsFile=") || strings.Contains(got, "ControlMaster") {
+		t.Errorf("unexpected ssh command %q", got)
+	}
+
+	jirix.ReuseConnections = true
+	got = sshCommand(jirix, nil)
+	if runtime.GOOS != "windows" && !strings.Contains(got, "-o ControlMaster=auto -o ControlPath='~/.ssh/jiri-%C' -o ControlPersist=") {
+		t.Errorf("ssh command %q does not share connections", got)
+	}
+	if !strings.Contains(got, "UserKnownHostsFile=") {
+		t.Errorf("ssh command %q does not use the known_hosts file of jiri", got)
+	}
+
+	// ssh reads the known_hosts file of a root whose path has spaces and
+	// quotes.
+	if _, err := exec.LookPath("ssh"); err == nil && runtime.GOOS != "windows" {
+		quoted := &jiri.X{Root: filepath.Join(t.TempDir(), `it's a "root"`)}
+		if err := os.MkdirAll(filepath.Dir(quoted.KnownHostsFile()), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(quoted.KnownHostsFile(), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		// ssh -G prints the configuration without connecting.
+		out, err := exec.Command("sh", "-c", sshCommand(quoted, nil)+" -G example.com").CombinedOutput()
+		if err != nil {
+			t.Fatalf("ssh -G: %v: %s", err, out)
+		}
+		if !strings.Contains(string(out), "userknownhostsfile "+quoted.KnownHostsFile()+" ") {
+			t.Errorf("ssh does not use %s:\n%s", quoted.KnownHostsFile(), out)
+		}
+	}
+
+	// The ssh command of the user is kept.
+	if got := sshCommand(jirix, map[string]string{"GIT_SSH_COMMAND": "ssh -v"}); got != "" {
+		t.Errorf("got ssh command %q, want none", got)
+	}
+	repo := t.TempDir()
+	if out, err := exec.Command("git", "init", repo).CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v: %s", err, out)
+	}
+	if hasSSHCommandConfig(repo, os.Environ()) {
+		t.Errorf("core.sshCommand is reported as set in a new repository")
+	}
+	other := t.TempDir()
+	if out, err := exec.Command("git", "init", other).CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v: %s", err, out)
+	}
+	if out, err := exec.Command("git", "-C", other, "config", "core.sshCommand", "ssh -v").CombinedOutput(); err != nil {
+		t.Fatalf("git config: %v: %s", err, out)
+	}
+	if !hasSSHCommandConfig(other, os.Environ()) {
+		t.Errorf("core.sshCommand is not reported as set")
+	}
+}
+
+func TestCredential(t *testing.T) {
+	env := cmdline.EnvFromOS()
+	jirix := &jiri.X{
+		Context: tool.NewContextFromEnv(env),
+		Root:    t.TempDir(),
+		Logger:  log.NewLogger(log.InfoLevel, color.NewColor(color.ColorNever), false, 0, time.Second, io.Discard, io.Discard),
+	}
+	// A helper that fills the credential of example.com, and records the
+	// credentials approved and rejected.
+	dir := t.TempDir()
+	record := filepath.Join(dir, "record")
+	helper := `!f() { if [ "$1" = get ]; then cat >/dev/null; echo username=user; echo password=secret; else grep -E "^(username|password)=" | sed "s/^/$1 /" >>` + record + `; fi; }; f`
+	config := filepath.Join(dir, "gitconfig")
+	if err := os.WriteFile(config, []byte("[credential]\n\thelper = "+strconv.Quote(helper)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	jirix.Env()["GIT_CONFIG_GLOBAL"] = config
+	jirix.Env()["GIT_TERMINAL_PROMPT"] = "0"
+
+	u, err := url.Parse("https://example.com/snapshots/snapshot.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := New(jirix, RootDirOpt(dir))
+	c, err := g.CredentialFill(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Credential{Username: "user", Password: "secret"}); c != want {
+		t.Errorf("got credential %+v, want %+v", c, want)
+	}
+	if err := g.CredentialApprove(u, c); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.CredentialReject(u, Credential{Username: "user", Password: "wrong"}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "store username=user\nstore password=secret\nerase username=user\nerase password=wrong\n"
+	if string(data) != want {
+		t.Errorf("got helper calls:\n%s\nwant:\n%s", data, want)
+	}
+}
+
+func TestURLRewrites(t *testing.T) {
+	env := cmdline.EnvFromOS()
+	jirix := &jiri.X{
+		Context: tool.NewContextFromEnv(env),
+		Root:    t.TempDir(),
+		Logger:  log.NewLogger(log.InfoLevel, color.NewColor(color.ColorNever), false, 0, time.Second, io.Discard, io.Discard),
+	}
+	dir := t.TempDir()
+	if err := New(jirix).Init(filepath.Join(dir, "remote")); err != nil {
+		t.Fatal(err)
+	}
+	// Only the rule of the project points to the remote.
+	jirix.URLRewrites = []jiri.URLRewrite{
+		{Base: filepath.Join(dir, "missing") + "/", InsteadOf: "mirror://"},
+		{Base: dir + "/", InsteadOf: "mirror://", Project: "project"},
+	}
+	dest := filepath.Join(dir, "clone")
+	if err := New(jirix, ProjectOpt("other")).Clone("mirror://remote", dest); err == nil {
+		t.Errorf("cloning with the rules of another project succeeded")
+	}
+	if err := New(jirix, ProjectOpt("project")).Clone("mirror://remote", dest); err != nil {
+		t.Fatal(err)
+	}
+	// The repository keeps the remote it was cloned from, not the rewritten
+	// one.
+	if got, err := New(jirix, RootDirOpt(dest)).ConfigGetKey("remote.origin.url"); err != nil || got != "mirror://remote" {
+		t.Errorf("remote.origin.url = %q, %v, want %q", got, err, "mirror://remote")
+	}
+}
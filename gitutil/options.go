@@ -98,6 +98,12 @@ type DepthOpt int
 
 func (DepthOpt) fetchOpt() {}
 
+// ShallowSinceOpt limits the history to commits more recent than a date.
+type ShallowSinceOpt string
+
+func (ShallowSinceOpt) fetchOpt() {}
+func (ShallowSinceOpt) cloneOpt() {}
+
 type UpdateShallowOpt bool
 
 func (UpdateShallowOpt) fetchOpt() {}
@@ -128,6 +134,22 @@ type OmitBlobsOpt bool
 
 func (OmitBlobsOpt) cloneOpt() {}
 
+// BundleURIOpt is a bundle to download and unbundle before fetching the
+// remaining objects from the remote.
+type BundleURIOpt string
+
+func (BundleURIOpt) cloneOpt() {}
+
+// OriginOpt is the name given to the remote cloned from, instead of "origin".
+type OriginOpt string
+
+func (OriginOpt) cloneOpt() {}
+
+// ServerBundlesOpt makes clone use the bundles advertised by the server.
+type ServerBundlesOpt bool
+
+func (ServerBundlesOpt) cloneOpt() {}
+
 type RebaseMerges bool
 
 func (RebaseMerges) rebaseOpt() {}
@@ -136,6 +158,12 @@ type UpdateHeadOkOpt bool
 
 func (UpdateHeadOkOpt) fetchOpt() {}
 
+// RefspecsOpt are refspecs fetched in addition to the one given to
+// FetchRefspec.
+type RefspecsOpt []string
+
+func (RefspecsOpt) fetchOpt() {}
+
 type InitOpt bool
 
 type JobsOpt uint
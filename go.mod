@@ -7,7 +7,6 @@ require (
 	github.com/google/subcommands v1.2.0
 	golang.org/x/net v0.0.0-20190909003024-a7b16738d86b
 	golang.org/x/sync v0.0.0-20190423024810-112230192c58
+	golang.org/x/sys v0.6.0
 	golang.org/x/term v0.6.0
 )
-
-require golang.org/x/sys v0.6.0 // indirect
@@ -0,0 +1,90 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jiri
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// HostLimit limits the network operations sent to a remote host, on top of
+// the global limit of X.Jobs.
+type HostLimit struct {
+	// Host is a host name, e.g. "fuchsia.googlesource.com", or a pattern
+	// like "*.googlesource.com" matching all of its subdomains.
+	Host string `xml:"host,attr"`
+	// Jobs is the maximum number of concurrent operations on the host, zero
+	// for no limit other than X.Jobs.
+	Jobs uint `xml:"jobs,attr,omitempty"`
+	// Bandwidth caps the average download rate from the host, in bytes per
+	// second with an optional K, M or G suffix, see ParseBandwidth. Empty
+	// means no cap.
+	Bandwidth string `xml:"bandwidth,attr,omitempty"`
+}
+
+// HostLimitFor returns the limit of host, taken from the entry of
+// X.HostLimits naming it, or else from the longest matching pattern. The zero
+// HostLimit, which does not limit anything, is returned for local paths and
+// for hosts without an entry.
+func (jirix *X) HostLimitFor(host string) HostLimit {
+	if host == "" {
+		return HostLimit{}
+	}
+	var best *HostLimit
+	for i, l := range jirix.HostLimits {
+		if l.Host == host {
+			return l
+		}
+		if suffix, ok := strings.CutPrefix(l.Host, "*"); ok && strings.HasSuffix(host, suffix) {
+			if best == nil || len(l.Host) > len(best.Host) {
+				best = &jirix.HostLimits[i]
+			}
+		}
+	}
+	if best == nil {
+		return HostLimit{}
+	}
+	return *best
+}
+
+// ParseBandwidth parses a rate in bytes per second, e.g. "500K" or "10M".
+// Suffixes are powers of 1024.
+func ParseBandwidth(s string) (int64, error) {
+	multiplier := int64(1)
+	num := s
+	switch {
+	case strings.HasSuffix(s, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		multiplier = 1 << 30
+	}
+	if multiplier != 1 {
+		num = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("bad bandwidth %q, should be a positive number of bytes per second with an optional K, M or G suffix", s)
+	}
+	return n * multiplier, nil
+}
+
+// RemoteHost returns the host of remote, or "" for local paths.
+func RemoteHost(remote string) string {
+	if u, err := url.Parse(remote); err == nil && u.Scheme != "" {
+		return u.Host
+	}
+	// scp-like syntax, e.g. "git@host:foo/bar".
+	if host, _, ok := strings.Cut(remote, ":"); ok && !strings.Contains(host, "/") {
+		if _, h, ok := strings.Cut(host, "@"); ok {
+			return h
+		}
+		return host
+	}
+	return ""
+}
@@ -0,0 +1,79 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jiri
+
+import "testing"
+
+func TestRemoteHost(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		remote, want string
+	}{
+		{"https://fuchsia.googlesource.com/fuchsia", "fuchsia.googlesource.com"},
+		{"sso://fuchsia/integration", "fuchsia"},
+		{"git@github.com:org/repo.git", "github.com"},
+		{"/srv/git/repo", ""},
+	}
+	for _, test := range tests {
+		if got := RemoteHost(test.remote); got != test.want {
+			t.Errorf("RemoteHost(%q) = %q, want %q", test.remote, got, test.want)
+		}
+	}
+}
+
+func TestHostLimitFor(t *testing.T) {
+	t.Parallel()
+	x := &X{
+		HostLimits: []HostLimit{
+			{Host: "*.googlesource.com", Jobs: 4},
+			{Host: "*-review.googlesource.com", Jobs: 2},
+			{Host: "fuchsia.googlesource.com", Jobs: 8, Bandwidth: "10M"},
+		},
+	}
+	tests := []struct {
+		host string
+		want HostLimit
+	}{
+		{"fuchsia.googlesource.com", x.HostLimits[2]},
+		{"chromium.googlesource.com", x.HostLimits[0]},
+		{"fuchsia-review.googlesource.com", x.HostLimits[1]},
+		{"github.com", HostLimit{}},
+		{"", HostLimit{}},
+	}
+	for _, test := range tests {
+		if got := x.HostLimitFor(test.host); got != test.want {
+			t.Errorf("HostLimitFor(%q) = %+v, want %+v", test.host, got, test.want)
+		}
+	}
+}
+
+func TestParseBandwidth(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		s    string
+		want int64
+	}{
+		{"100", 100},
+		{"500K", 500 << 10},
+		{"10M", 10 << 20},
+		{"1G", 1 << 30},
+		{"", 0},
+		{"0", 0},
+		{"-1K", 0},
+		{"10MB", 0},
+	}
+	for _, test := range tests {
+		got, err := ParseBandwidth(test.s)
+		if test.want == 0 {
+			if err == nil {
+				t.Errorf("ParseBandwidth(%q) = %d, want an error", test.s, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("ParseBandwidth(%q) = %d, %v, want %d", test.s, got, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jiritest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/gitutil"
+	"go.fuchsia.dev/jiri/project"
+)
+
+// FakeCommit is a commit of a FakeRepo.
+type FakeCommit struct {
+	// Parent is the revision of the parent commit, or "" for a root commit.
+	Parent string
+	// Files maps file paths to their contents at this commit.
+	Files map[string]string
+}
+
+// FakeRepo is the state of an in-memory git repository. Branch names are
+// short names, e.g. "main" for a local branch and "origin/main" for a remote
+// branch.
+type FakeRepo struct {
+	Commits        map[string]FakeCommit
+	Branches       map[string]string
+	Tracking       map[string]string
+	RemoteBranches map[string]string
+	Remotes        map[string]string
+	// Head is the checked out branch, or "" if HEAD is detached at
+	// HeadRevision.
+	Head         string
+	HeadRevision string
+	// Uncommitted lists the files with uncommitted changes and Untracked
+	// the untracked ones.
+	Uncommitted []string
+	Untracked   []string
+}
+
+// NewFakeRepo returns an empty FakeRepo.
+func NewFakeRepo() *FakeRepo {
+	return &FakeRepo{
+		Commits:        make(map[string]FakeCommit),
+		Branches:       make(map[string]string),
+		Tracking:       make(map[string]string),
+		RemoteBranches: make(map[string]string),
+		Remotes:        make(map[string]string),
+	}
+}
+
+// Commit adds a commit with files on top of the checked out branch, or of the
+// detached HEAD, and returns its revision. Revisions are derived from the
+// number of commits, so they are stable across runs.
+func (r *FakeRepo) Commit(files map[string]string) string {
+	parent := r.head()
+	rev := fmt.Sprintf("%040x", len(r.Commits)+1)
+	tree := make(map[string]string)
+	if p, ok := r.Commits[parent]; ok {
+		for k, v := range p.Files {
+			tree[k] = v
+		}
+	}
+	for k, v := range files {
+		tree[k] = v
+	}
+	r.Commits[rev] = FakeCommit{Parent: parent, Files: tree}
+	if r.Head != "" {
+		r.Branches[r.Head] = rev
+	} else {
+		r.HeadRevision = rev
+	}
+	return rev
+}
+
+func (r *FakeRepo) head() string {
+	if r.Head != "" {
+		return r.Branches[r.Head]
+	}
+	return r.HeadRevision
+}
+
+// resolve returns the revision ref points to.
+func (r *FakeRepo) resolve(ref string) (string, error) {
+	if ref == "HEAD" {
+		if rev := r.head(); rev != "" {
+			return rev, nil
+		}
+		return "", fmt.Errorf("HEAD does not point to a commit")
+	}
+	if rev, ok := r.Branches[strings.TrimPrefix(ref, "refs/heads/")]; ok {
+		return rev, nil
+	}
+	if rev, ok := r.RemoteBranches[strings.TrimPrefix(ref, "refs/remotes/")]; ok {
+		return rev, nil
+	}
+	if _, ok := r.Commits[ref]; ok {
+		return ref, nil
+	}
+	return "", fmt.Errorf("unknown revision %q", ref)
+}
+
+// FakeGitRepos is a set of in-memory repositories, keyed by their directory
+// or, for remotes, their URL. It stands in for git in tests of the project
+// package, see Factory.
+type FakeGitRepos struct {
+	mu    sync.Mutex
+	repos map[string]*FakeRepo
+}
+
+// NewFakeGitRepos returns an empty FakeGitRepos.
+func NewFakeGitRepos() *FakeGitRepos {
+	return &FakeGitRepos{repos: make(map[string]*FakeRepo)}
+}
+
+// Add adds repo at dir, which is a directory or a remote URL.
+func (f *FakeGitRepos) Add(dir string, repo *FakeRepo) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.repos[dir] = repo
+}
+
+// Repo returns the repository at dir, or nil if there is none.
+func (f *FakeGitRepos) Repo(dir string) *FakeRepo {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.repos[dir]
+}
+
+// Factory returns a project.GitFactory creating a FakeGit for the repository
+// of the gitutil.RootDirOpt option, or of the working directory of jirix. Set
+// it as the GitFactory of a jiri.X to make the project package use it.
+func (f *FakeGitRepos) Factory() project.GitFactory {
+	return func(jirix *jiri.X, opts ...gitutil.GitOpt) project.Git {
+		dir := jirix.Cwd
+		for _, opt := range opts {
+			if d, ok := opt.(gitutil.RootDirOpt); ok {
+				dir = string(d)
+			}
+		}
+		return &FakeGit{repos: f, dir: dir}
+	}
+}
+
+// FakeGit implements project.Git on a repository of a FakeGitRepos. It only
+// models what the tests using it need: cloning, fetching, fast-forward merges
+// and reading branches, status and files. Options are ignored and the other
+// operations panic.
+type FakeGit struct {
+	project.Git
+	repos *FakeGitRepos
+	dir   string
+}
+
+// repo calls fn with the repository of g while holding the lock.
+func (g *FakeGit) repo(fn func(r *FakeRepo) error) error {
+	g.repos.mu.Lock()
+	defer g.repos.mu.Unlock()
+	r, ok := g.repos.repos[g.dir]
+	if !ok {
+		return fmt.Errorf("%s: not a git repository", g.dir)
+	}
+	return fn(r)
+}
+
+func (g *FakeGit) Clone(repo, path string, opts ...gitutil.CloneOpt) error {
+	g.repos.mu.Lock()
+	defer g.repos.mu.Unlock()
+	src, ok := g.repos.repos[repo]
+	if !ok {
+		return fmt.Errorf("repository %s not found", repo)
+	}
+	dst := NewFakeRepo()
+	for rev, c := range src.Commits {
+		dst.Commits[rev] = c
+	}
+	for b, rev := range src.Branches {
+		dst.RemoteBranches["origin/"+b] = rev
+	}
+	dst.Remotes["origin"] = repo
+	if src.Head != "" {
+		dst.Head = src.Head
+		dst.Branches[src.Head] = src.Branches[src.Head]
+		dst.Tracking[src.Head] = "origin/" + src.Head
+	} else {
+		dst.HeadRevision = src.HeadRevision
+	}
+	g.repos.repos[path] = dst
+	return nil
+}
+
+func (g *FakeGit) CurrentRevision() (string, error) {
+	return g.CurrentRevisionForRef("HEAD")
+}
+
+func (g *FakeGit) CurrentRevisionForRef(ref string) (string, error) {
+	var rev string
+	err := g.repo(func(r *FakeRepo) error {
+		var err error
+		rev, err = r.resolve(ref)
+		return err
+	})
+	return rev, err
+}
+
+// Fetch fetches all branches of remote.
+func (g *FakeGit) Fetch(remote string, opts ...gitutil.FetchOpt) error {
+	g.repos.mu.Lock()
+	defer g.repos.mu.Unlock()
+	r, ok := g.repos.repos[g.dir]
+	if !ok {
+		return fmt.Errorf("%s: not a git repository", g.dir)
+	}
+	url, ok := r.Remotes[remote]
+	if !ok {
+		url = remote
+	}
+	src, ok := g.repos.repos[url]
+	if !ok {
+		return fmt.Errorf("repository %s not found", url)
+	}
+	for rev, c := range src.Commits {
+		r.Commits[rev] = c
+	}
+	for b, rev := range src.Branches {
+		r.RemoteBranches[remote+"/"+b] = rev
+	}
+	return nil
+}
+
+func (g *FakeGit) GetAllBranchesInfo() ([]gitutil.Branch, error) {
+	var branches []gitutil.Branch
+	err := g.repo(func(r *FakeRepo) error {
+		names := make([]string, 0, len(r.Branches))
+		for name := range r.Branches {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			b := gitutil.Branch{
+				Reference: &gitutil.Reference{
+					Name:     name,
+					Revision: r.Branches[name],
+					IsHead:   name == r.Head,
+				},
+			}
+			if upstream, ok := r.Tracking[name]; ok {
+				b.Tracking = &gitutil.Reference{
+					Name:     upstream,
+					Revision: r.RemoteBranches[upstream],
+				}
+			}
+			branches = append(branches, b)
+		}
+		return nil
+	})
+	return branches, err
+}
+
+// Merge fast-forwards the checked out branch, or the detached HEAD, to
+// branch.
+func (g *FakeGit) Merge(branch string, opts ...gitutil.MergeOpt) error {
+	return g.repo(func(r *FakeRepo) error {
+		rev, err := r.resolve(branch)
+		if err != nil {
+			return err
+		}
+		if r.Head == "" {
+			r.HeadRevision = rev
+		} else {
+			r.Branches[r.Head] = rev
+		}
+		return nil
+	})
+}
+
+func (g *FakeGit) Show(ref, file string) (string, error) {
+	var content string
+	err := g.repo(func(r *FakeRepo) error {
+		rev, err := r.resolve(ref)
+		if err != nil {
+			return err
+		}
+		c, ok := r.Commits[rev].Files[file]
+		if !ok {
+			return fmt.Errorf("path %s does not exist in %s", file, ref)
+		}
+		content = c
+		return nil
+	})
+	return content, err
+}
+
+func (g *FakeGit) Status() (*gitutil.WorkingTreeStatus, error) {
+	status := &gitutil.WorkingTreeStatus{}
+	err := g.repo(func(r *FakeRepo) error {
+		status.Changed = append(status.Changed, r.Uncommitted...)
+		status.Untracked = append(status.Untracked, r.Untracked...)
+		return nil
+	})
+	return status, err
+}
@@ -0,0 +1,171 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.fuchsia.dev/jiri/color"
+)
+
+// Record is a single log message.
+type Record struct {
+	Time      time.Time
+	Level     LogLevel
+	Component string
+	Message   string
+}
+
+// Formatter turns records into the lines written to the log.
+type Formatter interface {
+	Format(r Record) string
+}
+
+// String returns the name of the level as used by structured formats.
+func (l LogLevel) String() string {
+	switch l {
+	case ErrorLevel:
+		return "error"
+	case WarningLevel:
+		return "warning"
+	case InfoLevel:
+		return "info"
+	case DebugLevel:
+		return "debug"
+	case TraceLevel:
+		return "trace"
+	}
+	return fmt.Sprintf("level%d", int(l))
+}
+
+var formatters = map[string]func(color.Color) Formatter{
+	"text":   func(c color.Color) Formatter { return TextFormatter{Color: c} },
+	"json":   func(color.Color) Formatter { return JSONFormatter{} },
+	"logfmt": func(color.Color) Formatter { return LogfmtFormatter{} },
+	"github": func(color.Color) Formatter { return GitHubFormatter{} },
+}
+
+// Formats returns the names of the formats supported by NewFormatter.
+func Formats() []string {
+	var names []string
+	for name := range formatters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewFormatter returns the formatter for the named format. Only the text
+// format uses c.
+func NewFormatter(name string, c color.Color) (Formatter, error) {
+	f, ok := formatters[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown log format %q, should be one of %s", name, strings.Join(Formats(), ", "))
+	}
+	return f(c), nil
+}
+
+// TextFormatter writes human readable, optionally colored, lines. Components
+// are not shown.
+type TextFormatter struct {
+	Color color.Color
+}
+
+func (f TextFormatter) Format(r Record) string {
+	prefix := ""
+	switch r.Level {
+	case ErrorLevel:
+		// Errors are not time stamped.
+		return f.Color.Red("ERROR: ") + r.Message
+	case WarningLevel:
+		prefix = f.Color.Yellow("WARN: ")
+	case DebugLevel:
+		prefix = f.Color.Cyan("DEBUG: ")
+	case TraceLevel:
+		prefix = f.Color.Blue("TRACE: ")
+	}
+	return fmt.Sprintf("[%s] %s%s", r.Time.Format("15:04:05.000"), prefix, r.Message)
+}
+
+// message returns the message of r without the trailing blank lines used to
+// separate messages in the text format.
+func message(r Record) string {
+	return strings.TrimRight(r.Message, "\n")
+}
+
+// JSONFormatter writes one JSON object per line.
+type JSONFormatter struct{}
+
+func (JSONFormatter) Format(r Record) string {
+	data, err := json.Marshal(struct {
+		Time      string `json:"time"`
+		Level     string `json:"level"`
+		Component string `json:"component,omitempty"`
+		Message   string `json:"msg"`
+	}{r.Time.Format(time.RFC3339Nano), r.Level.String(), r.Component, message(r)})
+	if err != nil {
+		// Marshaling strings cannot fail.
+		panic(err)
+	}
+	return string(data)
+}
+
+// LogfmtFormatter writes lines of key=value pairs.
+type LogfmtFormatter struct{}
+
+func (LogfmtFormatter) Format(r Record) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "time=%s level=%s", r.Time.Format(time.RFC3339Nano), r.Level)
+	if r.Component != "" {
+		fmt.Fprintf(&b, " component=%s", logfmtValue(r.Component))
+	}
+	fmt.Fprintf(&b, " msg=%s", logfmtValue(message(r)))
+	return b.String()
+}
+
+func logfmtValue(v string) string {
+	if v == "" || strings.ContainsAny(v, " =\"\t\n\r") {
+		return strconv.Quote(v)
+	}
+	return v
+}
+
+// GitHubFormatter writes errors, warnings and debug messages as GitHub
+// Actions workflow commands, so that they are shown as annotations, and
+// other messages as plain text.
+type GitHubFormatter struct{}
+
+func (GitHubFormatter) Format(r Record) string {
+	command := ""
+	switch r.Level {
+	case ErrorLevel:
+		command = "error"
+	case WarningLevel:
+		command = "warning"
+	case DebugLevel, TraceLevel:
+		command = "debug"
+	default:
+		return message(r)
+	}
+	params := ""
+	if r.Component != "" {
+		params = " title=" + githubEscape(r.Component, true)
+	}
+	return fmt.Sprintf("::%s%s::%s", command, params, githubEscape(message(r), false))
+}
+
+// githubEscape escapes v for use in a workflow command message, or parameter.
+func githubEscape(v string, param bool) string {
+	v = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(v)
+	if param {
+		v = strings.NewReplacer(":", "%3A", ",", "%2C").Replace(v)
+	}
+	return v
+}
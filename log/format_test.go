@@ -0,0 +1,74 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"go.fuchsia.dev/jiri/color"
+)
+
+func TestFormatters(t *testing.T) {
+	r := Record{
+		Time:      time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Level:     ErrorLevel,
+		Component: "gitutil",
+		Message:   "fetch failed: 100% \"bad\"\nretrying\n\n",
+	}
+	tests := []struct {
+		format, want string
+	}{
+		{"text", "ERROR: fetch failed: 100% \"bad\"\nretrying\n\n"},
+		{"json", `{"time":"2024-01-02T03:04:05Z","level":"error","component":"gitutil","msg":"fetch failed: 100% \"bad\"\nretrying"}`},
+		{"logfmt", `time=2024-01-02T03:04:05Z level=error component=gitutil msg="fetch failed: 100% \"bad\"\nretrying"`},
+		{"github", "::error title=gitutil::fetch failed: 100%25 \"bad\"%0Aretrying"},
+	}
+	for _, test := range tests {
+		f, err := NewFormatter(test.format, color.NewColor(color.ColorNever))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := f.Format(r); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.format, got, test.want)
+		}
+	}
+	if _, err := NewFormatter("xml", nil); err == nil {
+		t.Errorf("unknown formats should fail")
+	}
+}
+
+func TestComponentLogger(t *testing.T) {
+	var out, errOut bytes.Buffer
+	logger := NewLogger(InfoLevel, color.NewColor(color.ColorNever), false, 0, time.Second, &out, &errOut)
+	logger.SetFormatter(JSONFormatter{})
+	logger.Component("cipd").Infof("fetched %d packages\n\n", 2)
+	logger.Component("hooks").Errorf("hook failed")
+	logger.Component("cipd").Debugf("not shown")
+
+	var record struct {
+		Level     string `json:"level"`
+		Component string `json:"component"`
+		Message   string `json:"msg"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &record); err != nil {
+		t.Fatalf("bad output %q: %v", out.String(), err)
+	}
+	if record.Level != "info" || record.Component != "cipd" || record.Message != "fetched 2 packages" {
+		t.Errorf("got %+v", record)
+	}
+	if err := json.Unmarshal(errOut.Bytes(), &record); err != nil {
+		t.Fatalf("bad error output %q: %v", errOut.String(), err)
+	}
+	if record.Level != "error" || record.Component != "hooks" {
+		t.Errorf("got %+v", record)
+	}
+	if !strings.Contains(logger.GetLogBuffer().String(), "not shown") {
+		t.Errorf("disabled levels should still be kept in the log buffer")
+	}
+}
@@ -50,6 +50,7 @@ type Logger struct {
 	goErrorLogger        *glog.Logger
 	goBufferLogger       *glog.Logger
 	color                color.Color
+	formatter            Formatter
 	progressLines        int
 	progressWindowSize   uint
 	enableProgress       uint32
@@ -95,6 +96,7 @@ func NewLogger(loggerLevel LogLevel, color color.Color, enableProgress bool, pro
 		goErrorLogger:        glog.New(errWriter, "", 0),
 		goBufferLogger:       glog.New(&logBuffer, "", 0),
 		color:                color,
+		formatter:            TextFormatter{Color: color},
 		progressLines:        0,
 		enableProgress:       0,
 		progressWindowSize:   progressWindowSize,
@@ -115,6 +117,14 @@ func NewLogger(loggerLevel LogLevel, color color.Color, enableProgress bool, pro
 	return l
 }
 
+// SetFormatter makes l format messages with f. Structured formats should not be
+// used with progress messages, see DisableProgress.
+func (l *Logger) SetFormatter(f Formatter) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.formatter = f
+}
+
 func (l *Logger) IsProgressEnabled() bool {
 	return atomic.LoadUint32(&l.enableProgress) == 1
 }
@@ -206,19 +216,28 @@ func (l *Logger) clearProgress() {
 	l.progressLines = 0
 }
 
-func (l *Logger) log(prefix, format string, a ...any) {
+// log writes a message of the given level, or only adds it to the log buffer
+// if the level is not enabled.
+func (l *Logger) log(level LogLevel, component, format string, a ...any) {
+	r := Record{
+		Time:      time.Now(),
+		Level:     level,
+		Component: component,
+		Message:   fmt.Sprintf(format, a...),
+	}
 	l.lock.Lock()
-	stamp := time.Now().Format("15:04:05.000")
 	defer l.lock.Unlock()
+	line := l.formatter.Format(r)
+	if l.LoggerLevel < level {
+		l.goBufferLogger.Print(line)
+		return
+	}
 	l.clearProgress()
-	l.goLogger.Printf("[%s] %s%s", stamp, prefix, fmt.Sprintf(format, a...))
-}
-
-func (l *Logger) logToBufferOnly(prefix, format string, a ...any) {
-	l.lock.Lock()
-	stamp := time.Now().Format("15:04:05.000")
-	defer l.lock.Unlock()
-	l.goBufferLogger.Printf("[%s] %s%s", stamp, prefix, fmt.Sprintf(format, a...))
+	if level == ErrorLevel {
+		l.goErrorLogger.Print(line)
+	} else {
+		l.goLogger.Print(line)
+	}
 }
 
 func (l *Logger) Logf(loglevel LogLevel, format string, a ...any) {
@@ -239,46 +258,57 @@ func (l *Logger) Logf(loglevel LogLevel, format string, a ...any) {
 }
 
 func (l *Logger) Infof(format string, a ...any) {
-	if l.LoggerLevel >= InfoLevel {
-		l.log("", format, a...)
-	} else {
-		l.logToBufferOnly("", format, a...)
-	}
+	l.log(InfoLevel, "", format, a...)
 }
 
 func (l *Logger) Debugf(format string, a ...any) {
-	if l.LoggerLevel >= DebugLevel {
-		l.log(l.color.Cyan("DEBUG: "), format, a...)
-	} else {
-		l.logToBufferOnly(l.color.Cyan("DEBUG: "), format, a...)
-	}
+	l.log(DebugLevel, "", format, a...)
 }
 
 func (l *Logger) Tracef(format string, a ...any) {
-	if l.LoggerLevel >= TraceLevel {
-		l.log(l.color.Blue("TRACE: "), format, a...)
-	} else {
-		l.logToBufferOnly(l.color.Blue("TRACE: "), format, a...)
-	}
+	l.log(TraceLevel, "", format, a...)
 }
 
 func (l *Logger) Warningf(format string, a ...any) {
-	if l.LoggerLevel >= WarningLevel {
-		l.log(l.color.Yellow("WARN: "), format, a...)
-	} else {
-		l.logToBufferOnly(l.color.Yellow("WARN: "), format, a...)
-	}
+	l.log(WarningLevel, "", format, a...)
 }
 
 func (l *Logger) Errorf(format string, a ...any) {
-	if l.LoggerLevel >= ErrorLevel {
-		l.lock.Lock()
-		defer l.lock.Unlock()
-		l.clearProgress()
-		l.goErrorLogger.Printf("%s%s", l.color.Red("ERROR: "), fmt.Sprintf(format, a...))
-	} else {
-		l.logToBufferOnly(l.color.Red("ERROR: "), format, a...)
-	}
+	l.log(ErrorLevel, "", format, a...)
+}
+
+// Component returns a logger for messages from the named part of jiri, e.g.
+// "gitutil" or "cipd". Structured formats record the component in a field of
+// its own, so that messages can be triaged by their origin.
+func (l *Logger) Component(name string) ComponentLogger {
+	return ComponentLogger{l: l, component: name}
+}
+
+// ComponentLogger logs messages on behalf of a component, see
+// Logger.Component.
+type ComponentLogger struct {
+	l         *Logger
+	component string
+}
+
+func (c ComponentLogger) Infof(format string, a ...any) {
+	c.l.log(InfoLevel, c.component, format, a...)
+}
+
+func (c ComponentLogger) Debugf(format string, a ...any) {
+	c.l.log(DebugLevel, c.component, format, a...)
+}
+
+func (c ComponentLogger) Tracef(format string, a ...any) {
+	c.l.log(TraceLevel, c.component, format, a...)
+}
+
+func (c ComponentLogger) Warningf(format string, a ...any) {
+	c.l.log(WarningLevel, c.component, format, a...)
+}
+
+func (c ComponentLogger) Errorf(format string, a ...any) {
+	c.l.log(ErrorLevel, c.component, format, a...)
 }
 
 // WriteLogToFile writes current logs into file.
@@ -0,0 +1,437 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package manifestedit edits the text of jiri manifests in place.
+//
+// Unlike project.Manifest.ToBytes, which serializes a manifest again and so
+// loses its comments, blank lines, attribute order and indentation, an
+// Editor patches the tokens of the original text: only the bytes of the
+// attributes and elements which are edited change.
+package manifestedit
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Selector selects elements by tag and attribute values.
+type Selector struct {
+	// Tag is the name of the elements, e.g. "project".
+	Tag string
+	// Attrs are the values the attributes of the elements must have.
+	Attrs map[string]string
+}
+
+// Elem returns the selector of the elements tag named name, e.g. the
+// <project> of a project.
+func Elem(tag, name string) Selector {
+	return Selector{Tag: tag, Attrs: map[string]string{"name": name}}
+}
+
+// With returns a copy of s which also requires attr to be value.
+func (s Selector) With(attr, value string) Selector {
+	attrs := map[string]string{attr: value}
+	for k, v := range s.Attrs {
+		if k != attr {
+			attrs[k] = v
+		}
+	}
+	return Selector{Tag: s.Tag, Attrs: attrs}
+}
+
+func (s Selector) String() string {
+	var attrs []string
+	for k, v := range s.Attrs {
+		attrs = append(attrs, fmt.Sprintf("%s=%q", k, v))
+	}
+	sort.Strings(attrs)
+	if len(attrs) == 0 {
+		return "<" + s.Tag + ">"
+	}
+	return "<" + s.Tag + " " + strings.Join(attrs, " ") + ">"
+}
+
+func (s Selector) matches(e *element) bool {
+	if e.tag != s.Tag {
+		return false
+	}
+	for k, v := range s.Attrs {
+		a := e.attr(k)
+		if a == nil || a.value != v {
+			return false
+		}
+	}
+	return true
+}
+
+// attribute is an attribute of a start tag, at text[start:end]. Its value,
+// unescaped, is at text[valueStart:valueEnd] and is quoted with quote.
+type attribute struct {
+	name                 string
+	value                string
+	start, end           int
+	valueStart, valueEnd int
+	quote                byte
+}
+
+// element is an element of the text, at text[start:end]. Its start tag ends
+// at startTagEnd, which is also end for empty elements, e.g. <project/>.
+type element struct {
+	tag         string
+	attrs       []attribute
+	start, end  int
+	startTagEnd int
+	selfClosing bool
+	depth       int
+}
+
+func (e *element) attr(name string) *attribute {
+	for i := range e.attrs {
+		if e.attrs[i].name == name {
+			return &e.attrs[i]
+		}
+	}
+	return nil
+}
+
+// Editor edits the text of a manifest. Edits are applied one after the
+// other; the text after each edit is always well-formed.
+type Editor struct {
+	text  string
+	elems []*element
+}
+
+// New returns an Editor of content, which must be well-formed XML.
+func New(content []byte) (*Editor, error) {
+	e := &Editor{}
+	if err := e.setText(string(content)); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Bytes returns the edited text.
+func (e *Editor) Bytes() []byte {
+	return []byte(e.text)
+}
+
+// String returns the edited text.
+func (e *Editor) String() string {
+	return e.text
+}
+
+// Count returns the number of elements selected by sel.
+func (e *Editor) Count(sel Selector) int {
+	n := 0
+	for _, elem := range e.elems {
+		if sel.matches(elem) {
+			n++
+		}
+	}
+	return n
+}
+
+// Attr returns the value of the attribute attr of the element selected by
+// sel, and whether it has such an attribute.
+func (e *Editor) Attr(sel Selector, attr string) (string, bool, error) {
+	elem, err := e.find(sel)
+	if err != nil {
+		return "", false, err
+	}
+	if a := elem.attr(attr); a != nil {
+		return a.value, true, nil
+	}
+	return "", false, nil
+}
+
+// SetAttr sets the attribute attr of the element selected by sel to value.
+// An existing attribute is changed in place. A new attribute is added after
+// the last one, on its own line aligned with it if the start tag spans
+// several lines, on the same line otherwise.
+func (e *Editor) SetAttr(sel Selector, attr, value string) error {
+	elem, err := e.find(sel)
+	if err != nil {
+		return err
+	}
+	if a := elem.attr(attr); a != nil {
+		if a.value == value {
+			return nil
+		}
+		return e.replace(a.valueStart, a.valueEnd, escapeAttr(value, a.quote))
+	}
+	pos := elem.start + 1 + len(elem.tag)
+	sep := " "
+	if n := len(elem.attrs); n > 0 {
+		last := elem.attrs[n-1]
+		pos = last.end
+		if strings.Contains(e.text[elem.start:last.start], "\n") {
+			sep = "\n" + leadingSpace(e.text, last.start)
+		}
+	}
+	return e.replace(pos, pos, fmt.Sprintf(`%s%s="%s"`, sep, attr, escapeAttr(value, '"')))
+}
+
+// RemoveAttr removes the attribute attr of the element selected by sel, with
+// the whitespace before it. It is not an error if there is no such attribute.
+func (e *Editor) RemoveAttr(sel Selector, attr string) error {
+	elem, err := e.find(sel)
+	if err != nil {
+		return err
+	}
+	a := elem.attr(attr)
+	if a == nil {
+		return nil
+	}
+	start := a.start
+	for start > 0 && isSpace(e.text[start-1]) {
+		start--
+	}
+	return e.replace(start, a.end, "")
+}
+
+// RemoveElement removes the element selected by sel. If it is alone on its
+// lines, these lines are removed, so that no blank line is left.
+func (e *Editor) RemoveElement(sel Selector) error {
+	elem, err := e.find(sel)
+	if err != nil {
+		return err
+	}
+	start, end := lineExtent(e.text, elem.start, elem.end)
+	return e.replace(start, end, "")
+}
+
+// AppendElement adds child, the text of an element, e.g.
+// `<project name="a" path="a" remote="https://a"/>`, as the last child of
+// the element selected by parent. The child is put on its own line, indented
+// like the other children of parent.
+func (e *Editor) AppendElement(parent Selector, child string) error {
+	if err := checkElement(child); err != nil {
+		return err
+	}
+	elem, err := e.find(parent)
+	if err != nil {
+		return err
+	}
+	parentIndent := lineIndent(e.text, elem.start)
+	indent := parentIndent + "  "
+	for _, c := range e.elems {
+		if c.depth == elem.depth+1 && c.start > elem.start && c.end <= elem.end {
+			indent = lineIndent(e.text, c.start)
+		}
+	}
+	if elem.selfClosing {
+		// <projects/> becomes <projects>child</projects>.
+		tagEnd := elem.end - len("/>")
+		for tagEnd > elem.start && isSpace(e.text[tagEnd-1]) {
+			tagEnd--
+		}
+		return e.replace(tagEnd, elem.end, ">\n"+indent+child+"\n"+parentIndent+"</"+elem.tag+">")
+	}
+	endTag := strings.LastIndex(e.text[:elem.end], "</")
+	lineStart := strings.LastIndexByte(e.text[:endTag], '\n') + 1
+	if strings.TrimSpace(e.text[lineStart:endTag]) == "" && lineStart > elem.startTagEnd {
+		return e.replace(lineStart, lineStart, indent+child+"\n")
+	}
+	return e.replace(endTag, endTag, "\n"+indent+child+"\n"+parentIndent)
+}
+
+// find returns the element selected by sel, which must select exactly one.
+func (e *Editor) find(sel Selector) (*element, error) {
+	var found *element
+	for _, elem := range e.elems {
+		if !sel.matches(elem) {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("more than one element %s", sel)
+		}
+		found = elem
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no element %s", sel)
+	}
+	return found, nil
+}
+
+// replace replaces text[start:end] with s, and parses the text again, so
+// that the offsets of the elements stay valid.
+func (e *Editor) replace(start, end int, s string) error {
+	return e.setText(e.text[:start] + s + e.text[end:])
+}
+
+func (e *Editor) setText(text string) error {
+	elems, err := parse(text)
+	if err != nil {
+		return err
+	}
+	e.text, e.elems = text, elems
+	return nil
+}
+
+// parse returns the elements of text, in document order.
+func parse(text string) ([]*element, error) {
+	var elems, stack []*element
+	d := xml.NewDecoder(strings.NewReader(text))
+	for {
+		off := int(d.InputOffset())
+		tok, err := d.Token()
+		if errors.Is(err, io.EOF) {
+			if len(elems) == 0 {
+				return nil, fmt.Errorf("no element")
+			}
+			return elems, nil
+		} else if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			end := int(d.InputOffset())
+			elem := &element{
+				tag:         t.Name.Local,
+				start:       off,
+				startTagEnd: end,
+				selfClosing: strings.HasSuffix(text[off:end], "/>"),
+				depth:       len(stack),
+			}
+			if elem.attrs, err = scanAttrs(text, off, end); err != nil {
+				return nil, err
+			}
+			elems = append(elems, elem)
+			stack = append(stack, elem)
+		case xml.EndElement:
+			elem := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			elem.end = int(d.InputOffset())
+		}
+	}
+}
+
+// scanAttrs returns the attributes of the start tag at text[start:end]. The
+// tag is known to be well-formed.
+func scanAttrs(text string, start, end int) ([]attribute, error) {
+	var attrs []attribute
+	i := start + 1
+	for i < end && !isSpace(text[i]) && text[i] != '/' && text[i] != '>' {
+		i++
+	}
+	for {
+		for i < end && isSpace(text[i]) {
+			i++
+		}
+		if i >= end || text[i] == '/' || text[i] == '>' {
+			return attrs, nil
+		}
+		a := attribute{start: i}
+		for i < end && text[i] != '=' && !isSpace(text[i]) {
+			i++
+		}
+		a.name = text[a.start:i]
+		if j := strings.IndexByte(a.name, ':'); j != -1 {
+			a.name = a.name[j+1:]
+		}
+		for i < end && text[i] != '"' && text[i] != '\'' {
+			i++
+		}
+		if i >= end {
+			return nil, fmt.Errorf("malformed attribute %q at offset %d", a.name, a.start)
+		}
+		a.quote = text[i]
+		a.valueStart = i + 1
+		j := strings.IndexByte(text[a.valueStart:end], a.quote)
+		if j == -1 {
+			return nil, fmt.Errorf("malformed attribute %q at offset %d", a.name, a.start)
+		}
+		a.valueEnd = a.valueStart + j
+		a.end = a.valueEnd + 1
+		value, err := unescapeAttr(text[a.valueStart:a.valueEnd])
+		if err != nil {
+			return nil, err
+		}
+		a.value = value
+		attrs = append(attrs, a)
+		i = a.end
+	}
+}
+
+// unescapeAttr returns the value of an attribute from its escaped text.
+func unescapeAttr(s string) (string, error) {
+	if !strings.Contains(s, "&") {
+		return s, nil
+	}
+	var v struct {
+		A string `xml:"a,attr"`
+	}
+	if err := xml.Unmarshal([]byte(`<v a="`+strings.ReplaceAll(s, `"`, "&quot;")+`"/>`), &v); err != nil {
+		return "", err
+	}
+	return v.A, nil
+}
+
+// escapeAttr returns value escaped to be quoted with quote.
+func escapeAttr(value string, quote byte) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(value))
+	s := b.String()
+	// EscapeText escapes both quotes, only one of them needs to be.
+	if quote == '"' {
+		return strings.ReplaceAll(s, "&#39;", "'")
+	}
+	return strings.ReplaceAll(s, "&#34;", `"`)
+}
+
+// checkElement returns an error unless s is a single well-formed element.
+func checkElement(s string) error {
+	elems, err := parse(s)
+	if err != nil {
+		return fmt.Errorf("invalid element %q: %v", s, err)
+	}
+	if elems[0].start != 0 || elems[0].end != len(s) {
+		return fmt.Errorf("invalid element %q: not a single element", s)
+	}
+	return nil
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// lineIndent returns the whitespace before text[pos] on its line, or "" if
+// there is something else before it.
+func lineIndent(text string, pos int) string {
+	lineStart := strings.LastIndexByte(text[:pos], '\n') + 1
+	if indent := text[lineStart:pos]; strings.TrimSpace(indent) == "" {
+		return indent
+	}
+	return ""
+}
+
+// leadingSpace returns the whitespace at the start of the line of text[pos].
+func leadingSpace(text string, pos int) string {
+	lineStart := strings.LastIndexByte(text[:pos], '\n') + 1
+	i := lineStart
+	for i < pos && isSpace(text[i]) {
+		i++
+	}
+	return text[lineStart:i]
+}
+
+// lineExtent extends text[start:end] to the whole lines it is on if there is
+// only whitespace around it on these lines, so that deleting it leaves no
+// blank line.
+func lineExtent(text string, start, end int) (int, int) {
+	lineStart := strings.LastIndexByte(text[:start], '\n') + 1
+	lineEnd := len(text)
+	if i := strings.IndexByte(text[end:], '\n'); i != -1 {
+		lineEnd = end + i + 1
+	}
+	if strings.TrimSpace(text[lineStart:start]) == "" && strings.TrimSpace(text[end:lineEnd]) == "" {
+		return lineStart, lineEnd
+	}
+	return start, end
+}
@@ -0,0 +1,228 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package manifestedit
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const manifest = `<?xml version="1.0" encoding="UTF-8"?>
+<!-- Top-level comment. -->
+<manifest>
+  <projects>
+    <!-- The first project. -->
+    <project name="a"
+             remote="https://a"
+             path="a"/>
+    <project path="b" name='b' remote="https://b" revision="1"/>
+
+    <project name="c" path="c" remote="https://c">
+      <copyfile src="x" dest="y"/>
+    </project>
+  </projects>
+  <packages/>
+</manifest>
+`
+
+func TestEditor(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(e *Editor) error
+		want string
+	}{
+		{
+			name: "set existing attribute",
+			edit: func(e *Editor) error { return e.SetAttr(Elem("project", "b"), "revision", "2") },
+			want: `<?xml version="1.0" encoding="UTF-8"?>
+<!-- Top-level comment. -->
+<manifest>
+  <projects>
+    <!-- The first project. -->
+    <project name="a"
+             remote="https://a"
+             path="a"/>
+    <project path="b" name='b' remote="https://b" revision="2"/>
+
+    <project name="c" path="c" remote="https://c">
+      <copyfile src="x" dest="y"/>
+    </project>
+  </projects>
+  <packages/>
+</manifest>
+`,
+		},
+		{
+			name: "add attribute to multi-line tag",
+			edit: func(e *Editor) error { return e.SetAttr(Elem("project", "a"), "revision", `"1"`) },
+			want: `<?xml version="1.0" encoding="UTF-8"?>
+<!-- Top-level comment. -->
+<manifest>
+  <projects>
+    <!-- The first project. -->
+    <project name="a"
+             remote="https://a"
+             path="a"
+             revision="&#34;1&#34;"/>
+    <project path="b" name='b' remote="https://b" revision="1"/>
+
+    <project name="c" path="c" remote="https://c">
+      <copyfile src="x" dest="y"/>
+    </project>
+  </projects>
+  <packages/>
+</manifest>
+`,
+		},
+		{
+			name: "add attribute to single-line tag",
+			edit: func(e *Editor) error { return e.SetAttr(Elem("project", "c"), "remotebranch", "dev") },
+			want: `<?xml version="1.0" encoding="UTF-8"?>
+<!-- Top-level comment. -->
+<manifest>
+  <projects>
+    <!-- The first project. -->
+    <project name="a"
+             remote="https://a"
+             path="a"/>
+    <project path="b" name='b' remote="https://b" revision="1"/>
+
+    <project name="c" path="c" remote="https://c" remotebranch="dev">
+      <copyfile src="x" dest="y"/>
+    </project>
+  </projects>
+  <packages/>
+</manifest>
+`,
+		},
+		{
+			name: "remove attribute",
+			edit: func(e *Editor) error { return e.RemoveAttr(Elem("project", "a"), "path") },
+			want: `<?xml version="1.0" encoding="UTF-8"?>
+<!-- Top-level comment. -->
+<manifest>
+  <projects>
+    <!-- The first project. -->
+    <project name="a"
+             remote="https://a"/>
+    <project path="b" name='b' remote="https://b" revision="1"/>
+
+    <project name="c" path="c" remote="https://c">
+      <copyfile src="x" dest="y"/>
+    </project>
+  </projects>
+  <packages/>
+</manifest>
+`,
+		},
+		{
+			name: "remove element",
+			edit: func(e *Editor) error { return e.RemoveElement(Elem("project", "c")) },
+			want: `<?xml version="1.0" encoding="UTF-8"?>
+<!-- Top-level comment. -->
+<manifest>
+  <projects>
+    <!-- The first project. -->
+    <project name="a"
+             remote="https://a"
+             path="a"/>
+    <project path="b" name='b' remote="https://b" revision="1"/>
+
+  </projects>
+  <packages/>
+</manifest>
+`,
+		},
+		{
+			name: "append element",
+			edit: func(e *Editor) error {
+				return e.AppendElement(Selector{Tag: "projects"}, `<project name="d" path="d" remote="https://d"/>`)
+			},
+			want: `<?xml version="1.0" encoding="UTF-8"?>
+<!-- Top-level comment. -->
+<manifest>
+  <projects>
+    <!-- The first project. -->
+    <project name="a"
+             remote="https://a"
+             path="a"/>
+    <project path="b" name='b' remote="https://b" revision="1"/>
+
+    <project name="c" path="c" remote="https://c">
+      <copyfile src="x" dest="y"/>
+    </project>
+    <project name="d" path="d" remote="https://d"/>
+  </projects>
+  <packages/>
+</manifest>
+`,
+		},
+		{
+			name: "append element to empty element",
+			edit: func(e *Editor) error {
+				return e.AppendElement(Selector{Tag: "packages"}, `<package name="p" version="v"/>`)
+			},
+			want: `<?xml version="1.0" encoding="UTF-8"?>
+<!-- Top-level comment. -->
+<manifest>
+  <projects>
+    <!-- The first project. -->
+    <project name="a"
+             remote="https://a"
+             path="a"/>
+    <project path="b" name='b' remote="https://b" revision="1"/>
+
+    <project name="c" path="c" remote="https://c">
+      <copyfile src="x" dest="y"/>
+    </project>
+  </projects>
+  <packages>
+    <package name="p" version="v"/>
+  </packages>
+</manifest>
+`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e, err := New([]byte(manifest))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := test.edit(e); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.want, e.String()); diff != "" {
+				t.Errorf("Wrong text (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestEditorErrors(t *testing.T) {
+	e, err := New([]byte(manifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetAttr(Elem("project", "missing"), "revision", "1"); err == nil {
+		t.Errorf("expected an error setting an attribute of a missing element")
+	}
+	if err := e.SetAttr(Selector{Tag: "project"}, "revision", "1"); err == nil {
+		t.Errorf("expected an error setting an attribute of several elements")
+	}
+	if err := e.AppendElement(Selector{Tag: "projects"}, `<project name="d"`); err == nil {
+		t.Errorf("expected an error appending a malformed element")
+	}
+	if e.String() != manifest {
+		t.Errorf("failed edits changed the text:\n%s", e.String())
+	}
+	if got, want := e.Count(Elem("project", "b").With("revision", "1")), 1; got != want {
+		t.Errorf("Count() = %d, want %d", got, want)
+	}
+	if _, err := New([]byte("<manifest>")); err == nil {
+		t.Errorf("expected an error for malformed XML")
+	}
+}
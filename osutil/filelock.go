@@ -0,0 +1,69 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package osutil
+
+import "os"
+
+// LockFile acquires an exclusive advisory lock on the file at path, creating
+// it if needed, and waits until other processes, or other goroutines of this
+// process, release it. The lock is released by calling unlock, or when the
+// process exits. The file itself is left in place.
+func LockFile(path string) (unlock func() error, err error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() error {
+		err := unlockFile(f)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}, nil
+}
+
+// LockRemovableFile is like LockFile, but the file is removed when the lock
+// is released, for locks which are only held for short times and would
+// otherwise pile up. A waiter which acquires the lock of a file removed, or
+// replaced, in the meantime tries again with the file now at path.
+func LockRemovableFile(path string) (unlock func() error, err error) {
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		if err := lockFile(f); err != nil {
+			f.Close()
+			return nil, err
+		}
+		locked, err := f.Stat()
+		if err != nil {
+			unlockFile(f)
+			f.Close()
+			return nil, err
+		}
+		if current, err := os.Stat(path); err != nil || !os.SameFile(locked, current) {
+			unlockFile(f)
+			f.Close()
+			continue
+		}
+		return func() error {
+			// Remove the file while still holding its lock, so that no other
+			// process holds the lock of the path once it is removed. Removing
+			// it may fail on Windows while others have it open, in which
+			// case it is left in place.
+			os.Remove(path)
+			err := unlockFile(f)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			return err
+		}, nil
+	}
+}
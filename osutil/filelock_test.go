@@ -0,0 +1,64 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package osutil
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestLockFile(t *testing.T) {
+	testLockFile(t, LockFile)
+}
+
+func TestLockRemovableFile(t *testing.T) {
+	path := testLockFile(t, LockRemovableFile)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("the lock file should be removed, got %v", err)
+	}
+}
+
+// testLockFile checks that lock serializes concurrent holders of the lock of
+// a file, and returns the path of the file.
+func testLockFile(t *testing.T, lock func(path string) (func() error, error)) string {
+	path := filepath.Join(t.TempDir(), "lock")
+	var holders, maxHolders atomic.Int32
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock, err := lock(path)
+			if err != nil {
+				errs <- err
+				return
+			}
+			n := holders.Add(1)
+			for {
+				m := maxHolders.Load()
+				if n <= m || maxHolders.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			holders.Add(-1)
+			if err := unlock(); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if got := maxHolders.Load(); got != 1 {
+		t.Errorf("the lock was held by %d goroutines at once, want 1", got)
+	}
+	return path
+}
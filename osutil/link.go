@@ -0,0 +1,76 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package osutil
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Symlink creates newname as a symbolic link to oldname. If the platform
+// refuses to create the link (e.g. Windows without developer mode), it falls
+// back to a directory junction or a copy of the target, see symlinkFallback.
+// As with os.Symlink, a relative oldname is resolved against the directory
+// containing newname.
+func Symlink(oldname, newname string) error {
+	err := os.Symlink(oldname, newname)
+	if err == nil {
+		return nil
+	}
+	target := oldname
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(newname), target)
+	}
+	return symlinkFallback(target, newname, err)
+}
+
+// Link creates newname as a hard link to oldname, copying oldname instead if
+// the file system does not support hard links.
+func Link(oldname, newname string) error {
+	if err := os.Link(oldname, newname); err != nil {
+		if copyErr := CopyFile(oldname, newname); copyErr != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Readlink returns the destination of the symbolic link name. If name was
+// created by the copy fallback of Symlink and is a regular file, name itself
+// is returned so callers can keep treating it as the link target.
+func Readlink(name string) (string, error) {
+	dest, err := os.Readlink(name)
+	if err == nil {
+		return dest, nil
+	}
+	if fi, statErr := os.Lstat(name); statErr == nil && fi.Mode().IsRegular() {
+		return name, nil
+	}
+	return "", err
+}
+
+// CopyFile copies the regular file src to dst, preserving its permission
+// bits. dst is truncated if it exists.
+func CopyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
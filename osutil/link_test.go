@@ -0,0 +1,68 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package osutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSymlinkRelative(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "target"), []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "link")
+	if err := Symlink("target", link); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "content" {
+		t.Errorf("reading through link: got %q, want %q", got, "content")
+	}
+	if _, err := Readlink(link); err != nil {
+		t.Errorf("Readlink(%q) failed: %v", link, err)
+	}
+}
+
+func TestReadlinkRegularFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "copy")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Readlink(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != file {
+		t.Errorf("Readlink(%q) = %q, want the file itself", file, got)
+	}
+}
+
+func TestLinkAndCopyFile(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.WriteFile(src, []byte("data"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []func(string, string) error{Link, CopyFile} {
+		dst := filepath.Join(dir, "dst")
+		os.Remove(dst)
+		if err := f(src, dst); err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != "data" {
+			t.Errorf("got %q, want %q", got, "data")
+		}
+	}
+}
@@ -0,0 +1,14 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !windows
+// +build !windows
+
+package osutil
+
+// symlinkFallback returns err unchanged: symbolic links are always available
+// on non-Windows platforms, so a failure is a real error.
+func symlinkFallback(target, newname string, err error) error {
+	return err
+}
@@ -0,0 +1,34 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build windows
+// +build windows
+
+package osutil
+
+import (
+	"os"
+	"os/exec"
+)
+
+// symlinkFallback emulates a symbolic link when creating one failed, which
+// happens on Windows unless developer mode is enabled or the process is
+// elevated. Directories are linked with an NTFS junction, which needs no
+// special privileges; files are copied.
+func symlinkFallback(target, newname string, err error) error {
+	fi, statErr := os.Stat(target)
+	if statErr != nil {
+		return err
+	}
+	if fi.IsDir() {
+		if jErr := exec.Command("cmd", "/c", "mklink", "/J", newname, target).Run(); jErr != nil {
+			return err
+		}
+		return nil
+	}
+	if copyErr := CopyFile(target, newname); copyErr != nil {
+		return err
+	}
+	return nil
+}
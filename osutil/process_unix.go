@@ -0,0 +1,33 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !windows
+// +build !windows
+
+package osutil
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+)
+
+// ProcessExists reports whether a process with the given pid is running.
+func ProcessExists(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// KillProcessGroupOnCancel runs cmd in a process group of its own, and makes
+// the cancellation of its context kill the whole group, so that the children
+// started by cmd do not outlive it.
+func KillProcessGroupOnCancel(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
@@ -0,0 +1,32 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build windows
+// +build windows
+
+package osutil
+
+import (
+	"os"
+	"os/exec"
+)
+
+// ProcessExists reports whether a process with the given pid is running.
+func ProcessExists(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	// FindProcess opens a handle to the process, which fails if it doesn't
+	// exist.
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
+
+// KillProcessGroupOnCancel does nothing on Windows, where the cancellation of
+// the context of cmd only kills cmd.
+func KillProcessGroupOnCancel(cmd *exec.Cmd) {}
@@ -1,27 +1,203 @@
 package osutil
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"syscall"
 )
 
+// Rename renames src to dst like os.Rename. If they are on different file
+// systems, where os.Rename fails, it copies src to dst instead, preserving
+// file modes and symbolic links, verifies the copy and removes src. A
+// directory is only moved this way if dst does not exist, so that src is
+// never copied into an existing directory.
 func Rename(src, dst string) error {
-	if err := os.Rename(src, dst); err != nil {
-		// Check if the rename operation failed
-		// because the source and destination are
-		// located on different mount points.
-		linkErr, ok := err.(*os.LinkError)
-		if !ok {
+	err := os.Rename(src, dst)
+	if err == nil || !isCrossDevice(err) {
+		return err
+	}
+	return moveAcrossDevices(src, dst)
+}
+
+// isCrossDevice returns true if err is the error of a rename across file
+// systems.
+func isCrossDevice(err error) bool {
+	var linkErr *os.LinkError
+	if !errors.As(err, &linkErr) {
+		return false
+	}
+	errno, ok := linkErr.Err.(syscall.Errno)
+	return ok && errno == syscall.EXDEV
+}
+
+func moveAcrossDevices(src, dst string) error {
+	fi, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		// Copy next to dst first, so that an existing dst is replaced
+		// atomically as with os.Rename.
+		tmp := filepath.Join(filepath.Dir(dst), fmt.Sprintf(".%s.tmp-%d", filepath.Base(dst), os.Getpid()))
+		if err := copyTree(src, tmp); err != nil {
+			os.RemoveAll(tmp)
 			return err
 		}
-		errno, ok := linkErr.Err.(syscall.Errno)
-		if !ok || errno != syscall.EXDEV {
+		if err := verifyTree(src, tmp); err != nil {
+			os.RemoveAll(tmp)
+			return err
+		}
+		if err := os.Rename(tmp, dst); err != nil {
+			os.RemoveAll(tmp)
+			return err
+		}
+		return os.Remove(src)
+	}
+	if _, err := os.Lstat(dst); err == nil {
+		return &os.LinkError{Op: "rename", Old: src, New: dst, Err: fs.ErrExist}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if err := copyTree(src, dst); err != nil {
+		os.RemoveAll(dst)
+		return fmt.Errorf("cannot copy %s to %s: %v", src, dst, err)
+	}
+	if err := verifyTree(src, dst); err != nil {
+		os.RemoveAll(dst)
+		return fmt.Errorf("cannot copy %s to %s: %v", src, dst, err)
+	}
+	return os.RemoveAll(src)
+}
+
+// copyTree copies src, a file, a symbolic link or a directory, to dst,
+// which must not exist. It preserves the permissions and the modification
+// times of the files and directories, and the targets of symbolic links.
+func copyTree(src, dst string) error {
+	var dirs []string
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch mode := d.Type(); {
+		case mode.IsDir():
+			// Directories get their permissions once their content is
+			// copied, as they may not be writable.
+			dirs = append(dirs, rel)
+			return os.Mkdir(target, 0700)
+		case mode&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return Symlink(link, target)
+		case mode.IsRegular():
+			if err := CopyFile(path, target); err != nil {
+				return err
+			}
+			return copyAttributes(path, target)
+		default:
+			return fmt.Errorf("cannot copy %s: unsupported file type %s", path, mode)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := copyAttributes(filepath.Join(src, dirs[i]), filepath.Join(dst, dirs[i])); err != nil {
 			return err
 		}
-		// Fall back to a non-atomic rename.
-		cmd := exec.Command("mv", src, dst)
-		return cmd.Run()
 	}
 	return nil
 }
+
+// copyAttributes gives dst the permissions and the modification time of
+// src.
+func copyAttributes(src, dst string) error {
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	// CopyFile creates files with the permissions of src minus the umask.
+	if err := os.Chmod(dst, fi.Mode()&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky)); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, fi.ModTime(), fi.ModTime())
+}
+
+// verifyTree checks that dst is a copy of src made by copyTree.
+func verifyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		want, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		got, err := os.Lstat(target)
+		if err != nil {
+			return fmt.Errorf("%s was not copied: %v", path, err)
+		}
+		if want.Mode()&fs.ModeSymlink != 0 {
+			wantLink, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			// The copy fallback of Symlink makes regular files.
+			if gotLink, err := Readlink(target); err != nil || (gotLink != wantLink && gotLink != target) {
+				return fmt.Errorf("symbolic link %s was not copied", path)
+			}
+			return nil
+		}
+		if got.Mode() != want.Mode() {
+			return fmt.Errorf("%s was copied with mode %s instead of %s", path, got.Mode(), want.Mode())
+		}
+		if !want.Mode().IsRegular() {
+			return nil
+		}
+		if got.Size() != want.Size() {
+			return fmt.Errorf("%s was copied with size %d instead of %d", path, got.Size(), want.Size())
+		}
+		wantSum, err := fileDigest(path)
+		if err != nil {
+			return err
+		}
+		gotSum, err := fileDigest(target)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(gotSum, wantSum) {
+			return fmt.Errorf("%s was not copied correctly", path)
+		}
+		return nil
+	})
+}
+
+func fileDigest(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
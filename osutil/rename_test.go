@@ -0,0 +1,93 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !windows
+// +build !windows
+
+package osutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestMoveAcrossDevices checks the fallback of Rename for moves between file
+// systems, which cannot be set up in a test.
+func TestMoveAcrossDevices(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.MkdirAll(filepath.Join(src, "sub", "private"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "sub", "script.sh"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "sub", "private", "key"), []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(src, "sub", "private"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("sub/script.sh", filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	// A directory is not moved into an existing one.
+	existing := filepath.Join(dir, "existing")
+	if err := os.Mkdir(existing, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := moveAcrossDevices(src, existing); err == nil {
+		t.Fatalf("expected moving %s to the existing %s to fail", src, existing)
+	}
+	if _, err := os.Stat(filepath.Join(existing, "src")); !os.IsNotExist(err) {
+		t.Fatalf("expected %s to be left untouched, got %v", existing, err)
+	}
+
+	dst := filepath.Join(dir, "dst")
+	if err := moveAcrossDevices(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Fatalf("expected %s to be removed, got %v", src, err)
+	}
+	for path, want := range map[string]os.FileMode{
+		"sub/script.sh":   0755,
+		"sub/private":     os.ModeDir | 0700,
+		"sub/private/key": 0600,
+	} {
+		fi, err := os.Stat(filepath.Join(dst, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fi.Mode(); got != want {
+			t.Errorf("got mode %s for %s, want %s", got, path, want)
+		}
+	}
+	if link, err := os.Readlink(filepath.Join(dst, "link")); err != nil {
+		t.Fatal(err)
+	} else if link != "sub/script.sh" {
+		t.Errorf("got link to %q, want %q", link, "sub/script.sh")
+	}
+	if data, err := os.ReadFile(filepath.Join(dst, "sub", "private", "key")); err != nil {
+		t.Fatal(err)
+	} else if string(data) != "secret" {
+		t.Errorf("got content %q, want %q", data, "secret")
+	}
+
+	// Files replace existing files as with os.Rename.
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := moveAcrossDevices(file, filepath.Join(dst, "sub", "private", "key")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dst, "sub", "private", "key")); err != nil {
+		t.Fatal(err)
+	} else if string(data) != "new" {
+		t.Errorf("got content %q, want %q", data, "new")
+	}
+}
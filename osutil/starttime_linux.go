@@ -0,0 +1,37 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+// +build linux
+
+package osutil
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ProcessStartTime returns an opaque token identifying when the process with
+// the given pid started, so that a reused pid can be told apart from the
+// process that first had it. It returns "" where this is not supported.
+func ProcessStartTime(pid int) (string, error) {
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return "", err
+	}
+	// The command name, in parentheses, may contain spaces, so the fields
+	// are counted from the last ')'. The start time is the 22nd field.
+	stat := string(data)
+	i := strings.LastIndexByte(stat, ')')
+	if i < 0 {
+		return "", fmt.Errorf("malformed stat of process %d", pid)
+	}
+	fields := strings.Fields(stat[i+1:])
+	if len(fields) < 20 {
+		return "", fmt.Errorf("malformed stat of process %d", pid)
+	}
+	return fields[19], nil
+}
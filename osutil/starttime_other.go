@@ -0,0 +1,15 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package osutil
+
+// ProcessStartTime returns an opaque token identifying when the process with
+// the given pid started, so that a reused pid can be told apart from the
+// process that first had it. It returns "" where this is not supported.
+func ProcessStartTime(pid int) (string, error) {
+	return "", nil
+}
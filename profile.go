@@ -0,0 +1,107 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jiri
+
+import (
+	"net/url"
+	"sort"
+	"time"
+)
+
+// ProjectTiming is the time spent updating a project, broken down by phase
+// (e.g. "fetch", "checkout", "rebase"). Phases don't overlap, and Total is
+// their sum. Durations are in seconds.
+type ProjectTiming struct {
+	Name   string             `json:"name"`
+	Path   string             `json:"path"`
+	Remote string             `json:"remote"`
+	Host   string             `json:"host"`
+	Phases map[string]float64 `json:"phases"`
+	Total  float64            `json:"total"`
+}
+
+// HostTiming is the time spent updating all projects of a host.
+type HostTiming struct {
+	Host     string  `json:"host"`
+	Projects int     `json:"projects"`
+	Total    float64 `json:"total"`
+}
+
+// UpdateProfile holds the project timings recorded during an update, slowest
+// first.
+type UpdateProfile struct {
+	Projects []ProjectTiming `json:"projects"`
+	Hosts    []HostTiming    `json:"hosts"`
+}
+
+// RecordProjectTiming adds d to the time spent in phase while updating the
+// project at path. It is safe to call concurrently.
+func (jirix *X) RecordProjectTiming(name, path, remote, phase string, d time.Duration) {
+	jirix.timingsMu.Lock()
+	defer jirix.timingsMu.Unlock()
+	if jirix.timings == nil {
+		jirix.timings = make(map[string]*ProjectTiming)
+	}
+	t, ok := jirix.timings[path]
+	if !ok {
+		host := remote
+		if u, err := url.Parse(remote); err == nil && u.Host != "" {
+			host = u.Host
+		}
+		t = &ProjectTiming{
+			Name:   name,
+			Path:   path,
+			Remote: remote,
+			Host:   host,
+			Phases: make(map[string]float64),
+		}
+		jirix.timings[path] = t
+	}
+	t.Phases[phase] += d.Seconds()
+	t.Total += d.Seconds()
+}
+
+// UpdateProfile returns the project timings recorded so far, or nil if there
+// are none.
+func (jirix *X) UpdateProfile() *UpdateProfile {
+	jirix.timingsMu.Lock()
+	defer jirix.timingsMu.Unlock()
+	if len(jirix.timings) == 0 {
+		return nil
+	}
+	p := &UpdateProfile{}
+	hosts := make(map[string]*HostTiming)
+	for _, t := range jirix.timings {
+		pt := *t
+		pt.Phases = make(map[string]float64, len(t.Phases))
+		for k, v := range t.Phases {
+			pt.Phases[k] = v
+		}
+		p.Projects = append(p.Projects, pt)
+		h, ok := hosts[t.Host]
+		if !ok {
+			h = &HostTiming{Host: t.Host}
+			hosts[t.Host] = h
+		}
+		h.Projects++
+		h.Total += t.Total
+	}
+	for _, h := range hosts {
+		p.Hosts = append(p.Hosts, *h)
+	}
+	sort.Slice(p.Projects, func(i, j int) bool {
+		if p.Projects[i].Total != p.Projects[j].Total {
+			return p.Projects[i].Total > p.Projects[j].Total
+		}
+		return p.Projects[i].Path < p.Projects[j].Path
+	})
+	sort.Slice(p.Hosts, func(i, j int) bool {
+		if p.Hosts[i].Total != p.Hosts[j].Total {
+			return p.Hosts[i].Total > p.Hosts[j].Total
+		}
+		return p.Hosts[i].Host < p.Hosts[j].Host
+	})
+	return p
+}
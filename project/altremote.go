@@ -0,0 +1,43 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"errors"
+
+	"go.fuchsia.dev/jiri"
+)
+
+// AltRemote is a mirror of the remote of a project, which jiri fetches from
+// when fetching from the remote fails, e.g. during an outage of the host.
+type AltRemote struct {
+	// Remote is the URL of the mirror.
+	Remote  string   `xml:"remote,attr"`
+	XMLName struct{} `xml:"altremote"`
+}
+
+// withAltRemotes calls fetch with remote, the remote of p after rewrites. If
+// it fails with a network error, which happens once the retries are
+// exhausted, fetch is called with each of the alternate remotes of p in turn
+// until one succeeds. The remote which was used is logged, so that it shows
+// in the update log.
+func withAltRemotes(jirix *jiri.X, p Project, remote string, fetch func(remote string) error) error {
+	err := fetch(remote)
+	var netErr *jiri.NetworkError
+	if err == nil || len(p.AltRemotes) == 0 || !errors.As(err, &netErr) {
+		return err
+	}
+	for _, alt := range p.AltRemotes {
+		altRemote := rewriteRemote(jirix, alt.Remote)
+		jirix.Logger.Warningf("Fetching project %s(%s) from %s failed, trying alternate remote %s\n\n", p.Name, p.Path, remote, altRemote)
+		if altErr := fetch(altRemote); altErr != nil {
+			jirix.Logger.Debugf("Fetching project %s(%s) from %s failed: %v", p.Name, p.Path, altRemote, altErr)
+			continue
+		}
+		jirix.Logger.Infof("Fetched project %s(%s) from alternate remote %s\n", p.Name, p.Path, altRemote)
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,439 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package project
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/retry"
+)
+
+const (
+	// ArchiveProjectVCS is the VCS of projects whose remote is an
+	// archive, unpacked read-only into the project path.
+	ArchiveProjectVCS = "archive"
+
+	// archiveMetaFile is the file, relative to the path of an archive
+	// project, recording the project it was unpacked from.
+	archiveMetaFile = ".jiri_archive"
+
+	// archiveLockPrefix prefixes the digest of archive projects in the
+	// revision of their lock.
+	archiveLockPrefix = "sha256:"
+)
+
+var sha256RE = regexp.MustCompile("^[0-9a-f]{64}$")
+
+// archiveVCS syncs archive projects: their remote is an archive, downloaded,
+// verified against the sha256 of the project and unpacked read-only into
+// its path.
+type archiveVCS struct{}
+
+func (archiveVCS) Name() string {
+	return ArchiveProjectVCS
+}
+
+func (archiveVCS) Validate(p Project) error {
+	if p.SHA256 != "" && !sha256RE.MatchString(p.SHA256) {
+		return fmt.Errorf("bad project %q: sha256 %q is not a lowercase hex sha256 digest", p.Name, p.SHA256)
+	}
+	if _, err := archiveFormat(p.Remote); err != nil {
+		return fmt.Errorf("bad project %q: %v", p.Name, err)
+	}
+	return nil
+}
+
+func (archiveVCS) UpToDate(jirix *jiri.X, p Project) (bool, error) {
+	if p.SHA256 == "" {
+		return false, fmt.Errorf("archive project %q has no sha256 in the manifest or jiri.lock", p.Name)
+	}
+	local, err := ProjectFromFile(jirix, filepath.Join(p.Path, archiveMetaFile))
+	return err == nil && local.Remote == p.Remote && local.SHA256 == p.SHA256, nil
+}
+
+func (archiveVCS) Test(jirix *jiri.X, p Project) error {
+	if jirix.Offline {
+		return fmt.Errorf("offline: archive project %q is not unpacked at sha256 %s", p.Name, p.SHA256)
+	}
+	return nil
+}
+
+// LocalChanges returns no changes, as archive projects are unpacked
+// read-only.
+func (archiveVCS) LocalChanges(jirix *jiri.X, p Project) (string, error) {
+	return "", nil
+}
+
+func (archiveVCS) Sync(jirix *jiri.X, p Project) error {
+	return unpackArchiveProject(jirix, p)
+}
+
+// Archive projects are locked to the digest of their archive rather than to
+// a revision.
+func (archiveVCS) LockRevision(jirix *jiri.X, p Project) (string, error) {
+	if p.SHA256 == "" {
+		return "", fmt.Errorf("archive project %q has no sha256", p.Name)
+	}
+	return archiveLockPrefix + p.SHA256, nil
+}
+
+func (archiveVCS) ApplyLock(p *Project, revision string) error {
+	digest := strings.TrimPrefix(revision, archiveLockPrefix)
+	if p.SHA256 == "" {
+		p.SHA256 = digest
+	} else if p.SHA256 != digest {
+		return fmt.Errorf("archive project %q has conflicting sha256 in manifest and jiri.lock: %s:%s", p.Name, p.SHA256, digest)
+	}
+	return nil
+}
+
+// archiveFormat returns the format of the archive at remote, from its
+// extension.
+func archiveFormat(remote string) (string, error) {
+	name := remote
+	if u, err := url.Parse(remote); err == nil && u.Path != "" {
+		name = u.Path
+	}
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar", ".zip"} {
+		if strings.HasSuffix(name, ext) {
+			return ext, nil
+		}
+	}
+	return "", fmt.Errorf("archive %q should be a .tar.gz, .tgz, .tar or .zip file", remote)
+}
+
+// unpackArchiveProject downloads the archive of p, verifies its digest and
+// unpacks it into the path of p, replacing the previous content.
+func unpackArchiveProject(jirix *jiri.X, p Project) error {
+	format, err := archiveFormat(p.Remote)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p.Path), 0755); err != nil {
+		return fmtError(err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p.Path), ".jiri-archive-*"+format)
+	if err != nil {
+		return fmtError(err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	msg := fmt.Sprintf("Downloading %s", p.Remote)
+	if err := retry.Function(jirix, func() error {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := tmp.Truncate(0); err != nil {
+			return err
+		}
+		return downloadArchive(jirix.RewriteURL(p.Name, rewriteRemote(jirix, p.Remote)), tmp)
+	}, msg, retry.AttemptsOpt(jirix.Attempts)); err != nil {
+		return &jiri.NetworkError{Err: err}
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return fmtError(err)
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, tmp); err != nil {
+		return fmtError(err)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != p.SHA256 {
+		return fmt.Errorf("archive %q of project %q has sha256 %s, want %s", p.Remote, p.Name, got, p.SHA256)
+	}
+
+	dir, err := os.MkdirTemp(filepath.Dir(p.Path), ".jiri-unpack-*")
+	if err != nil {
+		return fmtError(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := unpackArchive(tmp, format, dir); err != nil {
+		return fmt.Errorf("cannot unpack archive %q of project %q: %v", p.Remote, p.Name, err)
+	}
+	root, err := archiveRoot(dir)
+	if err != nil {
+		return fmtError(err)
+	}
+	if err := os.Chmod(root, 0755); err != nil {
+		return fmtError(err)
+	}
+	if err := p.ToFile(jirix, filepath.Join(root, archiveMetaFile)); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(p.Path); err != nil {
+		return fmtError(err)
+	}
+	return fmtError(os.Rename(root, p.Path))
+}
+
+// downloadArchive writes the archive at remote, a URL or a local path, to w.
+func downloadArchive(remote string, w io.Writer) error {
+	u, err := url.Parse(remote)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		path := remote
+		if err == nil && u.Scheme == "file" {
+			path = u.Path
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(w, f)
+		return err
+	}
+	// The credentials are sent in a header, and kept out of errors.
+	remote, user := splitCredentials(remote)
+	req, err := http.NewRequest(http.MethodGet, remote, nil)
+	if err != nil {
+		return err
+	}
+	if user != nil {
+		password, _ := user.Password()
+		req.SetBasicAuth(user.Username(), password)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("downloading %s failed: %s", remote, resp.Status)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// archiveRoot returns the directory of dir holding the unpacked archive: the
+// single top-level directory of the archive if there is one, as is customary
+// for source tarballs, and dir otherwise.
+func archiveRoot(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 1 && entries[0].IsDir() {
+		return filepath.Join(dir, entries[0].Name()), nil
+	}
+	return dir, nil
+}
+
+// unpackArchive unpacks the archive f, of format, into dir. Files are made
+// read-only, as the content of archive projects is never edited locally.
+func unpackArchive(f *os.File, format, dir string) error {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if format == ".zip" {
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		zr, err := zip.NewReader(f, info.Size())
+		if err != nil {
+			return err
+		}
+		for _, zf := range zr.File {
+			if err := unpackZipFile(zf, dir); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var r io.Reader = f
+	if format != ".tar" {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return checkArchiveSymlinks(dir)
+		}
+		if err != nil {
+			return err
+		}
+		dest, err := archiveEntryPath(dir, hdr.Name)
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(dest, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeArchiveFile(dest, tr, hdr.FileInfo().Mode()); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if filepath.IsAbs(hdr.Linkname) || !filepath.IsLocal(filepath.Join(filepath.Dir(hdr.Name), hdr.Linkname)) {
+				return fmt.Errorf("symlink %q points outside of the archive", hdr.Name)
+			}
+			if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+				return err
+			}
+			if err := os.Symlink(hdr.Linkname, dest); err != nil {
+				return err
+			}
+		default:
+			// Skip hard links, devices and the like.
+		}
+	}
+}
+
+func unpackZipFile(zf *zip.File, dir string) error {
+	dest, err := archiveEntryPath(dir, zf.Name)
+	if err != nil {
+		return err
+	}
+	if zf.FileInfo().IsDir() {
+		return os.MkdirAll(dest, 0755)
+	}
+	if !zf.Mode().IsRegular() {
+		return nil
+	}
+	rc, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return writeArchiveFile(dest, rc, zf.Mode())
+}
+
+// archiveEntryPath returns where to unpack the archive entry name into dir,
+// refusing entries outside of it, and entries under a symlink unpacked
+// earlier, through which they could be written outside of it.
+func archiveEntryPath(dir, name string) (string, error) {
+	name = strings.TrimPrefix(filepath.FromSlash(name), "."+string(filepath.Separator))
+	if name == "" || name == "." {
+		return dir, nil
+	}
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("archive entry %q is outside of the archive", name)
+	}
+	parent := dir
+	parts := strings.Split(filepath.Clean(name), string(filepath.Separator))
+	for _, part := range parts[:len(parts)-1] {
+		parent = filepath.Join(parent, part)
+		if info, err := os.Lstat(parent); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("archive entry %q is under a symlink", name)
+		}
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// maxArchiveSymlinks bounds the symlinks followed to resolve a symlink of an
+// archive, as the kernel does.
+const maxArchiveSymlinks = 40
+
+// checkArchiveSymlinks checks that the symlinks unpacked into dir resolve
+// inside of it, following the symlinks their targets go through. Their
+// targets are checked once all entries are unpacked, as an entry may be a
+// symlink on the path of the target of an earlier one.
+func checkArchiveSymlinks(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.Type()&fs.ModeSymlink == 0 {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		target, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		links := 0
+		if _, err := resolveArchiveSymlink(dir, splitArchivePath(filepath.Dir(rel)), target, &links); err != nil {
+			return fmt.Errorf("symlink %q: %v", filepath.ToSlash(rel), err)
+		}
+		return nil
+	})
+}
+
+func splitArchivePath(rel string) []string {
+	if rel == "." || rel == "" {
+		return nil
+	}
+	return strings.Split(rel, string(filepath.Separator))
+}
+
+// resolveArchiveSymlink returns the path components, relative to dir, of
+// target resolved from the directory cur of dir, following the symlinks it
+// goes through. It fails if target goes outside of dir.
+func resolveArchiveSymlink(dir string, cur []string, target string, links *int) ([]string, error) {
+	if filepath.IsAbs(target) || filepath.VolumeName(target) != "" {
+		return nil, fmt.Errorf("points to absolute path %q", target)
+	}
+	cur = slices.Clone(cur)
+	for _, part := range strings.Split(filepath.ToSlash(target), "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if len(cur) == 0 {
+				return nil, fmt.Errorf("points outside of the archive")
+			}
+			cur = cur[:len(cur)-1]
+			continue
+		}
+		next := append(slices.Clone(cur), part)
+		path := filepath.Join(append([]string{dir}, next...)...)
+		info, err := os.Lstat(path)
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			cur = next
+			continue
+		}
+		if *links++; *links > maxArchiveSymlinks {
+			return nil, fmt.Errorf("too many levels of symlinks")
+		}
+		link, err := os.Readlink(path)
+		if err != nil {
+			return nil, err
+		}
+		if cur, err = resolveArchiveSymlink(dir, cur, link, links); err != nil {
+			return nil, err
+		}
+	}
+	return cur, nil
+}
+
+func writeArchiveFile(dest string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(dest, os.O_CREATE|os.O_EXCL|os.O_WRONLY, (mode.Perm()|0400)&^0222)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
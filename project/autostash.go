@@ -0,0 +1,103 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/gitutil"
+)
+
+// autostashFile is the file, in the metadata directory of a project, that
+// records the stash commit of the uncommitted changes set aside by
+// "jiri update -autostash" until they are restored.
+const autostashFile = "autostash"
+
+// autostashMessage is the message of the stash entries of "jiri update
+// -autostash".
+const autostashMessage = "jiri update -autostash"
+
+func autostashRecord(jirix *jiri.X, project Project) (string, error) {
+	gitDir, err := project.AbsoluteGitDir(jirix)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(gitDir, jiri.ProjectMetaDir, autostashFile), nil
+}
+
+// autostash stashes the uncommitted changes of project, records the stash in
+// its metadata and returns a function restoring the changes, to call once the
+// project is updated. If the changes of a previous autostash were not
+// restored, it reports the project as failed instead and returns nil, so that
+// they are never buried under a new stash.
+func autostash(jirix *jiri.X, project Project, relativePath string) (func(), error) {
+	record, err := autostashRecord(jirix, project)
+	if err != nil {
+		return nil, err
+	}
+	scm := newGit(jirix, gitutil.RootDirOpt(project.Path))
+	if data, err := os.ReadFile(record); err == nil {
+		previous := strings.TrimSpace(string(data))
+		if i, err := scm.StashIndex(previous); err != nil {
+			return nil, err
+		} else if i >= 0 {
+			msg := fmt.Sprintf("Project %s(%s) contains uncommitted changes, and the changes stashed by a previous update as stash@{%d} (%s) were not restored.", project.Name, relativePath, i, shortRevision(previous))
+			msg += fmt.Sprintf("\nApply them with %s or drop them with %s, then try again.\n\n",
+				jirix.Color.Yellow("git -C %q stash apply stash@{%d}", relativePath, i),
+				jirix.Color.Yellow("git -C %q stash drop stash@{%d}", relativePath, i))
+			jirix.Logger.Errorf("%s", msg)
+			return nil, nil
+		}
+		// The stash was applied and dropped by hand.
+		if err := os.Remove(record); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	rev, err := scm.StashPush(autostashMessage)
+	if err != nil {
+		return nil, fmt.Errorf("cannot stash the uncommitted changes of project %q: %v", project.Name, err)
+	}
+	if rev == "" {
+		return func() {}, nil
+	}
+	if err := SafeWriteFile(jirix, record, []byte(rev+"\n")); err != nil {
+		if err2 := scm.StashApply(rev); err2 == nil {
+			scm.StashDrop(rev)
+		}
+		return nil, err
+	}
+	jirix.Logger.Debugf("For project %q, stashed uncommitted changes as %s", project.Name, rev)
+	return func() {
+		if err := scm.StashApply(rev); err != nil {
+			// The stash is the latest one, unless the update made others,
+			// e.g. in hooks.
+			stash := "stash@{0}"
+			if i, err := scm.StashIndex(rev); err == nil && i >= 0 {
+				stash = fmt.Sprintf("stash@{%d}", i)
+			}
+			msg := fmt.Sprintf("For project %s(%s), your uncommitted changes conflict with the update and were not fully restored: %v", project.Name, relativePath, err)
+			msg += fmt.Sprintf("\nThe conflicts are left in the working tree, and your changes are kept in the stash as %s (%s). Once the conflicts are resolved, drop it with\n%s\n\n",
+				stash, shortRevision(rev), jirix.Color.Yellow("git -C %q stash drop %s", relativePath, stash))
+			jirix.Logger.Errorf("%s", msg)
+			jirix.IncrementFailures()
+			return
+		}
+		if err := scm.StashDrop(rev); err != nil {
+			jirix.Logger.Warningf("For project %s(%s), restored your uncommitted changes but could not drop their stash %s: %v\n\n", project.Name, relativePath, shortRevision(rev), err)
+			return
+		}
+		if err := os.Remove(record); err != nil {
+			jirix.Logger.Warningf("For project %s(%s), could not remove %s: %v\n\n", project.Name, relativePath, record, err)
+		}
+		jirix.Logger.Debugf("For project %q, restored uncommitted changes from %s", project.Name, rev)
+	}, nil
+}
@@ -0,0 +1,70 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package project
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/gitutil"
+)
+
+// incompleteClonesDir is the directory, relative to the root metadata
+// directory, holding a marker for each project being cloned. A marker left
+// behind means that the clone was interrupted and that the project directory
+// is not a usable checkout.
+const incompleteClonesDir = "incomplete_clones"
+
+// cloneMarkerPath returns the path of the in-progress marker of the clone of
+// a project into dest.
+func cloneMarkerPath(jirix *jiri.X, dest string) string {
+	rel, err := filepath.Rel(jirix.Root, dest)
+	if err != nil {
+		rel = dest
+	}
+	return filepath.Join(jirix.RootMetaDir(), incompleteClonesDir, url.PathEscape(filepath.ToSlash(rel)))
+}
+
+// markCloneInProgress records that a project is being cloned into dest.
+func markCloneInProgress(jirix *jiri.X, dest string) error {
+	return SafeWriteFile(jirix, cloneMarkerPath(jirix, dest), []byte(dest+"\n"))
+}
+
+// markCloneDone removes the in-progress marker of the clone into dest.
+func markCloneDone(jirix *jiri.X, dest string) error {
+	if err := os.Remove(cloneMarkerPath(jirix, dest)); err != nil && !os.IsNotExist(err) {
+		return fmtError(err)
+	}
+	return nil
+}
+
+// isIncompleteClone returns whether dest holds a clone that was interrupted
+// before it completed. A marker is only trusted if dest has the git directory
+// of the clone; a stale one, e.g. left by a failed clone whose directory was
+// since replaced by the user, is removed.
+func isIncompleteClone(jirix *jiri.X, dest string) bool {
+	if _, err := os.Stat(cloneMarkerPath(jirix, dest)); err != nil {
+		return false
+	}
+	if _, err := os.Lstat(filepath.Join(dest, ".git")); err != nil {
+		if err := markCloneDone(jirix, dest); err != nil {
+			jirix.Logger.Debugf("Cannot remove the stale clone marker of %s: %v", dest, err)
+		}
+		return false
+	}
+	return true
+}
+
+// isCorruptClone returns whether the clone in dest exists but misses objects,
+// e.g. because a packfile was truncated by a network failure, so that
+// cloning it again may succeed.
+func isCorruptClone(jirix *jiri.X, dest string) bool {
+	if _, err := os.Stat(filepath.Join(dest, ".git")); err != nil {
+		return false
+	}
+	return newGit(jirix, gitutil.RootDirOpt(dest)).CheckConnectivity() != nil
+}
@@ -0,0 +1,143 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/gitutil"
+)
+
+// failedRebasesFile is the file, in the metadata directory of a project,
+// that records the branches "jiri update" could not rebase.
+const failedRebasesFile = "failed_rebases.json"
+
+// FailedRebase is a local branch that "jiri update" could not rebase, as
+// rebasing it conflicts.
+type FailedRebase struct {
+	Branch string `json:"branch"`
+	// Onto is the upstream branch, or the JIRI_HEAD revision for untracked
+	// branches, that the branch was rebased onto.
+	Onto string `json:"onto"`
+}
+
+// Conflict is a project with a rebase or a cherry-pick to resolve.
+type Conflict struct {
+	Project Project
+	// InProgress is gitutil.RebaseInProgress or
+	// gitutil.CherryPickInProgress if one is stopped in the project, e.g.
+	// on conflicts, or "" otherwise.
+	InProgress string
+	// FailedRebases are the branches the last update of the project could
+	// not rebase and which are still not rebased.
+	FailedRebases []FailedRebase
+}
+
+func failedRebasesRecord(jirix *jiri.X, project Project) (string, error) {
+	gitDir, err := project.AbsoluteGitDir(jirix)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(gitDir, jiri.ProjectMetaDir, failedRebasesFile), nil
+}
+
+// writeFailedRebases records rebases as the branches of project that the
+// update could not rebase, replacing those of the previous update.
+func writeFailedRebases(jirix *jiri.X, project Project, rebases []FailedRebase) error {
+	record, err := failedRebasesRecord(jirix, project)
+	if err != nil {
+		return err
+	}
+	if len(rebases) == 0 {
+		if err := os.Remove(record); err != nil && !os.IsNotExist(err) {
+			return fmtError(err)
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(rebases, "", "  ")
+	if err != nil {
+		return err
+	}
+	return SafeWriteFile(jirix, record, append(data, '\n'))
+}
+
+// readFailedRebases returns the branches of project that its last update
+// could not rebase.
+func readFailedRebases(jirix *jiri.X, project Project) ([]FailedRebase, error) {
+	record, err := failedRebasesRecord(jirix, project)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(record)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmtError(err)
+	}
+	var rebases []FailedRebase
+	if err := json.Unmarshal(data, &rebases); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", record, err)
+	}
+	return rebases, nil
+}
+
+// FindConflicts returns the projects with a rebase or a cherry-pick stopped
+// on conflicts, or with branches the last update could not rebase, sorted
+// by path. Failed rebases of branches which were deleted, or since rebased,
+// are left out.
+func FindConflicts(jirix *jiri.X, projects Projects) ([]Conflict, error) {
+	var conflicts []Conflict
+	for _, p := range projects {
+		scm := newGit(jirix, gitutil.RootDirOpt(p.Path))
+		inProgress, err := scm.InProgress()
+		if err != nil {
+			return nil, fmt.Errorf("project %q: %v", p.Name, err)
+		}
+		rebases, err := readFailedRebases(jirix, p)
+		if err != nil {
+			return nil, err
+		}
+		var pending []FailedRebase
+		for _, r := range rebases {
+			if exists, err := scm.BranchExists(r.Branch); err != nil || !exists {
+				continue
+			}
+			if rebased, err := scm.IsAncestor(r.Onto, r.Branch); err == nil && rebased {
+				continue
+			}
+			pending = append(pending, r)
+		}
+		if inProgress != "" || len(pending) > 0 {
+			conflicts = append(conflicts, Conflict{Project: p, InProgress: inProgress, FailedRebases: pending})
+		}
+	}
+	sort.Slice(conflicts, func(i, j int) bool {
+		return conflicts[i].Project.Path < conflicts[j].Project.Path
+	})
+	return conflicts, nil
+}
+
+// StartRebase checks out the branch of r in project and rebases it again,
+// leaving the rebase stopped on its conflicts for the user to resolve. It
+// returns true if the rebase stopped on conflicts, and false if it went
+// through.
+func StartRebase(jirix *jiri.X, project Project, r FailedRebase) (bool, error) {
+	scm := newGit(jirix, gitutil.RootDirOpt(project.Path))
+	if err := scm.Checkout(r.Branch); err != nil {
+		return false, err
+	}
+	if err := scm.Rebase(r.Onto); err != nil {
+		if inProgress, err2 := scm.InProgress(); err2 == nil && inProgress == gitutil.RebaseInProgress {
+			return true, nil
+		}
+		return false, err
+	}
+	return false, nil
+}
@@ -0,0 +1,302 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package project
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.fuchsia.dev/jiri"
+)
+
+// CopyFile is a file of a project that is copied elsewhere in the jiri root
+// after the project is updated, e.g. a top-level build file.
+type CopyFile struct {
+	// Src is the path of the file, relative to the project.
+	Src string `xml:"src,attr"`
+	// Dest is the path of the copy, relative to the jiri root.
+	Dest    string   `xml:"dest,attr"`
+	XMLName struct{} `xml:"copyfile"`
+}
+
+// LinkFile is a file or directory of a project that is symlinked from
+// elsewhere in the jiri root after the project is updated.
+type LinkFile struct {
+	// Src is the path of the file or directory, relative to the project.
+	Src string `xml:"src,attr"`
+	// Dest is the path of the symlink, relative to the jiri root.
+	Dest    string   `xml:"dest,attr"`
+	XMLName struct{} `xml:"linkfile"`
+}
+
+// validateFilePaths checks the src and dest of a copyfile or linkfile element
+// of project p.
+func validateFilePaths(p *Project, elem, src, dest string) error {
+	for _, path := range []string{src, dest} {
+		if path == "" || filepath.IsAbs(path) || filepath.IsAbs(filepath.FromSlash(path)) {
+			return fmt.Errorf("bad project %q: %s must have relative src and dest, got %q and %q", p.Name, elem, src, dest)
+		}
+		clean := filepath.Clean(filepath.FromSlash(path))
+		if clean == "." || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("bad project %q: %s path %q is outside of its directory", p.Name, elem, path)
+		}
+	}
+	clean := filepath.Clean(filepath.FromSlash(dest))
+	if clean == jiri.JiriManifestFile || clean == jiri.RootMetaDir || strings.HasPrefix(clean, jiri.RootMetaDir+string(filepath.Separator)) {
+		return fmt.Errorf("bad project %q: %s dest %q is a file of jiri", p.Name, elem, dest)
+	}
+	return nil
+}
+
+// projectFilesRecord returns the file recording the destinations, relative
+// to the root, of the copyfile and linkfile elements created by jiri.
+func projectFilesRecord(jirix *jiri.X) string {
+	return filepath.Join(jirix.RootMetaDir(), "project_files.json")
+}
+
+// readProjectFiles returns the absolute destinations of the copyfile and
+// linkfile elements created by jiri.
+func readProjectFiles(jirix *jiri.X) (map[string]bool, error) {
+	created := make(map[string]bool)
+	data, err := os.ReadFile(projectFilesRecord(jirix))
+	if os.IsNotExist(err) {
+		return created, nil
+	} else if err != nil {
+		return nil, fmtError(err)
+	}
+	var dests []string
+	if err := json.Unmarshal(data, &dests); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", projectFilesRecord(jirix), err)
+	}
+	for _, dest := range dests {
+		created[filepath.Join(jirix.Root, filepath.FromSlash(dest))] = true
+	}
+	return created, nil
+}
+
+// writeProjectFiles records created as the destinations of the copyfile and
+// linkfile elements created by jiri.
+func writeProjectFiles(jirix *jiri.X, created map[string]bool) error {
+	dests := []string{}
+	for dest := range created {
+		rel, err := filepath.Rel(jirix.Root, dest)
+		if err != nil {
+			return err
+		}
+		dests = append(dests, filepath.ToSlash(rel))
+	}
+	sort.Strings(dests)
+	data, err := json.MarshalIndent(dests, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if old, err := os.ReadFile(projectFilesRecord(jirix)); err == nil && bytes.Equal(old, data) {
+		return nil
+	} else if os.IsNotExist(err) && len(dests) == 0 {
+		return nil
+	}
+	return SafeWriteFile(jirix, projectFilesRecord(jirix), data)
+}
+
+// checkProjectFileDest returns an error if dest, a destination of the
+// copyfile and linkfile elements of p, is in the checkout of another project
+// of projects. Projects at the root are left out, as all destinations are in
+// it.
+func checkProjectFileDest(jirix *jiri.X, p Project, dest string, projects ...Projects) error {
+	for _, ps := range projects {
+		for _, other := range ps {
+			path := filepath.Clean(other.Path)
+			if path == filepath.Clean(p.Path) || path == filepath.Clean(jirix.Root) {
+				continue
+			}
+			if dest == path || strings.HasPrefix(dest, path+string(filepath.Separator)) {
+				return fmt.Errorf("%s is in the checkout of project %q", dest, other.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// projectFileDests returns the absolute destinations of the copyfile and
+// linkfile elements of p.
+func projectFileDests(jirix *jiri.X, p Project) []string {
+	var dests []string
+	for _, f := range p.CopyFiles {
+		dests = append(dests, filepath.Join(jirix.Root, filepath.FromSlash(f.Dest)))
+	}
+	for _, f := range p.LinkFiles {
+		dests = append(dests, filepath.Join(jirix.Root, filepath.FromSlash(f.Dest)))
+	}
+	return dests
+}
+
+// updateProjectFiles materializes the copyfile and linkfile elements of
+// remoteProjects, and removes the files created for elements of
+// localProjects, as recorded in their metadata, that are gone from the
+// manifest.
+func updateProjectFiles(jirix *jiri.X, localProjects, remoteProjects Projects) error {
+	skipped := func(key ProjectKey) bool {
+		local, ok := localProjects[key]
+		return ok && (local.LocalConfig.Ignore || local.LocalConfig.NoUpdate)
+	}
+	wanted := make(map[string]bool)
+	for key, p := range remoteProjects {
+		if skipped(key) {
+			continue
+		}
+		for _, dest := range projectFileDests(jirix, p) {
+			wanted[dest] = true
+		}
+	}
+
+	var stale []string
+	for key, p := range localProjects {
+		if skipped(key) {
+			continue
+		}
+		for _, dest := range projectFileDests(jirix, p) {
+			if !wanted[dest] {
+				stale = append(stale, dest)
+			}
+		}
+	}
+	sort.Strings(stale)
+	created, err := readProjectFiles(jirix)
+	if err != nil {
+		return err
+	}
+	var errs error
+	for _, dest := range stale {
+		// Only remove the files jiri created, not those which took their
+		// place.
+		if !created[dest] {
+			jirix.Logger.Debugf("Not removing %s, which is no longer copied or linked from a project, as jiri did not create it", dest)
+			continue
+		}
+		jirix.Logger.Debugf("Removing %s, which is no longer copied or linked from a project", dest)
+		if err := os.Remove(dest); err != nil && !os.IsNotExist(err) {
+			errs = errors.Join(errs, fmtError(err))
+			continue
+		}
+		delete(created, dest)
+	}
+
+	keys := make(ProjectKeys, 0, len(remoteProjects))
+	for key := range remoteProjects {
+		keys = append(keys, key)
+	}
+	sort.Sort(keys)
+	for _, key := range keys {
+		p := remoteProjects[key]
+		if skipped(key) {
+			continue
+		}
+		// Projects which failed to be checked out are reported elsewhere.
+		if _, err := os.Stat(p.Path); err != nil {
+			continue
+		}
+		for _, f := range p.CopyFiles {
+			dest := filepath.Join(jirix.Root, filepath.FromSlash(f.Dest))
+			err := checkProjectFileDest(jirix, p, dest, localProjects, remoteProjects)
+			if err == nil {
+				err = copyProjectFile(jirix, p, f, created[dest])
+			}
+			if err != nil {
+				errs = errors.Join(errs, fmt.Errorf("copyfile %q of project %q: %w", f.Src, p.Name, err))
+				continue
+			}
+			created[dest] = true
+		}
+		for _, f := range p.LinkFiles {
+			dest := filepath.Join(jirix.Root, filepath.FromSlash(f.Dest))
+			err := checkProjectFileDest(jirix, p, dest, localProjects, remoteProjects)
+			if err == nil {
+				err = linkProjectFile(jirix, p, f, created[dest])
+			}
+			if err != nil {
+				errs = errors.Join(errs, fmt.Errorf("linkfile %q of project %q: %w", f.Src, p.Name, err))
+				continue
+			}
+			created[dest] = true
+		}
+	}
+	return errors.Join(errs, writeProjectFiles(jirix, created))
+}
+
+// copyProjectFile copies f of project p, unless the copy is up to date. An
+// existing file is only replaced if jiri created it, as told by created.
+func copyProjectFile(jirix *jiri.X, p Project, f CopyFile, created bool) error {
+	src := filepath.Join(p.Path, filepath.FromSlash(f.Src))
+	dest := filepath.Join(jirix.Root, filepath.FromSlash(f.Dest))
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", src)
+	}
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Lstat(dest); err == nil {
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("%s already exists and is not a regular file", dest)
+		}
+		// A file with the same content is adopted, e.g. a copy made before
+		// jiri recorded them.
+		if old, err := os.ReadFile(dest); err == nil && bytes.Equal(old, data) {
+			return nil
+		}
+		if !created {
+			return fmt.Errorf("%s already exists and was not created by jiri", dest)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	// Remove any previous copy first, it may be read-only.
+	if err := os.Remove(dest); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.WriteFile(dest, data, info.Mode().Perm())
+}
+
+// linkProjectFile creates the symlink for f of project p, unless it already
+// exists. The symlink is relative, so that the jiri root can be moved. An
+// existing symlink is only replaced if jiri created it, as told by created.
+func linkProjectFile(jirix *jiri.X, p Project, f LinkFile, created bool) error {
+	src := filepath.Join(p.Path, filepath.FromSlash(f.Src))
+	dest := filepath.Join(jirix.Root, filepath.FromSlash(f.Dest))
+	target, err := filepath.Rel(filepath.Dir(dest), src)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Lstat(dest); err == nil {
+		if info.Mode()&os.ModeSymlink == 0 {
+			return fmt.Errorf("%s already exists and is not a symlink", dest)
+		}
+		if old, err := os.Readlink(dest); err == nil && old == target {
+			return nil
+		}
+		if !created {
+			return fmt.Errorf("%s already exists and was not created by jiri", dest)
+		}
+		if err := os.Remove(dest); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	return os.Symlink(target, dest)
+}
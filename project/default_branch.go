@@ -0,0 +1,121 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/manifestedit"
+)
+
+// manifestEditMu serializes the edits of manifest files by
+// migrateRemoteBranch, as several projects may be declared in the same file.
+var manifestEditMu sync.Mutex
+
+// remoteBranchRevision returns the revision of the remote branch of remote at
+// HEAD, given scm, the repository of local. If the branch no longer exists
+// upstream and the default branch of the remote was changed, e.g. from
+// "master" to "main", the user is warned and, if migrate is set, the project
+// is moved to the new default branch by migrateRemoteBranch. The default
+// branch is not looked up offline.
+func remoteBranchRevision(jirix *jiri.X, scm Git, local Project, remote *Project, migrate bool, localManifestProjects []string) (string, error) {
+	branch := remote.RemoteBranch
+	if branch == "" {
+		branch = "main"
+	}
+	rev, err := scm.CurrentRevisionForRef("remotes/" + local.PrimaryRemote() + "/" + branch)
+	if err == nil {
+		return rev, nil
+	}
+	// Looking up the default branch needs the remote, which is queried at
+	// the URL it is fetched from.
+	if jirix.Offline {
+		return "", err
+	}
+	defaultBranch, lsErr := scm.RemoteDefaultBranch(rewriteRemote(jirix, remote.Remote))
+	if lsErr != nil || defaultBranch == branch {
+		return "", err
+	}
+	if !migrate {
+		jirix.Logger.Warningf("Branch %q of project %s(%s) no longer exists, the default branch of %s is now %q.\nSet remotebranch=%q for the project in %s, or run \"jiri update -migrate-default-branch\".\n\n",
+			branch, remote.Name, local.Path, remote.Remote, defaultBranch, defaultBranch, remote.ManifestPath)
+		return "", err
+	}
+	return migrateRemoteBranch(jirix, scm, local, remote, branch, defaultBranch, localManifestProjects)
+}
+
+// migrateRemoteBranch moves remote from the remote branch from to to: the
+// local branches tracking from are made to track to and, if remote is
+// declared in a manifest of the working tree, its remotebranch attribute is
+// rewritten. It returns the revision of to.
+func migrateRemoteBranch(jirix *jiri.X, scm Git, local Project, remote *Project, from, to string, localManifestProjects []string) (string, error) {
+	remoteName := local.PrimaryRemote()
+	rev, err := scm.CurrentRevisionForRef("remotes/" + remoteName + "/" + to)
+	if err != nil {
+		return "", err
+	}
+	branches, err := scm.GetAllBranchesInfo()
+	if err != nil {
+		return "", err
+	}
+	for _, b := range branches {
+		// The tracking information of branches whose upstream is gone is
+		// not reported by GetAllBranchesInfo, so read their configuration.
+		if r, err := scm.ConfigGetKey("branch." + b.Name + ".remote"); err != nil || r != remoteName {
+			continue
+		}
+		if merge, err := scm.ConfigGetKey("branch." + b.Name + ".merge"); err != nil || merge != "refs/heads/"+from {
+			continue
+		}
+		if err := scm.SetUpstream(b.Name, remoteName+"/"+to); err != nil {
+			return "", err
+		}
+		jirix.Logger.Infof("Branch %q of project %s(%s) now tracks %s/%s\n", b.Name, remote.Name, local.Path, remoteName, to)
+	}
+	remote.RemoteBranch = to
+	// Manifests of projects which are not local manifest projects are
+	// checked out at the revision of their import, so editing them would
+	// only make the project dirty.
+	if remote.ManifestPath == "" || (remote.ImportedBy != "" && !slices.Contains(localManifestProjects, remote.ImportedBy)) {
+		jirix.Logger.Warningf("Default branch of %s is now %q, set remotebranch=%q for project %s in %s.\n\n", remote.Remote, to, to, remote.Name, remote.ManifestPath)
+		return rev, nil
+	}
+	manifestEditMu.Lock()
+	defer manifestEditMu.Unlock()
+	data, err := os.ReadFile(remote.ManifestPath)
+	if err != nil {
+		return "", err
+	}
+	content, err := setProjectAttr(string(data), remote.Name, "remotebranch", to)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", remote.ManifestPath, err)
+	}
+	if err := SafeWriteFile(jirix, remote.ManifestPath, []byte(content)); err != nil {
+		return "", err
+	}
+	if err := UpdateLockfileManifestDigest(jirix, remote.ManifestPath); err != nil {
+		return "", err
+	}
+	jirix.Logger.Infof("Set remotebranch=%q for project %s in %s\n", to, remote.Name, remote.ManifestPath)
+	return rev, nil
+}
+
+// setProjectAttr sets the attribute attr of the project name to value in
+// content, the text of a manifest, adding the attribute if needed. The rest
+// of content is left untouched.
+func setProjectAttr(content, name, attr, value string) (string, error) {
+	e, err := manifestedit.New([]byte(content))
+	if err != nil {
+		return "", err
+	}
+	if err := e.SetAttr(manifestedit.Elem("project", name), attr, value); err != nil {
+		return "", err
+	}
+	return e.String(), nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/cipd"
+)
+
+var envNameRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// envData is the data the value of an Env is expanded with.
+type envData struct {
+	// Root is the jiri root directory.
+	Root string
+	// OS and Arch describe the current platform in the format used for
+	// package paths, e.g. "linux" and "x64".
+	OS   string
+	Arch string
+}
+
+// envFuncs returns the functions available to Env values. The "package"
+// function returns the absolute path of the named package of pkgs.
+func envFuncs(jirix *jiri.X, pkgs Packages) template.FuncMap {
+	return template.FuncMap{
+		"package": func(name string) (string, error) {
+			var keys PackageKeys
+			for key, pkg := range pkgs {
+				if pkg.Name == name {
+					keys = append(keys, key)
+				}
+			}
+			if len(keys) == 0 {
+				return "", fmt.Errorf("package %q is not in the manifest", name)
+			}
+			// Pick a deterministic package if several versions are present.
+			sort.Sort(keys)
+			pkg := pkgs[keys[0]]
+			path, err := pkg.ResolvePath()
+			if err != nil {
+				return "", err
+			}
+			return filepath.Join(jirix.Root, path), nil
+		},
+	}
+}
+
+// Expand returns the value of e for the current platform. The value may
+// refer to {{.Root}}, {{.OS}}, {{.Arch}} and to the paths of pkgs with
+// {{package "name"}}.
+func (e Env) Expand(jirix *jiri.X, pkgs Packages) (string, error) {
+	tmpl, err := template.New(e.Name).Funcs(envFuncs(jirix, pkgs)).Parse(e.Value)
+	if err != nil {
+		return "", fmt.Errorf("parsing env %q failed: %v", e.Name, err)
+	}
+	platform := cipd.FuchsiaPlatform(cipd.CurrentPlatform)
+	data := envData{Root: jirix.Root, OS: platform.OS, Arch: platform.Arch}
+	var buf strings.Builder
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("expanding env %q failed: %v", e.Name, err)
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,144 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package project
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.fuchsia.dev/jiri"
+)
+
+// lastFailureFile is the file, in the root metadata directory, recording the
+// failures of the last update that failed.
+const lastFailureFile = "last_failure.json"
+
+// OperationError is the error of an operation of update on a project, e.g.
+// fetching or creating it. Its message is the one of Err.
+type OperationError struct {
+	Project   Project
+	Operation string
+	Err       error
+}
+
+func (e *OperationError) Error() string { return e.Err.Error() }
+func (e *OperationError) Unwrap() error { return e.Err }
+
+// UpdateFailure is a failure of an update, on a project if Path is set.
+type UpdateFailure struct {
+	Project   string `json:"project,omitempty"`
+	Path      string `json:"path,omitempty"`
+	Operation string `json:"operation,omitempty"`
+	Error     string `json:"error"`
+}
+
+// UpdateParams are the parameters of an update that "jiri last-failure
+// -rerun" needs to update the projects that failed the same way.
+type UpdateParams struct {
+	// Snapshot is the snapshot the update checked out, if any.
+	Snapshot              string   `json:"snapshot,omitempty"`
+	LocalManifestProjects []string `json:"local_manifest_projects,omitempty"`
+	Groups                string   `json:"groups,omitempty"`
+	ExcludedGroups        string   `json:"excluded_groups,omitempty"`
+	FetchingAttrs         string   `json:"fetching_attrs,omitempty"`
+	OverrideOptional      bool     `json:"override_optional,omitempty"`
+	RebaseTracked         bool     `json:"rebase_tracked,omitempty"`
+	RebaseUntracked       bool     `json:"rebase_untracked,omitempty"`
+	RebaseAll             bool     `json:"rebase_all,omitempty"`
+	AutoStash             bool     `json:"autostash,omitempty"`
+	ResetOnForcePush      bool     `json:"reset_on_force_push,omitempty"`
+}
+
+// LastFailure records the failures of the last update that failed.
+type LastFailure struct {
+	Time    time.Time `json:"time"`
+	Command string    `json:"command"`
+	// Params is nil for failures recorded by versions of jiri which did not
+	// record the parameters of the update.
+	Params   *UpdateParams   `json:"params,omitempty"`
+	Failures []UpdateFailure `json:"failures"`
+}
+
+// UpdateFailures splits errs, the errors of an update, into its failures,
+// one per project operation that failed.
+func UpdateFailures(errs ...error) []UpdateFailure {
+	var failures []UpdateFailure
+	seen := make(map[UpdateFailure]bool)
+	add := func(f UpdateFailure) {
+		if !seen[f] {
+			seen[f] = true
+			failures = append(failures, f)
+		}
+	}
+	var walk func(err error)
+	walk = func(err error) {
+		var opErr *OperationError
+		var dirty *jiri.DirtyTreeError
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			for _, e := range joined.Unwrap() {
+				walk(e)
+			}
+		} else if errors.As(err, &opErr) {
+			add(UpdateFailure{Project: opErr.Project.Name, Path: opErr.Project.Path, Operation: opErr.Operation, Error: opErr.Error()})
+		} else if errors.As(err, &dirty) {
+			add(UpdateFailure{Project: dirty.Project, Path: dirty.Path, Operation: updateOpKind, Error: dirty.Error()})
+		} else if err != nil {
+			add(UpdateFailure{Error: err.Error()})
+		}
+	}
+	for _, err := range errs {
+		walk(err)
+	}
+	return failures
+}
+
+func lastFailurePath(jirix *jiri.X) string {
+	return filepath.Join(jirix.RootMetaDir(), lastFailureFile)
+}
+
+// WriteLastFailure records failures as those of the last update, run by
+// command with params.
+func WriteLastFailure(jirix *jiri.X, command string, params UpdateParams, failures []UpdateFailure) error {
+	data, err := json.MarshalIndent(LastFailure{
+		Time:     time.Now(),
+		Command:  command,
+		Params:   &params,
+		Failures: failures,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return SafeWriteFile(jirix, lastFailurePath(jirix), append(data, '\n'))
+}
+
+// ReadLastFailure returns the failures of the last update that failed, or
+// nil if the last update succeeded.
+func ReadLastFailure(jirix *jiri.X) (*LastFailure, error) {
+	data, err := os.ReadFile(lastFailurePath(jirix))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmtError(err)
+	}
+	var last LastFailure
+	if err := json.Unmarshal(data, &last); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", lastFailureFile, err)
+	}
+	return &last, nil
+}
+
+// ClearLastFailure forgets the failures of the last update, once an update
+// succeeded.
+func ClearLastFailure(jirix *jiri.X) error {
+	if err := os.Remove(lastFailurePath(jirix)); err != nil && !os.IsNotExist(err) {
+		return fmtError(err)
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"go.fuchsia.dev/jiri"
+)
+
+func TestUpdateFailures(t *testing.T) {
+	fetchErr := &OperationError{Project: Project{Name: "a", Path: "/root/a"}, Operation: "fetch", Err: errors.New("fetch failed for a")}
+	createErr := &OperationError{Project: Project{Name: "b", Path: "/root/b"}, Operation: createOpKind, Err: errors.New("Creating project \"b\": boom")}
+	// The errors of the fast and the full scan of an update are joined, so
+	// the same failure may appear twice.
+	err := fmt.Errorf("%w, %w", errors.Join(fetchErr, createErr), errors.Join(fetchErr))
+	got := UpdateFailures(err, &jiri.DirtyTreeError{Project: "c", Path: "/root/c"}, errors.New("hook failed"))
+	want := []UpdateFailure{
+		{Project: "a", Path: "/root/a", Operation: "fetch", Error: "fetch failed for a"},
+		{Project: "b", Path: "/root/b", Operation: createOpKind, Error: "Creating project \"b\": boom"},
+		{Project: "c", Path: "/root/c", Operation: updateOpKind, Error: "project c(/root/c) contains uncommitted changes"},
+		{Error: "hook failed"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got failures %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"go.fuchsia.dev/jiri"
+)
+
+const (
+	// fetchCheckpointFile is the file, in the root metadata directory,
+	// recording the projects fetched by updates which did not succeed yet.
+	fetchCheckpointFile = "fetch_checkpoint.json"
+	// fetchCheckpointMaxAge is how long a fetch checkpoint is used, so that
+	// only immediate retries of an update skip fetching projects.
+	fetchCheckpointMaxAge = time.Hour
+)
+
+// fetchCheckpoint is the ledger of the projects fetched by an update, kept
+// until the update succeeds, so that retrying it, e.g. on a flaky builder,
+// only fetches the projects which failed to fetch.
+type fetchCheckpoint struct {
+	// Manifest is the digest of the projects of the manifest the projects
+	// were fetched for. The checkpoint is dropped once it changes.
+	Manifest string    `json:"manifest"`
+	Time     time.Time `json:"time"`
+	// Projects maps the keys of the fetched projects to their revision in
+	// the manifest.
+	Projects map[string]string `json:"projects"`
+
+	mu sync.Mutex
+}
+
+func fetchCheckpointPath(jirix *jiri.X) string {
+	return filepath.Join(jirix.RootMetaDir(), fetchCheckpointFile)
+}
+
+// manifestDigest returns a digest of the remote, branch and revision of
+// projects, which changes whenever the manifest changes what is fetched.
+func manifestDigest(projects Projects) string {
+	var keys ProjectKeys
+	for key := range projects {
+		keys = append(keys, key)
+	}
+	sort.Sort(keys)
+	h := sha256.New()
+	for _, key := range keys {
+		p := projects[key]
+		fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\n", key, p.Remote, p.RemoteBranch, p.Revision)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// readFetchCheckpoint returns the fetch checkpoint of the root for the
+// projects of the manifest, or an empty one if there is none or if it was
+// written for another manifest or too long ago.
+func readFetchCheckpoint(jirix *jiri.X, remoteProjects Projects) *fetchCheckpoint {
+	digest := manifestDigest(remoteProjects)
+	empty := &fetchCheckpoint{Manifest: digest, Projects: make(map[string]string)}
+	data, err := os.ReadFile(fetchCheckpointPath(jirix))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			jirix.Logger.Warningf("Ignoring the fetch checkpoint: %s\n\n", err)
+		}
+		return empty
+	}
+	c := &fetchCheckpoint{}
+	if err := json.Unmarshal(data, c); err != nil {
+		jirix.Logger.Warningf("Ignoring the invalid fetch checkpoint %s: %s\n\n", fetchCheckpointPath(jirix), err)
+		return empty
+	}
+	if c.Manifest != digest {
+		jirix.Logger.Debugf("Ignoring the fetch checkpoint, the manifest changed")
+		return empty
+	}
+	if time.Since(c.Time) > fetchCheckpointMaxAge {
+		jirix.Logger.Debugf("Ignoring the fetch checkpoint written at %s", c.Time.Format(time.RFC3339))
+		return empty
+	}
+	if c.Projects == nil {
+		c.Projects = make(map[string]string)
+	}
+	return c
+}
+
+// fetched returns true if the project of key was fetched at revision.
+func (c *fetchCheckpoint) fetched(key ProjectKey, revision string) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rev, ok := c.Projects[key.String()]
+	return ok && rev == revision
+}
+
+// record records that the project of key was fetched at revision.
+func (c *fetchCheckpoint) record(key ProjectKey, revision string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Projects[key.String()] = revision
+}
+
+func (c *fetchCheckpoint) write(jirix *jiri.X) error {
+	c.mu.Lock()
+	c.Time = time.Now()
+	data, err := json.MarshalIndent(c, "", "  ")
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return SafeWriteFile(jirix, fetchCheckpointPath(jirix), append(data, '\n'))
+}
+
+// clearFetchCheckpoint drops the fetch checkpoint of the root, once an
+// update succeeded.
+func clearFetchCheckpoint(jirix *jiri.X) error {
+	if err := os.Remove(fetchCheckpointPath(jirix)); err != nil && !os.IsNotExist(err) {
+		return fmtError(err)
+	}
+	return nil
+}
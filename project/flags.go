@@ -0,0 +1,238 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.fuchsia.dev/jiri"
+)
+
+// FlagMode is how WriteProjectFlags and WritePackageFlags write the flag
+// files of the "flag" attributes of projects and packages.
+type FlagMode int
+
+const (
+	// FlagsWrite writes the flag files, or none of them if manifest
+	// elements conflict on a file.
+	FlagsWrite FlagMode = iota
+	// FlagsDryRun only reports the flag files that would be written.
+	FlagsDryRun
+	// FlagsForce writes the flag files despite conflicts. The last of the
+	// conflicting writes of a file, in report order, wins.
+	FlagsForce
+)
+
+// flagMode returns the mode updates write flag files in.
+func flagMode(jirix *jiri.X) FlagMode {
+	if jirix.ForceFlags {
+		return FlagsForce
+	}
+	return FlagsWrite
+}
+
+// FlagWrite is the write of a flag file for the "flag" attribute of a
+// project or a package.
+type FlagWrite struct {
+	// File is the path of the flag file relative to the root.
+	File    string `json:"file"`
+	Content string `json:"content"`
+	// Kind is "project" or "package".
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// Source is the manifest declaring the project or package.
+	Source string `json:"source,omitempty"`
+}
+
+func (w FlagWrite) String() string {
+	s := fmt.Sprintf("%s %q", w.Kind, w.Name)
+	if w.Source != "" {
+		s += fmt.Sprintf(" (%s)", w.Source)
+	}
+	return fmt.Sprintf("%s writes %q", s, w.Content)
+}
+
+// FlagConflict is a flag file written with different contents by several
+// elements, or written by both projects and packages, in which case its
+// content depends on whether projects or packages are updated last.
+type FlagConflict struct {
+	File   string      `json:"file"`
+	Writes []FlagWrite `json:"writes"`
+}
+
+func (c FlagConflict) String() string {
+	var writes []string
+	for _, w := range c.Writes {
+		writes = append(writes, w.String())
+	}
+	return fmt.Sprintf("conflicting flags for file %q: %s", c.File, strings.Join(writes, ", "))
+}
+
+// FlagReport lists the flag files written, or that would be written, and
+// which manifest element writes each.
+type FlagReport struct {
+	Writes    []FlagWrite    `json:"writes"`
+	Conflicts []FlagConflict `json:"conflicts,omitempty"`
+}
+
+// newFlagReport returns the report of writes, sorted by file, with their
+// conflicts.
+func newFlagReport(writes []FlagWrite) *FlagReport {
+	sort.SliceStable(writes, func(i, j int) bool {
+		if writes[i].File != writes[j].File {
+			return writes[i].File < writes[j].File
+		}
+		if writes[i].Kind != writes[j].Kind {
+			return writes[i].Kind < writes[j].Kind
+		}
+		return writes[i].Name < writes[j].Name
+	})
+	r := &FlagReport{Writes: writes}
+	for i := 0; i < len(writes); {
+		j := i + 1
+		conflict := false
+		for ; j < len(writes) && writes[j].File == writes[i].File; j++ {
+			if writes[j].Content != writes[i].Content || writes[j].Kind != writes[i].Kind {
+				conflict = true
+			}
+		}
+		if conflict {
+			r.Conflicts = append(r.Conflicts, FlagConflict{File: writes[i].File, Writes: writes[i:j]})
+		}
+		i = j
+	}
+	if r.Writes == nil {
+		r.Writes = []FlagWrite{}
+	}
+	return r
+}
+
+// Err returns an error listing the conflicts of r, if any.
+func (r *FlagReport) Err() error {
+	if len(r.Conflicts) == 0 {
+		return nil
+	}
+	var msgs []string
+	for _, c := range r.Conflicts {
+		msgs = append(msgs, c.String())
+	}
+	return errors.New("encountered " + strings.Join(msgs, "\n"))
+}
+
+// write writes the flag files of r, as mode says.
+func (r *FlagReport) write(jirix *jiri.X, mode FlagMode) error {
+	if mode == FlagsDryRun {
+		return nil
+	}
+	if err := r.Err(); err != nil {
+		if mode != FlagsForce {
+			return err
+		}
+		jirix.Logger.Warningf("Writing flag files despite conflicts: %s\n\n", err)
+	}
+	files := make(map[string]string)
+	for _, w := range r.Writes {
+		files[w.File] = w.Content
+	}
+	var writeErrorBuf bytes.Buffer
+	for file, content := range files {
+		if err := SafeWriteFile(jirix, filepath.Join(jirix.Root, file), []byte(content)); err != nil {
+			writeErrorBuf.WriteString(fmt.Sprintf("write flag %q to file %q failed: %v\n", content, file, err))
+		}
+	}
+	if writeErrorBuf.Len() > 0 {
+		return errors.New(writeErrorBuf.String())
+	}
+	return nil
+}
+
+// projectFlagWrites returns the flag writes of projects. Unlike packages,
+// projects have no failure flags, as unfetchable projects are errors.
+func projectFlagWrites(jirix *jiri.X, projects Projects) ([]FlagWrite, error) {
+	var writes []FlagWrite
+	for _, v := range projects {
+		if v.Flag == "" {
+			continue
+		}
+		file, success, _, err := parseFlag(v.Flag)
+		if err != nil {
+			return nil, fmt.Errorf("unknown project flag format found in project %+v: %v", v, err)
+		}
+		writes = append(writes, FlagWrite{
+			File:    filepath.ToSlash(file),
+			Content: success,
+			Kind:    "project",
+			Name:    v.Name,
+			Source:  relativeToRoot(jirix, v.ManifestPath),
+		})
+	}
+	return writes, nil
+}
+
+// packageFlagWrites returns the flag writes of pkgs: the success flags of
+// those in pkgsWA, which are accessible, and the failure flags of the
+// others.
+func packageFlagWrites(jirix *jiri.X, pkgs, pkgsWA Packages) ([]FlagWrite, error) {
+	var writes []FlagWrite
+	for k, v := range pkgs {
+		if v.Flag == "" {
+			continue
+		}
+		file, success, failure, err := parseFlag(v.Flag)
+		if err != nil {
+			return nil, fmt.Errorf("unknown package flag format found in package %+v: %v", v, err)
+		}
+		content := failure
+		if _, ok := pkgsWA[k]; ok {
+			content = success
+		}
+		writes = append(writes, FlagWrite{
+			File:    filepath.ToSlash(file),
+			Content: content,
+			Kind:    "package",
+			Name:    v.Name,
+			Source:  relativeToRoot(jirix, v.ManifestPath),
+		})
+	}
+	return writes, nil
+}
+
+// CheckFlags returns the report of the flag files that updating to projects
+// and pkgs writes, with the conflicts between all of them, including those
+// between projects and packages, which writing the flags of each separately
+// does not detect. Packages are assumed to be accessible.
+func CheckFlags(jirix *jiri.X, projects Projects, pkgs Packages) (*FlagReport, error) {
+	writes, err := projectFlagWrites(jirix, projects)
+	if err != nil {
+		return nil, err
+	}
+	pkgWrites, err := packageFlagWrites(jirix, pkgs, pkgs)
+	if err != nil {
+		return nil, err
+	}
+	return newFlagReport(append(writes, pkgWrites...)), nil
+}
+
+// ListFlags loads the manifest files, as "jiri resolve" does with
+// resolveConfig, and returns the report of the flag files of their projects
+// and packages.
+func ListFlags(jirix *jiri.X, manifestFiles []string, resolveConfig ResolveConfig) (*FlagReport, error) {
+	projects, _, pkgs, _, err := loadManifestFiles(jirix, manifestFiles, resolveConfig.LocalManifestProjects())
+	if err != nil {
+		return nil, err
+	}
+	FilterProjectsPackagesByGroup(jirix, projects, pkgs)
+	if attrs, ok := resolveConfig.FetchingAttrs(); ok {
+		if err := FilterOptionalProjectsPackages(jirix, attrs, projects, pkgs); err != nil {
+			return nil, err
+		}
+	}
+	return CheckFlags(jirix, projects, pkgs)
+}
@@ -0,0 +1,78 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package project
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"go.fuchsia.dev/jiri"
+)
+
+// forcePushBackupRefPrefix prefixes the refs that local branches are saved
+// to before being reset onto a force-pushed upstream.
+const forcePushBackupRefPrefix = "refs/jiri/backups/"
+
+// forcePushedBase returns the previous revision of the remote-tracking branch
+// that branch tracks if its last update was not a fast-forward, that is, if
+// the remote branch was force-pushed, and branch is based on that previous
+// revision. It returns "" otherwise. The previous revision comes from the
+// reflog, so only the last fetch is checked.
+func forcePushedBase(scm Git, project Project, branch BranchState) string {
+	upstream := branch.Tracking
+	if upstream == nil || !strings.HasPrefix(upstream.Name, project.PrimaryRemote()+"/") {
+		return ""
+	}
+	previous, err := scm.CurrentRevisionForRef(upstream.Name + "@{1}")
+	if err != nil || previous == upstream.Revision {
+		return ""
+	}
+	if ff, err := scm.IsAncestor(previous, upstream.Revision); err != nil || ff {
+		return ""
+	}
+	if based, err := scm.IsAncestor(previous, branch.Revision); err != nil || !based {
+		return ""
+	}
+	return previous
+}
+
+// handleForcePush deals with branch, based on the previous revision of its
+// upstream before that was force-pushed. Rebasing or merging it would replay
+// the rewritten upstream commits, so it is either reset onto the new upstream
+// with its commits saved to a backup ref, if jirix.ResetOnForcePush is set,
+// or left alone with a warning describing the force-push.
+func handleForcePush(jirix *jiri.X, scm Git, project Project, relativePath string, branch BranchState, previous string) error {
+	upstream := branch.Tracking
+	msg := fmt.Sprintf("For project %s(%s), %q was force-pushed from %s to %s, which does not contain the previous revision.", project.Name, relativePath, upstream.Name, shortRevision(previous), shortRevision(upstream.Revision))
+	if !jirix.ResetOnForcePush {
+		gitCommand := jirix.Color.Yellow("git -C %q rebase --onto %s %s %s", relativePath, upstream.Name, previous, branch.Name)
+		msg += fmt.Sprintf("\nYour branch %q is based on the previous revision and was not updated. To move your commits onto the new upstream run\n%s", branch.Name, gitCommand)
+		msg += "\nor update with -reset-on-force-push to reset the branch to the new upstream, keeping your commits in a backup ref.\n\n"
+		jirix.Logger.Warningf("%s", msg)
+		jirix.IncrementFailures()
+		return nil
+	}
+	backup := fmt.Sprintf("%s%s/%d", forcePushBackupRefPrefix, branch.Name, time.Now().Unix())
+	if err := scm.UpdateRef(backup, branch.Revision); err != nil {
+		return err
+	}
+	if err := scm.Checkout(branch.Name); err != nil {
+		return err
+	}
+	if err := scm.Reset(upstream.Name); err != nil {
+		return err
+	}
+	msg += fmt.Sprintf("\nReset your branch %q to it, its previous revision %s is saved in %s.\n\n", branch.Name, shortRevision(branch.Revision), backup)
+	jirix.Logger.Warningf("%s", msg)
+	return nil
+}
+
+func shortRevision(revision string) string {
+	if len(revision) > 12 {
+		return revision[:12]
+	}
+	return revision
+}
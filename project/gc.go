@@ -0,0 +1,222 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package project
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.fuchsia.dev/jiri"
+)
+
+// GCParams controls which kinds of garbage GarbageCollect looks for and
+// whether it removes them.
+type GCParams struct {
+	// Force deletes orphaned projects even if they contain local branches,
+	// uncommitted changes or untracked files.
+	Force bool
+	// DryRun reports what would be removed without removing anything.
+	DryRun bool
+	// Cache prunes git cache directories that are not referenced by any
+	// project in the manifest. Caches may be shared between checkouts, so
+	// this is opt-in.
+	Cache bool
+	// Packages prunes package directories that were installed by earlier
+	// updates but are no longer referenced by the manifest.
+	Packages bool
+}
+
+// GCCandidates lists everything GarbageCollect considered removable.
+type GCCandidates struct {
+	Projects     []Project
+	CacheDirs    []string
+	PackagePaths []string
+}
+
+// GarbageCollect finds projects in the local checkout that are no longer
+// part of the manifest and deletes them, along with stale cache directories
+// and package paths if requested. Projects containing local work are only
+// deleted when params.Force is set. The returned candidates are everything
+// that was considered, whether or not it was removed.
+func GarbageCollect(jirix *jiri.X, params GCParams) (*GCCandidates, error) {
+	localProjects, err := LocalProjects(jirix, FullScan)
+	if err != nil {
+		return nil, err
+	}
+	remoteProjects, _, pkgs, err := LoadManifestFile(jirix, jirix.JiriManifestFile(), localProjects, nil)
+	if err != nil {
+		return nil, err
+	}
+	MatchLocalWithRemote(localProjects, remoteProjects)
+
+	candidates := &GCCandidates{}
+	if params.Cache {
+		// Collect cache candidates before optional projects are filtered
+		// out, so caches for projects behind attributes are kept.
+		if candidates.CacheDirs, err = staleCacheDirs(jirix, localProjects, remoteProjects); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := FilterOptionalProjectsPackages(jirix, jirix.FetchingAttrs, remoteProjects, pkgs); err != nil {
+		return nil, err
+	}
+	FilterProjectsPackagesByGroup(jirix, remoteProjects, pkgs)
+	for key, p := range localProjects {
+		if _, ok := remoteProjects[key]; !ok {
+			candidates.Projects = append(candidates.Projects, p)
+		}
+	}
+	// Visit nested projects before their parents.
+	sort.Slice(candidates.Projects, func(i, j int) bool {
+		return candidates.Projects[i].Path > candidates.Projects[j].Path
+	})
+
+	if params.Packages {
+		if candidates.PackagePaths, err = stalePackagePaths(jirix, remoteProjects, pkgs); err != nil {
+			return nil, err
+		}
+	}
+
+	if params.DryRun {
+		return candidates, nil
+	}
+
+	var ops []deleteOperation
+	for _, p := range candidates.Projects {
+		ops = append(ops, deleteOperation{
+			commonOperation: commonOperation{
+				project: p,
+				source:  p.Path,
+			},
+			force: params.Force,
+			keep:  nestedProjectPaths(p.Path, remoteProjects),
+		})
+	}
+	if err := runDeleteOperations(jirix, ops, true); err != nil {
+		return nil, err
+	}
+	for _, dir := range candidates.CacheDirs {
+		jirix.Logger.Debugf("Deleting cache directory %q", dir)
+		if err := os.RemoveAll(dir); err != nil {
+			return nil, fmtError(err)
+		}
+	}
+	for _, dir := range candidates.PackagePaths {
+		jirix.Logger.Debugf("Deleting package directory %q", dir)
+		if err := os.RemoveAll(dir); err != nil {
+			return nil, fmtError(err)
+		}
+		if err := removeEmptyParents(jirix, path.Dir(dir)); err != nil {
+			return nil, err
+		}
+	}
+	return candidates, nil
+}
+
+// staleCacheDirs returns the directories in the git cache that do not belong
+// to any local or manifest project.
+func staleCacheDirs(jirix *jiri.X, localProjects, remoteProjects Projects) ([]string, error) {
+	if jirix.Cache == "" {
+		return nil, nil
+	}
+	partialDir := filepath.Join(jirix.Cache, "partial")
+	inUse := map[string]bool{partialDir: true}
+	// The cache of package instances is in the git cache by default.
+	if jirix.CipdCacheDir != "" {
+		inUse[filepath.Clean(jirix.CipdCacheDir)] = true
+	}
+	for _, projects := range []Projects{localProjects, remoteProjects} {
+		for _, p := range projects {
+			dir, err := p.CacheDirPath(jirix)
+			if err != nil {
+				return nil, err
+			}
+			inUse[dir] = true
+		}
+	}
+
+	var stale []string
+	for _, root := range []string{jirix.Cache, partialDir} {
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmtError(err)
+		}
+		for _, e := range entries {
+			dir := filepath.Join(root, e.Name())
+			if e.IsDir() && !inUse[dir] {
+				stale = append(stale, dir)
+			}
+		}
+	}
+	return stale, nil
+}
+
+// stalePackagePaths returns package directories recorded in the update
+// history that still exist on disk but are no longer used by any package in
+// the manifest and do not overlap with a project.
+func stalePackagePaths(jirix *jiri.X, remoteProjects Projects, pkgs Packages) ([]string, error) {
+	var inUse []string
+	for _, p := range remoteProjects {
+		inUse = append(inUse, p.Path)
+	}
+	for _, pkg := range pkgs {
+		rel, err := pkg.ResolvePath()
+		if err != nil {
+			return nil, err
+		}
+		inUse = append(inUse, filepath.Join(jirix.Root, rel))
+	}
+	overlaps := func(dir string) bool {
+		for _, p := range inUse {
+			if p == dir || strings.HasPrefix(p, dir+string(filepath.Separator)) || strings.HasPrefix(dir, p+string(filepath.Separator)) {
+				return true
+			}
+		}
+		return false
+	}
+
+	entries, err := os.ReadDir(jirix.UpdateHistoryDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmtError(err)
+	}
+	seen := map[string]bool{}
+	var stale []string
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		m, err := ManifestFromFile(jirix, filepath.Join(jirix.UpdateHistoryDir(), e.Name()))
+		if err != nil {
+			jirix.Logger.Debugf("Skipping update history file %q: %s", e.Name(), err)
+			continue
+		}
+		for _, pkg := range m.Packages {
+			rel, err := pkg.ResolvePath()
+			if err != nil {
+				continue
+			}
+			dir := filepath.Join(jirix.Root, rel)
+			if seen[dir] || dir == jirix.Root || overlaps(dir) {
+				continue
+			}
+			seen[dir] = true
+			if _, err := os.Stat(dir); err == nil {
+				stale = append(stale, dir)
+			}
+		}
+	}
+	sort.Strings(stale)
+	return stale, nil
+}
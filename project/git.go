@@ -0,0 +1,98 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"net/url"
+
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/gitutil"
+)
+
+// Git is the interface to a git repository used by this package. It is
+// implemented by *gitutil.Git, which runs the git binary, and by
+// jiritest.FakeGit, which keeps repositories in memory.
+type Git interface {
+	AbsoluteGitDir() (string, error)
+	Add(file string) error
+	AddOrReplacePartialRemote(name, path string) error
+	AddOrReplaceRemote(name, path string) error
+	AddRemote(name, path string) error
+	Am(files ...string) error
+	AmAbort() error
+	ApplyPatch(file string, reverse bool) error
+	BranchExists(branch string) (bool, error)
+	CheckConnectivity() error
+	Checkout(ref string, opts ...gitutil.CheckoutOpt) error
+	Clone(repo, path string, opts ...gitutil.CloneOpt) error
+	CommitWithMessage(message string) error
+	Config(configArgs ...string) error
+	ConfigGetKey(key string) (string, error)
+	CountCommits(branch, base string) (int, error)
+	CredentialApprove(u *url.URL, c gitutil.Credential) error
+	CredentialFill(u *url.URL) (gitutil.Credential, error)
+	CredentialReject(u *url.URL, c gitutil.Credential) error
+	CurrentGitHooksPath() (string, error)
+	CurrentRevision() (string, error)
+	CurrentRevisionForRef(ref string) (string, error)
+	CurrentRevisionOfBranch(branch string) (string, error)
+	DeleteBranch(branch string, opts ...gitutil.DeleteBranchOpt) error
+	DeleteRemote(name string) error
+	Fetch(remote string, opts ...gitutil.FetchOpt) error
+	FetchRefspec(remote, refspec string, opts ...gitutil.FetchOpt) error
+	FilesWithUncommittedChanges() ([]string, error)
+	GetAllBranchesInfo() ([]gitutil.Branch, error)
+	GetBranches(args ...string) ([]string, string, error)
+	GetRemoteBranchesContaining(commit string) ([]string, error)
+	HasUncommittedChanges() (bool, error)
+	HasUntrackedFiles() (bool, error)
+	InProgress() (string, error)
+	Init(path string, opts ...gitutil.CloneOpt) error
+	IsAncestor(ancestor, descendant string) (bool, error)
+	IsRevAvailable(jirix *jiri.X, remote, rev string) bool
+	ListBranchesContainingRef(commit string) (map[string]bool, error)
+	ListNotes(ref string) (map[string]string, error)
+	ListRemoteBranchesContainingRef(commit string) (map[string]bool, error)
+	LocalConfig() ([][2]string, error)
+	LogRange(base, head, format string, max int) ([]string, error)
+	LsRemote(args ...string) (string, error)
+	Merge(branch string, opts ...gitutil.MergeOpt) error
+	ModifiedFiles(baseBranch, currentBranch string) ([]string, error)
+	PathObjectID(ref, path string) (string, error)
+	Push(remote, branch string, opts ...gitutil.PushOpt) error
+	ReadBlobs(blobs []string) (map[string]string, error)
+	Rebase(upstream string, opts ...gitutil.RebaseOpt) error
+	RebaseAbort() error
+	RemoteDefaultBranch(remote string) (string, error)
+	RemoveUntrackedFiles() error
+	RenameRemote(oldName, newName string) error
+	Repack(opts ...gitutil.RepackOpt) error
+	Reset(target string, opts ...gitutil.ResetOpt) error
+	SetHeadBranch(branch string) error
+	SetRemoteUrl(name, url string) error
+	SetUpstream(branch, upstream string) error
+	Show(ref, file string) (string, error)
+	StashApply(rev string) error
+	StashDrop(rev string) error
+	StashIndex(rev string) (int, error)
+	StashPush(message string) (string, error)
+	Status() (*gitutil.WorkingTreeStatus, error)
+	Submodules() ([]gitutil.Submodule, error)
+	SupportsBuiltinFSMonitor() bool
+	TopLevel() (string, error)
+	UpdateRef(ref, revision string) error
+	WorktreeRepair() error
+}
+
+// GitFactory creates the Git for the repository selected by opts. Tests set
+// one as the GitFactory of their jiri.X.
+type GitFactory func(jirix *jiri.X, opts ...gitutil.GitOpt) Git
+
+func newGit(jirix *jiri.X, opts ...gitutil.GitOpt) Git {
+	if jirix.GitFactory != nil {
+		return jirix.GitFactory.(GitFactory)(jirix, opts...)
+	}
+	return gitutil.New(jirix, opts...)
+}
@@ -0,0 +1,178 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package project
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"go.fuchsia.dev/jiri"
+)
+
+const generatedFileHeader = "# Generated by jiri from the git_attributes of projects. Do not edit.\n"
+
+// gitAttributeRE matches the attribute names git accepts in .gitattributes.
+var gitAttributeRE = regexp.MustCompile(`^[A-Za-z0-9_.][-A-Za-z0-9_.]*$`)
+
+// gitAttributesPath returns the path of p relative to the root, as used in
+// the generated files, or "" for a project at the root itself.
+func gitAttributesPath(jirix *jiri.X, p Project) string {
+	rel, err := filepath.Rel(jirix.Root, p.Path)
+	if err != nil || rel == "." || !filepath.IsLocal(rel) {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+// sortedGitAttributes returns the git attributes of p, sorted.
+func sortedGitAttributes(p Project) []string {
+	attrs := newAttributes(p.GitAttributes)
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// GitAttributesFileContent returns the content of a .gitattributes file
+// assigning the git attributes of each of projects to its path, with the
+// projects grouped by their attributes.
+func GitAttributesFileContent(jirix *jiri.X, projects Projects) []byte {
+	groups := make(map[string][]string)
+	for _, p := range projects {
+		path := gitAttributesPath(jirix, p)
+		attrs := sortedGitAttributes(p)
+		if path == "" || len(attrs) == 0 {
+			continue
+		}
+		key := strings.Join(attrs, " ")
+		groups[key] = append(groups[key], path)
+	}
+	var buf bytes.Buffer
+	buf.WriteString(generatedFileHeader)
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&buf, "\n# %s\n", strings.ReplaceAll(key, " ", ","))
+		paths := groups[key]
+		slices.Sort(paths)
+		for _, path := range paths {
+			fmt.Fprintf(&buf, "%s %s\n", path, key)
+		}
+	}
+	return buf.Bytes()
+}
+
+// CodeOwnersFileContent returns the content of a CODEOWNERS file routing the
+// path of each of projects to the owners that rules give to its git
+// attributes. Projects none of whose attributes have owners are left out.
+func CodeOwnersFileContent(jirix *jiri.X, projects Projects, rules []jiri.CodeOwnerRule) []byte {
+	owners := make(map[string][]string)
+	for _, r := range rules {
+		owners[r.Attribute] = append(owners[r.Attribute], strings.Fields(r.Owners)...)
+	}
+	routes := make(map[string][]string)
+	for _, p := range projects {
+		path := gitAttributesPath(jirix, p)
+		if path == "" {
+			continue
+		}
+		var pathOwners []string
+		for _, attr := range sortedGitAttributes(p) {
+			for _, o := range owners[attr] {
+				if !slices.Contains(pathOwners, o) {
+					pathOwners = append(pathOwners, o)
+				}
+			}
+		}
+		if len(pathOwners) > 0 {
+			routes[path] = pathOwners
+		}
+	}
+	var buf bytes.Buffer
+	buf.WriteString(generatedFileHeader)
+	if len(routes) > 0 {
+		buf.WriteString("\n")
+	}
+	paths := make([]string, 0, len(routes))
+	for path := range routes {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+	for _, path := range paths {
+		fmt.Fprintf(&buf, "/%s/ %s\n", path, strings.Join(routes[path], " "))
+	}
+	return buf.Bytes()
+}
+
+// rootRelativePath returns path, relative to the root unless absolute, as an
+// absolute path.
+func rootRelativePath(jirix *jiri.X, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(jirix.Root, path)
+}
+
+// GeneratedAttributeFiles returns the content of the files generated from
+// the git attributes of projects that are configured for the root, see
+// jiri.X.GitAttributesFile, keyed by their absolute path.
+func GeneratedAttributeFiles(jirix *jiri.X, projects Projects) map[string][]byte {
+	files := make(map[string][]byte)
+	if jirix.GitAttributesFile != "" {
+		files[rootRelativePath(jirix, jirix.GitAttributesFile)] = GitAttributesFileContent(jirix, projects)
+	}
+	if jirix.CodeOwnersFile != "" {
+		files[rootRelativePath(jirix, jirix.CodeOwnersFile)] = CodeOwnersFileContent(jirix, projects, jirix.CodeOwners)
+	}
+	return files
+}
+
+// writeGeneratedAttributeFiles refreshes the files generated from the git
+// attributes of projects, if any is configured.
+func writeGeneratedAttributeFiles(jirix *jiri.X, projects Projects) error {
+	for file, data := range GeneratedAttributeFiles(jirix, projects) {
+		if old, err := os.ReadFile(file); err == nil && bytes.Equal(old, data) {
+			continue
+		}
+		jirix.Logger.Debugf("Writing %s", file)
+		if err := SafeWriteFile(jirix, file, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckGitAttributes returns the problems with the git attributes of
+// projects: attributes that are not valid in .gitattributes, and
+// jirix.CodeOwners rules for attributes that no project has.
+func CheckGitAttributes(jirix *jiri.X, projects Projects) []string {
+	var problems []string
+	used := make(map[string]bool)
+	for _, p := range projects {
+		for _, attr := range sortedGitAttributes(p) {
+			used[attr] = true
+			if !gitAttributeRE.MatchString(attr) {
+				problems = append(problems, fmt.Sprintf("project %q has git attribute %q, which is not a valid .gitattributes attribute name", p.Name, attr))
+			}
+		}
+	}
+	for _, r := range jirix.CodeOwners {
+		if !used[r.Attribute] {
+			problems = append(problems, fmt.Sprintf("code owners %q are given to git attribute %q, which no project has", r.Owners, r.Attribute))
+		}
+	}
+	slices.Sort(problems)
+	return problems
+}
@@ -0,0 +1,63 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package project
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.fuchsia.dev/jiri"
+)
+
+func TestGeneratedAttributeFiles(t *testing.T) {
+	jirix := &jiri.X{Root: "/root"}
+	projects := Projects{}
+	for _, p := range []Project{
+		{Name: "a", Path: "/root/a", GitAttributes: "minimal,manifest"},
+		{Name: "b", Path: "/root/third_party/b", GitAttributes: "manifest,minimal"},
+		{Name: "c", Path: "/root/c", GitAttributes: "extras"},
+		{Name: "d", Path: "/root/d"},
+		{Name: "root", Path: "/root", GitAttributes: "manifest"},
+	} {
+		projects[p.Key()] = p
+	}
+
+	want := `# Generated by jiri from the git_attributes of projects. Do not edit.
+
+# extras
+c extras
+
+# manifest,minimal
+a manifest minimal
+third_party/b manifest minimal
+`
+	if diff := cmp.Diff(want, string(GitAttributesFileContent(jirix, projects))); diff != "" {
+		t.Errorf("wrong .gitattributes (-want +got):\n%s", diff)
+	}
+
+	rules := []jiri.CodeOwnerRule{
+		{Attribute: "minimal", Owners: "@core"},
+		{Attribute: "manifest", Owners: "@build @core"},
+	}
+	want = `# Generated by jiri from the git_attributes of projects. Do not edit.
+
+/a/ @build @core
+/third_party/b/ @build @core
+`
+	if diff := cmp.Diff(want, string(CodeOwnersFileContent(jirix, projects, rules))); diff != "" {
+		t.Errorf("wrong CODEOWNERS (-want +got):\n%s", diff)
+	}
+
+	jirix.CodeOwners = append(rules, jiri.CodeOwnerRule{Attribute: "unused", Owners: "@nobody"})
+	bad := Project{Name: "e", Path: "/root/e", GitAttributes: "-bad"}
+	projects[bad.Key()] = bad
+	wantProblems := []string{
+		`code owners "@nobody" are given to git attribute "unused", which no project has`,
+		`project "e" has git attribute "-bad", which is not a valid .gitattributes attribute name`,
+	}
+	if diff := cmp.Diff(wantProblems, CheckGitAttributes(jirix, projects)); diff != "" {
+		t.Errorf("wrong problems (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,243 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package project
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/envvar"
+	"go.fuchsia.dev/jiri/retry"
+)
+
+// HgProjectVCS is the VCS of Mercurial projects.
+const HgProjectVCS = "hg"
+
+var hgNodeRE = regexp.MustCompile("^[0-9a-f]{40}$")
+
+// hgVCS syncs Mercurial projects with the hg command. The remote of the
+// project is cloned into its path and updated to its revision, or to the
+// head of its remote branch, "default" unless set.
+type hgVCS struct{}
+
+func (hgVCS) Name() string {
+	return HgProjectVCS
+}
+
+func (hgVCS) Validate(p Project) error {
+	if p.Remote == "" {
+		return fmt.Errorf("bad project %q: hg projects need a remote", p.Name)
+	}
+	return nil
+}
+
+// hgTarget returns the revision p should be updated to.
+func hgTarget(p Project) string {
+	if p.Revision != "" && p.Revision != "HEAD" {
+		return p.Revision
+	}
+	// "main" is the default remote branch of jiri projects, which
+	// Mercurial calls "default".
+	if p.RemoteBranch != "" && p.RemoteBranch != "main" {
+		return p.RemoteBranch
+	}
+	return "default"
+}
+
+func (hgVCS) UpToDate(jirix *jiri.X, p Project) (bool, error) {
+	// Branches may have moved, only projects pinned to a node can be
+	// known to be up to date without pulling.
+	if !hgNodeRE.MatchString(p.Revision) {
+		return false, nil
+	}
+	if _, err := os.Stat(filepath.Join(p.Path, ".hg")); err != nil {
+		return false, nil
+	}
+	// Uncommitted changes are reported with a trailing "+".
+	node, err := runHg(jirix, p.Path, "identify", "--debug", "--id")
+	if err != nil {
+		return false, nil
+	}
+	return node == p.Revision, nil
+}
+
+func (hgVCS) Test(jirix *jiri.X, p Project) error {
+	if _, err := os.Stat(filepath.Join(p.Path, ".hg")); err != nil && jirix.Offline {
+		return fmt.Errorf("offline: hg project %q is not cloned at %q", p.Name, p.Path)
+	}
+	return nil
+}
+
+func (hgVCS) Sync(jirix *jiri.X, p Project) error {
+	// The URL rewrite rules of jiri may add credentials to the remote, so
+	// the rewritten remote is only given to the commands which need it,
+	// with its credentials in a config file, and the repository keeps the
+	// remote of the manifest. Remotes and paths follow "--", so that a
+	// manifest cannot pass them as options to hg.
+	remote, env, cleanup, err := hgAuth(jirix, jirix.RewriteURL(p.Name, rewriteRemote(jirix, p.Remote)))
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	if _, err := os.Stat(filepath.Join(p.Path, ".hg")); err != nil {
+		if err := os.MkdirAll(filepath.Dir(p.Path), 0755); err != nil {
+			return fmtError(err)
+		}
+		if _, err := runHg(jirix, "", "init", "--", p.Path); err != nil {
+			return err
+		}
+		hgrc := fmt.Sprintf("[paths]\ndefault = %s\n", p.Remote)
+		if err := os.WriteFile(filepath.Join(p.Path, ".hg", "hgrc"), []byte(hgrc), 0644); err != nil {
+			return fmtError(err)
+		}
+		if err := retry.Function(jirix, func() error {
+			_, err := runHgWithEnv(jirix, p.Path, env, "pull", "--", remote)
+			return err
+		}, fmt.Sprintf("Cloning %s", p.Remote), retry.AttemptsOpt(jirix.Attempts)); err != nil {
+			return &jiri.NetworkError{Err: err}
+		}
+	} else if !jirix.Offline {
+		if err := retry.Function(jirix, func() error {
+			_, err := runHgWithEnv(jirix, p.Path, env, "pull", "--", remote)
+			return err
+		}, fmt.Sprintf("Pulling %s", p.Remote), retry.AttemptsOpt(jirix.Attempts)); err != nil {
+			return &jiri.NetworkError{Err: err}
+		}
+	}
+	// --check refuses to update over uncommitted changes.
+	if _, err := runHg(jirix, p.Path, "update", "--check", "--rev="+hgTarget(p)); err != nil {
+		return fmt.Errorf("cannot update hg project %q at %q: %v", p.Name, p.Path, err)
+	}
+	return nil
+}
+
+func (hgVCS) LockRevision(jirix *jiri.X, p Project) (string, error) {
+	if hgNodeRE.MatchString(p.Revision) {
+		return p.Revision, nil
+	}
+	remote, env, cleanup, err := hgAuth(jirix, jirix.RewriteURL(p.Name, rewriteRemote(jirix, p.Remote)))
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+	node, err := runHgWithEnv(jirix, "", env, "identify", "--debug", "--id", "--rev="+hgTarget(p), "--", remote)
+	if err != nil {
+		return "", fmt.Errorf("cannot resolve %q of hg project %q: %v", hgTarget(p), p.Name, err)
+	}
+	return node, nil
+}
+
+func (hgVCS) ApplyLock(p *Project, revision string) error {
+	if p.Revision == "" || p.Revision == "HEAD" {
+		p.Revision = revision
+	} else if p.Revision != revision {
+		return fmt.Errorf("hg project %q has conflicting revisions in manifest and jiri.lock: %s:%s", p.Name, p.Revision, revision)
+	}
+	return nil
+}
+
+func (hgVCS) LocalChanges(jirix *jiri.X, p Project) (string, error) {
+	if _, err := os.Stat(filepath.Join(p.Path, ".hg")); err != nil {
+		return "", nil
+	}
+	var changes []string
+	if status, err := runHg(jirix, p.Path, "status"); err != nil {
+		return "", err
+	} else if status != "" {
+		changes = append(changes, "uncommitted changes")
+	}
+	// Changesets which were not pushed are not public. Unlike
+	// "hg outgoing", this doesn't need the remote.
+	if drafts, err := runHg(jirix, p.Path, "log", "--rev=draft() or secret()", "--template={node}\n"); err != nil {
+		return "", err
+	} else if drafts != "" {
+		changes = append(changes, "changesets which were not pushed")
+	}
+	return strings.Join(changes, " and "), nil
+}
+
+// hgAuth moves the credentials of remote, if any, to the [auth] section of a
+// temporary config file, which the returned environment adds to the config
+// files read by hg, and returns remote without them. cleanup removes the
+// file.
+func hgAuth(jirix *jiri.X, remote string) (string, map[string]string, func(), error) {
+	remote, user := splitCredentials(remote)
+	if user == nil {
+		return remote, nil, func() {}, nil
+	}
+	password, _ := user.Password()
+	if strings.ContainsAny(user.Username()+password, "\r\n") {
+		return "", nil, nil, fmt.Errorf("bad credentials for %s: they cannot hold line breaks", remote)
+	}
+	f, err := os.CreateTemp("", "jiri-hgrc-*")
+	if err != nil {
+		return "", nil, nil, fmtError(err)
+	}
+	cleanup := func() { os.Remove(f.Name()) }
+	hgrc := fmt.Sprintf("[auth]\njiri.prefix = %s\njiri.username = %s\n", remote, user.Username())
+	if password != "" {
+		hgrc += fmt.Sprintf("jiri.password = %s\n", password)
+	}
+	_, err = f.WriteString(hgrc)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, nil, fmtError(err)
+	}
+	// Setting HGRCPATH replaces the default config files, so they are
+	// listed along with the temporary file.
+	paths := jirix.Env()["HGRCPATH"]
+	if paths == "" {
+		var defaults []string
+		if runtime.GOOS != "windows" {
+			defaults = append(defaults, "/etc/mercurial/hgrc", "/etc/mercurial/hgrc.d")
+		}
+		if home, err := os.UserHomeDir(); err == nil {
+			defaults = append(defaults, filepath.Join(home, ".hgrc"), filepath.Join(home, "mercurial.ini"))
+		}
+		if dir, err := os.UserConfigDir(); err == nil {
+			defaults = append(defaults, filepath.Join(dir, "hg", "hgrc"))
+		}
+		paths = strings.Join(defaults, string(os.PathListSeparator))
+	}
+	env := map[string]string{"HGRCPATH": paths + string(os.PathListSeparator) + f.Name()}
+	return remote, env, cleanup, nil
+}
+
+// runHg runs hg with args in dir, and returns its trimmed output.
+func runHg(jirix *jiri.X, dir string, args ...string) (string, error) {
+	return runHgWithEnv(jirix, dir, nil, args...)
+}
+
+// runHgWithEnv is like runHg, with the variables of env added to the
+// environment of hg.
+func runHgWithEnv(jirix *jiri.X, dir string, env map[string]string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	command := exec.Command("hg", args...)
+	command.Dir = dir
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+	hgEnv := jirix.Env()
+	// Ignore the user configuration, which may change the output of hg.
+	hgEnv["HGPLAIN"] = "1"
+	for k, v := range env {
+		hgEnv[k] = v
+	}
+	command.Env = envvar.MapToSlice(hgEnv)
+	jirix.Logger.Tracef("Run: hg %s (%s)", strings.Join(args, " "), dir)
+	if err := command.Run(); err != nil {
+		return "", fmt.Errorf("hg %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
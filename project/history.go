@@ -0,0 +1,233 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.fuchsia.dev/jiri"
+)
+
+// HistorySnapshot is a snapshot in the update history directory.
+type HistorySnapshot struct {
+	// Name is the file name of the snapshot, the RFC3339 time of the update.
+	Name string
+	// Path is the absolute path of the snapshot file.
+	Path string
+	// Time is the time of the update parsed from Name.
+	Time time.Time
+}
+
+// ListUpdateHistory returns the snapshots in the update history directory,
+// newest first. The "latest" and "second-latest" links and files whose names
+// are not timestamps are not included.
+func ListUpdateHistory(jirix *jiri.X) ([]HistorySnapshot, error) {
+	entries, err := os.ReadDir(jirix.UpdateHistoryDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmtError(err)
+	}
+	var snapshots []HistorySnapshot
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, e.Name())
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, HistorySnapshot{
+			Name: e.Name(),
+			Path: filepath.Join(jirix.UpdateHistoryDir(), e.Name()),
+			Time: t,
+		})
+	}
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].Time.After(snapshots[j].Time)
+	})
+	return snapshots, nil
+}
+
+// ResolveUpdateHistory returns the path of the update history snapshot called
+// name. Besides snapshot names, "latest" and "second-latest" are accepted, as
+// well as "~N" for the Nth snapshot before the latest one.
+func ResolveUpdateHistory(jirix *jiri.X, name string) (string, error) {
+	switch name {
+	case "latest":
+		return jirix.UpdateHistoryLatestLink(), nil
+	case "second-latest":
+		return jirix.UpdateHistorySecondLatestLink(), nil
+	}
+	if suffix, ok := strings.CutPrefix(name, "~"); ok {
+		n, err := strconv.Atoi(suffix)
+		if err != nil || n < 0 || strings.HasPrefix(suffix, "+") {
+			return "", fmt.Errorf("invalid update history snapshot %q, want ~N for a number N", name)
+		}
+		snapshots, err := ListUpdateHistory(jirix)
+		if err != nil {
+			return "", err
+		}
+		if n >= len(snapshots) {
+			return "", fmt.Errorf("update history only has %d snapshots", len(snapshots))
+		}
+		return snapshots[n].Path, nil
+	}
+	path := filepath.Join(jirix.UpdateHistoryDir(), filepath.Base(name))
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("update history snapshot %q not found", name)
+		}
+		return "", fmtError(err)
+	}
+	return path, nil
+}
+
+// ReadUpdateSummary returns the summary of the update which wrote the update
+// history snapshot at path, as returned by ResolveUpdateHistory, from the
+// update history logs. It returns nil if the log of the update is not kept or
+// has no summary, e.g. because the update failed or predates summaries.
+func ReadUpdateSummary(jirix *jiri.X, path string) (*jiri.UpdateSummary, error) {
+	name := filepath.Base(path)
+	if _, err := time.Parse(time.RFC3339, name); err != nil {
+		// "latest" and "second-latest" are hard links to a snapshot.
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, fmtError(err)
+		}
+		snapshots, err := ListUpdateHistory(jirix)
+		if err != nil {
+			return nil, err
+		}
+		name = ""
+		for _, s := range snapshots {
+			if si, err := os.Stat(s.Path); err == nil && os.SameFile(fi, si) {
+				name = s.Name
+				break
+			}
+		}
+		if name == "" {
+			return nil, nil
+		}
+	}
+	t, err := time.Parse(time.RFC3339, name)
+	if err != nil {
+		return nil, nil
+	}
+
+	// The log of an update is written after its snapshot.
+	entries, err := os.ReadDir(jirix.UpdateHistoryLogDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmtError(err)
+	}
+	var logs []HistorySnapshot
+	for _, e := range entries {
+		if lt, err := time.Parse(time.RFC3339, e.Name()); err == nil && !e.IsDir() && !lt.Before(t) {
+			logs = append(logs, HistorySnapshot{Name: e.Name(), Path: filepath.Join(jirix.UpdateHistoryLogDir(), e.Name()), Time: lt})
+		}
+	}
+	sort.SliceStable(logs, func(i, j int) bool {
+		return logs[i].Time.Before(logs[j].Time)
+	})
+	for _, log := range logs {
+		summary, err := readUpdateSummary(log.Path)
+		if err != nil {
+			return nil, err
+		}
+		if summary != nil && summary.Snapshot == name {
+			return summary, nil
+		}
+	}
+	return nil, nil
+}
+
+// readUpdateSummary reads the summary appended to the update history log
+// file by appendUpdateSummary, if any.
+func readUpdateSummary(file string) (*jiri.UpdateSummary, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmtError(err)
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadString('\n')
+		if strings.TrimSpace(line) == updateSummaryHeader {
+			var summary jiri.UpdateSummary
+			if err := json.NewDecoder(r).Decode(&summary); err != nil {
+				return nil, fmt.Errorf("invalid update summary in %s: %v", file, err)
+			}
+			return &summary, nil
+		}
+		if err != nil {
+			return nil, nil
+		}
+	}
+}
+
+// retainedHistory returns the names of snapshots that the retention policy
+// keeps: the newest keep snapshots, and the newest snapshot of each day
+// within the last keepDays days. A zero value disables the respective rule.
+func retainedHistory(snapshots []HistorySnapshot, keep, keepDays int, now time.Time) map[string]bool {
+	retained := make(map[string]bool)
+	for i, s := range snapshots {
+		if i < keep {
+			retained[s.Name] = true
+		}
+	}
+	if keepDays > 0 {
+		cutoff := now.AddDate(0, 0, -keepDays)
+		days := make(map[string]bool)
+		// snapshots are sorted newest first, so the first one seen for a day
+		// is the last update of that day.
+		for _, s := range snapshots {
+			if s.Time.Before(cutoff) {
+				continue
+			}
+			day := s.Time.Local().Format(time.DateOnly)
+			if !days[day] {
+				days[day] = true
+				retained[s.Name] = true
+			}
+		}
+	}
+	return retained
+}
+
+// CompactUpdateHistory deletes the update history snapshots that are not kept
+// by the retention policy configured with jirix.HistoryKeep and
+// jirix.HistoryKeepDays. If neither is set, all snapshots are kept. The
+// "latest" and "second-latest" links are hard links and stay valid.
+func CompactUpdateHistory(jirix *jiri.X) error {
+	if jirix.HistoryKeep <= 0 && jirix.HistoryKeepDays <= 0 {
+		return nil
+	}
+	snapshots, err := ListUpdateHistory(jirix)
+	if err != nil {
+		return err
+	}
+	retained := retainedHistory(snapshots, jirix.HistoryKeep, jirix.HistoryKeepDays, time.Now())
+	for _, s := range snapshots {
+		if retained[s.Name] {
+			continue
+		}
+		jirix.Logger.Debugf("Deleting update history snapshot %s", s.Name)
+		if err := os.Remove(s.Path); err != nil && !os.IsNotExist(err) {
+			return fmtError(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/jiritest/xtest"
+	"go.fuchsia.dev/jiri/project"
+)
+
+// writeHistory creates empty update history snapshots for the given times and
+// returns their names.
+func writeHistory(t *testing.T, jirix *jiri.X, times ...time.Time) []string {
+	t.Helper()
+	if err := os.MkdirAll(jirix.UpdateHistoryDir(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, tm := range times {
+		name := tm.Format(time.RFC3339)
+		if err := os.WriteFile(filepath.Join(jirix.UpdateHistoryDir(), name), []byte("<manifest/>\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func historyNames(t *testing.T, jirix *jiri.X) []string {
+	t.Helper()
+	snapshots, err := project.ListUpdateHistory(jirix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, s := range snapshots {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
+func TestListUpdateHistory(t *testing.T) {
+	t.Parallel()
+
+	jirix := xtest.NewX(t)
+	now := time.Now().Truncate(time.Second)
+	names := writeHistory(t, jirix, now.Add(-2*time.Hour), now, now.Add(-time.Hour))
+	if err := os.WriteFile(jirix.UpdateHistoryLatestLink(), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{names[1], names[2], names[0]}
+	if got := historyNames(t, jirix); !reflect.DeepEqual(got, want) {
+		t.Errorf("ListUpdateHistory() = %v, want %v", got, want)
+	}
+
+	for name, want := range map[string]string{
+		"latest": jirix.UpdateHistoryLatestLink(),
+		"~0":     filepath.Join(jirix.UpdateHistoryDir(), names[1]),
+		"~2":     filepath.Join(jirix.UpdateHistoryDir(), names[0]),
+		names[2]: filepath.Join(jirix.UpdateHistoryDir(), names[2]),
+	} {
+		got, err := project.ResolveUpdateHistory(jirix, name)
+		if err != nil {
+			t.Errorf("ResolveUpdateHistory(%q) failed: %v", name, err)
+		} else if got != want {
+			t.Errorf("ResolveUpdateHistory(%q) = %q, want %q", name, got, want)
+		}
+	}
+	for _, name := range []string{"~3", "~0junk", "~-1", "~+1", "~", "no-such-snapshot"} {
+		if _, err := project.ResolveUpdateHistory(jirix, name); err == nil {
+			t.Errorf("ResolveUpdateHistory(%q) should have failed", name)
+		}
+	}
+}
+
+func TestCompactUpdateHistory(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now().Truncate(time.Second)
+	noon := time.Date(now.Year(), now.Month(), now.Day(), 12, 0, 0, 0, now.Location())
+	times := []time.Time{
+		noon.AddDate(0, 0, -10),
+		noon.AddDate(0, 0, -2).Add(-time.Hour),
+		noon.AddDate(0, 0, -2),
+		noon.AddDate(0, 0, -1).Add(-time.Hour),
+		noon.AddDate(0, 0, -1),
+	}
+
+	tests := []struct {
+		keep, keepDays int
+		want           []int
+	}{
+		{0, 0, []int{4, 3, 2, 1, 0}},
+		{2, 0, []int{4, 3}},
+		{0, 5, []int{4, 2}},
+		{1, 5, []int{4, 2}},
+		{3, 5, []int{4, 3, 2}},
+	}
+	for _, test := range tests {
+		jirix := xtest.NewX(t)
+		jirix.HistoryKeep = test.keep
+		jirix.HistoryKeepDays = test.keepDays
+		names := writeHistory(t, jirix, times...)
+		if err := project.CompactUpdateHistory(jirix); err != nil {
+			t.Fatal(err)
+		}
+		var want []string
+		for _, i := range test.want {
+			want = append(want, names[i])
+		}
+		if got := historyNames(t, jirix); !reflect.DeepEqual(got, want) {
+			t.Errorf("keep=%d keepDays=%d: got %v, want %v", test.keep, test.keepDays, got, want)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/cipd"
+	"go.fuchsia.dev/jiri/gitutil"
+)
+
+// HookRun records the last successful run of a hook, and the state of the
+// inputs of the hook it ran with.
+type HookRun struct {
+	Name        string `json:"name"`
+	Project     string `json:"project"`
+	Action      string `json:"action"`
+	Interpreter string `json:"interpreter,omitempty"`
+	Inputs      string `json:"inputs,omitempty"`
+	// Revision is the revision of the project of the hook.
+	Revision string `json:"revision"`
+	// InputRevisions maps the inputs of the hook to the object name of
+	// each in Revision, or "" for inputs which do not exist.
+	InputRevisions map[string]string `json:"input_revisions,omitempty"`
+	// PackageInstances maps the packages required by the hook to the
+	// instance IDs deployed for them, comma-separated.
+	PackageInstances map[string]string `json:"package_instances,omitempty"`
+	Time             time.Time         `json:"time"`
+}
+
+// hookRunKey returns the key of the run of h in the hook runs file.
+func hookRunKey(h Hook) string {
+	return h.Name + KeySeparator + h.ProjectName
+}
+
+// inputs returns the paths of the inputs of h.
+func (h Hook) inputs() []string {
+	var paths []string
+	for _, path := range strings.Split(h.Inputs, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			paths = append(paths, filepath.ToSlash(path))
+		}
+	}
+	return paths
+}
+
+// ReadHookRuns returns the last successful run of the hooks, keyed by hook
+// name and project.
+func ReadHookRuns(jirix *jiri.X) (map[string]HookRun, error) {
+	data, err := os.ReadFile(jirix.UpdateHistoryHookRunsFile())
+	if os.IsNotExist(err) {
+		return map[string]HookRun{}, nil
+	} else if err != nil {
+		return nil, fmtError(err)
+	}
+	runs := map[string]HookRun{}
+	if err := json.Unmarshal(data, &runs); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", jirix.UpdateHistoryHookRunsFile(), err)
+	}
+	return runs, nil
+}
+
+func writeHookRuns(jirix *jiri.X, runs map[string]HookRun) error {
+	data, err := json.MarshalIndent(runs, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(jirix.UpdateHistoryDir(), 0755); err != nil {
+		return fmtError(err)
+	}
+	return SafeWriteFile(jirix, jirix.UpdateHistoryHookRunsFile(), append(data, '\n'))
+}
+
+// newHookRun returns the run of h with the current state of its inputs,
+// including the instances of the packages it requires among deployed. It
+// returns false if the state of the inputs cannot be known, e.g. because
+// the project of the hook is not a git repository, in which case the hook
+// always runs.
+func newHookRun(jirix *jiri.X, h Hook, deployed []cipd.DeployedPackage) (HookRun, bool) {
+	run := HookRun{
+		Name:        h.Name,
+		Project:     h.ProjectName,
+		Action:      h.Action,
+		Interpreter: h.Interpreter,
+		Inputs:      h.Inputs,
+	}
+	scm := newGit(jirix, gitutil.RootDirOpt(h.ActionPath))
+	revision, err := scm.CurrentRevision()
+	if err != nil {
+		return run, false
+	}
+	run.Revision = revision
+	for _, input := range h.inputs() {
+		id, err := scm.PathObjectID(revision, input)
+		if err != nil {
+			return run, false
+		}
+		if run.InputRevisions == nil {
+			run.InputRevisions = make(map[string]string)
+		}
+		run.InputRevisions[input] = id
+	}
+	for _, name := range h.RequiredPackages() {
+		resolved, err := cipd.CurrentPlatform.Resolver().Resolve(name)
+		if err != nil {
+			return run, false
+		}
+		var ids []string
+		for _, pkg := range deployed {
+			if pkg.PackageName == resolved {
+				ids = append(ids, pkg.InstanceID)
+			}
+		}
+		if len(ids) == 0 {
+			return run, false
+		}
+		if run.PackageInstances == nil {
+			run.PackageInstances = make(map[string]string)
+		}
+		run.PackageInstances[name] = strings.Join(ids, ",")
+	}
+	return run, true
+}
+
+// unchangedSince returns true if the hook of run has the same definition as
+// when it ran last, and its inputs did not change since: the instances of
+// the packages it requires, and the files at the paths of its inputs, if it
+// declares some, or its whole project otherwise.
+func (run HookRun) unchangedSince(last HookRun) bool {
+	if run.Action != last.Action || run.Interpreter != last.Interpreter || run.Inputs != last.Inputs {
+		return false
+	}
+	if !maps.Equal(run.PackageInstances, last.PackageInstances) {
+		return false
+	}
+	if run.Inputs == "" {
+		return run.Revision == last.Revision
+	}
+	return maps.Equal(run.InputRevisions, last.InputRevisions)
+}
+
+// runChangedHooks runs the hooks whose inputs changed since their last
+// successful run, or all of them if force is set, and records the runs of
+// those which succeed.
+func runChangedHooks(jirix *jiri.X, hooks Hooks, runHookTimeout uint, force bool) error {
+	last, err := ReadHookRuns(jirix)
+	if err != nil {
+		return err
+	}
+	// Hooks requiring packages always run if the deployed packages cannot
+	// be read.
+	deployed, err := cipd.Deployed(jirix.Root)
+	if err != nil {
+		jirix.Logger.Debugf("Cannot read the deployed packages: %v", err)
+	}
+	toRun := make(Hooks)
+	current := make(map[HookKey]HookRun)
+	for key, hook := range hooks {
+		run, ok := newHookRun(jirix, hook, deployed)
+		if ok {
+			current[key] = run
+		}
+		if prev, found := last[hookRunKey(hook)]; ok && found && !force && run.unchangedSince(prev) {
+			jirix.Logger.Component("hooks").Debugf("Skipping hook(%s) for project %q, its inputs did not change since %s", hook.Name, hook.ProjectName, prev.Time.Format(time.RFC3339))
+			jirix.RecordHook(jiri.HookSummary{Name: hook.Name, Project: hook.ProjectName, Skipped: true})
+			continue
+		}
+		toRun[key] = hook
+	}
+	if skipped := len(hooks) - len(toRun); skipped != 0 {
+		jirix.Logger.Infof("Skipped %d hook(s) whose inputs did not change, run 'jiri update -force-hooks' to run them\n", skipped)
+	}
+	if len(toRun) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	err = runHooks(jirix, toRun, runHookTimeout, func(hook Hook) {
+		if run, ok := current[hook.Key()]; ok {
+			run.Time = now
+			last[hookRunKey(hook)] = run
+		}
+	})
+	if err2 := writeHookRuns(jirix, last); err2 != nil {
+		jirix.Logger.Warningf("Failed to record the runs of hooks: %s\n\n", err2)
+	}
+	return err
+}
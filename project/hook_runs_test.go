@@ -0,0 +1,76 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/cipd"
+	"go.fuchsia.dev/jiri/color"
+	"go.fuchsia.dev/jiri/log"
+	"go.fuchsia.dev/jiri/tool"
+)
+
+// TestHookRunPackages checks that a hook requiring a package runs again once
+// another instance of the package is deployed.
+func TestHookRunPackages(t *testing.T) {
+	jirix := &jiri.X{
+		Context: tool.NewDefaultContext(),
+		Root:    t.TempDir(),
+		Logger:  log.NewLogger(log.InfoLevel, color.NewColor(color.ColorNever), false, 0, time.Second, os.Stdout, os.Stderr),
+	}
+	dir := t.TempDir()
+	for _, args := range [][]string{
+		{"init"},
+		{"-c", "user.name=John Doe", "-c", "user.email=john.doe@example.com", "commit", "--allow-empty", "-m", "init"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	pkgDir := filepath.Join(jirix.Root, ".cipd", "pkgs", "0")
+	write := func(path, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deploy := func(instanceID string) []cipd.DeployedPackage {
+		t.Helper()
+		write(filepath.Join(pkgDir, instanceID, ".cipdpkg", "manifest.json"), `{"files": []}`)
+		write(filepath.Join(pkgDir, "_current.txt"), instanceID)
+		deployed, err := cipd.Deployed(jirix.Root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return deployed
+	}
+	write(filepath.Join(pkgDir, "description.json"), `{"subdir": "prebuilt/tool", "package_name": "fuchsia/tool/`+cipd.CurrentPlatform.String()+`"}`)
+
+	hook := Hook{Name: "setup", ProjectName: "p", Action: "setup.sh", ActionPath: dir, RequiresPackage: "fuchsia/tool/${platform}"}
+	first, ok := newHookRun(jirix, hook, deploy("instance-1"))
+	if !ok {
+		t.Fatalf("the state of the hook should be known")
+	}
+	if run, ok := newHookRun(jirix, hook, deploy("instance-1")); !ok || !run.unchangedSince(first) {
+		t.Errorf("the hook should be unchanged with the same package instance, got %+v, want %+v", run, first)
+	}
+	if run, ok := newHookRun(jirix, hook, deploy("instance-2")); !ok || run.unchangedSince(first) {
+		t.Errorf("the hook should run again with a new package instance, got %+v", run)
+	}
+	if _, ok := newHookRun(jirix, hook, nil); ok {
+		t.Errorf("a hook whose package is not deployed should always run")
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package project
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.fuchsia.dev/jiri"
+)
+
+// hostLimiter schedules network operations so that at most jirix.Jobs of them
+// run at once, and each remote host gets no more than its configured jobs and
+// bandwidth, see jiri.HostLimit.
+//
+// git has no way to cap its download rate, so bandwidth is capped on average:
+// the size of the packs downloaded by each operation is measured, and the
+// next operations on the host are held back until the host is within its
+// rate again.
+type hostLimiter struct {
+	jirix *jiri.X
+	jobs  chan struct{}
+
+	mu    sync.Mutex
+	hosts map[string]*hostState
+}
+
+type hostState struct {
+	// jobs is nil if the host has no limit of its own.
+	jobs chan struct{}
+	// rate is the bandwidth cap in bytes per second, zero for no cap.
+	rate int64
+
+	mu sync.Mutex
+	// next is when the bytes already downloaded from the host are paid
+	// for, according to rate.
+	next time.Time
+}
+
+func newHostLimiter(jirix *jiri.X) *hostLimiter {
+	return &hostLimiter{
+		jirix: jirix,
+		jobs:  make(chan struct{}, jirix.Jobs),
+		hosts: make(map[string]*hostState),
+	}
+}
+
+func (l *hostLimiter) host(name string) *hostState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if h, ok := l.hosts[name]; ok {
+		return h
+	}
+	h := &hostState{}
+	limit := l.jirix.HostLimitFor(name)
+	if limit.Jobs != 0 {
+		h.jobs = make(chan struct{}, limit.Jobs)
+	}
+	if limit.Bandwidth != "" {
+		rate, err := jiri.ParseBandwidth(limit.Bandwidth)
+		if err != nil {
+			l.jirix.Logger.Warningf("Ignoring the bandwidth limit of host %s: %v\n\n", name, err)
+		}
+		h.rate = rate
+	}
+	l.hosts[name] = h
+	return h
+}
+
+// acquire blocks until an operation downloading from remote into the git
+// repository at dir can start, and returns the function to call once it is
+// done. Operations on local remotes, such as caches, are only subject to the
+// global limit.
+func (l *hostLimiter) acquire(remote, dir string) func() {
+	h := l.host(jiri.RemoteHost(remote))
+	// Wait for the host first, so that operations queued for a busy host
+	// don't hold global slots that others could use.
+	if h.jobs != nil {
+		h.jobs <- struct{}{}
+	}
+	h.wait()
+	l.jobs <- struct{}{}
+	size := int64(0)
+	if h.rate != 0 {
+		size = packSize(dir)
+	}
+	return func() {
+		<-l.jobs
+		if h.rate != 0 {
+			h.charge(packSize(dir) - size)
+		}
+		if h.jobs != nil {
+			<-h.jobs
+		}
+	}
+}
+
+// wait blocks until the host is within its bandwidth cap.
+func (h *hostState) wait() {
+	if h.rate == 0 {
+		return
+	}
+	h.mu.Lock()
+	next := h.next
+	h.mu.Unlock()
+	if d := time.Until(next); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// charge accounts for n bytes downloaded from the host.
+func (h *hostState) charge(n int64) {
+	if n <= 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	if h.next.Before(now) {
+		h.next = now
+	}
+	h.next = h.next.Add(time.Duration(float64(n) / float64(h.rate) * float64(time.Second)))
+}
+
+// packSize returns the total size of the packs of the git repository at dir,
+// bare or not.
+func packSize(dir string) int64 {
+	packDir := filepath.Join(dir, ".git", "objects", "pack")
+	if _, err := os.Stat(packDir); err != nil {
+		packDir = filepath.Join(dir, "objects", "pack")
+	}
+	entries, err := os.ReadDir(packDir)
+	if err != nil {
+		return 0
+	}
+	var size int64
+	for _, e := range entries {
+		if filepath.Ext(e.Name()) != ".pack" {
+			continue
+		}
+		if info, err := e.Info(); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
@@ -0,0 +1,60 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package project_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/jiritest/xtest"
+	"go.fuchsia.dev/jiri/project"
+)
+
+// TestHostLimiter checks that operations are limited per host, and globally.
+func TestHostLimiter(t *testing.T) {
+	t.Parallel()
+
+	jirix := xtest.NewX(t)
+	jirix.Jobs = 3
+	jirix.HostLimits = []jiri.HostLimit{{Host: "slow.example.com", Jobs: 1}}
+	acquire := project.InternalHostLimiter(jirix)
+
+	var mu sync.Mutex
+	running := make(map[string]int)
+	maxRunning := make(map[string]int)
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		for _, remote := range []string{"https://slow.example.com/repo", "https://fast.example.com/repo"} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				host := jiri.RemoteHost(remote)
+				release := acquire(remote, t.TempDir())
+				mu.Lock()
+				running[host]++
+				running["all"]++
+				for _, k := range []string{host, "all"} {
+					maxRunning[k] = max(maxRunning[k], running[k])
+				}
+				mu.Unlock()
+				time.Sleep(10 * time.Millisecond)
+				mu.Lock()
+				running[host]--
+				running["all"]--
+				mu.Unlock()
+				release()
+			}()
+		}
+	}
+	wg.Wait()
+	if got := maxRunning["slow.example.com"]; got != 1 {
+		t.Errorf("got %d concurrent operations on slow.example.com, want 1", got)
+	}
+	if got := maxRunning["all"]; got > 3 {
+		t.Errorf("got %d concurrent operations, want at most 3", got)
+	}
+}
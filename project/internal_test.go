@@ -4,5 +4,16 @@
 
 package project
 
+import "go.fuchsia.dev/jiri"
+
 // InternalWriteMetadata exports writeMetadata for tests.
 var InternalWriteMetadata = writeMetadata
+
+// InternalHostLimiter returns the acquire method of a new hostLimiter for
+// tests.
+func InternalHostLimiter(jirix *jiri.X) func(remote, dir string) func() {
+	return newHostLimiter(jirix).acquire
+}
+
+// InternalUnpackArchive exports unpackArchive for tests.
+var InternalUnpackArchive = unpackArchive
@@ -0,0 +1,105 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package project
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/cipd"
+)
+
+// Interpreter maps the actions of hooks with an extension to the command
+// running them, so that hooks written as scripts also run on hosts that
+// cannot execute them directly, such as Windows.
+type Interpreter struct {
+	// Extension is the extension of the actions, e.g. ".py".
+	Extension string `xml:"extension,attr"`
+	// Command is the command line running the action, which is appended to
+	// it. Its first word is a path relative to the jiri root if it contains
+	// a slash, e.g. the python binary of a package, and is otherwise looked
+	// up in PATH. ${platform}, ${os} and ${arch} are expanded like in
+	// package names.
+	Command string `xml:"command,attr"`
+	// OS restricts the mapping to hosts running this operating system, as
+	// named by Go, e.g. "windows". Empty means all of them.
+	OS      string   `xml:"os,attr,omitempty"`
+	XMLName struct{} `xml:"interpreter"`
+}
+
+// Interpreters maps the keys of interpreters to them.
+type Interpreters map[string]Interpreter
+
+// Key returns the key of i, unique per extension and operating system.
+func (i Interpreter) Key() string {
+	return i.OS + KeySeparator + i.Extension
+}
+
+func (i *Interpreter) validate() error {
+	if !strings.HasPrefix(i.Extension, ".") || strings.ContainsAny(i.Extension, `/\`) {
+		return fmt.Errorf("bad interpreter: extension should start with a dot, got %q", i.Extension)
+	}
+	if strings.TrimSpace(i.Command) == "" {
+		return fmt.Errorf("bad interpreter for %q: command is required", i.Extension)
+	}
+	return nil
+}
+
+// defaultInterpreters are the interpreters of the extensions Windows cannot
+// execute, used when the manifest doesn't map them.
+var defaultInterpreters = map[string]string{
+	".sh":  "bash",
+	".py":  "python3",
+	".ps1": "powershell -NoProfile -ExecutionPolicy Bypass -File",
+}
+
+// interpreterFor returns the command mapped to the extension of action for
+// this host by interpreters, or "" if there is none.
+func (interpreters Interpreters) interpreterFor(action string) string {
+	ext := filepath.Ext(action)
+	if ext == "" {
+		return ""
+	}
+	if i, ok := interpreters[runtime.GOOS+KeySeparator+ext]; ok {
+		return i.Command
+	}
+	if i, ok := interpreters[KeySeparator+ext]; ok {
+		return i.Command
+	}
+	return ""
+}
+
+// hookCommand returns the command running hook, which is killed when ctx is
+// done.
+func hookCommand(ctx context.Context, jirix *jiri.X, hook Hook) (*exec.Cmd, error) {
+	action := filepath.Join(hook.ActionPath, filepath.FromSlash(hook.Action))
+	interpreter := hook.Interpreter
+	if interpreter == "" {
+		interpreter = hook.InterpreterCommand
+	}
+	if interpreter == "" && runtime.GOOS == "windows" {
+		interpreter = defaultInterpreters[strings.ToLower(filepath.Ext(action))]
+	}
+	if interpreter == "" {
+		return exec.CommandContext(ctx, action), nil
+	}
+	resolved, err := cipd.CurrentPlatform.Resolver().Resolve(interpreter)
+	if err != nil {
+		return nil, fmt.Errorf("bad interpreter %q of hook %q: %v", interpreter, hook.Name, err)
+	}
+	args := strings.Fields(resolved)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("bad interpreter %q of hook %q: it is blank on this platform", interpreter, hook.Name)
+	}
+	if strings.Contains(args[0], "/") && !filepath.IsAbs(args[0]) {
+		args[0] = filepath.Join(jirix.Root, filepath.FromSlash(args[0]))
+	}
+	return exec.CommandContext(ctx, args[0], append(args[1:], action)...), nil
+}
@@ -6,7 +6,9 @@ package project
 
 import (
 	"bytes"
+	"encoding/xml"
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -17,6 +19,23 @@ import (
 	"go.fuchsia.dev/jiri/gitutil"
 )
 
+// recordManifestDigest records the sha256 of the content of the manifest file,
+// for lockfiles to record the manifests they were resolved from.
+func (ld *loader) recordManifestDigest(jirix *jiri.X, file string, data []byte) {
+	lock := manifestLock(jirix, file, data)
+	ld.ManifestDigests[lock.Path] = lock
+}
+
+// projectHooksFile is the file, relative to the root of a project, where the
+// project declares its own hooks.
+const projectHooksFile = ".jiri/hooks.xml"
+
+// projectHooks is the content of a projectHooksFile.
+type projectHooks struct {
+	Hooks   []Hook   `xml:"hook"`
+	XMLName struct{} `xml:"hooks"`
+}
+
 type importCache struct {
 	localManifest bool
 	ref           string
@@ -30,10 +49,21 @@ type loader struct {
 	Projects         Projects
 	ProjectOverrides map[string]Project
 	ImportOverrides  map[string]Import
+	HookOverrides    map[HookKey]Hook
+	PackageOverrides map[string]Package
 	ProjectLocks     ProjectLocks
 	Hooks            Hooks
 	Packages         Packages
 	PackageLocks     PackageLocks
+	PackageAllowList []PackageAllow
+	HostKeys         []HostKey
+	ProjectGroups    map[string][]string
+	PackageGroups    map[string][]string
+	Envs             Envs
+	Interpreters     Interpreters
+	UploadChecks     []UploadCheck
+	CIPDClient       *CIPDClient
+	ManifestDigests  ManifestLocks
 	TmpDir           string
 	localProjects    Projects
 	importProjects   Projects
@@ -44,6 +74,12 @@ type loader struct {
 	manifests        map[string]bool
 	lockfiles        map[string]bool
 	parentFile       string
+	// vars are the variables of the manifests importing the manifest being
+	// loaded, which override its own.
+	vars map[string]string
+	// localManifests maps the names of the local manifest projects whose
+	// manifests were loaded from their working trees to those manifests.
+	localManifests map[string]string
 }
 
 type importTreeNode struct {
@@ -218,10 +254,17 @@ func newManifestLoader(localProjects Projects, update bool, file string) *loader
 		Projects:         make(Projects),
 		ProjectOverrides: make(map[string]Project),
 		ImportOverrides:  make(map[string]Import),
+		HookOverrides:    make(map[HookKey]Hook),
+		PackageOverrides: make(map[string]Package),
 		ProjectLocks:     make(ProjectLocks),
 		Hooks:            make(Hooks),
 		Packages:         make(Packages),
 		PackageLocks:     make(PackageLocks),
+		ProjectGroups:    make(map[string][]string),
+		PackageGroups:    make(map[string][]string),
+		Envs:             make(Envs),
+		Interpreters:     make(Interpreters),
+		ManifestDigests:  make(ManifestLocks),
 		localProjects:    localProjects,
 		importProjects:   make(Projects),
 		update:           update,
@@ -230,6 +273,7 @@ func newManifestLoader(localProjects Projects, update bool, file string) *loader
 		lockfiles:        make(map[string]bool),
 		importTree:       newImportTree(),
 		parentFile:       file,
+		localManifests:   make(map[string]string),
 	}
 }
 
@@ -308,6 +352,9 @@ func (ld *loader) Load(jirix *jiri.X, root, repoPath, file, ref, cycleKey string
 }
 
 func (ld *loader) cloneManifestRepo(jirix *jiri.X, remote *Import, cacheDirPath string, localManifest bool) error {
+	if jirix.Offline {
+		return fmt.Errorf("offline: manifest project %q is not checked out locally", remote.Name)
+	}
 	if !ld.update || localManifest {
 		jirix.Logger.Warningf("import %q not found locally, getting from server. Please check your manifest file (default: .jiri_manifest).\nMake sure that the 'name' attributes on the 'import' and 'project' tags match and that there is a corresponding 'project' tag for every 'import' tag.\n\n", remote.Name)
 	}
@@ -337,21 +384,24 @@ func (ld *loader) cloneManifestRepo(jirix *jiri.X, remote *Import, cacheDirPath
 		jirix.Logger.Debugf("%s", logStr)
 		task := jirix.Logger.AddTaskMsg("%s", logStr)
 		defer task.Done()
-		if err := updateOrCreateCache(jirix, cacheDirPath, remoteUrl, remote.RemoteBranch, remote.Revision, 0); err != nil {
+		if err := updateOrCreateCache(jirix, remote.Name, cacheDirPath, remoteUrl, remote.RemoteBranch, nil, remote.Revision, "", 0, ""); err != nil {
 			return err
 		}
 	}
 	opts := []gitutil.CloneOpt{gitutil.ReferenceOpt(cacheDirPath), gitutil.NoCheckoutOpt(true)}
+	if p.HistoryDepth > 0 {
+		opts = append(opts, gitutil.DepthOpt(p.HistoryDepth))
+	}
 	if jirix.UsePartialClone(p.Remote) {
 		opts = append(opts, gitutil.OmitBlobsOpt(true))
 	}
 	if jirix.Dissociate {
 		opts = append(opts, gitutil.DissociateOpt(true))
 	}
-	if err := clone(jirix, remoteUrl, path, opts...); err != nil {
+	if err := clone(jirix, remote.Name, remoteUrl, path, opts...); err != nil {
 		return err
 	}
-	scm := gitutil.New(jirix, gitutil.RootDirOpt(p.Path))
+	scm := newGit(jirix, gitutil.RootDirOpt(p.Path))
 	if jirix.UsePartialClone(p.Remote) && cacheDirPath != "" {
 		// Set Cache Remote
 		if err := scm.Config("extensions.partialClone", "origin"); err != nil {
@@ -364,6 +414,9 @@ func (ld *loader) cloneManifestRepo(jirix *jiri.X, remote *Import, cacheDirPath
 
 	p.Revision = remote.Revision
 	p.RemoteBranch = remote.RemoteBranch
+	if err := ensureShallowRevision(jirix, p); err != nil {
+		return err
+	}
 	if err := checkoutHeadRevision(jirix, p, false); err != nil {
 		return fmt.Errorf("Not able to checkout head for %s(%s): %v", p.Name, p.Path, err)
 	}
@@ -371,6 +424,18 @@ func (ld *loader) cloneManifestRepo(jirix *jiri.X, remote *Import, cacheDirPath
 	return nil
 }
 
+// ensureShallowRevision fetches the pinned revision of the shallow manifest
+// project p if it is not part of its fetched history.
+func ensureShallowRevision(jirix *jiri.X, p Project) error {
+	if !p.isShallow() || p.Revision == "" || p.Revision == "HEAD" {
+		return nil
+	}
+	if _, err := newGit(jirix, gitutil.RootDirOpt(p.Path)).Show(p.Revision, ""); err == nil {
+		return nil
+	}
+	return fetchShallowRevision(jirix, p, p.Revision)
+}
+
 // loadLockFile will recursively load lockfiles from dir to its parent directories until it
 // reaches $JIRI_ROOT. It will only report errors on lockfiles such as unknown format or conflating data.
 // All I/O related errors will be ignored.
@@ -392,7 +457,7 @@ func (ld *loader) loadLockFile(jirix *jiri.X, repoPath, dir, lockFileName, ref s
 
 	var data []byte
 	if repoPath != "" {
-		s, err := gitutil.New(jirix, gitutil.RootDirOpt(repoPath)).Show(ref, lockfile)
+		s, err := newGit(jirix, gitutil.RootDirOpt(repoPath)).Show(ref, lockfile)
 		if err != nil {
 			// It's fine if jiri.lock cannot be find, skip this jiri.lock
 			jirix.Logger.Debugf("Could not find %q in repository %q for ref %q", lockfile, repoPath, ref)
@@ -474,6 +539,9 @@ func (ld *loader) load(jirix *jiri.X, root, repoPath, file, ref string, parentIm
 			if err != nil {
 				return nil, fmt.Errorf("Error reading from manifest file %s %s:%s:error(%s)", repoPath, ref, file, err)
 			}
+			if data, err := os.ReadFile(file); err == nil {
+				ld.recordManifestDigest(jirix, f, data)
+			}
 			if jirix.LockfileEnabled {
 				if err := ld.loadLockFile(jirix, repoPath, filepath.Dir(file), jirix.LockfileName, ref); err != nil {
 					return nil, err
@@ -482,7 +550,7 @@ func (ld *loader) load(jirix *jiri.X, root, repoPath, file, ref string, parentIm
 			return m, err
 		}
 		// repoPath != ""
-		s, err := gitutil.New(jirix, gitutil.RootDirOpt(repoPath)).Show(ref, file)
+		s, err := newGit(jirix, gitutil.RootDirOpt(repoPath)).Show(ref, file)
 		if err != nil {
 			return nil, fmt.Errorf("Unable to get manifest file for %s %s:%s:error(%s)", repoPath, ref, file, err)
 		}
@@ -490,6 +558,7 @@ func (ld *loader) load(jirix *jiri.X, root, repoPath, file, ref string, parentIm
 		if err != nil {
 			return nil, fmt.Errorf("Error reading from manifest file %s %s:%s:error(%s)", repoPath, ref, file, err)
 		}
+		ld.recordManifestDigest(jirix, f, []byte(s))
 		if jirix.LockfileEnabled {
 			if err := ld.loadLockFile(jirix, repoPath, filepath.Dir(file), jirix.LockfileName, ref); err != nil {
 				return nil, err
@@ -502,6 +571,26 @@ func (ld *loader) load(jirix *jiri.X, root, repoPath, file, ref string, parentIm
 	if err != nil {
 		return err
 	}
+	vars := manifestVars(m, ld.vars, jirix.Vars)
+	if err := m.expandVars(vars); err != nil {
+		return fmt.Errorf("%s: %v", shortFileName(jirix.Root, repoPath, file, ref), err)
+	}
+	// Install the host keys first, as the projects and manifests imported
+	// by m may have SSH remotes on these hosts. Host keys are only trusted
+	// from the manifests imported by the .jiri_manifest file, not from the
+	// manifests these import in turn.
+	if parentImport == nil || parentImport.Parent == "" {
+		ld.HostKeys = append(ld.HostKeys, m.HostKeys...)
+		if err := installHostKeys(jirix, m.HostKeys); err != nil {
+			return err
+		}
+	} else if len(m.HostKeys) != 0 {
+		jirix.Logger.Warningf("Ignoring the host keys of manifest %q imported by %q. Host keys are honored only in the manifests imported by the .jiri_manifest file and their local imports\n\n", shortFileName(jirix.Root, repoPath, file, ref), parentImport.Name)
+	}
+	// The manifests imported by m inherit its variables.
+	parentVars := ld.vars
+	ld.vars = vars
+	defer func() { ld.vars = parentVars }()
 
 	if jirix.UsingSnapshot && !jirix.OverrideOptional {
 		// using attributes defined in snapshot file instead of
@@ -526,8 +615,35 @@ func (ld *loader) load(jirix *jiri.X, root, repoPath, file, ref string, parentIm
 			}
 			ld.ImportOverrides[key] = p
 		}
-	} else if len(m.ProjectOverrides)+len(m.ImportOverrides) > 0 {
-		return fmt.Errorf("manifest %q contains overrides but was imported by %q. Overrides are allowed only in the root manifest", shortFileName(jirix.Root, repoPath, file, ref), parentImport)
+		for _, h := range m.HookOverrides {
+			if h.Name == "" || h.ProjectName == "" {
+				return fmt.Errorf("bad hook override: must specify name and project: %+v", h)
+			}
+			if h.Action == "" && h.RequiresPackage == "" && h.Interpreter == "" && h.Timeout == "" && h.Inputs == "" {
+				return fmt.Errorf("bad override of hook %q for project %q: must override its action, requires-package, interpreter, timeout or inputs", h.Name, h.ProjectName)
+			}
+			if err := h.validate(); err != nil {
+				return err
+			}
+			if _, ok := ld.HookOverrides[h.Key()]; ok {
+				return fmt.Errorf("duplicate override of hook %q for project %q", h.Name, h.ProjectName)
+			}
+			ld.HookOverrides[h.Key()] = h
+		}
+		for _, p := range m.PackageOverrides {
+			if p.Name == "" {
+				return fmt.Errorf("bad package override: must specify name: %+v", p)
+			}
+			if _, ok := ld.PackageOverrides[p.Name]; ok {
+				return fmt.Errorf("duplicate override of package %q", p.Name)
+			}
+			ld.PackageOverrides[p.Name] = p
+		}
+		if err := checkOverridesExpires(m); err != nil {
+			return err
+		}
+	} else if len(m.ProjectOverrides)+len(m.ImportOverrides)+len(m.HookOverrides)+len(m.PackageOverrides) > 0 {
+		return fmt.Errorf("manifest %q contains overrides but was imported by %q. Overrides are allowed only in the root manifest", shortFileName(jirix.Root, repoPath, file, ref), parentImport.Name)
 	}
 
 	// Use manifest's directory name and file name as default
@@ -554,8 +670,15 @@ func (ld *loader) load(jirix *jiri.X, root, repoPath, file, ref string, parentIm
 		}
 		nextRoot := filepath.Join(root, imp.Root)
 		imp.Name = filepath.Join(nextRoot, imp.Name)
+		imp.inheritedAttrs = make(attributes)
+		imp.chain = []string{imp.Name}
 		if parentImport != nil {
 			imp.Parent = parentImport.Name
+			imp.inheritedAttrs.Add(parentImport.inheritedAttrs)
+			imp.chain = append(imp.chain, parentImport.chain...)
+		}
+		if imp.InheritAttributes {
+			imp.inheritedAttrs.Add(newAttributes(imp.Attributes))
 		}
 		key := imp.ProjectKey()
 		p, ok := ld.localProjects[key]
@@ -576,6 +699,10 @@ func (ld *loader) load(jirix *jiri.X, root, repoPath, file, ref string, parentIm
 		// loadImport.
 		p.Revision = imp.Revision
 		p.RemoteBranch = imp.RemoteBranch
+		if imp.HistoryDepth > 0 {
+			p.HistoryDepth = imp.HistoryDepth
+			p.ShallowSince = ""
+		}
 		ld.importProjects[key] = p
 
 		self.addChild(ld.importTree.getNode(repoPath, imp.Manifest, ""))
@@ -600,6 +727,12 @@ func (ld *loader) load(jirix *jiri.X, root, repoPath, file, ref string, parentIm
 		if err := hook.validate(); err != nil {
 			return err
 		}
+		// Apply override if it exists.
+		if override, ok := ld.HookOverrides[hook.Key()]; ok {
+			hook.update(&override)
+		}
+		// Record manifest location.
+		hook.ManifestPath = f
 		hookMap[hook.ProjectName] = append(hookMap[hook.ProjectName], hook)
 	}
 
@@ -612,6 +745,11 @@ func (ld *loader) load(jirix *jiri.X, root, repoPath, file, ref string, parentIm
 		}
 		// normalize project attributes
 		project.ComputedAttributes = newAttributes(project.Attributes)
+		// The manifest project of an import is needed to load it, so it
+		// doesn't inherit the attributes of the import.
+		if parentImport != nil && project.Remote != parentImport.Remote {
+			project.ComputedAttributes.Add(parentImport.inheritedAttrs)
+		}
 		project.Attributes = project.ComputedAttributes.String()
 		// Make paths absolute by prepending <root>.
 		project.absolutizePaths(filepath.Join(jirix.Root, root))
@@ -635,11 +773,15 @@ func (ld *loader) load(jirix *jiri.X, root, repoPath, file, ref string, parentIm
 					return fmt.Errorf("project %q found in %q defines different revision than its corresponding import tag.", key, shortFileName(jirix.Root, repoPath, file, ref))
 				}
 			}
+			// Keep the manifest project as shallow as its import.
+			if r.HistoryDepth > 0 && !project.isShallow() {
+				project.HistoryDepth = r.HistoryDepth
+			}
 		}
 
 		if dup, ok := ld.Projects[key]; ok && !reflect.DeepEqual(dup, project) {
 			// TODO(toddw): Tell the user the other conflicting file.
-			return fmt.Errorf("duplicate project %q found in %q", key, shortFileName(jirix.Root, repoPath, file, ref))
+			return fmt.Errorf("%w %q found in %q", errDuplicateProject, key, shortFileName(jirix.Root, repoPath, file, ref))
 		}
 
 		// Record manifest location.
@@ -652,8 +794,10 @@ func (ld *loader) load(jirix *jiri.X, root, repoPath, file, ref string, parentIm
 					return fmt.Errorf("project name differs from import name for %s", parentImport.Remote)
 				}
 				project.ImportedBy = parentImport.Parent
+				project.ImportChain = parentImport.chain[1:]
 			} else {
 				project.ImportedBy = parentImport.Name
+				project.ImportChain = parentImport.chain
 			}
 		}
 
@@ -663,6 +807,11 @@ func (ld *loader) load(jirix *jiri.X, root, repoPath, file, ref string, parentIm
 		ld.Projects[key] = project
 	}
 
+	for _, check := range m.UploadChecks {
+		check.ManifestPath = f
+		ld.UploadChecks = append(ld.UploadChecks, check)
+	}
+
 	for _, hook := range m.Hooks {
 		if hook.ActionPath == "" {
 			return fmt.Errorf("invalid hook %q for project %q. Please make sure you are importing project %q and this hook is in the manifest which directly/indirectly imports that project.", hook.Name, hook.ProjectName, hook.ProjectName)
@@ -671,9 +820,50 @@ func (ld *loader) load(jirix *jiri.X, root, repoPath, file, ref string, parentIm
 		ld.Hooks[key] = hook
 	}
 
+	// Only the root manifest and its local imports restrict packages: an
+	// imported manifest could otherwise widen the allow list.
+	if parentImport == nil {
+		ld.PackageAllowList = append(ld.PackageAllowList, m.PackageAllowList...)
+	} else if len(m.PackageAllowList) > 0 {
+		jirix.Logger.Warningf("Ignoring the package allow list of manifest %q imported by %q. Package allow lists are honored only in the root manifest\n\n", shortFileName(jirix.Root, repoPath, file, ref), parentImport.Name)
+	}
+
+	// Group members are named like the projects of this manifest, so they
+	// are rooted the same way.
+	for _, g := range m.Groups {
+		for _, member := range g.Projects {
+			name := filepath.Join(root, member.Name)
+			ld.ProjectGroups[name] = append(ld.ProjectGroups[name], g.Name)
+		}
+		for _, member := range g.Packages {
+			ld.PackageGroups[member.Name] = append(ld.PackageGroups[member.Name], g.Name)
+		}
+	}
+
+	// Imports are loaded first, so an importing manifest overrides the
+	// variables of the manifests it imports.
+	for _, env := range m.Envs {
+		ld.Envs[env.Name] = env
+	}
+	// Likewise for the interpreters of hook actions.
+	for _, i := range m.Interpreters {
+		ld.Interpreters[i.Key()] = i
+	}
+	// And for the cipd client pin.
+	if m.CIPDClient != nil {
+		ld.CIPDClient = m.CIPDClient
+	}
+
 	for _, pkg := range m.Packages {
+		// Apply override if it exists.
+		if override, ok := ld.PackageOverrides[pkg.Name]; ok {
+			pkg.update(&override)
+		}
 		// normalize package attributes.
 		pkg.ComputedAttributes = newAttributes(pkg.Attributes)
+		if parentImport != nil {
+			pkg.ComputedAttributes.Add(parentImport.inheritedAttrs)
+		}
 		pkg.Attributes = pkg.ComputedAttributes.String()
 		// Record manifest location.
 		pkg.ManifestPath = f
@@ -715,14 +905,22 @@ func (ld *loader) loadImport(jirix *jiri.X, root string, imp Import, cacheDirPat
 				// local git as we anyways update all the projects later.
 				fetch := true
 				if project.Revision != "" && project.Revision != "HEAD" {
-					if _, err := gitutil.New(jirix, gitutil.RootDirOpt(project.Path)).Show(project.Revision, ""); err == nil {
+					if _, err := newGit(jirix, gitutil.RootDirOpt(project.Path)).Show(project.Revision, ""); err == nil {
 						fetch = false
 					}
 				}
+				if fetch && jirix.Offline {
+					if project.Revision != "" && project.Revision != "HEAD" {
+						return fmt.Errorf("offline: revision %q of manifest project %q is not available locally", project.Revision, project.Name)
+					}
+					// Read the manifest from the last fetched state of the
+					// remote branch.
+					fetch = false
+				}
 				if fetch {
 					if cacheDirPath != "" {
 						remoteUrl := rewriteRemote(jirix, project.Remote)
-						if err := updateOrCreateCache(jirix, cacheDirPath, remoteUrl, project.RemoteBranch, project.Revision, 0); err != nil {
+						if err := updateOrCreateCache(jirix, project.Name, cacheDirPath, remoteUrl, project.RemoteBranch, project.fetchRefspecs(""), project.Revision, project.BundleURL, 0, ""); err != nil {
 							return err
 						}
 					}
@@ -733,10 +931,13 @@ func (ld *loader) loadImport(jirix *jiri.X, root string, imp Import, cacheDirPat
 					if err := fetchAll(jirix, project); err != nil {
 						return fmt.Errorf("Fetch failed for project(%s), %s", project.Path, err)
 					}
+					if err := ensureShallowRevision(jirix, project); err != nil {
+						return fmt.Errorf("Fetch failed for project(%s), %s", project.Path, err)
+					}
 				}
 			} else {
 				// If not updating then try to get file from JIRI_HEAD
-				if _, err := gitutil.New(jirix, gitutil.RootDirOpt(project.Path)).Show("JIRI_HEAD", ""); err == nil {
+				if _, err := newGit(jirix, gitutil.RootDirOpt(project.Path)).Show("JIRI_HEAD", ""); err == nil {
 					// JIRI_HEAD available, set ref
 					ref = "JIRI_HEAD"
 				}
@@ -756,11 +957,76 @@ func (ld *loader) loadImport(jirix *jiri.X, root string, imp Import, cacheDirPat
 	}
 	// Only recursively load import project's local manifest if it is explicitly passed to -local-manifest-project
 	if lm {
-		return ld.Load(jirix, root, "", filepath.Join(project.Path, imp.Manifest), "", imp.cycleKey(), &imp, localManifestProjects)
+		file := filepath.Join(project.Path, imp.Manifest)
+		ld.localManifests[project.Name] = file
+		return ld.Load(jirix, root, "", file, "", imp.cycleKey(), &imp, localManifestProjects)
 	}
 	return ld.Load(jirix, root, project.Path, imp.Manifest, ref, imp.cycleKey(), &imp, localManifestProjects)
 }
 
+// reportLocalManifests reports the manifests that were loaded from the
+// working trees of local manifest projects, and warns about the
+// localManifestProjects whose manifests weren't used.
+func (ld *loader) reportLocalManifests(jirix *jiri.X, localManifestProjects []string) {
+	if len(localManifestProjects) == 0 {
+		return
+	}
+	for _, name := range slices.Sorted(maps.Keys(ld.localManifests)) {
+		jirix.Logger.Infof("Using local manifest %s of project %q", shortFileName(jirix.Root, "", ld.localManifests[name], ""), name)
+	}
+	for _, name := range localManifestProjects {
+		if _, ok := ld.localManifests[name]; !ok {
+			jirix.Logger.Warningf("The local manifest of project %q was not used, no import loads its manifest from it.\n\n", name)
+		}
+	}
+}
+
+// applyInterpreters sets the interpreter mapped to the extension of their
+// action by the manifests on the hooks without an interpreter of their own.
+func (ld *loader) applyInterpreters() {
+	for key, hook := range ld.Hooks {
+		if hook.Interpreter == "" {
+			hook.InterpreterCommand = ld.Interpreters.interpreterFor(hook.Action)
+			ld.Hooks[key] = hook
+		}
+	}
+}
+
+// applyGroups records the groups declared by the manifests on their member
+// projects and packages.
+func (ld *loader) applyGroups(jirix *jiri.X) {
+	found := make(map[string]bool)
+	for key, p := range ld.Projects {
+		groups := newAttributes(p.Groups)
+		if names, ok := ld.ProjectGroups[p.Name]; ok {
+			found[p.Name] = true
+			groups.Add(newAttributes(strings.Join(names, ",")))
+		}
+		p.Groups = groups.String()
+		ld.Projects[key] = p
+	}
+	for name, groups := range ld.ProjectGroups {
+		if !found[name] {
+			jirix.Logger.Warningf("Project %q of group(s) %s is not in the manifest\n\n", name, strings.Join(groups, ","))
+		}
+	}
+	found = make(map[string]bool)
+	for key, pkg := range ld.Packages {
+		groups := newAttributes(pkg.Groups)
+		if names, ok := ld.PackageGroups[pkg.Name]; ok {
+			found[pkg.Name] = true
+			groups.Add(newAttributes(strings.Join(names, ",")))
+		}
+		pkg.Groups = groups.String()
+		ld.Packages[key] = pkg
+	}
+	for name, groups := range ld.PackageGroups {
+		if !found[name] {
+			jirix.Logger.Warningf("Package %q of group(s) %s is not in the manifest\n\n", name, strings.Join(groups, ","))
+		}
+	}
+}
+
 func (ld *loader) GenerateGitAttributesForProjects(jirix *jiri.X) {
 	ld.importTree.buildImportAttributes()
 	for k, v := range ld.Projects {
@@ -771,3 +1037,50 @@ func (ld *loader) GenerateGitAttributesForProjects(jirix *jiri.X) {
 	}
 	jirix.Logger.Debugf("Generated dot file: %s", ld.importTree.generateAttributeGraph())
 }
+
+// loadProjectHooks returns the hooks declared in the projectHooksFile of each
+// of projects which the manifest allows to, see Project.AllowHooks. These
+// hooks belong to, and run in, the project declaring them, so their "project"
+// attribute can be omitted.
+func loadProjectHooks(jirix *jiri.X, projects Projects) (Hooks, error) {
+	hooks := make(Hooks)
+	for _, p := range projects {
+		file := filepath.Join(p.Path, projectHooksFile)
+		data, err := os.ReadFile(file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmtError(err)
+		}
+		if !p.AllowHooks {
+			jirix.Logger.Warningf("Project %s(%s) declares hooks in %s, they don't run as the manifest does not set allowhooks=\"true\" for it\n\n", p.Name, p.Path, projectHooksFile)
+			continue
+		}
+		var ph projectHooks
+		if err := xml.Unmarshal(data, &ph); err != nil {
+			return nil, fmt.Errorf("invalid hooks file %s: %v", file, err)
+		}
+		for _, hook := range ph.Hooks {
+			if hook.ProjectName != "" && hook.ProjectName != p.Name {
+				return nil, fmt.Errorf("invalid hook %q in %s: it can only belong to project %q", hook.Name, file, p.Name)
+			}
+			hook.ProjectName = p.Name
+			if hook.Name == "" || hook.Action == "" {
+				return nil, fmt.Errorf("invalid hook in %s: name and action are required: %+v", file, hook)
+			}
+			if err := hook.validate(); err != nil {
+				return nil, fmt.Errorf("%s: %v", file, err)
+			}
+			if !filepath.IsLocal(hook.Action) {
+				return nil, fmt.Errorf("invalid hook %q in %s: action %q is not inside the project", hook.Name, file, hook.Action)
+			}
+			hook.ActionPath = p.Path
+			if _, ok := hooks[hook.Key()]; ok {
+				return nil, fmt.Errorf("duplicate hook %q in %s", hook.Name, file)
+			}
+			hooks[hook.Key()] = hook
+		}
+	}
+	return hooks, nil
+}
@@ -0,0 +1,50 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/color"
+	"go.fuchsia.dev/jiri/log"
+)
+
+func TestResolveHookLocks(t *testing.T) {
+	var out bytes.Buffer
+	jirix := &jiri.X{Logger: log.NewLogger(log.InfoLevel, color.NewColor(color.ColorNever), false, 0, time.Second, &out, &out)}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "setup.sh"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	hooks := make(Hooks)
+	for _, h := range []Hook{
+		{Name: "setup", ProjectName: "a", Action: "setup.sh", ActionPath: dir},
+		{Name: "missing", ProjectName: "a", Action: "missing.sh", ActionPath: dir},
+		{Name: "unselected", ProjectName: "b", Action: "setup.sh", ActionPath: filepath.Join(dir, "b")},
+	} {
+		hooks[h.Key()] = h
+	}
+	selected := Projects{MakeProjectKey("a", "https://example.com/a"): {Name: "a"}}
+	locks := resolveHookLocks(jirix, hooks, selected)
+	if len(locks) != len(hooks) {
+		t.Errorf("got %d hook locks, want %d", len(locks), len(hooks))
+	}
+	for _, lock := range locks {
+		if (lock.Digest != "") != (lock.Name == "setup") {
+			t.Errorf("got digest %q for hook %q", lock.Digest, lock.Name)
+		}
+	}
+	// Only the hooks of the selected projects are expected to be checked
+	// out.
+	if got := out.String(); !strings.Contains(got, `"missing"`) || strings.Contains(got, `"unselected"`) {
+		t.Errorf("got warnings %q, want one for hook \"missing\" only", got)
+	}
+}
@@ -7,6 +7,7 @@ package project
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	_ "embed"
 	"encoding/json"
 	"encoding/xml"
@@ -14,11 +15,13 @@ import (
 	"fmt"
 	"hash/fnv"
 	"io"
+	"io/fs"
+	"maps"
 	"net/url"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"text/template"
@@ -29,6 +32,7 @@ import (
 	"go.fuchsia.dev/jiri/envvar"
 	"go.fuchsia.dev/jiri/gerrit"
 	"go.fuchsia.dev/jiri/gitutil"
+	"go.fuchsia.dev/jiri/osutil"
 	"go.fuchsia.dev/jiri/retry"
 	"golang.org/x/net/publicsuffix"
 )
@@ -41,16 +45,26 @@ var commitMsgHookBytes []byte
 
 // Manifest represents a setting used for updating the universe.
 type Manifest struct {
-	Version          string        `xml:"version,attr,omitempty"`
-	Attributes       string        `xml:"attributes,attr,omitempty"`
-	Imports          []Import      `xml:"imports>import"`
-	LocalImports     []LocalImport `xml:"imports>localimport"`
-	Projects         []Project     `xml:"projects>project"`
-	ProjectOverrides []Project     `xml:"overrides>project"`
-	ImportOverrides  []Import      `xml:"overrides>import"`
-	Hooks            []Hook        `xml:"hooks>hook"`
-	Packages         []Package     `xml:"packages>package"`
-	XMLName          struct{}      `xml:"manifest"`
+	Version          string         `xml:"version,attr,omitempty"`
+	Attributes       string         `xml:"attributes,attr,omitempty"`
+	Imports          []Import       `xml:"imports>import"`
+	LocalImports     []LocalImport  `xml:"imports>localimport"`
+	Projects         []Project      `xml:"projects>project"`
+	ProjectOverrides []Project      `xml:"overrides>project"`
+	ImportOverrides  []Import       `xml:"overrides>import"`
+	HookOverrides    []Hook         `xml:"overrides>hook"`
+	PackageOverrides []Package      `xml:"overrides>package"`
+	Hooks            []Hook         `xml:"hooks>hook"`
+	Interpreters     []Interpreter  `xml:"hooks>interpreter"`
+	Packages         []Package      `xml:"packages>package"`
+	PackageAllowList []PackageAllow `xml:"packageallowlist>allow"`
+	Groups           []Group        `xml:"groups>group"`
+	Envs             []Env          `xml:"envs>env"`
+	Vars             []Var          `xml:"vars>var"`
+	HostKeys         []HostKey      `xml:"hostkeys>hostkey"`
+	UploadChecks     []UploadCheck  `xml:"uploadchecks>check"`
+	CIPDClient       *CIPDClient    `xml:"cipd_client"`
+	XMLName          struct{}       `xml:"manifest"`
 }
 
 // ManifestFromBytes returns a manifest parsed from data, with defaults filled
@@ -95,6 +109,12 @@ var (
 	emptyOverridesBytes = []byte("\n  <overrides></overrides>\n")
 	emptyHooksBytes     = []byte("\n  <hooks></hooks>\n")
 	emptyPackagesBytes  = []byte("\n  <packages></packages>\n")
+	emptyAllowListBytes = []byte("\n  <packageallowlist></packageallowlist>\n")
+	emptyGroupsBytes    = []byte("\n  <groups></groups>\n")
+	emptyEnvsBytes      = []byte("\n  <envs></envs>\n")
+	emptyVarsBytes      = []byte("\n  <vars></vars>\n")
+	emptyHostKeysBytes  = []byte("\n  <hostkeys></hostkeys>\n")
+	emptyChecksBytes    = []byte("\n  <uploadchecks></uploadchecks>\n")
 
 	endElemBytes        = []byte("/>\n")
 	endImportBytes      = []byte("></import>\n")
@@ -102,8 +122,19 @@ var (
 	endProjectBytes     = []byte("></project>\n")
 	endHookBytes        = []byte("></hook>\n")
 	endPackageBytes     = []byte("></package>\n")
+	endAllowBytes       = []byte("></allow>\n")
+	endGroupBytes       = []byte("></group>\n")
+	endEnvBytes         = []byte("></env>\n")
+	endVarBytes         = []byte("></var>\n")
+	endHostKeyBytes     = []byte("></hostkey>\n")
+	endInterpreterBytes = []byte("></interpreter>\n")
+	endCheckBytes       = []byte("></check>\n")
+	endDigestBytes      = []byte("></digest>\n")
 
 	endProjectSoloBytes = []byte("></project>")
+	endCopyFileBytes    = []byte("></copyfile>")
+	endLinkFileBytes    = []byte("></linkfile>")
+	endAltRemoteBytes   = []byte("></altremote>")
 	endElemSoloBytes    = []byte("/>")
 )
 
@@ -119,8 +150,22 @@ func (m *Manifest) deepCopy() *Manifest {
 	x.Projects = append([]Project(nil), m.Projects...)
 	x.ProjectOverrides = append([]Project(nil), m.ProjectOverrides...)
 	x.ImportOverrides = append([]Import(nil), m.ImportOverrides...)
+	x.HookOverrides = append([]Hook(nil), m.HookOverrides...)
+	x.PackageOverrides = append([]Package(nil), m.PackageOverrides...)
 	x.Hooks = append([]Hook(nil), m.Hooks...)
+	x.Interpreters = append([]Interpreter(nil), m.Interpreters...)
 	x.Packages = append([]Package(nil), m.Packages...)
+	x.PackageAllowList = append([]PackageAllow(nil), m.PackageAllowList...)
+	x.Groups = append([]Group(nil), m.Groups...)
+	x.Envs = append([]Env(nil), m.Envs...)
+	x.Vars = append([]Var(nil), m.Vars...)
+	x.HostKeys = append([]HostKey(nil), m.HostKeys...)
+	x.UploadChecks = append([]UploadCheck(nil), m.UploadChecks...)
+	if m.CIPDClient != nil {
+		c := *m.CIPDClient
+		c.Digests = append([]CIPDClientDigest(nil), c.Digests...)
+		x.CIPDClient = &c
+	}
 	x.Version = m.Version
 	x.Attributes = m.Attributes
 	return x
@@ -143,11 +188,28 @@ func (m *Manifest) ToBytes() ([]byte, error) {
 	data = bytes.Replace(data, emptyOverridesBytes, newlineBytes, -1)
 	data = bytes.Replace(data, emptyHooksBytes, newlineBytes, -1)
 	data = bytes.Replace(data, emptyPackagesBytes, newlineBytes, -1)
+	data = bytes.Replace(data, emptyAllowListBytes, newlineBytes, -1)
+	data = bytes.Replace(data, emptyGroupsBytes, newlineBytes, -1)
+	data = bytes.Replace(data, emptyEnvsBytes, newlineBytes, -1)
+	data = bytes.Replace(data, emptyVarsBytes, newlineBytes, -1)
+	data = bytes.Replace(data, emptyHostKeysBytes, newlineBytes, -1)
+	data = bytes.Replace(data, emptyChecksBytes, newlineBytes, -1)
 	data = bytes.Replace(data, endImportBytes, endElemBytes, -1)
 	data = bytes.Replace(data, endLocalImportBytes, endElemBytes, -1)
 	data = bytes.Replace(data, endProjectBytes, endElemBytes, -1)
 	data = bytes.Replace(data, endHookBytes, endElemBytes, -1)
 	data = bytes.Replace(data, endPackageBytes, endElemBytes, -1)
+	data = bytes.Replace(data, endAllowBytes, endElemBytes, -1)
+	data = bytes.Replace(data, endGroupBytes, endElemBytes, -1)
+	data = bytes.Replace(data, endEnvBytes, endElemBytes, -1)
+	data = bytes.Replace(data, endVarBytes, endElemBytes, -1)
+	data = bytes.Replace(data, endHostKeyBytes, endElemBytes, -1)
+	data = bytes.Replace(data, endInterpreterBytes, endElemBytes, -1)
+	data = bytes.Replace(data, endCheckBytes, endElemBytes, -1)
+	data = bytes.Replace(data, endDigestBytes, endElemBytes, -1)
+	data = bytes.Replace(data, endCopyFileBytes, endElemSoloBytes, -1)
+	data = bytes.Replace(data, endLinkFileBytes, endElemSoloBytes, -1)
+	data = bytes.Replace(data, endAltRemoteBytes, endElemSoloBytes, -1)
 	if !bytes.HasSuffix(data, newlineBytes) {
 		data = append(data, '\n')
 	}
@@ -211,6 +273,49 @@ func (m *Manifest) fillDefaults() error {
 		if err := m.Packages[index].FillDefaults(); err != nil {
 			return err
 		}
+		if err := m.Packages[index].validate(); err != nil {
+			return err
+		}
+	}
+	for index := range m.PackageAllowList {
+		if err := m.PackageAllowList[index].validate(); err != nil {
+			return err
+		}
+	}
+	for index := range m.Groups {
+		if err := m.Groups[index].validate(); err != nil {
+			return err
+		}
+	}
+	for index := range m.Envs {
+		if err := m.Envs[index].validate(); err != nil {
+			return err
+		}
+	}
+	for index := range m.Vars {
+		if err := m.Vars[index].validate(); err != nil {
+			return err
+		}
+	}
+	for index := range m.HostKeys {
+		if err := m.HostKeys[index].validate(); err != nil {
+			return err
+		}
+	}
+	for index := range m.Interpreters {
+		if err := m.Interpreters[index].validate(); err != nil {
+			return err
+		}
+	}
+	for index := range m.UploadChecks {
+		if err := m.UploadChecks[index].validate(); err != nil {
+			return err
+		}
+	}
+	if m.CIPDClient != nil {
+		if err := m.CIPDClient.validate(); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -263,11 +368,30 @@ type Import struct {
 	Revision string `xml:"revision,attr,omitempty"`
 	// RemoteBranch is the name of the remote branch to track.
 	RemoteBranch string `xml:"remotebranch,attr,omitempty"`
+	// HistoryDepth limits the history of the manifest project to that many
+	// commits. Zero means full history.
+	HistoryDepth int `xml:"historydepth,attr,omitempty"`
 	// Root path, prepended to all project paths specified in the manifest file.
-	Root    string   `xml:"root,attr,omitempty"`
+	Root string `xml:"root,attr,omitempty"`
+	// Attributes is a list of attributes separated by comma, added to the
+	// attributes of all the projects and packages brought in by the import
+	// when InheritAttributes is set.
+	Attributes string `xml:"attributes,attr,omitempty"`
+	// InheritAttributes makes the projects and packages brought in by the
+	// import, directly or through nested imports, inherit Attributes.
+	InheritAttributes bool `xml:"inherit-attributes,attr,omitempty"`
+	// Expires is the date, as YYYY-MM-DD, after which this import override
+	// is reported as expired. It is only used in <overrides>.
+	Expires string   `xml:"expires,attr,omitempty"`
 	XMLName struct{} `xml:"import"`
 	// Parent is the name of the parent import, if any.
 	Parent string `xml:"-"`
+	// chain holds the names of the import and of the imports it is nested
+	// in, innermost first.
+	chain []string
+	// inheritedAttrs are the attributes inherited by the projects and
+	// packages of the import, from it and the imports it is nested in.
+	inheritedAttrs attributes
 }
 
 func (i *Import) fillDefaults() error {
@@ -298,6 +422,12 @@ func (i *Import) validate() error {
 	if i.Manifest == "" || i.Remote == "" {
 		return fmt.Errorf("bad import: both manifest and remote must be specified")
 	}
+	if i.Attributes != "" && !i.InheritAttributes {
+		return fmt.Errorf("bad import %q: attributes are only used with inherit-attributes=\"true\"", i.Name)
+	}
+	if i.HistoryDepth < 0 {
+		return fmt.Errorf("bad import %q: historydepth must not be negative", i.Name)
+	}
 	return nil
 }
 
@@ -308,6 +438,7 @@ func (i *Import) toProject(path string) (Project, error) {
 		Remote:       i.Remote,
 		Revision:     i.Revision,
 		RemoteBranch: i.RemoteBranch,
+		HistoryDepth: i.HistoryDepth,
 	}
 	err := p.fillDefaults()
 	return p, err
@@ -357,9 +488,16 @@ func (i *Import) update(o *Import) {
 	if o.RemoteBranch != "" {
 		i.RemoteBranch = o.RemoteBranch
 	}
+	if o.HistoryDepth > 0 {
+		i.HistoryDepth = o.HistoryDepth
+	}
 	if o.Root != "" {
 		i.Root = o.Root
 	}
+	if o.Attributes != "" {
+		i.Attributes = o.Attributes
+		i.InheritAttributes = o.InheritAttributes
+	}
 }
 
 // LocalImport represents a local manifest import.
@@ -377,10 +515,21 @@ func (i *LocalImport) validate() error {
 }
 
 type LocalConfig struct {
-	Ignore   bool     `xml:"ignore"`
-	NoUpdate bool     `xml:"no-update"`
-	NoRebase bool     `xml:"no-rebase"`
-	XMLName  struct{} `xml:"config"`
+	Ignore   bool `xml:"ignore"`
+	NoUpdate bool `xml:"no-update"`
+	NoRebase bool `xml:"no-rebase"`
+	// Rebase overrides the rebase policy of the project in the manifest,
+	// see Project.Rebase.
+	Rebase string `xml:"rebase,omitempty"`
+	// Pin is a revision that "jiri update" checks the project out at,
+	// regardless of the revision in the manifest.
+	Pin string `xml:"pin,omitempty"`
+	// Reviewers and Hashtags are comma-separated lists added by "jiri
+	// upload" to the CLs of the project, in addition to those of the
+	// manifest.
+	Reviewers string   `xml:"reviewers,omitempty"`
+	Hashtags  string   `xml:"hashtags,omitempty"`
+	XMLName   struct{} `xml:"config"`
 }
 
 // Reads localConfig from given reader. Returns incorrect bytes
@@ -431,11 +580,73 @@ func WriteLocalConfig(jirix *jiri.X, project Project, lc LocalConfig) error {
 
 // Hook represents a hook to run
 type Hook struct {
-	Name        string   `xml:"name,attr"`
-	Action      string   `xml:"action,attr"`
-	ProjectName string   `xml:"project,attr"`
-	XMLName     struct{} `xml:"hook"`
-	ActionPath  string   `xml:"-"`
+	Name            string `xml:"name,attr"`
+	Action          string `xml:"action,attr"`
+	ProjectName     string `xml:"project,attr"`
+	RequiresPackage string `xml:"requires-package,attr,omitempty"`
+	// Interpreter is the command running the action, see Interpreter.Command.
+	Interpreter string `xml:"interpreter,attr,omitempty"`
+	// Timeout is the duration, e.g. "30m", after which the hook is killed
+	// along with the processes it started. It defaults to the timeout of
+	// the command running the hooks.
+	Timeout string `xml:"timeout,attr,omitempty"`
+	// Expires is the date, as YYYY-MM-DD, after which this hook override is
+	// reported as expired. It is only used in <overrides>.
+	Expires string `xml:"expires,attr,omitempty"`
+	// Inputs are the comma-separated paths, relative to the project of the
+	// hook, that the hook depends on. When set, "jiri update" only runs the
+	// hook again once one of them changed, instead of once its project
+	// changed revision.
+	Inputs     string   `xml:"inputs,attr,omitempty"`
+	XMLName    struct{} `xml:"hook"`
+	ActionPath string   `xml:"-"`
+	// InterpreterCommand is the command mapped to the extension of the
+	// action for this host by the <interpreter> tags of the manifest, used
+	// when Interpreter is empty.
+	InterpreterCommand string `xml:"-"`
+	// ManifestPath stores the absolute path of the manifest.
+	ManifestPath string `xml:"-"`
+}
+
+// update overrides the action, required packages, interpreter, timeout and
+// inputs of h with the ones of other, if set.
+func (h *Hook) update(other *Hook) {
+	if other.Action != "" {
+		h.Action = other.Action
+	}
+	if other.RequiresPackage != "" {
+		h.RequiresPackage = other.RequiresPackage
+	}
+	if other.Interpreter != "" {
+		h.Interpreter = other.Interpreter
+	}
+	if other.Timeout != "" {
+		h.Timeout = other.Timeout
+	}
+	if other.Inputs != "" {
+		h.Inputs = other.Inputs
+	}
+}
+
+// timeout returns how long h may run, defaultTimeout unless the hook has a
+// timeout of its own.
+func (h Hook) timeout(defaultTimeout time.Duration) time.Duration {
+	if d, err := time.ParseDuration(h.Timeout); err == nil {
+		return d
+	}
+	return defaultTimeout
+}
+
+// RequiredPackages returns the names of the packages that must be fetched
+// before h runs.
+func (h Hook) RequiredPackages() []string {
+	var names []string
+	for _, name := range strings.Split(h.RequiresPackage, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
 }
 
 // HookKey is a map key for a project.
@@ -463,6 +674,14 @@ func (h *Hook) validate() error {
 	if strings.Contains(h.ProjectName, KeySeparator) {
 		return fmt.Errorf("bad hook: project cannot contain %q: %+v", KeySeparator, *h)
 	}
+	if h.Timeout != "" {
+		if d, err := time.ParseDuration(h.Timeout); err != nil || d <= 0 {
+			return fmt.Errorf("bad hook %q: timeout %q should be a positive duration, e.g. \"30m\"", h.Name, h.Timeout)
+		}
+	}
+	if h.Interpreter != "" && strings.TrimSpace(h.Interpreter) == "" {
+		return fmt.Errorf("bad hook %q: interpreter cannot be blank", h.Name)
+	}
 	return nil
 }
 
@@ -509,6 +728,15 @@ type Package struct {
 	// this package is successfully fetched.
 	Flag string `xml:"flag,attr,omitempty"`
 
+	// Action is a command run in the package directory after the package
+	// is fetched, e.g. to create symlinks or codesign binaries, like a hook.
+	// It is relative to the package directory.
+	Action string `xml:"action,attr,omitempty"`
+
+	// ActionTimeout is the duration, e.g. "5m", after which Action is
+	// killed. It defaults to the timeout of fetching packages.
+	ActionTimeout string `xml:"action-timeout,attr,omitempty"`
+
 	// Attributes store the the list attributes for this package.
 	// When it starts with "+", a computed default attributes will
 	// be appended.
@@ -517,7 +745,11 @@ type Package struct {
 	// Instances store the known instance ids for this package.
 	// It is mainly used by snapshot file.
 	Instances []PackageInstance `xml:"instance"`
-	XMLName   struct{}          `xml:"package"`
+
+	// Expires is the date, as YYYY-MM-DD, after which this package override
+	// is reported as expired. It is only used in <overrides>.
+	Expires string   `xml:"expires,attr,omitempty"`
+	XMLName struct{} `xml:"package"`
 
 	// ComputedAttributes stores computed attributes object
 	// which is easier to perform matching and comparing.
@@ -525,6 +757,230 @@ type Package struct {
 
 	// ManifestPath stores the absolute path of the manifest.
 	ManifestPath string `xml:"-"`
+
+	// Groups lists the manifest groups this package belongs to, separated
+	// by comma. It is computed from the <group> elements of the manifests
+	// and recorded in snapshots.
+	Groups string `xml:"groups,attr,omitempty"`
+
+	// Signers stores the identities allowed to register instances of this
+	// package, taken from the matching rule of the manifest's package allow
+	// list. Instances registered by anyone else are refused at fetch time.
+	Signers []string `xml:"-"`
+
+	// VerifiedInstances stores the instances of this package whose signer
+	// was checked, which the ensure file then deploys instead of Version.
+	VerifiedInstances []PackageInstance `xml:"-"`
+}
+
+// PackageAllow is an entry of the package allow list of a manifest. Once any
+// loaded manifest declares an allow list, every package must be under the
+// Prefix of one of its entries.
+type PackageAllow struct {
+	// Prefix is a cipd package name or a package path prefix.
+	Prefix string `xml:"prefix,attr"`
+
+	// Signers is an optional comma separated list of identities (e.g.
+	// "user:builder@example.com") that must have registered the package
+	// instance, as reported by "cipd describe".
+	Signers string   `xml:"signers,attr,omitempty"`
+	XMLName struct{} `xml:"allow"`
+}
+
+func (a *PackageAllow) validate() error {
+	if a.Prefix == "" || strings.Contains(a.Prefix, "$") {
+		return fmt.Errorf("bad package allow: must specify a prefix without templates: %+v", *a)
+	}
+	return nil
+}
+
+// matches returns the length of the matched prefix if name is allowed by a,
+// or -1 otherwise.
+func (a PackageAllow) matches(name string) int {
+	prefix := strings.TrimSuffix(a.Prefix, "/")
+	if name == prefix || strings.HasPrefix(name, prefix+"/") {
+		return len(prefix)
+	}
+	return -1
+}
+
+// signers returns the identities listed in the Signers attribute.
+func (a PackageAllow) signers() []string {
+	var ret []string
+	for _, s := range strings.Split(a.Signers, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
+
+// Group names a set of projects and packages of a manifest. Unlike
+// attributes, groups are selected or excluded as a whole, see
+// FilterProjectsPackagesByGroup.
+type Group struct {
+	Name     string        `xml:"name,attr"`
+	Projects []GroupMember `xml:"project"`
+	Packages []GroupMember `xml:"package"`
+	XMLName  struct{}      `xml:"group"`
+}
+
+// GroupMember refers to a project or package of a Group by name.
+type GroupMember struct {
+	Name string `xml:"name,attr"`
+}
+
+func (g *Group) validate() error {
+	if g.Name == "" || strings.ContainsAny(g.Name, ", ") {
+		return fmt.Errorf("bad group: must specify a name without commas or spaces: %+v", *g)
+	}
+	for _, m := range append(append([]GroupMember(nil), g.Projects...), g.Packages...) {
+		if m.Name == "" {
+			return fmt.Errorf("bad group %q: members must specify a name", g.Name)
+		}
+	}
+	return nil
+}
+
+// Env is an environment variable exported by "jiri env". Value is a
+// text/template, see Env.Expand.
+type Env struct {
+	Name    string   `xml:"name,attr"`
+	Value   string   `xml:"value,attr"`
+	XMLName struct{} `xml:"env"`
+}
+
+// Envs maps environment variable names to their Env.
+type Envs map[string]Env
+
+func (e *Env) validate() error {
+	if !envNameRE.MatchString(e.Name) {
+		return fmt.Errorf("bad env: must specify a valid variable name: %+v", *e)
+	}
+	if _, err := template.New(e.Name).Funcs(envFuncs(nil, nil)).Parse(e.Value); err != nil {
+		return fmt.Errorf("bad env %q: %v", e.Name, err)
+	}
+	return nil
+}
+
+// Var is a manifest variable, referred to as {{name}} in the attributes of
+// projects and imports locating them, see Manifest.expandVars.
+type Var struct {
+	Name    string   `xml:"name,attr"`
+	Value   string   `xml:"value,attr"`
+	XMLName struct{} `xml:"var"`
+}
+
+func (v *Var) validate() error {
+	if !envNameRE.MatchString(v.Name) {
+		return fmt.Errorf("bad var: must specify a valid variable name: %+v", *v)
+	}
+	return nil
+}
+
+// HostKey is the public key of a host serving remotes over SSH, which jiri
+// adds to its known_hosts file, see installHostKeys.
+type HostKey struct {
+	// Host is the host name as written in known_hosts files, e.g.
+	// "gerrit.example.com", or "[gerrit.example.com]:29418" for a port
+	// other than 22.
+	Host string `xml:"host,attr"`
+	// Key is the public key of the host, e.g. "ssh-ed25519 AAAA...", as
+	// printed by ssh-keyscan.
+	Key     string   `xml:"key,attr"`
+	XMLName struct{} `xml:"hostkey"`
+}
+
+func (k *HostKey) validate() error {
+	if k.Host == "" || strings.ContainsAny(k.Host, " \t\n,") {
+		return fmt.Errorf("bad hostkey: must specify a valid host: %+v", *k)
+	}
+	if fields := strings.Fields(k.Key); len(fields) != 2 {
+		return fmt.Errorf("bad hostkey for %q: key %q should be a key type followed by the base64 key", k.Host, k.Key)
+	}
+	return nil
+}
+
+// CIPDClient pins the cipd client bootstrapped by jiri to Version, instead of
+// the version built into jiri. The client downloaded for a platform is
+// verified against the sha256 of its Digests entry before it is run.
+type CIPDClient struct {
+	Version string             `xml:"version,attr"`
+	Digests []CIPDClientDigest `xml:"digest"`
+}
+
+// CIPDClientDigest is the sha256 of the cipd client for Platform.
+type CIPDClientDigest struct {
+	Platform string `xml:"platform,attr"`
+	SHA256   string `xml:"sha256,attr"`
+}
+
+func (c *CIPDClient) validate() error {
+	if c.Version == "" {
+		return fmt.Errorf("bad cipd_client: must specify a version")
+	}
+	if len(c.Digests) == 0 {
+		return fmt.Errorf("bad cipd_client %q: must specify the digests of the client", c.Version)
+	}
+	seen := make(map[string]bool)
+	for _, d := range c.Digests {
+		if _, err := cipd.NewPlatform(d.Platform); err != nil {
+			return fmt.Errorf("bad cipd_client %q: %v", c.Version, err)
+		}
+		if !sha256RE.MatchString(d.SHA256) {
+			return fmt.Errorf("bad cipd_client %q: sha256 %q of %s is not a lowercase hex sha256 digest", c.Version, d.SHA256, d.Platform)
+		}
+		if seen[d.Platform] {
+			return fmt.Errorf("bad cipd_client %q: duplicate digest for %s", c.Version, d.Platform)
+		}
+		seen[d.Platform] = true
+	}
+	return nil
+}
+
+// pin returns c as the cipd client pin of jiri.X.
+func (c *CIPDClient) pin() *jiri.CIPDClientPin {
+	p := &jiri.CIPDClientPin{Version: c.Version, Digests: make(map[string]string)}
+	for _, d := range c.Digests {
+		p.Digests[d.Platform] = d.SHA256
+	}
+	return p
+}
+
+// cipdClientFromPin returns the manifest element of pin, nil if pin is nil.
+func cipdClientFromPin(pin *jiri.CIPDClientPin) *CIPDClient {
+	if pin == nil {
+		return nil
+	}
+	c := &CIPDClient{Version: pin.Version}
+	for _, platform := range slices.Sorted(maps.Keys(pin.Digests)) {
+		c.Digests = append(c.Digests, CIPDClientDigest{Platform: platform, SHA256: pin.Digests[platform]})
+	}
+	return c
+}
+
+// CheckPackagesAllowed returns an error if allowList is not empty and any of
+// pkgs is not covered by one of its entries. Otherwise, the Signers of every
+// package are set from the longest matching entry.
+func CheckPackagesAllowed(pkgs Packages, allowList []PackageAllow) error {
+	if len(allowList) == 0 {
+		return nil
+	}
+	for key, pkg := range pkgs {
+		best := -1
+		var rule PackageAllow
+		for _, item := range allowList {
+			if n := item.matches(pkg.Name); n > best {
+				best, rule = n, item
+			}
+		}
+		if best < 0 {
+			return fmt.Errorf("package %s in manifest %s is not in the package allow list", pkg.Name, pkg.ManifestPath)
+		}
+		pkg.Signers = rule.signers()
+		pkgs[key] = pkg
+	}
+	return nil
 }
 
 // PackagesByKey implements the Sort interface. It sorts Packages by
@@ -601,6 +1057,41 @@ type PackageInstance struct {
 	XMLName struct{} `xml:"instance"`
 }
 
+// update overrides the fields of p that are set in other. Only the fields
+// that do not change the identity of the package can be overridden.
+func (p *Package) update(other *Package) {
+	if other.Version != "" {
+		p.Version = other.Version
+	}
+	if other.Path != "" {
+		p.Path = other.Path
+	}
+	if other.Platforms != "" {
+		p.Platforms = other.Platforms
+	}
+	if other.Flag != "" {
+		p.Flag = other.Flag
+	}
+	if other.Action != "" {
+		p.Action = other.Action
+	}
+	if other.ActionTimeout != "" {
+		p.ActionTimeout = other.ActionTimeout
+	}
+}
+
+func (p *Package) validate() error {
+	if p.Action != "" && !filepath.IsLocal(filepath.FromSlash(p.Action)) {
+		return fmt.Errorf("bad package %q: action %q should be a path inside the package", p.Name, p.Action)
+	}
+	if p.ActionTimeout != "" {
+		if d, err := time.ParseDuration(p.ActionTimeout); err != nil || d <= 0 {
+			return fmt.Errorf("bad package %q: action-timeout %q should be a positive duration, e.g. \"5m\"", p.Name, p.ActionTimeout)
+		}
+	}
+	return nil
+}
+
 // FillDefaults function fills default platforms information into
 // Package struct if it is not defined and path is using template.
 func (p *Package) FillDefaults() error {
@@ -717,6 +1208,83 @@ func LoadManifest(jirix *jiri.X) (Projects, Hooks, Packages, error) {
 	return LoadManifestFile(jirix, file, localProjects, nil)
 }
 
+// overrideExpiresLayout is the layout of the expires attribute of overrides.
+const overrideExpiresLayout = "2006-01-02"
+
+// OverrideExpired returns whether an override whose expires attribute is
+// expires has expired at now. Overrides expire at the end of their expiry
+// date, in local time.
+func OverrideExpired(expires string, now time.Time) (bool, error) {
+	if expires == "" {
+		return false, nil
+	}
+	t, err := time.ParseInLocation(overrideExpiresLayout, expires, time.Local)
+	if err != nil {
+		return false, fmt.Errorf("bad expires date %q, should be YYYY-MM-DD", expires)
+	}
+	return !now.Before(t.AddDate(0, 0, 1)), nil
+}
+
+// checkOverridesExpires checks the expires attribute of the overrides of m.
+func checkOverridesExpires(m *Manifest) error {
+	var dates []string
+	for _, v := range m.ProjectOverrides {
+		dates = append(dates, v.Expires)
+	}
+	for _, v := range m.ImportOverrides {
+		dates = append(dates, v.Expires)
+	}
+	for _, v := range m.HookOverrides {
+		dates = append(dates, v.Expires)
+	}
+	for _, v := range m.PackageOverrides {
+		dates = append(dates, v.Expires)
+	}
+	for _, d := range dates {
+		if _, err := OverrideExpired(d, time.Now()); err != nil {
+			return fmt.Errorf("bad override: %v", err)
+		}
+	}
+	return nil
+}
+
+// expiredOverrides returns a description of each override of ld that expired
+// at now, sorted.
+func (ld *loader) expiredOverrides(now time.Time) []string {
+	var expired []string
+	add := func(expires, format string, args ...any) {
+		if ok, _ := OverrideExpired(expires, now); ok {
+			expired = append(expired, fmt.Sprintf(format, args...)+" expired on "+expires)
+		}
+	}
+	for _, v := range ld.ProjectOverrides {
+		add(v.Expires, "override of project %s(remote: %s)", v.Name, v.Remote)
+	}
+	for _, v := range ld.ImportOverrides {
+		add(v.Expires, "override of import %s(remote: %s)", v.Name, v.Remote)
+	}
+	for _, v := range ld.HookOverrides {
+		add(v.Expires, "override of hook %s(project: %s)", v.Name, v.ProjectName)
+	}
+	for _, v := range ld.PackageOverrides {
+		add(v.Expires, "override of package %s", v.Name)
+	}
+	sort.Strings(expired)
+	return expired
+}
+
+// checkExpiredOverrides fails if jirix.FailExpiredOverrides is set and some
+// overrides of ld expired.
+func (ld *loader) checkExpiredOverrides(jirix *jiri.X) error {
+	if !jirix.FailExpiredOverrides {
+		return nil
+	}
+	if expired := ld.expiredOverrides(time.Now()); len(expired) != 0 {
+		return fmt.Errorf("%s has expired overrides, remove them with \"jiri override -delete\" or extend their expires date:\n%s", jirix.JiriManifestFile(), strings.Join(expired, "\n"))
+	}
+	return nil
+}
+
 func (ld *loader) warnOverrides(jirix *jiri.X) {
 	if len(ld.ProjectOverrides) != 0 {
 		for _, v := range ld.ProjectOverrides {
@@ -738,13 +1306,31 @@ func (ld *loader) warnOverrides(jirix *jiri.X) {
 			jirix.OverrideWarned = true
 		}
 	}
+	for _, v := range ld.HookOverrides {
+		jirix.Logger.Warningf("Hook %s(project: %s) is overridden to run %s, if that is not what you want, please remove it from the <overrides> of %s.", v.Name, v.ProjectName, v.Action, jirix.JiriManifestFile())
+		jirix.OverrideWarned = true
+	}
+	for _, v := range ld.PackageOverrides {
+		jirix.Logger.Warningf("Package %s is overridden (version: %q, path: %q), if that is not what you want, please remove it from the <overrides> of %s.", v.Name, v.Version, v.Path, jirix.JiriManifestFile())
+		jirix.OverrideWarned = true
+	}
+	for _, e := range ld.expiredOverrides(time.Now()) {
+		jirix.Logger.Warningf("EXPIRED: the %s. It is a temporary pin which should be removed with \"jiri override -delete\" or have its expires date extended in %s.", e, jirix.JiriManifestFile())
+	}
 }
 
 func (ld *loader) enforceLocks(jirix *jiri.X) error {
 	enforceProjLocks := func(jirix *jiri.X) (err error) {
 		for _, v := range ld.Projects {
 			if projectLock, ok := ld.ProjectLocks[ProjectLockKey(v.Key())]; ok {
-				if v.Revision == "" || v.Revision == "HEAD" {
+				if vcs := v.vcs(); vcs != nil {
+					if e := vcs.ApplyLock(&v, projectLock.Revision); e != nil {
+						jirix.Logger.Debugf("%s", e)
+						err = e
+					} else {
+						ld.Projects[v.Key()] = v
+					}
+				} else if v.Revision == "" || v.Revision == "HEAD" {
 					v.Revision = projectLock.Revision
 					ld.Projects[v.Key()] = v
 				} else if v.Revision != projectLock.Revision {
@@ -818,33 +1404,95 @@ func (ld *loader) enforceLocks(jirix *jiri.X) error {
 // errors about ".git/index.lock exists", you are likely calling
 // LoadManifestFile in parallel.
 func LoadManifestFile(jirix *jiri.X, file string, localProjects Projects, localManifestProjects []string) (Projects, Hooks, Packages, error) {
+	ld, err := loadManifestFile(jirix, file, localProjects, localManifestProjects)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return ld.Projects, ld.Hooks, ld.Packages, nil
+}
+
+// LoadManifestUploadChecks loads the manifest like LoadManifestFile, and
+// returns its projects and the upload checks it declares, see UploadCheck.
+func LoadManifestUploadChecks(jirix *jiri.X, file string, localProjects Projects) (Projects, []UploadCheck, error) {
+	ld, err := loadManifestFile(jirix, file, localProjects, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	checks, err := ld.resolveUploadChecks()
+	if err != nil {
+		return nil, nil, err
+	}
+	return ld.Projects, checks, nil
+}
+
+// LoadManifestEnvs loads the manifest like LoadManifestFile, and returns the
+// environment variables and packages it defines.
+func LoadManifestEnvs(jirix *jiri.X, file string, localProjects Projects) (Envs, Packages, error) {
+	ld, err := loadManifestFile(jirix, file, localProjects, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ld.Envs, ld.Packages, nil
+}
+
+func loadManifestFile(jirix *jiri.X, file string, localProjects Projects, localManifestProjects []string) (*loader, error) {
 	ld := newManifestLoader(localProjects, false, file)
 	if err := ld.Load(jirix, "", "", file, "", "", nil, localManifestProjects); err != nil {
-		return nil, nil, nil, err
+		return nil, err
 	}
 	jirix.AddCleanupFunc(ld.cleanup)
+	ld.reportLocalManifests(jirix, localManifestProjects)
+	jirix.CIPDClient = nil
+	if ld.CIPDClient != nil {
+		jirix.CIPDClient = ld.CIPDClient.pin()
+	}
 	if jirix.LockfileEnabled {
 		if err := ld.enforceLocks(jirix); err != nil {
-			return nil, nil, nil, err
+			return nil, err
 		}
 	}
 	if !jirix.OverrideWarned {
 		ld.warnOverrides(jirix)
 	}
+	if err := ld.checkExpiredOverrides(jirix); err != nil {
+		return nil, err
+	}
+	if err := CheckPackagesAllowed(ld.Packages, ld.PackageAllowList); err != nil {
+		return nil, err
+	}
+	if file == jirix.JiriManifestFile() {
+		if err := writeHostKeys(jirix, ld.HostKeys); err != nil {
+			return nil, err
+		}
+	}
+	ld.applyGroups(jirix)
+	ld.applyInterpreters()
 	ld.GenerateGitAttributesForProjects(jirix)
-	return ld.Projects, ld.Hooks, ld.Packages, nil
+	return ld, nil
 }
 
 // LoadUpdatedManifest loads an updated manifest starting with the .jiri_manifest file for localProjects. It will use
 // local manifest files instead of manifest files in remote repositories if localManifestProjects exists and is not empty.
 func LoadUpdatedManifest(jirix *jiri.X, localProjects Projects, localManifestProjects []string) (Projects, Hooks, Packages, error) {
+	return LoadUpdatedManifestFile(jirix, jirix.JiriManifestFile(), localProjects, localManifestProjects)
+}
+
+// LoadUpdatedManifestFile is like LoadUpdatedManifest, starting with file
+// instead of the .jiri_manifest file. Imported manifest repositories are
+// fetched, but their checkouts are not changed.
+func LoadUpdatedManifestFile(jirix *jiri.X, file string, localProjects Projects, localManifestProjects []string) (Projects, Hooks, Packages, error) {
 	jirix.TimerPush("load updated manifest")
 	defer jirix.TimerPop()
-	ld := newManifestLoader(localProjects, true, jirix.JiriManifestFile())
-	if err := ld.Load(jirix, "", "", jirix.JiriManifestFile(), "", "", nil, localManifestProjects); err != nil {
+	ld := newManifestLoader(localProjects, true, file)
+	if err := ld.Load(jirix, "", "", file, "", "", nil, localManifestProjects); err != nil {
 		return nil, nil, nil, err
 	}
 	jirix.AddCleanupFunc(ld.cleanup)
+	ld.reportLocalManifests(jirix, localManifestProjects)
+	jirix.CIPDClient = nil
+	if ld.CIPDClient != nil {
+		jirix.CIPDClient = ld.CIPDClient.pin()
+	}
 	if jirix.LockfileEnabled {
 		if err := ld.enforceLocks(jirix); err != nil {
 			return nil, nil, nil, err
@@ -853,6 +1501,19 @@ func LoadUpdatedManifest(jirix *jiri.X, localProjects Projects, localManifestPro
 	if !jirix.OverrideWarned {
 		ld.warnOverrides(jirix)
 	}
+	if err := ld.checkExpiredOverrides(jirix); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := CheckPackagesAllowed(ld.Packages, ld.PackageAllowList); err != nil {
+		return nil, nil, nil, err
+	}
+	if file == jirix.JiriManifestFile() {
+		if err := writeHostKeys(jirix, ld.HostKeys); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	ld.applyGroups(jirix)
+	ld.applyInterpreters()
 	ld.GenerateGitAttributesForProjects(jirix)
 	return ld.Projects, ld.Hooks, ld.Packages, nil
 }
@@ -878,6 +1539,34 @@ func resolvePackageLocks(jirix *jiri.X, pkgs Packages) (PackageLocks, error) {
 	if err != nil {
 		return nil, err
 	}
+	// Instances registered by anyone but the signers of their package are
+	// not locked, as they would then be deployed.
+	signed := make(map[string]Package)
+	for _, pkg := range pkgs {
+		if len(pkg.Signers) == 0 {
+			continue
+		}
+		plats, err := pkg.GetPlatforms()
+		if err != nil {
+			return nil, err
+		}
+		names, err := cipd.ResolvePlatforms(pkg.Name, plats)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			signed[name] = pkg
+		}
+	}
+	for _, val := range pkgInstances {
+		pkg, ok := signed[val.PackageName]
+		if !ok {
+			continue
+		}
+		if _, err := verifyPackageSigner(jirix, pkg, val.PackageName, val.InstanceID); err != nil {
+			return nil, fmt.Errorf("refusing to lock package %s@%s: %v", val.PackageName, val.VersionTag, err)
+		}
+	}
 	// TODO: Remove this boilerplate once we have a better package
 	// layout that doesn't cause import cycles
 	pkgLocks := make(PackageLocks)
@@ -893,16 +1582,71 @@ func resolvePackageLocks(jirix *jiri.X, pkgs Packages) (PackageLocks, error) {
 	return pkgLocks, nil
 }
 
-// resolveProjectLocks resolves project revisions <project> tags in manifests
-func resolveProjectLocks(projects Projects) (ProjectLocks, error) {
+// resolveProjectLocks resolves project revisions <project> tags in manifests.
+// Revisions pinned to a tag are resolved to the commit the tag points to, so
+// that a moved tag cannot change what a lockfile checks out.
+func resolveProjectLocks(jirix *jiri.X, projects Projects) (ProjectLocks, error) {
 	projectLocks := make(ProjectLocks)
 	for _, v := range projects {
+		if vcs := v.vcs(); vcs != nil {
+			rev, err := vcs.LockRevision(jirix, v)
+			if err != nil {
+				return nil, err
+			}
+			projectLock := ProjectLock{v.Remote, v.Name, rev}
+			projectLocks[projectLock.Key()] = projectLock
+			continue
+		}
+		if IsTagRevision(v.Revision) {
+			rev, err := resolveTagRevision(jirix, v.Name, v.Remote, v.Revision)
+			if err != nil {
+				return nil, fmt.Errorf("cannot resolve %q for project %q: %v", v.Revision, v.Name, err)
+			}
+			v.Revision = rev
+		}
 		projectLock := ProjectLock{v.Remote, v.Name, v.Revision}
 		projectLocks[projectLock.Key()] = projectLock
 	}
 	return projectLocks, nil
 }
 
+// resolveTagRevision returns the commit that tag points to on remote, the
+// remote of the project with the given name. For annotated tags this is the
+// peeled commit, not the hash of the tag object.
+func resolveTagRevision(jirix *jiri.X, name, remote, tag string) (string, error) {
+	scm := newGit(jirix, gitutil.ProjectOpt(name))
+	remote = rewriteRemote(jirix, remote)
+	out, err := scm.LsRemote(remote, tag+"^{}")
+	if err != nil {
+		// Lightweight tags have no peeled entry.
+		if out, err = scm.LsRemote(remote, tag); err != nil {
+			return "", err
+		}
+	}
+	fields := strings.Fields(out)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("tag %q not found on %q", tag, remote)
+	}
+	return fields[0], nil
+}
+
+// WriteCipdEnsureFiles writes the cipd ensure and version files of pkgs to
+// file+".ensure" and file+".version" for the public packages, and to
+// file+"_internal.ensure" and file+"_internal.version" for all of them, as
+// cipd needs the internal ensure file to have the public packages too.
+func WriteCipdEnsureFiles(jirix *jiri.X, pkgs Packages, file string) error {
+	publicPkgs := make(Packages)
+	for _, pkg := range pkgs {
+		if !pkg.Internal {
+			publicPkgs[pkg.Key()] = pkg
+		}
+	}
+	if err := CreateCipdSnapshot(jirix, publicPkgs, file); err != nil {
+		return err
+	}
+	return CreateCipdSnapshot(jirix, pkgs, file+"_internal")
+}
+
 // CipdSnapshot generates a snapshot of the cipd ensure and version file
 func CreateCipdSnapshot(jirix *jiri.X, pkgs Packages, file string) error {
 	ensureSnapshotFilePath := file + ".ensure"
@@ -917,12 +1661,13 @@ func CreateCipdSnapshot(jirix *jiri.X, pkgs Packages, file string) error {
 		return err
 	}
 
-	// Move files to snapshot destination
-	err = os.Rename(ensureFilePath, ensureSnapshotFilePath)
+	// Move files to snapshot destination, which may be on another file
+	// system than the temporary files.
+	err = osutil.Rename(ensureFilePath, ensureSnapshotFilePath)
 	if err != nil {
 		return err
 	}
-	err = os.Rename(versionFilePath, versionSnapshotFilePath)
+	err = osutil.Rename(versionFilePath, versionSnapshotFilePath)
 	if err != nil {
 		return err
 	}
@@ -940,6 +1685,10 @@ func FetchPackages(jirix *jiri.X, pkgs Packages, fetchTimeout uint) error {
 		return err
 	}
 
+	if err := verifyPackageSigners(jirix, pkgsWAccess); err != nil {
+		return err
+	}
+
 	ensureFilePath, err := generateEnsureFile(jirix, pkgsWAccess, !jirix.LockfileEnabled || jirix.UsingSnapshot, "")
 	if err != nil {
 		return err
@@ -954,9 +1703,22 @@ func FetchPackages(jirix *jiri.X, pkgs Packages, fetchTimeout uint) error {
 		defer os.Remove(versionFilePath)
 	}
 
+	// The packages deployed before, to tell the instances which were
+	// already there from those fetched.
+	deployed, err := cipd.Deployed(jirix.Root)
+	if err != nil {
+		jirix.Logger.Debugf("Cannot read the deployed packages: %v", err)
+	}
 	if err := cipd.Ensure(jirix, ensureFilePath, jirix.Root, fetchTimeout); err != nil {
 		return err
 	}
+	recordDeployedPackages(jirix, deployed)
+
+	if jirix.LockfileEnabled && !jirix.UsingSnapshot {
+		if err := verifyPackageInstances(jirix, pkgsWAccess); err != nil {
+			return err
+		}
+	}
 
 	if hasInternalPkgs {
 		if err := writePackageJSON(jirix, len(pkgs) == len(pkgsWAccess)); err != nil {
@@ -965,7 +1727,11 @@ func FetchPackages(jirix *jiri.X, pkgs Packages, fetchTimeout uint) error {
 	}
 
 	// Write explicit flags.
-	if err := WritePackageFlags(jirix, pkgs, pkgsWAccess); err != nil {
+	if _, err := WritePackageFlags(jirix, pkgs, pkgsWAccess, flagMode(jirix)); err != nil {
+		return err
+	}
+
+	if err := runPackageActions(jirix, pkgsWAccess, fetchTimeout); err != nil {
 		return err
 	}
 
@@ -981,59 +1747,106 @@ func FetchPackages(jirix *jiri.X, pkgs Packages, fetchTimeout uint) error {
 	return writeAttributesJSON(jirix)
 }
 
-// WritePackageFlags write flag files into project directory using in "flag"
-// attribute from pkgs.
-func WritePackageFlags(jirix *jiri.X, pkgs, pkgsWA Packages) error {
-	// The flag attribute has a format of $FILE_NAME|$FLAG_SUCCESSFUL|$FLAG_FAILED
-	// When a package is successfully downloaded, jiri will write $FLAG_SUCCESSFUL
-	// to $FILE_NAME. If the package is not downloaded due to access reasons,
-	// jiri will write $FLAG_FAILED to $FILE_NAME.
-	// '|' is a forbidden symbol in Windows path, which is unlikely
-	// to be used by path.
-
-	flagMap := make(map[string]string)
-	fill := func(file, flag string) error {
-		if v, ok := flagMap[file]; ok {
-			if v != flag {
-				return fmt.Errorf("encountered conflicting flags for file %q: %q conflicts with %q", file, v, flag)
-			}
-		} else {
-			flagMap[file] = flag
-		}
-		return nil
+// recordDeployedPackages records the packages deployed in the root for the
+// summary of the update, as cache hits if they were in before.
+func recordDeployedPackages(jirix *jiri.X, before []cipd.DeployedPackage) {
+	after, err := cipd.Deployed(jirix.Root)
+	if err != nil {
+		jirix.Logger.Debugf("Cannot read the deployed packages: %v", err)
+		return
 	}
-
-	for k, v := range pkgs {
-		if v.Flag == "" {
+	previous := make(map[string]string)
+	for _, pkg := range before {
+		previous[pkg.Subdir+KeySeparator+pkg.PackageName] = pkg.InstanceID
+	}
+	for _, pkg := range after {
+		var size uint64
+		for _, f := range pkg.Files {
+			size += f.Size
+		}
+		jirix.RecordPackage(jiri.PackageSummary{
+			Name:       pkg.PackageName,
+			Path:       pkg.Subdir,
+			InstanceID: pkg.InstanceID,
+			Size:       size,
+			CacheHit:   previous[pkg.Subdir+KeySeparator+pkg.PackageName] == pkg.InstanceID,
+		})
+	}
+}
+
+// verifyPackageSigners checks that the instances of pkgs, for every platform
+// they resolve for, were registered by one of the Signers of their package, if
+// any are required, and records them in the VerifiedInstances of the
+// packages, so that the ensure file deploys the instances which were checked
+// rather than what their version resolves to afterwards. The instances of the
+// lockfile are checked if there are any. All platforms are checked, not only
+// the current one, as the resolved instances also end up in lockfiles and
+// exported ensure files deployed on other hosts.
+func verifyPackageSigners(jirix *jiri.X, pkgs Packages) error {
+	for key, pkg := range pkgs {
+		if len(pkg.Signers) == 0 {
 			continue
 		}
-		fields := strings.Split(v.Flag, "|")
-		if len(fields) != 3 {
-			return fmt.Errorf("unknown package flag format found in package %+v", v)
+		plats, err := pkg.GetPlatforms()
+		if err != nil {
+			return err
 		}
-		if _, ok := pkgsWA[k]; ok {
-			// package is successfully fetched, write successful flag.
-			if err := fill(fields[0], fields[1]); err != nil {
-				return err
+		names, err := cipd.ResolvePlatforms(pkg.Name, plats)
+		if err != nil {
+			return err
+		}
+		locked := make(map[string]string)
+		for _, ins := range pkg.Instances {
+			locked[ins.Name] = ins.ID
+		}
+		var verified []PackageInstance
+		for _, name := range names {
+			version := pkg.Version
+			if id, ok := locked[name]; ok {
+				version = id
 			}
-		} else {
-			// package is failed to fetched, write failed flag.
-			if err := fill(fields[0], fields[2]); err != nil {
-				return err
+			id, err := verifyPackageSigner(jirix, pkg, name, version)
+			if err != nil {
+				return fmt.Errorf("refusing to deploy package %s@%s: %v", name, pkg.Version, err)
 			}
+			verified = append(verified, PackageInstance{Name: name, ID: id})
 		}
+		pkg.VerifiedInstances = verified
+		pkgs[key] = pkg
 	}
+	return nil
+}
 
-	var writeErrorBuf bytes.Buffer
-	for k, v := range flagMap {
-		if err := os.WriteFile(filepath.Join(jirix.Root, k), []byte(v), 0644); err != nil {
-			writeErrorBuf.WriteString(fmt.Sprintf("write package flag %q to file %q failed due to error: %v\n", v, k, err))
-		}
+// verifyPackageSigner checks that the instance of the package name that
+// version resolves to was registered by one of the Signers of pkg, and
+// returns its instance id.
+func verifyPackageSigner(jirix *jiri.X, pkg Package, name, version string) (string, error) {
+	desc, err := cipd.Describe(jirix, name, version)
+	if err != nil {
+		return "", err
 	}
-	if writeErrorBuf.Len() > 0 {
-		return errors.New(writeErrorBuf.String())
+	if !slices.Contains(pkg.Signers, desc.RegisteredBy) {
+		return "", fmt.Errorf("instance %s was registered by %q, which is not an allowed signer %v", desc.InstanceID, desc.RegisteredBy, pkg.Signers)
 	}
-	return nil
+	return desc.InstanceID, nil
+}
+
+// WritePackageFlags write flag files into project directory using in "flag"
+// attribute from pkgs, as mode says, and returns the report of the flag
+// files.
+func WritePackageFlags(jirix *jiri.X, pkgs, pkgsWA Packages, mode FlagMode) (*FlagReport, error) {
+	// The flag attribute has a format of $FILE_NAME|$FLAG_SUCCESSFUL|$FLAG_FAILED
+	// When a package is successfully downloaded, jiri will write $FLAG_SUCCESSFUL
+	// to $FILE_NAME. If the package is not downloaded due to access reasons,
+	// jiri will write $FLAG_FAILED to $FILE_NAME.
+	// '|' is a forbidden symbol in Windows path, which is unlikely
+	// to be used by path.
+	writes, err := packageFlagWrites(jirix, pkgs, pkgsWA)
+	if err != nil {
+		return nil, err
+	}
+	report := newFlagReport(writes)
+	return report, report.write(jirix, mode)
 }
 
 // GenerateJSON generates a json file which contains fetched
@@ -1051,7 +1864,7 @@ func writePackageJSON(jirix *jiri.X, access bool) error {
 		// Skip json file creation if PrebuiltJSON is not set.
 		return nil
 	}
-	return os.WriteFile(filepath.Join(jirix.RootMetaDir(), jirix.PrebuiltJSON), jsonData, 0644)
+	return SafeWriteFile(jirix, filepath.Join(jirix.RootMetaDir(), jirix.PrebuiltJSON), jsonData)
 }
 
 func generateEnsureFile(jirix *jiri.X, pkgs Packages, ignoreCryptoCheck bool, versionFilePath string) (string, error) {
@@ -1149,6 +1962,18 @@ func (p *Package) cipdDecl(jirix *jiri.X) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	if len(p.VerifiedInstances) != 0 {
+		candPath, err := cipd.ResolvePlatforms(p.Name, []cipd.Platform{cipd.CurrentPlatform})
+		if err != nil {
+			return "", err
+		}
+		for _, inst := range p.VerifiedInstances {
+			if len(candPath) > 0 && inst.Name == candPath[0] {
+				buf.WriteString(fmt.Sprintf("%s %s\n", inst.Name, inst.ID))
+				return buf.String(), nil
+			}
+		}
+	}
 	if jirix.UsingSnapshot && len(p.Instances) != 0 {
 		candPath, err := cipd.ResolvePlatforms(p.Name, []cipd.Platform{cipd.CurrentPlatform})
 		if err != nil {
@@ -1193,16 +2018,71 @@ func generateVersionFile(jirix *jiri.X, ensureFile string, pkgs Packages) (strin
 		versionFileBuf.WriteString(decl)
 	}
 	jirix.Logger.Debugf("Generated version file content:\n%v", versionFileBuf.String())
-	return versionFileName, os.WriteFile(versionFileName, versionFileBuf.Bytes(), 0655)
+	return versionFileName, SafeWriteFileMode(jirix, versionFileName, versionFileBuf.Bytes(), 0655)
+}
+
+// CheckHookPackages verifies that the packages required by hooks are in pkgs
+// and present on disk, so that no hook runs before its packages are fetched.
+func CheckHookPackages(jirix *jiri.X, hooks Hooks, pkgs Packages) error {
+	byName := make(map[string][]Package)
+	for _, pkg := range pkgs {
+		byName[pkg.Name] = append(byName[pkg.Name], pkg)
+	}
+	var sorted HooksByName
+	for _, hook := range hooks {
+		sorted = append(sorted, hook)
+	}
+	sort.Sort(sorted)
+	var errs []string
+	for _, hook := range sorted {
+		for _, name := range hook.RequiredPackages() {
+			candidates, ok := byName[name]
+			if !ok {
+				errs = append(errs, fmt.Sprintf("hook %q of project %q requires package %q, which is not declared in the manifest or is filtered out by attributes, groups or -package-to-skip", hook.Name, hook.ProjectName, name))
+				continue
+			}
+			found := false
+			for _, pkg := range candidates {
+				pkgPath, err := pkg.ResolvePath()
+				if err != nil {
+					return err
+				}
+				if _, err := os.Stat(filepath.Join(jirix.Root, pkgPath)); err == nil {
+					found = true
+					break
+				}
+			}
+			if !found {
+				errs = append(errs, fmt.Sprintf("hook %q of project %q requires package %q, which is not fetched; run 'jiri fetch-packages' or update with -fetch-packages=true", hook.Name, hook.ProjectName, name))
+			}
+		}
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("missing packages required by hooks:\n%s", strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+// RunHooks runs hooks, in parallel. Unless force is set, hooks whose inputs
+// did not change since they last ran successfully are skipped, see
+// Hook.Inputs.
+func RunHooks(jirix *jiri.X, hooks Hooks, runHookTimeout uint, force bool) error {
+	if err := runChangedHooks(jirix, hooks, runHookTimeout, force); err != nil {
+		return &jiri.HookError{Err: err}
+	}
+	return nil
 }
 
-// RunHooks runs all given hooks.
-func RunHooks(jirix *jiri.X, hooks Hooks, runHookTimeout uint) error {
+// runHooks runs hooks, in parallel, and calls succeeded, if not nil, with
+// each hook which succeeds.
+func runHooks(jirix *jiri.X, hooks Hooks, runHookTimeout uint, succeeded func(Hook)) error {
 	jirix.TimerPush("run hooks")
 	defer jirix.TimerPop()
-	jirix.Logger.Debugf("Running Jiri hooks")
-	defer jirix.Logger.Debugf("Running Jiri ")
+	jirix.Logger.Component("hooks").Debugf("Running Jiri hooks")
+	defer jirix.Logger.Component("hooks").Debugf("Running Jiri ")
 	type result struct {
+		hook    Hook
+		timeout time.Duration
 		outFile *os.File
 		errFile *os.File
 		err     error
@@ -1216,47 +2096,67 @@ func RunHooks(jirix *jiri.X, hooks Hooks, runHookTimeout uint) error {
 	for _, hook := range hooks {
 		go func(hook Hook) {
 			logStr := fmt.Sprintf("running hook(%s) for project %q", hook.Name, hook.ProjectName)
-			jirix.Logger.Debugf("%s", logStr)
+			jirix.Logger.Component("hooks").Debugf("%s", logStr)
 			task := jirix.Logger.AddTaskMsg("%s", logStr)
 			defer task.Done()
 			outFile, err := os.CreateTemp(tmpDir, hook.Name+"-out")
 			if err != nil {
-				ch <- result{nil, nil, fmtError(err)}
+				ch <- result{hook: hook, err: fmtError(err)}
 				return
 			}
 			errFile, err := os.CreateTemp(tmpDir, hook.Name+"-err")
 			if err != nil {
-				ch <- result{nil, nil, fmtError(err)}
+				ch <- result{hook: hook, err: fmtError(err)}
 				return
 			}
 
 			fmt.Fprintf(outFile, "output for hook(%v) for project %q\n", hook.Name, hook.ProjectName)
 			fmt.Fprintf(errFile, "Error for hook(%v) for project %q\n", hook.Name, hook.ProjectName)
 			cmdLine := filepath.Join(hook.ActionPath, hook.Action)
+			timeout := hook.timeout(time.Duration(runHookTimeout) * time.Minute)
+			start, exitCode := time.Now(), -1
 			err = retry.Function(jirix, func() error {
-				ctx, cancel := context.WithTimeout(context.Background(), time.Duration(runHookTimeout)*time.Minute)
+				exitCode = -1
+				ctx, cancel := context.WithTimeout(context.Background(), timeout)
 				defer cancel()
-				command := exec.CommandContext(ctx, cmdLine)
+				command, err := hookCommand(ctx, jirix, hook)
+				if err != nil {
+					return err
+				}
+				osutil.KillProcessGroupOnCancel(command)
 				command.Dir = hook.ActionPath
 				command.Stdin = os.Stdin
 				command.Stdout = outFile
 				command.Stderr = errFile
 				env := jirix.Env()
 				command.Env = envvar.MapToSlice(env)
-				jirix.Logger.Tracef("Run: %q", cmdLine)
+				jirix.Logger.Component("hooks").Tracef("Run: %q", command.Args)
 				err = command.Run()
+				if command.ProcessState != nil {
+					exitCode = command.ProcessState.ExitCode()
+				}
 				if ctx.Err() == context.DeadlineExceeded {
 					err = ctx.Err()
 				}
-				scm := gitutil.New(jirix, gitutil.RootDirOpt(filepath.Dir(filepath.Dir(cmdLine))))
+				scm := newGit(jirix, gitutil.RootDirOpt(filepath.Dir(filepath.Dir(cmdLine))))
 				revision, err2 := scm.CurrentRevisionOfBranch("HEAD")
 				if err2 == nil {
-					jirix.Logger.Debugf("  Invoked hook(%v) for project %q on revision %q", hook.Name, hook.ProjectName, revision)
+					jirix.Logger.Component("hooks").Debugf("  Invoked hook(%v) for project %q on revision %q", hook.Name, hook.ProjectName, revision)
 				}
 				return err
 			}, fmt.Sprintf("running hook(%s) for project %s", hook.Name, hook.ProjectName),
 				retry.AttemptsOpt(jirix.Attempts))
-			ch <- result{outFile, errFile, err}
+			summary := jiri.HookSummary{
+				Name:     hook.Name,
+				Project:  hook.ProjectName,
+				Duration: time.Since(start).Seconds(),
+				ExitCode: exitCode,
+			}
+			if err != nil {
+				summary.Error = err.Error()
+			}
+			jirix.RecordHook(summary)
+			ch <- result{hook, timeout, outFile, errFile, err}
 		}(hook)
 
 	}
@@ -1279,7 +2179,7 @@ func RunHooks(jirix *jiri.X, hooks Hooks, runHookTimeout uint) error {
 			out.outFile.Seek(0, 0)
 			var buf bytes.Buffer
 			io.Copy(&buf, out.outFile)
-			jirix.Logger.Errorf("Timeout while executing hook\n%s\n\n", buf.String())
+			jirix.Logger.Component("hooks").Errorf("Timeout after %s while executing hook(%s) for project %q\n%s\n\n", out.timeout, out.hook.Name, out.hook.ProjectName, buf.String())
 			err = fmt.Errorf("Hooks execution failed.")
 			continue
 		}
@@ -1296,16 +2196,19 @@ func RunHooks(jirix *jiri.X, hooks Hooks, runHookTimeout uint) error {
 				out.errFile.Seek(0, 0)
 				io.Copy(&buf, out.errFile)
 			}
-			jirix.Logger.Errorf("%s\n%s\n%s\n", out.err, buf.String(), outBuf.String())
+			jirix.Logger.Component("hooks").Errorf("%s\n%s\n%s\n", out.err, buf.String(), outBuf.String())
 			err = fmt.Errorf("Hooks execution failed.")
 		} else {
 			if outBuf.String() != "" {
-				jirix.Logger.Debugf("%s\n", outBuf.String())
+				jirix.Logger.Component("hooks").Debugf("%s\n", outBuf.String())
+			}
+			if succeeded != nil {
+				succeeded(out.hook)
 			}
 		}
 	}
 	if timeout {
-		err = fmt.Errorf("%s Use %s flag to set timeout.", err, jirix.Color.Yellow("-hook-timeout"))
+		err = fmt.Errorf("%s Use the %s attribute of the hook or the %s flag to set timeout.", err, jirix.Color.Yellow("timeout"), jirix.Color.Yellow("-hook-timeout"))
 	}
 	return err
 }
@@ -1371,6 +2274,74 @@ func (f commitMsgFetcher) fetch(jirix *jiri.X, gerritHost string) ([]byte, error
 	return bytes, nil
 }
 
+// GitHooksDrift returns the hooks of the githooks directory of p, relative to
+// it, which are missing from the git hooks directory of the project or differ
+// from the installed ones, e.g. because they were modified locally or the
+// manifest changed them since they were installed.
+func GitHooksDrift(jirix *jiri.X, p Project) ([]string, error) {
+	if p.GitHooks == "" {
+		return nil, nil
+	}
+	gitHooksDstDir, err := newGit(jirix, gitutil.RootDirOpt(p.Path)).CurrentGitHooksPath()
+	if err != nil {
+		return nil, err
+	}
+	var drifted []string
+	err = filepath.WalkDir(p.GitHooks, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		relPath, err := filepath.Rel(p.GitHooks, path)
+		if err != nil {
+			return err
+		}
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		dst, err := os.ReadFile(filepath.Join(gitHooksDstDir, relPath))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err != nil || sha256.Sum256(src) != sha256.Sum256(dst) {
+			drifted = append(drifted, relPath)
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		// The githooks directory is not checked out, e.g. because the
+		// project declaring it is not part of the root anymore.
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmtError(err)
+	}
+	return drifted, nil
+}
+
+// warnGitHooksDrift warns about the projects of ops whose git hooks differ from
+// those of the manifest, when jiri is configured to keep them.
+func warnGitHooksDrift(jirix *jiri.X, ops []operation) {
+	var drifted []string
+	for _, op := range ops {
+		if _, err := os.Stat(op.Project().Path); err != nil {
+			continue
+		}
+		hooks, err := GitHooksDrift(jirix, op.Project())
+		if err != nil {
+			jirix.Logger.Debugf("Failed to check git hooks of %s: %v", op.Project().Name, err)
+			continue
+		}
+		if len(hooks) != 0 {
+			drifted = append(drifted, fmt.Sprintf("%s(%s)", op.Project().Name, strings.Join(hooks, ", ")))
+		}
+	}
+	if len(drifted) != 0 {
+		sort.Strings(drifted)
+		jirix.Logger.Warningf("Git hooks of %d project(s) differ from those of the manifest: %s\nRun 'jiri update -force-githooks' to reinstall them.\n\n", len(drifted), strings.Join(drifted, "; "))
+	}
+}
+
 func applyGitHooks(jirix *jiri.X, ops []operation) error {
 	jirix.TimerPush("apply githooks")
 	defer jirix.TimerPop()
@@ -1380,7 +2351,7 @@ func applyGitHooks(jirix *jiri.X, ops []operation) error {
 			continue
 		}
 		// Dynamically find githook directory.
-		scm := gitutil.New(jirix, gitutil.RootDirOpt(op.Project().Path))
+		scm := newGit(jirix, gitutil.RootDirOpt(op.Project().Path))
 		gitHooksDstDir, err := scm.CurrentGitHooksPath()
 		if err != nil {
 			return err
@@ -0,0 +1,228 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/gitutil"
+	"go.fuchsia.dev/jiri/manifestedit"
+)
+
+// NewProjectParams describes a project created by NewProject.
+type NewProjectParams struct {
+	// Project is the project to create. Its Path is absolute.
+	Project Project
+	// Template is a directory of seed files copied into the project, if
+	// set. Files named *.tmpl are text/templates of TemplateData, and lose
+	// their suffix.
+	Template string
+	// Owners, if any, are written to the OWNERS file of the project.
+	Owners []string
+	// Manifest is the manifest file the project is added to, if set.
+	Manifest string
+	// CreateRemote is a command creating the remote of the project, if
+	// set. It is run with the name and remote of the project as arguments,
+	// and the initial commit is then pushed to the remote.
+	CreateRemote string
+}
+
+// TemplateData is the data the *.tmpl files of the template of a new
+// project are executed with.
+type TemplateData struct {
+	Name         string
+	Path         string
+	Remote       string
+	RemoteBranch string
+	Owners       []string
+	Year         int
+}
+
+// NewProject creates the git repository of a new project from a template,
+// commits its files, and adds the project to a manifest, so that it is a
+// jiri project like the ones checked out by "jiri update".
+func NewProject(jirix *jiri.X, params NewProjectParams) (e error) {
+	p := params.Project
+	if err := p.fillDefaults(); err != nil {
+		return err
+	}
+	if entries, err := os.ReadDir(p.Path); err == nil && len(entries) != 0 {
+		return fmt.Errorf("directory %s already exists and is not empty", p.Path)
+	} else if err != nil && !os.IsNotExist(err) {
+		return fmtError(err)
+	}
+	rel, err := filepath.Rel(jirix.Root, p.Path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return fmt.Errorf("project path %s is not inside the root %s", p.Path, jirix.Root)
+	}
+
+	var manifest []byte
+	if params.Manifest != "" {
+		// The manifest is edited first so that nothing is created if the
+		// project cannot be added to it.
+		if manifest, err = addProjectToManifest(params.Manifest, p, filepath.ToSlash(rel)); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(p.Path, 0755); err != nil {
+		return fmtError(err)
+	}
+	defer func() {
+		if e != nil {
+			os.RemoveAll(p.Path)
+		}
+	}()
+	data := TemplateData{
+		Name:         p.Name,
+		Path:         filepath.ToSlash(rel),
+		Remote:       p.Remote,
+		RemoteBranch: p.RemoteBranch,
+		Owners:       params.Owners,
+		Year:         time.Now().Year(),
+	}
+	if params.Template != "" {
+		if err := copyProjectTemplate(params.Template, p.Path, data); err != nil {
+			return err
+		}
+	}
+	if len(params.Owners) != 0 {
+		owners := strings.Join(params.Owners, "\n") + "\n"
+		if err := os.WriteFile(filepath.Join(p.Path, "OWNERS"), []byte(owners), 0644); err != nil {
+			return fmtError(err)
+		}
+	}
+
+	scm := newGit(jirix, gitutil.RootDirOpt(p.Path))
+	if err := scm.Init(p.Path); err != nil {
+		return err
+	}
+	if email, err := scm.ConfigGetKey("user.email"); err != nil || email == "" {
+		scm = newGit(jirix, gitutil.RootDirOpt(p.Path), gitutil.UserNameOpt("jiri"), gitutil.UserEmailOpt("jiri@localhost"))
+	}
+	if err := scm.SetHeadBranch(p.RemoteBranch); err != nil {
+		return err
+	}
+	if err := scm.Add("."); err != nil {
+		return err
+	}
+	if err := scm.CommitWithMessage("Initial commit"); err != nil {
+		return err
+	}
+	if err := scm.AddRemote(p.PrimaryRemote(), p.Remote); err != nil {
+		return err
+	}
+	if params.CreateRemote != "" {
+		cmd := exec.Command(params.CreateRemote, p.Name, p.Remote)
+		cmd.Dir = p.Path
+		cmd.Env = append(os.Environ(), "JIRI_ROOT="+jirix.Root)
+		cmd.Stdout = jirix.Stdout()
+		cmd.Stderr = jirix.Stderr()
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("creating the remote %s with %s: %v", p.Remote, params.CreateRemote, err)
+		}
+		if err := scm.Push(p.PrimaryRemote(), "HEAD:refs/heads/"+p.RemoteBranch, gitutil.VerifyOpt(false)); err != nil {
+			return err
+		}
+	}
+	if err := writeMetadata(jirix, p, p.Path); err != nil {
+		return err
+	}
+	if params.Manifest != "" {
+		if err := SafeWriteFile(jirix, params.Manifest, manifest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addProjectToManifest returns the text of the manifest file with p, at
+// the path rel of the root, added to its projects.
+func addProjectToManifest(file string, p Project, rel string) ([]byte, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmtError(err)
+	}
+	e, err := manifestedit.New(content)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	if e.Count(manifestedit.Elem("project", p.Name)) != 0 {
+		return nil, fmt.Errorf("project %s is already in %s", p.Name, file)
+	}
+	projects := manifestedit.Selector{Tag: "projects"}
+	if e.Count(projects) == 0 {
+		if err := e.AppendElement(manifestedit.Selector{Tag: "manifest"}, "<projects/>"); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+	}
+	attrs := [][2]string{{"name", p.Name}, {"path", rel}, {"remote", p.Remote}}
+	if p.RemoteBranch != "main" {
+		attrs = append(attrs, [2]string{"remotebranch", p.RemoteBranch})
+	}
+	var elem strings.Builder
+	elem.WriteString("<project")
+	for _, attr := range attrs {
+		fmt.Fprintf(&elem, " %s=\"", attr[0])
+		xml.EscapeText(&elem, []byte(attr[1]))
+		elem.WriteString("\"")
+	}
+	elem.WriteString("/>")
+	if err := e.AppendElement(projects, elem.String()); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return e.Bytes(), nil
+}
+
+// copyProjectTemplate copies the files of the template directory src into
+// dir, executing the *.tmpl files with data.
+func copyProjectTemplate(src, dir string, data TemplateData) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(filepath.Join(dir, rel), 0755)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if strings.HasSuffix(rel, ".tmpl") {
+			rel = strings.TrimSuffix(rel, ".tmpl")
+			tmpl, err := template.New(rel).Option("missingkey=error").Parse(string(content))
+			if err != nil {
+				return fmt.Errorf("parsing template %s: %v", path, err)
+			}
+			var buf bytes.Buffer
+			if err := tmpl.Execute(&buf, data); err != nil {
+				return fmt.Errorf("executing template %s: %v", path, err)
+			}
+			content = buf.Bytes()
+		}
+		return os.WriteFile(filepath.Join(dir, rel), content, info.Mode().Perm())
+	})
+}
@@ -12,6 +12,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
 	"slices"
 	"sort"
 	"strings"
@@ -30,6 +31,7 @@ const (
 	moveOpKind         = "move"
 	nullOpKind         = "null"
 	updateOpKind       = "update"
+	vcsOpKind          = "vcs"
 )
 
 type operation interface {
@@ -75,6 +77,15 @@ func (op commonOperation) Destination() string {
 	return op.destination
 }
 
+// fromRevision describes the revision the project is checked out at before
+// the operation, if known.
+func (op commonOperation) fromRevision() string {
+	if r := op.state.CurrentBranch.Revision; r != "" {
+		return fmt.Sprintf(" from %q", fmtRevision(r))
+	}
+	return ""
+}
+
 // createOperation represents the creation of a project.
 type createOperation struct {
 	commonOperation
@@ -87,13 +98,14 @@ func (op createOperation) Kind() string {
 func (op createOperation) checkoutProject(jirix *jiri.X, cache string) error {
 	var err error
 	remote := rewriteRemote(jirix, op.project.Remote)
-	scm := gitutil.New(jirix, gitutil.RootDirOpt(op.project.Path))
+	remoteName := op.project.PrimaryRemote()
+	scm := newGit(jirix, gitutil.RootDirOpt(op.project.Path))
 	// Hack to make fuchsia.git happen
 	if op.destination == jirix.Root {
 		if err = scm.Init(op.destination); err != nil {
 			return err
 		}
-		if err = scm.AddOrReplaceRemote("origin", remote); err != nil {
+		if err = scm.AddOrReplaceRemote(remoteName, remote); err != nil {
 			return err
 		}
 		// This appears to be set to 0 via some quirk of `git init`.
@@ -101,16 +113,16 @@ func (op createOperation) checkoutProject(jirix *jiri.X, cache string) error {
 			return err
 		}
 		if jirix.UsePartialClone(op.project.Remote) {
-			if err := scm.Config("extensions.partialClone", "origin"); err != nil {
+			if err := scm.Config("extensions.partialClone", remoteName); err != nil {
 				return err
 			}
-			if err := scm.AddOrReplacePartialRemote("origin", remote); err != nil {
+			if err := scm.AddOrReplacePartialRemote(remoteName, remote); err != nil {
 				return err
 			}
 		}
 		// We must specify a refspec here in order for patch to be able to set
 		// upstream to 'origin/main'.
-		if err := scm.Config("remote.origin.fetch", "+refs/heads/*:refs/remotes/origin/*"); err != nil {
+		if err := scm.Config("remote."+remoteName+".fetch", "+refs/heads/*:refs/remotes/"+remoteName+"/*"); err != nil {
 			return err
 		}
 		if cache != "" {
@@ -135,7 +147,7 @@ func (op createOperation) checkoutProject(jirix *jiri.X, cache string) error {
 			// https://github.com/git/git/blob/main/builtin/clone.c#L1399 for
 			// more details.
 			opts := []gitutil.RepackOpt{gitutil.RepackAllOpt(true), gitutil.RemoveRedundantOpt(true)}
-			if err := gitutil.New(jirix).Repack(opts...); err != nil {
+			if err := newGit(jirix).Repack(opts...); err != nil {
 				return err
 			}
 			if err := os.Remove(filepath.Join(op.destination, ".git/objects/info/alternates")); err != nil {
@@ -147,14 +159,17 @@ func (op createOperation) checkoutProject(jirix *jiri.X, cache string) error {
 		if cache != "" {
 			r = cache
 			defer func() {
-				if err := scm.AddOrReplaceRemote("origin", remote); err != nil {
+				if err := scm.AddOrReplaceRemote(remoteName, remote); err != nil {
 					jirix.Logger.Errorf("failed to set remote back to %v for project %+v", remote, op.project)
 				}
 			}()
 		}
 		opts := []gitutil.CloneOpt{gitutil.NoCheckoutOpt(true)}
-		if op.project.HistoryDepth > 0 {
-			opts = append(opts, gitutil.DepthOpt(op.project.HistoryDepth))
+		if remoteName != "origin" {
+			opts = append(opts, gitutil.OriginOpt(remoteName))
+		}
+		if op.project.isShallow() {
+			opts = append(opts, gitutil.DepthOpt(op.project.HistoryDepth), gitutil.ShallowSinceOpt(op.project.ShallowSince))
 		} else {
 			// Shallow clones can not be used as as local git reference
 			opts = append(opts, gitutil.ReferenceOpt(cache))
@@ -166,7 +181,27 @@ func (op createOperation) checkoutProject(jirix *jiri.X, cache string) error {
 		if jirix.Dissociate {
 			opts = append(opts, gitutil.DissociateOpt(true))
 		}
-		if err = clone(jirix, r, op.destination, opts...); err != nil {
+		// Only the clone itself is timed here: the fetch and checkout
+		// phases are timed on their own, and phases must not overlap for
+		// the total time of the project to be right.
+		stop := timePhase(jirix, op.project, "clone")
+		// Bundles only help when cloning from the remote itself.
+		if cache != "" || !cloneFromBundle(jirix, op.project.Name, r, op.destination, op.project.BundleURL, op.project.isShallow(), opts...) {
+			if cache != "" {
+				err = clone(jirix, op.project.Name, r, op.destination, opts...)
+			} else {
+				err = withAltRemotes(jirix, op.project, r, func(r string) error {
+					return clone(jirix, op.project.Name, r, op.destination, opts...)
+				})
+				// Keep the remote of the project, even if it was cloned
+				// from an alternate remote.
+				if err == nil && len(op.project.AltRemotes) != 0 {
+					err = scm.SetRemoteUrl(remoteName, remote)
+				}
+			}
+		}
+		stop()
+		if err != nil {
 			return err
 		}
 	}
@@ -186,7 +221,7 @@ func (op createOperation) checkoutProject(jirix *jiri.X, cache string) error {
 	if branches, _, err := scm.GetBranches(); err != nil {
 		jirix.Logger.Warningf("not able to get branches for newly created project %s(%s)\n\n", op.project.Name, op.project.Path)
 	} else {
-		scm := gitutil.New(jirix, gitutil.RootDirOpt(op.project.Path))
+		scm := newGit(jirix, gitutil.RootDirOpt(op.project.Path))
 		for _, b := range branches {
 			if err := scm.DeleteBranch(b); err != nil {
 				jirix.Logger.Warningf("not able to delete branch %s for project %s(%s)\n\n", b, op.project.Name, op.project.Path)
@@ -206,7 +241,12 @@ func (op createOperation) Run(jirix *jiri.X) (e error) {
 				return fmtError(err)
 			}
 		} else {
-			if isEmpty, err := isEmpty(op.destination); err != nil {
+			if isIncompleteClone(jirix, op.destination) {
+				jirix.Logger.Warningf("Removing the incomplete clone of project %s(%s) left by an interrupted update\n\n", op.project.Name, op.destination)
+				if err := os.RemoveAll(op.destination); err != nil {
+					return fmtError(err)
+				}
+			} else if isEmpty, err := isEmpty(op.destination); err != nil {
 				return err
 			} else if !isEmpty {
 				return fmt.Errorf("cannot create %q as it already exists and is not empty", op.destination)
@@ -230,15 +270,35 @@ func (op createOperation) Run(jirix *jiri.X) (e error) {
 		cache = ""
 	}
 
-	if err := op.checkoutProject(jirix, cache); err != nil {
-		if op.destination != jirix.Root {
-			if err := os.RemoveAll(op.destination); err != nil {
-				jirix.Logger.Warningf("Not able to remove %q after create failed: %s", op.destination, err)
-			}
+	if op.destination == jirix.Root {
+		return op.checkoutProject(jirix, cache)
+	}
+	if err := markCloneInProgress(jirix, op.destination); err != nil {
+		return err
+	}
+	// The marker only outlives the clone if jiri is interrupted: a failed
+	// clone is removed along with its marker.
+	defer func() {
+		if err := markCloneDone(jirix, op.destination); err != nil && e == nil {
+			e = err
+		}
+	}()
+	err = op.checkoutProject(jirix, cache)
+	if err != nil && isCorruptClone(jirix, op.destination) {
+		// The clone misses objects, which happens when a flaky network
+		// truncates a packfile: clean it and clone again once.
+		jirix.Logger.Warningf("Clone of project %s(%s) is incomplete, cloning it again: %s\n\n", op.project.Name, op.destination, err)
+		if err := os.RemoveAll(op.destination); err != nil {
+			return fmtError(err)
+		}
+		err = op.checkoutProject(jirix, cache)
+	}
+	if err != nil {
+		if err := os.RemoveAll(op.destination); err != nil {
+			jirix.Logger.Warningf("Not able to remove %q after create failed: %s", op.destination, err)
 		}
 		return err
 	}
-
 	return nil
 }
 
@@ -253,6 +313,78 @@ func (op createOperation) Test(jirix *jiri.X) error {
 // deleteOperation represents the deletion of a project.
 type deleteOperation struct {
 	commonOperation
+	// force deletes the project even if it contains branches or local
+	// changes.
+	force bool
+	// keep are the paths of the live projects, of the manifest or not
+	// updated, nested in the project. They are left in place when the
+	// project is deleted.
+	keep []string
+}
+
+// nestedProjectPaths returns the paths of the projects strictly under dir.
+func nestedProjectPaths(dir string, projects ...Projects) []string {
+	var paths []string
+	prefix := filepath.Clean(dir) + string(filepath.Separator)
+	for _, ps := range projects {
+		for _, p := range ps {
+			if strings.HasPrefix(filepath.Clean(p.Path), prefix) {
+				paths = append(paths, filepath.Clean(p.Path))
+			}
+		}
+	}
+	sort.Strings(paths)
+	return slices.Compact(paths)
+}
+
+// removeAllExcept removes dir and everything in it, except the paths of keep
+// under dir and the directories leading to them.
+func removeAllExcept(dir string, keep []string) error {
+	if len(keep) == 0 {
+		return os.RemoveAll(dir)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name := filepath.Join(dir, e.Name())
+		var nested []string
+		kept := false
+		for _, k := range keep {
+			if k == name {
+				kept = true
+				break
+			}
+			if strings.HasPrefix(k, name+string(filepath.Separator)) {
+				nested = append(nested, k)
+			}
+		}
+		switch {
+		case kept:
+		case len(nested) > 0 && e.IsDir():
+			if err := removeAllExcept(name, nested); err != nil {
+				return err
+			}
+		default:
+			if err := os.RemoveAll(name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// removeSource removes the project, leaving the projects of op.keep in
+// place.
+func (op deleteOperation) removeSource(jirix *jiri.X) error {
+	if len(op.keep) > 0 {
+		jirix.Logger.Warningf("Project %q is deleted except for the projects nested in it: %s\n\n", op.project.Name, strings.Join(op.keep, ", "))
+	}
+	if err := removeAllExcept(op.source, op.keep); err != nil {
+		return fmtError(err)
+	}
+	return removeEmptyParents(jirix, path.Dir(op.source))
 }
 
 func (op deleteOperation) Kind() string {
@@ -264,21 +396,21 @@ func (op deleteOperation) Run(jirix *jiri.X) error {
 		jirix.Logger.Warningf("Project %s(%s) won't be deleted due to its local-config\n\n", op.project.Name, op.source)
 		return nil
 	}
+	if op.force {
+		return op.removeSource(jirix)
+	}
 	// Never delete projects with non-main branches, uncommitted work, or
 	// untracked content.
-	scm := gitutil.New(jirix, gitutil.RootDirOpt(op.project.Path))
+	scm := newGit(jirix, gitutil.RootDirOpt(op.project.Path))
 	branches, _, err := scm.GetBranches()
 	if err != nil {
 		return fmt.Errorf("Cannot get branches for project %q: %s", op.Project().Name, err)
 	}
-	uncommitted, err := scm.HasUncommittedChanges()
+	status, err := scm.Status()
 	if err != nil {
 		return fmt.Errorf("Cannot get uncommitted changes for project %q: %s", op.Project().Name, err)
 	}
-	untracked, err := scm.HasUntrackedFiles()
-	if err != nil {
-		return fmt.Errorf("Cannot get untracked changes for project %q: %s", op.Project().Name, err)
-	}
+	uncommitted, untracked := len(status.Changed) != 0, len(status.Untracked) != 0
 	extraBranches := false
 	for _, branch := range branches {
 		if !strings.Contains(branch, "HEAD detached") {
@@ -305,11 +437,7 @@ func (op deleteOperation) Run(jirix *jiri.X) error {
 		jirix.Logger.Warningf("%s", msg)
 		return nil
 	}
-
-	if err := os.RemoveAll(op.source); err != nil {
-		return fmtError(err)
-	}
-	return removeEmptyParents(jirix, path.Dir(op.source))
+	return op.removeSource(jirix)
 }
 
 func removeEmptyParents(jirix *jiri.X, dir string) error {
@@ -367,6 +495,9 @@ type moveOperation struct {
 	rebaseUntracked bool
 	rebaseAll       bool
 	snapshot        bool
+	// movedFrom is the original location of the project, if it was moved
+	// along with its parent project to source.
+	movedFrom string
 }
 
 func (op moveOperation) Kind() string {
@@ -384,6 +515,20 @@ func (op moveOperation) Run(jirix *jiri.X) error {
 			return fmtError(err)
 		}
 	}
+	from := op.source
+	if op.movedFrom != "" {
+		from = op.movedFrom
+	}
+	if from != op.destination {
+		if err := relocateGitPaths(jirix, from, op.destination); err != nil {
+			return err
+		}
+	}
+	// A project whose remote changed along with its path has its remote
+	// changed once moved, as DiffStates reports both changes.
+	if (Change{Old: &op.state.Project, New: &op.project}).RemoteChanged() {
+		return changeRemoteOperation{op.commonOperation, op.rebaseTracked, op.rebaseUntracked, op.rebaseAll, op.snapshot}.Run(jirix)
+	}
 	if err := syncProjectMaster(jirix, op.project, op.state, op.rebaseTracked, op.rebaseUntracked, op.rebaseAll, op.snapshot); err != nil {
 		return err
 	}
@@ -391,7 +536,7 @@ func (op moveOperation) Run(jirix *jiri.X) error {
 }
 
 func (op moveOperation) String() string {
-	return fmt.Sprintf("move project %q located in %q to %q and advance it to %q", op.project.Name, op.source, op.destination, fmtRevision(op.project.Revision))
+	return fmt.Sprintf("move project %q located in %q to %q and advance it%s to %q", op.project.Name, op.source, op.destination, op.fromRevision(), fmtRevision(op.project.Revision))
 }
 
 func (op moveOperation) Test(jirix *jiri.X) error {
@@ -460,21 +605,52 @@ func (op changeRemoteOperation) Run(jirix *jiri.X) error {
 		jirix.Logger.Warningf("Project %s(%s) won't be updated due to its local-config. It has a changed remote\n\n", op.project.Name, op.project.Path)
 		return nil
 	}
-	git := gitutil.New(jirix, gitutil.RootDirOpt(op.project.Path))
-	tempRemote := "new-remote-origin"
-	if err := git.AddRemote(tempRemote, op.project.Remote); err != nil {
+	git := newGit(jirix, gitutil.RootDirOpt(op.project.Path))
+	remoteName := op.project.PrimaryRemote()
+	if oldName := op.state.Project.PrimaryRemote(); oldName != remoteName {
+		if err := git.RenameRemote(oldName, remoteName); err != nil {
+			return err
+		}
+	}
+	if op.state.Project.Remote != op.project.Remote {
+		if ok, err := op.branchesInNewRemote(jirix, git); err != nil || !ok {
+			return err
+		}
+	}
+
+	// Everything ok, change the remote url
+	if err := git.SetRemoteUrl(remoteName, rewriteRemote(jirix, op.project.Remote)); err != nil {
+		return err
+	}
+
+	if err := fetch(jirix, op.project.Name, op.project.Path, "", gitutil.AllOpt(true), gitutil.PruneOpt(true)); err != nil {
 		return err
 	}
-	defer git.DeleteRemote(tempRemote)
 
-	if err := fetch(jirix, op.project.Path, tempRemote); err != nil {
+	if err := syncProjectMaster(jirix, op.project, op.state, op.rebaseTracked, op.rebaseUntracked, op.rebaseAll, op.snapshot); err != nil {
 		return err
 	}
 
+	return writeMetadata(jirix, op.project, op.project.Path)
+}
+
+// branchesInNewRemote reports whether all local branches of the project are on
+// commits of its new remote, logging the branch that is not otherwise.
+func (op changeRemoteOperation) branchesInNewRemote(jirix *jiri.X, git Git) (bool, error) {
+	tempRemote := "new-remote-origin"
+	if err := git.AddRemote(tempRemote, rewriteRemote(jirix, op.project.Remote)); err != nil {
+		return false, err
+	}
+	defer git.DeleteRemote(tempRemote)
+
+	if err := fetch(jirix, op.project.Name, op.project.Path, tempRemote); err != nil {
+		return false, err
+	}
+
 	// Check for all leaf commits in new remote
 	for _, branch := range op.state.Branches {
 		if containingBranches, err := git.GetRemoteBranchesContaining(branch.Revision); err != nil {
-			return err
+			return false, err
 		} else {
 			foundBranch := false
 			for _, remoteBranchName := range containingBranches {
@@ -487,30 +663,16 @@ func (op changeRemoteOperation) Run(jirix *jiri.X) error {
 				jirix.Logger.Errorf("Note: For project %q(%v), remote url has changed. Its branch %q is on a commit", op.project.Name, op.project.Path, branch.Name)
 				jirix.Logger.Errorf("which is not in new remote(%v). Please manually reset your branches or move", op.project.Remote)
 				jirix.Logger.Errorf("your project folder out of the root and try again")
-				return nil
+				return false, nil
 			}
 
 		}
 	}
-
-	// Everything ok, change the remote url
-	if err := git.SetRemoteUrl("origin", op.project.Remote); err != nil {
-		return err
-	}
-
-	if err := fetch(jirix, op.project.Path, "", gitutil.AllOpt(true), gitutil.PruneOpt(true)); err != nil {
-		return err
-	}
-
-	if err := syncProjectMaster(jirix, op.project, op.state, op.rebaseTracked, op.rebaseUntracked, op.rebaseAll, op.snapshot); err != nil {
-		return err
-	}
-
-	return writeMetadata(jirix, op.project, op.project.Path)
+	return true, nil
 }
 
 func (op changeRemoteOperation) String() string {
-	return fmt.Sprintf("Change remote of project %q to %q and update it to %q", op.project.Name, op.project.Remote, fmtRevision(op.project.Revision))
+	return fmt.Sprintf("Change remote of project %q to %q and update it%s to %q", op.project.Name, op.project.Remote, op.fromRevision(), fmtRevision(op.project.Revision))
 }
 
 func (op changeRemoteOperation) Test(jirix *jiri.X) error {
@@ -538,7 +700,7 @@ func (op updateOperation) Run(jirix *jiri.X) error {
 }
 
 func (op updateOperation) String() string {
-	return fmt.Sprintf("advance/rebase project %q located in %q to %q", op.project.Name, op.source, fmtRevision(op.project.Revision))
+	return fmt.Sprintf("advance/rebase project %q located in %q%s to %q", op.project.Name, op.source, op.fromRevision(), fmtRevision(op.project.Revision))
 }
 
 func (op updateOperation) Test(jirix *jiri.X) error {
@@ -743,13 +905,28 @@ func computeOperations(
 		}
 		result = append(result, computeOp(jirix, local, remote, state, rebaseTracked, rebaseUntracked, rebaseAll, snapshot))
 	}
+	// Projects nested in deleted ones are kept if they are in the manifest or
+	// not updated.
+	skipped := Projects{}
+	for key := range skipProjects {
+		if p, ok := localProjects[key]; ok {
+			skipped[key] = p
+		}
+	}
+	for i, op := range result {
+		if del, ok := op.(deleteOperation); ok {
+			del.keep = nestedProjectPaths(del.source, remoteProjects, skipped)
+			result[i] = del
+		}
+	}
 	sort.Sort(result)
 	return result, nil
 }
 
 // Based on localManifestProjects, determine which projects to skip updating.
 // A project will be skipped unless it is in localManifestProjects, or is a
-// dependency of one of the localManifestProjects.
+// dependency of one of the localManifestProjects, that is, pulled in by its
+// import directly or through nested imports.
 func getProjectsToSkip(allProjects []Project, localManifestProjects []string) (map[ProjectKey]bool, error) {
 	skipProjects := make(map[ProjectKey]bool)
 	if len(localManifestProjects) == 0 {
@@ -757,13 +934,17 @@ func getProjectsToSkip(allProjects []Project, localManifestProjects []string) (m
 	}
 
 	projectsByName := make(map[string]Project)
+	imports := make(map[string]bool)
 	for _, proj := range allProjects {
 		projectsByName[proj.Name] = proj
+		for _, imp := range proj.ImportChain {
+			imports[imp] = true
+		}
 	}
 
 	// Validate local manifest projects.
 	for _, imp := range localManifestProjects {
-		if _, ok := projectsByName[imp]; !ok {
+		if _, ok := projectsByName[imp]; !ok && !imports[imp] {
 			return nil, fmt.Errorf("Local manifest project %q doesn't exist.", imp)
 		}
 	}
@@ -771,13 +952,14 @@ func getProjectsToSkip(allProjects []Project, localManifestProjects []string) (m
 	// For each project, skip it if it's not a local manifest project or a
 	// dependency of a local manifest project.
 	for _, proj := range allProjects {
-		skip := true
+		skip := !slices.ContainsFunc(proj.ImportChain, func(imp string) bool {
+			return slices.Contains(localManifestProjects, imp)
+		})
 		// Traverse up the import graph to see if `proj` is a dependency of a
 		// local manifest project.
-		for curr := proj.Name; curr != ""; curr = projectsByName[curr].ImportedBy {
+		for curr := proj.Name; skip && curr != ""; curr = projectsByName[curr].ImportedBy {
 			if slices.Contains(localManifestProjects, curr) {
 				skip = false
-				break
 			}
 		}
 		if skip {
@@ -788,21 +970,25 @@ func getProjectsToSkip(allProjects []Project, localManifestProjects []string) (m
 	return skipProjects, nil
 }
 
+// computeOp returns the operation bringing the local project to remote. The
+// change is classified with the predicates of Change, as DiffStates does.
 func computeOp(jirix *jiri.X, local, remote *Project, state *ProjectState, rebaseTracked, rebaseUntracked, rebaseAll, snapshot bool) operation {
+	change := Change{Old: local, New: remote}
 	switch {
-	case local == nil && remote != nil:
+	case change.Added():
 		return createOperation{commonOperation{
 			destination: remote.Path,
 			project:     *remote,
 			source:      "",
 		}}
-	case local != nil && remote == nil:
-		return deleteOperation{commonOperation{
+	case change.Removed():
+		return deleteOperation{commonOperation: commonOperation{
 			destination: "",
 			project:     *local,
 			source:      local.Path,
 		}}
 	case local != nil && remote != nil:
+		rebaseTracked, rebaseUntracked, rebaseAll = remote.rebaseFlags(rebaseTracked, rebaseUntracked, rebaseAll)
 		localBranchesNeedUpdating := false
 		if !snapshot {
 			cb := state.CurrentBranch
@@ -824,14 +1010,7 @@ func computeOp(jirix *jiri.X, local, remote *Project, state *ProjectState, rebas
 			}
 		}
 		switch {
-		case local.Remote != remote.Remote:
-			return changeRemoteOperation{commonOperation{
-				destination: remote.Path,
-				project:     *remote,
-				source:      local.Path,
-				state:       *state,
-			}, rebaseTracked, rebaseUntracked, rebaseAll, snapshot}
-		case local.Path != remote.Path:
+		case change.Moved():
 			if remote.Path == jirix.Root {
 				return createOperation{commonOperation{
 					destination: remote.Path,
@@ -839,29 +1018,37 @@ func computeOp(jirix *jiri.X, local, remote *Project, state *ProjectState, rebas
 					source:      "",
 				}}
 			}
-			// moveOperation also does an update, so we don't need to check the
-			// revision here.
+			// moveOperation also does an update, and changes the remote if
+			// needed, so we don't need to check the remote or the revision
+			// here.
 			return moveOperation{commonOperation{
 				destination: remote.Path,
 				project:     *remote,
 				source:      local.Path,
 				state:       *state,
+			}, rebaseTracked, rebaseUntracked, rebaseAll, snapshot, ""}
+		case change.RemoteChanged():
+			return changeRemoteOperation{commonOperation{
+				destination: remote.Path,
+				project:     *remote,
+				source:      local.Path,
+				state:       *state,
 			}, rebaseTracked, rebaseUntracked, rebaseAll, snapshot}
-		case snapshot && local.Revision != remote.Revision:
+		case snapshot && change.RevisionChanged():
 			return updateOperation{commonOperation{
 				destination: remote.Path,
 				project:     *remote,
 				source:      local.Path,
 				state:       *state,
 			}, rebaseTracked, rebaseUntracked, rebaseAll, snapshot}
-		case localBranchesNeedUpdating || (state.CurrentBranch.Name == "" && local.Revision != remote.Revision):
+		case localBranchesNeedUpdating || (state.CurrentBranch.Name == "" && change.RevisionChanged()):
 			return updateOperation{commonOperation{
 				destination: remote.Path,
 				project:     *remote,
 				source:      local.Path,
 				state:       *state,
 			}, rebaseTracked, rebaseUntracked, rebaseAll, snapshot}
-		case state.CurrentBranch.Tracking == nil && local.Revision != remote.Revision:
+		case state.CurrentBranch.Tracking == nil && change.RevisionChanged():
 			return updateOperation{commonOperation{
 				destination: remote.Path,
 				project:     *remote,
@@ -939,7 +1126,7 @@ func runCreateOperations(jirix *jiri.X, ops []createOperation) error {
 			jirix.Logger.Debugf("%v", op)
 			if err := op.Run(jirix); err != nil {
 				task.Done()
-				errs <- fmt.Errorf("%s: %s", logMsg, err)
+				errs <- &OperationError{Project: op.Project(), Operation: op.Kind(), Err: fmt.Errorf("%s: %s", logMsg, err)}
 				return
 			}
 			task.Done()
@@ -1020,7 +1207,7 @@ func runDeleteOperations(jirix *jiri.X, ops []deleteOperation, gc bool) error {
 	}
 	notDeleted := NewPathTrie()
 	if !gc {
-		msg := fmt.Sprintf("%d project(s) is/are marked to be deleted. Run '%s' to delete them.", len(ops), jirix.Color.Yellow("jiri update -gc"))
+		msg := fmt.Sprintf("%d project(s) is/are marked to be deleted. Run '%s' to delete them.", len(ops), jirix.Color.Yellow("jiri gc"))
 		if jirix.Logger.LoggerLevel < log.DebugLevel {
 			msg = fmt.Sprintf("%s\nOr run '%s' or '%s' to see the list of projects.", msg, jirix.Color.Yellow("jiri update -v"), jirix.Color.Yellow("jiri status -d"))
 		}
@@ -1049,7 +1236,7 @@ func runDeleteOperations(jirix *jiri.X, ops []deleteOperation, gc bool) error {
 		jirix.Logger.Debugf("%s", op)
 		if err := op.Run(jirix); err != nil {
 			task.Done()
-			return fmt.Errorf("%s: %s", logMsg, err)
+			return &OperationError{Project: op.Project(), Operation: op.Kind(), Err: fmt.Errorf("%s: %s", logMsg, err)}
 		}
 		task.Done()
 		if _, err := os.Stat(op.source); err == nil {
@@ -1068,8 +1255,9 @@ func runMoveOperations(jirix *jiri.X, ops []moveOperation) error {
 	parentSrcPath := ""
 	parentDestPath := ""
 	for _, op := range ops {
-		if parentSrcPath != "" && strings.HasPrefix(op.source, parentSrcPath) {
-			op.source = filepath.Join(parentDestPath, strings.Replace(op.source, parentSrcPath, "", 1))
+		if parentSrcPath != "" && strings.HasPrefix(op.source, parentSrcPath+string(filepath.Separator)) {
+			op.movedFrom = op.source
+			op.source = filepath.Join(parentDestPath, strings.TrimPrefix(op.source, parentSrcPath))
 		} else {
 			parentSrcPath = op.source
 			parentDestPath = op.destination
@@ -1079,7 +1267,7 @@ func runMoveOperations(jirix *jiri.X, ops []moveOperation) error {
 		jirix.Logger.Debugf("%s", op)
 		if err := op.Run(jirix); err != nil {
 			task.Done()
-			return fmt.Errorf("%s: %s", logMsg, err)
+			return &OperationError{Project: op.Project(), Operation: op.Kind(), Err: fmt.Errorf("%s: %s", logMsg, err)}
 		}
 		task.Done()
 	}
@@ -1095,16 +1283,25 @@ func runCommonOperations(jirix *jiri.X, ops operations, loglevel log.LogLevel) e
 		jirix.Logger.Logf(loglevel, "%s", op)
 		if err := op.Run(jirix); err != nil {
 			task.Done()
-			return fmt.Errorf("%s: %s", logMsg, err)
+			return &OperationError{Project: op.Project(), Operation: op.Kind(), Err: fmt.Errorf("%s: %s", logMsg, err)}
 		}
 		task.Done()
 	}
 	return nil
 }
 
+// renameDir moves the directory src to dst. If one contains the other, src
+// is moved to the swap directory first.
 func renameDir(jirix *jiri.X, src, dst string) error {
 	// Parent directory permissions
 	perm := os.FileMode(0755)
+	sep := string(filepath.Separator)
+	if !strings.HasPrefix(dst, src+sep) && !strings.HasPrefix(src, dst+sep) {
+		if err := os.MkdirAll(filepath.Dir(dst), perm); err != nil {
+			return err
+		}
+		return osutil.Rename(src, dst)
+	}
 	swapDir := jirix.SwapDir()
 
 	// Hash src path as swap dir name
@@ -1116,6 +1313,10 @@ func renameDir(jirix *jiri.X, src, dst string) error {
 		return err
 	}
 
+	if _, err := os.Lstat(tmp); err == nil {
+		return fmt.Errorf("%s exists, it may hold the content of %s left by an interrupted move. Move it back or remove it", tmp, src)
+	}
+
 	// Move src -> tmp
 	if err := osutil.Rename(src, tmp); err != nil {
 		return err
@@ -1141,3 +1342,75 @@ func renameDir(jirix *jiri.X, src, dst string) error {
 	}
 	return nil
 }
+
+// relocateGitPaths updates the absolute paths under src recorded in the
+// repository of a project moved from src to dst: the alternates of its
+// object store, the values of its local config, and the links between the
+// repository and its linked worktrees.
+func relocateGitPaths(jirix *jiri.X, src, dst string) error {
+	gitDir := filepath.Join(dst, ".git")
+	if fi, err := os.Stat(gitDir); err != nil || !fi.IsDir() {
+		// The .git file of submodules is relative to their superproject.
+		return nil
+	}
+	relocate := func(path string) (string, bool) {
+		if path == src {
+			return dst, true
+		}
+		if rel, ok := strings.CutPrefix(path, src+string(filepath.Separator)); ok {
+			return filepath.Join(dst, rel), true
+		}
+		return path, false
+	}
+
+	worktrees, err := filepath.Glob(filepath.Join(gitDir, "worktrees", "*", "gitdir"))
+	if err != nil {
+		return err
+	}
+	for _, file := range append([]string{filepath.Join(gitDir, "objects", "info", "alternates")}, worktrees...) {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return fmtError(err)
+		}
+		lines := strings.Split(string(data), "\n")
+		changed := false
+		for i, line := range lines {
+			if path, ok := relocate(line); ok {
+				lines[i], changed = path, true
+			}
+		}
+		if changed {
+			if err := os.WriteFile(file, []byte(strings.Join(lines, "\n")), 0644); err != nil {
+				return fmtError(err)
+			}
+		}
+	}
+
+	scm := newGit(jirix, gitutil.RootDirOpt(dst))
+	configs, err := scm.LocalConfig()
+	if err != nil {
+		return err
+	}
+	seen := make(map[[2]string]bool)
+	for _, config := range configs {
+		path, ok := relocate(config[1])
+		if !ok || seen[config] {
+			continue
+		}
+		seen[config] = true
+		// The value pattern leaves the other values of multi-valued keys.
+		if err := scm.Config("--local", "--replace-all", config[0], path, "^"+regexp.QuoteMeta(config[1])+"$"); err != nil {
+			return err
+		}
+	}
+
+	if len(worktrees) > 0 {
+		if err := scm.WorktreeRepair(); err != nil {
+			jirix.Logger.Warningf("Could not repair the worktrees of %s after moving it from %s: %s\n\n", dst, src, err)
+		}
+	}
+	return nil
+}
@@ -41,3 +41,32 @@ func TestGetProjectsToSkip(t *testing.T) {
 		t.Errorf("Wrong projects to skip (-want +got):\n%s", diff)
 	}
 }
+
+func TestGetProjectsToSkipImportChain(t *testing.T) {
+	// B is imported by A, but has no project of its own, so only the import
+	// chains link C to it.
+	projects := []Project{
+		{Name: "A"},
+		{Name: "C", ImportedBy: "B", ImportChain: []string{"B", "A"}},
+		{Name: "D", ImportedBy: "A", ImportChain: []string{"A"}},
+	}
+
+	toSkipMap, err := getProjectsToSkip(projects, []string{"B"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for k := range toSkipMap {
+		got = append(got, k.name)
+	}
+	slices.Sort(got)
+
+	want := []string{"A", "D"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Wrong projects to skip (-want +got):\n%s", diff)
+	}
+
+	if _, err := getProjectsToSkip(projects, []string{"E"}); err == nil {
+		t.Errorf("expected an error for a local manifest project that doesn't exist")
+	}
+}
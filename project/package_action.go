@@ -0,0 +1,64 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/cipd"
+)
+
+// packageActionHooks returns the hooks running the actions of the packages
+// of pkgs that are deployed for the current platform.
+func packageActionHooks(jirix *jiri.X, pkgs Packages) (Hooks, error) {
+	hooks := make(Hooks)
+	for _, pkg := range pkgs {
+		if pkg.Action == "" {
+			continue
+		}
+		names, err := cipd.ResolvePlatforms(pkg.Name, []cipd.Platform{cipd.CurrentPlatform})
+		if err != nil {
+			return nil, err
+		}
+		if len(names) == 0 {
+			// Not deployed on this platform.
+			continue
+		}
+		dir, err := pkg.ResolvePath()
+		if err != nil {
+			return nil, err
+		}
+		if !isPathDir(filepath.Join(jirix.Root, dir)) {
+			continue
+		}
+		hook := Hook{
+			Name:        "action",
+			Action:      pkg.Action,
+			ProjectName: fmt.Sprintf("%s(%s)", pkg.Name, dir),
+			Timeout:     pkg.ActionTimeout,
+			ActionPath:  filepath.Join(jirix.Root, dir),
+		}
+		hooks[hook.Key()] = hook
+	}
+	return hooks, nil
+}
+
+// runPackageActions runs the actions of the packages of pkgs after they are
+// fetched, in the same way as hooks. runTimeout is the default timeout of
+// the actions, in minutes.
+func runPackageActions(jirix *jiri.X, pkgs Packages, runTimeout uint) error {
+	hooks, err := packageActionHooks(jirix, pkgs)
+	if err != nil || len(hooks) == 0 {
+		return err
+	}
+	jirix.TimerPush("run package actions")
+	defer jirix.TimerPop()
+	if err := runHooks(jirix, hooks, runTimeout, nil); err != nil {
+		return fmt.Errorf("running package actions: %v", err)
+	}
+	return nil
+}
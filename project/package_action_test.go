@@ -0,0 +1,56 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/color"
+	"go.fuchsia.dev/jiri/log"
+	"go.fuchsia.dev/jiri/tool"
+)
+
+func TestRunPackageActions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test actions are shell scripts")
+	}
+	jirix := &jiri.X{
+		Context:  tool.NewDefaultContext(),
+		Root:     t.TempDir(),
+		Jobs:     jiri.DefaultJobs,
+		Attempts: 1,
+		Logger:   log.NewLogger(log.InfoLevel, color.NewColor(color.ColorNever), false, 0, time.Second, os.Stdout, os.Stderr),
+	}
+	dir := filepath.Join(jirix.Root, "prebuilt", "tool")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "setup.sh"), []byte("#!/bin/sh\ntouch done\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "fail.sh"), []byte("#!/bin/sh\nexit 1\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	deployed := Package{Name: "fuchsia/tool", Path: "prebuilt/tool", Action: "setup.sh"}
+	// The action of a package which was not deployed is not run.
+	missing := Package{Name: "fuchsia/missing", Path: "prebuilt/missing", Action: "setup.sh"}
+	pkgs := Packages{deployed.Key(): deployed, missing.Key(): missing}
+	if err := runPackageActions(jirix, pkgs, DefaultHookTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "done")); err != nil {
+		t.Errorf("action of package %s was not run: %v", deployed.Name, err)
+	}
+
+	deployed.Action = "fail.sh"
+	if err := runPackageActions(jirix, Packages{deployed.Key(): deployed}, DefaultHookTimeout); err == nil {
+		t.Errorf("failed action of package %s did not fail", deployed.Name)
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/cipd"
+)
+
+// verifyPackageInstances checks that the packages deployed by cipd are the
+// instances of pkgs recorded in the lockfiles. cipd verifies the content of
+// each instance it downloads against its instance ID, which is a hash of the
+// content, so the deployed packages are those the lockfiles were made with.
+func verifyPackageInstances(jirix *jiri.X, pkgs Packages) error {
+	locked := false
+	for _, pkg := range pkgs {
+		if len(pkg.Instances) != 0 {
+			locked = true
+			break
+		}
+	}
+	if !locked {
+		return nil
+	}
+	installed, err := cipd.Installed(jirix, jirix.Root)
+	if err != nil {
+		return err
+	}
+	return checkPackageInstances(pkgs, installed)
+}
+
+// checkPackageInstances returns an error if a package of installed is not
+// one of the instances of pkgs with that name. Packages without instances
+// are not checked.
+func checkPackageInstances(pkgs Packages, installed []cipd.InstalledPackage) error {
+	ids := make(map[string][]string)
+	for _, pkg := range pkgs {
+		for _, ins := range pkg.Instances {
+			ids[ins.Name] = append(ids[ins.Name], ins.ID)
+		}
+	}
+	var errs []string
+	for _, p := range installed {
+		want, ok := ids[p.PackageName]
+		if !ok || slices.Contains(want, p.InstanceID) {
+			continue
+		}
+		errs = append(errs, fmt.Sprintf("package %s in %q is instance %s, but the lockfiles have %s", p.PackageName, p.Subdir, p.InstanceID, strings.Join(want, " or ")))
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("deployed packages don't match the lockfiles:\n%s", strings.Join(errs, "\n"))
+	}
+	return nil
+}
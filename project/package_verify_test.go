@@ -0,0 +1,72 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"strings"
+	"testing"
+
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/cipd"
+)
+
+func TestCheckPackageInstances(t *testing.T) {
+	pkgs := Packages{}
+	for _, pkg := range []Package{
+		{Name: "a/${platform}", Version: "v1", Path: "a", Instances: []PackageInstance{
+			{Name: "a/linux-amd64", ID: "a-linux"},
+			{Name: "a/mac-amd64", ID: "a-mac"},
+		}},
+		{Name: "b", Version: "v1", Path: "b", Instances: []PackageInstance{{Name: "b", ID: "b1"}}},
+		{Name: "b", Version: "v2", Path: "b2", Instances: []PackageInstance{{Name: "b", ID: "b2"}}},
+		{Name: "unlocked", Version: "latest", Path: "c"},
+	} {
+		pkgs[pkg.Key()] = pkg
+	}
+
+	installed := []cipd.InstalledPackage{
+		{Subdir: "a", PackageName: "a/linux-amd64", InstanceID: "a-linux"},
+		{Subdir: "b", PackageName: "b", InstanceID: "b1"},
+		{Subdir: "b2", PackageName: "b", InstanceID: "b2"},
+		{Subdir: "c", PackageName: "unlocked", InstanceID: "anything"},
+	}
+	if err := checkPackageInstances(pkgs, installed); err != nil {
+		t.Errorf("expected the locked instances to be accepted, got: %v", err)
+	}
+
+	installed[0].InstanceID = "a-stale"
+	err := checkPackageInstances(pkgs, installed)
+	if err == nil {
+		t.Fatalf("expected an error for an instance not in the lockfiles")
+	}
+	if want := "package a/linux-amd64 in \"a\" is instance a-stale, but the lockfiles have a-linux"; !strings.Contains(err.Error(), want) {
+		t.Errorf("expected %q in error, got: %v", want, err)
+	}
+}
+
+func TestCipdDeclVerifiedInstances(t *testing.T) {
+	names, err := cipd.ResolvePlatforms("a/${platform}", []cipd.Platform{cipd.CurrentPlatform})
+	if err != nil || len(names) != 1 {
+		t.Fatalf("cannot resolve the package for the current platform: %v, %v", names, err)
+	}
+	pkg := Package{Name: "a/${platform}", Version: "latest", Path: "a"}
+	decl, err := pkg.cipdDecl(&jiri.X{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(decl, " latest\n") {
+		t.Errorf("got declaration %q, want it to deploy version latest", decl)
+	}
+
+	// The ensure file deploys the instance whose signer was checked, not
+	// what the version resolves to.
+	pkg.VerifiedInstances = []PackageInstance{{Name: names[0], ID: "verified"}}
+	if decl, err = pkg.cipdDecl(&jiri.X{}); err != nil {
+		t.Fatal(err)
+	}
+	if want := names[0] + " verified\n"; !strings.HasSuffix(decl, want) {
+		t.Errorf("got declaration %q, want it to end with %q", decl, want)
+	}
+}
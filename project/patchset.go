@@ -0,0 +1,241 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/gitutil"
+)
+
+const (
+	// PatchsetAm applies patches as commits with "git am".
+	PatchsetAm = "am"
+	// PatchsetApply applies patches to the working tree and the index with
+	// "git apply", without committing them.
+	PatchsetApply = "apply"
+
+	patchsetDir  = "patchset"
+	patchsetFile = "applied.json"
+)
+
+// Patch is a patch file applied to a project.
+type Patch struct {
+	Name string
+	Data []byte
+}
+
+// AppliedPatchset records the patches applied to a project by
+// "jiri apply-patchset", so that they can be reverted.
+type AppliedPatchset struct {
+	// Mode is PatchsetAm or PatchsetApply.
+	Mode string `json:"mode"`
+	// BaseRevision is the revision the patches were applied on, and
+	// HeadRevision the one they resulted in, which are the same with
+	// PatchsetApply.
+	BaseRevision string         `json:"base_revision"`
+	HeadRevision string         `json:"head_revision"`
+	Patches      []AppliedPatch `json:"patches"`
+	Time         time.Time      `json:"time"`
+}
+
+// AppliedPatch is a patch recorded in an AppliedPatchset. Its content is
+// kept next to the record, as File.
+type AppliedPatch struct {
+	Name   string `json:"name"`
+	File   string `json:"file"`
+	SHA256 string `json:"sha256"`
+}
+
+// patchsetMetaDir returns the directory in which the patchset applied to p
+// is recorded.
+func patchsetMetaDir(jirix *jiri.X, p Project) (string, error) {
+	gitDir, err := p.AbsoluteGitDir(jirix)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(gitDir, jiri.ProjectMetaDir, patchsetDir), nil
+}
+
+// ReadAppliedPatchset returns the patchset applied to p, or nil if there is
+// none.
+func ReadAppliedPatchset(jirix *jiri.X, p Project) (*AppliedPatchset, error) {
+	dir, err := patchsetMetaDir(jirix, p)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, patchsetFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmtError(err)
+	}
+	var ps AppliedPatchset
+	if err := json.Unmarshal(data, &ps); err != nil {
+		return nil, fmt.Errorf("invalid patchset record of project %s: %v", p.Name, err)
+	}
+	return &ps, nil
+}
+
+// ApplyPatchset applies patches, in order, to p with mode, and records them
+// in the metadata of p. The project must have no uncommitted changes nor
+// patchset applied already. If a patch fails to apply, the project is
+// restored as it was.
+func ApplyPatchset(jirix *jiri.X, p Project, mode string, patches []Patch) (e error) {
+	if mode != PatchsetAm && mode != PatchsetApply {
+		return fmt.Errorf("patchset mode %q should be %q or %q", mode, PatchsetAm, PatchsetApply)
+	}
+	if applied, err := ReadAppliedPatchset(jirix, p); err != nil {
+		return err
+	} else if applied != nil {
+		return fmt.Errorf("project %s already has a patchset applied, revert it first", p.Name)
+	}
+	scm := newGit(jirix, gitutil.RootDirOpt(p.Path))
+	if dirty, err := scm.HasUncommittedChanges(); err != nil {
+		return err
+	} else if dirty {
+		return fmt.Errorf("project %s has uncommitted changes", p.Name)
+	}
+	base, err := scm.CurrentRevision()
+	if err != nil {
+		return err
+	}
+
+	dir, err := patchsetMetaDir(jirix, p)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmtError(err)
+	}
+	defer func() {
+		if e != nil {
+			os.RemoveAll(dir)
+		}
+	}()
+	ps := AppliedPatchset{Mode: mode, BaseRevision: base, Time: time.Now().UTC()}
+	var files []string
+	for i, patch := range patches {
+		file := fmt.Sprintf("%04d-%s", i+1, filepath.Base(patch.Name))
+		if err := os.WriteFile(filepath.Join(dir, file), patch.Data, 0644); err != nil {
+			return fmtError(err)
+		}
+		sum := sha256.Sum256(patch.Data)
+		ps.Patches = append(ps.Patches, AppliedPatch{Name: patch.Name, File: file, SHA256: hex.EncodeToString(sum[:])})
+		files = append(files, filepath.Join(dir, file))
+	}
+
+	switch mode {
+	case PatchsetAm:
+		// Patches made by "git format-patch" carry their author, but git
+		// still needs a committer.
+		if email, err := scm.ConfigGetKey("user.email"); err != nil || email == "" {
+			scm = newGit(jirix, gitutil.RootDirOpt(p.Path), gitutil.UserNameOpt("jiri"), gitutil.UserEmailOpt("jiri@localhost"))
+		}
+		if err := scm.Am(files...); err != nil {
+			if abortErr := scm.AmAbort(); abortErr != nil {
+				return fmt.Errorf("%v, and restoring the project failed: %v", err, abortErr)
+			}
+			return err
+		}
+	case PatchsetApply:
+		for i, file := range files {
+			if err := scm.ApplyPatch(file, false); err != nil {
+				if resetErr := scm.Reset(base); resetErr != nil {
+					return fmt.Errorf("%v, and restoring the project failed: %v", err, resetErr)
+				}
+				return fmt.Errorf("applying %s: %v", ps.Patches[i].Name, err)
+			}
+		}
+	}
+	if ps.HeadRevision, err = scm.CurrentRevision(); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(ps, "", "  ")
+	if err != nil {
+		return err
+	}
+	return SafeWriteFile(jirix, filepath.Join(dir, patchsetFile), data)
+}
+
+// RevertPatchset reverts the patchset applied to p, if any, and forgets it.
+// It fails rather than losing work if the project changed since, e.g. if
+// commits were made on top of the patches.
+func RevertPatchset(jirix *jiri.X, p Project) error {
+	ps, err := ReadAppliedPatchset(jirix, p)
+	if err != nil || ps == nil {
+		return err
+	}
+	dir, err := patchsetMetaDir(jirix, p)
+	if err != nil {
+		return err
+	}
+	scm := newGit(jirix, gitutil.RootDirOpt(p.Path))
+	head, err := scm.CurrentRevision()
+	if err != nil {
+		return err
+	}
+	if head != ps.HeadRevision {
+		return fmt.Errorf("project %s moved from %s since its patchset was applied, revert it by hand and delete %s", p.Name, ps.HeadRevision, dir)
+	}
+	switch ps.Mode {
+	case PatchsetAm:
+		if dirty, err := scm.HasUncommittedChanges(); err != nil {
+			return err
+		} else if dirty {
+			return fmt.Errorf("project %s has uncommitted changes", p.Name)
+		}
+		if err := scm.Reset(ps.BaseRevision); err != nil {
+			return err
+		}
+	case PatchsetApply:
+		for i := len(ps.Patches) - 1; i >= 0; i-- {
+			if err := scm.ApplyPatch(filepath.Join(dir, ps.Patches[i].File), true); err != nil {
+				err = fmt.Errorf("reverting %s from project %s: %v", ps.Patches[i].Name, p.Name, err)
+				// Apply the patches reverted already again, so that the
+				// project is left with the patchset it records.
+				for _, patch := range ps.Patches[i+1:] {
+					if applyErr := scm.ApplyPatch(filepath.Join(dir, patch.File), false); applyErr != nil {
+						return fmt.Errorf("%v, and applying the patches reverted already again failed: %v\nDiscard the changes of the project with \"git reset --hard\" if they are only those of the patchset, or revert its remaining patches by hand, and delete %s", err, applyErr, dir)
+					}
+				}
+				return fmt.Errorf("%v\nThe patchset is still applied. Undo the changes made to the project since, or revert the patches in %s by hand with \"git apply -R --index\", from the last one, and delete %s", err, dir, dir)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown patchset mode %q of project %s", ps.Mode, p.Name)
+	}
+	return fmtError(os.RemoveAll(dir))
+}
+
+// RevertPatchsets reverts the patchsets applied to projects. Failures of
+// single projects don't stop the others, and are all returned.
+func RevertPatchsets(jirix *jiri.X, projects Projects) error {
+	var errs []error
+	for _, p := range projects {
+		ps, err := ReadAppliedPatchset(jirix, p)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if ps == nil {
+			continue
+		}
+		if err := RevertPatchset(jirix, p); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		jirix.Logger.Infof("Reverted the %d patches applied to project %s(%s)", len(ps.Patches), p.Name, p.Path)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,107 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package project
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/gitutil"
+)
+
+// printUpdatePlan writes to w what update would do with ops, the operations
+// on git projects, vcsOps, those on the other projects, and pkgs, without
+// doing it. It first checks with Test that every operation would succeed.
+func printUpdatePlan(jirix *jiri.X, w io.Writer, ops, vcsOps operations, pkgs Packages, params UpdateUniverseParams) error {
+	for _, op := range append(append(operations(nil), ops...), vcsOps...) {
+		if err := op.Test(jirix); err != nil {
+			return err
+		}
+	}
+	upToDate := 0
+	var lines []string
+	for _, op := range append(append(operations(nil), ops...), vcsOps...) {
+		switch op := op.(type) {
+		case nullOperation:
+			upToDate++
+		case deleteOperation:
+			line := op.String()
+			if !params.GC {
+				line += " (skipped without -gc)"
+			}
+			lines = append(lines, line)
+		default:
+			lines = append(lines, op.String()+commitCount(jirix, op))
+		}
+	}
+	if params.FetchPackages {
+		var pkgKeys PackagesByKey
+		for _, pkg := range pkgs {
+			pkgKeys = append(pkgKeys, pkg)
+		}
+		sort.Sort(pkgKeys)
+		for _, pkg := range pkgKeys {
+			pkgPath, err := pkg.ResolvePath()
+			if err != nil {
+				return err
+			}
+			if _, err := os.Stat(filepath.Join(jirix.Root, pkgPath)); err == nil {
+				continue
+			}
+			lines = append(lines, fmt.Sprintf("download package %q at version %q to %q", pkg.Name, pkg.Version, pkgPath))
+		}
+	}
+	if len(lines) == 0 {
+		fmt.Fprintf(w, "Nothing to update, %d project(s) up-to-date.\n", upToDate)
+		return nil
+	}
+	fmt.Fprintf(w, "Update plan:\n")
+	for _, line := range lines {
+		fmt.Fprintf(w, "  %s\n", line)
+	}
+	fmt.Fprintf(w, "%d project(s) up-to-date.\n", upToDate)
+	if params.FetchPackages && len(pkgs) > 0 {
+		fmt.Fprintf(w, "Packages already present are brought to their versions by cipd.\n")
+	}
+	return nil
+}
+
+// commitCount describes how many commits op moves the checkout of an
+// existing project forward and back, or returns "" if it does not change its
+// revision.
+func commitCount(jirix *jiri.X, op operation) string {
+	var state ProjectState
+	switch op := op.(type) {
+	case updateOperation:
+		state = op.state
+	case moveOperation:
+		state = op.state
+	case changeRemoteOperation:
+		state = op.state
+	default:
+		return ""
+	}
+	from, to := state.CurrentBranch.Revision, op.Project().Revision
+	if from == "" || from == to {
+		return ""
+	}
+	scm := newGit(jirix, gitutil.RootDirOpt(op.Source()))
+	ahead, err := scm.CountCommits(to, from)
+	if err != nil {
+		return " (commit count unknown)"
+	}
+	behind, err := scm.CountCommits(from, to)
+	if err != nil {
+		return " (commit count unknown)"
+	}
+	if behind == 0 {
+		return fmt.Sprintf(" (%d new commit(s))", ahead)
+	}
+	return fmt.Sprintf(" (%d new commit(s), %d commit(s) dropped or rebased)", ahead, behind)
+}
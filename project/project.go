@@ -6,21 +6,23 @@ package project
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"encoding/xml"
 	"errors"
 	"fmt"
-	"io"
 	"io/fs"
-	"net/http"
+	"maps"
 	"net/url"
 	"os"
 	"os/exec"
-	"path"
 	"path/filepath"
 	"reflect"
 	"regexp"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -29,12 +31,15 @@ import (
 	"go.fuchsia.dev/jiri/cipd"
 	"go.fuchsia.dev/jiri/gitutil"
 	"go.fuchsia.dev/jiri/log"
+	"go.fuchsia.dev/jiri/osutil"
 	"go.fuchsia.dev/jiri/retry"
+	"go.fuchsia.dev/jiri/tool"
 	"golang.org/x/sync/errgroup"
 )
 
 var (
 	errVersionMismatch    = errors.New("snapshot file version mismatch")
+	errDuplicateProject   = errors.New("duplicate project")
 	httpsRe               = regexp.MustCompile("^https://(.*?)\\.googlesource\\.com/(.*)")
 	ssoRe                 = regexp.MustCompile("^sso://(.*?)/")
 	DefaultHookTimeout    = uint(5)  // DefaultHookTimeout is the time in minutes to wait for a hook to timeout.
@@ -62,6 +67,9 @@ type Project struct {
 	Remote string `xml:"remote,attr,omitempty"`
 	// RemoteBranch is the name of the remote branch to track.
 	RemoteBranch string `xml:"remotebranch,attr,omitempty"`
+	// RemoteName is the name of the git remote for Remote in the local
+	// checkout. If not set, "origin" is used.
+	RemoteName string `xml:"remotename,attr,omitempty"`
 	// Revision is the revision the project should be advanced to during "jiri
 	// update".  If Revision is set, RemoteBranch will be ignored.  If Revision
 	// is not set, "HEAD" is used as the default.
@@ -70,11 +78,56 @@ type Project struct {
 	// commands. It is used to limit downloading large histories for large
 	// projects.
 	HistoryDepth int `xml:"historydepth,attr,omitempty"`
+	// ShallowSince is the date passed as --shallow-since to git clone and
+	// git fetch. Like HistoryDepth, it limits the downloaded history, but
+	// by age rather than by number of commits. The two are exclusive.
+	ShallowSince string `xml:"shallowsince,attr,omitempty"`
+	// BundleURL is a git bundle that the initial clone of the project is
+	// seeded from before fetching the remaining objects from Remote.
+	BundleURL string `xml:"bundleurl,attr,omitempty"`
+	// Refspecs is a comma-separated list of the refs fetched for the
+	// project, e.g. "refs/heads/main,refs/tags/release-*", for repositories
+	// with too many refs to fetch them all. The remote branch of the project
+	// is always fetched. By default all branches are fetched.
+	Refspecs string `xml:"refspecs,attr,omitempty"`
 	// GerritHost is the gerrit host where project CLs will be sent.
 	GerritHost string `xml:"gerrithost,attr,omitempty"`
+	// Reviewers is a comma-separated list of reviewers that "jiri upload"
+	// adds to the CLs of the project by default.
+	Reviewers string `xml:"reviewers,attr,omitempty"`
+	// Hashtags is a comma-separated list of hashtags that "jiri upload"
+	// adds to the CLs of the project by default.
+	Hashtags string `xml:"hashtags,attr,omitempty"`
+	// Description is a short description of the project, shown by "jiri
+	// project list".
+	Description string `xml:"description,attr,omitempty"`
+	// Owners is a comma-separated list of the owners of the project, shown
+	// by "jiri project list".
+	Owners string `xml:"owners,attr,omitempty"`
 	// GitHooks is a directory containing git hooks that will be installed for
 	// this project.
 	GitHooks string `xml:"githooks,attr,omitempty"`
+	// Rebase is how "jiri update" updates the local branches of the
+	// project: "never", "tracked" or "all", see RebasePolicy. If not set,
+	// the rebase flags of "jiri update" apply.
+	Rebase string `xml:"rebase,attr,omitempty"`
+	// GitConfig is a comma-separated list of "key=value" git configs, e.g.
+	// "core.fsmonitor=true,core.untrackedCache=true", that "jiri update"
+	// sets in the local config of the project.
+	GitConfig string `xml:"gitconfig,attr,omitempty"`
+	// AllowHooks lets the project declare hooks in its own projectHooksFile,
+	// which "jiri update" runs. The hooks of projects without it are ignored,
+	// so that a project cannot run code just by committing the file.
+	AllowHooks bool `xml:"allowhooks,attr,omitempty"`
+
+	// VCS is the version control system of the project: "git", the
+	// default, "hg", or "archive" for read-only vendored projects whose
+	// Remote is a tarball or zip file unpacked into Path. Projects that are
+	// not git projects are synced by the VCS backend, see VCS.
+	VCS string `xml:"vcs,attr,omitempty"`
+	// SHA256 is the hex digest of the archive of an archive project. It is
+	// verified before unpacking, and recorded in jiri.lock.
+	SHA256 string `xml:"sha256,attr,omitempty"`
 
 	// Attributes is a list of attributes for a project separated by comma.
 	// The project will not be fetched by default when attributes are present.
@@ -89,6 +142,27 @@ type Project struct {
 	// this project is successfully fetched.
 	Flag string `xml:"flag,attr,omitempty"`
 
+	// Groups lists the manifest groups this project belongs to, separated
+	// by comma. It is computed from the <group> elements of the manifests
+	// and recorded in snapshots.
+	Groups string `xml:"groups,attr,omitempty"`
+
+	// CopyFiles lists files of the project to copy elsewhere in the jiri
+	// root after it is updated.
+	CopyFiles []CopyFile `xml:"copyfile"`
+
+	// LinkFiles lists files or directories of the project to symlink from
+	// elsewhere in the jiri root after it is updated.
+	LinkFiles []LinkFile `xml:"linkfile"`
+
+	// AltRemotes lists mirrors of Remote that are fetched from, in order,
+	// when fetching from Remote fails with network errors.
+	AltRemotes []AltRemote `xml:"altremote"`
+
+	// Expires is the date, as YYYY-MM-DD, after which this project
+	// override is reported as expired. It is only used in <overrides>.
+	Expires string `xml:"expires,attr,omitempty"`
+
 	XMLName struct{} `xml:"project"`
 
 	// This is used to store computed key. This is useful when remote and
@@ -102,11 +176,20 @@ type Project struct {
 	// which is easier to perform matching and comparing.
 	ComputedAttributes attributes `xml:"-"`
 
+	// Superproject is the path of the project of which this project is a
+	// checked out git submodule, see LocalSubmodules.
+	Superproject string `xml:"-"`
+
 	// ManifestPath stores the absolute path of the manifest.
 	ManifestPath string `xml:"-"`
 
 	// ImportedBy is the name of the <import> that pulls in this project.
 	ImportedBy string `xml:"-"`
+
+	// ImportChain holds the names of the <import> that pulls in this project
+	// and of the imports it is nested in, innermost first. Unlike following
+	// ImportedBy, it doesn't depend on the imports having matching projects.
+	ImportChain []string `xml:"-"`
 }
 
 // ProjectsByPath implements the Sort interface. It sorts Projects by
@@ -153,9 +236,16 @@ func ProjectKeyFromString(s string) (ProjectKey, bool) {
 	return MakeProjectKey(parts[0], parts[1]), true
 }
 
-// MakeProjectKey returns the project key, given the project name and normalized remote.
+// MakeProjectKey returns the project key, given the project name and remote,
+// which is normalized, see SetRemoteNormalizer.
 func MakeProjectKey(name, remote string) ProjectKey {
-	return ProjectKey{name: name, remote: rewriteAndNormalizeRemote(remote)}
+	return ProjectKey{name: name, remote: normalizeRemote(remote)}
+}
+
+// legacyKey returns the key of p in older versions of jiri, see
+// LegacyRemote.
+func legacyKey(p Project) ProjectKey {
+	return ProjectKey{name: p.Name, remote: LegacyRemote(p.Remote)}
 }
 
 // KeySeparator is a reserved string used in ProjectKeys and HookKeys.
@@ -204,7 +294,12 @@ func (p Project) ToFile(jirix *jiri.X, filename string) error {
 		return fmt.Errorf("project xml.Marshal failed: %v", err)
 	}
 	// Same logic as Manifest.ToBytes, to make the output more compact.
-	data = bytes.Replace(data, endProjectSoloBytes, endElemSoloBytes, -1)
+	if len(p.CopyFiles) == 0 && len(p.LinkFiles) == 0 && len(p.AltRemotes) == 0 {
+		data = bytes.Replace(data, endProjectSoloBytes, endElemSoloBytes, -1)
+	}
+	data = bytes.Replace(data, endCopyFileBytes, endElemSoloBytes, -1)
+	data = bytes.Replace(data, endLinkFileBytes, endElemSoloBytes, -1)
+	data = bytes.Replace(data, endAltRemoteBytes, endElemSoloBytes, -1)
 	if !bytes.HasSuffix(data, newlineBytes) {
 		data = append(data, '\n')
 	}
@@ -241,7 +336,7 @@ func (p *Project) relativizePaths(basepath string) error {
 }
 
 func (p *Project) AbsoluteGitDir(jirix *jiri.X) (string, error) {
-	scm := gitutil.New(jirix, gitutil.RootDirOpt(p.Path))
+	scm := newGit(jirix, gitutil.RootDirOpt(p.Path))
 	return scm.AbsoluteGitDir()
 }
 
@@ -277,9 +372,219 @@ func (p *Project) validate() error {
 	if strings.Contains(p.Name, KeySeparator) {
 		return fmt.Errorf("bad project: name cannot contain %q: %+v", KeySeparator, *p)
 	}
+	if p.HistoryDepth > 0 && p.ShallowSince != "" {
+		return fmt.Errorf("bad project %q: historydepth and shallowsince cannot be used together", p.Name)
+	}
+	if err := p.validateVCS(); err != nil {
+		return err
+	}
+	if err := ValidateRebasePolicy(p.Rebase); err != nil {
+		return fmt.Errorf("bad project %q: %v", p.Name, err)
+	}
+	switch {
+	case strings.ContainsAny(p.RemoteName, "/ \t\n"):
+		return fmt.Errorf("bad project %q: remotename %q is not a valid git remote name", p.Name, p.RemoteName)
+	case p.RemoteName == "jiri" || p.RemoteName == "cache":
+		return fmt.Errorf("bad project %q: remotename %q is reserved by jiri", p.Name, p.RemoteName)
+	}
+	if _, err := p.gitConfigs(); err != nil {
+		return fmt.Errorf("bad project %q: %v", p.Name, err)
+	}
+	for _, ref := range p.refs() {
+		if !strings.HasPrefix(ref, "refs/") || strings.ContainsAny(ref, ": \t\n") {
+			return fmt.Errorf("bad project %q: refspecs entry %q should be a ref such as \"refs/heads/main\"", p.Name, ref)
+		}
+	}
+	for _, alt := range p.AltRemotes {
+		if alt.Remote == "" {
+			return fmt.Errorf("bad project %q: altremote must specify a remote", p.Name)
+		}
+	}
+	for _, f := range p.CopyFiles {
+		if err := validateFilePaths(p, "copyfile", f.Src, f.Dest); err != nil {
+			return err
+		}
+	}
+	for _, f := range p.LinkFiles {
+		if err := validateFilePaths(p, "linkfile", f.Src, f.Dest); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// isShallow reports whether p only fetches part of the project history.
+func (p Project) isShallow() bool {
+	return p.HistoryDepth > 0 || p.ShallowSince != ""
+}
+
+// Rebase policies of projects, see Project.Rebase.
+const (
+	// RebaseNever never rebases local branches, the current branch is only
+	// fast-forwarded to its upstream.
+	RebaseNever = "never"
+	// RebaseTracked rebases the current branch onto its upstream, like
+	// "jiri update -rebase-tracked".
+	RebaseTracked = "tracked"
+	// RebaseAll rebases all tracked branches, like "jiri update
+	// -rebase-all".
+	RebaseAll = "all"
+)
+
+// ValidateRebasePolicy returns an error if policy is not a rebase policy or
+// empty.
+func ValidateRebasePolicy(policy string) error {
+	switch policy {
+	case "", RebaseNever, RebaseTracked, RebaseAll:
+		return nil
+	}
+	return fmt.Errorf("rebase %q should be %q, %q or %q", policy, RebaseNever, RebaseTracked, RebaseAll)
+}
+
+// RebasePolicy returns the rebase policy of p: the one of its local config
+// if set, else the one of the manifest, or "" if neither sets one.
+func (p Project) RebasePolicy() string {
+	if p.LocalConfig.Rebase != "" {
+		return p.LocalConfig.Rebase
+	}
+	return p.Rebase
+}
+
+// rebaseFlags returns the rebase flags of "jiri update" to use for p: the
+// ones given if p has no rebase policy, or those of its policy otherwise.
+// The policy of a project wins over the flags, so that projects that must
+// not be rebased aren't even if a user passes -rebase-all.
+func (p Project) rebaseFlags(rebaseTracked, rebaseUntracked, rebaseAll bool) (bool, bool, bool) {
+	switch p.RebasePolicy() {
+	case RebaseNever:
+		return false, false, false
+	case RebaseTracked:
+		return true, false, false
+	case RebaseAll:
+		return true, rebaseUntracked, true
+	}
+	return rebaseTracked, rebaseUntracked, rebaseAll
+}
+
+// PrimaryRemote returns the name of the git remote that p is fetched from and
+// pushed to, "origin" unless set by the remotename attribute.
+func (p Project) PrimaryRemote() string {
+	if p.RemoteName != "" {
+		return p.RemoteName
+	}
+	return "origin"
+}
+
+// RemoteBranchRef returns the remote-tracking ref of the remote branch of p,
+// e.g. "refs/remotes/origin/main".
+func (p Project) RemoteBranchRef() string {
+	branch := p.RemoteBranch
+	if branch == "" {
+		branch = "main"
+	}
+	return "refs/remotes/" + p.PrimaryRemote() + "/" + branch
+}
+
+// refs returns the refs of the refspecs attribute of p.
+func (p Project) refs() []string {
+	var refs []string
+	for _, ref := range strings.Split(p.Refspecs, ",") {
+		if ref = strings.TrimSpace(ref); ref != "" {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// manifestGitConfigs are the git configs which the gitconfig attribute of a
+// project can set, with the check of their values. They only change how fast
+// git is on huge working trees: other configs, like core.sshCommand,
+// core.hooksPath or aliases, would let a manifest run commands.
+var manifestGitConfigs = map[string]func(string) bool{
+	"core.fsmonitor":         isGitBool,
+	"core.untrackedcache":    func(v string) bool { return isGitBool(v) || strings.EqualFold(v, "keep") },
+	"core.preloadindex":      isGitBool,
+	"core.commitgraph":       isGitBool,
+	"feature.manyfiles":      isGitBool,
+	"fetch.writecommitgraph": isGitBool,
+	"index.threads": func(v string) bool {
+		_, err := strconv.ParseUint(v, 10, 16)
+		return err == nil || isGitBool(v)
+	},
+	"index.version": func(v string) bool { return v == "2" || v == "3" || v == "4" },
+}
+
+// isGitBool returns whether v is a boolean value for git config.
+func isGitBool(v string) bool {
+	_, ok := parseGitBool(v)
+	return ok
+}
+
+// parseGitBool parses v as a boolean value for git config, and returns
+// whether it is one.
+func parseGitBool(v string) (bool, bool) {
+	switch strings.ToLower(v) {
+	case "true", "yes", "on", "1":
+		return true, true
+	case "false", "no", "off", "0":
+		return false, true
+	}
+	return false, false
+}
+
+// gitConfigs returns the git configs of the gitconfig attribute of p, in
+// order, with their keys in lower case. Only the configs of
+// manifestGitConfigs are allowed.
+func (p Project) gitConfigs() ([][2]string, error) {
+	var configs [][2]string
+	for _, config := range strings.Split(p.GitConfig, ",") {
+		if config = strings.TrimSpace(config); config == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(config, "=")
+		if key = strings.TrimSpace(key); !ok || !strings.Contains(key, ".") || strings.ContainsAny(key, " \t\n") {
+			return nil, fmt.Errorf("gitconfig entry %q should be of the form \"<section>.<key>=<value>\"", config)
+		}
+		key, value = strings.ToLower(key), strings.TrimSpace(value)
+		valid, ok := manifestGitConfigs[key]
+		if !ok {
+			return nil, fmt.Errorf("gitconfig entry %q cannot be set by manifests", config)
+		}
+		if !valid(value) {
+			return nil, fmt.Errorf("gitconfig entry %q has an invalid value", config)
+		}
+		configs = append(configs, [2]string{key, value})
+	}
+	return configs, nil
+}
+
+// fetchRefspecs returns the refspecs fetching the refs of the refspecs
+// attribute of p, and its remote branch, or nil if p fetches all branches.
+// Branches are fetched to remote-tracking refs of remoteName, or to the same
+// refs if remoteName is empty, as in caches.
+func (p Project) fetchRefspecs(remoteName string) []string {
+	refs := p.refs()
+	if len(refs) == 0 {
+		return nil
+	}
+	branch := p.RemoteBranch
+	if branch == "" {
+		branch = "main"
+	}
+	if !slices.Contains(refs, "refs/heads/"+branch) {
+		refs = append([]string{"refs/heads/" + branch}, refs...)
+	}
+	var refspecs []string
+	for _, ref := range refs {
+		dst := ref
+		if branch, ok := strings.CutPrefix(ref, "refs/heads/"); ok && remoteName != "" {
+			dst = "refs/remotes/" + remoteName + "/" + branch
+		}
+		refspecs = append(refspecs, "+"+ref+":"+dst)
+	}
+	return refspecs
+}
+
 func (p *Project) update(other *Project) {
 	if other.Path != "" {
 		p.Path = other.Path
@@ -287,11 +592,27 @@ func (p *Project) update(other *Project) {
 	if other.RemoteBranch != "" {
 		p.RemoteBranch = other.RemoteBranch
 	}
+	if other.RemoteName != "" {
+		p.RemoteName = other.RemoteName
+	}
 	if other.Revision != "" {
 		p.Revision = other.Revision
 	}
+	// HistoryDepth and ShallowSince are exclusive, so setting one of them
+	// replaces the other.
 	if other.HistoryDepth != 0 {
 		p.HistoryDepth = other.HistoryDepth
+		p.ShallowSince = ""
+	}
+	if other.ShallowSince != "" {
+		p.ShallowSince = other.ShallowSince
+		p.HistoryDepth = 0
+	}
+	if other.BundleURL != "" {
+		p.BundleURL = other.BundleURL
+	}
+	if other.Refspecs != "" {
+		p.Refspecs = other.Refspecs
 	}
 	if other.GerritHost != "" {
 		p.GerritHost = other.GerritHost
@@ -299,14 +620,45 @@ func (p *Project) update(other *Project) {
 	if other.GitHooks != "" {
 		p.GitHooks = other.GitHooks
 	}
+	if other.Rebase != "" {
+		p.Rebase = other.Rebase
+	}
+	if other.GitConfig != "" {
+		p.GitConfig = other.GitConfig
+	}
+	if other.AllowHooks {
+		p.AllowHooks = true
+	}
+	if other.Reviewers != "" {
+		p.Reviewers = other.Reviewers
+	}
+	if other.Hashtags != "" {
+		p.Hashtags = other.Hashtags
+	}
+	if other.Description != "" {
+		p.Description = other.Description
+	}
+	if other.Owners != "" {
+		p.Owners = other.Owners
+	}
 	if other.Flag != "" {
 		p.Flag = other.Flag
 	}
+	if len(other.CopyFiles) != 0 {
+		p.CopyFiles = other.CopyFiles
+	}
+	if len(other.LinkFiles) != 0 {
+		p.LinkFiles = other.LinkFiles
+	}
+	if len(other.AltRemotes) != 0 {
+		p.AltRemotes = other.AltRemotes
+	}
 }
 
 // WriteProjectFlags write flag files into project directory using in "flag"
-// attribute from projects.
-func WriteProjectFlags(jirix *jiri.X, projects Projects) error {
+// attribute from projects, as mode says, and returns the report of the flag
+// files.
+func WriteProjectFlags(jirix *jiri.X, projects Projects, mode FlagMode) (*FlagReport, error) {
 	// The flag attribute has a format of $FILE_NAME|$FLAG_SUCCESSFUL|$FLAG_FAILED
 	// When a package is successfully downloaded, jiri will write $FLAG_SUCCESSFUL
 	// to $FILE_NAME. If the package is not downloaded due to access reasons,
@@ -317,41 +669,12 @@ func WriteProjectFlags(jirix *jiri.X, projects Projects) error {
 	// Unlike WritePackageFlags that writes the failure flags when the package was
 	// not fetched due to permission issues, this function will not write failure
 	// flags, as unfetchable projects are considered as errors.
-	flagMap := make(map[string]string)
-	fill := func(file, flag string) error {
-		if v, ok := flagMap[file]; ok {
-			if v != flag {
-				return fmt.Errorf("encountered conflicting flags for file %q: %q conflicts with %q", file, v, flag)
-			}
-		} else {
-			flagMap[file] = flag
-		}
-		return nil
-	}
-
-	for _, v := range projects {
-		if v.Flag == "" {
-			continue
-		}
-		fields := strings.Split(v.Flag, "|")
-		if len(fields) != 3 {
-			return fmt.Errorf("unknown project flag format found in project %+v", v)
-		}
-		if err := fill(fields[0], fields[1]); err != nil {
-			return err
-		}
-	}
-
-	var writeErrorBuf bytes.Buffer
-	for k, v := range flagMap {
-		if err := os.WriteFile(filepath.Join(jirix.Root, k), []byte(v), 0644); err != nil {
-			writeErrorBuf.WriteString(fmt.Sprintf("write package flag %q to file %q failed: %v\n", v, k, err))
-		}
-	}
-	if writeErrorBuf.Len() > 0 {
-		return errors.New(writeErrorBuf.String())
+	writes, err := projectFlagWrites(jirix, projects)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	report := newFlagReport(writes)
+	return report, report.write(jirix, mode)
 }
 
 type attributes map[string]bool
@@ -477,13 +800,126 @@ type ResolveConfig interface {
 	EnableProjectLock() bool
 	HostnameAllowList() []string
 	FullResolve() bool
+	// DiffRange, if set, is a git revision range of the manifest
+	// repository. A partial resolve then only resolves the projects and
+	// packages changed by its commits, and keeps the other locks.
+	DiffRange() string
+	// FetchingAttrs returns the attributes selecting the optional projects
+	// and packages to resolve, and whether there are any. All projects and
+	// packages are resolved otherwise.
+	FetchingAttrs() (string, bool)
+}
+
+// HookLock describes the script run by a jiri hook, so that changes to it can
+// be detected. It is recorded by lockfiles of version 2.0 and later.
+type HookLock struct {
+	Name        string `json:"name"`
+	ProjectName string `json:"project"`
+	Action      string `json:"action"`
+	Digest      string `json:"sha256,omitempty"`
+}
+
+// HookLocks type is a map wrapper over HookLock for faster look up.
+type HookLocks map[HookKey]HookLock
+
+func (h HookLock) Key() HookKey {
+	return MakeHookKey(h.Name, h.ProjectName)
+}
+
+// ManifestLock describes a manifest file that a lockfile was resolved from.
+// It is recorded by lockfiles of version 2.0 and later.
+type ManifestLock struct {
+	Path   string `json:"path"`
+	Digest string `json:"sha256"`
+}
+
+// ManifestLocks type is a map wrapper over ManifestLock, keyed by the path of
+// the manifest relative to the jiri root.
+type ManifestLocks map[string]ManifestLock
+
+// LockDigests are the digests of the hook scripts and manifest files that a
+// lockfile of version 2.0 or later was resolved from.
+type LockDigests struct {
+	Hooks     HookLocks
+	Manifests ManifestLocks
 }
 
 // LockFile represents the structure of the lock file.
 type LockFile struct {
-	Version  string        `json:"version"`
-	Projects []ProjectLock `json:"projects,omitempty"`
-	Packages []PackageLock `json:"packages,omitempty"`
+	Version   string         `json:"version"`
+	Projects  []ProjectLock  `json:"projects,omitempty"`
+	Packages  []PackageLock  `json:"packages,omitempty"`
+	Hooks     []HookLock     `json:"hooks,omitempty"`
+	Manifests []ManifestLock `json:"manifests,omitempty"`
+}
+
+// manifestLock returns the lock of the manifest file, whose content is data.
+func manifestLock(jirix *jiri.X, file string, data []byte) ManifestLock {
+	path := filepath.ToSlash(shortFileName(jirix.Root, "", file, ""))
+	sum := sha256.Sum256(data)
+	return ManifestLock{Path: path, Digest: hex.EncodeToString(sum[:])}
+}
+
+// UpdateManifest sets the digest of the manifest file in d to the one of
+// data, its new content, if d records the manifest, and returns whether the
+// digest changed.
+func (d LockDigests) UpdateManifest(jirix *jiri.X, file string, data []byte) bool {
+	if abs, err := filepath.Abs(file); err == nil {
+		file = abs
+	}
+	lock := manifestLock(jirix, file, data)
+	old, ok := d.Manifests[lock.Path]
+	if !ok || old == lock {
+		return false
+	}
+	d.Manifests[lock.Path] = lock
+	return true
+}
+
+// UpdateLockfileManifestDigest updates the digest of the manifest file in
+// the lockfile next to it, after file was rewritten, so that the lockfile
+// does not record the digest of its previous content.
+func UpdateLockfileManifestDigest(jirix *jiri.X, file string) error {
+	if jirix.LockfileName == "" {
+		return nil
+	}
+	lockfile := filepath.Join(filepath.Dir(file), jirix.LockfileName)
+	bin, err := os.ReadFile(lockfile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmtError(err)
+	}
+	projectLocks, pkgLocks, version, err := UnmarshalLockEntries(bin)
+	if err != nil {
+		return fmt.Errorf("%s: %v", lockfile, err)
+	}
+	if !lockDigestsVersion(version) {
+		return nil
+	}
+	digests, err := UnmarshalLockDigests(bin)
+	if err != nil {
+		return fmt.Errorf("%s: %v", lockfile, err)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmtError(err)
+	}
+	if !digests.UpdateManifest(jirix, file, data) {
+		return nil
+	}
+	ebin, err := MarshalLockFile(projectLocks, pkgLocks, digests, version)
+	if err != nil {
+		return err
+	}
+	jirix.Logger.Debugf("Updated the digest of %s in %s", file, lockfile)
+	return SafeWriteFile(jirix, lockfile, ebin)
+}
+
+// lockDigestsVersion reports whether lockfiles of the given version record
+// LockDigests.
+func lockDigestsVersion(version string) bool {
+	return version != "0.0" && version != "1.0"
 }
 
 // UnmarshalLockEntries unmarshals project locks and package locks from
@@ -571,9 +1007,42 @@ func UnmarshalLockEntries(jsonData []byte) (ProjectLocks, PackageLocks, string,
 	return projectLocks, pkgLocks, version, nil
 }
 
+// UnmarshalLockDigests unmarshals the hook and manifest digests from jsonData.
+// They are empty for lockfiles older than version 2.0.
+func UnmarshalLockDigests(jsonData []byte) (LockDigests, error) {
+	digests := LockDigests{Hooks: make(HookLocks), Manifests: make(ManifestLocks)}
+	var lockfile LockFile
+	if err := json.Unmarshal(jsonData, &lockfile); err != nil {
+		// Lockfiles of version 0.0 are lists of entries, without digests.
+		if _, _, _, err := UnmarshalLockEntries(jsonData); err != nil {
+			return digests, err
+		}
+		return digests, nil
+	}
+	for _, h := range lockfile.Hooks {
+		if v, ok := digests.Hooks[h.Key()]; ok && v != h {
+			return digests, fmt.Errorf("hook %q of project %q has more than 1 lock %+v, %+v", h.Name, h.ProjectName, v, h)
+		}
+		digests.Hooks[h.Key()] = h
+	}
+	for _, m := range lockfile.Manifests {
+		if v, ok := digests.Manifests[m.Path]; ok && v != m {
+			return digests, fmt.Errorf("manifest %q has more than 1 digest %q, %q", m.Path, v.Digest, m.Digest)
+		}
+		digests.Manifests[m.Path] = m
+	}
+	return digests, nil
+}
+
 // MarshalLockEntries marshals project locks and package locks into
 // json format data.
 func MarshalLockEntries(projectLocks ProjectLocks, pkgLocks PackageLocks, version string) ([]byte, error) {
+	return MarshalLockFile(projectLocks, pkgLocks, LockDigests{}, version)
+}
+
+// MarshalLockFile marshals project locks, package locks and, for version 2.0
+// and later, the digests of hooks and manifests into json format data.
+func MarshalLockFile(projectLocks ProjectLocks, pkgLocks PackageLocks, digests LockDigests, version string) ([]byte, error) {
 	entries := make([]any, len(projectLocks)+len(pkgLocks))
 	projEntries := make([]ProjectLock, len(projectLocks))
 	pkgEntries := make([]PackageLock, len(pkgLocks))
@@ -625,6 +1094,23 @@ func MarshalLockEntries(projectLocks ProjectLocks, pkgLocks PackageLocks, versio
 			Projects: projEntries,
 			Packages: pkgEntries,
 		}
+		if lockDigestsVersion(version) {
+			for _, v := range digests.Hooks {
+				lock.Hooks = append(lock.Hooks, v)
+			}
+			sort.Slice(lock.Hooks, func(i, j int) bool {
+				if lock.Hooks[i].ProjectName != lock.Hooks[j].ProjectName {
+					return lock.Hooks[i].ProjectName < lock.Hooks[j].ProjectName
+				}
+				return lock.Hooks[i].Name < lock.Hooks[j].Name
+			})
+			for _, v := range digests.Manifests {
+				lock.Manifests = append(lock.Manifests, v)
+			}
+			sort.Slice(lock.Manifests, func(i, j int) bool {
+				return lock.Manifests[i].Path < lock.Manifests[j].Path
+			})
+		}
 		jsonData, err = json.MarshalIndent(&lock, "", "    ")
 	}
 
@@ -666,11 +1152,16 @@ func overrideImport(remote Import, projectOverrides map[string]Project, importOv
 
 func cacheDirPathFromRemote(jirix *jiri.X, remote string) (string, error) {
 	if jirix.Cache != "" {
-		url, err := url.Parse(remote)
+		url, err := url.Parse(sshRemoteURL(remote))
 		if err != nil {
 			return "", err
 		}
-		dirname := url.Host + strings.Replace(strings.Replace(url.Path, "-", "--", -1), "/", "-", -1)
+		host := url.Host
+		if isSSHScheme(url.Scheme) {
+			// Share the cache of the https remote of the same repository.
+			host = url.Hostname()
+		}
+		dirname := host + strings.Replace(strings.Replace(url.Path, "-", "--", -1), "/", "-", -1)
 		referenceDir := filepath.Join(jirix.Cache, dirname)
 		if jirix.UsePartialClone(remote) {
 			referenceDir = filepath.Join(jirix.Cache, "partial", dirname)
@@ -687,12 +1178,10 @@ func (p *Project) CacheDirPath(jirix *jiri.X) (string, error) {
 }
 
 func (p *Project) writeJiriRevisionFiles(jirix *jiri.X) error {
-	scm := gitutil.New(jirix, gitutil.RootDirOpt(p.Path))
-	head := "refs/remotes/origin/main"
+	scm := newGit(jirix, gitutil.RootDirOpt(p.Path))
+	head := p.RemoteBranchRef()
 	if p.Revision != "" && p.Revision != "HEAD" {
 		head = p.Revision
-	} else if p.RemoteBranch != "" {
-		head = "refs/remotes/origin/" + p.RemoteBranch
 	}
 	head, err := scm.CurrentRevisionForRef(head)
 	if err != nil {
@@ -738,7 +1227,7 @@ func (p *Project) setDefaultConfigs(jirix *jiri.X) error {
 		// recursion enabled globally.
 		"submodule.recurse": "false",
 	}
-	scm := gitutil.New(jirix, gitutil.RootDirOpt(p.Path))
+	scm := newGit(jirix, gitutil.RootDirOpt(p.Path))
 	for k, v := range configs {
 		if currentVal, err := scm.ConfigGetKey(k); err != nil {
 			return err
@@ -750,7 +1239,67 @@ func (p *Project) setDefaultConfigs(jirix *jiri.X) error {
 			return err
 		}
 	}
-	return nil
+	return p.setManifestConfigs(jirix)
+}
+
+// manifestConfigsKey is the git config where jiri records the keys of the
+// git configs it set from the gitconfig attribute of a project, separated by
+// spaces, so that it unsets those dropped from the attribute.
+const manifestConfigsKey = "jiri.manifestconfigs"
+
+// setManifestConfigs sets the git configs of the gitconfig attribute of p in
+// its local config, and unsets those that it set before and which are not in
+// the attribute anymore. The builtin fsmonitor is only enabled where git has
+// it, as git complains on every command otherwise.
+func (p *Project) setManifestConfigs(jirix *jiri.X) error {
+	configs, err := p.gitConfigs()
+	if err != nil {
+		return err
+	}
+	scm := newGit(jirix, gitutil.RootDirOpt(p.Path))
+	// ConfigGetKey fails for keys which are not set.
+	previous, _ := scm.ConfigGetKey(manifestConfigsKey)
+	if len(configs) == 0 && previous == "" {
+		return nil
+	}
+	applied := make(map[string]bool)
+	var keys []string
+	for _, config := range configs {
+		k, v := config[0], config[1]
+		if on, _ := parseGitBool(v); k == "core.fsmonitor" && on && !scm.SupportsBuiltinFSMonitor() {
+			jirix.Logger.Debugf("Not enabling the fsmonitor of project %s(%s), git has no builtin fsmonitor on this host", p.Name, p.Path)
+			continue
+		}
+		if !applied[k] {
+			applied[k] = true
+			keys = append(keys, k)
+		}
+		if currentVal, err := scm.ConfigGetKey(k); err == nil && currentVal == v {
+			continue
+		}
+		if err := scm.Config(k, v); err != nil {
+			return fmt.Errorf("not able to set %s for project %s(%s) due to error: %v", k, p.Name, p.Path, err)
+		}
+	}
+	for _, k := range strings.Fields(previous) {
+		if applied[k] {
+			continue
+		}
+		if _, err := scm.ConfigGetKey(k); err != nil {
+			continue
+		}
+		if err := scm.Config("--unset-all", k); err != nil {
+			return fmt.Errorf("not able to unset %s for project %s(%s) due to error: %v", k, p.Name, p.Path, err)
+		}
+	}
+	switch current := strings.Join(keys, " "); {
+	case current == previous:
+		return nil
+	case current == "":
+		return scm.Config("--unset-all", manifestConfigsKey)
+	default:
+		return scm.Config(manifestConfigsKey, current)
+	}
 }
 
 func (p *Project) setupDefaultPushTarget(jirix *jiri.X) error {
@@ -758,12 +1307,13 @@ func (p *Project) setupDefaultPushTarget(jirix *jiri.X) error {
 		// Skip projects w/o gerrit host
 		return nil
 	}
-	scm := gitutil.New(jirix, gitutil.RootDirOpt(p.Path))
+	scm := newGit(jirix, gitutil.RootDirOpt(p.Path))
 	defaultPushRefSpec := "HEAD:refs/for/main"
-	pushRefSpec, err := scm.ConfigGetKey("remote.origin.push")
+	pushKey := "remote." + p.PrimaryRemote() + ".push"
+	pushRefSpec, err := scm.ConfigGetKey(pushKey)
 	if err != nil || pushRefSpec != defaultPushRefSpec {
-		if err := scm.Config("remote.origin.push", defaultPushRefSpec); err != nil {
-			return fmt.Errorf("not able to set remote.origin.push for project %s(%s) due to error: %v", p.Name, p.Path, err)
+		if err := scm.Config(pushKey, defaultPushRefSpec); err != nil {
+			return fmt.Errorf("not able to set %s for project %s(%s) due to error: %v", pushKey, p.Name, p.Path, err)
 		}
 	}
 	if err := scm.Config("--get", "push.default"); err != nil {
@@ -772,27 +1322,26 @@ func (p *Project) setupDefaultPushTarget(jirix *jiri.X) error {
 			return fmt.Errorf("not able to set push.default for project %s(%s) due to error: %v", p.Name, p.Path, err)
 		}
 	}
-	jirix.Logger.Debugf("set remote.origin.push to \"HEAD:refs/for/main\" for project %s(%s)", p.Name, p.Path)
+	jirix.Logger.Debugf("set %s to \"HEAD:refs/for/main\" for project %s(%s)", pushKey, p.Name, p.Path)
 	return nil
 }
 
 func (p *Project) setupPushURL(jirix *jiri.X) error {
-	scm := gitutil.New(jirix, gitutil.RootDirOpt(p.Path))
-	if err := scm.Config("remote.origin.pushurl", rewriteHTTPSToSSO(p.Remote)); err != nil {
-		return fmt.Errorf("not able to set remote.origin.pushurl for project %s(%s) due to error: %v", p.Name, p.Path, err)
+	scm := newGit(jirix, gitutil.RootDirOpt(p.Path))
+	pushURLKey := "remote." + p.PrimaryRemote() + ".pushurl"
+	if err := scm.Config(pushURLKey, rewriteHTTPSToSSO(p.Remote)); err != nil {
+		return fmt.Errorf("not able to set %s for project %s(%s) due to error: %v", pushURLKey, p.Name, p.Path, err)
 	}
-	jirix.Logger.Debugf("set remote.origin.pushurl to %s for project %s(%s)", rewriteHTTPSToSSO(p.Remote), p.Name, p.Path)
+	jirix.Logger.Debugf("set %s to %s for project %s(%s)", pushURLKey, rewriteHTTPSToSSO(p.Remote), p.Name, p.Path)
 	return nil
 }
 
 func (p *Project) IsOnJiriHead(jirix *jiri.X) (bool, error) {
-	scm := gitutil.New(jirix, gitutil.RootDirOpt(p.Path))
-	jiriHead := "refs/remotes/origin/main"
+	scm := newGit(jirix, gitutil.RootDirOpt(p.Path))
+	jiriHead := p.RemoteBranchRef()
 	var err error
 	if p.Revision != "" && p.Revision != "HEAD" {
 		jiriHead = p.Revision
-	} else if p.RemoteBranch != "" {
-		jiriHead = "refs/remotes/origin/" + p.RemoteBranch
 	}
 	jiriHead, err = scm.CurrentRevisionForRef(jiriHead)
 	if err != nil {
@@ -851,8 +1400,10 @@ func (sm ScanMode) String() string {
 // CreateSnapshot creates a manifest that encodes the current state of
 // HEAD of all projects and writes this snapshot out to the given file.
 // if hooks are not passed, jiri will read JiriManifestFile and get hooks from there,
-// so always pass hooks incase updating from a snapshot
-func CreateSnapshot(jirix *jiri.X, file string, hooks Hooks, pkgs Packages, cipdEnsure bool, localManifestProjects []string) error {
+// so always pass hooks incase updating from a snapshot.
+// If filter is not empty, only the projects, packages and hooks it selects
+// are recorded.
+func CreateSnapshot(jirix *jiri.X, file string, hooks Hooks, pkgs Packages, cipdEnsure bool, localManifestProjects []string, filter *SnapshotFilter) error {
 	jirix.TimerPush("create snapshot")
 	defer jirix.TimerPop()
 
@@ -869,10 +1420,6 @@ func CreateSnapshot(jirix *jiri.X, file string, hooks Hooks, pkgs Packages, cipd
 		return err
 	}
 
-	for _, project := range localProjects {
-		manifest.Projects = append(manifest.Projects, project)
-	}
-
 	if hooks == nil || pkgs == nil {
 		_, tmpHooks, tmpPkgs, err := LoadManifestFile(jirix, jirix.JiriManifestFile(), localProjects, localManifestProjects)
 		if err != nil {
@@ -886,24 +1433,26 @@ func CreateSnapshot(jirix *jiri.X, file string, hooks Hooks, pkgs Packages, cipd
 		}
 	}
 
-	if cipdEnsure {
-		// CreateCipdSnapshot adds a file suffix to 'file' so it won't conflict with
-		// with the manifest filename.
+	FilterProjectsPackagesByGroup(jirix, localProjects, pkgs)
+	vcsProjects, err := LocalVCSProjects(jirix)
+	if err != nil {
+		return err
+	}
+	for k, project := range vcsProjects {
+		localProjects[k] = project
+	}
+	localProjects, pkgs, hooks, err = filter.apply(jirix, localProjects, pkgs, hooks)
+	if err != nil {
+		return err
+	}
+	for _, project := range localProjects {
+		manifest.Projects = append(manifest.Projects, project)
+	}
 
-		// Create separate snapshots for public and internal
-		publicPkgs := make(Packages)
-		for _, pkg := range pkgs {
-			if !pkg.Internal {
-				publicPkgs[pkg.Key()] = pkg
-			}
-		}
-		err := CreateCipdSnapshot(jirix, publicPkgs, file)
-		if err != nil {
-			return err
-		}
-		// Due to how CIPD works, we want the internal usage version to be public+internal packages.
-		err = CreateCipdSnapshot(jirix, pkgs, file+"_internal")
-		if err != nil {
+	if cipdEnsure {
+		// WriteCipdEnsureFiles adds a file suffix to 'file' so it won't
+		// conflict with the manifest filename.
+		if err := WriteCipdEnsureFiles(jirix, pkgs, file); err != nil {
 			return err
 		}
 	}
@@ -914,6 +1463,7 @@ func CreateSnapshot(jirix *jiri.X, file string, hooks Hooks, pkgs Packages, cipd
 	for _, pack := range pkgs {
 		manifest.Packages = append(manifest.Packages, pack)
 	}
+	manifest.CIPDClient = cipdClientFromPin(jirix.CIPDClient)
 
 	return manifest.ToFile(jirix, file)
 }
@@ -921,10 +1471,23 @@ func CreateSnapshot(jirix *jiri.X, file string, hooks Hooks, pkgs Packages, cipd
 // CheckoutSnapshot updates project state to the state specified in the given
 // snapshot file.  Note that the snapshot file must not contain remote imports.
 func CheckoutSnapshot(jirix *jiri.X, snapshot string, gc, runHooks, fetchPkgs bool, runHookTimeout, fetchTimeout uint, pkgsToSkip []string) error {
+	return CheckoutSnapshotWithParams(jirix, snapshot, UpdateUniverseParams{
+		GC:                   gc,
+		RunHookTimeout:       runHookTimeout,
+		FetchPackagesTimeout: fetchTimeout,
+		RunHooks:             runHooks,
+		FetchPackages:        fetchPkgs,
+		PackagesToSkip:       pkgsToSkip,
+	})
+}
+
+// CheckoutSnapshotWithParams is like CheckoutSnapshot, with the options of
+// the update given by params.
+func CheckoutSnapshotWithParams(jirix *jiri.X, snapshot string, params UpdateUniverseParams) error {
 	jirix.UsingSnapshot = true
 	// Find all local projects.
 	scanMode := FastScan
-	if gc {
+	if params.GC {
 		scanMode = FullScan
 	}
 	localProjects, err := LocalProjects(jirix, scanMode)
@@ -935,19 +1498,12 @@ func CheckoutSnapshot(jirix *jiri.X, snapshot string, gc, runHooks, fetchPkgs bo
 	if err != nil {
 		return err
 	}
-	params := UpdateUniverseParams{
-		GC:                   gc,
-		RunHookTimeout:       runHookTimeout,
-		FetchPackagesTimeout: fetchTimeout,
-		RunHooks:             runHooks,
-		FetchPackages:        fetchPkgs,
-		PackagesToSkip:       pkgsToSkip,
-	}
 	return updateProjects(jirix, localProjects, remoteProjects, hooks, pkgs, true /*snapshot*/, params)
 }
 
-// LoadSnapshotFile loads the specified snapshot manifest.  If the snapshot
-// manifest contains a remote import, an error will be returned.
+// LoadSnapshotFile loads the specified snapshot manifest, a local file or a
+// URL, see fetchSnapshot.  If the snapshot manifest contains a remote import,
+// an error will be returned.
 func LoadSnapshotFile(jirix *jiri.X, snapshot string) (Projects, Hooks, Packages, error) {
 	// Snapshot files already have pinned Project revisions and Package instance IDs.
 	// They will cause conflicts with current lockfiles. Disable the lockfile for now.
@@ -960,26 +1516,9 @@ func LoadSnapshotFile(jirix *jiri.X, snapshot string) (Projects, Hooks, Packages
 		if !os.IsNotExist(err) {
 			return nil, nil, nil, fmtError(err)
 		}
-		u, err := url.ParseRequestURI(snapshot)
-		if err != nil {
-			return nil, nil, nil, fmt.Errorf("%q is neither a URL nor a valid file path", snapshot)
-		}
-		jirix.Logger.Infof("Getting snapshot from URL %q", u)
-		resp, err := http.Get(u.String())
-		if err != nil {
-			return nil, nil, nil, fmt.Errorf("Error getting snapshot from URL %q: %v", u, err)
-		}
-		defer resp.Body.Close()
-		tmpFile, err := os.CreateTemp("", "snapshot")
-		if err != nil {
-			return nil, nil, nil, fmt.Errorf("Error creating tmp file: %v", err)
-		}
-		snapshot = tmpFile.Name()
-		defer os.Remove(snapshot)
-		if _, err = io.Copy(tmpFile, resp.Body); err != nil {
-			return nil, nil, nil, fmt.Errorf("Error writing to tmp file: %v", err)
+		if snapshot, err = fetchSnapshot(jirix, snapshot); err != nil {
+			return nil, nil, nil, err
 		}
-
 	}
 
 	m, err := ManifestFromFile(jirix, snapshot)
@@ -997,7 +1536,7 @@ func LoadSnapshotFile(jirix *jiri.X, snapshot string) (Projects, Hooks, Packages
 // reading the jiri project metadata located in a directory at the root of the
 // current repository.
 func CurrentProject(jirix *jiri.X) (*Project, error) {
-	gitDir, err := gitutil.New(jirix).AbsoluteGitDir()
+	gitDir, err := newGit(jirix).AbsoluteGitDir()
 	if err != nil {
 		return nil, nil
 	}
@@ -1017,14 +1556,28 @@ func CurrentProject(jirix *jiri.X) (*Project, error) {
 func setProjectRevisions(jirix *jiri.X, projects Projects) (Projects, error) {
 	jirix.TimerPush("set revisions")
 	defer jirix.TimerPop()
+	var mu sync.Mutex
+	var eg errgroup.Group
+	limit := make(chan struct{}, jirix.Jobs)
 	for name, project := range projects {
-		scm := gitutil.New(jirix, gitutil.RootDirOpt(project.Path))
-		revision, err := scm.CurrentRevision()
-		if err != nil {
-			return nil, fmt.Errorf("Can't get revision for project %q: %v", project.Name, err)
-		}
-		project.Revision = revision
-		projects[name] = project
+		// jirix is not threadsafe, so we make a clone for each goroutine.
+		jirix := jirix.Clone(tool.ContextOpts{})
+		eg.Go(func() error {
+			limit <- struct{}{}
+			defer func() { <-limit }()
+			revision, err := currentRevision(jirix, project.Path)
+			if err != nil {
+				return fmt.Errorf("Can't get revision for project %q: %v", project.Name, err)
+			}
+			project.Revision = revision
+			mu.Lock()
+			projects[name] = project
+			mu.Unlock()
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
 	}
 	return projects, nil
 }
@@ -1036,28 +1589,16 @@ func rewriteHTTPSToSSO(remote string) string {
 	return httpsRe.ReplaceAllString(remote, "sso://$1/$2")
 }
 
+// rewriteRemote returns the URL jiri should use to talk to remote, after
+// applying the sso:// to https:// rewrite if enabled. The URL rewrite rules
+// from the jiri config are applied by git for each command instead, see
+// gitutil.ProjectOpt, so that the remotes stored in the repositories don't
+// hold the credentials of the rules.
 func rewriteRemote(jirix *jiri.X, remote string) string {
-	if !jirix.RewriteSsoToHttps {
-		return remote
-	}
-	if strings.HasPrefix(remote, "sso://") {
-		return ssoRe.ReplaceAllString(remote, "https://$1.googlesource.com/")
-	}
-	return remote
-}
-
-// rewriteAndNormalizeRemote rewrites sso:// prefixed remotes and removes the
-// scheme (e.g. https://) from the remote.
-func rewriteAndNormalizeRemote(remote string) string {
-	if strings.HasPrefix(remote, "sso://") {
+	if jirix.RewriteSsoToHttps && strings.HasPrefix(remote, "sso://") {
 		remote = ssoRe.ReplaceAllString(remote, "https://$1.googlesource.com/")
 	}
-	u, err := url.Parse(remote)
-	if err != nil {
-		// If remote isn't parseable don't try to remove schema
-		return remote
-	}
-	return strings.TrimPrefix(remote, u.Scheme)
+	return remote
 }
 
 // LocalProjects returns projects on the local filesystem.  If all projects in
@@ -1084,11 +1625,15 @@ func LocalProjects(jirix *jiri.X, scanMode ScanMode) (Projects, error) {
 		// load the snapshot, in order to determine the local projects.
 		snapshotProjects, _, _, err := LoadSnapshotFile(jirix, latestSnapshot)
 		if err != nil {
-			if err == errVersionMismatch {
+			// Projects of the snapshot may be duplicates once their remotes
+			// are normalized, if it was written by an older jiri.
+			if err == errVersionMismatch || errors.Is(err, errDuplicateProject) {
 				return loadLocalProjectsSlow(jirix)
 			}
 			return nil, err
 		}
+		// Only git projects are found by scanning, see LocalVCSProjects.
+		splitVCSProjects(snapshotProjects)
 		projectsExist, err := projectsExistLocally(jirix, snapshotProjects)
 		if err != nil {
 			return nil, err
@@ -1157,12 +1702,29 @@ func MatchLocalWithRemote(localProjects, remoteProjects Projects) {
 		return
 	}
 
+	// A duplicate left by an older jiri, see findLocalProjects, may be the
+	// checkout at the path of the remote project: swap their keys.
+	for remoteKey, remoteProject := range remoteProjects {
+		local, ok := localProjects[remoteKey]
+		if !ok || local.Path == remoteProject.Path {
+			continue
+		}
+		for localKey := range localKeysNotInRemote {
+			dup := localProjects[localKey]
+			if dup.Path == remoteProject.Path && localKey == legacyKey(dup) && MakeProjectKey(dup.Name, dup.Remote) == remoteKey {
+				local.ComputedKey, dup.ComputedKey = localKey, remoteKey
+				localProjects[localKey], localProjects[remoteKey] = local, dup
+				break
+			}
+		}
+	}
+
 	for remoteKey, remoteProject := range remoteProjects {
 		if _, ok := localProjects[remoteKey]; !ok {
 			for localKey := range localKeysNotInRemote {
 				localProject := localProjects[localKey]
 				if localProject.Path == remoteProject.Path &&
-					(localProject.Name == remoteProject.Name || rewriteAndNormalizeRemote(localProject.Remote) == rewriteAndNormalizeRemote(remoteProject.Remote)) {
+					(localProject.Name == remoteProject.Name || normalizeRemote(localProject.Remote) == normalizeRemote(remoteProject.Remote)) {
 					delete(localProjects, localKey)
 					delete(localKeysNotInRemote, localKey)
 					// Change local project key
@@ -1179,10 +1741,10 @@ func MatchLocalWithRemote(localProjects, remoteProjects Projects) {
 	}
 }
 
-func loadManifestFiles(jirix *jiri.X, manifestFiles []string, localManifestProjects []string) (Projects, Packages, error) {
+func loadManifestFiles(jirix *jiri.X, manifestFiles []string, localManifestProjects []string) (Projects, Hooks, Packages, ManifestLocks, error) {
 	localProjects, err := LocalProjects(jirix, FastScan)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, nil, err
 	}
 	jirix.Logger.Debugf("Print local projects: ")
 	for _, v := range localProjects {
@@ -1190,7 +1752,9 @@ func loadManifestFiles(jirix *jiri.X, manifestFiles []string, localManifestProje
 	}
 	jirix.Logger.Debugf("Print local projects ends")
 	allProjects := make(Projects)
+	allHooks := make(Hooks)
 	allPkgs := make(Packages)
+	allManifests := make(ManifestLocks)
 
 	addProject := func(projects Projects) error {
 		for _, project := range projects {
@@ -1205,6 +1769,19 @@ func loadManifestFiles(jirix *jiri.X, manifestFiles []string, localManifestProje
 		return nil
 	}
 
+	addHook := func(hooks Hooks) error {
+		for _, hook := range hooks {
+			if existingHook, ok := allHooks[hook.Key()]; ok {
+				if existingHook != hook {
+					return fmt.Errorf("hook: %v conflicts with hook: %v", existingHook, hook)
+				}
+				continue
+			}
+			allHooks[hook.Key()] = hook
+		}
+		return nil
+	}
+
 	addPkg := func(pkgs Packages) error {
 		for _, pkg := range pkgs {
 			if existingPkg, ok := allPkgs[pkg.Key()]; ok {
@@ -1218,39 +1795,58 @@ func loadManifestFiles(jirix *jiri.X, manifestFiles []string, localManifestProje
 		return nil
 	}
 	for _, manifestFile := range manifestFiles {
-		remoteProjects, _, pkgs, err := LoadManifestFile(jirix, manifestFile, localProjects, localManifestProjects)
+		ld, err := loadManifestFile(jirix, manifestFile, localProjects, localManifestProjects)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, nil, err
 		}
-		if err := addProject(remoteProjects); err != nil {
-			return nil, nil, err
+		if err := addProject(ld.Projects); err != nil {
+			return nil, nil, nil, nil, err
 		}
-		if err := addPkg(pkgs); err != nil {
-			return nil, nil, err
+		if err := addHook(ld.Hooks); err != nil {
+			return nil, nil, nil, nil, err
+		}
+		if err := addPkg(ld.Packages); err != nil {
+			return nil, nil, nil, nil, err
+		}
+		for path, m := range ld.ManifestDigests {
+			allManifests[path] = m
 		}
 	}
 
-	return allProjects, allPkgs, nil
+	return allProjects, allHooks, allPkgs, allManifests, nil
 }
 
-func writeLockFile(jirix *jiri.X, lockfilePath string, projectLocks ProjectLocks, pkgLocks PackageLocks, version string) error {
-	data, err := MarshalLockEntries(projectLocks, pkgLocks, version)
-	if err != nil {
-		return err
+// resolveHookLocks computes the digests of the scripts run by hooks. Hooks
+// whose script cannot be read, e.g. because its project is not checked out,
+// are recorded without digest. This is only reported for the hooks of the
+// selected projects, as the others are not expected to be checked out.
+func resolveHookLocks(jirix *jiri.X, hooks Hooks, selected Projects) HookLocks {
+	selectedNames := make(map[string]bool)
+	for _, p := range selected {
+		selectedNames[p.Name] = true
 	}
-	jirix.Logger.Debugf("Generated jiri lockfile content: \n%v", string(data))
+	hookLocks := make(HookLocks)
+	for _, hook := range hooks {
+		lock := HookLock{Name: hook.Name, ProjectName: hook.ProjectName, Action: hook.Action}
+		if data, err := os.ReadFile(filepath.Join(hook.ActionPath, hook.Action)); err == nil {
+			sum := sha256.Sum256(data)
+			lock.Digest = hex.EncodeToString(sum[:])
+		} else if selectedNames[hook.ProjectName] {
+			jirix.Logger.Warningf("Cannot compute the digest of hook %q of project %q: %v\n\n", hook.Name, hook.ProjectName, err)
+		}
+		hookLocks[lock.Key()] = lock
+	}
+	return hookLocks
+}
 
-	tempFile, err := os.CreateTemp(path.Dir(lockfilePath), "jirilock.*")
+func writeLockFile(jirix *jiri.X, lockfilePath string, projectLocks ProjectLocks, pkgLocks PackageLocks, digests LockDigests, version string) error {
+	data, err := MarshalLockFile(projectLocks, pkgLocks, digests, version)
 	if err != nil {
 		return err
 	}
-	defer tempFile.Close()
-	defer os.Remove(tempFile.Name())
-	if _, err := tempFile.Write(data); err != nil {
-		return errors.New("I/O error while writing jiri lockfile")
-	}
-	tempFile.Close()
-	return os.Rename(tempFile.Name(), lockfilePath)
+	jirix.Logger.Debugf("Generated jiri lockfile content: \n%v", string(data))
+
+	return SafeWriteFile(jirix, lockfilePath, data)
 }
 
 // HostnameAllowed determines if hostname is allowed under reference.
@@ -1378,28 +1974,60 @@ func getChangedLocksPkgs(ePkgLocks PackageLocks, pkgs Packages) (PackageLocks, P
 func GenerateJiriLockFile(jirix *jiri.X, manifestFiles []string, resolveConfig ResolveConfig) error {
 	jirix.Logger.Debugf("Generate jiri lockfile for manifests %v to %q", manifestFiles, resolveConfig.LockFilePath())
 
+	var digests LockDigests
+	var eProjectLocks ProjectLocks
+	var changedProjects, changedPkgs map[string]bool
 	resolveLocks := func(jirix *jiri.X, manifestFiles []string, resolveFully bool, ePkgLocks PackageLocks, version string) (projectLocks ProjectLocks, pkgLocks PackageLocks, err error) {
-		projects, pkgs, err := loadManifestFiles(jirix, manifestFiles, resolveConfig.LocalManifestProjects())
+		projects, hooks, pkgs, manifests, err := loadManifestFiles(jirix, manifestFiles, resolveConfig.LocalManifestProjects())
 		if err != nil {
 			return nil, nil, err
 		}
+		FilterProjectsPackagesByGroup(jirix, projects, pkgs)
+		if attrs, ok := resolveConfig.FetchingAttrs(); ok {
+			if err := FilterOptionalProjectsPackages(jirix, attrs, projects, pkgs); err != nil {
+				return nil, nil, err
+			}
+		}
+		if lockDigestsVersion(version) {
+			digests = LockDigests{Hooks: resolveHookLocks(jirix, hooks, projects), Manifests: manifests}
+		}
 		// Check hostnames of projects.
 		if err := CheckProjectsHostnames(projects, resolveConfig.HostnameAllowList()); err != nil {
 			return nil, nil, err
 		}
 		if resolveConfig.EnableProjectLock() {
-			// For project locks, there is no differences between
-			// full or partial resolve.
-			projectLocks, err = resolveProjectLocks(projects)
+			// Without a diff range, there is no differences between full or
+			// partial resolve for project locks.
+			projectsToProcess := projects
+			if !resolveFully && changedProjects != nil {
+				projectsToProcess = make(Projects)
+				for k, v := range projects {
+					if _, ok := eProjectLocks[ProjectLock{Remote: v.Remote, Name: v.Name}.Key()]; !ok || changedProjects[v.Name] {
+						projectsToProcess[k] = v
+					}
+				}
+			}
+			projectLocks, err = resolveProjectLocks(jirix, projectsToProcess)
 			if err != nil {
 				return
 			}
+			// Keep the existing locks of the other projects.
+			for _, v := range projects {
+				key := ProjectLock{Remote: v.Remote, Name: v.Name}.Key()
+				if _, ok := projectLocks[key]; !ok {
+					projectLocks[key] = eProjectLocks[key]
+				}
+			}
 		}
 		if resolveConfig.EnablePackageLock() {
 			var pkgsToProcess Packages
 			var locksToProcess PackageLocks
 			if resolveFully {
 				pkgsToProcess = pkgs
+			} else if changedPkgs != nil {
+				if locksToProcess, pkgsToProcess, err = getRangeChangedLocksPkgs(ePkgLocks, pkgs, changedPkgs); err != nil {
+					return
+				}
 			} else {
 				if locksToProcess, pkgsToProcess, err = getChangedLocksPkgs(ePkgLocks, pkgs); err != nil {
 					jirix.Logger.Warningf("%v, fallback to full resolve", err)
@@ -1489,21 +2117,28 @@ func GenerateJiriLockFile(jirix *jiri.X, manifestFiles []string, resolveConfig R
 	// Read existing lockfile.
 	jsonData, err := os.ReadFile(resolveConfig.LockFilePath())
 	if err == nil {
-		_, ePkgLocks, version, err = UnmarshalLockEntries(jsonData)
+		eProjectLocks, ePkgLocks, version, err = UnmarshalLockEntries(jsonData)
 	}
 	if err != nil {
 		resolveFully = true
 	}
 	resolveFully = resolveFully || resolveConfig.FullResolve()
+	if !resolveFully && resolveConfig.DiffRange() != "" {
+		if changedProjects, changedPkgs, err = manifestRangeChanges(jirix, manifestFiles, resolveConfig.DiffRange()); err != nil {
+			jirix.Logger.Warningf("%v, fallback to full resolve", err)
+			resolveFully = true
+		}
+	}
 	if resolveFully {
-		version = "1.0"
+		// Full resolves migrate lockfiles to the latest version.
+		version = "2.0"
 	}
 
 	projectLocks, pkgLocks, err := resolveLocks(jirix, manifestFiles, resolveFully, ePkgLocks, version)
 	if err != nil {
 		return err
 	}
-	return writeLockFile(jirix, resolveConfig.LockFilePath(), projectLocks, pkgLocks, version)
+	return writeLockFile(jirix, resolveConfig.LockFilePath(), projectLocks, pkgLocks, digests, version)
 }
 
 type UpdateUniverseParams struct {
@@ -1517,6 +2152,31 @@ type UpdateUniverseParams struct {
 	FetchPackagesTimeout  uint
 	PackagesToSkip        []string
 	LocalManifestProjects []string
+	// DryRun prints the plan of the update instead of changing the
+	// projects and packages. Projects are still fetched.
+	DryRun bool
+	// NoCheckout only fetches the projects, into the cache if any, and
+	// leaves working trees, packages and hooks as they are.
+	NoCheckout bool
+	// MigrateDefaultBranch moves the projects whose remote branch no longer
+	// exists to the new default branch of their remote, see
+	// migrateRemoteBranch.
+	MigrateDefaultBranch bool
+	// Paths, if set, restricts the operations of the update to the projects
+	// at these paths, e.g. to retry the projects that failed in the last
+	// update.
+	Paths []string
+	// ForceHooks runs all the hooks, even those whose inputs did not change
+	// since they last ran successfully.
+	ForceHooks bool
+	// FetchCheckpoint records the projects fetched by the update until it
+	// succeeds, so that retrying a failed update only fetches the projects
+	// which were not fetched, see fetchCheckpoint.
+	FetchCheckpoint bool
+	// NoHistory does not record the update in the update history, e.g. for
+	// the temporary checkouts of "jiri bisect", which would otherwise move
+	// "latest" and compact away the snapshots being bisected.
+	NoHistory bool
 }
 
 // UpdateUniverse updates all local projects and tools to match the remote
@@ -1540,6 +2200,10 @@ func UpdateUniverse(jirix *jiri.X, params UpdateUniverseParams) (e error) {
 		MatchLocalWithRemote(localProjects, remoteProjects)
 
 		if err != nil {
+			var classifiedErr jiri.ClassifiedError
+			if !errors.As(err, &classifiedErr) {
+				err = &jiri.ManifestError{Err: err}
+			}
 			return err
 		}
 
@@ -1547,6 +2211,15 @@ func UpdateUniverse(jirix *jiri.X, params UpdateUniverseParams) (e error) {
 		return updateProjects(jirix, localProjects, remoteProjects, hooks, pkgs, false /*snapshot*/, params)
 	}
 
+	// The fetch checkpoint is only needed until the update succeeds.
+	defer func() {
+		if e == nil && params.FetchCheckpoint && !params.DryRun {
+			if err := clearFetchCheckpoint(jirix); err != nil {
+				jirix.Logger.Warningf("Failed to remove the fetch checkpoint: %s\n\n", err)
+			}
+		}
+	}()
+
 	// Specifying gc should always force a full filesystem scan.
 	if params.GC {
 		return updateFn(FullScan)
@@ -1561,7 +2234,7 @@ func UpdateUniverse(jirix *jiri.X, params UpdateUniverseParams) (e error) {
 			if err.Error() == err2.Error() {
 				return err
 			}
-			return fmt.Errorf("%v, %v", err, err2)
+			return fmt.Errorf("%w, %w", err, err2)
 		}
 	}
 
@@ -1577,6 +2250,12 @@ func WriteUpdateHistoryLog(jirix *jiri.X) error {
 	if err := jirix.Logger.WriteLogToFile(logFile); err != nil {
 		return err
 	}
+	if err := appendUpdateProfile(jirix, logFile); err != nil {
+		return err
+	}
+	if err := appendUpdateSummary(jirix, logFile); err != nil {
+		return err
+	}
 
 	latestLink, secondLatestLink := jirix.UpdateHistoryLogLatestLink(), jirix.UpdateHistoryLogSecondLatestLink()
 
@@ -1586,14 +2265,14 @@ func WriteUpdateHistoryLog(jirix *jiri.X) error {
 		return err
 	}
 	if latestLinkExists {
-		latestFile, err := os.Readlink(latestLink)
+		latestFile, err := osutil.Readlink(latestLink)
 		if err != nil {
 			return fmtError(err)
 		}
 		if err := os.RemoveAll(secondLatestLink); err != nil {
 			return fmtError(err)
 		}
-		if err := os.Symlink(latestFile, secondLatestLink); err != nil {
+		if err := osutil.Symlink(latestFile, secondLatestLink); err != nil {
 			return fmtError(err)
 		}
 	}
@@ -1607,14 +2286,14 @@ func WriteUpdateHistoryLog(jirix *jiri.X) error {
 	if err := os.RemoveAll(latestLink); err != nil {
 		return fmtError(err)
 	}
-	return fmtError(os.Symlink(logFile, latestLink))
+	return fmtError(osutil.Symlink(logFile, latestLink))
 }
 
 // WriteUpdateHistorySnapshot creates a snapshot of the current state of all
 // projects and writes it to the update history directory.
 func WriteUpdateHistorySnapshot(jirix *jiri.X, hooks Hooks, pkgs Packages, localManifestProjects []string) error {
 	snapshotFile := filepath.Join(jirix.UpdateHistoryDir(), time.Now().Format(time.RFC3339))
-	if err := CreateSnapshot(jirix, snapshotFile, hooks, pkgs, false, localManifestProjects); err != nil {
+	if err := CreateSnapshot(jirix, snapshotFile, hooks, pkgs, false, localManifestProjects, nil); err != nil {
 		return err
 	}
 
@@ -1629,7 +2308,7 @@ func WriteUpdateHistorySnapshot(jirix *jiri.X, hooks Hooks, pkgs Packages, local
 		if err := os.RemoveAll(secondLatestLink); err != nil {
 			return fmtError(err)
 		}
-		if err := os.Link(latestLink, secondLatestLink); err != nil {
+		if err := osutil.Link(latestLink, secondLatestLink); err != nil {
 			return fmtError(err)
 		}
 	}
@@ -1638,7 +2317,17 @@ func WriteUpdateHistorySnapshot(jirix *jiri.X, hooks Hooks, pkgs Packages, local
 	if err := os.RemoveAll(latestLink); err != nil {
 		return fmtError(err)
 	}
-	return fmtError(os.Link(snapshotFile, latestLink))
+	if err := osutil.Link(snapshotFile, latestLink); err != nil {
+		return fmtError(err)
+	}
+	jirix.RecordUpdateSnapshot(filepath.Base(snapshotFile))
+
+	// Drop snapshots that fall outside of the retention policy. Failing to do
+	// so doesn't affect the update, so only warn about it.
+	if err := CompactUpdateHistory(jirix); err != nil {
+		jirix.Logger.Warningf("Failed to compact update history: %s\n\n", err)
+	}
+	return nil
 }
 
 // CleanupProjects restores the given jiri projects back to their detached
@@ -1684,7 +2373,7 @@ func CleanupProjects(jirix *jiri.X, localProjects Projects, cleanupBranches bool
 // resetLocalProject checks out the detached_head, cleans up untracked files
 // and uncommitted changes, and optionally deletes all the branches except main.
 func resetLocalProject(jirix *jiri.X, local, remote Project, cleanupBranches bool) error {
-	scm := gitutil.New(jirix, gitutil.RootDirOpt(local.Path))
+	scm := newGit(jirix, gitutil.RootDirOpt(local.Path))
 	headRev, err := GetHeadRevision(remote)
 	if err != nil {
 		return err
@@ -1724,7 +2413,7 @@ func IsLocalProject(jirix *jiri.X, path string) (bool, error) {
 	if _, err := os.Stat(dotGit); err != nil {
 		return false, nil
 	}
-	scm := gitutil.New(jirix, gitutil.RootDirOpt(path))
+	scm := newGit(jirix, gitutil.RootDirOpt(path))
 	gitDir, err := scm.AbsoluteGitDir()
 	if err != nil {
 		return false, nil
@@ -1754,7 +2443,7 @@ func IsLocalProject(jirix *jiri.X, path string) (bool, error) {
 // ProjectAtPath returns a Project struct corresponding to the project at the
 // path in the filesystem.
 func ProjectAtPath(jirix *jiri.X, path string) (Project, error) {
-	scm := gitutil.New(jirix, gitutil.RootDirOpt(path))
+	scm := newGit(jirix, gitutil.RootDirOpt(path))
 	gitDir, err := scm.AbsoluteGitDir()
 	if err != nil {
 		return Project{}, err
@@ -1821,14 +2510,32 @@ func findLocalProjects(jirix *jiri.X, path string, projects Projects) error {
 			}
 			projectsMutex.Lock()
 			if p, ok := projects[project.Key()]; ok {
-				projectsMutex.Unlock()
-				errs <- fmt.Errorf("name conflict: both %s and %s contain project with key %v", p.Path, project.Path, project.Key())
-				return
+				if legacyKey(p) == legacyKey(project) {
+					projectsMutex.Unlock()
+					errs <- fmt.Errorf("name conflict: both %s and %s contain project with key %v", p.Path, project.Path, project.Key())
+					return
+				}
+				// Older versions of jiri didn't normalize remotes as
+				// much, and may have checked out the same repository
+				// twice. Keep the first path in the key, and the other
+				// one as a stale project under its legacy key.
+				if project.Path < p.Path {
+					p, project = project, p
+					projects[project.Key()] = p
+				}
+				log <- fmt.Sprintf("Projects at %s and %s are the same repository %q, %s is a duplicate left by an older version of jiri, please delete it\n\n", p.Path, project.Path, project.Name, project.Path)
+				project.ComputedKey = legacyKey(project)
 			}
 			projects[project.Key()] = project
 			projectsMutex.Unlock()
 		}
 
+		// The projects of a jiri root nested in this one belong to it.
+		if path != jirix.Root && jiri.IsRoot(path) {
+			jirix.Logger.Debugf("Skipped nested jiri root %s for local project search", path)
+			return
+		}
+
 		// Recurse into all the sub directories.
 		fileInfos, err := os.ReadDir(path)
 		if err != nil && !os.IsPermission(err) {
@@ -1883,7 +2590,7 @@ func fetchAll(jirix *jiri.X, project Project) error {
 		return fmt.Errorf("project %q does not have a remote", project.Name)
 	}
 
-	scm := gitutil.New(jirix, gitutil.RootDirOpt(project.Path))
+	scm := newGit(jirix, gitutil.RootDirOpt(project.Path), gitutil.ProjectOpt(project.Name))
 	remote := rewriteRemote(jirix, project.Remote)
 	r := remote
 	cachePath, err := project.CacheDirPath(jirix)
@@ -1894,18 +2601,57 @@ func fetchAll(jirix *jiri.X, project Project) error {
 		r = cachePath
 	}
 	defer func() {
-		if err := scm.SetRemoteUrl("origin", remote); err != nil {
+		if err := scm.SetRemoteUrl(project.PrimaryRemote(), remote); err != nil {
 			jirix.Logger.Errorf("failed to set remote back to %v for project %+v", remote, project)
 		}
 	}()
-	if err := scm.SetRemoteUrl("origin", r); err != nil {
+	if err := scm.SetRemoteUrl(project.PrimaryRemote(), r); err != nil {
 		return err
 	}
 	opts := []gitutil.FetchOpt{gitutil.PruneOpt(true)}
-	if project.HistoryDepth > 0 {
-		opts = append(opts, gitutil.DepthOpt(project.HistoryDepth), gitutil.UpdateShallowOpt(true))
+	if project.isShallow() {
+		opts = append(opts, gitutil.DepthOpt(project.HistoryDepth), gitutil.ShallowSinceOpt(project.ShallowSince), gitutil.UpdateShallowOpt(true))
+	}
+	if IsTagRevision(project.Revision) {
+		// Tags are only fetched automatically if they point into fetched
+		// history, so ask for the pinned tag explicitly.
+		opts = append(opts, gitutil.FetchTagOpt(strings.TrimPrefix(project.Revision, "refs/tags/")))
 	}
-	return fetch(jirix, project.Path, "origin", opts...)
+	if refspecs := project.fetchRefspecs(project.PrimaryRemote()); refspecs != nil {
+		opts = append(opts, gitutil.RefspecsOpt(refspecs))
+	}
+	defer timePhase(jirix, project, "fetch")()
+	if cachePath != "" {
+		// The cache was already updated from the alternate remotes if needed.
+		return fetch(jirix, project.Name, project.Path, project.PrimaryRemote(), opts...)
+	}
+	return withAltRemotes(jirix, project, remote, func(r string) error {
+		if err := scm.SetRemoteUrl(project.PrimaryRemote(), r); err != nil {
+			return err
+		}
+		return fetch(jirix, project.Name, project.Path, project.PrimaryRemote(), opts...)
+	})
+}
+
+// fetchShallowRevision deepens the shallow clone of project to include
+// revision, which a fetch of the remote branch does not bring in when the
+// revision is older than the fetched history, for instance when the pinned
+// revision of a manifest project moves backwards.
+func fetchShallowRevision(jirix *jiri.X, project Project, revision string) error {
+	msg := fmt.Sprintf("Fetching %s for %s", revision, project.Path)
+	if err := retry.Function(jirix, func() error {
+		return newGit(jirix, gitutil.RootDirOpt(project.Path), gitutil.ProjectOpt(project.Name)).FetchRefspec(project.PrimaryRemote(), revision,
+			gitutil.DepthOpt(project.HistoryDepth), gitutil.ShallowSinceOpt(project.ShallowSince), gitutil.UpdateShallowOpt(true))
+	}, msg, retry.AttemptsOpt(jirix.Attempts)); err != nil {
+		return &jiri.NetworkError{Err: err}
+	}
+	return nil
+}
+
+// IsTagRevision reports whether rev pins a project to a tag, e.g.
+// "refs/tags/v1.0".
+func IsTagRevision(rev string) bool {
+	return strings.HasPrefix(rev, "refs/tags/")
 }
 
 func GetHeadRevision(project Project) (string, error) {
@@ -1916,15 +2662,16 @@ func GetHeadRevision(project Project) (string, error) {
 	if project.Revision != "HEAD" {
 		return project.Revision, nil
 	}
-	return "remotes/origin/" + project.RemoteBranch, nil
+	return "remotes/" + project.PrimaryRemote() + "/" + project.RemoteBranch, nil
 }
 
 func checkoutHeadRevision(jirix *jiri.X, project Project, forceCheckout bool) error {
+	defer timePhase(jirix, project, "checkout")()
 	revision, err := GetHeadRevision(project)
 	if err != nil {
 		return err
 	}
-	git := gitutil.New(jirix, gitutil.RootDirOpt(project.Path))
+	git := newGit(jirix, gitutil.RootDirOpt(project.Path), gitutil.ProjectOpt(project.Name))
 	opts := []gitutil.CheckoutOpt{
 		gitutil.DetachOpt(true),
 		gitutil.ForceOpt(forceCheckout),
@@ -1935,7 +2682,7 @@ func checkoutHeadRevision(jirix *jiri.X, project Project, forceCheckout bool) er
 	}
 	jirix.Logger.Debugf("Checkout %s to head revision %s failed, fallback to fetch: %v", project.Name, revision, err)
 	if project.Revision != "" && project.Revision != "HEAD" {
-		if err2 := git.FetchRefspec("origin", project.Revision); err2 != nil {
+		if err2 := git.FetchRefspec(project.PrimaryRemote(), project.Revision); err2 != nil {
 			return fmt.Errorf("error while fetching after failed to checkout revision %s for project %s (%s): %s\ncheckout error: %v", revision, project.Name, project.Path, err2, err)
 		}
 		return git.Checkout(revision, opts...)
@@ -1945,7 +2692,8 @@ func checkoutHeadRevision(jirix *jiri.X, project Project, forceCheckout bool) er
 }
 
 func tryRebase(jirix *jiri.X, project Project, branch string) (bool, error) {
-	scm := gitutil.New(jirix, gitutil.RootDirOpt(project.Path))
+	defer timePhase(jirix, project, "rebase")()
+	scm := newGit(jirix, gitutil.RootDirOpt(project.Path))
 	if err := scm.Rebase(branch); err != nil {
 		err := scm.RebaseAbort()
 		return false, err
@@ -1967,10 +2715,21 @@ func syncProjectMaster(jirix *jiri.X, project Project, state ProjectState, rebas
 		return nil
 	}
 
-	scm := gitutil.New(jirix, gitutil.RootDirOpt(project.Path))
+	scm := newGit(jirix, gitutil.RootDirOpt(project.Path))
 
 	if diff, err := scm.FilesWithUncommittedChanges(); err != nil {
 		return fmt.Errorf("Cannot get uncommitted changes for project %q: %s", project.Name, err)
+	} else if len(diff) != 0 && jirix.AutoStash {
+		// The changes are restored after the deferred checkouts below.
+		restore, err := autostash(jirix, project, relativePath)
+		if err != nil {
+			return err
+		}
+		if restore == nil {
+			jirix.AddFailure(&jiri.DirtyTreeError{Project: project.Name, Path: relativePath})
+			return nil
+		}
+		defer restore()
 	} else if len(diff) != 0 {
 		msg := fmt.Sprintf("Project %s(%s) contains uncommitted changes", project.Name, relativePath)
 		if jirix.Logger.LoggerLevel >= log.DebugLevel {
@@ -1979,9 +2738,9 @@ func syncProjectMaster(jirix *jiri.X, project Project, state ProjectState, rebas
 				msg += "\n" + item
 			}
 		}
-		msg += "\nCommit or discard the changes and try again.\n\n"
+		msg += "\nCommit or discard the changes and try again, or update with -autostash.\n\n"
 		jirix.Logger.Errorf("%s", msg)
-		jirix.IncrementFailures()
+		jirix.AddFailure(&jiri.DirtyTreeError{Project: project.Name, Path: relativePath})
 		return nil
 	}
 
@@ -2027,7 +2786,13 @@ func syncProjectMaster(jirix *jiri.X, project Project, state ProjectState, rebas
 			jirix.Logger.Warningf("For project %s(%s), not merging your local branches due to its local-config\n\n", project.Name, relativePath)
 			return nil
 		}
-		if err := scm.Merge(tracking.Name, gitutil.FfOnlyOpt(true)); err != nil {
+		if previous := forcePushedBase(scm, project, state.CurrentBranch); previous != "" {
+			return handleForcePush(jirix, scm, project, relativePath, state.CurrentBranch, previous)
+		}
+		stop := timePhase(jirix, project, "merge")
+		err := scm.Merge(tracking.Name, gitutil.FfOnlyOpt(true))
+		stop()
+		if err != nil {
 			msg := fmt.Sprintf("For project %s(%s), not able to fast forward your local branch %q to %q\n\n", project.Name, relativePath, state.CurrentBranch.Name, tracking.Name)
 			jirix.Logger.Errorf("%s", msg)
 			jirix.IncrementFailures()
@@ -2052,6 +2817,14 @@ func syncProjectMaster(jirix *jiri.X, project Project, state ProjectState, rebas
 	if err != nil {
 		return err
 	}
+	// The branches which cannot be rebased are recorded for "jiri
+	// resolve-conflicts".
+	var failedRebases []FailedRebase
+	defer func() {
+		if err := writeFailedRebases(jirix, project, failedRebases); err != nil {
+			jirix.Logger.Warningf("For project %s(%s), could not record the branches which failed to rebase: %v\n\n", project.Name, relativePath, err)
+		}
+	}()
 	for _, branch := range branches {
 		tracking := branch.Tracking
 		circularDependencyMap := make(map[string]bool)
@@ -2088,6 +2861,12 @@ func syncProjectMaster(jirix *jiri.X, project Project, state ProjectState, rebas
 				jirix.Logger.Warningf("For project %s(%s), not rebasing your local branches due to its local-config\n\n", project.Name, relativePath)
 				break
 			}
+			if previous := forcePushedBase(scm, project, branch); previous != "" {
+				if err := handleForcePush(jirix, scm, project, relativePath, branch, previous); err != nil {
+					return err
+				}
+				continue
+			}
 			if err := scm.Checkout(branch.Name); err != nil {
 				msg := fmt.Sprintf("For project %s(%s), not able to rebase your local branch %q onto %q", project.Name, relativePath, branch.Name, tracking.Name)
 				msg += "\nPlease do it manually\n\n"
@@ -2102,8 +2881,9 @@ func syncProjectMaster(jirix *jiri.X, project Project, state ProjectState, rebas
 			if rebaseSuccess {
 				jirix.Logger.Debugf("For project %q, rebased your local branch %q on %q", project.Name, branch.Name, tracking.Name)
 			} else {
+				failedRebases = append(failedRebases, FailedRebase{Branch: branch.Name, Onto: tracking.Name})
 				msg := fmt.Sprintf("For project %s(%s), not able to rebase your local branch %q onto %q", project.Name, relativePath, branch.Name, tracking.Name)
-				msg += "\nPlease do it manually\n\n"
+				msg += "\nPlease do it manually, e.g. with \"jiri resolve-conflicts -open\"\n\n"
 				jirix.Logger.Errorf("%s", msg)
 				jirix.IncrementFailures()
 			}
@@ -2133,8 +2913,9 @@ func syncProjectMaster(jirix *jiri.X, project Project, state ProjectState, rebas
 			if rebaseSuccess {
 				jirix.Logger.Debugf("For project %q, rebased your untracked branch %q on %q", project.Name, branch.Name, headRevision)
 			} else {
+				failedRebases = append(failedRebases, FailedRebase{Branch: branch.Name, Onto: headRevision})
 				msg := fmt.Sprintf("For project %s(%s), not able to rebase your untracked branch %q onto JIRI_HEAD.", project.Name, relativePath, branch.Name)
-				msg += "\nPlease do it manually\n\n"
+				msg += "\nPlease do it manually, e.g. with \"jiri resolve-conflicts -open\"\n\n"
 				jirix.Logger.Errorf("%s", msg)
 				jirix.IncrementFailures()
 			}
@@ -2153,8 +2934,9 @@ func syncProjectMaster(jirix *jiri.X, project Project, state ProjectState, rebas
 
 // setRemoteHeadRevisions set the repo statuses from remote for
 // projects at HEAD so we can detect when a local project is already
-// up-to-date.
-func setRemoteHeadRevisions(jirix *jiri.X, remoteProjects Projects, localProjects Projects) error {
+// up-to-date. If migrateDefaultBranch is set, projects whose remote
+// branch was replaced by a new default branch upstream are moved to it.
+func setRemoteHeadRevisions(jirix *jiri.X, remoteProjects Projects, localProjects Projects, migrateDefaultBranch bool, localManifestProjects []string) error {
 	jirix.TimerPush("Set Remote Revisions")
 	defer jirix.TimerPop()
 
@@ -2170,12 +2952,20 @@ func setRemoteHeadRevisions(jirix *jiri.X, remoteProjects Projects, localProject
 			for key := range keys {
 				local := localProjects[key]
 				remote := remoteProjects[key]
-				scm := gitutil.New(jirix, gitutil.RootDirOpt(local.Path))
-				b := "main"
-				if remote.RemoteBranch != "" {
-					b = remote.RemoteBranch
+				scm := newGit(jirix, gitutil.RootDirOpt(local.Path))
+				if IsTagRevision(remote.Revision) {
+					// Resolve the tag to the commit it points to, so it
+					// compares equal to the locally checked out revision.
+					rev, err := scm.CurrentRevisionForRef(remote.Revision)
+					if err != nil {
+						jirix.Logger.Debugf("Cannot resolve %q for project %s(%s): %s", remote.Revision, remote.Name, local.Path, err)
+						continue
+					}
+					remote.Revision = rev
+					updatedRemotes <- remote
+					continue
 				}
-				rev, err := scm.CurrentRevisionForRef("remotes/origin/" + b)
+				rev, err := remoteBranchRevision(jirix, scm, local, &remote, migrateDefaultBranch, localManifestProjects)
 				if err != nil {
 					errs <- err
 					return
@@ -2189,7 +2979,7 @@ func setRemoteHeadRevisions(jirix *jiri.X, remoteProjects Projects, localProject
 	for key, local := range localProjects {
 		remote, ok := remoteProjects[key]
 		// Don't update when project has pinned revision or its remote has changed
-		if !ok || remote.Revision != "HEAD" || local.Remote != remote.Remote {
+		if !ok || (remote.Revision != "HEAD" && !IsTagRevision(remote.Revision)) || local.Remote != remote.Remote {
 			continue
 		}
 		keys <- key
@@ -2207,15 +2997,23 @@ func setRemoteHeadRevisions(jirix *jiri.X, remoteProjects Projects, localProject
 	return errFromChannel(errs)
 }
 
-func updateOrCreateCache(jirix *jiri.X, dir, remote, branch, revision string, depth int) error {
+// updateOrCreateCache updates the cache of remote in dir for the project with
+// the given name, creating it if needed. refspecs, if set, are the only refs
+// fetched into the cache, see Project.Refspecs.
+func updateOrCreateCache(jirix *jiri.X, name, dir, remote, branch string, refspecs []string, revision, bundleURL string, depth int, shallowSince string) error {
 	refspec := "+refs/heads/*:refs/heads/*"
-	if depth > 0 {
+	shallow := depth > 0 || shallowSince != ""
+	if shallow {
 		// Shallow cache, fetch only manifest tracked remote branch
 		refspec = fmt.Sprintf("+refs/heads/%s:refs/heads/%s", branch, branch)
 	}
+	filtered := len(refspecs) > 0
+	if filtered {
+		refspec, refspecs = refspecs[0], refspecs[1:]
+	}
 	errCacheCorruption := errors.New("git cache corrupted")
 	updateCache := func() error {
-		scm := gitutil.New(jirix, gitutil.RootDirOpt(dir))
+		scm := newGit(jirix, gitutil.RootDirOpt(dir), gitutil.ProjectOpt(name))
 		// Test if git cache is intact
 		var objectsDir string
 		if jirix.UsePartialClone(remote) {
@@ -2244,6 +3042,12 @@ func updateOrCreateCache(jirix *jiri.X, dir, remote, branch, revision string, de
 			jirix.Logger.Warningf("set remote.origin.fetch failed under git cache directory %q due to error: %v", dir, err)
 			return errCacheCorruption
 		}
+		for _, r := range refspecs {
+			if err := scm.Config("--add", "remote.origin.fetch", r); err != nil {
+				jirix.Logger.Warningf("set remote.origin.fetch failed under git cache directory %q due to error: %v", dir, err)
+				return errCacheCorruption
+			}
+		}
 		if jirix.UsePartialClone(remote) {
 			if err := scm.AddOrReplacePartialRemote("origin", remote); err != nil {
 				return err
@@ -2266,17 +3070,22 @@ func updateOrCreateCache(jirix *jiri.X, dir, remote, branch, revision string, de
 			// Use --update-head-ok here to force fetch to update the current branch.
 			// This is used in the case of a partial clone having a working tree
 			// checked out in the cache.
-			if err := scm.FetchRefspec("origin", refspec,
-				gitutil.DepthOpt(depth), gitutil.PruneOpt(true), gitutil.UpdateShallowOpt(true), gitutil.UpdateHeadOkOpt(true)); err != nil {
+			if err := scm.FetchRefspec("origin", refspec, gitutil.RefspecsOpt(refspecs),
+				gitutil.DepthOpt(depth), gitutil.ShallowSinceOpt(shallowSince), gitutil.PruneOpt(true), gitutil.UpdateShallowOpt(true), gitutil.UpdateHeadOkOpt(true)); err != nil {
 				return err
 			}
+			if IsTagRevision(revision) {
+				if err := scm.FetchRefspec("origin", "", gitutil.FetchTagOpt(strings.TrimPrefix(revision, "refs/tags/")), gitutil.DepthOpt(depth), gitutil.ShallowSinceOpt(shallowSince)); err != nil {
+					return err
+				}
+			}
 			if jirix.UsePartialClone(remote) {
 				if err := scm.Checkout(revision, gitutil.DetachOpt(true), gitutil.ForceOpt(true)); err != nil {
 					return err
 				}
 			}
 			return nil
-		}, fmt.Sprintf("Fetching for %s:%s", dir, refspec),
+		}, fmt.Sprintf("Fetching for %s:%s", dir, strings.Join(append([]string{refspec}, refspecs...), " ")),
 			retry.AttemptsOpt(jirix.Attempts)); err != nil {
 			return err
 		}
@@ -2293,17 +3102,28 @@ func updateOrCreateCache(jirix *jiri.X, dir, remote, branch, revision string, de
 		t := jirix.Logger.TrackTime("%s", msg)
 		defer t.Done()
 
-		opts := []gitutil.CloneOpt{gitutil.DepthOpt(depth)}
+		opts := []gitutil.CloneOpt{gitutil.DepthOpt(depth), gitutil.ShallowSinceOpt(shallowSince)}
 		if jirix.UsePartialClone(remote) {
 			opts = append(opts, gitutil.NoCheckoutOpt(true), gitutil.OmitBlobsOpt(true))
 		} else {
 			opts = append(opts, gitutil.BareOpt(true))
 		}
-		if err := gitutil.New(jirix).Clone(remote, dir, opts...); err != nil {
-			return err
+		if filtered && !jirix.UsePartialClone(remote) {
+			// A clone would fetch all the refs, so only create the
+			// repository and let updateCache fetch the filtered refs.
+			if err := newGit(jirix).Init(dir, gitutil.BareOpt(true)); err != nil {
+				return err
+			}
+			if err := newGit(jirix, gitutil.RootDirOpt(dir)).Config("remote.origin.url", remote); err != nil {
+				return err
+			}
+		} else if !cloneFromBundle(jirix, name, remote, dir, bundleURL, shallow, opts...) {
+			if err := newGit(jirix, gitutil.ProjectOpt(name)).Clone(remote, dir, opts...); err != nil {
+				return err
+			}
 		}
 
-		git := gitutil.New(jirix, gitutil.RootDirOpt(dir))
+		git := newGit(jirix, gitutil.RootDirOpt(dir), gitutil.ProjectOpt(name))
 		if jirix.UsePartialClone(remote) {
 			if err := git.Checkout(revision, gitutil.DetachOpt(true), gitutil.ForceOpt(true)); err != nil {
 				return err
@@ -2348,7 +3168,7 @@ func updateCache(jirix *jiri.X, remoteProjects Projects) error {
 	errs := make(chan error, len(remoteProjects))
 	var wg sync.WaitGroup
 	processingPath := make(map[string]*sync.Mutex)
-	fetchLimit := make(chan struct{}, jirix.Jobs)
+	limiter := newHostLimiter(jirix)
 	for _, project := range remoteProjects {
 		if cacheDirPath, err := project.CacheDirPath(jirix); err == nil {
 			if processingPath[cacheDirPath] == nil {
@@ -2359,18 +3179,23 @@ func updateCache(jirix *jiri.X, remoteProjects Projects) error {
 				continue
 			}
 			wg.Add(1)
-			fetchLimit <- struct{}{}
-			go func(dir, remote string, depth int, branch, revision string, cacheMutex *sync.Mutex) {
-				cacheMutex.Lock()
-				defer func() { <-fetchLimit }()
+			go func(project Project, dir, remote string, depth int, shallowSince, branch, revision, bundleURL string, cacheMutex *sync.Mutex) {
 				defer wg.Done()
-				defer cacheMutex.Unlock()
 				remote = rewriteRemote(jirix, remote)
-				if err := updateOrCreateCache(jirix, dir, remote, branch, revision, depth); err != nil {
+				defer limiter.acquire(remote, dir)()
+				cacheMutex.Lock()
+				defer cacheMutex.Unlock()
+				defer timePhase(jirix, project, "cache")()
+				if err := withAltRemotes(jirix, project, remote, func(remote string) error {
+					if err := updateOrCreateCache(jirix, project.Name, dir, remote, branch, project.fetchRefspecs(""), revision, bundleURL, depth, shallowSince); err != nil {
+						return &jiri.NetworkError{Err: err}
+					}
+					return nil
+				}); err != nil {
 					errs <- err
 					return
 				}
-			}(cacheDirPath, project.Remote, project.HistoryDepth, project.RemoteBranch, project.Revision, processingPath[cacheDirPath])
+			}(project, cacheDirPath, project.Remote, project.HistoryDepth, project.ShallowSince, project.RemoteBranch, project.Revision, project.BundleURL, processingPath[cacheDirPath])
 		} else {
 			errs <- err
 		}
@@ -2381,10 +3206,13 @@ func updateCache(jirix *jiri.X, remoteProjects Projects) error {
 	return errFromChannel(errs)
 }
 
-func fetchLocalProjects(jirix *jiri.X, localProjects, remoteProjects Projects) error {
+// fetchLocalProjects fetches the local projects which are in the manifest.
+// Projects recorded in checkpoint, if not nil, are not fetched again, and
+// the projects fetched successfully are recorded in it.
+func fetchLocalProjects(jirix *jiri.X, localProjects, remoteProjects Projects, checkpoint *fetchCheckpoint) error {
 	jirix.TimerPush("fetch local projects")
 	defer jirix.TimerPop()
-	fetchLimit := make(chan struct{}, jirix.Jobs)
+	limiter := newHostLimiter(jirix)
 	errs := make(chan error, len(localProjects))
 	var wg sync.WaitGroup
 	for key, project := range localProjects {
@@ -2397,19 +3225,34 @@ func fetchLocalProjects(jirix *jiri.X, localProjects, remoteProjects Projects) e
 			if r.Remote != project.Remote {
 				continue
 			}
+			if checkpoint.fetched(key, r.Revision) {
+				jirix.Logger.Debugf("Not fetching project %s(%s), it was fetched by the previous attempt of the update", project.Name, project.Path)
+				continue
+			}
 			wg.Add(1)
-			fetchLimit <- struct{}{}
 			project.HistoryDepth = r.HistoryDepth
-			go func(project Project) {
-				defer func() { <-fetchLimit }()
+			project.ShallowSince = r.ShallowSince
+			project.Refspecs = r.Refspecs
+			project.AltRemotes = r.AltRemotes
+			if IsTagRevision(r.Revision) {
+				project.Revision = r.Revision
+			}
+			go func(project Project, key ProjectKey, revision string) {
 				defer wg.Done()
+				// Projects are fetched from their cache, if any.
+				remote := rewriteRemote(jirix, project.Remote)
+				if cachePath, err := project.CacheDirPath(jirix); err == nil && cachePath != "" {
+					remote = cachePath
+				}
+				defer limiter.acquire(remote, project.Path)()
 				task := jirix.Logger.AddTaskMsg("Fetching remotes for project %q", project.Name)
 				defer task.Done()
 				if err := fetchAll(jirix, project); err != nil {
-					errs <- fmt.Errorf("fetch failed for %v: %v", project.Name, err)
+					errs <- &OperationError{Project: project, Operation: "fetch", Err: fmt.Errorf("fetch failed for %v: %w", project.Name, err)}
 					return
 				}
-			}(project)
+				checkpoint.record(key, revision)
+			}(project, key, r.Revision)
 		}
 	}
 	wg.Wait()
@@ -2418,6 +3261,138 @@ func fetchLocalProjects(jirix *jiri.X, localProjects, remoteProjects Projects) e
 	return errFromChannel(errs)
 }
 
+// changedProjects returns the projects updated by ops whose revision differs
+// from the one of the previous update, including new projects. The previous
+// revisions are read from the latest update snapshot, so that retrying a
+// failed update still finds the projects changed by the failed attempt, or
+// from states if there is no usable snapshot. If all is set, all the projects
+// updated by ops are returned.
+func changedProjects(jirix *jiri.X, ops operations, states map[ProjectKey]*ProjectState, all bool) (Projects, error) {
+	previous := make(map[ProjectKey]string)
+	for key, state := range states {
+		previous[key] = state.CurrentBranch.Revision
+	}
+	latestSnapshot := jirix.UpdateHistoryLatestLink()
+	if exists, err := isFile(latestSnapshot); err != nil {
+		return nil, err
+	} else if exists {
+		if snapshotProjects, _, _, err := LoadSnapshotFile(jirix, latestSnapshot); err == nil {
+			for key, p := range snapshotProjects {
+				previous[key] = p.Revision
+			}
+		}
+	}
+	changed := make(Projects)
+	for _, op := range ops {
+		if _, ok := op.(deleteOperation); ok {
+			continue
+		}
+		project := op.Project()
+		if project.LocalConfig.Ignore || project.LocalConfig.NoUpdate {
+			continue
+		}
+		if _, err := os.Stat(project.Path); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmtError(err)
+		}
+		if !all {
+			rev, err := newGit(jirix, gitutil.RootDirOpt(project.Path)).CurrentRevision()
+			if err != nil {
+				return nil, err
+			}
+			if previous[project.Key()] == rev {
+				continue
+			}
+		}
+		changed[project.Key()] = project
+	}
+	return changed, nil
+}
+
+// checkAvailableOffline verifies that the projects and, if checkPkgs is true,
+// the packages can be updated without network access, and returns an error
+// listing everything that is missing locally otherwise.
+func checkAvailableOffline(jirix *jiri.X, localProjects, remoteProjects Projects, pkgs Packages, checkPkgs bool) error {
+	var keys ProjectKeys
+	for key := range remoteProjects {
+		keys = append(keys, key)
+	}
+	sort.Sort(keys)
+	var missing []string
+	for _, key := range keys {
+		remote := remoteProjects[key]
+		local, ok := localProjects[key]
+		if !ok {
+			missing = append(missing, fmt.Sprintf("project %s(%s): not checked out", remote.Name, remote.Path))
+			continue
+		}
+		if local.LocalConfig.Ignore || local.LocalConfig.NoUpdate {
+			continue
+		}
+		if local.Remote != remote.Remote {
+			missing = append(missing, fmt.Sprintf("project %s(%s): remote changed to %q", remote.Name, local.Path, remote.Remote))
+			continue
+		}
+		rev, err := GetHeadRevision(remote)
+		if err != nil {
+			return err
+		}
+		if _, err := newGit(jirix, gitutil.RootDirOpt(local.Path)).CurrentRevisionForRef(rev); err != nil {
+			missing = append(missing, fmt.Sprintf("project %s(%s): %s not available locally", remote.Name, local.Path, rev))
+		}
+	}
+	if checkPkgs {
+		var pkgKeys PackagesByKey
+		for _, pkg := range pkgs {
+			pkgKeys = append(pkgKeys, pkg)
+		}
+		sort.Sort(pkgKeys)
+		for _, pkg := range pkgKeys {
+			pkgPath, err := pkg.ResolvePath()
+			if err != nil {
+				return err
+			}
+			if _, err := os.Stat(filepath.Join(jirix.Root, pkgPath)); err != nil {
+				missing = append(missing, fmt.Sprintf("package %s: not found at %s", pkg.Name, pkgPath))
+			}
+		}
+	}
+	if len(missing) != 0 {
+		return fmt.Errorf("cannot update offline, missing locally:\n%s", strings.Join(missing, "\n"))
+	}
+	return nil
+}
+
+// applyLocalPins sets the revision of the remote projects whose local config
+// pins them to a revision, see "jiri pin".
+func applyLocalPins(jirix *jiri.X, localProjects, remoteProjects Projects) {
+	for key, local := range localProjects {
+		if local.LocalConfig.Pin == "" {
+			continue
+		}
+		remote, ok := remoteProjects[key]
+		if !ok {
+			continue
+		}
+		jirix.Logger.Warningf("Project %s(%s) is pinned to revision %s by its local config, if that is not what you want, please run \"jiri pin -delete %s\" to unpin it.\n\n", local.Name, local.Path, local.LocalConfig.Pin, local.Name)
+		remote.Revision = local.LocalConfig.Pin
+		remoteProjects[key] = remote
+	}
+}
+
+// projectsAtPaths returns the projects of projects at one of paths.
+func projectsAtPaths(projects Projects, paths []string) Projects {
+	selected := make(Projects)
+	for key, p := range projects {
+		if slices.Contains(paths, p.Path) {
+			selected[key] = p
+		}
+	}
+	return selected
+}
+
 // FilterPackagesByName removes packages in place given a list of CIPD package names.
 func FilterPackagesByName(jirix *jiri.X, pkgs Packages, pkgsToSkip []string) {
 	if len(pkgsToSkip) == 0 {
@@ -2468,6 +3443,55 @@ func FilterOptionalProjectsPackages(jirix *jiri.X, attrs string, projects Projec
 	return nil
 }
 
+// FilterProjectsPackagesByGroup removes projects and packages in place that
+// are not selected by jirix.Groups and jirix.ExcludedGroups. Projects and
+// packages that belong to no group are always kept. Otherwise they are
+// removed if any of their groups is excluded, or if groups are selected and
+// none of theirs is.
+func FilterProjectsPackagesByGroup(jirix *jiri.X, projects Projects, pkgs Packages) {
+	include := newAttributes(jirix.Groups)
+	exclude := newAttributes(jirix.ExcludedGroups)
+	if include.IsEmpty() && exclude.IsEmpty() {
+		return
+	}
+	known := make(attributes)
+	for _, v := range projects {
+		known.Add(newAttributes(v.Groups))
+	}
+	for _, v := range pkgs {
+		known.Add(newAttributes(v.Groups))
+	}
+	for _, groups := range []attributes{include, exclude} {
+		for g := range groups {
+			if !known[g] {
+				jirix.Logger.Warningf("Group %q does not have any projects or packages\n\n", g)
+			}
+		}
+	}
+	selected := func(groups string) bool {
+		attrs := newAttributes(groups)
+		if attrs.IsEmpty() {
+			return true
+		}
+		if exclude.Match(attrs) {
+			return false
+		}
+		return include.IsEmpty() || include.Match(attrs)
+	}
+	for k, v := range projects {
+		if !selected(v.Groups) {
+			jirix.Logger.Debugf("project %q is filtered by group (%s)", v.Name, v.Groups)
+			delete(projects, k)
+		}
+	}
+	for k, v := range pkgs {
+		if !selected(v.Groups) {
+			jirix.Logger.Debugf("package %q is filtered by group (%s)", v.Name, v.Groups)
+			delete(pkgs, k)
+		}
+	}
+}
+
 func updateProjects(jirix *jiri.X, localProjects, remoteProjects Projects, hooks Hooks, pkgs Packages, snapshot bool, params UpdateUniverseParams) error {
 	jirix.TimerPush("update projects")
 	defer jirix.TimerPop()
@@ -2487,19 +3511,54 @@ func updateProjects(jirix *jiri.X, localProjects, remoteProjects Projects, hooks
 	if err := FilterOptionalProjectsPackages(jirix, jirix.FetchingAttrs, remoteProjects, pkgs); err != nil {
 		return err
 	}
+	FilterProjectsPackagesByGroup(jirix, remoteProjects, pkgs)
 	FilterPackagesByName(jirix, pkgs, params.PackagesToSkip)
+	applyLocalPins(jirix, localProjects, remoteProjects)
+	vcsProjects := splitVCSProjects(remoteProjects)
 
-	if err := updateCache(jirix, remoteProjects); err != nil {
-		return err
+	if jirix.Offline {
+		if err := checkAvailableOffline(jirix, localProjects, remoteProjects, pkgs, params.FetchPackages); err != nil {
+			return err
+		}
+	} else {
+		if err := updateCache(jirix, remoteProjects); err != nil {
+			return err
+		}
+		fetched := localProjects
+		if len(params.Paths) > 0 {
+			fetched = projectsAtPaths(localProjects, params.Paths)
+		}
+		var checkpoint *fetchCheckpoint
+		if params.FetchCheckpoint {
+			checkpoint = readFetchCheckpoint(jirix, remoteProjects)
+		}
+		err := fetchLocalProjects(jirix, fetched, remoteProjects, checkpoint)
+		if checkpoint != nil {
+			if err2 := checkpoint.write(jirix); err2 != nil {
+				jirix.Logger.Warningf("Failed to write the fetch checkpoint: %s\n\n", err2)
+			}
+		}
+		if err != nil {
+			return err
+		}
 	}
-	if err := fetchLocalProjects(jirix, localProjects, remoteProjects); err != nil {
-		return err
+	if params.NoCheckout {
+		packageFetched, hookRun = true, true
+		return nil
 	}
 	states, err := GetProjectStates(jirix, localProjects, false)
 	if err != nil {
 		return err
 	}
-	if err := setRemoteHeadRevisions(jirix, remoteProjects, localProjects); err != nil {
+	// The revisions the manifest pins projects to, for their provenance,
+	// before those of the projects tracking a branch are resolved.
+	pinnedRevisions := make(map[ProjectKey]string)
+	for key, p := range remoteProjects {
+		if p.Revision != "" && p.Revision != "HEAD" {
+			pinnedRevisions[key] = p.Revision
+		}
+	}
+	if err := setRemoteHeadRevisions(jirix, remoteProjects, localProjects, params.MigrateDefaultBranch && !params.DryRun, params.LocalManifestProjects); err != nil {
 		return err
 	}
 
@@ -2507,6 +3566,21 @@ func updateProjects(jirix *jiri.X, localProjects, remoteProjects Projects, hooks
 	if err != nil {
 		return err
 	}
+	if len(params.Paths) > 0 {
+		// Only run the operations on the selected projects, the others are
+		// left as they are.
+		ops = slices.DeleteFunc(ops, func(op operation) bool {
+			return !slices.Contains(params.Paths, op.Source()) && !slices.Contains(params.Paths, op.Destination())
+		})
+	}
+	if params.DryRun {
+		packageFetched, hookRun = true, true
+		vcsOps, err := computeVCSOperations(jirix, vcsProjects)
+		if err != nil {
+			return err
+		}
+		return printUpdatePlan(jirix, jirix.Stdout(), ops, vcsOps, pkgs, params)
+	}
 
 	batchOps := append(operations(nil), ops...)
 	for len(batchOps) > 0 {
@@ -2525,6 +3599,37 @@ func updateProjects(jirix *jiri.X, localProjects, remoteProjects Projects, hooks
 		if err := runBatch(jirix, params.GC, batch); err != nil {
 			return err
 		}
+		for _, op := range batch {
+			jirix.RecordOperation(op.Kind())
+		}
+	}
+	if err := updateVCSProjects(jirix, vcsProjects, params.GC); err != nil {
+		return err
+	}
+	generated := make(Projects)
+	maps.Copy(generated, remoteProjects)
+	maps.Copy(generated, vcsProjects)
+	if err := writeGeneratedAttributeFiles(jirix, generated); err != nil {
+		return err
+	}
+
+	// Hooks declared by the projects themselves only run when the project
+	// changed revision, unless hooks are forced, and are not recorded in the
+	// update snapshot.
+	var projectHooks Hooks
+	if params.RunHooks {
+		changed, err := changedProjects(jirix, ops, states, params.ForceHooks)
+		if err != nil {
+			return err
+		}
+		if projectHooks, err = loadProjectHooks(jirix, changed); err != nil {
+			return err
+		}
+		for key, hook := range projectHooks {
+			if _, ok := hooks[key]; ok {
+				return fmt.Errorf("hook %q of project %q is declared both in the manifest and in %s", hook.Name, hook.ProjectName, projectHooksFile)
+			}
+		}
 	}
 
 	jirix.TimerPush("jiri revision files")
@@ -2555,6 +3660,9 @@ func updateProjects(jirix *jiri.X, localProjects, remoteProjects Projects, hooks
 			if err := project.writeJiriRevisionFiles(jirix); err != nil {
 				return err
 			}
+			if err := project.writeProvenance(jirix, pinnedRevisions[project.Key()]); err != nil {
+				return err
+			}
 			if err := project.setupDefaultPushTarget(jirix); err != nil {
 				return err
 			}
@@ -2571,9 +3679,21 @@ func updateProjects(jirix *jiri.X, localProjects, remoteProjects Projects, hooks
 	}
 	jirix.TimerPop()
 
+	jirix.TimerPush("jiri project copy and link files")
+	if err := updateProjectFiles(jirix, localProjects, remoteProjects); err != nil {
+		return err
+	}
+	jirix.TimerPop()
+
 	jirix.TimerPush("jiri project flag files")
 
-	if err := WriteProjectFlags(jirix, remoteProjects); err != nil {
+	// Projects and packages writing the same flag file conflict, whichever
+	// is written last wins.
+	if report, err := CheckFlags(jirix, remoteProjects, pkgs); err != nil {
+		jirix.Logger.Errorf("failures in write jiri project flag files: %v", err)
+	} else if err := report.Err(); err != nil && !jirix.ForceFlags {
+		jirix.Logger.Errorf("failures in write jiri project flag files: %v", err)
+	} else if _, err := WriteProjectFlags(jirix, remoteProjects, flagMode(jirix)); err != nil {
 		jirix.Logger.Errorf("failures in write jiri project flag files: %v", err)
 	}
 	jirix.TimerPop()
@@ -2605,7 +3725,8 @@ func updateProjects(jirix *jiri.X, localProjects, remoteProjects Projects, hooks
 
 	if params.FetchPackages {
 		packageFetched = true
-		if len(pkgs) > 0 {
+		// Offline, the packages were verified to be present instead.
+		if len(pkgs) > 0 && !jirix.Offline {
 			if err := FetchPackages(jirix, pkgs, params.FetchPackagesTimeout); err != nil {
 				return err
 			}
@@ -2613,13 +3734,25 @@ func updateProjects(jirix *jiri.X, localProjects, remoteProjects Projects, hooks
 	}
 
 	// Generate snapshot before running hooks so hooks can depend on the snapshot
-	if err := WriteUpdateHistorySnapshot(jirix, hooks, pkgs, params.LocalManifestProjects); err != nil {
-		return err
+	if !params.NoHistory {
+		if err := WriteUpdateHistorySnapshot(jirix, hooks, pkgs, params.LocalManifestProjects); err != nil {
+			return err
+		}
 	}
 
 	if params.RunHooks {
 		hookRun = true
-		if err := RunHooks(jirix, hooks, params.RunHookTimeout); err != nil {
+		allHooks := make(Hooks, len(hooks)+len(projectHooks))
+		for key, hook := range hooks {
+			allHooks[key] = hook
+		}
+		for key, hook := range projectHooks {
+			allHooks[key] = hook
+		}
+		if err := CheckHookPackages(jirix, allHooks, pkgs); err != nil {
+			return err
+		}
+		if err := RunHooks(jirix, allHooks, params.RunHookTimeout, params.ForceHooks); err != nil {
 			return err
 		}
 	}
@@ -2628,6 +3761,7 @@ func updateProjects(jirix *jiri.X, localProjects, remoteProjects Projects, hooks
 		return applyGitHooks(jirix, ops)
 	}
 	jirix.Logger.Warningf("Git hooks are not updated. If you would like to update git hooks for all projects, please run 'jiri init -keep-git-hooks=false'.")
+	warnGitHooksDrift(jirix, ops)
 	return nil
 }
 
@@ -2699,7 +3833,7 @@ func getProjectStatus(jirix *jiri.X, ps Projects) ([]ProjectStatus, error) {
 				if project.LocalConfig.Ignore || project.LocalConfig.NoUpdate {
 					continue
 				}
-				scm := gitutil.New(jirix, gitutil.RootDirOpt(project.Path))
+				scm := newGit(jirix, gitutil.RootDirOpt(project.Path))
 				diff, err := scm.FilesWithUncommittedChanges()
 				if err != nil {
 					errs <- fmt.Errorf("Cannot get uncommitted changes for project %q: %s", project.Name, err)
@@ -5,17 +5,24 @@
 package project_test
 
 import (
+	"archive/tar"
 	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"reflect"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"go.fuchsia.dev/jiri"
 	"go.fuchsia.dev/jiri/cipd"
@@ -23,6 +30,7 @@ import (
 	"go.fuchsia.dev/jiri/jiritest"
 	"go.fuchsia.dev/jiri/jiritest/xtest"
 	"go.fuchsia.dev/jiri/project"
+	"go.fuchsia.dev/jiri/tool"
 )
 
 func dirExists(dirname string) error {
@@ -264,6 +272,99 @@ func TestLocalProjects(t *testing.T) {
 	checkProjectsMatchPaths(t, foundProjects, projectPaths[1:])
 }
 
+// TestLocalProjectsNestedRoot checks that a full scan leaves out the jiri
+// roots nested in the root, keeping a project that contains one.
+func TestLocalProjectsNestedRoot(t *testing.T) {
+	t.Parallel()
+
+	jirix := xtest.NewX(t)
+	outer := filepath.Join(jirix.Root, "outer")
+	paths := []string{
+		outer,
+		filepath.Join(outer, "inner"),
+		filepath.Join(jirix.Root, "nested", "project"),
+	}
+	for i, path := range paths {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+		git := gitutil.New(jirix, gitutil.UserNameOpt("John Doe"), gitutil.UserEmailOpt("john.doe@example.com"), gitutil.RootDirOpt(path))
+		if err := git.Init(path); err != nil {
+			t.Fatal(err)
+		}
+		if err := git.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		p := project.Project{Path: path, Name: projectName(i)}
+		if err := project.InternalWriteMetadata(jirix, p, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, dir := range []string{outer, filepath.Join(jirix.Root, "nested")} {
+		if err := os.MkdirAll(filepath.Join(dir, jiri.RootMetaDir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	foundProjects, err := project.LocalProjects(jirix, project.FullScan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkProjectsMatchPaths(t, foundProjects, paths[:1])
+}
+
+// TestLocalProjectsRevisions checks that LocalProjects keeps reporting the
+// current revision of projects as their HEAD moves.
+func TestLocalProjectsRevisions(t *testing.T) {
+	t.Parallel()
+
+	jirix := xtest.NewX(t)
+	path := filepath.Join(jirix.Root, "repo")
+	if err := os.MkdirAll(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	git := gitutil.New(jirix, gitutil.UserNameOpt("John Doe"), gitutil.UserEmailOpt("john.doe@example.com"), gitutil.RootDirOpt(path))
+	if err := git.Init(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := project.InternalWriteMetadata(jirix, project.Project{Name: "repo", Path: path}, path); err != nil {
+		t.Fatal(err)
+	}
+	checkRevision := func() {
+		t.Helper()
+		want, err := git.CurrentRevision()
+		if err != nil {
+			t.Fatal(err)
+		}
+		projects, err := project.LocalProjects(jirix, project.FullScan)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range projects {
+			if p.Revision != want {
+				t.Errorf("got revision %q, want %q", p.Revision, want)
+			}
+		}
+	}
+
+	var revisions []string
+	for i := 0; i < 3; i++ {
+		if err := git.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		checkRevision()
+		rev, err := git.CurrentRevision()
+		if err != nil {
+			t.Fatal(err)
+		}
+		revisions = append(revisions, rev)
+	}
+	if err := git.Checkout(revisions[0], gitutil.DetachOpt(true)); err != nil {
+		t.Fatal(err)
+	}
+	checkRevision()
+}
+
 // setupUniverse creates a fake jiri root with 3 remote projects.  Each project
 // has a README with text "initial readme".
 func setupUniverse(t *testing.T) ([]project.Project, *jiritest.FakeJiriRoot) {
@@ -333,6 +434,23 @@ func TestUpdateUniverseSimple(t *testing.T) {
 		checkReadme(t, p, "initial readme")
 		checkJiriRevFiles(t, p)
 	}
+
+	// Check that the time spent cloning each project was recorded.
+	profile := fake.X.UpdateProfile()
+	if profile == nil {
+		t.Fatal("expected project timings to be recorded")
+	}
+	cloned := make(map[string]bool)
+	for _, pt := range profile.Projects {
+		if _, ok := pt.Phases["clone"]; ok {
+			cloned[pt.Path] = true
+		}
+	}
+	for _, p := range localProjects {
+		if !cloned[p.Path] {
+			t.Errorf("expected clone timing for project %s, got %+v", p.Name, profile.Projects)
+		}
+	}
 }
 
 func TestUpdateUniverseWhenLocalTracksLocal(t *testing.T) {
@@ -675,6 +793,182 @@ func TestLoadManifestFileRecursiveImport(t *testing.T) {
 	}
 }
 
+// TestImportInheritAttributes checks that projects brought in by an import
+// with inherit-attributes get its attributes, also through import overrides.
+func TestImportInheritAttributes(t *testing.T) {
+	t.Parallel()
+
+	localProjects, fake := setupUniverse(t)
+	manifest, err := fake.ReadRemoteManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lastProject := manifest.Projects[len(manifest.Projects)-1]
+	manifest.Projects = manifest.Projects[:len(manifest.Projects)-1]
+	lastProject.Attributes = "own"
+	remoteManifestStr := "remotemanifest"
+	if err := fake.CreateRemoteProject(remoteManifestStr); err != nil {
+		t.Fatal(err)
+	}
+	remoteManifest := &project.Manifest{
+		Projects: []project.Project{lastProject, {
+			Name:   remoteManifestStr,
+			Path:   remoteManifestStr,
+			Remote: fake.Projects[remoteManifestStr],
+		}},
+	}
+	if err := remoteManifest.ToFile(fake.X, filepath.Join(fake.Projects[remoteManifestStr], "manifest")); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, fake.X, fake.Projects[remoteManifestStr], "manifest", "1")
+	manifest.Imports = []project.Import{{
+		Name:              remoteManifestStr,
+		Remote:            fake.Projects[remoteManifestStr],
+		Manifest:          "manifest",
+		Attributes:        "extra",
+		InheritAttributes: true,
+	}}
+	if err := fake.WriteRemoteManifest(manifest); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	lastPath := filepath.Join(fake.X.Root, lastProject.Path)
+	if _, err := os.Stat(lastPath); !os.IsNotExist(err) {
+		t.Errorf("project %q with inherited attributes should not be fetched by default, stat returned %v", lastProject.Name, err)
+	}
+	if err := dirExists(filepath.Join(fake.X.Root, remoteManifestStr)); err != nil {
+		t.Errorf("manifest project of the import should not inherit its attributes: %v", err)
+	}
+
+	checkAttributes := func(want string) {
+		t.Helper()
+		projects, _, _, err := project.LoadManifestFile(fake.X, fake.X.JiriManifestFile(), project.Projects{}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range projects {
+			switch p.Name {
+			case lastProject.Name:
+				if p.Attributes != want {
+					t.Errorf("project %q has attributes %q, want %q", p.Name, p.Attributes, want)
+				}
+			case remoteManifestStr, localProjects[0].Name:
+				if p.Attributes != "" {
+					t.Errorf("project %q has attributes %q, want none", p.Name, p.Attributes)
+				}
+			}
+		}
+	}
+	checkAttributes("extra,own")
+
+	fake.X.FetchingAttrs = "extra"
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := dirExists(lastPath); err != nil {
+		t.Errorf("project %q should be fetched with its inherited attribute: %v", lastProject.Name, err)
+	}
+
+	// Overrides of the import keep its attributes unless they set theirs.
+	jiriManifest, err := fake.ReadJiriManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	jiriManifest.ImportOverrides = []project.Import{{
+		Name:     remoteManifestStr,
+		Remote:   fake.Projects[remoteManifestStr],
+		Manifest: "manifest",
+		Revision: "HEAD",
+	}}
+	if err := fake.WriteJiriManifest(jiriManifest); err != nil {
+		t.Fatal(err)
+	}
+	checkAttributes("extra,own")
+	jiriManifest.ImportOverrides[0].Attributes = "other"
+	jiriManifest.ImportOverrides[0].InheritAttributes = true
+	if err := fake.WriteJiriManifest(jiriManifest); err != nil {
+		t.Fatal(err)
+	}
+	checkAttributes("other,own")
+}
+
+// TestLoadNestedLocalManifest tests that the manifest of a nested import is
+// read from the working tree of its project when it is a local manifest
+// project.
+func TestLoadNestedLocalManifest(t *testing.T) {
+	t.Parallel()
+
+	_, fake := setupUniverse(t)
+	manifest, err := fake.ReadRemoteManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lastProject := manifest.Projects[len(manifest.Projects)-1]
+	manifest.Projects = manifest.Projects[:len(manifest.Projects)-1]
+	remoteManifestStr := "remotemanifest"
+	if err := fake.CreateRemoteProject(remoteManifestStr); err != nil {
+		t.Fatal(err)
+	}
+	remoteManifest := &project.Manifest{
+		Projects: []project.Project{lastProject, {
+			Name:   remoteManifestStr,
+			Path:   remoteManifestStr,
+			Remote: fake.Projects[remoteManifestStr],
+		}},
+	}
+	if err := remoteManifest.ToFile(fake.X, filepath.Join(fake.Projects[remoteManifestStr], "manifest")); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, fake.X, fake.Projects[remoteManifestStr], "manifest", "1")
+	manifest.Imports = []project.Import{{
+		Name:     remoteManifestStr,
+		Remote:   fake.Projects[remoteManifestStr],
+		Manifest: "manifest",
+	}}
+	if err := fake.WriteRemoteManifest(manifest); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Change the manifest in the working tree of the nested import only.
+	remoteManifest.Projects[0].Attributes = "local"
+	if err := remoteManifest.ToFile(fake.X, filepath.Join(fake.X.Root, remoteManifestStr, "manifest")); err != nil {
+		t.Fatal(err)
+	}
+	localProjects, err := project.LocalProjects(fake.X, project.FastScan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loadLast := func(localManifestProjects []string) project.Project {
+		t.Helper()
+		projects, _, _, err := project.LoadUpdatedManifest(fake.X, localProjects, localManifestProjects)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range projects {
+			if p.Name == lastProject.Name {
+				return p
+			}
+		}
+		t.Fatalf("project %q not found", lastProject.Name)
+		return project.Project{}
+	}
+	if got := loadLast(nil); got.Attributes != "" {
+		t.Errorf("project %q has attributes %q from the remote manifest, want none", got.Name, got.Attributes)
+	}
+	got := loadLast([]string{remoteManifestStr})
+	if got.Attributes != "local" {
+		t.Errorf("project %q has attributes %q from the local manifest, want %q", got.Name, got.Attributes, "local")
+	}
+	if want := []string{remoteManifestStr, jiritest.ManifestProjectName}; !reflect.DeepEqual(got.ImportChain, want) {
+		t.Errorf("project %q has import chain %q, want %q", got.Name, got.ImportChain, want)
+	}
+}
+
 func TestProjectUpdateWhenIgnore(t *testing.T) {
 	t.Parallel()
 
@@ -792,114 +1086,1396 @@ func TestBranchUpdateWhenNoRebase(t *testing.T) {
 	}
 }
 
-// TestHookLoadSimple tests that manifest is loaded correctly
-// with correct project path in hook
-func TestHookLoadSimple(t *testing.T) {
+// TestUpdateIncompleteClone tests that update replaces the incomplete clone
+// of a project left by an interrupted update.
+func TestUpdateIncompleteClone(t *testing.T) {
 	t.Parallel()
 
-	p, fake := setupUniverse(t)
-
-	err := fake.AddHook(project.Hook{Name: "hook1",
-		Action:      "action.sh",
-		ProjectName: p[0].Name})
+	localProjects, fake := setupUniverse(t)
+	p := localProjects[1]
+	if err := os.MkdirAll(filepath.Join(p.Path, ".git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(p.Path, ".git", "HEAD"), []byte("truncated"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	marker := filepath.Join(fake.X.RootMetaDir(), "incomplete_clones", "path-1")
+	if err := os.MkdirAll(filepath.Dir(marker), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(marker, []byte(p.Path+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
 
-	if err != nil {
+	if err := fake.UpdateUniverse(false); err != nil {
 		t.Fatal(err)
 	}
-	err = fake.UpdateUniverse(false)
-	if err == nil {
-		t.Fatal("run hook should throw error as there is no action.sh script")
+	checkReadme(t, p, "initial readme")
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Errorf("the in-progress marker of the clone should be removed, got %v", err)
 	}
 }
 
-// TestRunHookFlag tests that hook is not executed when flag is false
-func TestRunHookFlag(t *testing.T) {
+// TestUpdateStaleCloneMarker tests that a failed clone does not leave its
+// in-progress marker behind, and that update does not trust a marker for a
+// directory which is not a clone.
+func TestUpdateStaleCloneMarker(t *testing.T) {
 	t.Parallel()
 
-	p, fake := setupUniverse(t)
-
-	err := fake.AddHook(project.Hook{Name: "hook1",
-		Action:      "action.sh",
-		ProjectName: p[0].Name})
+	_, fake := setupUniverse(t)
+	missing := project.Project{
+		Name:   "missing",
+		Path:   filepath.Join(fake.X.Root, "missing"),
+		Remote: filepath.Join(t.TempDir(), "missing"),
+	}
+	if err := fake.AddProject(missing); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err == nil {
+		t.Fatalf("expected the clone of a missing remote to fail")
+	}
+	marker := filepath.Join(fake.X.RootMetaDir(), "incomplete_clones", "missing")
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Errorf("the marker of the failed clone should be removed, got %v", err)
+	}
 
-	if err != nil {
+	// The user puts their own files where the clone failed, with a stale
+	// marker.
+	userFile := filepath.Join(missing.Path, "user")
+	if err := os.MkdirAll(missing.Path, 0755); err != nil {
 		t.Fatal(err)
 	}
-	if err := project.UpdateUniverse(fake.X, project.UpdateUniverseParams{
-		RebaseTracked:        true,
-		RunHookTimeout:       project.DefaultHookTimeout,
-		FetchPackagesTimeout: project.DefaultPackageTimeout,
-	}); err != nil {
+	if err := os.WriteFile(userFile, []byte("user file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(marker), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(marker, []byte(missing.Path+"\n"), 0644); err != nil {
 		t.Fatal(err)
 	}
+	if err := fake.UpdateUniverse(false); err == nil {
+		t.Fatalf("expected the update to fail")
+	}
+	if data, err := os.ReadFile(userFile); err != nil || string(data) != "user file" {
+		t.Errorf("the file of the user was removed: %q, %v", data, err)
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Errorf("the stale marker should be removed, got %v", err)
+	}
 }
 
-// TestHookLoadError tests that manifest load
-// throws error for invalid hook
-func TestHookLoadError(t *testing.T) {
+// TestUpdateImportHistoryDepth tests that the historydepth of an import is
+// applied to its manifest project, and that update checks out an older
+// pinned revision of the manifest project.
+func TestUpdateImportHistoryDepth(t *testing.T) {
 	t.Parallel()
 
 	_, fake := setupUniverse(t)
-
-	err := fake.AddHook(project.Hook{Name: "hook1",
-		Action:      "action",
-		ProjectName: "non-existent"})
-
+	// The manifest revision that only lists the manifest project itself,
+	// after the initial commit and the empty manifest.
+	first := fake.ProjectHashes[jiritest.ManifestProjectName][2]
+	m, err := fake.ReadJiriManifest()
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = fake.UpdateUniverse(false)
-	if err == nil {
-		t.Fatal("Update universe should throw error for the hook")
-	}
-	if !strings.Contains(err.Error(), "invalid hook") {
+	m.Imports[0].HistoryDepth = 1
+	m.Imports[0].Revision = first
+	if err := fake.WriteJiriManifest(m); err != nil {
 		t.Fatal(err)
 	}
-}
-
-// TestUpdateUniverseWithRevision checks that UpdateUniverse will pull remote
-// projects at the specified revision.
-func TestUpdateUniverseWithRevision(t *testing.T) {
-	t.Parallel()
-
-	localProjects, fake := setupUniverse(t)
 
-	// Set project 1's revision in the manifest to the current revision.
-	g := gitutil.New(fake.X, gitutil.RootDirOpt(fake.Projects[localProjects[1].Name]))
-	rev, err := g.CurrentRevision()
+	projects, _, _, err := project.LoadManifest(fake.X)
 	if err != nil {
 		t.Fatal(err)
 	}
-	m, err := fake.ReadRemoteManifest()
+	manifestProject, err := projects.FindUnique(jiritest.ManifestProjectName)
 	if err != nil {
 		t.Fatal(err)
 	}
-	projects := []project.Project{}
-	for _, p := range m.Projects {
-		if p.Name == localProjects[1].Name {
-			p.Revision = rev
-		}
-		projects = append(projects, p)
+	if manifestProject.HistoryDepth != 1 {
+		t.Errorf("got historydepth %d for the manifest project, want 1", manifestProject.HistoryDepth)
 	}
-	m.Projects = projects
+
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	rev, err := gitutil.New(fake.X, gitutil.RootDirOpt(filepath.Join(fake.X.Root, jiritest.ManifestProjectPath))).CurrentRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev != first {
+		t.Errorf("got manifest project at %s, want %s", rev, first)
+	}
+}
+
+// TestUpdateDryRun tests that a dry-run update prints its plan without
+// changing the projects.
+func TestUpdateDryRun(t *testing.T) {
+	t.Parallel()
+
+	localProjects, fake := setupUniverse(t)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	p := localProjects[1]
+	writeReadme(t, fake.X, fake.Projects[p.Name], "new readme")
+
+	var stdout bytes.Buffer
+	jirix := fake.X.Clone(tool.ContextOpts{Stdout: &stdout})
+	if err := project.UpdateUniverse(jirix, project.UpdateUniverseParams{
+		GC:     true,
+		DryRun: true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	checkReadme(t, p, "initial readme")
+	want := fmt.Sprintf("advance/rebase project %q located in %q", p.Name, p.Path)
+	if got := stdout.String(); !strings.Contains(got, want) || !strings.Contains(got, "(1 new commit(s))") {
+		t.Errorf("plan %q should contain %q with 1 new commit", got, want)
+	}
+}
+
+// TestManifestVars tests that references to manifest variables are replaced
+// by their value, and that -var overrides the value of the manifest.
+func TestManifestVars(t *testing.T) {
+	t.Parallel()
+
+	localProjects, fake := setupUniverse(t)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	p := localProjects[1]
+	oldRev, err := gitutil.New(fake.X, gitutil.RootDirOpt(p.Path)).CurrentRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeReadme(t, fake.X, fake.Projects[p.Name], "new readme")
+	newRev, err := gitutil.New(fake.X, gitutil.RootDirOpt(fake.Projects[p.Name])).CurrentRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := fake.ReadRemoteManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Vars = []project.Var{{Name: "pinned_rev", Value: oldRev}}
+	for i := range m.Projects {
+		if m.Projects[i].Name == p.Name {
+			m.Projects[i].Revision = "{{pinned_rev}}"
+		}
+	}
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	checkReadme(t, p, "initial readme")
+
+	fake.X.Vars = jiri.ManifestVars{"pinned_rev": newRev}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	checkReadme(t, p, "new readme")
+
+	fake.X.Vars = nil
+	for i := range m.Projects {
+		if m.Projects[i].Name == p.Name {
+			m.Projects[i].Revision = "{{undefined_rev}}"
+		}
+	}
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err == nil || !strings.Contains(err.Error(), `undefined variable "undefined_rev"`) {
+		t.Errorf("got error %v, want an undefined variable error", err)
+	}
+}
+
+// TestUpdateNoCheckout tests that an update with NoCheckout fetches projects
+// without changing their working trees.
+func TestUpdateNoCheckout(t *testing.T) {
+	t.Parallel()
+
+	localProjects, fake := setupUniverse(t)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	p := localProjects[1]
+	writeReadme(t, fake.X, fake.Projects[p.Name], "new readme")
+	remoteRev, err := gitutil.New(fake.X, gitutil.RootDirOpt(fake.Projects[p.Name])).CurrentRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := project.UpdateUniverse(fake.X, project.UpdateUniverseParams{
+		GC:         true,
+		RunHooks:   true,
+		NoCheckout: true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	checkReadme(t, p, "initial readme")
+	if !gitutil.New(fake.X, gitutil.RootDirOpt(p.Path)).IsRevAvailable(fake.X, p.Remote, remoteRev) {
+		t.Errorf("revision %s of project %q was not fetched", remoteRev, p.Name)
+	}
+}
+
+// TestSSHRemotes tests that the SSH and https remotes of a repository are
+// the same project, and that the host keys of the manifest are installed in
+// the known_hosts file of jiri.
+func TestSSHRemotes(t *testing.T) {
+	t.Parallel()
+
+	https := project.MakeProjectKey("repo", "https://host.example.com/repo")
+	for _, remote := range []string{
+		"git@host.example.com:repo",
+		"host.example.com:repo",
+		"ssh://git@host.example.com/repo",
+		"ssh://user@host.example.com:29418/repo",
+	} {
+		if got := project.MakeProjectKey("repo", remote); got != https {
+			t.Errorf("key of remote %q is %v, want %v", remote, got, https)
+		}
+	}
+	if got := project.MakeProjectKey("repo", "https://other.example.com/repo"); got == https {
+		t.Errorf("remotes of different hosts have the same key %v", got)
+	}
+
+	_, fake := setupUniverse(t)
+	m, err := fake.ReadRemoteManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.HostKeys = []project.HostKey{
+		{Host: "host.example.com", Key: "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIHostKey"},
+		{Host: "[host.example.com]:29418", Key: "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIGerritKey"},
+	}
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+	// Keys are only added once.
+	for i := 0; i < 2; i++ {
+		if err := fake.UpdateUniverse(false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(fake.X.KnownHostsFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "host.example.com ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIHostKey\n[host.example.com]:29418 ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIGerritKey\n"
+	if string(data) != want {
+		t.Errorf("got known_hosts %q, want %q", data, want)
+	}
+
+	// Keys dropped from the manifest are removed, and the keys of the
+	// manifests imported by the manifest are ignored.
+	const imported = "imported"
+	if err := fake.CreateRemoteProject(imported); err != nil {
+		t.Fatal(err)
+	}
+	importedManifest := &project.Manifest{
+		HostKeys: []project.HostKey{{Host: "evil.example.com", Key: "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIEvilKey"}},
+	}
+	if err := importedManifest.ToFile(fake.X, filepath.Join(fake.Projects[imported], "manifest")); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, fake.X, fake.Projects[imported], "manifest", "host keys")
+	m.HostKeys = m.HostKeys[:1]
+	m.Imports = append(m.Imports, project.Import{
+		Name:     imported,
+		Remote:   fake.Projects[imported],
+		Manifest: "manifest",
+	})
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	data, err = os.ReadFile(fake.X.KnownHostsFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = "host.example.com ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIHostKey\n"
+	if string(data) != want {
+		t.Errorf("got known_hosts %q, want %q", data, want)
+	}
+}
+
+// TestUpdateRefspecs tests that only the refs of the refspecs attribute of a
+// project, and its remote branch, are fetched.
+func TestUpdateRefspecs(t *testing.T) {
+	t.Parallel()
+
+	localProjects, fake := setupUniverse(t)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	p := localProjects[1]
+	m, err := fake.ReadRemoteManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range m.Projects {
+		if m.Projects[i].Name == p.Name {
+			m.Projects[i].Refspecs = "refs/tags/release-*"
+		}
+	}
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+	remote := fake.Projects[p.Name]
+	git := func(dir string, args ...string) string {
+		out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+		return string(out)
+	}
+	git(remote, "checkout", "-b", "other")
+	writeReadme(t, fake.X, remote, "other readme")
+	git(remote, "tag", "release-1")
+	writeReadme(t, fake.X, remote, "dev readme")
+	git(remote, "tag", "dev-1")
+	git(remote, "checkout", "main")
+	writeReadme(t, fake.X, remote, "main readme")
+
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	checkReadme(t, p, "main readme")
+	refs := git(p.Path, "for-each-ref", "--format=%(refname)")
+	if !strings.Contains(refs, "refs/tags/release-1\n") {
+		t.Errorf("refs/tags/release-1 was not fetched, got refs:\n%s", refs)
+	}
+	for _, ref := range []string{"refs/remotes/origin/other", "refs/tags/dev-1"} {
+		if strings.Contains(refs, ref+"\n") {
+			t.Errorf("%s was fetched, got refs:\n%s", ref, refs)
+		}
+	}
+}
+
+// TestUpdateAltRemotes tests that projects are fetched from their alternate
+// remotes when their remote is unreachable.
+func TestUpdateAltRemotes(t *testing.T) {
+	t.Parallel()
+
+	localProjects, fake := setupUniverse(t)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	fake.X.Attempts = 1
+	p := localProjects[1]
+	remote := fake.Projects[p.Name]
+	mirror := filepath.Join(t.TempDir(), "mirror")
+	if out, err := exec.Command("git", "clone", remote, mirror).CombinedOutput(); err != nil {
+		t.Fatalf("git clone: %v: %s", err, out)
+	}
+	m, err := fake.ReadRemoteManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range m.Projects {
+		if m.Projects[i].Name == p.Name {
+			m.Projects[i].AltRemotes = []project.AltRemote{{Remote: filepath.Join(t.TempDir(), "missing")}, {Remote: mirror}}
+		}
+	}
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(remote, remote+".down"); err != nil {
+		t.Fatal(err)
+	}
+	writeReadme(t, fake.X, mirror, "mirror readme")
+
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	checkReadme(t, p, "mirror readme")
+	url, err := gitutil.New(fake.X, gitutil.RootDirOpt(p.Path)).RemoteUrl("origin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != remote {
+		t.Errorf("got remote %q, want the remote of the project %q", url, remote)
+	}
+}
+
+// TestUpdateMigrateDefaultBranch tests that projects whose remote branch was
+// renamed upstream are only updated with MigrateDefaultBranch, which moves
+// them and their local branches to the new default branch.
+func TestUpdateMigrateDefaultBranch(t *testing.T) {
+	t.Parallel()
+
+	localProjects, fake := setupUniverse(t)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	p := localProjects[1]
+	if out, err := exec.Command("git", "-C", fake.Projects[p.Name], "branch", "-m", "main", "trunk").CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	writeReadme(t, fake.X, fake.Projects[p.Name], "trunk readme")
+	localGit := gitutil.New(fake.X, gitutil.RootDirOpt(p.Path))
+	if err := localGit.CreateBranchWithUpstream("feature", "origin/main"); err != nil {
+		t.Fatal(err)
+	}
+	params := project.UpdateUniverseParams{
+		GC:                    true,
+		LocalManifestProjects: []string{jiritest.ManifestProjectName},
+	}
+	if err := project.UpdateUniverse(fake.X, params); err == nil {
+		t.Fatal("update succeeded without a remote branch")
+	}
+	checkReadme(t, p, "initial readme")
+
+	params.MigrateDefaultBranch = true
+	if err := project.UpdateUniverse(fake.X, params); err != nil {
+		t.Fatal(err)
+	}
+	checkReadme(t, p, "trunk readme")
+	branches, err := localGit.GetAllBranchesInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range branches {
+		if b.Name == "feature" && (b.Tracking == nil || b.Tracking.Name != "origin/trunk") {
+			t.Errorf("branch feature tracks %+v, want origin/trunk", b.Tracking)
+		}
+	}
+	m, err := project.ManifestFromFile(fake.X, filepath.Join(fake.X.Root, jiritest.ManifestProjectPath, jiritest.ManifestFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mp := range m.Projects {
+		if mp.Name == p.Name && mp.RemoteBranch != "trunk" {
+			t.Errorf("got remotebranch %q for project %q, want trunk", mp.RemoteBranch, p.Name)
+		}
+	}
+}
+
+// TestGitHooksDrift tests that hooks of the githooks directory which are
+// missing or modified in a project are reported.
+func TestGitHooksDrift(t *testing.T) {
+	t.Parallel()
+
+	localProjects, fake := setupUniverse(t)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	p := localProjects[1]
+	p.GitHooks = filepath.Join(fake.X.Root, "githooks")
+	if err := os.MkdirAll(p.GitHooks, 0755); err != nil {
+		t.Fatal(err)
+	}
+	hook := []byte("#!/bin/sh\nexit 0\n")
+	if err := os.WriteFile(filepath.Join(p.GitHooks, "pre-commit"), hook, 0755); err != nil {
+		t.Fatal(err)
+	}
+	installed := filepath.Join(p.Path, ".git", "hooks", "pre-commit")
+	for _, test := range []struct {
+		installed []byte
+		want      []string
+	}{
+		{nil, []string{"pre-commit"}},
+		{hook, nil},
+		{[]byte("#!/bin/sh\nexit 1\n"), []string{"pre-commit"}},
+	} {
+		if test.installed != nil {
+			if err := os.WriteFile(installed, test.installed, 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, err := project.GitHooksDrift(fake.X, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("with installed hook %q: got drifted hooks %v, want %v", test.installed, got, test.want)
+		}
+	}
+}
+
+// TestUpdateRebasePolicy tests that the rebase policy of a project in the
+// manifest replaces the rebase flags of update, and that the local config of
+// the project overrides it.
+func TestUpdateRebasePolicy(t *testing.T) {
+	t.Parallel()
+
+	localProjects, fake := setupUniverse(t)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	p := localProjects[1]
+	m, err := fake.ReadRemoteManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range m.Projects {
+		if m.Projects[i].Name == p.Name {
+			m.Projects[i].Rebase = project.RebaseTracked
+		}
+	}
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+	gitLocal := gitutil.New(fake.X, gitutil.RootDirOpt(p.Path))
+	if err := gitLocal.Checkout("main"); err != nil {
+		t.Fatal(err)
+	}
+	// The rebases run by jiri commit too.
+	if err := gitLocal.Config("user.name", "John Doe"); err != nil {
+		t.Fatal(err)
+	}
+	if err := gitLocal.Config("user.email", "john.doe@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The local and remote main branches diverge, so that update can't
+	// fast-forward the local one.
+	diverge := func(name string) string {
+		writeFile(t, fake.X, p.Path, name, "local")
+		writeFile(t, fake.X, fake.Projects[p.Name], name+"-remote", "remote")
+		rev, err := gitutil.New(fake.X, gitutil.RootDirOpt(fake.Projects[p.Name])).CurrentRevision()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rev
+	}
+
+	remoteRev := diverge("file1")
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	if parent, err := gitLocal.CurrentRevisionForRef("HEAD~1"); err != nil || parent != remoteRev {
+		t.Errorf("local branch should be rebased onto %s, its parent is %s (%v)", remoteRev, parent, err)
+	}
+
+	if err := project.WriteLocalConfig(fake.X, p, project.LocalConfig{Rebase: project.RebaseNever}); err != nil {
+		t.Fatal(err)
+	}
+	localRev, err := gitLocal.CurrentRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	diverge("file2")
+	if err := project.UpdateUniverse(fake.X, project.UpdateUniverseParams{
+		RebaseAll:            true,
+		RunHookTimeout:       project.DefaultHookTimeout,
+		FetchPackagesTimeout: project.DefaultPackageTimeout,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if parent, err := gitLocal.CurrentRevisionForRef("HEAD~1"); err != nil || parent != localRev {
+		t.Errorf("local branch should not be rebased with the never policy, its parent is %s (%v), want %s", parent, err, localRev)
+	}
+}
+
+// TestHookLoadSimple tests that manifest is loaded correctly
+// with correct project path in hook
+func TestHookLoadSimple(t *testing.T) {
+	t.Parallel()
+
+	p, fake := setupUniverse(t)
+
+	err := fake.AddHook(project.Hook{Name: "hook1",
+		Action:      "action.sh",
+		ProjectName: p[0].Name})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fake.UpdateUniverse(false)
+	if err == nil {
+		t.Fatal("run hook should throw error as there is no action.sh script")
+	}
+}
+
+// TestRunHookFlag tests that hook is not executed when flag is false
+func TestRunHookFlag(t *testing.T) {
+	t.Parallel()
+
+	p, fake := setupUniverse(t)
+
+	err := fake.AddHook(project.Hook{Name: "hook1",
+		Action:      "action.sh",
+		ProjectName: p[0].Name})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := project.UpdateUniverse(fake.X, project.UpdateUniverseParams{
+		RebaseTracked:        true,
+		RunHookTimeout:       project.DefaultHookTimeout,
+		FetchPackagesTimeout: project.DefaultPackageTimeout,
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestHookLoadError tests that manifest load
+// throws error for invalid hook
+func TestHookLoadError(t *testing.T) {
+	t.Parallel()
+
+	_, fake := setupUniverse(t)
+
+	err := fake.AddHook(project.Hook{Name: "hook1",
+		Action:      "action",
+		ProjectName: "non-existent"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fake.UpdateUniverse(false)
+	if err == nil {
+		t.Fatal("Update universe should throw error for the hook")
+	}
+	if !strings.Contains(err.Error(), "invalid hook") {
+		t.Fatal(err)
+	}
+}
+
+// TestHookRequiresPackage tests that hooks do not run when the packages they
+// require are missing.
+func TestHookRequiresPackage(t *testing.T) {
+	t.Parallel()
+
+	p, fake := setupUniverse(t)
+
+	err := fake.AddHook(project.Hook{Name: "hook1",
+		Action:          "action.sh",
+		ProjectName:     p[0].Name,
+		RequiresPackage: "missing/pkg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fake.UpdateUniverse(false)
+	if err == nil || !strings.Contains(err.Error(), `requires package "missing/pkg"`) {
+		t.Fatalf("expected an error about the missing package, got %v", err)
+	}
+
+	hooks := project.Hooks{}
+	hook := project.Hook{Name: "hook1", ProjectName: p[0].Name, RequiresPackage: "tools/foo, tools/bar"}
+	hooks[hook.Key()] = hook
+	pkgs := project.Packages{}
+	for _, pkg := range []project.Package{
+		{Name: "tools/foo", Path: "prebuilt/foo"},
+		{Name: "tools/bar", Path: "prebuilt/bar"},
+	} {
+		pkgs[pkg.Key()] = pkg
+	}
+	if err := os.MkdirAll(filepath.Join(fake.X.Root, "prebuilt", "foo"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	err = project.CheckHookPackages(fake.X, hooks, pkgs)
+	if err == nil || !strings.Contains(err.Error(), `"tools/bar", which is not fetched`) {
+		t.Fatalf("expected an error about tools/bar not being fetched, got %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(fake.X.Root, "prebuilt", "bar"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := project.CheckHookPackages(fake.X, hooks, pkgs); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestProjectHooks tests that the hooks declared in a project run after the
+// project changes revision, only if the manifest allows the project to.
+func TestProjectHooks(t *testing.T) {
+	t.Parallel()
+
+	localProjects, fake := setupUniverse(t)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	marker := filepath.Join(t.TempDir(), "marker")
+	remoteDir := fake.Projects[localProjects[1].Name]
+	script := writeUncommitedFile(t, remoteDir, "hook.sh", "#!/bin/sh\necho ran >> "+marker+"\n")
+	if err := os.Chmod(script, 0755); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, fake.X, remoteDir, script, "add hook script")
+	if err := os.MkdirAll(filepath.Join(remoteDir, ".jiri"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fake.X, remoteDir, ".jiri/hooks.xml", `<hooks><hook name="mark" action="hook.sh"/></hooks>`)
+
+	// The manifest does not allow the project to declare hooks.
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Fatalf("hook of a project without allowhooks should not run, got %v", err)
+	}
+
+	m, err := fake.ReadRemoteManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range m.Projects {
+		if m.Projects[i].Name == localProjects[1].Name {
+			m.Projects[i].AllowHooks = true
+		}
+	}
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+	writeReadme(t, fake.X, remoteDir, "allow hooks")
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(marker); err != nil || string(data) != "ran\n" {
+		t.Fatalf("hook should have run once, got %q, %v", data, err)
+	}
+	// The project did not change, the hook must not run again.
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(marker); err != nil || string(data) != "ran\n" {
+		t.Fatalf("hook should not run when the project is unchanged, got %q, %v", data, err)
+	}
+
+	writeFile(t, fake.X, remoteDir, ".jiri/hooks.xml", `<hooks><hook name="mark" action="../escape.sh"/></hooks>`)
+	if err := fake.UpdateUniverse(false); err == nil || !strings.Contains(err.Error(), "is not inside the project") {
+		t.Fatalf("expected an error for an action outside of the project, got %v", err)
+	}
+}
+
+// TestHookInputs tests that manifest hooks only run again once their inputs
+// changed, or when they are forced.
+func TestHookInputs(t *testing.T) {
+	t.Parallel()
+
+	localProjects, fake := setupUniverse(t)
+	marker := filepath.Join(t.TempDir(), "marker")
+	remoteDir := fake.Projects[localProjects[1].Name]
+	script := writeUncommitedFile(t, remoteDir, "hook.sh", "echo ran >> "+marker+"\n")
+	commitFile(t, fake.X, remoteDir, script, "add hook script")
+	if err := os.MkdirAll(filepath.Join(remoteDir, "data"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fake.X, remoteDir, "data/input", "1")
+	m, err := fake.ReadRemoteManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Hooks = append(m.Hooks, project.Hook{Name: "mark", Action: "hook.sh", ProjectName: localProjects[1].Name, Interpreter: "sh", Inputs: "data/"})
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+
+	runs := 0
+	update := func(force bool, want int, msg string) {
+		t.Helper()
+		if err := project.UpdateUniverse(fake.X, project.UpdateUniverseParams{
+			RunHooks:             true,
+			ForceHooks:           force,
+			FetchPackages:        true,
+			RunHookTimeout:       project.DefaultHookTimeout,
+			FetchPackagesTimeout: project.DefaultPackageTimeout,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		runs += want
+		data, err := os.ReadFile(marker)
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		if got := strings.Count(string(data), "ran\n"); got != runs {
+			t.Fatalf("%s: hook ran %d times, want %d", msg, got, runs)
+		}
+	}
+	update(false, 1, "first update")
+	writeReadme(t, fake.X, remoteDir, "not an input")
+	update(false, 0, "update without changed inputs")
+	writeFile(t, fake.X, remoteDir, "data/input", "2")
+	update(false, 1, "update with changed inputs")
+	update(true, 1, "forced update")
+}
+
+// TestUpdateSummary tests that the update history log records the hooks and
+// operations of the update, for the snapshot of the update.
+func TestUpdateSummary(t *testing.T) {
+	t.Parallel()
+
+	localProjects, fake := setupUniverse(t)
+	remoteDir := fake.Projects[localProjects[1].Name]
+	for name, content := range map[string]string{"ok.sh": "echo ok\n", "fail.sh": "exit 3\n"} {
+		script := writeUncommitedFile(t, remoteDir, name, content)
+		commitFile(t, fake.X, remoteDir, script, "add "+name)
+	}
+	m, err := fake.ReadRemoteManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Hooks = append(m.Hooks,
+		project.Hook{Name: "ok", Action: "ok.sh", ProjectName: localProjects[1].Name, Interpreter: "sh"},
+		project.Hook{Name: "fail", Action: "fail.sh", ProjectName: localProjects[1].Name, Interpreter: "sh"})
 	if err := fake.WriteRemoteManifest(m); err != nil {
 		t.Fatal(err)
 	}
-	// Update README in all projects.
-	for _, remoteProjectDir := range fake.Projects {
-		writeReadme(t, fake.X, remoteProjectDir, "new revision")
+	if err := project.UpdateUniverse(fake.X, project.UpdateUniverseParams{
+		RunHooks:             true,
+		RunHookTimeout:       project.DefaultHookTimeout,
+		FetchPackagesTimeout: project.DefaultPackageTimeout,
+	}); err == nil {
+		t.Fatalf("expected the failing hook to fail the update")
+	}
+	if err := project.WriteUpdateHistoryLog(fake.X); err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := project.ReadUpdateSummary(fake.X, fake.X.UpdateHistoryLatestLink())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary == nil {
+		t.Fatalf("no summary found for the latest snapshot")
+	}
+	if summary.Operations["create"] != len(localProjects) {
+		t.Errorf("got %d create operations, want %d: %+v", summary.Operations["create"], len(localProjects), summary.Operations)
+	}
+	exitCodes := make(map[string]int)
+	for _, h := range summary.Hooks {
+		exitCodes[h.Name] = h.ExitCode
+	}
+	if want := map[string]int{"ok": 0, "fail": 3}; !reflect.DeepEqual(exitCodes, want) {
+		t.Errorf("got hook exit codes %v, want %v", exitCodes, want)
+	}
+}
+
+// TestHookInterpreters tests that hooks run with their interpreter, or with
+// the one mapped to the extension of their action by the manifest.
+func TestHookInterpreters(t *testing.T) {
+	t.Parallel()
+
+	localProjects, fake := setupUniverse(t)
+	dir := t.TempDir()
+	remoteDir := fake.Projects[localProjects[1].Name]
+	// The scripts are not executable, only their interpreter can run them.
+	for _, name := range []string{"direct.sh", "mapped.jh"} {
+		script := writeUncommitedFile(t, remoteDir, name, "echo ran > "+filepath.Join(dir, name)+"\n")
+		commitFile(t, fake.X, remoteDir, script, "add "+name)
+	}
+	m, err := fake.ReadRemoteManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Hooks = append(m.Hooks,
+		project.Hook{Name: "direct", Action: "direct.sh", ProjectName: localProjects[1].Name, Interpreter: "sh"},
+		project.Hook{Name: "mapped", Action: "mapped.jh", ProjectName: localProjects[1].Name})
+	m.Interpreters = append(m.Interpreters,
+		project.Interpreter{Extension: ".jh", Command: "sh"},
+		project.Interpreter{Extension: ".jh", Command: "no-such-interpreter", OS: "no-such-os"})
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"direct.sh", "mapped.jh"} {
+		if data, err := os.ReadFile(filepath.Join(dir, name)); err != nil || string(data) != "ran\n" {
+			t.Errorf("%s should have run, got %q, %v", name, data, err)
+		}
+	}
+
+	m.Interpreters = append(m.Interpreters, project.Interpreter{Extension: "jh", Command: "sh"})
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err == nil || !strings.Contains(err.Error(), "extension should start with a dot") {
+		t.Fatalf("expected an error for the bad extension, got %v", err)
+	}
+
+	m.Interpreters = m.Interpreters[:len(m.Interpreters)-1]
+	m.Hooks[len(m.Hooks)-2].Interpreter = " "
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err == nil || !strings.Contains(err.Error(), "interpreter cannot be blank") {
+		t.Fatalf("expected an error for the blank interpreter, got %v", err)
+	}
+}
+
+// TestHookTimeout tests that a hook is killed after its own timeout, along
+// with the processes it started.
+func TestHookTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the processes started by hooks are not killed on windows")
+	}
+	t.Parallel()
+
+	localProjects, fake := setupUniverse(t)
+	dir := t.TempDir()
+	late := filepath.Join(dir, "late")
+	remoteDir := fake.Projects[localProjects[1].Name]
+	script := writeUncommitedFile(t, remoteDir, "slow.sh", "(sleep 1; echo late > "+late+") &\nsleep 30\n")
+	commitFile(t, fake.X, remoteDir, script, "add slow.sh")
+	m, err := fake.ReadRemoteManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Hooks = append(m.Hooks, project.Hook{Name: "slow", Action: "slow.sh", ProjectName: localProjects[1].Name, Interpreter: "sh", Timeout: "200ms"})
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := fake.UpdateUniverse(false); err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Fatalf("expected the hook to time out, got %v", err)
+	}
+	if d := time.Since(start); d > 20*time.Second {
+		t.Errorf("the hook should be killed after its timeout, update took %s", d)
+	}
+	time.Sleep(1500 * time.Millisecond)
+	if _, err := os.Stat(late); err == nil {
+		t.Errorf("the processes started by the hook should be killed with it")
+	}
+}
+
+// TestUpdateUniverseWithRevision checks that UpdateUniverse will pull remote
+// projects at the specified revision.
+func TestUpdateUniverseWithRevision(t *testing.T) {
+	t.Parallel()
+
+	localProjects, fake := setupUniverse(t)
+
+	// Set project 1's revision in the manifest to the current revision.
+	g := gitutil.New(fake.X, gitutil.RootDirOpt(fake.Projects[localProjects[1].Name]))
+	rev, err := g.CurrentRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := fake.ReadRemoteManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	projects := []project.Project{}
+	for _, p := range m.Projects {
+		if p.Name == localProjects[1].Name {
+			p.Revision = rev
+		}
+		projects = append(projects, p)
+	}
+	m.Projects = projects
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+	// Update README in all projects.
+	for _, remoteProjectDir := range fake.Projects {
+		writeReadme(t, fake.X, remoteProjectDir, "new revision")
+	}
+	// Check that calling UpdateUniverse() updates all projects except for
+	// project 1.
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range localProjects {
+		if i == 1 {
+			checkReadme(t, p, "initial readme")
+		} else {
+			checkReadme(t, p, "new revision")
+		}
+	}
+}
+
+// TestUpdateUniverseWithBundle checks that new projects are seeded from their
+// "bundleurl", and that an unusable bundle does not prevent the clone.
+func TestUpdateUniverseWithBundle(t *testing.T) {
+	t.Parallel()
+
+	localProjects, fake := setupUniverse(t)
+	bundle := filepath.Join(t.TempDir(), "bundle")
+	cmd := exec.Command("git", "bundle", "create", bundle, "--all")
+	cmd.Dir = fake.Projects[localProjects[1].Name]
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git bundle create failed: %v\n%s", err, out)
+	}
+	m, err := fake.ReadRemoteManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range m.Projects {
+		switch p.Name {
+		case localProjects[0].Name:
+			m.Projects[i].BundleURL = filepath.Join(t.TempDir(), "missing")
+		case localProjects[1].Name:
+			m.Projects[i].BundleURL = bundle
+		}
+	}
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range localProjects {
+		checkReadme(t, p, "initial readme")
+	}
+	// git records the refs of the bundle under refs/bundles.
+	g := gitutil.New(fake.X, gitutil.RootDirOpt(localProjects[1].Path))
+	if _, err := g.CurrentRevisionForRef("refs/bundles/main"); err != nil {
+		t.Errorf("project %s was not cloned from its bundle: %v", localProjects[1].Name, err)
+	}
+}
+
+// TestUpdateUniverseArchiveProject checks that archive projects are
+// downloaded, verified and unpacked, only once, and deleted by gc.
+// TestUnpackArchiveSymlinks checks that archives cannot write, or point
+// symlinks, outside of the directory they are unpacked into through chained
+// symlinks.
+func TestUnpackArchiveSymlinks(t *testing.T) {
+	t.Parallel()
+
+	unpack := func(entries []tar.Header) (string, error) {
+		t.Helper()
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, hdr := range entries {
+			if err := tw.WriteHeader(&hdr); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tw.Write(make([]byte, hdr.Size)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		base := t.TempDir()
+		archive := filepath.Join(base, "archive.tar")
+		if err := os.WriteFile(archive, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open(archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		dir := filepath.Join(base, "root", "unpack")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		return dir, project.InternalUnpackArchive(f, ".tar", dir)
+	}
+
+	chain := []tar.Header{
+		{Name: "sub/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "sub/up", Typeflag: tar.TypeSymlink, Linkname: ".."},
+		{Name: "l", Typeflag: tar.TypeSymlink, Linkname: "sub/up/.."},
+	}
+	dir, err := unpack(append(chain, tar.Header{Name: "l/evil", Typeflag: tar.TypeReg, Mode: 0644, Size: 4}))
+	if err == nil {
+		t.Errorf("expected a file written through chained symlinks to be rejected")
+	}
+	if _, err := os.Lstat(filepath.Join(filepath.Dir(dir), "evil")); !os.IsNotExist(err) {
+		t.Errorf("archive wrote outside of its directory: %v", err)
+	}
+	if _, err := unpack(chain); err == nil {
+		t.Errorf("expected a symlink resolving outside through another symlink to be rejected")
+	}
+
+	if _, err := unpack([]tar.Header{
+		{Name: "lib64/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "lib64/libfoo.so", Typeflag: tar.TypeReg, Mode: 0644, Size: 4},
+		{Name: "lib", Typeflag: tar.TypeSymlink, Linkname: "lib64"},
+		{Name: "bin/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "bin/libfoo.so", Typeflag: tar.TypeSymlink, Linkname: "../lib/libfoo.so"},
+	}); err != nil {
+		t.Errorf("symlinks inside of the archive should be unpacked: %v", err)
+	}
+}
+
+func TestUpdateUniverseArchiveProject(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	content := "vendored readme"
+	if err := tw.WriteHeader(&tar.Header{Name: "foo-1.2/", Typeflag: tar.TypeDir, Mode: 0755}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: "foo-1.2/README", Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	archive := buf.Bytes()
+	sum := sha256.Sum256(archive)
+	digest := hex.EncodeToString(sum[:])
+
+	downloads := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downloads++
+		w.Write(archive)
+	}))
+	defer ts.Close()
+
+	_, fake := setupUniverse(t)
+	m, err := fake.ReadRemoteManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := m.Projects
+	m.Projects = append(m.Projects, project.Project{
+		Name:   "foo",
+		Path:   "third_party/foo",
+		Remote: ts.URL + "/foo-1.2.tar.gz",
+		VCS:    project.ArchiveProjectVCS,
+		SHA256: strings.Repeat("0", 64),
+	})
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err == nil || !strings.Contains(err.Error(), "has sha256 "+digest) {
+		t.Fatalf("expected a digest mismatch error, got %v", err)
+	}
+
+	m.Projects[len(m.Projects)-1].SHA256 = digest
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+	downloads = 0
+	for i := 0; i < 2; i++ {
+		if err := fake.UpdateUniverse(false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	readme := filepath.Join(fake.X.Root, "third_party", "foo", "README")
+	if got, err := os.ReadFile(readme); err != nil {
+		t.Fatal(err)
+	} else if string(got) != content {
+		t.Errorf("got README %q, want %q", got, content)
+	}
+	if info, err := os.Stat(readme); err != nil {
+		t.Fatal(err)
+	} else if info.Mode().Perm()&0222 != 0 {
+		t.Errorf("unpacked file should be read-only, got mode %v", info.Mode())
+	}
+	if downloads != 1 {
+		t.Errorf("got %d downloads, want 1: an unchanged archive should not be downloaded again", downloads)
+	}
+
+	m.Projects = orig
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(readme); err != nil {
+		t.Errorf("archive project should be kept without gc: %v", err)
+	}
+	if err := fake.UpdateUniverse(true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(fake.X.Root, "third_party", "foo")); !os.IsNotExist(err) {
+		t.Errorf("archive project should be deleted with gc, stat returned %v", err)
+	}
+}
+
+// TestUpdateUniverseHgProject checks that Mercurial projects are cloned and
+// updated with the hg backend.
+func TestUpdateUniverseHgProject(t *testing.T) {
+	t.Parallel()
+	if _, err := exec.LookPath("hg"); err != nil {
+		t.Skip("hg is not installed")
+	}
+
+	repo := t.TempDir()
+	hg := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("hg", append([]string{"--config", "ui.username=test"}, args...)...)
+		cmd.Dir = repo
+		cmd.Env = append(os.Environ(), "HGPLAIN=1")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("hg %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+	commit := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(repo, "README"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		hg("commit", "--addremove", "-m", content)
+	}
+	hg("init")
+	commit("first")
+
+	_, fake := setupUniverse(t)
+	m, err := fake.ReadRemoteManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Projects = append(m.Projects, project.Project{
+		Name:   "hgproject",
+		Path:   "third_party/hgproject",
+		Remote: repo,
+		VCS:    project.HgProjectVCS,
+	})
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+	readme := filepath.Join(fake.X.Root, "third_party", "hgproject", "README")
+	for _, want := range []string{"first", "second"} {
+		if want != "first" {
+			commit(want)
+		}
+		if err := fake.UpdateUniverse(false); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := os.ReadFile(readme); err != nil {
+			t.Fatal(err)
+		} else if string(got) != want {
+			t.Errorf("got README %q, want %q", got, want)
+		}
+	}
+
+	// A project removed from the manifest is not deleted with local changes.
+	if err := os.WriteFile(readme, []byte("local change"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m.Projects = m.Projects[:len(m.Projects)-1]
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(readme); err != nil {
+		t.Fatalf("the hg project with local changes should not be deleted: %v", err)
+	}
+	if err := os.WriteFile(readme, []byte("second"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(readme); !os.IsNotExist(err) {
+		t.Errorf("the hg project without local changes should be deleted: %v", err)
+	}
+}
+
+func TestProjectVCSValidation(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		project string
+		err     string
+	}{
+		{`<project name="a" path="a" remote="https://example.com/a" vcs="hg"/>`, ""},
+		{`<project name="a" path="a" remote="https://example.com/a.tar.gz" vcs="archive"/>`, ""},
+		{`<project name="a" path="a" remote="https://example.com/a" vcs="svn"/>`, "unknown vcs"},
+		{`<project name="a" path="a" remote="https://example.com/a" vcs="hg" sha256="` + strings.Repeat("0", 64) + `"/>`, "sha256 can only be set on archive projects"},
+	}
+	for _, test := range tests {
+		_, err := project.ManifestFromBytes([]byte("<manifest><projects>" + test.project + "</projects></manifest>"))
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", test.project, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%s: got error %v, want one containing %q", test.project, err, test.err)
+		}
+	}
+}
+
+// TestUpdateUniverseOffline tests that an offline update does not fetch, and
+// lists the projects that are missing locally.
+func TestUpdateUniverseOffline(t *testing.T) {
+	t.Parallel()
+
+	localProjects, fake := setupUniverse(t)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	local := localProjects[1]
+	scm := gitutil.New(fake.X, gitutil.RootDirOpt(local.Path))
+	rev, err := scm.CurrentRevision()
+	if err != nil {
+		t.Fatal(err)
 	}
-	// Check that calling UpdateUniverse() updates all projects except for
-	// project 1.
+	writeReadme(t, fake.X, fake.Projects[local.Name], "new commit")
+
+	fake.X.Offline = true
 	if err := fake.UpdateUniverse(false); err != nil {
 		t.Fatal(err)
 	}
-	for i, p := range localProjects {
-		if i == 1 {
-			checkReadme(t, p, "initial readme")
-		} else {
-			checkReadme(t, p, "new revision")
-		}
+	if got, err := scm.CurrentRevision(); err != nil {
+		t.Fatal(err)
+	} else if got != rev {
+		t.Errorf("offline update moved project %s to %s, want %s", local.Name, got, rev)
+	}
+
+	if err := fake.CreateRemoteProject("offline-new"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.AddProject(project.Project{
+		Name:   "offline-new",
+		Path:   "offline-new",
+		Remote: fake.Projects["offline-new"],
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// Make the new manifest available locally, as a previous fetch would.
+	manifestDir := filepath.Join(fake.X.Root, jiritest.ManifestProjectPath)
+	if err := gitutil.New(fake.X, gitutil.RootDirOpt(manifestDir)).Fetch("origin"); err != nil {
+		t.Fatal(err)
+	}
+	err = fake.UpdateUniverse(false)
+	if err == nil || !strings.Contains(err.Error(), "project offline-new(") || !strings.Contains(err.Error(), "not checked out") {
+		t.Fatalf("expected an error listing the missing project, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(fake.X.Root, "offline-new")); !os.IsNotExist(err) {
+		t.Errorf("offline update should not clone projects, stat returned %v", err)
+	}
+}
+
+// TestUpdateUniverseWithShallowSince checks that projects with "shallowsince"
+// only fetch the history since that date.
+func TestUpdateUniverseWithShallowSince(t *testing.T) {
+	t.Parallel()
+
+	fake := jiritest.NewFakeJiriRoot(t)
+	if err := fake.CreateRemoteProject("shallow"); err != nil {
+		t.Fatal(err)
+	}
+	remoteDir := fake.Projects["shallow"]
+	// Backdate the initial commit, so that only the README commit is recent.
+	const date = "2000-01-01T00:00:00Z"
+	if err := gitutil.New(fake.X, gitutil.RootDirOpt(remoteDir), gitutil.CommitterDateOpt(date)).CommitAmend(); err != nil {
+		t.Fatal(err)
+	}
+	writeReadme(t, fake.X, remoteDir, "initial readme")
+	p := project.Project{
+		Name: "shallow",
+		Path: filepath.Join(fake.X.Root, "shallow"),
+		// git ignores --shallow-since for clones from a local path.
+		Remote:       "file://" + remoteDir,
+		ShallowSince: "2010-01-01",
+	}
+	if err := fake.AddProject(p); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	checkReadme(t, p, "initial readme")
+	if err := fileExists(filepath.Join(p.Path, ".git", "shallow")); err != nil {
+		t.Errorf("project should be a shallow clone: %v", err)
+	}
+
+	if _, err := project.ManifestFromBytes([]byte(`<manifest><projects><project name="a" historydepth="1" shallowsince="2024-01-01"/></projects></manifest>`)); err == nil {
+		t.Errorf("historydepth and shallowsince should not be allowed together")
 	}
 }
 
@@ -1070,6 +2646,69 @@ func TestUpdateUniverseWithUncommitted(t *testing.T) {
 	}
 }
 
+// TestUpdateUniverseAutoStash checks that -autostash updates projects with
+// uncommitted changes and restores them, and that changes conflicting with
+// the update are kept in the stash.
+func TestUpdateUniverseAutoStash(t *testing.T) {
+	t.Parallel()
+
+	localProjects, fake := setupUniverse(t)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	fake.X.AutoStash = true
+	p := localProjects[1]
+	scm := gitutil.New(fake.X, gitutil.RootDirOpt(p.Path))
+	stashSize := func() int {
+		size, err := scm.StashSize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return size
+	}
+
+	writeUncommitedFile(t, p.Path, "README", "local change")
+	writeFile(t, fake.X, fake.Projects[p.Name], "other", "remote change")
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(p.Path, "other")); err != nil {
+		t.Errorf("project was not updated: %v", err)
+	}
+	checkReadme(t, p, "local change")
+	if got := stashSize(); got != 0 {
+		t.Errorf("got %d stash entries, want none", got)
+	}
+
+	// The remote change conflicts with the local one.
+	writeReadme(t, fake.X, fake.Projects[p.Name], "remote change")
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	if fake.X.Failures() == 0 {
+		t.Errorf("expected the conflict to be reported")
+	}
+	if got := stashSize(); got != 1 {
+		t.Fatalf("got %d stash entries, want the local change", got)
+	}
+	if out, err := scm.Show("stash@{0}", "README"); err != nil || out != "local change" {
+		t.Errorf("got stashed README %q, %v, want the local change", out, err)
+	}
+
+	// The unrestored stash is not buried under another one.
+	if err := scm.Reset("HEAD"); err != nil {
+		t.Fatal(err)
+	}
+	writeUncommitedFile(t, p.Path, "README", "another change")
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	if got := stashSize(); got != 1 {
+		t.Errorf("got %d stash entries, want 1", got)
+	}
+	checkReadme(t, p, "another change")
+}
+
 // TestUpdateUniverseMovedProject checks that UpdateUniverse can move a
 // project.
 func TestUpdateUniverseMovedProject(t *testing.T) {
@@ -1151,6 +2790,70 @@ func TestUpdateUniverseMovedProjectSelf(t *testing.T) {
 	checkReadme(t, localProjects[1], "initial readme")
 }
 
+// TestUpdateUniverseMovedProjectGitPaths checks that moving a project updates
+// the absolute paths recorded in its repository and keeps its linked
+// worktrees working.
+func TestUpdateUniverseMovedProjectGitPaths(t *testing.T) {
+	t.Parallel()
+
+	localProjects, fake := setupUniverse(t)
+
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	oldProjectPath := localProjects[1].Path
+	runGit := func(dir string, args ...string) string {
+		t.Helper()
+		out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s failed in %s: %v\n%s", strings.Join(args, " "), dir, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	runGit(oldProjectPath, "config", "jiri.test.path", filepath.Join(oldProjectPath, "tools"))
+	outside := filepath.Join(fake.X.Root, "worktree")
+	runGit(oldProjectPath, "worktree", "add", "--detach", outside)
+	inside := filepath.Join(oldProjectPath, "worktree")
+	runGit(oldProjectPath, "worktree", "add", "--detach", inside)
+
+	m, err := fake.ReadRemoteManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	localProjects[1].Path = filepath.Join(fake.X.Root, "new-project-path")
+	projects := []project.Project{}
+	for _, p := range m.Projects {
+		if p.Name == localProjects[1].Name {
+			p.Path = localProjects[1].Path
+		}
+		projects = append(projects, p)
+	}
+	m.Projects = projects
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	checkReadme(t, localProjects[1], "initial readme")
+
+	if got, want := runGit(localProjects[1].Path, "config", "jiri.test.path"), filepath.Join(localProjects[1].Path, "tools"); got != want {
+		t.Errorf("got config %q, want %q", got, want)
+	}
+	worktrees := runGit(localProjects[1].Path, "worktree", "list", "--porcelain")
+	for _, dir := range []string{outside, filepath.Join(localProjects[1].Path, "worktree")} {
+		if !strings.Contains(worktrees, "worktree "+dir+"\n") {
+			t.Errorf("worktree %s is not listed:\n%s", dir, worktrees)
+		}
+		if got, want := runGit(dir, "rev-parse", "--git-common-dir"), filepath.Join(localProjects[1].Path, ".git"); got != want {
+			t.Errorf("got common git dir %q for worktree %s, want %q", got, dir, want)
+		}
+	}
+	if strings.Contains(worktrees, oldProjectPath+"\n") || strings.Contains(worktrees, "prunable") {
+		t.Errorf("unexpected worktrees:\n%s", worktrees)
+	}
+}
+
 // TestUpdateUniverseChangeRemote checks that UpdateUniverse can change remote
 // of a project.
 func TestUpdateUniverseChangeRemote(t *testing.T) {
@@ -1192,6 +2895,89 @@ func TestUpdateUniverseChangeRemote(t *testing.T) {
 	checkReadme(t, localProjects[1], "new commit")
 }
 
+// TestUpdateUniverseChangeRemoteNameAndPath checks that UpdateUniverse both
+// moves a project and changes its remote when both changed.
+func TestUpdateUniverseChangeRemoteNameAndPath(t *testing.T) {
+	t.Parallel()
+
+	localProjects, fake := setupUniverse(t)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	writeReadme(t, fake.X, fake.Projects[localProjects[1].Name], "new commit")
+
+	m, err := fake.ReadRemoteManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldProjectPath := localProjects[1].Path
+	localProjects[1].Path = filepath.Join(fake.X.Root, "new-project-path")
+	for i, p := range m.Projects {
+		if p.Name == localProjects[1].Name {
+			m.Projects[i].Path = localProjects[1].Path
+			m.Projects[i].RemoteName = "upstream"
+		}
+	}
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := dirExists(oldProjectPath); err == nil {
+		t.Errorf("expected project %q at path %q not to exist but it did", localProjects[1].Name, oldProjectPath)
+	}
+	checkReadme(t, localProjects[1], "new commit")
+	scm := gitutil.New(fake.X, gitutil.RootDirOpt(localProjects[1].Path))
+	if got, err := scm.ConfigGetKey("remote.upstream.url"); err != nil || got != localProjects[1].Remote {
+		t.Errorf("remote.upstream.url = %q, %v, want %q", got, err, localProjects[1].Remote)
+	}
+}
+
+// TestUpdateUniverseChangeRemoteRewrite checks that the new remote of a
+// project is rewritten by the URL rewrite rules of the project, like the
+// remote of a clone, and that the project keeps the remote of the manifest.
+func TestUpdateUniverseChangeRemoteRewrite(t *testing.T) {
+	t.Parallel()
+
+	localProjects, fake := setupUniverse(t)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+
+	changedRemoteDir := fake.Projects[localProjects[1].Name] + "-remote-changed"
+	if err := os.Rename(fake.Projects[localProjects[1].Name], changedRemoteDir); err != nil {
+		t.Fatal(err)
+	}
+	writeReadme(t, fake.X, changedRemoteDir, "new commit")
+	fake.X.URLRewrites = []jiri.URLRewrite{{Base: filepath.Dir(changedRemoteDir) + "/", InsteadOf: "mirror://", Project: localProjects[1].Name}}
+
+	m, err := fake.ReadRemoteManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	projects := []project.Project{}
+	for _, p := range m.Projects {
+		if p.Name == localProjects[1].Name {
+			p.Remote = "mirror://" + filepath.Base(changedRemoteDir)
+		}
+		projects = append(projects, p)
+	}
+	m.Projects = projects
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	checkReadme(t, localProjects[1], "new commit")
+	want := "mirror://" + filepath.Base(changedRemoteDir)
+	scm := gitutil.New(fake.X, gitutil.RootDirOpt(localProjects[1].Path))
+	if got, err := scm.ConfigGetKey("remote.origin.url"); err != nil || got != want {
+		t.Errorf("remote.origin.url = %q, %v, want %q", got, err, want)
+	}
+}
+
 func TestIgnoredProjectsNotMoved(t *testing.T) {
 	t.Parallel()
 
@@ -1422,52 +3208,201 @@ func TestUpdateUniverseNewProjectSamePath(t *testing.T) {
 	if err := fake.WriteRemoteManifest(m); err != nil {
 		t.Fatal(err)
 	}
-	// Check that UpdateUniverse() does not fail.
-	if err := fake.UpdateUniverse(true); err != nil {
+	// Check that UpdateUniverse() does not fail.
+	if err := fake.UpdateUniverse(true); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestUpdateUniverseRemoteBranch checks that UpdateUniverse can pull from a
+// non-main remote branch.
+func TestUpdateUniverseRemoteBranch(t *testing.T) {
+	t.Parallel()
+
+	localProjects, fake := setupUniverse(t)
+
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Commit to main branch of a project 1.
+	writeReadme(t, fake.X, fake.Projects[localProjects[1].Name], "main commit")
+	// Create and checkout a new branch of project 1 and make a new commit.
+	git := gitutil.New(fake.X, gitutil.RootDirOpt(fake.Projects[localProjects[1].Name]))
+	if err := git.CreateAndCheckoutBranch("non-main"); err != nil {
+		t.Fatal(err)
+	}
+	writeReadme(t, fake.X, fake.Projects[localProjects[1].Name], "non-main commit")
+	// Point the manifest to the new non-main branch.
+	m, err := fake.ReadRemoteManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	projects := []project.Project{}
+	for _, p := range m.Projects {
+		if p.Name == localProjects[1].Name {
+			p.RemoteBranch = "non-main"
+		}
+		projects = append(projects, p)
+	}
+	m.Projects = projects
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+	// Check that UpdateUniverse pulls the commit from the non-main branch.
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	checkReadme(t, localProjects[1], "non-main commit")
+}
+
+// TestUpdateUniverseRemoteName checks that UpdateUniverse clones projects with
+// a remotename attribute under that remote, and renames the remote of existing
+// checkouts when the attribute changes.
+func TestUpdateUniverseRemoteName(t *testing.T) {
+	t.Parallel()
+
+	localProjects, fake := setupUniverse(t)
+	setRemoteName := func(key project.ProjectKey, name string) {
+		t.Helper()
+		m, err := fake.ReadRemoteManifest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, p := range m.Projects {
+			if p.Key() == key {
+				m.Projects[i].RemoteName = name
+			}
+		}
+		if err := fake.WriteRemoteManifest(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkRemotes := func(p project.Project, want string) {
+		t.Helper()
+		git := gitutil.New(fake.X, gitutil.RootDirOpt(p.Path))
+		if url, err := git.RemoteUrl(want); err != nil || url == "" {
+			t.Errorf("project %s: remote %q not found: %v", p.Name, want, err)
+		}
+		if want != "origin" {
+			if url, _ := git.RemoteUrl("origin"); url != "" {
+				t.Errorf("project %s: unexpected remote origin %q", p.Name, url)
+			}
+		}
+		if ok, err := p.IsOnJiriHead(fake.X); err != nil || !ok {
+			t.Errorf("project %s is not on JIRI_HEAD: %v", p.Name, err)
+		}
+	}
+
+	setRemoteName(localProjects[2].Key(), "upstream")
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	p2 := localProjects[2]
+	p2.RemoteName = "upstream"
+	checkRemotes(p2, "upstream")
+
+	setRemoteName(localProjects[1].Key(), "upstream")
+	writeReadme(t, fake.X, fake.Projects[localProjects[1].Name], "new commit")
+	if err := fake.UpdateUniverse(false); err != nil {
 		t.Fatal(err)
 	}
+	p1 := localProjects[1]
+	p1.RemoteName = "upstream"
+	checkRemotes(p1, "upstream")
+	checkReadme(t, localProjects[1], "new commit")
 }
 
-// TestUpdateUniverseRemoteBranch checks that UpdateUniverse can pull from a
-// non-main remote branch.
-func TestUpdateUniverseRemoteBranch(t *testing.T) {
+// TestUpdateUniverseCopyLinkFiles checks that UpdateUniverse materializes the
+// copyfile and linkfile elements of projects, and removes the files of
+// elements removed from the manifest.
+func TestUpdateUniverseCopyLinkFiles(t *testing.T) {
 	t.Parallel()
 
 	localProjects, fake := setupUniverse(t)
+	writeReadme(t, fake.X, fake.Projects[localProjects[1].Name], "readme")
+	setFiles := func(copyFiles []project.CopyFile, linkFiles []project.LinkFile) {
+		t.Helper()
+		m, err := fake.ReadRemoteManifest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, p := range m.Projects {
+			if p.Name == localProjects[1].Name {
+				m.Projects[i].CopyFiles = copyFiles
+				m.Projects[i].LinkFiles = linkFiles
+			}
+		}
+		if err := fake.WriteRemoteManifest(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	copied := filepath.Join(fake.X.Root, "top", "README.copy")
+	linked := filepath.Join(fake.X.Root, "README.link")
 
+	setFiles([]project.CopyFile{{Src: "README", Dest: "top/README.copy"}}, []project.LinkFile{{Src: "README", Dest: "README.link"}})
 	if err := fake.UpdateUniverse(false); err != nil {
 		t.Fatal(err)
 	}
-
-	// Commit to main branch of a project 1.
-	writeReadme(t, fake.X, fake.Projects[localProjects[1].Name], "main commit")
-	// Create and checkout a new branch of project 1 and make a new commit.
-	git := gitutil.New(fake.X, gitutil.RootDirOpt(fake.Projects[localProjects[1].Name]))
-	if err := git.CreateAndCheckoutBranch("non-main"); err != nil {
-		t.Fatal(err)
+	for _, file := range []string{copied, linked} {
+		if data, err := os.ReadFile(file); err != nil || string(data) != "readme" {
+			t.Errorf("%s: got %q, %v, want %q", file, data, err, "readme")
+		}
 	}
-	writeReadme(t, fake.X, fake.Projects[localProjects[1].Name], "non-main commit")
-	// Point the manifest to the new non-main branch.
-	m, err := fake.ReadRemoteManifest()
+	if target, err := os.Readlink(linked); err != nil || filepath.IsAbs(target) {
+		t.Errorf("%s: got link to %q, %v, want a relative link", linked, target, err)
+	}
+	p, err := project.ProjectAtPath(fake.X, localProjects[1].Path)
 	if err != nil {
 		t.Fatal(err)
 	}
-	projects := []project.Project{}
-	for _, p := range m.Projects {
-		if p.Name == localProjects[1].Name {
-			p.RemoteBranch = "non-main"
+	if len(p.CopyFiles) != 1 || len(p.LinkFiles) != 1 {
+		t.Errorf("metadata got copyfiles %v and linkfiles %v, want one of each", p.CopyFiles, p.LinkFiles)
+	}
+
+	setFiles(nil, nil)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{copied, linked} {
+		if _, err := os.Lstat(file); !os.IsNotExist(err) {
+			t.Errorf("%s was not removed: %v", file, err)
 		}
-		projects = append(projects, p)
 	}
-	m.Projects = projects
-	if err := fake.WriteRemoteManifest(m); err != nil {
+
+	// Files jiri did not create are not replaced.
+	if err := os.WriteFile(copied, []byte("user file"), 0644); err != nil {
 		t.Fatal(err)
 	}
-	// Check that UpdateUniverse pulls the commit from the non-main branch.
+	setFiles([]project.CopyFile{{Src: "README", Dest: "top/README.copy"}}, nil)
+	if err := fake.UpdateUniverse(false); err == nil || !strings.Contains(err.Error(), "not created by jiri") {
+		t.Errorf("expected the copy over a user file to fail, got %v", err)
+	}
+	if data, err := os.ReadFile(copied); err != nil || string(data) != "user file" {
+		t.Errorf("%s: got %q, %v, want the user file", copied, data, err)
+	}
+	setFiles(nil, nil)
 	if err := fake.UpdateUniverse(false); err != nil {
 		t.Fatal(err)
 	}
-	checkReadme(t, localProjects[1], "non-main commit")
+	if _, err := os.Stat(copied); err != nil {
+		t.Errorf("user file %s was removed: %v", copied, err)
+	}
+
+	// Destinations in other projects or in jiri files are rejected.
+	setFiles([]project.CopyFile{{Src: "README", Dest: filepath.Base(localProjects[2].Path) + "/README.copy"}}, nil)
+	if err := fake.UpdateUniverse(false); err == nil || !strings.Contains(err.Error(), "in the checkout of project") {
+		t.Errorf("expected the copy into another project to fail, got %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(localProjects[2].Path, "README.copy")); err == nil {
+		t.Errorf("copyfile wrote %q in the checkout of another project", data)
+	}
+	for _, dest := range []string{".jiri_root/README", ".jiri_manifest"} {
+		manifest := `<manifest><projects><project name="p" path="p" remote="r"><copyfile src="README" dest="` + dest + `"/></project></projects></manifest>`
+		if _, err := project.ManifestFromBytes([]byte(manifest)); err == nil {
+			t.Errorf("expected copyfile to %s to be rejected", dest)
+		}
+	}
 }
 
 // TestUpdateWhenRemoteChangesRebased checks that UpdateUniverse can pull from a
@@ -1583,6 +3518,74 @@ func TestUpdateWhenConflictMerge(t *testing.T) {
 	checkJiriRevFiles(t, localProjects[1])
 }
 
+// TestUpdateWhenRemoteForcePushed checks that branches based on the previous
+// revision of a force-pushed upstream are left alone with a warning, or
+// reset onto the new upstream with a backup ref if ResetOnForcePush is set.
+func TestUpdateWhenRemoteForcePushed(t *testing.T) {
+	t.Parallel()
+
+	localProjects, fake := setupUniverse(t)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	remoteDir := fake.Projects[localProjects[1].Name]
+	writeReadme(t, fake.X, remoteDir, "upstream commit")
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+
+	gitLocal := gitutil.New(fake.X, gitutil.UserNameOpt("John Doe"), gitutil.UserEmailOpt("john.doe@example.com"), gitutil.RootDirOpt(localProjects[1].Path))
+	if err := gitLocal.Checkout("main"); err != nil {
+		t.Fatal(err)
+	}
+	writeReadme(t, fake.X, localProjects[1].Path, "local commit")
+	localRev, err := gitLocal.CurrentRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Force-push a rewritten main.
+	gitRemote := gitutil.New(fake.X, gitutil.UserNameOpt("John Doe"), gitutil.UserEmailOpt("john.doe@example.com"), gitutil.RootDirOpt(remoteDir))
+	if err := gitRemote.Reset("HEAD~1"); err != nil {
+		t.Fatal(err)
+	}
+	writeReadme(t, fake.X, remoteDir, "rewritten commit")
+	remoteRev, err := gitRemote.CurrentRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failures := fake.X.Failures()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	if fake.X.Failures() == failures {
+		t.Errorf("update should report the force-pushed upstream as a failure")
+	}
+	if got, err := gitLocal.CurrentRevisionForRef("main"); err != nil {
+		t.Fatal(err)
+	} else if got != localRev {
+		t.Errorf("branch main moved to %s, want it left at %s", got, localRev)
+	}
+
+	fake.X.ResetOnForcePush = true
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := gitLocal.CurrentRevisionForRef("main"); err != nil {
+		t.Fatal(err)
+	} else if got != remoteRev {
+		t.Errorf("branch main is at %s, want it reset to %s", got, remoteRev)
+	}
+	out, err := exec.Command("git", "-C", localProjects[1].Path, "for-each-ref", "--format=%(objectname)", "refs/jiri/backups/main").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(out)); got != localRev {
+		t.Errorf("backup ref points to %q, want %s", got, localRev)
+	}
+}
+
 func TestTagNotContainedInBranch(t *testing.T) {
 	t.Parallel()
 
@@ -1640,6 +3643,65 @@ func TestTagNotContainedInBranch(t *testing.T) {
 	}
 }
 
+// TestAnnotatedTagRevision tests that projects pinned to an annotated tag are
+// checked out at the tagged commit and stay there as the branch moves on.
+func TestAnnotatedTagRevision(t *testing.T) {
+	t.Parallel()
+
+	localProjects, fake := setupUniverse(t)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+
+	remoteDir := fake.Projects[localProjects[1].Name]
+	gitRemote := gitutil.New(fake.X, gitutil.UserNameOpt("John Doe"), gitutil.UserEmailOpt("john.doe@example.com"), gitutil.RootDirOpt(remoteDir))
+	writeReadme(t, fake.X, remoteDir, "tagged commit")
+	taggedRev, err := gitRemote.CurrentRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gitRemote.CreateAnnotatedTag("v1", "release v1"); err != nil {
+		t.Fatal(err)
+	}
+	writeReadme(t, fake.X, remoteDir, "after tag")
+
+	m, err := fake.ReadRemoteManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range m.Projects {
+		if p.Name == localProjects[1].Name {
+			m.Projects[i].Revision = "refs/tags/v1"
+		}
+	}
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := fake.UpdateUniverse(false); err != nil {
+			t.Fatal(err)
+		}
+		gitLocal := gitutil.New(fake.X, gitutil.RootDirOpt(localProjects[1].Path))
+		localRev, err := gitLocal.CurrentRevision()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if localRev != taggedRev {
+			t.Fatalf("update %d: current commit is %v, it should be %v", i, localRev, taggedRev)
+		}
+		pinned := localProjects[1]
+		pinned.Revision = "refs/tags/v1"
+		onHead, err := pinned.IsOnJiriHead(fake.X)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !onHead {
+			t.Errorf("update %d: project should be on JIRI_HEAD", i)
+		}
+	}
+}
+
 // TestCheckoutSnapshotUrl tests checking out snapshot functionality from a url
 func TestCheckoutSnapshotUrl(t *testing.T) {
 	t.Parallel()
@@ -2104,6 +4166,63 @@ func TestManifestToFromBytes(t *testing.T) {
     <hook name="testhook" action="action.sh" project="project1"/>
   </hooks>
 </manifest>
+`,
+		},
+		{
+			project.Manifest{
+				Groups: []project.Group{
+					{
+						Name:     "build",
+						Projects: []project.GroupMember{{Name: "project1"}},
+						Packages: []project.GroupMember{{Name: "pkg1"}},
+					},
+					{Name: "empty"},
+				},
+			},
+			`<manifest>
+  <groups>
+    <group name="build">
+      <project name="project1"/>
+      <package name="pkg1"/>
+    </group>
+    <group name="empty"/>
+  </groups>
+</manifest>
+`,
+		},
+		{
+			project.Manifest{
+				Projects: []project.Project{
+					{
+						Name:         "project1",
+						Path:         "path1",
+						Remote:       "remote1",
+						RemoteBranch: "main",
+						Revision:     "HEAD",
+						CopyFiles:    []project.CopyFile{{Src: "BUILD.root", Dest: "BUILD"}},
+						LinkFiles:    []project.LinkFile{{Src: "tools", Dest: "tools"}},
+					},
+				},
+			},
+			`<manifest>
+  <projects>
+    <project name="project1" path="path1" remote="remote1">
+      <copyfile src="BUILD.root" dest="BUILD"/>
+      <linkfile src="tools" dest="tools"/>
+    </project>
+  </projects>
+</manifest>
+`,
+		},
+		{
+			project.Manifest{
+				Envs: []project.Env{{Name: "FOO", Value: "{{.Root}}/foo"}},
+			},
+			`<manifest>
+  <envs>
+    <env name="FOO" value="{{.Root}}/foo"/>
+  </envs>
+</manifest>
 `,
 		},
 	}
@@ -2303,6 +4422,65 @@ func TestMarshalAndUnmarshalLockEntries(t *testing.T) {
 
 }
 
+func TestMarshalLockFileVersions(t *testing.T) {
+	t.Parallel()
+
+	projectLock := project.ProjectLock{Remote: "https://fuchsia.googlesource.com/fuchsia", Name: "fuchsia", Revision: "abcdef123456"}
+	pkgLock := project.PackageLock{
+		PackageName: "fuchsia/go/mac-amd64",
+		VersionTag:  "git_revision:b8bd7d94a2ae6c80ab8b6ed5900d3eeba8a777c3",
+		InstanceID:  "3c33b55c1a75b900536c91181805bb8668857341",
+	}
+	hookLock := project.HookLock{Name: "setup", ProjectName: "fuchsia", Action: "scripts/setup.sh", Digest: "0123abcd"}
+	manifestLock := project.ManifestLock{Path: "integration/fuchsia/minimal", Digest: "4567ef01"}
+	projectLocks := project.ProjectLocks{projectLock.Key(): projectLock}
+	pkgLocks := project.PackageLocks{pkgLock.Key(): pkgLock}
+	digests := project.LockDigests{
+		Hooks:     project.HookLocks{hookLock.Key(): hookLock},
+		Manifests: project.ManifestLocks{manifestLock.Path: manifestLock},
+	}
+	emptyDigests := project.LockDigests{Hooks: project.HookLocks{}, Manifests: project.ManifestLocks{}}
+
+	for _, test := range []struct {
+		version string
+		want    project.LockDigests
+	}{
+		// Older versions drop the digests.
+		{"0.0", emptyDigests},
+		{"1.0", emptyDigests},
+		{"2.0", digests},
+	} {
+		data, err := project.MarshalLockFile(projectLocks, pkgLocks, digests, test.version)
+		if err != nil {
+			t.Fatalf("%s: %v", test.version, err)
+		}
+		gotProjectLocks, gotPkgLocks, gotVersion, err := project.UnmarshalLockEntries(data)
+		if err != nil {
+			t.Fatalf("%s: %v", test.version, err)
+		}
+		if gotVersion != test.version {
+			t.Errorf("got version %q, want %q", gotVersion, test.version)
+		}
+		if !reflect.DeepEqual(gotProjectLocks, projectLocks) || !reflect.DeepEqual(gotPkgLocks, pkgLocks) {
+			t.Errorf("%s: got locks %v, %v, want %v, %v", test.version, gotProjectLocks, gotPkgLocks, projectLocks, pkgLocks)
+		}
+		gotDigests, err := project.UnmarshalLockDigests(data)
+		if err != nil {
+			t.Fatalf("%s: %v", test.version, err)
+		}
+		if !reflect.DeepEqual(gotDigests, test.want) {
+			t.Errorf("%s: got digests %+v, want %+v", test.version, gotDigests, test.want)
+		}
+		// Marshalling again what was read gives the same lockfile.
+		again, err := project.MarshalLockFile(gotProjectLocks, gotPkgLocks, gotDigests, gotVersion)
+		if err != nil {
+			t.Fatalf("%s: %v", test.version, err)
+		}
+		if string(again) != string(data) {
+			t.Errorf("%s: round trip changed the lockfile from:\n%s\nto:\n%s", test.version, data, again)
+		}
+	}
+}
 func TestGetPath(t *testing.T) {
 	t.Parallel()
 
@@ -2363,7 +4541,7 @@ func TestWritePackageFlags(t *testing.T) {
 		testPkgs[v.Key()] = v
 	}
 
-	if err := project.WritePackageFlags(jirix, testPkgs, testPkgsWA); err != nil {
+	if _, err := project.WritePackageFlags(jirix, testPkgs, testPkgsWA, project.FlagsWrite); err != nil {
 		t.Errorf("WritePackageFlags failed due to error: %v", err)
 	}
 
@@ -2396,7 +4574,7 @@ func TestWriteProjectFlags(t *testing.T) {
 		testProjs[v.Key()] = v
 	}
 
-	if err := project.WriteProjectFlags(jirix, testProjs); err != nil {
+	if _, err := project.WriteProjectFlags(jirix, testProjs, project.FlagsWrite); err != nil {
 		t.Errorf("WritePackageFlags failed due to error: %v", err)
 	}
 
@@ -2411,6 +4589,71 @@ func TestWriteProjectFlags(t *testing.T) {
 	}
 }
 
+func TestWriteFlagsModes(t *testing.T) {
+	t.Parallel()
+
+	jirix := xtest.NewX(t)
+	testProjs := make(project.Projects)
+	for _, p := range []project.Project{
+		{Name: "test0", Remote: "test0", Flag: "flagfile0|internal = true|internal = false"},
+		{Name: "test1", Remote: "test1", Flag: "flagfile1|a = true|a = false"},
+		{Name: "test2", Remote: "test2", Flag: "flagfile1|b = true|b = false"},
+	} {
+		testProjs[p.Key()] = p
+	}
+	flagFile := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(jirix.Root, name))
+		if os.IsNotExist(err) {
+			return ""
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	report, err := project.WriteProjectFlags(jirix, testProjs, project.FlagsDryRun)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Writes) != 3 || len(report.Conflicts) != 1 || len(report.Conflicts[0].Writes) != 2 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if got := flagFile("flagfile0"); got != "" {
+		t.Errorf("dry run wrote %q", got)
+	}
+
+	if _, err := project.WriteProjectFlags(jirix, testProjs, project.FlagsWrite); err == nil || !strings.Contains(err.Error(), `project "test1" writes "a = true", project "test2" writes "b = true"`) {
+		t.Errorf("expected a conflict error, got %v", err)
+	}
+	if got := flagFile("flagfile0"); got != "" {
+		t.Errorf("flags were written despite conflicts: %q", got)
+	}
+
+	if _, err := project.WriteProjectFlags(jirix, testProjs, project.FlagsForce); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := flagFile("flagfile0"), "internal = true"; got != want {
+		t.Errorf("got flagfile0 %q, want %q", got, want)
+	}
+	if got, want := flagFile("flagfile1"), "b = true"; got != want {
+		t.Errorf("got flagfile1 %q, want %q", got, want)
+	}
+
+	// A package writing the flag file of a project conflicts with it, even
+	// with the same content.
+	pkgs := make(project.Packages)
+	pkg := project.Package{Name: "pkg", Version: "version", Flag: "flagfile0|internal = true|internal = false"}
+	pkgs[pkg.Key()] = pkg
+	delete(testProjs, project.MakeProjectKey("test2", "test2"))
+	report, err = project.CheckFlags(jirix, testProjs, pkgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Conflicts) != 1 || report.Conflicts[0].File != "flagfile0" {
+		t.Errorf("expected a conflict for flagfile0, got %+v", report.Conflicts)
+	}
+}
+
 func TestOptionalProjectsAndPackages(t *testing.T) {
 	t.Parallel()
 
@@ -2635,10 +4878,91 @@ func TestOverrideProject(t *testing.T) {
 	scm := gitutil.New(fake.X, gitutil.RootDirOpt(localProjects[0].Path))
 	currentHash, err := scm.CurrentRevision()
 	if err != nil {
-		t.Errorf("failed to get current hash due to error: %v", err)
+		t.Errorf("failed to get current hash due to error: %v", err)
+	}
+	if currentHash != testHash {
+		t.Errorf("expected git hash %q, got %q", testHash, currentHash)
+	}
+}
+
+func TestOverrideHookAndPackage(t *testing.T) {
+	t.Parallel()
+
+	jirix := xtest.NewX(t)
+	child := &project.Manifest{
+		Projects: []project.Project{
+			{Name: "p1", Path: "p1", Remote: "https://example.com/p1"},
+		},
+		Hooks: []project.Hook{
+			{Name: "h1", ProjectName: "p1", Action: "hook.sh"},
+		},
+		Packages: []project.Package{
+			{Name: "fuchsia/tools/jiri", Path: "jiri", Version: "version:1"},
+		},
+	}
+	childFile := filepath.Join(jirix.Root, "child")
+	if err := child.ToFile(jirix, childFile); err != nil {
+		t.Fatal(err)
+	}
+	root := &project.Manifest{
+		LocalImports: []project.LocalImport{{File: "child"}},
+		HookOverrides: []project.Hook{
+			{Name: "h1", ProjectName: "p1", Action: "other.sh"},
+		},
+		PackageOverrides: []project.Package{
+			{Name: "fuchsia/tools/jiri", Path: "prebuilt/jiri", Version: "version:2"},
+		},
+	}
+	rootFile := filepath.Join(jirix.Root, "root")
+	if err := root.ToFile(jirix, rootFile); err != nil {
+		t.Fatal(err)
+	}
+	_, hooks, pkgs, err := project.LoadManifestFile(jirix, rootFile, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hook, ok := hooks[project.MakeHookKey("h1", "p1")]
+	if !ok || hook.Action != "other.sh" {
+		t.Errorf("expecting hook h1 to run other.sh, got %+v", hooks)
+	}
+	if len(pkgs) != 1 {
+		t.Fatalf("expecting 1 package, got %+v", pkgs)
+	}
+	for _, pkg := range pkgs {
+		if pkg.Version != "version:2" || pkg.Path != "prebuilt/jiri" {
+			t.Errorf("expecting overridden package version and path, got %+v", pkg)
+		}
+	}
+
+	// Overriding the same package twice is an error.
+	root.PackageOverrides = append(root.PackageOverrides, root.PackageOverrides[0])
+	if err := root.ToFile(jirix, rootFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := project.LoadManifestFile(jirix, rootFile, nil, nil); err == nil || !strings.Contains(err.Error(), "duplicate override") {
+		t.Errorf("expecting duplicate override error, got %v", err)
+	}
+
+	// A hook override may keep the action of the hook.
+	root.PackageOverrides = nil
+	root.HookOverrides = []project.Hook{{Name: "h1", ProjectName: "p1", Timeout: "5m"}}
+	if err := root.ToFile(jirix, rootFile); err != nil {
+		t.Fatal(err)
+	}
+	_, hooks, _, err = project.LoadManifestFile(jirix, rootFile, nil, nil)
+	if err != nil {
+		t.Fatal(err)
 	}
-	if currentHash != testHash {
-		t.Errorf("expected git hash %q, got %q", testHash, currentHash)
+	if hook := hooks[project.MakeHookKey("h1", "p1")]; hook.Action != "hook.sh" || hook.Timeout != "5m" {
+		t.Errorf("expecting hook h1 to run hook.sh with a timeout of 5m, got %+v", hook)
+	}
+	// But it must override something.
+	root.HookOverrides = []project.Hook{{Name: "h1", ProjectName: "p1"}}
+	if err := root.ToFile(jirix, rootFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := project.LoadManifestFile(jirix, rootFile, nil, nil); err == nil || !strings.Contains(err.Error(), "bad override of hook") {
+		t.Errorf("expecting an error for an empty hook override, got %v", err)
 	}
 }
 
@@ -2734,6 +5058,310 @@ func TestCheckProjectsHostnames(t *testing.T) {
 	}
 }
 
+func TestCheckPackagesAllowed(t *testing.T) {
+	t.Parallel()
+
+	allowList := []project.PackageAllow{
+		{Prefix: "fuchsia/tools/"},
+		{Prefix: "fuchsia/tools/jiri", Signers: "user:builder@example.com, user:release@example.com"},
+		{Prefix: "infra/3pp/git"},
+	}
+	pkgs := make(project.Packages)
+	for _, pkg := range []project.Package{
+		{Name: "fuchsia/tools/cipd/${platform}", Path: "cipd"},
+		{Name: "fuchsia/tools/jiri/${platform}", Path: "jiri"},
+		{Name: "infra/3pp/git", Path: "git"},
+	} {
+		pkgs[pkg.Key()] = pkg
+	}
+	if err := project.CheckPackagesAllowed(pkgs, allowList); err != nil {
+		t.Fatalf("expecting nil from CheckPackagesAllowed, but got: %v", err)
+	}
+	for _, pkg := range pkgs {
+		var want []string
+		if pkg.Path == "jiri" {
+			want = []string{"user:builder@example.com", "user:release@example.com"}
+		}
+		if !reflect.DeepEqual(pkg.Signers, want) {
+			t.Errorf("package %s: expecting signers %v, got %v", pkg.Name, want, pkg.Signers)
+		}
+	}
+
+	for _, name := range []string{"fuchsia/toolsx/jiri", "infra/3pp/git-lfs", "other/pkg"} {
+		pkg := project.Package{Name: name, Path: "path"}
+		pkgs := project.Packages{pkg.Key(): pkg}
+		if err := project.CheckPackagesAllowed(pkgs, allowList); err == nil {
+			t.Errorf("expecting error from CheckPackagesAllowed for %q, but got nil", name)
+		}
+	}
+
+	pkg := project.Package{Name: "other/pkg", Path: "path"}
+	if err := project.CheckPackagesAllowed(project.Packages{pkg.Key(): pkg}, nil); err != nil {
+		t.Errorf("expecting nil from CheckPackagesAllowed without allow list, but got: %v", err)
+	}
+}
+
+func TestLoadManifestFilePackageAllowList(t *testing.T) {
+	t.Parallel()
+
+	jirix := xtest.NewX(t)
+	manifest := &project.Manifest{
+		Packages: []project.Package{
+			{Name: "fuchsia/tools/jiri", Path: "jiri", Version: "latest"},
+			{Name: "other/pkg", Path: "other", Version: "latest"},
+		},
+		PackageAllowList: []project.PackageAllow{
+			{Prefix: "fuchsia/tools"},
+		},
+	}
+	file := filepath.Join(jirix.Root, "manifest")
+	if err := manifest.ToFile(jirix, file); err != nil {
+		t.Fatal(err)
+	}
+	_, _, _, err := project.LoadManifestFile(jirix, file, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "other/pkg") {
+		t.Fatalf("expecting package allow list error for other/pkg, got %v", err)
+	}
+
+	manifest.PackageAllowList = append(manifest.PackageAllowList, project.PackageAllow{Prefix: "other/pkg"})
+	if err := manifest.ToFile(jirix, file); err != nil {
+		t.Fatal(err)
+	}
+	_, _, pkgs, err := project.LoadManifestFile(jirix, file, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkgs) != 2 {
+		t.Errorf("expecting 2 packages, got %v", pkgs)
+	}
+}
+
+// TestLoadManifestImportedPackageAllowList checks that an imported manifest
+// cannot widen the package allow list of the root manifest.
+func TestLoadManifestImportedPackageAllowList(t *testing.T) {
+	t.Parallel()
+
+	fake := jiritest.NewFakeJiriRoot(t)
+	jiriManifest, err := fake.ReadJiriManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	jiriManifest.PackageAllowList = []project.PackageAllow{{Prefix: "fuchsia/tools"}}
+	if err := fake.WriteJiriManifest(jiriManifest); err != nil {
+		t.Fatal(err)
+	}
+	m, err := fake.ReadRemoteManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Packages = []project.Package{
+		{Name: "fuchsia/tools/jiri", Path: "jiri", Version: "latest"},
+		{Name: "other/pkg", Path: "other", Version: "latest"},
+	}
+	m.PackageAllowList = []project.PackageAllow{{Prefix: "other/pkg"}}
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+	_, _, _, err = project.LoadUpdatedManifest(fake.X, project.Projects{}, nil)
+	if err == nil || !strings.Contains(err.Error(), "other/pkg") {
+		t.Fatalf("expecting package allow list error for other/pkg, got %v", err)
+	}
+}
+
+func TestLoadManifestFileGroups(t *testing.T) {
+	t.Parallel()
+
+	jirix := xtest.NewX(t)
+	manifest := &project.Manifest{
+		Projects: []project.Project{
+			{Name: "a", Path: "a", Remote: "https://example.com/a"},
+			{Name: "b", Path: "b", Remote: "https://example.com/b"},
+			{Name: "c", Path: "c", Remote: "https://example.com/c"},
+		},
+		Packages: []project.Package{
+			{Name: "pkg/x", Path: "x", Version: "latest"},
+			{Name: "pkg/y", Path: "y", Version: "latest"},
+		},
+		Groups: []project.Group{
+			{
+				Name:     "build",
+				Projects: []project.GroupMember{{Name: "a"}, {Name: "b"}},
+				Packages: []project.GroupMember{{Name: "pkg/x"}},
+			},
+			{
+				Name:     "docs",
+				Projects: []project.GroupMember{{Name: "b"}},
+			},
+		},
+	}
+	file := filepath.Join(jirix.Root, "manifest")
+	if err := manifest.ToFile(jirix, file); err != nil {
+		t.Fatal(err)
+	}
+	projects, _, pkgs, err := project.LoadManifestFile(jirix, file, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups := make(map[string]string)
+	for _, p := range projects {
+		groups[p.Name] = p.Groups
+	}
+	for _, pkg := range pkgs {
+		groups[pkg.Name] = pkg.Groups
+	}
+	want := map[string]string{"a": "build", "b": "build,docs", "c": "", "pkg/x": "build", "pkg/y": ""}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("got groups %v, want %v", groups, want)
+	}
+
+	names := func() []string {
+		var names []string
+		for _, p := range projects {
+			names = append(names, p.Name)
+		}
+		for _, pkg := range pkgs {
+			names = append(names, pkg.Name)
+		}
+		sort.Strings(names)
+		return names
+	}
+	jirix.ExcludedGroups = "docs"
+	project.FilterProjectsPackagesByGroup(jirix, projects, pkgs)
+	if got, want := names(), []string{"a", "c", "pkg/x", "pkg/y"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("-exclude-group=docs: got %v, want %v", got, want)
+	}
+
+	projects, _, pkgs, err = project.LoadManifestFile(jirix, file, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jirix.Groups, jirix.ExcludedGroups = "docs", ""
+	project.FilterProjectsPackagesByGroup(jirix, projects, pkgs)
+	if got, want := names(), []string{"b", "c", "pkg/y"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("-group=docs: got %v, want %v", got, want)
+	}
+}
+
+func TestLoadManifestEnvs(t *testing.T) {
+	t.Parallel()
+
+	jirix := xtest.NewX(t)
+	imported := &project.Manifest{
+		Envs: []project.Env{
+			{Name: "FOO", Value: "imported"},
+			{Name: "BAR", Value: `{{package "pkg/bar"}}/bin`},
+		},
+		Packages: []project.Package{
+			{Name: "pkg/bar", Path: "prebuilt/bar", Version: "latest"},
+		},
+	}
+	if err := imported.ToFile(jirix, filepath.Join(jirix.Root, "imported")); err != nil {
+		t.Fatal(err)
+	}
+	manifest := &project.Manifest{
+		LocalImports: []project.LocalImport{{File: "imported"}},
+		Envs: []project.Env{
+			{Name: "FOO", Value: "{{.Root}}/foo"},
+			{Name: "PLATFORM", Value: "{{.OS}}-{{.Arch}}"},
+		},
+	}
+	file := filepath.Join(jirix.Root, "manifest")
+	if err := manifest.ToFile(jirix, file); err != nil {
+		t.Fatal(err)
+	}
+	envs, pkgs, err := project.LoadManifestEnvs(jirix, file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plat := cipd.FuchsiaPlatform(cipd.CurrentPlatform)
+	want := map[string]string{
+		"FOO":      filepath.Join(jirix.Root, "foo"),
+		"BAR":      filepath.Join(jirix.Root, "prebuilt", "bar") + "/bin",
+		"PLATFORM": plat.OS + "-" + plat.Arch,
+	}
+	got := make(map[string]string)
+	for name, env := range envs {
+		if got[name], err = env.Expand(jirix, pkgs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got envs %v, want %v", got, want)
+	}
+
+	env := project.Env{Name: "MISSING", Value: `{{package "pkg/missing"}}`}
+	if _, err := env.Expand(jirix, pkgs); err == nil {
+		t.Errorf("expanding an env referring to a missing package should fail")
+	}
+	if _, err := project.ManifestFromBytes([]byte(`<manifest><envs><env name="1BAD" value="x"/></envs></manifest>`)); err == nil {
+		t.Errorf("an env with an invalid name should be rejected")
+	}
+}
+
+func TestLoadManifestCIPDClient(t *testing.T) {
+	t.Parallel()
+
+	jirix := xtest.NewX(t)
+	digest := strings.Repeat("a", 64)
+	imported := &project.Manifest{
+		CIPDClient: &project.CIPDClient{
+			Version: "git_revision:imported",
+			Digests: []project.CIPDClientDigest{{Platform: "linux-amd64", SHA256: digest}},
+		},
+	}
+	if err := imported.ToFile(jirix, filepath.Join(jirix.Root, "imported")); err != nil {
+		t.Fatal(err)
+	}
+	manifest := &project.Manifest{
+		LocalImports: []project.LocalImport{{File: "imported"}},
+		CIPDClient: &project.CIPDClient{
+			Version: "git_revision:root",
+			Digests: []project.CIPDClientDigest{
+				{Platform: "mac-arm64", SHA256: digest},
+				{Platform: "linux-amd64", SHA256: digest},
+			},
+		},
+	}
+	file := filepath.Join(jirix.Root, "manifest")
+	if err := manifest.ToFile(jirix, file); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := project.LoadManifestFile(jirix, file, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	// The importing manifest overrides the pin of the imported one.
+	want := &jiri.CIPDClientPin{
+		Version: "git_revision:root",
+		Digests: map[string]string{"linux-amd64": digest, "mac-arm64": digest},
+	}
+	if !reflect.DeepEqual(jirix.CIPDClient, want) {
+		t.Errorf("got cipd client pin %+v, want %+v", jirix.CIPDClient, want)
+	}
+
+	snapshot := filepath.Join(jirix.Root, "snapshot")
+	if err := project.CreateSnapshot(jirix, snapshot, project.Hooks{}, project.Packages{}, false, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	m, err := project.ManifestFromFile(jirix, snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.CIPDClient == nil || m.CIPDClient.Version != "git_revision:root" || len(m.CIPDClient.Digests) != 2 {
+		t.Errorf("snapshot does not pin the cipd client: %+v", m.CIPDClient)
+	}
+
+	for _, bad := range []string{
+		`<manifest><cipd_client version="v"/></manifest>`,
+		`<manifest><cipd_client><digest platform="linux-amd64" sha256="` + digest + `"/></cipd_client></manifest>`,
+		`<manifest><cipd_client version="v"><digest platform="linux" sha256="` + digest + `"/></cipd_client></manifest>`,
+		`<manifest><cipd_client version="v"><digest platform="linux-amd64" sha256="abc"/></cipd_client></manifest>`,
+	} {
+		if _, err := project.ManifestFromBytes([]byte(bad)); err == nil {
+			t.Errorf("manifest %s should be rejected", bad)
+		}
+	}
+}
+
 func TestPrefixTree(t *testing.T) {
 	t.Parallel()
 
@@ -2866,3 +5494,204 @@ func TestUpdateUniverseWithInvalidLocalManifests(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+// TestFetchCheckpoint tests that retrying a failed update with a fetch
+// checkpoint only fetches the projects which failed to fetch.
+func TestFetchCheckpoint(t *testing.T) {
+	t.Parallel()
+
+	localProjects, fake := setupUniverse(t)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	params := project.UpdateUniverseParams{
+		FetchCheckpoint:      true,
+		RunHookTimeout:       project.DefaultHookTimeout,
+		FetchPackagesTimeout: project.DefaultPackageTimeout,
+	}
+	checkpoint := filepath.Join(fake.X.RootMetaDir(), "fetch_checkpoint.json")
+
+	// Make the fetch of project 1 fail.
+	remote1 := fake.Projects[localProjects[1].Name]
+	if err := os.Rename(remote1, remote1+".away"); err != nil {
+		t.Fatal(err)
+	}
+	if err := project.UpdateUniverse(fake.X, params); err == nil {
+		t.Fatalf("expected the update to fail")
+	}
+	data, err := os.ReadFile(checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), localProjects[0].Name+project.KeySeparator) || strings.Contains(string(data), localProjects[1].Name+project.KeySeparator) {
+		t.Fatalf("wrong fetch checkpoint:\n%s", data)
+	}
+
+	// The retry fetches project 1, but not project 0 which was fetched.
+	if err := os.Rename(remote1+".away", remote1); err != nil {
+		t.Fatal(err)
+	}
+	writeReadme(t, fake.X, fake.Projects[localProjects[0].Name], "not fetched")
+	writeReadme(t, fake.X, remote1, "fetched")
+	if err := project.UpdateUniverse(fake.X, params); err != nil {
+		t.Fatal(err)
+	}
+	checkReadme(t, localProjects[0], "initial readme")
+	checkReadme(t, localProjects[1], "fetched")
+	if _, err := os.Stat(checkpoint); !os.IsNotExist(err) {
+		t.Fatalf("the fetch checkpoint should be removed once the update succeeds: %v", err)
+	}
+
+	if err := project.UpdateUniverse(fake.X, params); err != nil {
+		t.Fatal(err)
+	}
+	checkReadme(t, localProjects[0], "not fetched")
+}
+
+// TestProjectGitConfig tests that the git configs of the gitconfig attribute
+// of a project are set in its local config, and that the working tree status
+// is reported with them.
+func TestProjectGitConfig(t *testing.T) {
+	t.Parallel()
+
+	localProjects, fake := setupUniverse(t)
+	p := localProjects[1]
+	m, err := fake.ReadRemoteManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range m.Projects {
+		if m.Projects[i].Name == p.Name {
+			m.Projects[i].GitConfig = "core.untrackedCache=true, core.fsmonitor=true"
+		}
+	}
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	scm := gitutil.New(fake.X, gitutil.RootDirOpt(p.Path))
+	if got, err := scm.ConfigGetKey("core.untrackedCache"); err != nil || got != "true" {
+		t.Errorf("got core.untrackedCache %q, %v, want \"true\"", got, err)
+	}
+	got, _ := scm.ConfigGetKey("core.fsmonitor")
+	if want := scm.SupportsBuiltinFSMonitor(); (got == "true") != want {
+		t.Errorf("got core.fsmonitor %q, want it set: %v", got, want)
+	}
+	if got, err := gitutil.New(fake.X, gitutil.RootDirOpt(localProjects[2].Path)).ConfigGetKey("core.untrackedCache"); err == nil {
+		t.Errorf("got core.untrackedCache %q for a project without gitconfig", got)
+	}
+
+	writeUncommitedFile(t, p.Path, "README", "changed")
+	staged := writeUncommitedFile(t, p.Path, "staged", "staged")
+	if err := scm.Add(staged); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(p.Path, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeUncommitedFile(t, filepath.Join(p.Path, "dir"), "untracked file", "untracked")
+	status, err := scm.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(status.Changed)
+	if want := []string{"README", "staged"}; !reflect.DeepEqual(status.Changed, want) {
+		t.Errorf("got changed files %q, want %q", status.Changed, want)
+	}
+	if want := []string{"dir/untracked file"}; !reflect.DeepEqual(status.Untracked, want) {
+		t.Errorf("got untracked files %q, want %q", status.Untracked, want)
+	}
+
+	// Configs dropped from the manifest are unset.
+	for i := range m.Projects {
+		if m.Projects[i].Name == p.Name {
+			m.Projects[i].GitConfig = "core.fsmonitor=false"
+		}
+	}
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := scm.ConfigGetKey("core.untrackedCache"); err == nil {
+		t.Errorf("got core.untrackedCache %q after it was dropped from the manifest", got)
+	}
+	if got, err := scm.ConfigGetKey("core.fsmonitor"); err != nil || got != "false" {
+		t.Errorf("got core.fsmonitor %q, %v, want \"false\"", got, err)
+	}
+
+	for _, config := range []string{"untrackedCache", "core.sshCommand=touch /tmp/pwned", "core.fsmonitor=.git/fsmonitor", "alias.st=!sh"} {
+		for i := range m.Projects {
+			if m.Projects[i].Name == p.Name {
+				m.Projects[i].GitConfig = config
+			}
+		}
+		if err := fake.WriteRemoteManifest(m); err == nil || !strings.Contains(err.Error(), "gitconfig") {
+			t.Errorf("expected an error for gitconfig %q, got %v", config, err)
+		}
+	}
+}
+
+// TestProvenance tests that updates record the provenance of projects.
+func TestProvenance(t *testing.T) {
+	t.Parallel()
+
+	localProjects, fake := setupUniverse(t)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	p := localProjects[1]
+	pinned, err := gitutil.New(fake.X, gitutil.RootDirOpt(fake.Projects[p.Name])).CurrentRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeReadme(t, fake.X, fake.Projects[p.Name], "not checked out")
+	m, err := fake.ReadRemoteManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range m.Projects {
+		if m.Projects[i].Name == p.Name {
+			m.Projects[i].Revision = pinned
+		}
+	}
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now().Add(-time.Second)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+
+	prov, err := project.ReadProvenance(fake.X, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	relPath, err := filepath.Rel(fake.X.Root, p.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prov.Name != p.Name || prov.Path != filepath.ToSlash(relPath) || prov.Remote != p.Remote {
+		t.Errorf("wrong project in provenance %+v", prov)
+	}
+	if prov.PinnedRevision != pinned || prov.Revision != pinned {
+		t.Errorf("got pinned revision %q and revision %q, want %q", prov.PinnedRevision, prov.Revision, pinned)
+	}
+	if prov.Manifest == "" || filepath.IsAbs(prov.Manifest) {
+		t.Errorf("got manifest %q, want a path relative to the root", prov.Manifest)
+	}
+	if prov.FetchTime.Before(start) {
+		t.Errorf("got fetch time %s, want after %s", prov.FetchTime, start)
+	}
+
+	prov, err = project.ReadProvenance(fake.X, localProjects[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prov.PinnedRevision != "" || prov.RemoteBranch != "main" {
+		t.Errorf("got pinned revision %q and remote branch %q for an unpinned project", prov.PinnedRevision, prov.RemoteBranch)
+	}
+}
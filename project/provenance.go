@@ -0,0 +1,116 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/gitutil"
+)
+
+// Provenance records why a project is checked out in the root, for tools
+// which need to know where a repository comes from without loading the
+// manifest. "jiri update" writes it in the metadata directory of each
+// project.
+type Provenance struct {
+	Name string `json:"name"`
+	// Path is the path of the project relative to the root, with slashes.
+	Path string `json:"path"`
+	// Manifest is the manifest file declaring the project, relative to the
+	// root if it is inside it.
+	Manifest string `json:"manifest"`
+	// ImportChain holds the names of the imports pulling in the project,
+	// innermost first.
+	ImportChain  []string `json:"import_chain,omitempty"`
+	Remote       string   `json:"remote"`
+	RemoteBranch string   `json:"remote_branch,omitempty"`
+	// PinnedRevision is the revision the manifest pins the project to, if
+	// any.
+	PinnedRevision string `json:"pinned_revision,omitempty"`
+	// Revision is the revision jiri checked out, JIRI_HEAD.
+	Revision string `json:"revision"`
+	// FetchTime is when the project was last fetched.
+	FetchTime time.Time `json:"fetch_time"`
+	// Cache is the cache the project was fetched from, if any.
+	Cache string `json:"cache,omitempty"`
+}
+
+// ProvenanceFile returns the path of the provenance file of p.
+func (p *Project) ProvenanceFile(jirix *jiri.X) (string, error) {
+	gitDir, err := p.AbsoluteGitDir(jirix)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(gitDir, jiri.ProjectMetaDir, jiri.ProjectProvenanceFile), nil
+}
+
+// ReadProvenance returns the provenance of p recorded by the last update.
+func ReadProvenance(jirix *jiri.X, p Project) (*Provenance, error) {
+	file, err := p.ProvenanceFile(jirix)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmtError(err)
+	}
+	prov := &Provenance{}
+	if err := json.Unmarshal(data, prov); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", file, err)
+	}
+	return prov, nil
+}
+
+// writeProvenance writes the provenance of p, which must be checked out at
+// JIRI_HEAD. pinnedRevision is the revision the manifest pins p to, if any.
+func (p *Project) writeProvenance(jirix *jiri.X, pinnedRevision string) error {
+	file, err := p.ProvenanceFile(jirix)
+	if err != nil {
+		return err
+	}
+	scm := newGit(jirix, gitutil.RootDirOpt(p.Path))
+	revision, err := scm.CurrentRevisionForRef("JIRI_HEAD")
+	if err != nil {
+		return fmt.Errorf("Cannot find revision of JIRI_HEAD for project %s(%s): %s", p.Name, p.Path, err)
+	}
+	prov := Provenance{
+		Name:           p.Name,
+		Path:           relativeToRoot(jirix, p.Path),
+		Manifest:       relativeToRoot(jirix, p.ManifestPath),
+		ImportChain:    p.ImportChain,
+		Remote:         p.Remote,
+		RemoteBranch:   p.RemoteBranch,
+		PinnedRevision: pinnedRevision,
+		Revision:       revision,
+	}
+	if cache, err := p.CacheDirPath(jirix); err == nil && cache != "" {
+		if _, err := os.Stat(cache); err == nil {
+			prov.Cache = cache
+		}
+	}
+	// Git touches FETCH_HEAD on each fetch, but clones do not write it. Fall
+	// back to the time recorded by the previous update, or to now for
+	// projects which were just cloned.
+	if fi, err := os.Stat(filepath.Join(filepath.Dir(filepath.Dir(file)), "FETCH_HEAD")); err == nil {
+		prov.FetchTime = fi.ModTime().UTC()
+	} else if last, err := ReadProvenance(jirix, *p); err == nil && !last.FetchTime.IsZero() {
+		prov.FetchTime = last.FetchTime
+	} else {
+		prov.FetchTime = time.Now().UTC()
+	}
+	data, err := json.MarshalIndent(prov, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return fmtError(err)
+	}
+	return SafeWriteFile(jirix, file, append(data, '\n'))
+}
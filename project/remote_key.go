@@ -0,0 +1,118 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// RemoteNormalizer canonicalizes the remotes of projects in their
+// ProjectKey, so that the different spellings of the remote of a
+// repository, e.g. its sso:// and https:// remotes, are the same project.
+type RemoteNormalizer interface {
+	NormalizeRemote(remote string) string
+}
+
+// RemoteNormalizerFunc adapts a function to a RemoteNormalizer.
+type RemoteNormalizerFunc func(remote string) string
+
+func (f RemoteNormalizerFunc) NormalizeRemote(remote string) string {
+	return f(remote)
+}
+
+var (
+	remoteNormalizerMu sync.RWMutex
+	remoteNormalizer   RemoteNormalizer = RemoteNormalizerFunc(CanonicalRemote)
+)
+
+// SetRemoteNormalizer makes n the normalizer of the remotes of project
+// keys, and returns the previous one. It must be called before any key is
+// made, e.g. by programs embedding jiri with their own hosting conventions.
+func SetRemoteNormalizer(n RemoteNormalizer) RemoteNormalizer {
+	remoteNormalizerMu.Lock()
+	defer remoteNormalizerMu.Unlock()
+	old := remoteNormalizer
+	remoteNormalizer = n
+	return old
+}
+
+func normalizeRemote(remote string) string {
+	remoteNormalizerMu.RLock()
+	defer remoteNormalizerMu.RUnlock()
+	return remoteNormalizer.NormalizeRemote(remote)
+}
+
+// LegacyRemote is the normalization of remotes of older versions of jiri,
+// which only drops the scheme, and the user and port of SSH remotes, once
+// sso:// remotes are rewritten to https://. It is used to recognize the
+// projects and data recorded by those versions.
+func LegacyRemote(remote string) string {
+	if strings.HasPrefix(remote, "sso://") {
+		remote = ssoRe.ReplaceAllString(remote, "https://$1.googlesource.com/")
+	}
+	remote = sshRemoteURL(remote)
+	u, err := url.Parse(remote)
+	if err != nil {
+		// If remote isn't parseable don't try to remove schema
+		return remote
+	}
+	if isSSHScheme(u.Scheme) {
+		return "://" + u.Hostname() + u.Path
+	}
+	return strings.TrimPrefix(remote, u.Scheme)
+}
+
+// CanonicalRemote is the default normalization of remotes. Besides what
+// LegacyRemote does, network remotes are made independent of:
+//   - the case of the host, and the user, password and default port;
+//   - a trailing slash or ".git" suffix;
+//   - the "/a/" prefix of authenticated googlesource.com paths;
+//   - query strings and fragments.
+//
+// So "sso://host/repo", "https://host.googlesource.com/a/repo.git/" and
+// "git@host.googlesource.com:repo" are the same. Remotes that are local
+// paths only lose their trailing slashes, since "repo" and "repo.git" may
+// be different directories.
+func CanonicalRemote(remote string) string {
+	if strings.HasPrefix(remote, "sso://") {
+		remote = ssoRe.ReplaceAllString(remote, "https://$1.googlesource.com/")
+	}
+	if strings.HasPrefix(remote, "://") {
+		// Already normalized, e.g. in a key given as a string.
+		remote = "https" + remote
+	}
+	remote = sshRemoteURL(remote)
+	u, err := url.Parse(remote)
+	if trimmed := strings.TrimRight(remote, "/"); trimmed != "" && (err != nil || u.Host == "") {
+		remote = trimmed
+	}
+	if err != nil {
+		return remote
+	}
+	if u.Host == "" {
+		return strings.TrimPrefix(remote, u.Scheme)
+	}
+	scheme := strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	if port := u.Port(); port != "" && !isSSHScheme(scheme) && port != defaultPorts[scheme] {
+		host += ":" + port
+	}
+	p := strings.TrimRight(u.Path, "/")
+	if scheme != "file" {
+		p = strings.TrimRight(strings.TrimSuffix(p, ".git"), "/")
+		if strings.HasSuffix(host, ".googlesource.com") && strings.HasPrefix(p, "/a/") {
+			p = strings.TrimPrefix(p, "/a")
+		}
+	}
+	return "://" + host + p
+}
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+	"git":   "9418",
+}
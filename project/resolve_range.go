@@ -0,0 +1,154 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package project
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/cipd"
+	"go.fuchsia.dev/jiri/gitutil"
+)
+
+// manifestRangeChanges returns the names of the projects and packages whose
+// elements were added, changed or removed by the commits of diffRange in the
+// repositories containing manifestFiles. diffRange is "<base>..<head>", or
+// "<base>" which stands for "<base>..HEAD". An error is returned if the
+// commits change imports, as the projects and packages they affect are not
+// known without loading them.
+func manifestRangeChanges(jirix *jiri.X, manifestFiles []string, diffRange string) (projects, pkgs map[string]bool, err error) {
+	base, head, _ := strings.Cut(diffRange, "..")
+	if head == "" {
+		head = "HEAD"
+	}
+	projects, pkgs = make(map[string]bool), make(map[string]bool)
+	repos := make(map[string]bool)
+	for _, file := range manifestFiles {
+		topLevel, err := newGit(jirix, gitutil.RootDirOpt(filepath.Dir(file))).TopLevel()
+		if err != nil {
+			return nil, nil, err
+		}
+		if repos[topLevel] {
+			continue
+		}
+		repos[topLevel] = true
+		scm := newGit(jirix, gitutil.RootDirOpt(topLevel))
+		files, err := scm.ModifiedFiles(base, head)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, f := range files {
+			before, after := manifestAtRevision(scm, base, f), manifestAtRevision(scm, head, f)
+			if before == nil && after == nil {
+				// Not a manifest.
+				continue
+			}
+			if before == nil {
+				before = &Manifest{}
+			}
+			if after == nil {
+				after = &Manifest{}
+			}
+			if !reflect.DeepEqual(before.Imports, after.Imports) ||
+				!reflect.DeepEqual(before.LocalImports, after.LocalImports) ||
+				!reflect.DeepEqual(before.ImportOverrides, after.ImportOverrides) {
+				return nil, nil, fmt.Errorf("%s changes the imports of %s", diffRange, f)
+			}
+			projectName := func(p Project) string { return p.Name }
+			packageName := func(p Package) string { return p.Name }
+			addChangedElements(projects, before.Projects, after.Projects, projectName)
+			addChangedElements(projects, before.ProjectOverrides, after.ProjectOverrides, projectName)
+			addChangedElements(pkgs, before.Packages, after.Packages, packageName)
+			addChangedElements(pkgs, before.PackageOverrides, after.PackageOverrides, packageName)
+		}
+	}
+	return projects, pkgs, nil
+}
+
+// manifestAtRevision returns the manifest in file at revision, or nil if the
+// file does not exist at revision or is not a manifest.
+func manifestAtRevision(scm Git, revision, file string) *Manifest {
+	data, err := scm.Show(revision, filepath.ToSlash(file))
+	if err != nil {
+		return nil
+	}
+	m, err := ManifestFromBytes([]byte(data))
+	if err != nil {
+		return nil
+	}
+	return m
+}
+
+// addChangedElements adds to changed the names of the elements which differ
+// between before and after.
+func addChangedElements[T any](changed map[string]bool, before, after []T, name func(T) string) {
+	byName := func(elems []T) map[string][]T {
+		m := make(map[string][]T)
+		for _, e := range elems {
+			m[name(e)] = append(m[name(e)], e)
+		}
+		return m
+	}
+	b, a := byName(before), byName(after)
+	for n := range b {
+		if !reflect.DeepEqual(b[n], a[n]) {
+			changed[n] = true
+		}
+	}
+	for n := range a {
+		if _, ok := b[n]; !ok {
+			changed[n] = true
+		}
+	}
+}
+
+// getRangeChangedLocksPkgs returns the locks of ePkgLocks which must be
+// dropped, and the packages of pkgs which must be resolved, given the names
+// of the packages changed in the manifests. Unlike getChangedLocksPkgs, locks
+// of packages no longer in the manifests are dropped instead of requiring a
+// full resolve.
+func getRangeChangedLocksPkgs(ePkgLocks PackageLocks, pkgs Packages, changed map[string]bool) (PackageLocks, Packages, error) {
+	retPkgs := make(Packages)
+	retLocks := make(PackageLocks)
+	// pkgMap is the mapping between expanded package name -> Package
+	pkgMap := make(map[string][]Package)
+	for _, v := range pkgs {
+		plats, err := v.GetPlatforms()
+		if err != nil {
+			return nil, nil, err
+		}
+		expandedNames, err := cipd.ResolvePlatforms(v.Name, plats)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, expandedName := range expandedNames {
+			pkgMap[expandedName] = append(pkgMap[expandedName], v)
+		}
+	}
+	locked := make(map[string]bool)
+	for k, v := range ePkgLocks {
+		locked[v.PackageName] = true
+		if _, ok := pkgMap[v.PackageName]; !ok {
+			retLocks[k] = v
+			continue
+		}
+		for _, pkg := range pkgMap[v.PackageName] {
+			if changed[pkg.Name] {
+				retLocks[k] = v
+			}
+		}
+	}
+	for name, expanded := range pkgMap {
+		for _, pkg := range expanded {
+			if changed[pkg.Name] || !locked[name] {
+				retPkgs[pkg.Key()] = pkg
+			}
+		}
+	}
+	return retLocks, retPkgs, nil
+}
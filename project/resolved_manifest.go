@@ -0,0 +1,234 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.fuchsia.dev/jiri"
+)
+
+// ResolvedManifest is a manifest after its imports, overrides and local
+// manifests are applied, and its projects and packages are filtered by the
+// optional attributes and groups of the root. It is meant to be encoded as
+// JSON for tools that need the manifest as jiri sees it.
+type ResolvedManifest struct {
+	Projects []ResolvedProject `json:"projects"`
+	Packages []ResolvedPackage `json:"packages"`
+	Hooks    []ResolvedHook    `json:"hooks"`
+}
+
+// ResolvedProject is a project of a ResolvedManifest. It holds every
+// attribute and element of the project in the manifest, but for the expiry
+// date of overrides, which is only meaningful in <overrides>.
+type ResolvedProject struct {
+	Name string `json:"name"`
+	// Path is the path of the project relative to the root, with slashes.
+	Path          string `json:"path"`
+	Remote        string `json:"remote"`
+	RemoteBranch  string `json:"remote_branch,omitempty"`
+	RemoteName    string `json:"remote_name,omitempty"`
+	Revision      string `json:"revision,omitempty"`
+	HistoryDepth  int    `json:"history_depth,omitempty"`
+	ShallowSince  string `json:"shallow_since,omitempty"`
+	BundleURL     string `json:"bundle_url,omitempty"`
+	Refspecs      string `json:"refspecs,omitempty"`
+	GerritHost    string `json:"gerrit_host,omitempty"`
+	Reviewers     string `json:"reviewers,omitempty"`
+	Hashtags      string `json:"hashtags,omitempty"`
+	Description   string `json:"description,omitempty"`
+	Owners        string `json:"owners,omitempty"`
+	GitHooks      string `json:"githooks,omitempty"`
+	Rebase        string `json:"rebase,omitempty"`
+	GitConfig     string `json:"gitconfig,omitempty"`
+	AllowHooks    bool   `json:"allow_hooks,omitempty"`
+	VCS           string `json:"vcs,omitempty"`
+	SHA256        string `json:"sha256,omitempty"`
+	Attributes    string `json:"attributes,omitempty"`
+	GitAttributes string `json:"git_attributes,omitempty"`
+	Flag          string `json:"flag,omitempty"`
+	Groups        string `json:"groups,omitempty"`
+	// CopyFiles and LinkFiles are the files of the project copied and
+	// symlinked elsewhere in the root.
+	CopyFiles []ResolvedFile `json:"copy_files,omitempty"`
+	LinkFiles []ResolvedFile `json:"link_files,omitempty"`
+	// AltRemotes are the mirrors of Remote.
+	AltRemotes []string `json:"alt_remotes,omitempty"`
+	// Source is the manifest file declaring the project, relative to the
+	// root if it is inside it.
+	Source string `json:"source"`
+	// ImportChain holds the names of the imports pulling in the project,
+	// innermost first.
+	ImportChain []string `json:"import_chain,omitempty"`
+}
+
+// ResolvedFile is a copyfile or linkfile element of a ResolvedProject.
+type ResolvedFile struct {
+	// Src is relative to the project, and Dest to the root.
+	Src  string `json:"src"`
+	Dest string `json:"dest"`
+}
+
+// ResolvedPackage is a package of a ResolvedManifest.
+type ResolvedPackage struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// Path is the path of the package for this host relative to the root,
+	// with slashes.
+	Path       string `json:"path"`
+	Platforms  string `json:"platforms,omitempty"`
+	Internal   bool   `json:"internal,omitempty"`
+	Attributes string `json:"attributes,omitempty"`
+	Groups     string `json:"groups,omitempty"`
+	Action     string `json:"action,omitempty"`
+	Source     string `json:"source"`
+}
+
+// ResolvedHook is a hook of a ResolvedManifest.
+type ResolvedHook struct {
+	Name            string `json:"name"`
+	Project         string `json:"project"`
+	Action          string `json:"action"`
+	Interpreter     string `json:"interpreter,omitempty"`
+	RequiresPackage string `json:"requires_package,omitempty"`
+	Timeout         string `json:"timeout,omitempty"`
+	Inputs          string `json:"inputs,omitempty"`
+	Source          string `json:"source"`
+}
+
+// ResolveManifest loads the manifest file, like "jiri update" does with the
+// .jiri_manifest file but without fetching any manifest, and returns it
+// resolved. localManifestProjects are the manifest projects whose local
+// manifests are used, as for LoadManifestFile.
+func ResolveManifest(jirix *jiri.X, file string, localManifestProjects []string) (*ResolvedManifest, error) {
+	localProjects, err := LocalProjects(jirix, FastScan)
+	if err != nil {
+		return nil, err
+	}
+	projects, hooks, pkgs, err := LoadManifestFile(jirix, file, localProjects, localManifestProjects)
+	if err != nil {
+		return nil, err
+	}
+	if err := FilterOptionalProjectsPackages(jirix, jirix.FetchingAttrs, projects, pkgs); err != nil {
+		return nil, err
+	}
+	FilterProjectsPackagesByGroup(jirix, projects, pkgs)
+
+	m := &ResolvedManifest{
+		Projects: []ResolvedProject{},
+		Packages: []ResolvedPackage{},
+		Hooks:    []ResolvedHook{},
+	}
+	names := make(map[string]bool)
+	for _, p := range projects {
+		names[p.Name] = true
+		rp := ResolvedProject{
+			Name:          p.Name,
+			Path:          relativeToRoot(jirix, p.Path),
+			Remote:        p.Remote,
+			RemoteBranch:  p.RemoteBranch,
+			RemoteName:    p.RemoteName,
+			Revision:      p.Revision,
+			HistoryDepth:  p.HistoryDepth,
+			ShallowSince:  p.ShallowSince,
+			BundleURL:     p.BundleURL,
+			Refspecs:      p.Refspecs,
+			GerritHost:    p.GerritHost,
+			Reviewers:     p.Reviewers,
+			Hashtags:      p.Hashtags,
+			Description:   p.Description,
+			Owners:        p.Owners,
+			GitHooks:      relativeToRoot(jirix, p.GitHooks),
+			Rebase:        p.Rebase,
+			GitConfig:     p.GitConfig,
+			AllowHooks:    p.AllowHooks,
+			VCS:           p.VCS,
+			SHA256:        p.SHA256,
+			Attributes:    p.Attributes,
+			GitAttributes: p.GitAttributes,
+			Flag:          p.Flag,
+			Groups:        p.Groups,
+			Source:        relativeToRoot(jirix, p.ManifestPath),
+			ImportChain:   p.ImportChain,
+		}
+		for _, f := range p.CopyFiles {
+			rp.CopyFiles = append(rp.CopyFiles, ResolvedFile{Src: f.Src, Dest: f.Dest})
+		}
+		for _, f := range p.LinkFiles {
+			rp.LinkFiles = append(rp.LinkFiles, ResolvedFile{Src: f.Src, Dest: f.Dest})
+		}
+		for _, r := range p.AltRemotes {
+			rp.AltRemotes = append(rp.AltRemotes, r.Remote)
+		}
+		m.Projects = append(m.Projects, rp)
+	}
+	for _, pkg := range pkgs {
+		path, err := pkg.ResolvePath()
+		if err != nil {
+			return nil, err
+		}
+		m.Packages = append(m.Packages, ResolvedPackage{
+			Name:       pkg.Name,
+			Version:    pkg.Version,
+			Path:       filepath.ToSlash(path),
+			Platforms:  pkg.Platforms,
+			Internal:   pkg.Internal,
+			Attributes: pkg.Attributes,
+			Groups:     pkg.Groups,
+			Action:     pkg.Action,
+			Source:     relativeToRoot(jirix, pkg.ManifestPath),
+		})
+	}
+	for _, h := range hooks {
+		// The hooks of the projects filtered out are not run.
+		if !names[h.ProjectName] {
+			continue
+		}
+		m.Hooks = append(m.Hooks, ResolvedHook{
+			Name:            h.Name,
+			Project:         h.ProjectName,
+			Action:          h.Action,
+			Interpreter:     h.Interpreter,
+			RequiresPackage: h.RequiresPackage,
+			Timeout:         h.Timeout,
+			Inputs:          h.Inputs,
+			Source:          relativeToRoot(jirix, h.ManifestPath),
+		})
+	}
+	sort.Slice(m.Projects, func(i, j int) bool {
+		if m.Projects[i].Path != m.Projects[j].Path {
+			return m.Projects[i].Path < m.Projects[j].Path
+		}
+		return m.Projects[i].Name < m.Projects[j].Name
+	})
+	sort.Slice(m.Packages, func(i, j int) bool {
+		if m.Packages[i].Name != m.Packages[j].Name {
+			return m.Packages[i].Name < m.Packages[j].Name
+		}
+		return m.Packages[i].Path < m.Packages[j].Path
+	})
+	sort.Slice(m.Hooks, func(i, j int) bool {
+		if m.Hooks[i].Project != m.Hooks[j].Project {
+			return m.Hooks[i].Project < m.Hooks[j].Project
+		}
+		return m.Hooks[i].Name < m.Hooks[j].Name
+	})
+	return m, nil
+}
+
+// relativeToRoot returns path relative to the root, with slashes, if it is
+// inside the root, and path otherwise.
+func relativeToRoot(jirix *jiri.X, path string) string {
+	if path == "" {
+		return ""
+	}
+	rel, err := filepath.Rel(jirix.Root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(rel)
+}
@@ -0,0 +1,221 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/gitutil"
+	"go.fuchsia.dev/jiri/retry"
+)
+
+// ReviewNotesRef is the ref in which Gerrit records the review of the
+// changes it submits, as a git note of each submitted commit.
+const ReviewNotesRef = "refs/notes/review"
+
+// ReviewNote is the review of a commit, as recorded by Gerrit.
+type ReviewNote struct {
+	// Number is the number of the CL, or 0 if unknown.
+	Number      int    `json:"number,omitempty"`
+	URL         string `json:"url,omitempty"`
+	Branch      string `json:"branch,omitempty"`
+	SubmittedBy string `json:"submitted_by,omitempty"`
+	SubmittedAt string `json:"submitted_at,omitempty"`
+}
+
+// ParseReviewNote parses a note of ReviewNotesRef, made of lines like:
+//
+//	Code-Review+2: Jane Doe <jane@example.com>
+//	Submitted-by: Jane Doe <jane@example.com>
+//	Submitted-at: Thu, 10 Jan 2019 18:00:00 +0000
+//	Reviewed-on: https://review.example.com/12345
+//	Project: foo
+//	Branch: refs/heads/main
+func ParseReviewNote(note string) ReviewNote {
+	var rn ReviewNote
+	for _, line := range strings.Split(note, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "Reviewed-on":
+			rn.URL = value
+			if u, err := url.Parse(value); err == nil {
+				if n, err := strconv.Atoi(path.Base(strings.TrimSuffix(u.Path, "/"))); err == nil {
+					rn.Number = n
+				}
+			}
+		case "Branch":
+			rn.Branch = value
+		case "Submitted-by":
+			rn.SubmittedBy = value
+		case "Submitted-at":
+			rn.SubmittedAt = value
+		}
+	}
+	return rn
+}
+
+// ReviewIndex maps the commits of a project to their review.
+type ReviewIndex struct {
+	// NotesRevision is the revision of ReviewNotesRef the index was built
+	// from.
+	NotesRevision string                `json:"notes_revision"`
+	Notes         map[string]ReviewNote `json:"notes"`
+}
+
+// Lookup returns the review of commit, if it is in the index. A nil index is
+// empty.
+func (index *ReviewIndex) Lookup(commit string) (ReviewNote, bool) {
+	if index == nil {
+		return ReviewNote{}, false
+	}
+	note, ok := index.Notes[commit]
+	return note, ok
+}
+
+// reviewIndexFile returns the file of the review index of the project with
+// the given key.
+func reviewIndexFile(jirix *jiri.X, key ProjectKey) string {
+	sum := sha256.Sum256([]byte(key.String()))
+	return filepath.Join(jirix.ReviewIndexDir(), hex.EncodeToString(sum[:8])+".json")
+}
+
+// LoadReviewIndex returns the review index of p, which is empty if the
+// review notes of p were never indexed.
+func LoadReviewIndex(jirix *jiri.X, p Project) (*ReviewIndex, error) {
+	index := &ReviewIndex{Notes: make(map[string]ReviewNote)}
+	file := reviewIndexFile(jirix, MakeProjectKey(p.Name, p.Remote))
+	// Indexes written before remotes were canonicalized are named after the
+	// legacy key of the project.
+	if legacy := reviewIndexFile(jirix, legacyKey(p)); legacy != file {
+		if _, err := os.Stat(file); os.IsNotExist(err) {
+			if err := os.Rename(legacy, file); err != nil && !os.IsNotExist(err) {
+				return nil, fmtError(err)
+			}
+		}
+	}
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return index, nil
+	} else if err != nil {
+		return nil, fmtError(err)
+	}
+	if err := json.Unmarshal(data, index); err != nil {
+		return nil, fmt.Errorf("invalid review index of project %s: %v", p.Name, err)
+	}
+	if index.Notes == nil {
+		index.Notes = make(map[string]ReviewNote)
+	}
+	return index, nil
+}
+
+// ReviewNotesEnabled reports whether the review notes of p are indexed,
+// i.e. whether its name matches one of the patterns of jirix.ReviewNotes.
+func ReviewNotesEnabled(jirix *jiri.X, p Project) bool {
+	for _, pattern := range strings.Split(jirix.ReviewNotes, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern == "" {
+			continue
+		}
+		if ok, _ := path.Match(pattern, p.Name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// UpdateReviewIndexes fetches ReviewNotesRef for the git projects of
+// projects whose review notes are enabled, and updates their review index.
+// Failures of single projects don't stop the others, and are all returned.
+func UpdateReviewIndexes(jirix *jiri.X, projects Projects) error {
+	keys := make(ProjectKeys, 0, len(projects))
+	for key, p := range projects {
+		if ReviewNotesEnabled(jirix, p) && p.VCSName() == GitProjectVCS {
+			keys = append(keys, key)
+		}
+	}
+	sort.Sort(keys)
+	// The notes are fetched in parallel, within the limits of the hosts, and
+	// the errors are returned in the order of the keys.
+	limiter := newHostLimiter(jirix)
+	errs := make([]error, len(keys))
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		wg.Add(1)
+		go func(i int, p Project) {
+			defer wg.Done()
+			if err := updateReviewIndex(jirix, limiter, p); err != nil {
+				errs[i] = fmt.Errorf("indexing the review notes of project %s(%s): %v", p.Name, p.Path, err)
+			}
+		}(i, projects[key])
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func updateReviewIndex(jirix *jiri.X, limiter *hostLimiter, p Project) error {
+	scm := newGit(jirix, gitutil.RootDirOpt(p.Path), gitutil.ProjectOpt(p.Name))
+	release := limiter.acquire(rewriteRemote(jirix, p.Remote), p.Path)
+	err := retry.Function(jirix, func() error {
+		return scm.FetchRefspec(p.PrimaryRemote(), "+"+ReviewNotesRef+":"+ReviewNotesRef)
+	}, fmt.Sprintf("Fetching the review notes of %s", p.Name), retry.AttemptsOpt(jirix.Attempts))
+	release()
+	if err != nil {
+		return &jiri.NetworkError{Err: err}
+	}
+	revision, err := scm.CurrentRevisionForRef(ReviewNotesRef)
+	if err != nil {
+		return err
+	}
+	index, err := LoadReviewIndex(jirix, p)
+	if err != nil {
+		return err
+	}
+	if index.NotesRevision == revision {
+		return nil
+	}
+	notes, err := scm.ListNotes(ReviewNotesRef)
+	if err != nil {
+		return err
+	}
+	// Notes are only ever added, so only the blobs of the commits which are
+	// not indexed yet are read.
+	var blobs []string
+	for commit, blob := range notes {
+		if _, ok := index.Notes[commit]; !ok {
+			blobs = append(blobs, blob)
+		}
+	}
+	contents, err := scm.ReadBlobs(blobs)
+	if err != nil {
+		return err
+	}
+	for commit, blob := range notes {
+		if content, ok := contents[blob]; ok {
+			index.Notes[commit] = ParseReviewNote(content)
+		}
+	}
+	index.NotesRevision = revision
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	return SafeWriteFile(jirix, reviewIndexFile(jirix, MakeProjectKey(p.Name, p.Remote)), data)
+}
@@ -0,0 +1,66 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"testing"
+
+	"go.fuchsia.dev/jiri"
+)
+
+func TestParseReviewNote(t *testing.T) {
+	tests := []struct {
+		note string
+		want ReviewNote
+	}{
+		{
+			note: `Code-Review+2: Jane Doe <jane@example.com>
+Verified+1: Bot <bot@example.com>
+Submitted-by: Jane Doe <jane@example.com>
+Submitted-at: Thu, 10 Jan 2019 18:00:00 +0000
+Reviewed-on: https://review.example.com/12345
+Project: foo
+Branch: refs/heads/main
+`,
+			want: ReviewNote{
+				Number:      12345,
+				URL:         "https://review.example.com/12345",
+				Branch:      "refs/heads/main",
+				SubmittedBy: "Jane Doe <jane@example.com>",
+				SubmittedAt: "Thu, 10 Jan 2019 18:00:00 +0000",
+			},
+		},
+		{
+			note: "Reviewed-on: https://review.example.com/c/foo/+/678/\n",
+			want: ReviewNote{Number: 678, URL: "https://review.example.com/c/foo/+/678/"},
+		},
+		{
+			note: "Reviewed-on: https://review.example.com/q/I0123\n",
+			want: ReviewNote{URL: "https://review.example.com/q/I0123"},
+		},
+		{
+			note: "not a review note",
+		},
+	}
+	for _, test := range tests {
+		if got := ParseReviewNote(test.note); got != test.want {
+			t.Errorf("ParseReviewNote(%q) = %+v, want %+v", test.note, got, test.want)
+		}
+	}
+}
+
+func TestReviewNotesEnabled(t *testing.T) {
+	jirix := &jiri.X{ReviewNotes: "foo, third_party/*"}
+	for name, want := range map[string]bool{
+		"foo":              true,
+		"foobar":           false,
+		"third_party/zlib": true,
+		"bar":              false,
+	} {
+		if got := ReviewNotesEnabled(jirix, Project{Name: name}); got != want {
+			t.Errorf("ReviewNotesEnabled(%q) = %t, want %t", name, got, want)
+		}
+	}
+}
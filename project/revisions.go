@@ -0,0 +1,86 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package project
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/gitutil"
+)
+
+// revisionCacheKey identifies the state of the HEAD of a project: the content
+// of its HEAD file and, when HEAD is a symbolic ref, the file holding the ref,
+// either its loose ref or packed-refs. git replaces these files when it
+// updates them, so comparing them with os.SameFile catches updates that don't
+// change their size or modification time.
+type revisionCacheKey struct {
+	head string
+	ref  os.FileInfo
+}
+
+func (k revisionCacheKey) equal(other revisionCacheKey) bool {
+	if k.head != other.head || (k.ref == nil) != (other.ref == nil) {
+		return false
+	}
+	return k.ref == nil || (os.SameFile(k.ref, other.ref) && k.ref.ModTime().Equal(other.ref.ModTime()) && k.ref.Size() == other.ref.Size())
+}
+
+type revisionCacheEntry struct {
+	key      revisionCacheKey
+	revision string
+}
+
+// revisionCache caches the current revision of projects by path, so that
+// repeated scans of the local projects within a command don't run git again
+// for projects whose HEAD did not move.
+var revisionCache sync.Map
+
+// headKey returns the cache key of the HEAD of the project at path, or false
+// if it cannot be computed, e.g. for worktrees whose .git is a file.
+func headKey(path string) (revisionCacheKey, bool) {
+	gitDir := filepath.Join(path, ".git")
+	head, err := os.ReadFile(filepath.Join(gitDir, "HEAD"))
+	if err != nil {
+		return revisionCacheKey{}, false
+	}
+	head = bytes.TrimSpace(head)
+	key := revisionCacheKey{head: string(head)}
+	ref, ok := bytes.CutPrefix(head, []byte("ref: "))
+	if !ok {
+		// Detached HEAD.
+		return key, true
+	}
+	info, err := os.Stat(filepath.Join(gitDir, filepath.FromSlash(string(ref))))
+	if err != nil {
+		if info, err = os.Stat(filepath.Join(gitDir, "packed-refs")); err != nil {
+			return revisionCacheKey{}, false
+		}
+	}
+	key.ref = info
+	return key, true
+}
+
+// currentRevision returns the revision checked out in the project at path,
+// from revisionCache if its HEAD did not change since it was cached.
+func currentRevision(jirix *jiri.X, path string) (string, error) {
+	key, ok := headKey(path)
+	if ok {
+		if e, found := revisionCache.Load(path); found && e.(revisionCacheEntry).key.equal(key) {
+			return e.(revisionCacheEntry).revision, nil
+		}
+	}
+	revision, err := newGit(jirix, gitutil.RootDirOpt(path)).CurrentRevision()
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		revisionCache.Store(path, revisionCacheEntry{key, revision})
+	}
+	return revision, nil
+}
@@ -0,0 +1,227 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/envvar"
+)
+
+// snapshotPinPrefix is the prefix of the fragment of a snapshot URL pinning
+// the sha256 hash of its content, e.g. "https://host/snapshot#sha256=<hex>".
+const snapshotPinPrefix = "sha256="
+
+// SnapshotDownloader writes to w the content of the snapshot at u, whose
+// fragment is removed.
+type SnapshotDownloader func(jirix *jiri.X, u *url.URL, w io.Writer) error
+
+// SnapshotDownloaders are the downloaders of snapshot URLs by scheme. Programs
+// embedding jiri may add or replace downloaders, e.g. to get gs:// URLs with
+// a storage client rather than the gcloud or gsutil tools, before loading
+// any snapshot.
+var SnapshotDownloaders = map[string]SnapshotDownloader{
+	"http":  downloadHTTPSnapshot,
+	"https": downloadHTTPSnapshot,
+	"gs":    downloadGSSnapshot,
+}
+
+// downloadHTTPSnapshot gets the snapshot at u. If an https server requires
+// authentication, the credential of u is asked to the git credential
+// helpers, and kept by them if the server accepts it.
+func downloadHTTPSnapshot(jirix *jiri.X, u *url.URL, w io.Writer) error {
+	resp, err := http.Get(u.String())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) && u.Scheme == "https" && u.User == nil {
+		scm := newGit(jirix)
+		cred, err := scm.CredentialFill(u)
+		if err != nil || cred.Password == "" {
+			return fmt.Errorf("%s, and no credential from the git credential helpers", resp.Status)
+		}
+		req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+		if err != nil {
+			return err
+		}
+		req.SetBasicAuth(cred.Username, cred.Password)
+		authResp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer authResp.Body.Close()
+		if authResp.StatusCode == http.StatusUnauthorized || authResp.StatusCode == http.StatusForbidden {
+			if err := scm.CredentialReject(u, cred); err != nil {
+				jirix.Logger.Debugf("Cannot reject the credential of %s: %v", u.Redacted(), err)
+			}
+		} else if authResp.StatusCode == http.StatusOK {
+			if err := scm.CredentialApprove(u, cred); err != nil {
+				jirix.Logger.Debugf("Cannot approve the credential of %s: %v", u.Redacted(), err)
+			}
+		}
+		resp = authResp
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// downloadGSSnapshot gets the snapshot at the gs:// URL u with "gcloud
+// storage cat", or with "gsutil cat" if gcloud is not installed, which use
+// the credential of the user.
+func downloadGSSnapshot(jirix *jiri.X, u *url.URL, w io.Writer) error {
+	var args []string
+	if _, err := exec.LookPath("gcloud"); err == nil {
+		args = []string{"gcloud", "storage", "cat", u.String()}
+	} else if _, err := exec.LookPath("gsutil"); err == nil {
+		args = []string{"gsutil", "cat", u.String()}
+	} else {
+		return fmt.Errorf("neither gcloud nor gsutil is installed")
+	}
+	var stderr bytes.Buffer
+	command := exec.Command(args[0], args[1:]...)
+	command.Stdout = w
+	command.Stderr = &stderr
+	command.Env = envvar.MapToSlice(jirix.Env())
+	if err := command.Run(); err != nil {
+		return fmt.Errorf("%s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// snapshotPin returns the sha256 hash of the content pinned by the fragment
+// of u, if any.
+func snapshotPin(u *url.URL) (string, error) {
+	if u.Fragment == "" {
+		return "", nil
+	}
+	pin, ok := strings.CutPrefix(u.Fragment, snapshotPinPrefix)
+	if !ok {
+		return "", fmt.Errorf("invalid fragment %q of snapshot URL %q, want %s<hex>", u.Fragment, u.Redacted(), snapshotPinPrefix)
+	}
+	if b, err := hex.DecodeString(pin); err != nil || len(b) != sha256.Size {
+		return "", fmt.Errorf("invalid sha256 hash %q of snapshot URL %q", pin, u.Redacted())
+	}
+	return strings.ToLower(pin), nil
+}
+
+// cachedSnapshot returns the path of the snapshot with the given hash in the
+// cache, or "" if it is not there or its content does not match the hash.
+func cachedSnapshot(jirix *jiri.X, hash string) string {
+	path := filepath.Join(jirix.SnapshotCacheDir(), "sha256", hash)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != hash {
+		jirix.Logger.Debugf("Ignoring the corrupted snapshot %s in the cache", path)
+		return ""
+	}
+	return path
+}
+
+// snapshotURLIndex returns the file of the cache recording the hash of the
+// snapshot last downloaded from u.
+func snapshotURLIndex(jirix *jiri.X, u *url.URL) string {
+	sum := sha256.Sum256([]byte(u.Redacted()))
+	return filepath.Join(jirix.SnapshotCacheDir(), "urls", hex.EncodeToString(sum[:]))
+}
+
+// fetchSnapshot returns the path of a local file with the content of the
+// snapshot at the URL snapshot.
+//
+// A file:// URL is the path of a local file. Other URLs are downloaded with
+// SnapshotDownloaders into the snapshot cache, where they are kept by the
+// hash of their content. A URL with a "#sha256=<hex>" fragment pins the hash
+// of its content: it is only downloaded if the cache does not already have
+// it, and the download fails if its content does not match. Offline, an
+// unpinned URL falls back to the content last downloaded from it.
+func fetchSnapshot(jirix *jiri.X, snapshot string) (string, error) {
+	u, err := url.Parse(snapshot)
+	if err != nil || u.Scheme == "" {
+		return "", fmt.Errorf("%q is neither a URL nor a valid file path", snapshot)
+	}
+	if u.Scheme == "file" {
+		if u.Host != "" && u.Host != "localhost" {
+			return "", fmt.Errorf("file URL %q must be of a local file", snapshot)
+		}
+		return filepath.FromSlash(u.Path), nil
+	}
+	download, ok := SnapshotDownloaders[u.Scheme]
+	if !ok {
+		return "", fmt.Errorf("unsupported scheme %q of snapshot URL %q", u.Scheme, u.Redacted())
+	}
+	hash, err := snapshotPin(u)
+	if err != nil {
+		return "", err
+	}
+	u.Fragment = ""
+	if hash != "" {
+		if path := cachedSnapshot(jirix, hash); path != "" {
+			jirix.Logger.Debugf("Using snapshot %s from the cache", hash)
+			return path, nil
+		}
+	}
+	index := snapshotURLIndex(jirix, u)
+	if jirix.Offline {
+		if hash == "" {
+			if data, err := os.ReadFile(index); err == nil {
+				if path := cachedSnapshot(jirix, strings.TrimSpace(string(data))); path != "" {
+					jirix.Logger.Warningf("offline: using the snapshot last downloaded from URL %q\n\n", u.Redacted())
+					return path, nil
+				}
+			}
+		}
+		return "", fmt.Errorf("offline: cannot download snapshot from URL %q", u.Redacted())
+	}
+
+	jirix.Logger.Infof("Getting snapshot from URL %q", u.Redacted())
+	var buf bytes.Buffer
+	if err := download(jirix, u, &buf); err != nil {
+		return "", fmt.Errorf("Error getting snapshot from URL %q: %v", u.Redacted(), err)
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	got := hex.EncodeToString(sum[:])
+	if hash != "" && got != hash {
+		return "", fmt.Errorf("snapshot from URL %q has sha256 hash %s, want %s", u.Redacted(), got, hash)
+	}
+	path := filepath.Join(jirix.SnapshotCacheDir(), "sha256", got)
+	if err := writeCachedSnapshot(jirix, path, buf.Bytes()); err != nil {
+		return "", err
+	}
+	if err := writeCachedSnapshot(jirix, index, []byte(got+"\n")); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// writeCachedSnapshot writes data to path in the snapshot cache, readable
+// only by the user, as snapshots may be downloaded with their credentials.
+func writeCachedSnapshot(jirix *jiri.X, path string, data []byte) error {
+	for _, dir := range []string{jirix.SnapshotCacheDir(), filepath.Dir(path)} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return fmtError(err)
+		}
+		// The directories may have been created readable by all.
+		if err := os.Chmod(dir, 0700); err != nil {
+			return fmtError(err)
+		}
+	}
+	return SafeWriteFileMode(jirix, path, data, 0600)
+}
@@ -0,0 +1,103 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/jiritest/xtest"
+	"go.fuchsia.dev/jiri/project"
+)
+
+const fetchedSnapshot = "<manifest version=\"" + project.ManifestVersion + "\">\n</manifest>\n"
+
+func init() {
+	project.SnapshotDownloaders["jiri-test"] = func(jirix *jiri.X, u *url.URL, w io.Writer) error {
+		_, err := io.WriteString(w, fetchedSnapshot)
+		return err
+	}
+}
+
+func TestLoadSnapshotURL(t *testing.T) {
+	t.Parallel()
+
+	jirix := xtest.NewX(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/snapshot" {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, fetchedSnapshot)
+	}))
+	sum := sha256.Sum256([]byte(fetchedSnapshot))
+	hash := hex.EncodeToString(sum[:])
+	load := func(snapshot string) error {
+		t.Helper()
+		_, _, _, err := project.LoadSnapshotFile(jirix, snapshot)
+		return err
+	}
+
+	if err := load(srv.URL + "/snapshot"); err != nil {
+		t.Fatal(err)
+	}
+	cached := filepath.Join(jirix.SnapshotCacheDir(), "sha256", hash)
+	if data, err := os.ReadFile(cached); err != nil || string(data) != fetchedSnapshot {
+		t.Errorf("snapshot is not in the cache: %q, %v", data, err)
+	}
+	for path, want := range map[string]os.FileMode{cached: 0600, filepath.Dir(cached): 0700, jirix.SnapshotCacheDir(): 0700} {
+		if fi, err := os.Stat(path); err != nil {
+			t.Error(err)
+		} else if got := fi.Mode().Perm(); got != want {
+			t.Errorf("%s has mode %v, want %v", path, got, want)
+		}
+	}
+	if err := load(srv.URL + "/missing"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("expected a missing snapshot to fail with its status, got %v", err)
+	}
+	if err := load(srv.URL + "/snapshot#sha256=" + strings.Repeat("0", 64)); err == nil {
+		t.Errorf("expected a snapshot not matching its pin to fail")
+	}
+
+	// Cached snapshots do not need the server.
+	srv.Close()
+	if err := load(srv.URL + "/snapshot#sha256=" + hash); err != nil {
+		t.Errorf("pinned snapshot should be read from the cache: %v", err)
+	}
+	jirix.Offline = true
+	if err := load(srv.URL + "/snapshot#sha256=" + hash); err != nil {
+		t.Errorf("pinned snapshot should be read from the cache offline: %v", err)
+	}
+	if err := load(srv.URL + "/snapshot"); err != nil {
+		t.Errorf("offline, the snapshot last downloaded should be used: %v", err)
+	}
+	if err := load(srv.URL + "/other"); err == nil || !strings.Contains(err.Error(), "offline") {
+		t.Errorf("expected a snapshot never downloaded to fail offline, got %v", err)
+	}
+	jirix.Offline = false
+
+	file := filepath.Join(t.TempDir(), "snapshot")
+	if err := os.WriteFile(file, []byte(fetchedSnapshot), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := load((&url.URL{Scheme: "file", Path: filepath.ToSlash(file)}).String()); err != nil {
+		t.Errorf("file URL: %v", err)
+	}
+	if err := load("jiri-test://bucket/snapshot"); err != nil {
+		t.Errorf("snapshot of a registered downloader: %v", err)
+	}
+	if err := load("ftp://host/snapshot"); err == nil || !strings.Contains(err.Error(), "unsupported scheme") {
+		t.Errorf("expected an unsupported scheme to fail, got %v", err)
+	}
+}
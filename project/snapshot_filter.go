@@ -0,0 +1,140 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.fuchsia.dev/jiri"
+)
+
+// SnapshotFilter restricts the projects and packages recorded by
+// CreateSnapshot, to make partial snapshots of the slice of the tree that a
+// downstream repository consumes. A project or package is recorded if it
+// matches every criterion that is set.
+type SnapshotFilter struct {
+	// Attributes is a comma separated list of attributes. A project or
+	// package is only recorded if it has one of them.
+	Attributes string
+
+	// Paths lists paths, absolute or relative to the root. A project or
+	// package is only recorded if it is at or under one of them.
+	Paths []string
+
+	// Names lists the names of the projects and packages to record. Each
+	// name must be the name of a project or package.
+	Names []string
+}
+
+// IsEmpty returns true if f records every project and package.
+func (f *SnapshotFilter) IsEmpty() bool {
+	return f == nil || (strings.TrimSpace(f.Attributes) == "" && len(f.Paths) == 0 && len(f.Names) == 0)
+}
+
+// apply returns the projects, packages and hooks selected by f. Hooks are
+// selected with their project. The selection must be closed under nesting:
+// the projects that contain a selected project or package must be selected
+// too, as a checkout of the snapshot would be missing them otherwise.
+func (f *SnapshotFilter) apply(jirix *jiri.X, projects Projects, pkgs Packages, hooks Hooks) (Projects, Packages, Hooks, error) {
+	if f.IsEmpty() {
+		return projects, pkgs, hooks, nil
+	}
+	attrs := newAttributes(f.Attributes)
+	var paths []string
+	for _, p := range f.Paths {
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(jirix.Root, p)
+		}
+		paths = append(paths, filepath.Clean(p))
+	}
+	names := make(map[string]bool)
+	for _, name := range f.Names {
+		names[name] = true
+	}
+	matched := make(map[string]bool)
+	selected := func(name, path, itemAttrs string) bool {
+		if !attrs.IsEmpty() && !attrs.Match(newAttributes(itemAttrs)) {
+			return false
+		}
+		if len(paths) > 0 && !underAny(path, paths) {
+			return false
+		}
+		if len(names) > 0 {
+			if !names[name] {
+				return false
+			}
+			matched[name] = true
+		}
+		return true
+	}
+
+	selectedProjects := make(Projects)
+	selectedNames := make(map[string]bool)
+	for k, p := range projects {
+		if selected(p.Name, p.Path, p.Attributes) {
+			selectedProjects[k] = p
+			selectedNames[p.Name] = true
+		}
+	}
+	selectedPkgs := make(Packages)
+	pkgPaths := make(map[PackageKey]string)
+	for k, pkg := range pkgs {
+		rel, err := pkg.ResolvePath()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		path := filepath.Join(jirix.Root, rel)
+		if selected(pkg.Name, path, pkg.Attributes) {
+			selectedPkgs[k] = pkg
+			pkgPaths[k] = path
+		}
+	}
+	for name := range names {
+		if !matched[name] {
+			return nil, nil, nil, fmt.Errorf("snapshot filter: no selected project or package is named %q", name)
+		}
+	}
+
+	var errs []string
+	checkNesting := func(kind, name, path string) {
+		for k, p := range projects {
+			if _, ok := selectedProjects[k]; ok || p.Path == path || !underAny(path, []string{p.Path}) {
+				continue
+			}
+			errs = append(errs, fmt.Sprintf("%s %q is inside project %q, which is not selected", kind, name, p.Name))
+		}
+	}
+	for _, p := range selectedProjects {
+		checkNesting("project", p.Name, p.Path)
+	}
+	for k, pkg := range selectedPkgs {
+		checkNesting("package", pkg.Name, pkgPaths[k])
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return nil, nil, nil, fmt.Errorf("snapshot filter is not closed under nesting:\n%s", strings.Join(errs, "\n"))
+	}
+
+	selectedHooks := make(Hooks)
+	for k, h := range hooks {
+		if selectedNames[h.ProjectName] {
+			selectedHooks[k] = h
+		}
+	}
+	return selectedProjects, selectedPkgs, selectedHooks, nil
+}
+
+// underAny returns true if path is one of dirs or is under one of them.
+func underAny(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		if path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
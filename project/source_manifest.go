@@ -14,6 +14,7 @@ import (
 	"sync"
 
 	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/cipd"
 	"go.fuchsia.dev/jiri/gerrit"
 	"go.fuchsia.dev/jiri/gitutil"
 )
@@ -56,8 +57,33 @@ type SourceManifest_GitCheckout struct {
 	FetchRef string `json:"fetch_ref,omitempty"`
 }
 
+type SourceManifest_CIPDPackage struct {
+	// The package pattern that was given to the CIPD client (if known). Ex.
+	//   infra/tools/luci/led/${platform}
+	PackagePattern string `json:"package_pattern,omitempty"`
+
+	// The fully resolved instance ID of the deployed package. Ex.
+	//   0cfafb3a705bd8f05f86c6444ff500397fbb711c
+	InstanceId string `json:"instance_id,omitempty"`
+
+	// The unresolved version ID of the deployed package. Ex.
+	//   git_revision:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa
+	//   latest
+	Version string `json:"version,omitempty"`
+}
+
 type SourceManifest_Directory struct {
 	GitCheckout *SourceManifest_GitCheckout `json:"git_checkout,omitempty"`
+
+	// The canonicalized hostname of the CIPD server which hosts the CIPD
+	// packages (if any). Ex.
+	//   chrome-infra-packages.appspot.com
+	CipdServerHost string `json:"cipd_server_host,omitempty"`
+
+	// Maps CIPD package name to CIPDPackage. Ex.
+	//   "some/package/name": {...}
+	//   "other/package": {...}
+	CipdPackage map[string]*SourceManifest_CIPDPackage `json:"cipd_package,omitempty"`
 }
 
 type SourceManifest struct {
@@ -120,7 +146,7 @@ func NewSourceManifest(jirix *jiri.X, projects Projects) (*SourceManifest, error
 		gc := &SourceManifest_GitCheckout{
 			RepoUrl: rewriteRemote(jirix, proj.Remote),
 		}
-		scm := gitutil.New(jirix, gitutil.RootDirOpt(filepath.Join(jirix.Root, proj.Path)))
+		scm := newGit(jirix, gitutil.RootDirOpt(filepath.Join(jirix.Root, proj.Path)))
 		if rev, err := scm.CurrentRevision(); err != nil {
 			return err
 		} else {
@@ -133,15 +159,16 @@ func NewSourceManifest(jirix *jiri.X, projects Projects) (*SourceManifest, error
 		if err != nil {
 			return err
 		}
-		if branchMap["origin/"+proj.RemoteBranch] {
+		remote := proj.PrimaryRemote() + "/"
+		if branchMap[remote+proj.RemoteBranch] {
 			gc.FetchRef = "refs/heads/" + proj.RemoteBranch
 		} else {
 			for b := range branchMap {
-				if strings.HasPrefix(b, "origin/HEAD ") {
+				if strings.HasPrefix(b, remote+"HEAD ") {
 					continue
 				}
-				if strings.HasPrefix(b, "origin") {
-					gc.FetchRef = "refs/heads/" + strings.TrimPrefix(b, "origin/")
+				if strings.HasPrefix(b, remote) {
+					gc.FetchRef = "refs/heads/" + strings.TrimPrefix(b, remote)
 					break
 				}
 			}
@@ -181,6 +208,56 @@ func NewSourceManifest(jirix *jiri.X, projects Projects) (*SourceManifest, error
 	return sm, errFromChannel(errs)
 }
 
+// AddCIPDPackages adds the CIPD packages deployed in the jiri root, as
+// recorded by cipd in its .cipd directory, to the directories of sm. pkgs are
+// the packages of the manifest, used to find the package patterns.
+func (sm *SourceManifest) AddCIPDPackages(jirix *jiri.X, pkgs Packages) error {
+	if _, err := os.Stat(filepath.Join(jirix.Root, ".cipd")); err != nil {
+		if os.IsNotExist(err) {
+			// No package was ever fetched.
+			return nil
+		}
+		return fmtError(err)
+	}
+	installed, err := cipd.Installed(jirix, jirix.Root)
+	if err != nil {
+		return err
+	}
+	patterns := make(map[string]string)
+	for _, pkg := range pkgs {
+		plats, err := pkg.GetPlatforms()
+		if err != nil {
+			return err
+		}
+		for _, plat := range plats {
+			if name, err := plat.Resolver().Resolve(pkg.Name); err == nil {
+				patterns[name] = pkg.Name
+			}
+		}
+	}
+	for _, p := range installed {
+		dir := p.Subdir
+		if dir == "" {
+			dir = "."
+		}
+		d, ok := sm.Directories[dir]
+		if !ok {
+			d = &SourceManifest_Directory{}
+			sm.Directories[dir] = d
+		}
+		if d.CipdPackage == nil {
+			d.CipdServerHost = cipd.ServerHost()
+			d.CipdPackage = make(map[string]*SourceManifest_CIPDPackage)
+		}
+		d.CipdPackage[p.PackageName] = &SourceManifest_CIPDPackage{
+			PackagePattern: patterns[p.PackageName],
+			InstanceId:     p.InstanceID,
+			Version:        p.Version,
+		}
+	}
+	return nil
+}
+
 func (sm *SourceManifest) ToFile(jirix *jiri.X, filename string) error {
 	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
 		return fmtError(err)
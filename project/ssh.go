@@ -0,0 +1,106 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"go.fuchsia.dev/jiri"
+)
+
+// scpRemoteRE matches the scp-like syntax of SSH remotes, e.g.
+// "git@host.com:repo". Host names of a single letter are not matched, to
+// not mistake Windows paths such as "C:\repo" for remotes.
+var scpRemoteRE = regexp.MustCompile(`^([^@/:]+@)?([^@/:\\]{2,}):([^/\\].*)$`)
+
+// sshRemoteURL returns remote as a URL if it uses the scp-like syntax of
+// SSH remotes, e.g. "ssh://git@host.com/repo" for "git@host.com:repo", and
+// remote unchanged otherwise.
+func sshRemoteURL(remote string) string {
+	if strings.Contains(remote, "://") {
+		return remote
+	}
+	m := scpRemoteRE.FindStringSubmatch(remote)
+	if m == nil {
+		return remote
+	}
+	return "ssh://" + m[1] + m[2] + "/" + m[3]
+}
+
+// isSSHScheme reports whether scheme is the one of an SSH remote.
+func isSSHScheme(scheme string) bool {
+	return scheme == "ssh" || scheme == "git+ssh" || scheme == "ssh+git"
+}
+
+// hostKeysMu serializes the updates of the known_hosts file of jiri.
+var hostKeysMu sync.Mutex
+
+// installHostKeys adds the host keys of keys missing from the known_hosts
+// file of jiri, see jiri.X.KnownHostsFile.
+func installHostKeys(jirix *jiri.X, keys []HostKey) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return updateKnownHosts(jirix, keys, true)
+}
+
+// writeHostKeys rewrites the known_hosts file of jiri with the host keys of
+// keys only, so that the keys dropped from the manifest are not trusted
+// anymore. The file is removed if there are no keys.
+func writeHostKeys(jirix *jiri.X, keys []HostKey) error {
+	return updateKnownHosts(jirix, keys, false)
+}
+
+// updateKnownHosts writes keys to the known_hosts file of jiri, after the
+// keys already in the file if keep is set.
+func updateKnownHosts(jirix *jiri.X, keys []HostKey, keep bool) error {
+	hostKeysMu.Lock()
+	defer hostKeysMu.Unlock()
+	file := jirix.KnownHostsFile()
+	data, err := os.ReadFile(file)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmtError(err)
+	}
+	known := make(map[string]bool)
+	content := ""
+	if keep {
+		for _, line := range strings.Split(string(data), "\n") {
+			known[strings.Join(strings.Fields(line), " ")] = true
+		}
+		content = string(data)
+		if content != "" && !strings.HasSuffix(content, "\n") {
+			content += "\n"
+		}
+	}
+	for _, k := range keys {
+		line := k.Host + " " + strings.Join(strings.Fields(k.Key), " ")
+		if known[line] {
+			continue
+		}
+		known[line] = true
+		content += line + "\n"
+	}
+	if content == string(data) {
+		return nil
+	}
+	if content == "" {
+		jirix.Logger.Debugf("Removing %s, the manifest has no SSH host keys", file)
+		if err := os.Remove(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmtError(err)
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return fmtError(err)
+	}
+	jirix.Logger.Debugf("Writing the SSH host keys of the manifest to %s", file)
+	return SafeWriteFile(jirix, file, []byte(content))
+}
@@ -32,7 +32,7 @@ type ProjectState struct {
 
 func setProjectState(jirix *jiri.X, state *ProjectState, checkDirty bool, ch chan<- error) {
 	var err error
-	scm := gitutil.New(jirix, gitutil.RootDirOpt(state.Project.Path))
+	scm := newGit(jirix, gitutil.RootDirOpt(state.Project.Path))
 	branches, err := scm.GetAllBranchesInfo()
 	if err != nil {
 		ch <- err
@@ -64,22 +64,19 @@ func setProjectState(jirix *jiri.X, state *ProjectState, checkDirty bool, ch cha
 		}
 	}
 	if state.CurrentBranch.Name == "" {
-		if state.CurrentBranch.Revision, err = scm.CurrentRevision(); err != nil {
+		if state.CurrentBranch.Revision, err = currentRevision(jirix, state.Project.Path); err != nil {
 			ch <- err
 			return
 		}
 	}
 	if checkDirty {
-		state.HasUncommitted, err = scm.HasUncommittedChanges()
+		status, err := scm.Status()
 		if err != nil {
 			ch <- fmt.Errorf("Cannot get uncommitted changes for project %q: %v", state.Project.Name, err)
 			return
 		}
-		state.HasUntracked, err = scm.HasUntrackedFiles()
-		if err != nil {
-			ch <- fmt.Errorf("Cannot get untracked changes for project %q: %v", state.Project.Name, err)
-			return
-		}
+		state.HasUncommitted = len(status.Changed) != 0
+		state.HasUntracked = len(status.Untracked) != 0
 	}
 	ch <- nil
 }
@@ -89,13 +86,18 @@ func GetProjectStates(jirix *jiri.X, projects Projects, checkDirty bool) (map[Pr
 	defer jirix.TimerPop()
 	states := make(map[ProjectKey]*ProjectState, len(projects))
 	sem := make(chan error, len(projects))
+	limit := make(chan struct{}, jirix.Jobs)
 	for key, project := range projects {
 		state := &ProjectState{
 			Project: project,
 		}
 		states[key] = state
 		// jirix is not threadsafe, so we make a clone for each goroutine.
-		go setProjectState(jirix.Clone(tool.ContextOpts{}), state, checkDirty, sem)
+		go func(jirix *jiri.X) {
+			limit <- struct{}{}
+			defer func() { <-limit }()
+			setProjectState(jirix, state, checkDirty, sem)
+		}(jirix.Clone(tool.ContextOpts{}))
 	}
 	for range projects {
 		err := <-sem
@@ -0,0 +1,88 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"go.fuchsia.dev/jiri/gitutil"
+	"go.fuchsia.dev/jiri/jiritest"
+	"go.fuchsia.dev/jiri/jiritest/xtest"
+	"go.fuchsia.dev/jiri/project"
+)
+
+// TestGetProjectStatesFakeGit runs GetProjectStates against in-memory
+// repositories.
+func TestGetProjectStatesFakeGit(t *testing.T) {
+	t.Parallel()
+	jirix := xtest.NewX(t)
+	repos := jiritest.NewFakeGitRepos()
+	jirix.GitFactory = repos.Factory()
+
+	remote := jiritest.NewFakeRepo()
+	remote.Head = "main"
+	base := remote.Commit(map[string]string{"README": "base"})
+	repos.Add("https://example.com/a", remote)
+
+	pathA := filepath.Join(jirix.Root, "a")
+	git := repos.Factory()(jirix)
+	if err := git.Clone("https://example.com/a", pathA); err != nil {
+		t.Fatal(err)
+	}
+	a := repos.Repo(pathA)
+	a.Uncommitted = []string{"README"}
+	head := remote.Commit(map[string]string{"README": "head"})
+
+	pathB := filepath.Join(jirix.Root, "b")
+	b := jiritest.NewFakeRepo()
+	detached := b.Commit(map[string]string{"file": "b"})
+	repos.Add(pathB, b)
+
+	projects := project.Projects{}
+	for _, p := range []project.Project{
+		{Name: "a", Path: pathA, Remote: "https://example.com/a"},
+		{Name: "b", Path: pathB, Remote: "https://example.com/b"},
+	} {
+		projects[p.Key()] = p
+	}
+	states, err := project.GetProjectStates(jirix, projects, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range projects {
+		state := states[p.Key()]
+		switch p.Name {
+		case "a":
+			if got := state.CurrentBranch.Name; got != "main" {
+				t.Errorf("a: current branch %q, want main", got)
+			}
+			if got := state.CurrentBranch.Revision; got != base {
+				t.Errorf("a: revision %s, want %s", got, base)
+			}
+			if !state.HasUncommitted || state.HasUntracked {
+				t.Errorf("a: got uncommitted=%t untracked=%t, want true, false", state.HasUncommitted, state.HasUntracked)
+			}
+		case "b":
+			if state.CurrentBranch.Name != "" || state.CurrentBranch.Revision != detached {
+				t.Errorf("b: got branch %q at %s, want detached at %s", state.CurrentBranch.Name, state.CurrentBranch.Revision, detached)
+			}
+		}
+	}
+
+	scm := repos.Factory()(jirix, gitutil.RootDirOpt(pathA))
+	if err := scm.Fetch("origin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := scm.Merge("origin/main"); err != nil {
+		t.Fatal(err)
+	}
+	if rev, err := scm.CurrentRevision(); err != nil || rev != head {
+		t.Errorf("after merge: got %s, %v, want %s", rev, err, head)
+	}
+	if content, err := scm.Show("HEAD", "README"); err != nil || content != "head" {
+		t.Errorf("Show(HEAD, README) = %q, %v, want \"head\"", content, err)
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"fmt"
+	"maps"
+	"sort"
+	"strings"
+
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/gitutil"
+)
+
+// Change is the difference of a project between two states, e.g. two
+// snapshots, or the local projects and the projects of the manifest.
+type Change struct {
+	// Old is the project in the old state, nil if the project was added.
+	Old *Project
+	// New is the project in the new state, nil if the project was removed.
+	New *Project
+}
+
+// Added reports whether the project is only in the new state.
+func (c Change) Added() bool {
+	return c.Old == nil && c.New != nil
+}
+
+// Removed reports whether the project is only in the old state.
+func (c Change) Removed() bool {
+	return c.Old != nil && c.New == nil
+}
+
+// RemoteChanged reports whether the project is fetched from another remote,
+// or under another remote name, in the new state.
+func (c Change) RemoteChanged() bool {
+	return c.Old != nil && c.New != nil &&
+		(c.Old.Remote != c.New.Remote || c.Old.PrimaryRemote() != c.New.PrimaryRemote())
+}
+
+// Moved reports whether the path of the project changed.
+func (c Change) Moved() bool {
+	return c.Old != nil && c.New != nil && c.Old.Path != c.New.Path
+}
+
+// RevisionChanged reports whether the revision of the project changed.
+func (c Change) RevisionChanged() bool {
+	return c.Old != nil && c.New != nil && c.Old.Revision != c.New.Revision
+}
+
+// project returns the project in the new state, or in the old state if it
+// was removed.
+func (c Change) project() *Project {
+	if c.New != nil {
+		return c.New
+	}
+	return c.Old
+}
+
+// ChangeCommit is a commit between the old and the new revision of a
+// project.
+type ChangeCommit struct {
+	Revision string `json:"revision"`
+	Author   string `json:"author"`
+	Subject  string `json:"subject"`
+}
+
+// Commits returns the commits between the old and the new revision of the
+// project, most recent first, read from its local checkout. At most max
+// commits are returned, or all of them if max is 0. It is an error if the
+// checkout doesn't have both revisions.
+func (c Change) Commits(jirix *jiri.X, max int) ([]ChangeCommit, error) {
+	if !c.RevisionChanged() {
+		return nil, nil
+	}
+	dir := c.New.Path
+	if !isPathDir(dir) {
+		dir = c.Old.Path
+	}
+	lines, err := newGit(jirix, gitutil.RootDirOpt(dir)).LogRange(c.Old.Revision, c.New.Revision, "%H%x00%an%x00%s", max)
+	if err != nil {
+		return nil, err
+	}
+	var commits []ChangeCommit
+	for _, line := range lines {
+		fields := strings.SplitN(line, "\x00", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected git log output %q", line)
+		}
+		commits = append(commits, ChangeCommit{Revision: fields[0], Author: fields[1], Subject: fields[2]})
+	}
+	return commits, nil
+}
+
+// ChangeSet is the difference between two states of the projects. Each
+// list is sorted by path.
+type ChangeSet struct {
+	// Added are the projects only in the new state.
+	Added []Change
+	// Removed are the projects only in the old state.
+	Removed []Change
+	// Updated are the projects whose remote, path or revision changed.
+	Updated []Change
+}
+
+// DiffStates returns the difference between the projects of old and new.
+// Projects are matched by key and, like "jiri update" does, a project of
+// old that is at the path of a project of new with the same name or remote
+// is the same project. Neither old nor new are modified.
+func DiffStates(old, new Projects) ChangeSet {
+	old = maps.Clone(old)
+	MatchLocalWithRemote(old, new)
+	var cs ChangeSet
+	for key, p := range old {
+		if _, ok := new[key]; !ok {
+			cs.Removed = append(cs.Removed, Change{Old: &p})
+		}
+	}
+	for key, p := range new {
+		o, ok := old[key]
+		if !ok {
+			cs.Added = append(cs.Added, Change{New: &p})
+			continue
+		}
+		c := Change{Old: &o, New: &p}
+		if c.RemoteChanged() || c.Moved() || c.RevisionChanged() {
+			cs.Updated = append(cs.Updated, c)
+		}
+	}
+	for _, changes := range [][]Change{cs.Added, cs.Removed, cs.Updated} {
+		sort.Slice(changes, func(i, j int) bool {
+			return changes[i].project().Path < changes[j].project().Path
+		})
+	}
+	return cs
+}
@@ -0,0 +1,149 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.fuchsia.dev/jiri"
+)
+
+// changeSummary describes a change as the list of its kinds, for comparison.
+func changeSummary(c Change) []string {
+	var kinds []string
+	for _, k := range []struct {
+		name string
+		is   bool
+	}{
+		{"added", c.Added()},
+		{"removed", c.Removed()},
+		{"remote", c.RemoteChanged()},
+		{"moved", c.Moved()},
+		{"revision", c.RevisionChanged()},
+	} {
+		if k.is {
+			kinds = append(kinds, k.name)
+		}
+	}
+	return append([]string{c.project().Name}, kinds...)
+}
+
+func TestDiffStates(t *testing.T) {
+	projects := func(ps ...Project) Projects {
+		m := make(Projects)
+		for _, p := range ps {
+			m[p.Key()] = p
+		}
+		return m
+	}
+	p := func(name, path, remote, revision string) Project {
+		return Project{Name: name, Path: "/root/" + path, Remote: remote, Revision: revision}
+	}
+	tests := []struct {
+		name     string
+		old, new Projects
+		want     [][]string
+	}{
+		{
+			name: "unchanged",
+			old:  projects(p("a", "a", "https://a", "1")),
+			new:  projects(p("a", "a", "https://a", "1")),
+		},
+		{
+			name: "add and remove",
+			old:  projects(p("a", "a", "https://a", "1")),
+			new:  projects(p("b", "b", "https://b", "1")),
+			want: [][]string{{"b", "added"}, {"a", "removed"}},
+		},
+		{
+			name: "revision",
+			old:  projects(p("a", "a", "https://a", "1")),
+			new:  projects(p("a", "a", "https://a", "2")),
+			want: [][]string{{"a", "revision"}},
+		},
+		{
+			name: "move and update",
+			old:  projects(p("a", "a", "https://a", "1")),
+			new:  projects(p("a", "b", "https://a", "2")),
+			want: [][]string{{"a", "moved", "revision"}},
+		},
+		{
+			name: "change remote at the same path",
+			old:  projects(p("a", "a", "https://a", "1")),
+			new:  projects(p("a", "a", "https://mirror/a", "1")),
+			want: [][]string{{"a", "remote"}},
+		},
+		{
+			name: "rename at the same path",
+			old:  projects(p("a", "a", "https://a", "1")),
+			new:  projects(p("renamed", "a", "https://a", "1")),
+		},
+		{
+			name: "change remote and path",
+			old:  projects(p("a", "a", "https://a", "1")),
+			new:  projects(p("a", "b", "https://mirror/a", "1")),
+			want: [][]string{{"a", "added"}, {"a", "removed"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			oldLen := len(test.old)
+			cs := DiffStates(test.old, test.new)
+			var got [][]string
+			for _, changes := range [][]Change{cs.Added, cs.Removed, cs.Updated} {
+				for _, c := range changes {
+					got = append(got, changeSummary(c))
+				}
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("Wrong changes (-want +got):\n%s", diff)
+			}
+			if len(test.old) != oldLen {
+				t.Errorf("DiffStates modified old")
+			}
+		})
+	}
+}
+
+func TestComputeOpMatrix(t *testing.T) {
+	jirix := &jiri.X{Root: "/root"}
+	p := func(path, remote, revision string) *Project {
+		return &Project{Name: "a", Path: "/root/" + path, Remote: remote, Revision: revision}
+	}
+	state := func(branch string, dirty bool) *ProjectState {
+		s := &ProjectState{HasUncommitted: dirty}
+		s.CurrentBranch = BranchState{ReferenceState: &ReferenceState{Name: branch, Revision: "1"}}
+		return s
+	}
+	tests := []struct {
+		name          string
+		local, remote *Project
+		state         *ProjectState
+		snapshot      bool
+		want          string
+	}{
+		{"create", nil, p("a", "https://a", "1"), nil, false, createOpKind},
+		{"delete", p("a", "https://a", "1"), nil, state("", false), false, deleteOpKind},
+		{"delete with dirty tree", p("a", "https://a", "1"), nil, state("main", true), false, deleteOpKind},
+		{"unchanged", p("a", "https://a", "1"), p("a", "https://a", "1"), state("", false), false, nullOpKind},
+		{"update detached", p("a", "https://a", "1"), p("a", "https://a", "2"), state("", false), false, updateOpKind},
+		{"update snapshot", p("a", "https://a", "1"), p("a", "https://a", "2"), state("main", false), true, updateOpKind},
+		{"move", p("a", "https://a", "1"), p("b", "https://a", "1"), state("", false), false, moveOpKind},
+		{"move and update", p("a", "https://a", "1"), p("b", "https://a", "2"), state("", false), false, moveOpKind},
+		{"move to root", p("a", "https://a", "1"), &Project{Name: "a", Path: "/root", Remote: "https://a", Revision: "1"}, state("", false), false, createOpKind},
+		{"change remote", p("a", "https://a", "1"), p("a", "https://mirror/a", "1"), state("", false), false, changeRemoteOpKind},
+		// The move changes the remote too, see moveOperation.Run.
+		{"change remote and move", p("a", "https://a", "1"), p("b", "https://mirror/a", "2"), state("", true), false, moveOpKind},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			op := computeOp(jirix, test.local, test.remote, test.state, false, false, false, test.snapshot)
+			if got := op.Kind(); got != test.want {
+				t.Errorf("got operation %q, want %q", got, test.want)
+			}
+		})
+	}
+}
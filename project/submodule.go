@@ -0,0 +1,98 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/gitutil"
+)
+
+// LocalSubmodules returns the git submodules checked out in the git
+// projects of projects, including the submodules of submodules, as
+// projects. They are named after their superproject and their path in it,
+// e.g. "fuchsia/third_party/foo", and their Revision is the one recorded in
+// their superproject.
+func LocalSubmodules(jirix *jiri.X, projects Projects) (Projects, error) {
+	submodules := make(Projects)
+	for _, p := range projects {
+		if p.VCSName() != GitProjectVCS || p.Superproject != "" {
+			continue
+		}
+		scm := newGit(jirix, gitutil.RootDirOpt(p.Path))
+		subs, err := scm.Submodules()
+		if err != nil {
+			return nil, fmt.Errorf("listing the submodules of project %s(%s): %v", p.Name, p.Path, err)
+		}
+		for _, sub := range subs {
+			sp := Project{
+				Name:         path.Join(p.Name, sub.Path),
+				Path:         filepath.Join(p.Path, filepath.FromSlash(sub.Path)),
+				Remote:       sub.Remote,
+				RemoteBranch: sub.Branch,
+				Revision:     sub.Revision,
+				Superproject: p.Path,
+			}
+			if err := sp.fillDefaults(); err != nil {
+				return nil, err
+			}
+			sp.ComputedKey = MakeProjectKey(sp.Name, sp.Remote)
+			submodules[sp.Key()] = sp
+		}
+	}
+	return submodules, nil
+}
+
+// WithSubmodules returns projects and, if submodules are enabled with
+// "jiri init -enable-submodules", their checked out submodules.
+func WithSubmodules(jirix *jiri.X, projects Projects) (Projects, error) {
+	if !jirix.EnableSubmodules {
+		return projects, nil
+	}
+	submodules, err := LocalSubmodules(jirix, projects)
+	if err != nil {
+		return nil, err
+	}
+	all := make(Projects, len(projects)+len(submodules))
+	for key, p := range projects {
+		all[key] = p
+	}
+	for key, p := range submodules {
+		all[key] = p
+	}
+	return all, nil
+}
+
+// SubmoduleAtPath returns the innermost checked out submodule of p which
+// contains dir, or p itself if dir is in none of them or submodules are
+// not enabled.
+func SubmoduleAtPath(jirix *jiri.X, p Project, dir string) (Project, error) {
+	if !jirix.EnableSubmodules || dir == p.Path {
+		return p, nil
+	}
+	submodules, err := LocalSubmodules(jirix, Projects{p.Key(): p})
+	if err != nil {
+		return Project{}, err
+	}
+	var paths []string
+	byPath := make(map[string]Project)
+	for _, sub := range submodules {
+		paths = append(paths, sub.Path)
+		byPath[sub.Path] = sub
+	}
+	// Nested submodules come after the submodules containing them.
+	sort.Sort(sort.Reverse(sort.StringSlice(paths)))
+	for _, subPath := range paths {
+		if dir == subPath || strings.HasPrefix(dir, subPath+string(filepath.Separator)) {
+			return byPath[subPath], nil
+		}
+	}
+	return p, nil
+}
@@ -0,0 +1,303 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/envvar"
+)
+
+// projectUploadChecksFile is the file, relative to the root of a project,
+// where the project declares its own upload checks.
+const projectUploadChecksFile = ".jiri/upload_checks.xml"
+
+// DefaultUploadCheckTimeout is how long an upload check without a timeout of
+// its own may run.
+const DefaultUploadCheckTimeout = 10 * time.Minute
+
+// UploadCheck is a check, e.g. a formatter, a linter or a commit message
+// validator, that "jiri upload" runs on the projects it uploads before
+// pushing them. The action runs in the uploaded project, with
+// JIRI_UPLOAD_PROJECT set to its name, JIRI_UPLOAD_REF to the uploaded ref
+// and JIRI_UPLOAD_BASE to the remote branch it is uploaded to, so that
+// JIRI_UPLOAD_BASE..JIRI_UPLOAD_REF are the uploaded commits. The upload
+// fails if the action exits with an error.
+type UploadCheck struct {
+	Name string `xml:"name,attr"`
+	// Action is the path of the check, relative to the project named by
+	// ProjectName.
+	Action      string `xml:"action,attr"`
+	ProjectName string `xml:"project,attr,omitempty"`
+	// Projects is a comma-separated list of the names, or globs matching
+	// the names, of the projects the check applies to. Empty means all
+	// projects.
+	Projects string `xml:"projects,attr,omitempty"`
+	// Interpreter is the command running the action, as for hooks.
+	Interpreter string `xml:"interpreter,attr,omitempty"`
+	// Timeout is the duration, e.g. "1m", after which the check is killed
+	// and fails. It defaults to DefaultUploadCheckTimeout.
+	Timeout    string   `xml:"timeout,attr,omitempty"`
+	XMLName    struct{} `xml:"check"`
+	ActionPath string   `xml:"-"`
+	// InterpreterCommand is the command mapped to the extension of the
+	// action by the <interpreter> tags of the manifest.
+	InterpreterCommand string `xml:"-"`
+	// ManifestPath stores the absolute path of the manifest, or of the
+	// upload checks file of the project declaring the check.
+	ManifestPath string `xml:"-"`
+}
+
+// projectUploadChecks is the content of a projectUploadChecksFile.
+type projectUploadChecks struct {
+	Checks  []UploadCheck `xml:"check"`
+	XMLName struct{}      `xml:"uploadchecks"`
+}
+
+func (c *UploadCheck) validate() error {
+	if c.Name == "" || c.Action == "" {
+		return fmt.Errorf("bad upload check: name and action are required: %+v", *c)
+	}
+	if c.Timeout != "" {
+		if d, err := time.ParseDuration(c.Timeout); err != nil || d <= 0 {
+			return fmt.Errorf("bad upload check %q: timeout %q should be a positive duration, e.g. \"1m\"", c.Name, c.Timeout)
+		}
+	}
+	if c.Interpreter != "" && strings.TrimSpace(c.Interpreter) == "" {
+		return fmt.Errorf("bad upload check %q: interpreter cannot be blank", c.Name)
+	}
+	for _, pattern := range c.projectPatterns() {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("bad upload check %q: invalid projects pattern %q", c.Name, pattern)
+		}
+	}
+	return nil
+}
+
+func (c UploadCheck) projectPatterns() []string {
+	var patterns []string
+	for _, pattern := range strings.Split(c.Projects, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+// appliesTo returns true if c checks the project named name.
+func (c UploadCheck) appliesTo(name string) bool {
+	patterns := c.projectPatterns()
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (c UploadCheck) timeout() time.Duration {
+	if d, err := time.ParseDuration(c.Timeout); err == nil && d > 0 {
+		return d
+	}
+	return DefaultUploadCheckTimeout
+}
+
+// resolveUploadChecks returns the upload checks of the manifests, with the
+// paths of the projects containing their actions.
+func (ld *loader) resolveUploadChecks() ([]UploadCheck, error) {
+	paths := make(map[string]string)
+	for _, p := range ld.Projects {
+		paths[p.Name] = p.Path
+	}
+	var checks []UploadCheck
+	for _, check := range ld.UploadChecks {
+		var ok bool
+		if check.ActionPath, ok = paths[check.ProjectName]; !ok {
+			return nil, fmt.Errorf("invalid upload check %q in %s: project %q is not in the manifest", check.Name, check.ManifestPath, check.ProjectName)
+		}
+		if !filepath.IsLocal(check.Action) {
+			return nil, fmt.Errorf("invalid upload check %q in %s: action %q is not inside project %q", check.Name, check.ManifestPath, check.Action, check.ProjectName)
+		}
+		if check.Interpreter == "" {
+			check.InterpreterCommand = ld.Interpreters.interpreterFor(check.Action)
+		}
+		checks = append(checks, check)
+	}
+	return checks, nil
+}
+
+// loadProjectUploadChecks returns the upload checks declared in the
+// projectUploadChecksFile of p, which only apply to p, if the manifest allows
+// p to declare hooks, see Project.AllowHooks.
+func loadProjectUploadChecks(jirix *jiri.X, p Project, allowed bool) ([]UploadCheck, error) {
+	file := filepath.Join(p.Path, projectUploadChecksFile)
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmtError(err)
+	}
+	if !allowed {
+		jirix.Logger.Warningf("Project %s(%s) declares upload checks in %s, they don't run as the manifest does not set allowhooks=\"true\" for it\n\n", p.Name, p.Path, projectUploadChecksFile)
+		return nil, nil
+	}
+	var pc projectUploadChecks
+	if err := xml.Unmarshal(data, &pc); err != nil {
+		return nil, fmt.Errorf("invalid upload checks file %s: %v", file, err)
+	}
+	for i := range pc.Checks {
+		check := &pc.Checks[i]
+		if err := check.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		if check.ProjectName != "" && check.ProjectName != p.Name {
+			return nil, fmt.Errorf("invalid upload check %q in %s: it can only belong to project %q", check.Name, file, p.Name)
+		}
+		if !filepath.IsLocal(check.Action) {
+			return nil, fmt.Errorf("invalid upload check %q in %s: action %q is not inside the project", check.Name, file, check.Action)
+		}
+		check.ProjectName, check.Projects = p.Name, ""
+		check.ActionPath, check.ManifestPath = p.Path, file
+	}
+	return pc.Checks, nil
+}
+
+// UploadTarget is a project "jiri upload" pushes.
+type UploadTarget struct {
+	Project Project
+	// Ref is the uploaded ref, e.g. "HEAD".
+	Ref string
+	// Base is the remote-tracking branch the ref is uploaded to, e.g.
+	// "origin/main".
+	Base string
+}
+
+// UploadCheckFailure is the failure of an upload check on a project.
+type UploadCheckFailure struct {
+	Check   UploadCheck
+	Project Project
+	Output  string
+	Err     error
+}
+
+// UploadCheckError is the error of the upload checks that failed.
+type UploadCheckError []UploadCheckFailure
+
+func (e UploadCheckError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d upload check(s) failed:", len(e))
+	for _, f := range e {
+		fmt.Fprintf(&b, "\n\ncheck %q failed for project %s(%s): %v", f.Check.Name, f.Project.Name, f.Project.Path, f.Err)
+		if output := strings.TrimSpace(f.Output); output != "" {
+			b.WriteString("\n  " + strings.ReplaceAll(output, "\n", "\n  "))
+		}
+	}
+	return b.String()
+}
+
+// RunUploadChecks runs checks, along with the upload checks declared by the
+// projects of targets themselves if their project in remoteProjects, the
+// projects of the manifest, allows it, on the targets they apply to, in
+// parallel. It returns an UploadCheckError if any of them fails.
+func RunUploadChecks(jirix *jiri.X, checks []UploadCheck, remoteProjects Projects, targets []UploadTarget) error {
+	type run struct {
+		check  UploadCheck
+		target UploadTarget
+	}
+	var runs []run
+	for _, target := range targets {
+		// Only the manifest allows it, not the checked out project.
+		allowed := remoteProjects[target.Project.Key()].AllowHooks
+		projectChecks, err := loadProjectUploadChecks(jirix, target.Project, allowed)
+		if err != nil {
+			return err
+		}
+		for _, check := range append(append([]UploadCheck(nil), checks...), projectChecks...) {
+			if check.appliesTo(target.Project.Name) {
+				runs = append(runs, run{check, target})
+			}
+		}
+	}
+	if len(runs) == 0 {
+		return nil
+	}
+
+	var failures UploadCheckError
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	jobs := make(chan struct{}, max(jirix.Jobs, 1))
+	for _, r := range runs {
+		wg.Add(1)
+		go func(r run) {
+			defer wg.Done()
+			jobs <- struct{}{}
+			defer func() { <-jobs }()
+			task := jirix.Logger.AddTaskMsg("Running upload check %q for project %q", r.check.Name, r.target.Project.Name)
+			defer task.Done()
+			if output, err := runUploadCheck(jirix, r.check, r.target); err != nil {
+				mu.Lock()
+				failures = append(failures, UploadCheckFailure{Check: r.check, Project: r.target.Project, Output: output, Err: err})
+				mu.Unlock()
+			}
+		}(r)
+	}
+	wg.Wait()
+	if len(failures) == 0 {
+		return nil
+	}
+	sort.Slice(failures, func(i, j int) bool {
+		if failures[i].Project.Name != failures[j].Project.Name {
+			return failures[i].Project.Name < failures[j].Project.Name
+		}
+		return failures[i].Check.Name < failures[j].Check.Name
+	})
+	return failures
+}
+
+// runUploadCheck runs check on target, and returns its combined output.
+func runUploadCheck(jirix *jiri.X, check UploadCheck, target UploadTarget) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), check.timeout())
+	defer cancel()
+	cmd, err := hookCommand(ctx, jirix, Hook{
+		Name:               check.Name,
+		Action:             check.Action,
+		ActionPath:         check.ActionPath,
+		Interpreter:        check.Interpreter,
+		InterpreterCommand: check.InterpreterCommand,
+	})
+	if err != nil {
+		return "", err
+	}
+	env := jirix.Env()
+	env["JIRI_ROOT"] = jirix.Root
+	env["JIRI_UPLOAD_PROJECT"] = target.Project.Name
+	env["JIRI_UPLOAD_REF"] = target.Ref
+	env["JIRI_UPLOAD_BASE"] = target.Base
+	var output bytes.Buffer
+	cmd.Dir = target.Project.Path
+	cmd.Env = envvar.MapToSlice(env)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err = cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", check.timeout())
+	}
+	return output.String(), err
+}
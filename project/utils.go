@@ -5,12 +5,18 @@
 package project
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"time"
 
 	"go.fuchsia.dev/jiri"
 	"go.fuchsia.dev/jiri/gitutil"
@@ -37,6 +43,63 @@ func fmtError(err error) error {
 	return fmt.Errorf("%s:%d: %s", filepath.Base(file), line, err)
 }
 
+// timePhase starts timing phase of the update of project. The returned
+// function records the elapsed time with jirix.RecordProjectTiming.
+func timePhase(jirix *jiri.X, project Project, phase string) func() {
+	start := time.Now()
+	return func() {
+		jirix.RecordProjectTiming(project.Name, project.Path, project.Remote, phase, time.Since(start))
+	}
+}
+
+// appendUpdateProfile appends the project timings recorded by the current
+// update, if any, to the log file as JSON.
+func appendUpdateProfile(jirix *jiri.X, logFile string) error {
+	profile := jirix.UpdateProfile()
+	if profile == nil {
+		return nil
+	}
+	data, err := json.MarshalIndent(profile, "", "  ")
+	if err != nil {
+		return fmtError(err)
+	}
+	f, err := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmtError(err)
+	}
+	if _, err := fmt.Fprintf(f, "\nProject update timings:\n%s\n", data); err != nil {
+		f.Close()
+		return fmtError(err)
+	}
+	return fmtError(f.Close())
+}
+
+// updateSummaryHeader is the line which precedes the summary of the update,
+// in JSON, in the update history logs.
+const updateSummaryHeader = "Update summary:"
+
+// appendUpdateSummary appends what the current update did, see
+// jiri.UpdateSummary, to logFile, for "jiri history show".
+func appendUpdateSummary(jirix *jiri.X, logFile string) error {
+	summary := jirix.UpdateSummary()
+	if summary == nil {
+		return nil
+	}
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return fmtError(err)
+	}
+	f, err := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmtError(err)
+	}
+	if _, err := fmt.Fprintf(f, "\n%s\n%s\n", updateSummaryHeader, data); err != nil {
+		f.Close()
+		return fmtError(err)
+	}
+	return fmtError(f.Close())
+}
+
 // errFromChannel converts a channel of errors into a single error using
 // errors.Join().
 func errFromChannel(c <-chan error) error {
@@ -47,15 +110,81 @@ func errFromChannel(c <-chan error) error {
 	return errors.Join(errs...)
 }
 
+// SafeWriteFile atomically replaces the content of filename with data: the
+// data is written to a temporary file in the same directory, which is then
+// renamed over filename, so that readers see either the old or the new
+// content. Writers of the same file, in this or other jiri processes, are
+// serialized by an advisory lock, see lockFileWrites. An existing file keeps
+// its permissions; a new one gets 0644. If filename is a symlink, the file it
+// points to is replaced, so that the symlink is kept.
 func SafeWriteFile(jirix *jiri.X, filename string, data []byte) error {
-	tmp := filename + ".tmp"
-	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+	return SafeWriteFileMode(jirix, filename, data, 0644)
+}
+
+// SafeWriteFileMode is SafeWriteFile, giving mode to filename if it is new.
+func SafeWriteFileMode(jirix *jiri.X, filename string, data []byte, mode os.FileMode) error {
+	if target, err := filepath.EvalSymlinks(filename); err == nil {
+		filename = target
+	}
+	dir := filepath.Dir(filename)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmtError(err)
+	}
+	unlock, err := lockFileWrites(jirix, filename)
+	if err != nil {
+		return fmtError(err)
+	}
+	defer unlock()
+	if fi, err := os.Stat(filename); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	// A unique temporary file, so that concurrent writers not going through
+	// the lock, e.g. of older jiri versions, don't write into the same one.
+	tmp, err := os.CreateTemp(dir, filepath.Base(filename)+".tmp*")
+	if err != nil {
+		return fmtError(err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmtError(err)
+	}
+	// Make sure the data is on disk before the rename, so that a crash
+	// doesn't leave an empty file behind.
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
 		return fmtError(err)
 	}
-	if err := os.WriteFile(tmp, data, 0644); err != nil {
+	if err := tmp.Close(); err != nil {
 		return fmtError(err)
 	}
-	return fmtError(osutil.Rename(tmp, filename))
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return fmtError(err)
+	}
+	return fmtError(osutil.Rename(tmp.Name(), filename))
+}
+
+// lockFileWrites acquires the advisory lock serializing the writes of the
+// files of the directory of filename, waiting for it if needed. Locking the
+// directory rather than the file bounds the lock files, as many files, e.g.
+// update history snapshots, have unique names; they are removed once
+// released anyway. The lock files are kept in the locks directory of the
+// root, rather than next to the files, which may be in git checkouts, or in a
+// directory of the user in the temporary directory outside of a root.
+func lockFileWrites(jirix *jiri.X, filename string) (unlock func() error, err error) {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(os.TempDir(), fmt.Sprintf("jiri-locks-%d", os.Getuid()))
+	if jirix.Root != "" && isPathDir(jirix.RootMetaDir()) {
+		dir = filepath.Join(jirix.RootMetaDir(), "locks")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(filepath.Dir(abs)))
+	return osutil.LockRemovableFile(filepath.Join(dir, hex.EncodeToString(sum[:8])+".lock"))
 }
 
 func isPathDir(dir string) bool {
@@ -90,22 +219,85 @@ func fmtRevision(r string) string {
 	return r[:l]
 }
 
-// clone is a wrapper that reattempts a git clone operation on failure.
-func clone(jirix *jiri.X, repo, path string, opts ...gitutil.CloneOpt) error {
+// clone is a wrapper that reattempts a git clone operation of the project
+// with the given name on failure.
+func clone(jirix *jiri.X, name, repo, path string, opts ...gitutil.CloneOpt) error {
 	msg := fmt.Sprintf("Cloning %s", repo)
 	t := jirix.Logger.TrackTime("%s", msg)
 	defer t.Done()
-	return retry.Function(jirix, func() error {
-		return gitutil.New(jirix).Clone(repo, path, opts...)
-	}, msg, retry.AttemptsOpt(jirix.Attempts))
+	if err := retry.Function(jirix, func() error {
+		return newGit(jirix, gitutil.ProjectOpt(name)).Clone(repo, path, opts...)
+	}, msg, retry.AttemptsOpt(jirix.Attempts)); err != nil {
+		return &jiri.NetworkError{Err: err}
+	}
+	return nil
+}
+
+// cloneFromBundle clones repo of the project with the given name into path,
+// seeding it from bundleURL or, if jirix.BundleURI is set, from the bundles
+// advertised by the server. It returns false if there is no bundle to use, in
+// which case the caller should clone normally. As bundles are an
+// optimization, failing to clone with them is reported as a warning and also
+// returns false.
+func cloneFromBundle(jirix *jiri.X, name, repo, path, bundleURL string, shallow bool, opts ...gitutil.CloneOpt) bool {
+	// git does not support bundles for shallow clones.
+	if shallow {
+		return false
+	}
+	switch {
+	case bundleURL != "":
+		opts = append(opts, gitutil.BundleURIOpt(bundleURL))
+	case jirix.BundleURI:
+		opts = append(opts, gitutil.ServerBundlesOpt(true))
+	default:
+		return false
+	}
+	msg := fmt.Sprintf("Cloning %s from bundle", repo)
+	t := jirix.Logger.TrackTime("%s", msg)
+	defer t.Done()
+	if err := newGit(jirix, gitutil.ProjectOpt(name)).Clone(repo, path, opts...); err != nil {
+		jirix.Logger.Warningf("Cloning %s with bundles failed, falling back to a normal clone: %v\n\n", repo, err)
+		if err := os.RemoveAll(path); err != nil {
+			jirix.Logger.Warningf("Not able to remove %q: %v\n\n", path, err)
+		}
+		return false
+	}
+	return true
 }
 
-// fetch is a wrapper that reattempts a git fetch operation on failure.
-func fetch(jirix *jiri.X, path, remote string, opts ...gitutil.FetchOpt) error {
+// fetch is a wrapper that reattempts a git fetch operation of the project
+// with the given name on failure.
+func fetch(jirix *jiri.X, name, path, remote string, opts ...gitutil.FetchOpt) error {
 	msg := fmt.Sprintf("Fetching for %s", path)
 	t := jirix.Logger.TrackTime("%s", msg)
 	defer t.Done()
-	return retry.Function(jirix, func() error {
-		return gitutil.New(jirix, gitutil.RootDirOpt(path)).Fetch(remote, opts...)
-	}, msg, retry.AttemptsOpt(jirix.Attempts))
+	if err := retry.Function(jirix, func() error {
+		return newGit(jirix, gitutil.RootDirOpt(path), gitutil.ProjectOpt(name)).Fetch(remote, opts...)
+	}, msg, retry.AttemptsOpt(jirix.Attempts)); err != nil {
+		return &jiri.NetworkError{Err: err}
+	}
+	return nil
+}
+
+// parseFlag splits a project or package "flag" attribute of the form
+// $FILE_NAME|$FLAG_SUCCESSFUL|$FLAG_FAILED. '|' is forbidden in Windows paths,
+// which is why it is used as the separator. The file name is always
+// interpreted with forward slashes and must stay inside the jiri root.
+func parseFlag(flag string) (file, success, failure string, err error) {
+	fields := strings.Split(flag, "|")
+	if len(fields) != 3 {
+		return "", "", "", fmt.Errorf("flag %q does not have the form <file>|<success>|<failure>", flag)
+	}
+	file = strings.TrimSpace(fields[0])
+	if file == "" {
+		return "", "", "", fmt.Errorf("flag %q has an empty file name", flag)
+	}
+	if path.IsAbs(file) || filepath.IsAbs(file) || filepath.VolumeName(file) != "" {
+		return "", "", "", fmt.Errorf("flag %q must use a path relative to the jiri root", flag)
+	}
+	file = filepath.FromSlash(path.Clean(file))
+	if file == ".." || strings.HasPrefix(file, ".."+string(filepath.Separator)) {
+		return "", "", "", fmt.Errorf("flag %q points outside of the jiri root", flag)
+	}
+	return file, fields[1], fields[2], nil
 }
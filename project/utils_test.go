@@ -0,0 +1,162 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.fuchsia.dev/jiri"
+)
+
+func TestParseFlag(t *testing.T) {
+	tests := []struct {
+		flag    string
+		file    string
+		success string
+		failure string
+		wantErr bool
+	}{
+		{flag: "build/flag|true|false", file: filepath.Join("build", "flag"), success: "true", failure: "false"},
+		{flag: " out/./flag |1|", file: filepath.Join("out", "flag"), success: "1", failure: ""},
+		{flag: "flag|a|b|c", wantErr: true},
+		{flag: "flag|a", wantErr: true},
+		{flag: "|a|b", wantErr: true},
+		{flag: "/etc/flag|a|b", wantErr: true},
+		{flag: "../flag|a|b", wantErr: true},
+		{flag: "a/../../flag|a|b", wantErr: true},
+	}
+	for _, test := range tests {
+		file, success, failure, err := parseFlag(test.flag)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("parseFlag(%q): expected error, got file %q", test.flag, file)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseFlag(%q): unexpected error: %v", test.flag, err)
+			continue
+		}
+		if file != test.file || success != test.success || failure != test.failure {
+			t.Errorf("parseFlag(%q) = %q, %q, %q; want %q, %q, %q", test.flag, file, success, failure, test.file, test.success, test.failure)
+		}
+	}
+}
+
+func TestSafeWriteFileConcurrent(t *testing.T) {
+	root := t.TempDir()
+	jirix := &jiri.X{Root: root}
+	if err := os.MkdirAll(jirix.RootMetaDir(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(root, "dir", "file")
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filename, []byte("initial"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Large enough contents that interleaved writes would be noticed.
+	var contents [][]byte
+	for i := range 20 {
+		contents = append(contents, bytes.Repeat([]byte(fmt.Sprintf("writer %d\n", i)), 10000))
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, len(contents))
+	for _, data := range contents {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- SafeWriteFile(jirix, filename, data)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	got, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, data := range contents {
+		if bytes.Equal(got, data) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		t.Errorf("the file does not contain the data of a single writer")
+	}
+	entries, err := os.ReadDir(filepath.Dir(filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if strings.Contains(e.Name(), ".tmp") {
+			t.Errorf("temporary file %s was left behind", e.Name())
+		}
+	}
+	if runtime.GOOS != "windows" {
+		locks, err := os.ReadDir(filepath.Join(jirix.RootMetaDir(), "locks"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(locks) != 0 {
+			t.Errorf("lock files were left behind: %v", locks)
+		}
+	}
+	if runtime.GOOS != "windows" {
+		fi, err := os.Stat(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := fi.Mode().Perm(), os.FileMode(0600); got != want {
+			t.Errorf("mode = %v, want %v", got, want)
+		}
+	}
+}
+
+// TestSafeWriteFileSymlink checks that writing a symlinked file replaces the
+// file it points to and keeps the symlink.
+func TestSafeWriteFileSymlink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on windows")
+	}
+	root := t.TempDir()
+	jirix := &jiri.X{Root: root}
+	target := filepath.Join(root, "manifests", "manifest")
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(target, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(root, "manifest")
+	if err := os.Symlink(filepath.Join("manifests", "manifest"), link); err != nil {
+		t.Fatal(err)
+	}
+	if err := SafeWriteFile(jirix, link, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Lstat(link); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("%s should still be a symlink, got %v, %v", link, fi, err)
+	}
+	if got, err := os.ReadFile(target); err != nil || string(got) != "new" {
+		t.Errorf("got %q, %v, want the new content in %s", got, err, target)
+	}
+}
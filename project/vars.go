@@ -0,0 +1,76 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+)
+
+// varRefRE matches the references to manifest variables, e.g. {{chromium_rev}}.
+var varRefRE = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// manifestVars returns the variables in scope in m: those declared by m,
+// overridden by inherited, the variables of the manifests importing m, and
+// by cmdline, those set on the command line.
+func manifestVars(m *Manifest, inherited, cmdline map[string]string) map[string]string {
+	vars := make(map[string]string)
+	for _, v := range m.Vars {
+		vars[v.Name] = v.Value
+	}
+	maps.Copy(vars, inherited)
+	maps.Copy(vars, cmdline)
+	return vars
+}
+
+// expandVars replaces the references to variables in s by their value in
+// vars. It is an error to refer to a variable which is not in vars.
+func expandVars(s string, vars map[string]string) (string, error) {
+	var err error
+	s = varRefRE.ReplaceAllStringFunc(s, func(ref string) string {
+		name := varRefRE.FindStringSubmatch(ref)[1]
+		value, ok := vars[name]
+		if !ok && err == nil {
+			err = fmt.Errorf("undefined variable %q", name)
+		}
+		return value
+	})
+	return s, err
+}
+
+// expandVars replaces the references to variables in the remote, revision and
+// path attributes of the projects of m, and in the remote, revision, manifest
+// and root attributes of its imports, including overrides, by their value in
+// vars.
+func (m *Manifest) expandVars(vars map[string]string) error {
+	expand := func(elem, name string, attrs ...*string) error {
+		for _, attr := range attrs {
+			value, err := expandVars(*attr, vars)
+			if err != nil {
+				return fmt.Errorf("bad %s %q: %v", elem, name, err)
+			}
+			*attr = value
+		}
+		return nil
+	}
+	for _, imports := range [][]Import{m.Imports, m.ImportOverrides} {
+		for i := range imports {
+			imp := &imports[i]
+			if err := expand("import", imp.Name, &imp.Remote, &imp.Revision, &imp.Manifest, &imp.Root); err != nil {
+				return err
+			}
+		}
+	}
+	for _, projects := range [][]Project{m.Projects, m.ProjectOverrides} {
+		for i := range projects {
+			p := &projects[i]
+			if err := expand("project", p.Name, &p.Remote, &p.Revision, &p.Path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestManifestExpandVars(t *testing.T) {
+	vars := map[string]string{"host": "https://example.com", "rev": "abc", "dir": "third_party"}
+	m := &Manifest{
+		Imports: []Import{{
+			Name:     "minimal",
+			Remote:   "{{host}}/manifest",
+			Revision: "{{rev}}",
+			Manifest: "{{dir}}/minimal",
+			Root:     "{{ dir }}",
+		}},
+		ImportOverrides: []Import{{Name: "minimal", Remote: "{{host}}/manifest", Manifest: "{{dir}}/full"}},
+		Projects:        []Project{{Name: "foo", Remote: "{{host}}/foo", Revision: "{{rev}}", Path: "{{dir}}/foo"}},
+	}
+	if err := m.expandVars(vars); err != nil {
+		t.Fatal(err)
+	}
+	want := &Manifest{
+		Imports: []Import{{
+			Name:     "minimal",
+			Remote:   "https://example.com/manifest",
+			Revision: "abc",
+			Manifest: "third_party/minimal",
+			Root:     "third_party",
+		}},
+		ImportOverrides: []Import{{Name: "minimal", Remote: "https://example.com/manifest", Manifest: "third_party/full"}},
+		Projects:        []Project{{Name: "foo", Remote: "https://example.com/foo", Revision: "abc", Path: "third_party/foo"}},
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("got manifest %+v, want %+v", m, want)
+	}
+
+	m = &Manifest{Imports: []Import{{Name: "minimal", Manifest: "{{undefined}}"}}}
+	if err := m.expandVars(vars); err == nil {
+		t.Errorf("expected an error for an undefined variable")
+	}
+}
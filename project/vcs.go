@@ -0,0 +1,260 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package project
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"go.fuchsia.dev/jiri"
+	"go.fuchsia.dev/jiri/log"
+)
+
+// GitProjectVCS is the VCS of projects that don't set one. Git projects are
+// handled by the rest of this package, the other VCS by their VCS backend.
+const GitProjectVCS = "git"
+
+// VCS is the backend syncing the projects of a version control system other
+// than git, selected by their vcs attribute. Projects of such a VCS are
+// synced after the git projects. Unlike git projects, jiri doesn't manage
+// their branches or local changes: they are only brought to the state
+// described by the manifest.
+type VCS interface {
+	// Name is the value of the vcs attribute of the projects of the VCS.
+	Name() string
+	// Validate checks the manifest attributes of p.
+	Validate(p Project) error
+	// UpToDate reports whether the checkout at p.Path is in the state
+	// described by p already, so that it doesn't need to be synced.
+	UpToDate(jirix *jiri.X, p Project) (bool, error)
+	// Test checks that p can be synced, before any project is.
+	Test(jirix *jiri.X, p Project) error
+	// Sync brings the checkout at p.Path to the state described by p,
+	// creating it if needed.
+	Sync(jirix *jiri.X, p Project) error
+	// LockRevision returns the revision of p recorded in jiri.lock.
+	LockRevision(jirix *jiri.X, p Project) (string, error)
+	// ApplyLock pins p to revision, read from jiri.lock, failing if p is
+	// pinned to another revision already.
+	ApplyLock(p *Project, revision string) error
+	// LocalChanges describes the work in the checkout at p.Path which
+	// deleting it would lose, e.g. uncommitted changes, or returns "" if
+	// there is none.
+	LocalChanges(jirix *jiri.X, p Project) (string, error)
+}
+
+var vcsBackends = map[string]VCS{
+	ArchiveProjectVCS: archiveVCS{},
+	HgProjectVCS:      hgVCS{},
+}
+
+// VCSName returns the version control system of p: the vcs attribute, and
+// "git" by default.
+func (p Project) VCSName() string {
+	if p.VCS != "" {
+		return p.VCS
+	}
+	return GitProjectVCS
+}
+
+// vcs returns the backend of p, or nil for git projects.
+func (p Project) vcs() VCS {
+	return vcsBackends[p.VCSName()]
+}
+
+func (p *Project) validateVCS() error {
+	name := p.VCSName()
+	if name != ArchiveProjectVCS && p.SHA256 != "" {
+		return fmt.Errorf("bad project %q: sha256 can only be set on archive projects", p.Name)
+	}
+	if name == GitProjectVCS {
+		return nil
+	}
+	v, ok := vcsBackends[name]
+	if !ok {
+		names := []string{GitProjectVCS}
+		for n := range vcsBackends {
+			names = append(names, n)
+		}
+		slices.Sort(names)
+		return fmt.Errorf("bad project %q: unknown vcs %q, should be one of %s", p.Name, name, strings.Join(names, ", "))
+	}
+	return v.Validate(*p)
+}
+
+// splitVCSProjects removes the projects that are not git projects from
+// projects, and returns them.
+func splitVCSProjects(projects Projects) Projects {
+	vcsProjects := make(Projects)
+	for key, p := range projects {
+		if p.vcs() != nil {
+			vcsProjects[key] = p
+			delete(projects, key)
+		}
+	}
+	return vcsProjects
+}
+
+// splitCredentials returns remote without the credentials of its URL, which
+// the URL rewrite rules of jiri may add, along with the credentials, or nil
+// if there are none. The VCS backends pass the credentials to the commands
+// they run by other means than their arguments, which other users can see.
+func splitCredentials(remote string) (string, *url.Userinfo) {
+	u, err := url.Parse(remote)
+	if err != nil || u.User == nil {
+		return remote, nil
+	}
+	user := u.User
+	u.User = nil
+	return u.String(), user
+}
+
+// vcsStateFile returns the file recording the projects that are not git
+// projects of the last update, which are not found by scanning the jiri root
+// for projects.
+func vcsStateFile(jirix *jiri.X) string {
+	return filepath.Join(jirix.RootMetaDir(), "vcs_projects")
+}
+
+// LocalVCSProjects returns the projects that are not git projects synced by
+// the last update.
+func LocalVCSProjects(jirix *jiri.X) (Projects, error) {
+	projects := make(Projects)
+	file := vcsStateFile(jirix)
+	if _, err := os.Stat(file); err != nil {
+		if os.IsNotExist(err) {
+			return projects, nil
+		}
+		return nil, fmtError(err)
+	}
+	m, err := ManifestFromFile(jirix, file)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range m.Projects {
+		p.absolutizePaths(jirix.Root)
+		projects[p.Key()] = p
+	}
+	return projects, nil
+}
+
+func writeVCSState(jirix *jiri.X, vcsProjects Projects) error {
+	m := Manifest{}
+	for _, p := range vcsProjects {
+		m.Projects = append(m.Projects, p)
+	}
+	return m.ToFile(jirix, vcsStateFile(jirix))
+}
+
+// vcsOperation represents syncing a project that is not a git project with
+// its VCS backend.
+type vcsOperation struct {
+	commonOperation
+	vcs VCS
+}
+
+func (op vcsOperation) Kind() string {
+	return vcsOpKind
+}
+
+func (op vcsOperation) Run(jirix *jiri.X) error {
+	return op.vcs.Sync(jirix, op.project)
+}
+
+func (op vcsOperation) String() string {
+	return fmt.Sprintf("sync %s project %q at %q from %q", op.vcs.Name(), op.project.Name, op.destination, op.project.Remote)
+}
+
+func (op vcsOperation) Test(jirix *jiri.X) error {
+	if _, err := os.Stat(filepath.Join(op.destination, ".git")); err == nil {
+		return fmt.Errorf("cannot sync %s project %q into %q, which is a git project", op.vcs.Name(), op.project.Name, op.destination)
+	}
+	return op.vcs.Test(jirix, op.project)
+}
+
+// computeVCSOperations returns the operations syncing the projects whose
+// path isn't in the state they describe already.
+func computeVCSOperations(jirix *jiri.X, vcsProjects Projects) (operations, error) {
+	var ops operations
+	for _, p := range vcsProjects {
+		v := p.vcs()
+		upToDate, err := v.UpToDate(jirix, p)
+		if err != nil {
+			return nil, err
+		}
+		if upToDate {
+			continue
+		}
+		ops = append(ops, vcsOperation{commonOperation{
+			destination: p.Path,
+			project:     p,
+			source:      p.Path,
+		}, v})
+	}
+	sort.Sort(ops)
+	return ops, nil
+}
+
+// updateVCSProjects syncs vcsProjects, and removes the projects that are not
+// git projects of the previous update that are gone from the manifest if gc
+// is set.
+func updateVCSProjects(jirix *jiri.X, vcsProjects Projects, gc bool) error {
+	jirix.TimerPush("update vcs projects")
+	defer jirix.TimerPop()
+
+	previous, err := LocalVCSProjects(jirix)
+	if err != nil {
+		return err
+	}
+	ops, err := computeVCSOperations(jirix, vcsProjects)
+	if err != nil {
+		return err
+	}
+	for _, op := range ops {
+		if err := op.Test(jirix); err != nil {
+			return err
+		}
+	}
+	if err := runCommonOperations(jirix, ops, log.DebugLevel); err != nil {
+		return err
+	}
+
+	current := make(map[string]bool)
+	for _, p := range vcsProjects {
+		current[p.Path] = true
+	}
+	for key, p := range previous {
+		if current[p.Path] {
+			continue
+		}
+		if !gc {
+			jirix.Logger.Warningf("%s project %q at %q was removed from the manifest. Run 'jiri update -gc' to delete it.\n\n", p.VCSName(), p.Name, p.Path)
+			vcsProjects[key] = p
+			continue
+		}
+		// As for git projects, local work is never deleted.
+		changes, err := p.vcs().LocalChanges(jirix, p)
+		if err != nil {
+			return fmt.Errorf("cannot check the local changes of %s project %q: %v", p.VCSName(), p.Name, err)
+		}
+		if changes != "" {
+			msg := fmt.Sprintf("%s project %q at %q was removed from the manifest, but won't be deleted as it contains %s", p.VCSName(), p.Name, p.Path, changes)
+			msg += fmt.Sprintf("\nIf you no longer need it, invoke '%s'\n\n", jirix.Color.Yellow("rm -rf %q", p.Path))
+			jirix.Logger.Warningf("%s", msg)
+			vcsProjects[key] = p
+			continue
+		}
+		jirix.Logger.Infof("Deleting %s project %q at %q, which was removed from the manifest", p.VCSName(), p.Name, p.Path)
+		if err := os.RemoveAll(p.Path); err != nil {
+			return fmtError(err)
+		}
+	}
+	return writeVCSState(jirix, vcsProjects)
+}
@@ -0,0 +1,225 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jiri
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"go.fuchsia.dev/jiri/osutil"
+)
+
+const (
+	// RootLockFile is the name of the lock file of a root, under
+	// RootMetaDir.
+	RootLockFile = "lock"
+
+	// rootLockEnv is set to the pid of the process holding the root lock,
+	// so that the jiri commands it runs, e.g. from hooks, don't wait for
+	// it.
+	rootLockEnv = "JIRI_ROOT_LOCK_PID"
+
+	rootLockPollInterval = 500 * time.Millisecond
+)
+
+// RootLockInfo describes the jiri process holding the lock of a root.
+type RootLockInfo struct {
+	PID int `json:"pid"`
+	// Start identifies when the process started, see
+	// osutil.ProcessStartTime. It is empty if unknown.
+	Start    string    `json:"start,omitempty"`
+	Hostname string    `json:"hostname"`
+	Command  string    `json:"command"`
+	Time     time.Time `json:"time"`
+}
+
+// RootLockedError is returned when the lock of a root is held by another jiri
+// process.
+type RootLockedError struct {
+	Path   string
+	Holder RootLockInfo
+}
+
+func (e *RootLockedError) Error() string {
+	return fmt.Sprintf("jiri root is locked by %q (pid %d on %s) since %s. Run with -wait to wait for it to finish, or remove %s if it is not running anymore", e.Holder.Command, e.Holder.PID, e.Holder.Hostname, e.Holder.Time.Format(time.RFC3339), e.Path)
+}
+func (e *RootLockedError) Class() string { return "locked" }
+func (e *RootLockedError) ExitCode() int { return ExitCodeLocked }
+
+// RootLockPath returns the path to the lock file of the root.
+func (jirix *X) RootLockPath() string {
+	return filepath.Join(jirix.RootMetaDir(), RootLockFile)
+}
+
+// LockRoot acquires the lock of the root, which commands changing the root
+// hold so that concurrent jiri processes don't interleave their operations.
+// The lock is advisory: commands that only read the root don't take it. If
+// another process holds it, LockRoot fails with a *RootLockedError, or waits
+// for it to be released if wait is set. Locks left behind by processes that
+// are not running anymore are removed. The lock is released by
+// jirix.RunCleanup.
+func (jirix *X) LockRoot(command string, wait bool) error {
+	path := jirix.RootLockPath()
+	self, err := currentRootLockInfo(command)
+	if err != nil {
+		return err
+	}
+	waiting := false
+	for {
+		err := createRootLock(path, self)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("cannot lock jiri root: %w", err)
+		}
+		holder, err := readRootLock(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("cannot read jiri root lock %s: %w", path, err)
+		}
+		if holder.Hostname == self.Hostname {
+			if holder.PID == self.PID && holder.Start == self.Start {
+				// Left by this process, before it re-executed itself
+				// after updating jiri.
+				break
+			}
+			if os.Getenv(rootLockEnv) == strconv.Itoa(holder.PID) {
+				// Held by a parent jiri process, which runs this one.
+				return nil
+			}
+			if rootLockStale(holder) {
+				jirix.Logger.Warningf("Removing stale jiri root lock of %q (pid %d), which is not running anymore.\n\n", holder.Command, holder.PID)
+				if err := removeStaleRootLock(path, holder); err != nil {
+					return fmt.Errorf("cannot remove stale jiri root lock: %w", err)
+				}
+				continue
+			}
+		}
+		lockedErr := &RootLockedError{Path: path, Holder: holder}
+		if !wait {
+			return lockedErr
+		}
+		if !waiting {
+			jirix.Logger.Infof("Waiting for %q (pid %d on %s) to release the jiri root lock", holder.Command, holder.PID, holder.Hostname)
+			waiting = true
+		}
+		time.Sleep(rootLockPollInterval)
+	}
+	os.Setenv(rootLockEnv, strconv.Itoa(self.PID))
+	jirix.AddCleanupFunc(func() {
+		// Only remove the lock if it is still ours.
+		if holder, err := readRootLock(path); err == nil && holder.PID == self.PID && holder.Start == self.Start && holder.Hostname == self.Hostname {
+			if err := os.Remove(path); err != nil {
+				jirix.Logger.Warningf("Cannot remove jiri root lock: %v\n\n", err)
+			}
+		}
+		os.Unsetenv(rootLockEnv)
+	})
+	return nil
+}
+
+func currentRootLockInfo(command string) (RootLockInfo, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return RootLockInfo{}, err
+	}
+	pid := os.Getpid()
+	start, _ := osutil.ProcessStartTime(pid)
+	return RootLockInfo{
+		PID:      pid,
+		Start:    start,
+		Hostname: hostname,
+		Command:  command,
+		Time:     time.Now().UTC(),
+	}, nil
+}
+
+// createRootLock atomically creates the lock file at path holding info. It is
+// written to a temporary file first and then linked into place, so that
+// readers never see a partially written lock. It fails with an error
+// matching fs.ErrExist if the lock is held.
+func createRootLock(path string, info RootLockInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), RootLockFile+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Link(tmp.Name(), path)
+}
+
+// removeStaleRootLock removes the lock at path if it is still held by the
+// stale holder. Removals are serialized by an advisory lock, and the holder
+// is checked again under it, so that of two processes finding the same stale
+// holder, the second does not remove the lock the first created meanwhile.
+func removeStaleRootLock(path string, holder RootLockInfo) error {
+	unlock, err := osutil.LockFile(path + ".stale")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	current, err := readRootLock(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if current.PID != holder.PID || current.Start != holder.Start || current.Hostname != holder.Hostname || !current.Time.Equal(holder.Time) {
+		// Replaced by a new holder in the meantime.
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func readRootLock(path string) (RootLockInfo, error) {
+	var info RootLockInfo
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return info, err
+	}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return info, fmt.Errorf("invalid lock file: %w", err)
+	}
+	return info, nil
+}
+
+// rootLockStale reports whether the process that created a lock on this host
+// is not running anymore, detecting reused pids by their start time.
+func rootLockStale(holder RootLockInfo) bool {
+	if !osutil.ProcessExists(holder.PID) {
+		return true
+	}
+	if holder.Start == "" {
+		return false
+	}
+	start, err := osutil.ProcessStartTime(holder.PID)
+	return err == nil && start != "" && start != holder.Start
+}
@@ -0,0 +1,105 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jiri
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"go.fuchsia.dev/jiri/color"
+	"go.fuchsia.dev/jiri/log"
+)
+
+func newLockTestX(t *testing.T) *X {
+	t.Helper()
+	return &X{
+		Root:   t.TempDir(),
+		Logger: log.NewLogger(log.InfoLevel, color.NewColor(color.ColorNever), false, 0, time.Second*100, io.Discard, io.Discard),
+	}
+}
+
+func TestLockRoot(t *testing.T) {
+	x := newLockTestX(t)
+	// A process that is running, on this host, holds the lock.
+	cmd := exec.Command("sleep", "30")
+	if err := cmd.Start(); err != nil {
+		t.Skipf("cannot start a process: %v", err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+	holder, err := currentRootLockInfo("update")
+	if err != nil {
+		t.Fatal(err)
+	}
+	holder.PID = cmd.Process.Pid
+	holder.Start = ""
+	if err := createRootLock(x.RootLockPath(), holder); err != nil {
+		t.Fatal(err)
+	}
+
+	err = x.LockRoot("run-hooks", false)
+	var lockedErr *RootLockedError
+	if !errors.As(err, &lockedErr) {
+		t.Fatalf("LockRoot returned %v, want a RootLockedError", err)
+	}
+	if lockedErr.Holder.PID != holder.PID || lockedErr.Holder.Command != "update" {
+		t.Errorf("LockRoot reported holder %+v, want %+v", lockedErr.Holder, holder)
+	}
+
+	// Waiting succeeds once the holder releases the lock.
+	go func() {
+		time.Sleep(2 * rootLockPollInterval)
+		os.Remove(x.RootLockPath())
+	}()
+	if err := x.LockRoot("run-hooks", true); err != nil {
+		t.Fatal(err)
+	}
+	info, err := readRootLock(x.RootLockPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.PID != os.Getpid() || info.Command != "run-hooks" {
+		t.Errorf("lock held by %+v, want this process", info)
+	}
+	x.RunCleanup()
+	if _, err := os.Stat(x.RootLockPath()); !os.IsNotExist(err) {
+		t.Errorf("lock not released, stat returned %v", err)
+	}
+}
+
+func TestLockRootStale(t *testing.T) {
+	x := newLockTestX(t)
+	// A process that exited left the lock behind.
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("cannot run a process: %v", err)
+	}
+	holder, err := currentRootLockInfo("update")
+	if err != nil {
+		t.Fatal(err)
+	}
+	holder.PID = cmd.Process.Pid
+	if err := createRootLock(x.RootLockPath(), holder); err != nil {
+		t.Fatal(err)
+	}
+	if err := x.LockRoot("update", false); err != nil {
+		t.Fatalf("stale lock was not removed: %v", err)
+	}
+	// Another process which found the same stale holder does not remove the
+	// lock just created.
+	if err := removeStaleRootLock(x.RootLockPath(), holder); err != nil {
+		t.Fatal(err)
+	}
+	if current, err := readRootLock(x.RootLockPath()); err != nil || current.PID != os.Getpid() {
+		t.Errorf("the lock of this process was removed: %+v, %v", current, err)
+	}
+	x.RunCleanup()
+}
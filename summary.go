@@ -0,0 +1,115 @@
+// Copyright 2024 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jiri
+
+import (
+	"maps"
+	"sort"
+)
+
+// HookSummary is a hook run, or skipped, by an update.
+type HookSummary struct {
+	Name    string `json:"name"`
+	Project string `json:"project"`
+	// Skipped is set if the inputs of the hook did not change since its
+	// last successful run, in which case it did not run.
+	Skipped bool `json:"skipped,omitempty"`
+	// Duration is in seconds, including the retries of the hook.
+	Duration float64 `json:"duration"`
+	// ExitCode is the exit code of the last attempt, or -1 if the hook
+	// could not be started or was killed, e.g. on timeout.
+	ExitCode int    `json:"exit_code"`
+	Error    string `json:"error,omitempty"`
+}
+
+// PackageSummary is a package instance deployed by an update.
+type PackageSummary struct {
+	Name string `json:"name"`
+	// Path is the directory of the package relative to the root.
+	Path       string `json:"path"`
+	InstanceID string `json:"instance_id"`
+	// Size is the total size in bytes of the files of the instance.
+	Size uint64 `json:"size"`
+	// CacheHit is set if the instance was already deployed before the
+	// update, which then had nothing to fetch.
+	CacheHit bool `json:"cache_hit"`
+}
+
+// UpdateSummary is what an update did: the hooks it ran, the packages it
+// deployed and the number of operations of each kind (e.g. "create",
+// "update") it ran on projects.
+type UpdateSummary struct {
+	// Snapshot is the name of the update history snapshot of the update.
+	Snapshot   string           `json:"snapshot,omitempty"`
+	Hooks      []HookSummary    `json:"hooks"`
+	Packages   []PackageSummary `json:"packages"`
+	Operations map[string]int   `json:"operations"`
+}
+
+// RecordHook records a hook run, or skipped, by the update. It is safe to
+// call concurrently.
+func (jirix *X) RecordHook(h HookSummary) {
+	jirix.summaryMu.Lock()
+	defer jirix.summaryMu.Unlock()
+	jirix.summary.Hooks = append(jirix.summary.Hooks, h)
+}
+
+// RecordPackage records a package instance deployed by the update. It is
+// safe to call concurrently.
+func (jirix *X) RecordPackage(p PackageSummary) {
+	jirix.summaryMu.Lock()
+	defer jirix.summaryMu.Unlock()
+	jirix.summary.Packages = append(jirix.summary.Packages, p)
+}
+
+// RecordOperation counts an operation of kind run on a project by the
+// update. It is safe to call concurrently.
+func (jirix *X) RecordOperation(kind string) {
+	jirix.summaryMu.Lock()
+	defer jirix.summaryMu.Unlock()
+	if jirix.summary.Operations == nil {
+		jirix.summary.Operations = make(map[string]int)
+	}
+	jirix.summary.Operations[kind]++
+}
+
+// RecordUpdateSnapshot records the name of the update history snapshot of
+// the update.
+func (jirix *X) RecordUpdateSnapshot(name string) {
+	jirix.summaryMu.Lock()
+	defer jirix.summaryMu.Unlock()
+	jirix.summary.Snapshot = name
+}
+
+// UpdateSummary returns what the update did so far, with the hooks and
+// packages sorted by project and path, or nil if nothing was recorded.
+func (jirix *X) UpdateSummary() *UpdateSummary {
+	jirix.summaryMu.Lock()
+	defer jirix.summaryMu.Unlock()
+	s := jirix.summary
+	if s.Snapshot == "" && len(s.Hooks) == 0 && len(s.Packages) == 0 && len(s.Operations) == 0 {
+		return nil
+	}
+	summary := &UpdateSummary{
+		Snapshot:   s.Snapshot,
+		Hooks:      append([]HookSummary{}, s.Hooks...),
+		Packages:   append([]PackageSummary{}, s.Packages...),
+		Operations: make(map[string]int, len(s.Operations)),
+	}
+	maps.Copy(summary.Operations, s.Operations)
+	sort.Slice(summary.Hooks, func(i, j int) bool {
+		if summary.Hooks[i].Project != summary.Hooks[j].Project {
+			return summary.Hooks[i].Project < summary.Hooks[j].Project
+		}
+		return summary.Hooks[i].Name < summary.Hooks[j].Name
+	})
+	sort.Slice(summary.Packages, func(i, j int) bool {
+		if summary.Packages[i].Path != summary.Packages[j].Path {
+			return summary.Packages[i].Path < summary.Packages[j].Path
+		}
+		return summary.Packages[i].Name < summary.Packages[j].Name
+	})
+	return summary
+}